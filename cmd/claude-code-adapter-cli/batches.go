@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/utils"
+)
+
+const (
+	// defaultBatchConcurrency is the number of batch requests handled at once when
+	// http.batches.max_concurrency is not set.
+	defaultBatchConcurrency = 4
+	// defaultBatchRetention is how long ended batches are kept when http.batches.retention is not set.
+	defaultBatchRetention = 24 * time.Hour
+	// batchExpiration is the time a batch has to end; requests not handled by then expire, as on
+	// the Anthropic API.
+	batchExpiration = 24 * time.Hour
+	// maxBatchRequests is the number of requests a batch may contain on the Anthropic API.
+	maxBatchRequests = 100_000
+)
+
+var batchCustomIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// messageBatches implements the Message Batches API on top of the messages handler: the requests
+// of a batch are sent through it one by one, at most maxConcurrency at once across all batches,
+// whatever the provider of their profile. Batches are kept in memory, so they do not survive a
+// restart, and are dropped once they ended more than retention ago.
+type messageBatches struct {
+	messages  http.HandlerFunc
+	sem       *semaphore.Weighted
+	retention time.Duration
+
+	mu sync.Mutex
+	// batches are ordered from the oldest to the most recent one.
+	batches []*messageBatch
+}
+
+type messageBatch struct {
+	mu      sync.Mutex
+	info    anthropic.MessageBatch
+	endedAt time.Time
+	results []*anthropic.MessageBatchIndividualResponse
+	cancel  context.CancelFunc
+}
+
+func newMessageBatches(messages http.HandlerFunc, maxConcurrency int, retention time.Duration) *messageBatches {
+	if maxConcurrency <= 0 {
+		maxConcurrency = defaultBatchConcurrency
+	}
+	if retention <= 0 {
+		retention = defaultBatchRetention
+	}
+	return &messageBatches{
+		messages:  messages,
+		sem:       semaphore.NewWeighted(int64(maxConcurrency)),
+		retention: retention,
+	}
+}
+
+func (s *messageBatches) onCreate(w http.ResponseWriter, r *http.Request) {
+	removeForwardedHeaders(r.Header)
+	if !utils.IsContentType(r.Header, "application/json") {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invalid Content-Type %q", r.Header.Get("Content-Type")))
+		return
+	}
+	rawBody, err := readRequestBody(w, r)
+	if err != nil {
+		status, message := requestBodyError(err)
+		respondError(w, status, message)
+		return
+	}
+	var req anthropic.CreateMessageBatchRequest
+	if err = json.Unmarshal(rawBody, &req); err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("The request body is not valid JSON: %s", err.Error()))
+		return
+	}
+	if err = validateMessageBatchRequests(req.Requests); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	now := time.Now().UTC()
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(batchExpiration))
+	batch := &messageBatch{
+		info: anthropic.MessageBatch{
+			ID:               "msgbatch_" + newInstanceID() + newInstanceID() + newInstanceID(),
+			Type:             anthropic.MessageBatchType,
+			ProcessingStatus: anthropic.MessageBatchProcessingStatusInProgress,
+			RequestCounts:    anthropic.MessageBatchRequestCounts{Processing: int64(len(req.Requests))},
+			CreatedAt:        now.Format(time.RFC3339Nano),
+			ExpiresAt:        now.Add(batchExpiration).Format(time.RFC3339Nano),
+		},
+		results: make([]*anthropic.MessageBatchIndividualResponse, len(req.Requests)),
+		cancel:  cancel,
+	}
+	// The results URL is only reported once the batch ended.
+	resultsURL := fmt.Sprintf("http://%s/v1/messages/batches/%s/results", r.Host, batch.info.ID)
+	// Requests are sent with the headers of the batch request, e.g. its credentials.
+	header := r.Header.Clone()
+	header.Del("Content-Length")
+	s.mu.Lock()
+	s.prune(now)
+	s.batches = append(s.batches, batch)
+	s.mu.Unlock()
+	slog.Info(fmt.Sprintf("created message batch %s with %d requests", batch.info.ID, len(req.Requests)))
+	go s.process(ctx, batch, req.Requests, header, resultsURL)
+	respondMessageBatch(w, batch.snapshot())
+}
+
+func validateMessageBatchRequests(requests []*anthropic.MessageBatchRequest) error {
+	if len(requests) == 0 {
+		return errors.New("requests: at least one request is required")
+	}
+	if len(requests) > maxBatchRequests {
+		return fmt.Errorf("requests: a batch contains at most %d requests, got %d", maxBatchRequests, len(requests))
+	}
+	seen := make(map[string]struct{}, len(requests))
+	for i, request := range requests {
+		if request == nil {
+			return fmt.Errorf("requests.%d: request must be an object", i)
+		}
+		if !batchCustomIDPattern.MatchString(request.CustomID) {
+			return fmt.Errorf("requests.%d.custom_id: %q must match %s", i, request.CustomID, batchCustomIDPattern.String())
+		}
+		if _, ok := seen[request.CustomID]; ok {
+			return fmt.Errorf("requests.%d.custom_id: %q is not unique within the batch", i, request.CustomID)
+		}
+		seen[request.CustomID] = struct{}{}
+		if !gjson.ParseBytes(request.Params).IsObject() {
+			return fmt.Errorf("requests.%d.params: params must be an object", i)
+		}
+	}
+	return nil
+}
+
+// process sends the requests of batch through the messages handler and ends the batch once every
+// request has a result. Requests not started when the batch is canceled or expires get a canceled
+// or an expired result.
+func (s *messageBatches) process(ctx context.Context, batch *messageBatch, requests []*anthropic.MessageBatchRequest, header http.Header, resultsURL string) {
+	defer batch.cancel()
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		// Acquire may succeed on a done context, which must not start another request.
+		if ctx.Err() != nil || s.sem.Acquire(ctx, 1) != nil {
+			batch.setResult(i, request.CustomID, interruptedBatchResult(ctx))
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.sem.Release(1)
+			batch.setResult(i, request.CustomID, s.send(ctx, request, header))
+		}()
+	}
+	wg.Wait()
+	batch.end(time.Now().UTC(), resultsURL)
+	slog.Info(fmt.Sprintf("message batch %s ended", batch.info.ID))
+}
+
+// send handles a single batch request with the messages handler; streaming is always disabled.
+func (s *messageBatches) send(ctx context.Context, request *anthropic.MessageBatchRequest, header http.Header) *anthropic.MessageBatchResult {
+	if ctx.Err() != nil {
+		return interruptedBatchResult(ctx)
+	}
+	body, err := sjson.DeleteBytes(request.Params, "stream")
+	if err != nil {
+		body = request.Params
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return erroredBatchResult(http.StatusInternalServerError, err.Error())
+	}
+	r.Header = header.Clone()
+	rw := &batchResponseWriter{header: make(http.Header)}
+	s.messages(rw, r)
+	if ctx.Err() != nil {
+		return interruptedBatchResult(ctx)
+	}
+	if rw.status == http.StatusOK {
+		return &anthropic.MessageBatchResult{
+			Type:    anthropic.MessageBatchResultTypeSucceeded,
+			Message: json.RawMessage(bytes.TrimSpace(rw.body.Bytes())),
+		}
+	}
+	var apiErr anthropic.Error
+	if json.Unmarshal(rw.body.Bytes(), &apiErr) != nil || apiErr.Inner == nil {
+		return erroredBatchResult(rw.status, http.StatusText(rw.status))
+	}
+	return &anthropic.MessageBatchResult{Type: anthropic.MessageBatchResultTypeErrored, Error: &apiErr}
+}
+
+func erroredBatchResult(status int, message string) *anthropic.MessageBatchResult {
+	return &anthropic.MessageBatchResult{
+		Type: anthropic.MessageBatchResultTypeErrored,
+		Error: &anthropic.Error{
+			ContentType: anthropic.ErrorContentType,
+			Inner: &anthropic.InnerError{
+				Type:    anthropic.ErrorTypeForStatus(status),
+				Message: message,
+			},
+		},
+	}
+}
+
+func interruptedBatchResult(ctx context.Context) *anthropic.MessageBatchResult {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &anthropic.MessageBatchResult{Type: anthropic.MessageBatchResultTypeExpired}
+	}
+	return &anthropic.MessageBatchResult{Type: anthropic.MessageBatchResultTypeCanceled}
+}
+
+func (b *messageBatch) setResult(i int, customID string, result *anthropic.MessageBatchResult) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.results[i] = &anthropic.MessageBatchIndividualResponse{CustomID: customID, Result: result}
+	counts := &b.info.RequestCounts
+	counts.Processing--
+	switch result.Type {
+	case anthropic.MessageBatchResultTypeSucceeded:
+		counts.Succeeded++
+	case anthropic.MessageBatchResultTypeErrored:
+		counts.Errored++
+	case anthropic.MessageBatchResultTypeCanceled:
+		counts.Canceled++
+	case anthropic.MessageBatchResultTypeExpired:
+		counts.Expired++
+	}
+}
+
+func (b *messageBatch) end(now time.Time, resultsURL string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endedAt = now
+	b.info.ProcessingStatus = anthropic.MessageBatchProcessingStatusEnded
+	b.info.EndedAt = lo.ToPtr(now.Format(time.RFC3339Nano))
+	b.info.ResultsURL = lo.ToPtr(resultsURL)
+}
+
+// snapshot returns a copy of the batch info that is safe to encode while the batch is processed.
+func (b *messageBatch) snapshot() *anthropic.MessageBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	info := b.info
+	return &info
+}
+
+// prune drops the batches that ended more than retention ago; s.mu must be held.
+func (s *messageBatches) prune(now time.Time) {
+	s.batches = slices.DeleteFunc(s.batches, func(batch *messageBatch) bool {
+		batch.mu.Lock()
+		defer batch.mu.Unlock()
+		return !batch.endedAt.IsZero() && now.Sub(batch.endedAt) > s.retention
+	})
+}
+
+func (s *messageBatches) lookup(w http.ResponseWriter, r *http.Request) *messageBatch {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now().UTC())
+	for _, batch := range s.batches {
+		if batch.info.ID == id {
+			return batch
+		}
+	}
+	respondError(w, http.StatusNotFound, fmt.Sprintf("Message batch %q not found", id))
+	return nil
+}
+
+func (s *messageBatches) onRetrieve(w http.ResponseWriter, r *http.Request) {
+	if batch := s.lookup(w, r); batch != nil {
+		respondMessageBatch(w, batch.snapshot())
+	}
+}
+
+// onList lists batches from the most recent one, supporting the limit, before_id and after_id
+// pagination parameters of the Anthropic API.
+func (s *messageBatches) onList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 20
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 1000 {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("limit: %q must be an integer between 1 and 1000", value))
+			return
+		}
+		limit = n
+	}
+	s.mu.Lock()
+	s.prune(time.Now().UTC())
+	batches := slices.Clone(s.batches)
+	s.mu.Unlock()
+	slices.Reverse(batches)
+	start, end := 0, len(batches)
+	if afterID := query.Get("after_id"); afterID != "" {
+		if i := slices.IndexFunc(batches, func(batch *messageBatch) bool { return batch.info.ID == afterID }); i >= 0 {
+			start = i + 1
+		}
+	}
+	if beforeID := query.Get("before_id"); beforeID != "" {
+		if i := slices.IndexFunc(batches, func(batch *messageBatch) bool { return batch.info.ID == beforeID }); i >= 0 {
+			end = i
+			start = max(start, end-limit)
+		}
+	}
+	start = min(start, end)
+	resp := &anthropic.ListMessageBatchesResponse{Data: make([]*anthropic.MessageBatch, 0)}
+	for _, batch := range batches[start:end] {
+		if len(resp.Data) == limit {
+			resp.HasMore = true
+			break
+		}
+		resp.Data = append(resp.Data, batch.snapshot())
+	}
+	if n := len(resp.Data); n > 0 {
+		resp.FirstID = lo.ToPtr(resp.Data[0].ID)
+		resp.LastID = lo.ToPtr(resp.Data[n-1].ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Warn(fmt.Sprintf("error sending message batches response: %s", err.Error()))
+	}
+}
+
+// onResults streams the results of an ended batch as JSON Lines, in the order of its requests.
+func (s *messageBatches) onResults(w http.ResponseWriter, r *http.Request) {
+	batch := s.lookup(w, r)
+	if batch == nil {
+		return
+	}
+	batch.mu.Lock()
+	ended := !batch.endedAt.IsZero()
+	results := batch.results
+	batch.mu.Unlock()
+	if !ended {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Message batch %q has not ended yet", batch.info.ID))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-jsonl")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, result := range results {
+		if err := encoder.Encode(result); err != nil {
+			slog.Warn(fmt.Sprintf("error sending message batch results: %s", err.Error()))
+			return
+		}
+	}
+}
+
+// onCancel cancels the requests of a batch that did not start yet; the ones in flight are
+// interrupted and reported as canceled too.
+func (s *messageBatches) onCancel(w http.ResponseWriter, r *http.Request) {
+	batch := s.lookup(w, r)
+	if batch == nil {
+		return
+	}
+	batch.mu.Lock()
+	if batch.info.ProcessingStatus == anthropic.MessageBatchProcessingStatusInProgress {
+		batch.info.ProcessingStatus = anthropic.MessageBatchProcessingStatusCanceling
+		batch.info.CancelInitiatedAt = lo.ToPtr(time.Now().UTC().Format(time.RFC3339Nano))
+		batch.cancel()
+	}
+	batch.mu.Unlock()
+	respondMessageBatch(w, batch.snapshot())
+}
+
+func (s *messageBatches) onDelete(w http.ResponseWriter, r *http.Request) {
+	batch := s.lookup(w, r)
+	if batch == nil {
+		return
+	}
+	if batch.snapshot().ProcessingStatus != anthropic.MessageBatchProcessingStatusEnded {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Message batch %q must be canceled or ended before it is deleted", batch.info.ID))
+		return
+	}
+	s.mu.Lock()
+	s.batches = slices.DeleteFunc(s.batches, func(b *messageBatch) bool { return b == batch })
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(&anthropic.DeletedMessageBatch{
+		ID:   batch.info.ID,
+		Type: anthropic.DeletedMessageBatchType,
+	}); err != nil {
+		slog.Warn(fmt.Sprintf("error sending message batch response: %s", err.Error()))
+	}
+}
+
+func respondMessageBatch(w http.ResponseWriter, batch *anthropic.MessageBatch) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(batch); err != nil {
+		slog.Warn(fmt.Sprintf("error sending message batch response: %s", err.Error()))
+	}
+}
+
+// batchResponseWriter collects the response of the messages handler to a batch request.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header { return w.header }
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/provider"
+)
+
+func newMessageBatchesTestServer(t *testing.T, backendURL string, maxConcurrency int) *httptest.Server {
+	t.Helper()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backendURL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	batches := newMessageBatches(onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 16), nil, &pmPtr), maxConcurrency, 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/messages/batches", batches.onCreate)
+	mux.HandleFunc("GET /v1/messages/batches", batches.onList)
+	mux.HandleFunc("GET /v1/messages/batches/{id}", batches.onRetrieve)
+	mux.HandleFunc("DELETE /v1/messages/batches/{id}", batches.onDelete)
+	mux.HandleFunc("POST /v1/messages/batches/{id}/cancel", batches.onCancel)
+	mux.HandleFunc("GET /v1/messages/batches/{id}/results", batches.onResults)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doBatchRequest(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+// waitMessageBatchEnded polls the batch until its processing ended.
+func waitMessageBatchEnded(t *testing.T, server *httptest.Server, id string) *anthropic.MessageBatch {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, body := doBatchRequest(t, http.MethodGet, server.URL+"/v1/messages/batches/"+id, "")
+		if status != http.StatusOK {
+			t.Fatalf("retrieve status = %d: %s", status, body)
+		}
+		var batch anthropic.MessageBatch
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			t.Fatal(err)
+		}
+		if batch.ProcessingStatus == anthropic.MessageBatchProcessingStatusEnded {
+			return &batch
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batch did not end: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMessageBatches(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		prompt := gjson.GetBytes(body, "messages.0.content.0.text").String()
+		if prompt == "fail" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"code":400,"message":"bad prompt"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"echo `+prompt+`"},"finish_reason":"stop"}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	server := newMessageBatchesTestServer(t, backend.URL, 2)
+
+	status, body := doBatchRequest(t, http.MethodPost, server.URL+"/v1/messages/batches", `{"requests":[
+		{"custom_id":"first","params":{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"one"}]}},
+		{"custom_id":"second","params":{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"fail"}]}},
+		{"custom_id":"third","params":{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"stream"}]}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("create status = %d: %s", status, body)
+	}
+	id := gjson.Get(body, "id").String()
+	if !strings.HasPrefix(id, "msgbatch_") || gjson.Get(body, "type").String() != anthropic.MessageBatchType {
+		t.Fatalf("unexpected batch: %s", body)
+	}
+
+	batch := waitMessageBatchEnded(t, server, id)
+	if counts := batch.RequestCounts; counts.Succeeded != 2 || counts.Errored != 1 || counts.Processing != 0 {
+		t.Errorf("request counts = %+v, want 2 succeeded and 1 errored", counts)
+	}
+	if batch.EndedAt == nil || batch.ResultsURL == nil || !strings.HasSuffix(*batch.ResultsURL, "/v1/messages/batches/"+id+"/results") {
+		t.Errorf("ended batch without ended_at or results_url: %+v", batch)
+	}
+
+	status, body = doBatchRequest(t, http.MethodGet, server.URL+"/v1/messages/batches/"+id+"/results", "")
+	if status != http.StatusOK {
+		t.Fatalf("results status = %d: %s", status, body)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("results = %q, want 3 lines", body)
+	}
+	// The third request asked for a stream, which batches do not support.
+	for i, want := range []struct{ customID, resultType, text string }{
+		{"first", "succeeded", "echo one"},
+		{"second", "errored", ""},
+		{"third", "succeeded", "echo stream"},
+	} {
+		result := gjson.Parse(lines[i])
+		if result.Get("custom_id").String() != want.customID || result.Get("result.type").String() != want.resultType {
+			t.Errorf("result %d = %s, want %s %s", i, lines[i], want.customID, want.resultType)
+		}
+		if want.text != "" && result.Get("result.message.content.0.text").String() != want.text {
+			t.Errorf("result %d = %s, want text %q", i, lines[i], want.text)
+		}
+	}
+	if errType := gjson.Get(lines[1], "result.error.error.type").String(); errType != anthropic.InvalidRequestError {
+		t.Errorf("errored result = %s, want an invalid_request_error", lines[1])
+	}
+
+	status, body = doBatchRequest(t, http.MethodGet, server.URL+"/v1/messages/batches?limit=1", "")
+	if status != http.StatusOK || gjson.Get(body, "data.0.id").String() != id || gjson.Get(body, "has_more").Bool() {
+		t.Errorf("list = %d: %s", status, body)
+	}
+
+	status, body = doBatchRequest(t, http.MethodDelete, server.URL+"/v1/messages/batches/"+id, "")
+	if status != http.StatusOK || gjson.Get(body, "type").String() != anthropic.DeletedMessageBatchType {
+		t.Errorf("delete = %d: %s", status, body)
+	}
+	if status, body = doBatchRequest(t, http.MethodGet, server.URL+"/v1/messages/batches/"+id, ""); status != http.StatusNotFound {
+		t.Errorf("retrieve after delete = %d: %s", status, body)
+	}
+}
+
+func TestMessageBatches_Cancel(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		arrived <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+	server := newMessageBatchesTestServer(t, backend.URL, 1)
+
+	status, body := doBatchRequest(t, http.MethodPost, server.URL+"/v1/messages/batches", `{"requests":[
+		{"custom_id":"a","params":{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}},
+		{"custom_id":"b","params":{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("create status = %d: %s", status, body)
+	}
+	id := gjson.Get(body, "id").String()
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first request did not reach the upstream")
+	}
+	if status, body = doBatchRequest(t, http.MethodGet, server.URL+"/v1/messages/batches/"+id+"/results", ""); status != http.StatusBadRequest {
+		t.Errorf("results of a batch in progress = %d: %s", status, body)
+	}
+
+	status, body = doBatchRequest(t, http.MethodPost, server.URL+"/v1/messages/batches/"+id+"/cancel", "")
+	if status != http.StatusOK || gjson.Get(body, "processing_status").String() == string(anthropic.MessageBatchProcessingStatusInProgress) {
+		t.Errorf("cancel = %d: %s", status, body)
+	}
+	batch := waitMessageBatchEnded(t, server, id)
+	if counts := batch.RequestCounts; counts.Canceled != 2 || counts.Processing != 0 {
+		t.Errorf("request counts = %+v, want 2 canceled", counts)
+	}
+	if batch.CancelInitiatedAt == nil {
+		t.Error("cancel_initiated_at is not set")
+	}
+}
+
+func TestMessageBatches_InvalidRequests(t *testing.T) {
+	server := newMessageBatchesTestServer(t, "http://127.0.0.1:0", 1)
+	for _, tt := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no requests", `{"requests":[]}`, "at least one request"},
+		{"invalid custom_id", `{"requests":[{"custom_id":"a b","params":{}}]}`, "requests.0.custom_id"},
+		{"duplicate custom_id", `{"requests":[{"custom_id":"a","params":{}},{"custom_id":"a","params":{}}]}`, "not unique"},
+		{"params not an object", `{"requests":[{"custom_id":"a","params":"hi"}]}`, "requests.0.params"},
+		{"invalid JSON", `{"requests":`, "not valid JSON"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doBatchRequest(t, http.MethodPost, server.URL+"/v1/messages/batches", tt.body)
+			if status != http.StatusBadRequest || !strings.Contains(body, tt.want) {
+				t.Errorf("status = %d: %s, want a 400 mentioning %q", status, body, tt.want)
+			}
+		})
+	}
+}
@@ -18,5 +18,8 @@ func newClaudeClaudeAdapterCliCommand() *cobra.Command {
 		SilenceUsage:  true,
 	}
 	cmd.AddCommand(newServeCommand())
+	cmd.AddCommand(newReplayCommand())
+	cmd.AddCommand(newValidateCommand())
+	cmd.AddCommand(newProfileCommand())
 	return cmd
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+// concurrencyLimiters holds the semaphore of each profile with a concurrency limit. It is keyed
+// by profile name and limit, so that in-flight requests keep counting against the same semaphore
+// across config reloads unless the limit itself changes.
+var concurrencyLimiters sync.Map // map[string]*semaphore.Weighted
+
+var errConcurrencyLimit = errors.New("concurrency limit reached")
+
+// acquireConcurrencySlot reserves a slot of the profile's concurrency limit. It waits up to the
+// profile's queue timeout for a slot to be freed, and fails immediately when the timeout is 0.
+// The returned function releases the slot and must be called exactly once.
+func acquireConcurrencySlot(ctx context.Context, prof *profile.Profile) (release func(), err error) {
+	limit := prof.Options.GetMaxConcurrency()
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	sem := getConcurrencyLimiter(prof.Name, limit)
+	if timeout := prof.Options.GetQueueTimeout(); timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if err = sem.Acquire(ctx, 1); err != nil {
+			return nil, errConcurrencyLimit
+		}
+	} else if !sem.TryAcquire(1) {
+		return nil, errConcurrencyLimit
+	}
+	return func() { sem.Release(1) }, nil
+}
+
+func getConcurrencyLimiter(name string, limit int) *semaphore.Weighted {
+	id := name + "\x00" + strconv.Itoa(limit)
+	if sem, ok := concurrencyLimiters.Load(id); ok {
+		return sem.(*semaphore.Weighted)
+	}
+	sem, _ := concurrencyLimiters.LoadOrStore(id, semaphore.NewWeighted(int64(limit)))
+	return sem.(*semaphore.Weighted)
+}
+
+// concurrencyLimitMessage describes a request rejected by acquireConcurrencySlot.
+func concurrencyLimitMessage(prof *profile.Profile) string {
+	message := fmt.Sprintf("Too many concurrent requests for profile %q (max_concurrency: %d)", prof.Name, prof.Options.GetMaxConcurrency())
+	if timeout := prof.Options.GetQueueTimeout(); timeout > 0 {
+		message += fmt.Sprintf(", no request finished within %s", timeout)
+	}
+	return message
+}
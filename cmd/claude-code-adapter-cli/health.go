@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/gemini"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/provider"
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+// defaultHealthcheckTimeout bounds the upstream probe of /healthz.
+const defaultHealthcheckTimeout = 5 * time.Second
+
+type healthResponse struct {
+	Status   string          `json:"status"`
+	Version  string          `json:"version"`
+	Profiles int             `json:"profiles"`
+	Upstream *upstreamHealth `json:"upstream,omitempty"`
+}
+
+type upstreamHealth struct {
+	Status   string `json:"status"`
+	Profile  string `json:"profile"`
+	Provider string `json:"provider"`
+	Error    string `json:"error,omitempty"`
+}
+
+// onLivez reports that the process is serving requests; it never touches the network.
+func onLivez(version string, pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondHealth(w, http.StatusOK, &healthResponse{
+			Status:   HealthStatusOK,
+			Version:  version,
+			Profiles: countProfiles(pmPtr.Load()),
+		})
+	}
+}
+
+// onHealthz reports the health of the adapter. When http.healthcheck.upstream is enabled,
+// the provider of the default (first) profile is probed with its credentials, and a failed
+// probe is reported with 503.
+func onHealthz(version string, client *http.Client, pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pm := pmPtr.Load()
+		resp := &healthResponse{
+			Status:   HealthStatusOK,
+			Version:  version,
+			Profiles: countProfiles(pm),
+		}
+		if !viper.GetBool(delimiter.ViperKey("http", "healthcheck", "upstream")) {
+			respondHealth(w, http.StatusOK, resp)
+			return
+		}
+		resp.Upstream = &upstreamHealth{Status: HealthStatusOK}
+		if pm == nil || len(pm.Profiles()) == 0 {
+			resp.Upstream.Status = HealthStatusUnavailable
+			resp.Upstream.Error = profile.ErrNoProfilesDefined.Error()
+		} else {
+			prof := pm.Profiles()[0]
+			resp.Upstream.Profile = prof.Name
+			resp.Upstream.Provider = prof.Provider
+			timeout := viper.GetDuration(delimiter.ViperKey("http", "healthcheck", "timeout"))
+			if timeout <= 0 {
+				timeout = defaultHealthcheckTimeout
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			if err := probeUpstream(ctx, client, prof); err != nil {
+				slog.Warn(fmt.Sprintf("upstream health check of profile %q failed: %s", prof.Name, err.Error()))
+				resp.Upstream.Status = HealthStatusUnavailable
+				resp.Upstream.Error = err.Error()
+			}
+		}
+		if resp.Upstream.Status != HealthStatusOK {
+			resp.Status = HealthStatusUnavailable
+			respondHealth(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+		respondHealth(w, http.StatusOK, resp)
+	}
+}
+
+// probeUpstream performs a lightweight authenticated request against the provider of prof:
+// listing one model for Anthropic, Gemini and OpenAI and looking up the API key for OpenRouter. Bedrock is
+// only checked for resolvable credentials, as the runtime endpoint has no cheap read-only call.
+func probeUpstream(ctx context.Context, client *http.Client, prof *profile.Profile) error {
+	var (
+		req *http.Request
+		err error
+	)
+	switch prof.Provider {
+	case ProviderAnthropic:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, prof.Anthropic.GetBaseURL()+"/v1/models?limit=1", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(anthropic.HeaderAPIKey, prof.Anthropic.GetAPIKey())
+		req.Header.Set(anthropic.HeaderVersion, prof.Anthropic.GetVersion())
+		provider.WithStaticHeaders(prof.Anthropic.GetHeaders())(req)
+	case ProviderOpenRouter:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, prof.OpenRouter.GetBaseURL()+"/v1/key", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+prof.OpenRouter.GetAPIKey())
+		provider.WithStaticHeaders(prof.OpenRouter.GetHeaders())(req)
+	case ProviderGemini:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, prof.Gemini.GetBaseURL()+"/v1beta/models?pageSize=1", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(gemini.HeaderAPIKey, prof.Gemini.GetAPIKey())
+	case ProviderOpenAI:
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, prof.OpenAI.GetBaseURL()+"/v1/models?limit=1", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+prof.OpenAI.GetAPIKey())
+		provider.WithStaticHeaders(prof.OpenAI.GetHeaders())(req)
+	case ProviderBedrock:
+		_, err = provider.LoadBedrockCredentials(prof.Bedrock)
+		return err
+	default:
+		return fmt.Errorf("health check is not supported for provider %q", prof.Provider)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("upstream responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func countProfiles(pm *profile.ProfileManager) int {
+	if pm == nil {
+		return 0
+	}
+	return len(pm.Profiles())
+}
+
+func respondHealth(w http.ResponseWriter, status int, resp *healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Warn(fmt.Sprintf("error sending health response: %s", err.Error()))
+	}
+}
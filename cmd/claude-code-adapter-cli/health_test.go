@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+func TestOnHealthz(t *testing.T) {
+	var upstreamStatus atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("X-Api-Key") != "sk-test" || r.Header.Get("Anthropic-Version") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(int(upstreamStatus.Load()))
+	}))
+	defer upstream.Close()
+
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:      "default",
+		Provider:  ProviderAnthropic,
+		Models:    []string{"*"},
+		Anthropic: &profile.AnthropicConfig{BaseURL: upstream.URL, APIKey: "sk-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+
+	upstreamKey := delimiter.ViperKey("http", "healthcheck", "upstream")
+	t.Cleanup(func() { viper.Set(upstreamKey, false) })
+	tests := []struct {
+		name           string
+		checkUpstream  bool
+		upstreamStatus int
+		wantStatus     int
+		wantUpstream   string
+	}{
+		{name: "upstream check disabled", checkUpstream: false, upstreamStatus: http.StatusInternalServerError, wantStatus: http.StatusOK},
+		{name: "upstream healthy", checkUpstream: true, upstreamStatus: http.StatusOK, wantStatus: http.StatusOK, wantUpstream: HealthStatusOK},
+		{name: "upstream failing", checkUpstream: true, upstreamStatus: http.StatusUnauthorized, wantStatus: http.StatusServiceUnavailable, wantUpstream: HealthStatusUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set(upstreamKey, tt.checkUpstream)
+			upstreamStatus.Store(int32(tt.upstreamStatus))
+			w := httptest.NewRecorder()
+			onHealthz("v1.2.3", upstream.Client(), &pmPtr)(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			var resp healthResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("invalid response body: %v", err)
+			}
+			if resp.Version != "v1.2.3" || resp.Profiles != 1 {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+			if tt.wantUpstream == "" {
+				if resp.Upstream != nil {
+					t.Errorf("upstream should not be probed: %+v", resp.Upstream)
+				}
+				return
+			}
+			if resp.Upstream == nil || resp.Upstream.Status != tt.wantUpstream || resp.Upstream.Profile != "default" {
+				t.Errorf("unexpected upstream health: %+v", resp.Upstream)
+			}
+		})
+	}
+}
+
+func TestOnLivez(t *testing.T) {
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	w := httptest.NewRecorder()
+	onLivez("v1.2.3", &pmPtr)(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response body: %v", err)
+	}
+	if resp.Status != HealthStatusOK || resp.Version != "v1.2.3" || resp.Profiles != 0 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestProbeUpstream_OpenAI(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer upstream.Close()
+	prof := &profile.Profile{
+		Name:     "openai",
+		Provider: ProviderOpenAI,
+		OpenAI:   &profile.OpenAIConfig{BaseURL: upstream.URL, APIKey: "sk-test"},
+	}
+	if err := probeUpstream(t.Context(), upstream.Client(), prof); err != nil {
+		t.Errorf("probeUpstream() = %v", err)
+	}
+	prof.OpenAI.APIKey = "sk-wrong"
+	if err := probeUpstream(t.Context(), upstream.Client(), prof); err == nil {
+		t.Error("probeUpstream() succeeded with a rejected API key")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+// Values of the log.format key.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// configureLogging sets up the default slog logger from log.format and log.level.
+// The text format keeps slog's standard output; debug mode always lowers the level to debug.
+func configureLogging(v *viper.Viper, out io.Writer) error {
+	level := slog.LevelInfo
+	if name := v.GetString(delimiter.ViperKey("log", "level")); name != "" {
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log.level %q: expected debug, info, warn or error", name)
+		}
+	}
+	if v.GetBool(delimiter.ViperKey("debug")) {
+		level = slog.LevelDebug
+	}
+	switch format := strings.ToLower(v.GetString(delimiter.ViperKey("log", "format"))); format {
+	case "", LogFormatText:
+		slog.SetLogLoggerLevel(level)
+	case LogFormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("invalid log.format %q: expected %q or %q", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(defaultLogger)
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+		v.Set(delimiter.ViperKey("log", "format"), "json")
+		v.Set(delimiter.ViperKey("log", "level"), "warn")
+		var out bytes.Buffer
+		if err := configureLogging(v, &out); err != nil {
+			t.Fatalf("configureLogging failed: %v", err)
+		}
+		slog.Info("dropped")
+		slog.With("request_id", 7, "profile", "default").Warn("kept", "provider", "openrouter")
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("expected one log line, got %q", out.String())
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+			t.Fatalf("log line is not JSON: %v", err)
+		}
+		if record["msg"] != "kept" || record["request_id"] != 7.0 || record["profile"] != "default" || record["provider"] != "openrouter" {
+			t.Errorf("unexpected record: %v", record)
+		}
+	})
+
+	t.Run("debug overrides level", func(t *testing.T) {
+		v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+		v.Set(delimiter.ViperKey("log", "format"), "json")
+		v.Set(delimiter.ViperKey("log", "level"), "error")
+		v.Set(delimiter.ViperKey("debug"), true)
+		var out bytes.Buffer
+		if err := configureLogging(v, &out); err != nil {
+			t.Fatalf("configureLogging failed: %v", err)
+		}
+		slog.Debug("visible")
+		if !strings.Contains(out.String(), `"msg":"visible"`) {
+			t.Errorf("debug record missing: %q", out.String())
+		}
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		for key, value := range map[string]string{delimiter.ViperKey("log", "format"): "xml", delimiter.ViperKey("log", "level"): "loud"} {
+			v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+			v.Set(key, value)
+			if err := configureLogging(v, &bytes.Buffer{}); err == nil {
+				t.Errorf("expected error for %s=%q", key, value)
+			}
+		}
+	})
+}
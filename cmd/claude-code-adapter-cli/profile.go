@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
+)
+
+func newProfileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Inspect the profiles of a config file",
+	}
+	cmd.AddCommand(newProfileMatchCommand())
+	return cmd
+}
+
+func newProfileMatchCommand() *cobra.Command {
+	var configFile string
+	cmd := &cobra.Command{
+		Use:   "match <model>",
+		Short: "Print the profile a model is routed to, with every option resolved to its effective value",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := readConfigFile(configFile)
+			if err != nil {
+				return err
+			}
+			pm, err := profile.LoadFromViper(v)
+			if err != nil {
+				return err
+			}
+			return printProfileMatch(cmd.OutOrStdout(), pm, args[0])
+		},
+	}
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.claude-code-adapter/config.yaml)")
+	return cmd
+}
+
+// printProfileMatch writes the profile pm routes model to as YAML, preceded by comments naming
+// the pattern that matched and the later profiles the match shadows.
+func printProfileMatch(w io.Writer, pm *profile.ProfileManager, model string) error {
+	matched, err := pm.Match(model)
+	if err != nil {
+		return fmt.Errorf("model %q: %w", model, err)
+	}
+	pattern, _ := matched.MatchedPattern(model)
+	fmt.Fprintf(w, "# model %q matches pattern %q of profile %q\n", model, pattern, matched.Name)
+	for _, p := range pm.Profiles() {
+		if p == matched {
+			continue
+		}
+		if pattern, shadowed := p.MatchedPattern(model); shadowed {
+			fmt.Fprintf(w, "# pattern %q of profile %q also matches, but profile %q comes first\n", pattern, p.Name, matched.Name)
+		}
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(effectiveProfile(matched)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// effectiveProfile returns a copy of p with every section present and every option replaced by
+// the value the adapter actually uses, as returned by its Get accessor. Credentials are redacted.
+func effectiveProfile(p *profile.Profile) *profile.Profile {
+	effective := &profile.Profile{
+		Name:       p.Name,
+		Models:     p.Models,
+		Provider:   p.Provider,
+		Options:    resolveSection(p.Options),
+		Anthropic:  resolveSection(p.Anthropic),
+		OpenRouter: resolveSection(p.OpenRouter),
+		Bedrock:    resolveSection(p.Bedrock),
+		Gemini:     resolveSection(p.Gemini),
+		OpenAI:     resolveSection(p.OpenAI),
+	}
+	redact := func(value *string) {
+		if *value != "" {
+			*value = snapshot.RedactedPlaceholder
+		}
+	}
+	redact(&effective.Anthropic.APIKey)
+	redact(&effective.OpenRouter.APIKey)
+	for i := range effective.OpenRouter.APIKeys {
+		redact(&effective.OpenRouter.APIKeys[i])
+	}
+	redact(&effective.Bedrock.SecretAccessKey)
+	redact(&effective.Bedrock.SessionToken)
+	redact(&effective.Gemini.APIKey)
+	redact(&effective.OpenAI.APIKey)
+	// Headers usually carry credentials as well, e.g. Cloudflare Access secrets.
+	for _, headers := range []*map[string]string{&effective.Anthropic.Headers, &effective.OpenRouter.Headers, &effective.OpenAI.Headers} {
+		*headers = maps.Clone(*headers)
+		for name := range *headers {
+			(*headers)[name] = snapshot.RedactedPlaceholder
+		}
+	}
+	return effective
+}
+
+// resolveSection returns a copy of section, a zero value when it is nil, whose fields are set
+// from the accessors of section.
+func resolveSection[T any](section *T) *T {
+	resolved := new(T)
+	if section != nil {
+		*resolved = *section
+	}
+	resolveFields(reflect.ValueOf(section), reflect.ValueOf(resolved).Elem(), "")
+	return resolved
+}
+
+// resolveFields sets each field F of dst to the result of the accessor GetF of receiver. The
+// fields of nested sections, like options.reasoning, are read through accessors of receiver
+// named after both, e.g. GetReasoningFormat. Fields without an accessor are kept as they are.
+func resolveFields(receiver, dst reflect.Value, prefix string) {
+	for i := range dst.NumField() {
+		field, value := dst.Type().Field(i), dst.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct {
+			nested := reflect.New(field.Type.Elem())
+			if !value.IsNil() {
+				nested.Elem().Set(value.Elem())
+			}
+			resolveFields(receiver, nested.Elem(), prefix+field.Name)
+			value.Set(nested)
+			continue
+		}
+		accessor := receiver.MethodByName("Get" + prefix + field.Name)
+		if !accessor.IsValid() || accessor.Type().NumIn() != 0 || accessor.Type().NumOut() != 1 {
+			continue
+		}
+		result := accessor.Call(nil)[0]
+		switch {
+		case result.Type().AssignableTo(field.Type):
+			value.Set(result)
+		case field.Type.Kind() == reflect.Pointer && result.Type().AssignableTo(field.Type.Elem()):
+			// Optional settings, like allow_fallbacks, are reported with their default filled in.
+			pointer := reflect.New(field.Type.Elem())
+			pointer.Elem().Set(result)
+			value.Set(pointer)
+		}
+	}
+}
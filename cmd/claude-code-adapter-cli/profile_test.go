@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+func TestProfileMatchCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+profiles:
+  sonnet:
+    models: ["claude-sonnet-*"]
+    provider: openrouter
+    options:
+      reasoning:
+        effort: high
+    openrouter:
+      api_key: sk-or-secret
+      headers:
+        X-Org-Id: org-123
+    openai:
+      api_key: sk-openai-secret
+  claude:
+    models: ["claude-*"]
+    provider: anthropic
+  default:
+    models: ["*"]
+    provider: openrouter
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	run := func(model string) (string, error) {
+		var out bytes.Buffer
+		cmd := newClaudeClaudeAdapterCliCommand()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"profile", "match", model, "--config", path})
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	out, err := run("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`# model "claude-sonnet-4" matches pattern "claude-sonnet-*" of profile "sonnet"`,
+		`# pattern "claude-*" of profile "claude" also matches, but profile "sonnet" comes first`,
+		`# pattern "*" of profile "default" also matches, but profile "sonnet" comes first`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "sk-or-secret") || strings.Contains(out, "org-123") || strings.Contains(out, "sk-openai-secret") {
+		t.Errorf("credentials are not redacted:\n%s", out)
+	}
+	var printed profile.Profile
+	if err = yaml.Unmarshal([]byte(out), &printed); err != nil {
+		t.Fatalf("output is not a YAML profile: %v\n%s", err, out)
+	}
+	if printed.Name != "sonnet" || printed.Provider != ProviderOpenRouter {
+		t.Errorf("printed profile %q with provider %q", printed.Name, printed.Provider)
+	}
+	// Explicit values are kept and unset ones are reported with their defaults.
+	if effort := printed.Options.Reasoning.Effort; effort != "high" {
+		t.Errorf("reasoning.effort = %q, want high", effort)
+	}
+	if format := printed.Options.Reasoning.Format; format == "" {
+		t.Error("reasoning.format is not defaulted")
+	}
+	if printed.Options.StreamDataBufferSize == 0 || printed.OpenRouter.BaseURL == "" || printed.Anthropic.Version == "" {
+		t.Errorf("options are not defaulted:\n%s", out)
+	}
+	if printed.OpenRouter.AllowFallbacks == nil || !*printed.OpenRouter.AllowFallbacks {
+		t.Error("openrouter.allow_fallbacks is not defaulted to true")
+	}
+
+	if out, err = run("gpt-5"); err != nil || !strings.Contains(out, `profile "default"`) || strings.Contains(out, "also matches") {
+		t.Errorf("unexpected match of gpt-5: %v\n%s", err, out)
+	}
+}
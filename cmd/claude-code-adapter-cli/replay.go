@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/x5iu/claude-code-adapter/pkg/adapter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
+)
+
+// replayMaxLineBytes bounds a single snapshot line; requests with inline images can be large.
+const replayMaxLineBytes = 64 * 1024 * 1024
+
+func newReplayCommand() *cobra.Command {
+	var (
+		profileName string
+		failOnDiff  bool
+	)
+	cmd := &cobra.Command{
+		Use:   "replay <snapshot.jsonl>",
+		Short: "Re-run request conversion on recorded snapshots and report differences",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			result, err := replaySnapshots(file, cmd.OutOrStdout(), profileName)
+			if err != nil {
+				return err
+			}
+			if failOnDiff && result.Mismatched > 0 {
+				return fmt.Errorf("%d of %d replayed snapshots differ", result.Mismatched, result.Replayed)
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&profileName, "profile", "", "only replay snapshots recorded with this profile")
+	flags.BoolVar(&failOnDiff, "fail-on-diff", false, "exit with a non-zero status if any conversion differs")
+	return cmd
+}
+
+type replayResult struct {
+	Replayed   int
+	Mismatched int
+	Skipped    int
+}
+
+// replaySnapshots converts the AnthropicRequest of every OpenRouter snapshot read from r again,
+// using the profile config recorded alongside it, and writes a report of the differences to w.
+func replaySnapshots(r io.Reader, w io.Writer, profileName string) (*replayResult, error) {
+	var result replayResult
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), replayMaxLineBytes)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var sn snapshot.Snapshot
+		if err := json.Unmarshal(line, &sn); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if sn.AnthropicRequest == nil || sn.OpenRouterRequest == nil ||
+			(profileName != "" && sn.Profile != profileName) {
+			result.Skipped++
+			continue
+		}
+		result.Replayed++
+		diffs, err := replaySnapshot(&sn)
+		if err != nil {
+			result.Mismatched++
+			fmt.Fprintf(w, "line %d: request %s (profile %q): conversion failed: %s\n", lineNo, sn.RequestID, sn.Profile, err.Error())
+			continue
+		}
+		if len(diffs) > 0 {
+			result.Mismatched++
+			fmt.Fprintf(w, "line %d: request %s (profile %q): %d difference(s)\n", lineNo, sn.RequestID, sn.Profile, len(diffs))
+			for _, diff := range diffs {
+				fmt.Fprintf(w, "  %s\n", diff)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "replayed %d snapshot(s): %d differ, %d skipped\n", result.Replayed, result.Mismatched, result.Skipped)
+	return &result, nil
+}
+
+func replaySnapshot(sn *snapshot.Snapshot) (diffs []string, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	ctx := profile.WithProfile(context.Background(), snapshotConfigToProfile(sn.Profile, sn.Config))
+	replayed, err := adapter.ConvertAnthropicRequestToOpenRouterRequest(ctx, sn.AnthropicRequest)
+	if err != nil {
+		return nil, err
+	}
+	var want, got any
+	if err = remarshal(sn.OpenRouterRequest, &want); err != nil {
+		return nil, err
+	}
+	if err = remarshal(replayed, &got); err != nil {
+		return nil, err
+	}
+	diffJSON("$", want, got, &diffs)
+	return diffs, nil
+}
+
+// remarshal round-trips v through JSON so both sides of a comparison use the same generic shape.
+func remarshal(v any, dst *any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// diffJSON appends one line per differing path between the decoded JSON values want and got.
+func diffJSON(path string, want, got any, diffs *[]string) {
+	switch w := want.(type) {
+	case map[string]any:
+		if g, ok := got.(map[string]any); ok {
+			keys := make([]string, 0, len(w)+len(g))
+			for k := range w {
+				keys = append(keys, k)
+			}
+			for k := range g {
+				if _, exists := w[k]; !exists {
+					keys = append(keys, k)
+				}
+			}
+			slices.Sort(keys)
+			for _, k := range keys {
+				diffJSON(path+"."+k, w[k], g[k], diffs)
+			}
+			return
+		}
+	case []any:
+		if g, ok := got.([]any); ok {
+			for i := range max(len(w), len(g)) {
+				var wi, gi any
+				if i < len(w) {
+					wi = w[i]
+				}
+				if i < len(g) {
+					gi = g[i]
+				}
+				diffJSON(path+"["+strconv.Itoa(i)+"]", wi, gi, diffs)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(want, got) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: recorded %s, replayed %s", path, formatJSONValue(want), formatJSONValue(got)))
+	}
+}
+
+func formatJSONValue(v any) string {
+	if v == nil {
+		return "<absent>"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// snapshotConfigToProfile rebuilds the conversion-relevant part of a profile from the config
+// recorded in a snapshot; it is the inverse of profileToSnapshotConfig.
+func snapshotConfigToProfile(name string, cfg *snapshot.Config) *profile.Profile {
+	p := &profile.Profile{Name: name}
+	if cfg == nil {
+		return p
+	}
+	p.Provider = cfg.Provider
+	if cfg.Options != nil {
+		p.Options = &profile.OptionsConfig{
+			Strict:                     cfg.Options.Strict,
+			PreventEmptyTextToolResult: cfg.Options.PreventEmptyTextToolResult,
+			Models:                     cfg.Options.Models,
+			ContextWindowResizeFactor:  cfg.Options.ContextWindowResizeFactor,
+			DisableCountTokensRequest:  cfg.Options.DisableCountTokensRequest,
+			LocalCountTokens:           cfg.Options.LocalCountTokens,
+			MinMaxTokens:               cfg.Options.MinMaxTokens,
+			DefaultMaxTokens:           cfg.Options.DefaultMaxTokens,
+			MaxTokensCap:               cfg.Options.MaxTokensCap,
+			DisallowedTools:            cfg.Options.DisallowedTools,
+			MaxTools:                   cfg.Options.MaxTools,
+			SanitizeToolNames:          cfg.Options.SanitizeToolNames,
+			EnforceSerialToolCalls:     cfg.Options.EnforceSerialToolCalls,
+			InterimUsageInterval:       cfg.Options.InterimUsageInterval,
+			AutoCacheBreakpoints:       cfg.Options.AutoCacheBreakpoints,
+			DisableThinking:            cfg.Options.DisableThinking,
+			MaxStopSequences:           cfg.Options.MaxStopSequences,
+			LogitBias:                  cfg.Options.LogitBias,
+			MaxContentPartBytes:        cfg.Options.MaxContentPartBytes,
+			StrictSchemaSanitize:       cfg.Options.StrictSchemaSanitize,
+			SystemPrefix:               cfg.Options.SystemPrefix,
+			SystemSuffix:               cfg.Options.SystemSuffix,
+			RedactedThinkingMode:       cfg.Options.RedactedThinkingMode,
+			ImageDetail:                cfg.Options.ImageDetail,
+		}
+		if cfg.Options.Reasoning != nil {
+			p.Options.Reasoning = &profile.ReasoningConfig{
+				Format:              cfg.Options.Reasoning.Format,
+				Effort:              cfg.Options.Reasoning.Effort,
+				Delimiter:           cfg.Options.Reasoning.Delimiter,
+				Exclude:             cfg.Options.Reasoning.Exclude,
+				PreferSummary:       cfg.Options.Reasoning.PreferSummary,
+				EffortSuffixFormats: cfg.Options.Reasoning.EffortSuffixFormats,
+			}
+		}
+	}
+	if cfg.Anthropic != nil {
+		p.Anthropic = &profile.AnthropicConfig{
+			UseRawRequestBody:              cfg.Anthropic.UseRawRequestBody,
+			EnablePassThroughMode:          cfg.Anthropic.EnablePassThroughMode,
+			DisableWebSearchBlockedDomains: cfg.Anthropic.DisableWebSearchBlockedDomains,
+			ForceThinking:                  cfg.Anthropic.ForceThinking,
+			BaseURL:                        cfg.Anthropic.BaseURL,
+			Version:                        cfg.Anthropic.Version,
+		}
+	}
+	if cfg.OpenRouter != nil {
+		p.OpenRouter = &profile.OpenRouterConfig{
+			BaseURL:               cfg.OpenRouter.BaseURL,
+			ModelReasoningFormat:  cfg.OpenRouter.ModelReasoningFormat,
+			PreferredProviders:    cfg.OpenRouter.PreferredProviders,
+			Transforms:            cfg.OpenRouter.Transforms,
+			ClampCacheTTL:         cfg.OpenRouter.ClampCacheTTL,
+			MergeConsecutiveRoles: cfg.OpenRouter.MergeConsecutiveRoles,
+			ForcePartsContent:     cfg.OpenRouter.ForcePartsContent,
+			ServiceTier:           cfg.OpenRouter.ServiceTier,
+			FallbackModels:        cfg.OpenRouter.FallbackModels,
+			WebSearch:             cfg.OpenRouter.WebSearch,
+		}
+	}
+	return p
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/adapter"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
+)
+
+func TestReplaySnapshots(t *testing.T) {
+	prof := &profile.Profile{
+		Name:     "openrouter",
+		Provider: ProviderOpenRouter,
+		Options: &profile.OptionsConfig{
+			Models:       map[string]string{"claude-sonnet-4": "anthropic/claude-sonnet-4"},
+			SystemPrefix: "prefix",
+			Reasoning:    &profile.ReasoningConfig{Format: "anthropic-claude-v1", Delimiter: "/"},
+		},
+		OpenRouter: &profile.OpenRouterConfig{Transforms: []string{"middle-out"}},
+	}
+	newSnapshot := func(requestID string, profileName string) *snapshot.Snapshot {
+		req := &anthropic.GenerateMessageRequest{
+			Model:     "claude-sonnet-4",
+			MaxTokens: 1024,
+			System:    anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "You are helpful."}},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}},
+			},
+		}
+		openrouterRequest, err := adapter.ConvertAnthropicRequestToOpenRouterRequest(profile.WithProfile(context.Background(), prof), req)
+		if err != nil {
+			t.Fatalf("ConvertAnthropicRequestToOpenRouterRequest failed: %v", err)
+		}
+		return &snapshot.Snapshot{
+			RequestID:         requestID,
+			Provider:          ProviderOpenRouter,
+			Profile:           profileName,
+			Config:            profileToSnapshotConfig(prof),
+			AnthropicRequest:  req,
+			OpenRouterRequest: openrouterRequest,
+			RequestHeader:     snapshot.Header{"Anthropic-Version": {"2023-06-01"}},
+		}
+	}
+	unchanged := newSnapshot("1", "openrouter")
+	changed := newSnapshot("2", "openrouter")
+	changed.OpenRouterRequest.Model = "anthropic/claude-opus-4"
+	other := newSnapshot("3", "other")
+	other.OpenRouterRequest.Model = "anthropic/claude-opus-4"
+	anthropicOnly := &snapshot.Snapshot{RequestID: "4", Provider: "anthropic", AnthropicRequest: unchanged.AnthropicRequest}
+
+	var input bytes.Buffer
+	for _, sn := range []*snapshot.Snapshot{unchanged, changed, other, anthropicOnly} {
+		line, err := json.Marshal(sn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input.Write(line)
+		input.WriteByte('\n')
+	}
+
+	t.Run("all profiles", func(t *testing.T) {
+		var out bytes.Buffer
+		result, err := replaySnapshots(bytes.NewReader(input.Bytes()), &out, "")
+		if err != nil {
+			t.Fatalf("replaySnapshots failed: %v", err)
+		}
+		if result.Replayed != 3 || result.Mismatched != 2 || result.Skipped != 1 {
+			t.Fatalf("unexpected result: %+v\n%s", result, out.String())
+		}
+		if !strings.Contains(out.String(), `$.model: recorded "anthropic/claude-opus-4", replayed "anthropic/claude-sonnet-4"`) {
+			t.Errorf("missing model diff in report:\n%s", out.String())
+		}
+		if strings.Contains(out.String(), "request 1 ") {
+			t.Errorf("unchanged snapshot reported as different:\n%s", out.String())
+		}
+	})
+
+	t.Run("profile filter", func(t *testing.T) {
+		var out bytes.Buffer
+		result, err := replaySnapshots(bytes.NewReader(input.Bytes()), &out, "other")
+		if err != nil {
+			t.Fatalf("replaySnapshots failed: %v", err)
+		}
+		if result.Replayed != 1 || result.Mismatched != 1 || result.Skipped != 3 {
+			t.Fatalf("unexpected result: %+v\n%s", result, out.String())
+		}
+	})
+
+	t.Run("invalid line", func(t *testing.T) {
+		if _, err := replaySnapshots(strings.NewReader("{not json}\n"), &bytes.Buffer{}, ""); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Fatalf("expected line error, got %v", err)
+		}
+	})
+}
+
+func TestDiffJSON(t *testing.T) {
+	var diffs []string
+	diffJSON("$",
+		map[string]any{"a": 1.0, "list": []any{"x", "y"}, "gone": true},
+		map[string]any{"a": 2.0, "list": []any{"x"}, "new": "v"},
+		&diffs,
+	)
+	want := []string{
+		"$.a: recorded 1, replayed 2",
+		"$.gone: recorded true, replayed <absent>",
+		`$.list[1]: recorded "y", replayed <absent>`,
+		`$.new: recorded <absent>, replayed "v"`,
+	}
+	if strings.Join(diffs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diffs =\n%s\nwant\n%s", strings.Join(diffs, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -3,16 +3,21 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,7 +34,10 @@ import (
 
 	"github.com/x5iu/claude-code-adapter/pkg/adapter"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/bedrock"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/gemini"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/metrics"
 	"github.com/x5iu/claude-code-adapter/pkg/profile"
 	"github.com/x5iu/claude-code-adapter/pkg/provider"
 	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
@@ -41,6 +49,9 @@ import (
 const (
 	ProviderAnthropic  = "anthropic"
 	ProviderOpenRouter = "openrouter"
+	ProviderOpenAI     = "openai"
+	ProviderBedrock    = "bedrock"
+	ProviderGemini     = "gemini"
 )
 
 func newServeCommand() *cobra.Command {
@@ -64,9 +75,10 @@ func newServeCommand() *cobra.Command {
 				}
 				slog.Info("using default config")
 			}
+			cobra.CheckErr(configureLogging(viper.GetViper(), os.Stderr))
+			viper.SetOptions(viper.WithLogger(slog.Default()))
 			if viper.GetBool(delimiter.ViperKey("debug")) {
 				slog.Info("using debug mode")
-				slog.SetLogLoggerLevel(slog.LevelDebug)
 				var debugBuf strings.Builder
 				viper.DebugTo(&debugBuf)
 				slog.Debug(">>>>>>>>>>>>>>>>> viper >>>>>>>>>>>>>>>>>" + "\n" + debugBuf.String())
@@ -78,52 +90,109 @@ func newServeCommand() *cobra.Command {
 	flags := cmd.Flags()
 	flags.StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.claude-code-adapter/config.yaml)")
 	flags.Bool("debug", false, "enable debug logging")
+	flags.String("log-format", LogFormatText, "log format, \"text\" or \"json\"")
+	flags.String("log-level", "info", "log level: debug, info, warn or error")
 	flags.Uint16P("port", "p", 2194, "port to serve on")
 	flags.String("host", "127.0.0.1", "host to serve on")
 	flags.String("snapshot", "", "snapshot recorder config")
+	flags.Bool("metrics", false, "expose Prometheus metrics on /metrics")
+	flags.Bool("websocket", false, "serve the messages endpoint over WebSocket on /v1/messages/ws")
+	flags.Int64("max-body-bytes", defaultMaxBodyBytes, "maximum size of a request body in bytes")
+	flags.String("profile", "", "serve every request with this profile instead of matching the model")
 	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("debug"), flags.Lookup("debug")))
+	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("log", "format"), flags.Lookup("log-format")))
+	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("log", "level"), flags.Lookup("log-level")))
 	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("http", "port"), flags.Lookup("port")))
 	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("http", "host"), flags.Lookup("host")))
 	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("snapshot"), flags.Lookup("snapshot")))
+	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("http", "metrics"), flags.Lookup("metrics")))
+	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("http", "websocket"), flags.Lookup("websocket")))
+	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("http", "max_body_bytes"), flags.Lookup("max-body-bytes")))
+	cobra.CheckErr(viper.BindPFlag(delimiter.ViperKey("profile"), flags.Lookup("profile")))
 	return cmd
 }
 
+// loadProfiles loads the profiles of v and stores them in pmPtr. Problems found by the validate
+// command are only logged for the first load, so that the server keeps starting with configs it
+// used to accept; a reload with problems is rejected and pmPtr keeps the running profiles.
+func loadProfiles(v *viper.Viper, pmPtr *atomic.Pointer[profile.ProfileManager]) error {
+	pm, err := profile.LoadFromViper(v)
+	if err != nil {
+		return err
+	}
+	if err := validateProfileManager(pm); err != nil {
+		if pmPtr.Load() != nil {
+			return err
+		}
+		slog.Warn(fmt.Sprintf("config has problems, requests to the affected profiles may fail: %s", err.Error()))
+	}
+	pmPtr.Store(pm)
+	slog.Info(fmt.Sprintf("loaded %d profiles", len(pm.Profiles())))
+	for _, p := range pm.Profiles() {
+		slog.Debug(fmt.Sprintf("profile %q: provider=%s, models=%v", p.Name, p.Provider, p.Models))
+	}
+	return nil
+}
+
 func serve(cmd *cobra.Command, _ []string) {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 	// Load profiles from configuration
 	var profileManagerPtr atomic.Pointer[profile.ProfileManager]
-	loadProfiles := func() error {
-		pm, err := profile.LoadFromViper(viper.GetViper())
-		if err != nil {
-			return err
-		}
-		profileManagerPtr.Store(pm)
-		slog.Info(fmt.Sprintf("loaded %d profiles", len(pm.Profiles())))
-		for _, p := range pm.Profiles() {
-			slog.Debug(fmt.Sprintf("profile %q: provider=%s, models=%v", p.Name, p.Provider, p.Models))
-		}
-		return nil
-	}
-	if err := loadProfiles(); err != nil {
+	if err := loadProfiles(viper.GetViper(), &profileManagerPtr); err != nil {
 		cobra.CheckErr(fmt.Errorf("profile: %w", err))
 	}
 	viper.OnConfigChange(func(fsnotify.Event) {
 		slog.Info("config file changed, reloading")
-		if err := loadProfiles(); err != nil {
-			slog.Error(fmt.Sprintf("error reloading profiles: %s", err.Error()))
+		if err := loadProfiles(viper.GetViper(), &profileManagerPtr); err != nil {
+			slog.Error(fmt.Sprintf("error reloading profiles, keeping the previous %d profiles: %s", len(profileManagerPtr.Load().Profiles()), err.Error()))
 		}
 	})
 	viper.WatchConfig()
-	recorder, err := makeSnapshotRecorder(ctx, viper.GetString(delimiter.ViperKey("snapshot")))
+	transport, err := newUpstreamTransport(viper.GetViper())
+	if err != nil {
+		cobra.CheckErr(fmt.Errorf("http: %w", err))
+	}
+	// The generated provider sends requests with http.DefaultClient.
+	http.DefaultClient.Transport = transport
+	recorder, err := makeSnapshotRecorder(ctx, viper.GetString(delimiter.ViperKey("snapshot")), &snapshot.RedactConfig{
+		Paths:    viper.GetStringSlice(delimiter.ViperKey("snapshot_redact", "paths")),
+		Patterns: viper.GetStringSlice(delimiter.ViperKey("snapshot_redact", "patterns")),
+		Headers:  viper.GetStringSlice(delimiter.ViperKey("snapshot_redact", "headers")),
+	})
 	if err != nil {
 		cobra.CheckErr(fmt.Errorf("snapshot: %w", err))
 	}
 	defer recorder.Close()
+	var metricsRegistry *metrics.Registry
+	if viper.GetBool(delimiter.ViperKey("http", "metrics")) {
+		metricsRegistry = metrics.NewRegistry()
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
-	mux.HandleFunc("/v1/messages", onMessages(cmd, provider.NewProvider(), recorder, &profileManagerPtr))
+	mux.HandleFunc("GET /livez", onLivez(cmd.Parent().Version, &profileManagerPtr))
+	mux.HandleFunc("GET /healthz", onHealthz(cmd.Parent().Version, http.DefaultClient, &profileManagerPtr))
+	messagesHandler := onMessages(cmd, provider.NewProvider(), recorder, metricsRegistry, &profileManagerPtr)
+	mux.HandleFunc("/v1/messages", messagesHandler)
+	if viper.GetBool(delimiter.ViperKey("http", "websocket")) {
+		mux.HandleFunc("GET /v1/messages/ws", onMessagesWebSocket(messagesHandler))
+	}
+	batches := newMessageBatches(messagesHandler,
+		viper.GetInt(delimiter.ViperKey("http", "batches", "max_concurrency")),
+		viper.GetDuration(delimiter.ViperKey("http", "batches", "retention")),
+	)
+	mux.HandleFunc("POST /v1/messages/batches", batches.onCreate)
+	mux.HandleFunc("GET /v1/messages/batches", batches.onList)
+	mux.HandleFunc("GET /v1/messages/batches/{id}", batches.onRetrieve)
+	mux.HandleFunc("DELETE /v1/messages/batches/{id}", batches.onDelete)
+	mux.HandleFunc("POST /v1/messages/batches/{id}/cancel", batches.onCancel)
+	mux.HandleFunc("GET /v1/messages/batches/{id}/results", batches.onResults)
 	mux.HandleFunc("/v1/messages/count_tokens", onCountTokens(&profileManagerPtr))
+	mux.HandleFunc("/v1/models", onModels(&profileManagerPtr))
+	mux.HandleFunc("/v1/debug/translate", onDebugTranslate(&profileManagerPtr))
+	if metricsRegistry != nil {
+		mux.Handle("/metrics", metricsRegistry)
+	}
 	server := &http.Server{
 		Addr:     fmt.Sprintf("%s:%d", viper.GetString(delimiter.ViperKey("http", "host")), viper.GetUint16(delimiter.ViperKey("http", "port"))),
 		Handler:  mux,
@@ -143,12 +212,15 @@ func serve(cmd *cobra.Command, _ []string) {
 	}
 }
 
-func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorder, pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
+func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorder, mr *metrics.Registry, pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
 	var (
-		requestCounter atomic.Int64
-		version        = cmd.Parent().Version
+		requestCounter   atomic.Int64
+		version          = cmd.Parent().Version
+		countTokensCache countTokensCaches
+		responseCache    responseCaches
+		instanceID       = newInstanceID()
 	)
-	return func(w http.ResponseWriter, r *http.Request) {
+	handle := func(w http.ResponseWriter, r *http.Request, attempt *messagesAttempt) {
 		var matchedProfileConfig *snapshot.Config
 		sn := &snapshot.Snapshot{
 			RequestTime: time.Now(),
@@ -156,6 +228,9 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 		}
 		requestID := requestCounter.Add(1)
 		sn.RequestID = strconv.FormatInt(requestID, 10)
+		sn.UpstreamRequestID = upstreamRequestID(r.Header, instanceID, requestID)
+		// Request-scoped fields are attached as attributes as soon as they are known.
+		logger := slog.With("request_id", requestID, "upstream_request_id", sn.UpstreamRequestID)
 		defer func() {
 			go func() {
 				sn.FinishTime = time.Now()
@@ -163,19 +238,36 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 				// record matched profile config instead of global config
 				sn.Config = matchedProfileConfig
 				if err := rec.Record(sn); err != nil {
-					slog.Warn(fmt.Sprintf("[%d] error recording snapshot: %s", requestID, err.Error()))
+					logger.Warn(fmt.Sprintf("error recording snapshot: %s", err.Error()))
 				}
 			}()
 		}()
+		defer func() {
+			// A response message is only assembled when the whole stream was consumed without error.
+			mr.ObserveRequest(metrics.Labels{Profile: sn.Profile, Provider: sn.Provider},
+				sn.StatusCode != http.StatusOK || sn.AnthropicResponse == nil)
+		}()
 		removeForwardedHeaders(r.Header)
 		r.Header.Del(anthropic.HeaderAPIKey)
+		profileOverride := r.Header.Get(HeaderProfileOverride)
+		r.Header.Del(HeaderProfileOverride)
+		if profileOverride == "" {
+			profileOverride = viper.GetString(delimiter.ViperKey("profile"))
+		}
 		r.Header.Set("User-Agent", fmt.Sprintf("claude-code-adapter-cli/%s", version[1:]))
+		// Forwarded as is to Anthropic, and set explicitly on requests to the other providers.
+		r.Header.Set(provider.HeaderRequestID, sn.UpstreamRequestID)
 		w.Header().Set("X-Cc-Request-Id", strconv.FormatInt(requestID, 10))
+		// The client's trace context is forwarded as is to Anthropic, along with its other headers.
+		var traceHeader http.Header
+		if viper.GetBool(delimiter.ViperKey("otel", "enabled")) {
+			traceHeader = r.Header
+		}
 		defer func() {
 			if err := recover(); err != nil {
-				slog.Error(fmt.Sprintf("[%d] panic recovered: %v", requestID, err))
-				slog.Debug(fmt.Sprintf(">>>>>>>>>>>>>>>>> [%d] stack >>>>>>>>>>>>>>>>>", requestID) + "\n" + string(utils.Stack()))
-				slog.Debug(fmt.Sprintf("<<<<<<<<<<<<<<<<< [%d] stack <<<<<<<<<<<<<<<<<", requestID))
+				logger.Error(fmt.Sprintf("panic recovered: %v", err))
+				logger.Debug(">>>>>>>>>>>>>>>>> stack >>>>>>>>>>>>>>>>>" + "\n" + string(utils.Stack()))
+				logger.Debug("<<<<<<<<<<<<<<<<< stack <<<<<<<<<<<<<<<<<")
 				respondError(w,
 					http.StatusInternalServerError,
 					fmt.Sprintf("An error occured while processing your request: %v", err),
@@ -191,19 +283,18 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 			sn.StatusCode = http.StatusBadRequest
 			return
 		}
-		rawBody, err := io.ReadAll(r.Body)
+		rawBody, err := readRequestBody(w, r)
 		if err != nil {
-			respondError(w,
-				http.StatusInternalServerError,
-				fmt.Sprintf("Failed to read request body: %s", err.Error()),
-			)
+			status, message := requestBodyError(err)
+			respondError(w, status, message)
 			sn.Error = &snapshot.Error{Message: err.Error()}
-			sn.StatusCode = http.StatusInternalServerError
+			sn.StatusCode = status
 			return
 		}
+		attempt.body = rawBody
 		rawBody, _ = json.MarshalIndent(json.RawMessage(rawBody), "", "    ")
-		slog.Debug(fmt.Sprintf(">>>>>>>>>>>>>>>>> [%d] anthropic request >>>>>>>>>>>>>>>>>", requestID) + "\n" + string(rawBody))
-		slog.Debug(fmt.Sprintf("<<<<<<<<<<<<<<<<< [%d] anthropic request <<<<<<<<<<<<<<<<<", requestID))
+		logger.Debug(">>>>>>>>>>>>>>>>> anthropic request >>>>>>>>>>>>>>>>>" + "\n" + string(rawBody))
+		logger.Debug("<<<<<<<<<<<<<<<<< anthropic request <<<<<<<<<<<<<<<<<")
 		var req *anthropic.GenerateMessageRequest
 		if err = json.Unmarshal(rawBody, &req); err != nil {
 			respondError(w,
@@ -219,86 +310,88 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 		r.Header.Del("Content-Length")
 		r.Header.Del("Transfer-Encoding")
 		r.Header.Del("Accept-Encoding")
-		slog.Info(fmt.Sprintf("[%d] request model: %s", requestID, req.Model))
-		// Match profile for the requested model
-		prof, err := pmPtr.Load().Match(req.Model)
-		if err != nil {
-			slog.Error(fmt.Sprintf("[%d] no profile matched for model %q: %s", requestID, req.Model, err.Error()))
-			respondError(w, http.StatusBadRequest, fmt.Sprintf("No profile configured for model %q", req.Model))
-			sn.Error = &snapshot.Error{Message: err.Error()}
-			sn.StatusCode = http.StatusBadRequest
-			return
+		logger = logger.With("model", req.Model)
+		logger.Info("received request")
+		// Match profile for the requested model, unless a previous attempt fell back to another one
+		prof := attempt.profile
+		if prof == nil && profileOverride != "" {
+			if prof, err = overrideProfile(pmPtr.Load(), profileOverride, req.Model); err != nil {
+				logger.Error(fmt.Sprintf("profile override %q rejected: %s", profileOverride, err.Error()))
+				status, message := profileMatchError(req.Model, err)
+				respondError(w, status, message)
+				sn.Error = &snapshot.Error{Message: err.Error()}
+				sn.StatusCode = status
+				return
+			}
+			logger.Info(fmt.Sprintf("profile override: %s, model matching skipped", prof.Name))
+		}
+		if prof == nil {
+			if prof, err = pmPtr.Load().Match(req.Model); err != nil {
+				logger.Error(fmt.Sprintf("no profile matched for model %q: %s", req.Model, err.Error()))
+				status, message := profileMatchError(req.Model, err)
+				respondError(w, status, message)
+				sn.Error = &snapshot.Error{Message: err.Error()}
+				sn.StatusCode = status
+				return
+			}
+		}
+		logger = logger.With("profile", prof.Name)
+		if len(attempt.tried) > 0 {
+			logger.Info(fmt.Sprintf("fallback profile: %s (provider=%s), after %s failed", prof.Name, prof.Provider, strings.Join(attempt.tried, ", ")))
+		} else {
+			logger.Info(fmt.Sprintf("matched profile: %s (provider=%s)", prof.Name, prof.Provider))
 		}
-		slog.Info(fmt.Sprintf("[%d] matched profile: %s (provider=%s)", requestID, prof.Name, prof.Provider))
 		sn.Profile = prof.Name
 		matchedProfileConfig = profileToSnapshotConfig(prof)
 		// Inject profile into request context
 		ctx := profile.WithProfile(r.Context(), prof)
+		if err = adapter.ResolveAnthropicVersion(r.Header, prof.Anthropic.GetVersion()); err != nil {
+			logger.Error(fmt.Sprintf("invalid request: %s", err.Error()))
+			respondError(w, http.StatusBadRequest, err.Error())
+			sn.Error = &snapshot.Error{Message: err.Error()}
+			sn.StatusCode = http.StatusBadRequest
+			return
+		}
+		if err = adapter.ValidateContentPartSizes(req, prof.Options.GetMaxContentPartBytes()); err != nil {
+			logger.Error(fmt.Sprintf("invalid request: %s", err.Error()))
+			respondError(w, http.StatusBadRequest, err.Error())
+			sn.Error = &snapshot.Error{Message: err.Error()}
+			sn.StatusCode = http.StatusBadRequest
+			return
+		}
+		context1M := anthropic.HasBetaFeature(r.Header, anthropic.BetaFeatureContext1M20250807)
+		if context1M && !prof.Options.SupportsContext1M(req.Model) {
+			message := fmt.Sprintf("Model %q does not support the 1M context window (anthropic-beta: %s)", req.Model, anthropic.BetaFeatureContext1M20250807)
+			logger.Error(fmt.Sprintf("invalid request: %s", message))
+			respondError(w, http.StatusBadRequest, message)
+			sn.Error = &snapshot.Error{Message: message}
+			sn.StatusCode = http.StatusBadRequest
+			return
+		}
 		// Remove disallowed tools as early as possible (ingress filtering)
-		if len(req.Tools) > 0 {
-			// Build a disallowed tool name set from profile options
-			disallowedSet := map[string]struct{}{}
-			for _, name := range prof.Options.GetDisallowedTools() {
-				if name == "" {
-					continue
-				}
-				disallowedSet[name] = struct{}{}
-			}
-			if len(disallowedSet) > 0 {
-				filtered := make([]*anthropic.Tool, 0, len(req.Tools))
-				removed := make([]string, 0)
-				for _, t := range req.Tools {
-					if _, blocked := disallowedSet[t.Name]; blocked {
-						removed = append(removed, t.Name)
-						continue
-					}
-					filtered = append(filtered, t)
-				}
-				if len(removed) > 0 {
-					slog.Info(fmt.Sprintf("[%d] removed disallowed tools: %s", requestID, strings.Join(removed, ",")))
-				}
-				req.Tools = filtered
-				// Normalize tool_choice if necessary
-				if len(req.Tools) == 0 {
-					if req.ToolChoice == nil {
-						req.ToolChoice = &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeNone}
-					} else {
-						req.ToolChoice.Type = anthropic.ToolChoiceTypeNone
-						req.ToolChoice.Name = ""
-					}
-				} else if req.ToolChoice != nil && req.ToolChoice.Type == anthropic.ToolChoiceTypeTool {
-					// Ensure selected tool still exists after filtering
-					remaining := map[string]struct{}{}
-					for _, t := range req.Tools {
-						remaining[t.Name] = struct{}{}
-					}
-					if _, ok := remaining[req.ToolChoice.Name]; !ok {
-						req.ToolChoice.Type = anthropic.ToolChoiceTypeNone
-						req.ToolChoice.Name = ""
-					}
-				}
-			}
+		if removed := preprocessRequest(req, prof); len(removed) > 0 {
+			logger.Info(fmt.Sprintf("removed disallowed tools: %s", strings.Join(removed, ",")))
 		}
-		if prof.Options.GetPreventEmptyTextToolResult() {
-			// No idea why Claude Code send empty text in tool_result, so we replace it with a hint message if necessary.
-			for _, message := range req.Messages {
-				if message != nil {
-					for _, content := range message.Content {
-						if content != nil && content.Type == anthropic.MessageContentTypeToolResult {
-							for _, part := range content.Content {
-								if part != nil && part.Type == anthropic.MessageContentTypeText && part.Text == "" {
-									part.Text = "(No content)"
-								}
-							}
-						}
-					}
-				}
+		if adapter.DefaultMiddlewares.HasRequest() {
+			if err = adapter.DefaultMiddlewares.TransformRequest(ctx, req); err != nil {
+				logger.Error(fmt.Sprintf("request rejected by middleware: %s", err.Error()))
+				respondError(w, http.StatusBadRequest, err.Error())
+				sn.Error = &snapshot.Error{Message: err.Error()}
+				sn.StatusCode = http.StatusBadRequest
+				return
+			}
+			// The raw body is forwarded by the pass-through and use_raw_request_body modes, which must
+			// not bypass what the middlewares changed.
+			if rawBody, err = json.Marshal(req); err != nil {
+				panic(fmt.Errorf("unreachable: %s", err.Error()))
 			}
 		}
 		var (
-			inputTokens  int64
-			outputTokens int64
-			stopReason   = anthropic.StopReason("unknown")
+			inputTokens              int64
+			outputTokens             int64
+			cacheReadInputTokens     int64
+			cacheCreationInputTokens int64
+			stopReason               = anthropic.StopReason("unknown")
 		)
 		countTokensCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
 		defer cancel()
@@ -316,14 +409,35 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 						if err == nil {
 							tool.InputSchema = newInputSchema
 						} else {
-							slog.Warn(fmt.Sprintf("[%d] error disabling %q in %s tool: %s", requestID, key, anthropic.ToolNameWebSearch, err.Error()))
+							logger.Warn(fmt.Sprintf("error disabling %q in %s tool: %s", key, anthropic.ToolNameWebSearch, err.Error()))
 						}
 					}
 				}
 			}
 		}
-		if !prof.Options.GetDisableCountTokensRequest() {
-			usage, err := prov.CountAnthropicTokens(countTokensCtx, &anthropic.CountTokensRequest{
+		// Pass-through responses are copied to the client as is and never assembled, so they are not cached.
+		var (
+			cachedResponses  *utils.LRU[string, *anthropic.Message]
+			responseCacheKey string
+		)
+		if !prof.Anthropic.GetEnablePassThroughMode() {
+			cachedResponses, responseCacheKey = responseCache.lookup(prof, req, r.Header)
+		}
+		if cachedMessage, ok := cachedResponses.Get(responseCacheKey); ok {
+			logger.Info("replaying cached response")
+			w.Header().Set("X-Cc-Response-Cache", "hit")
+			sn.AnthropicResponse = cachedMessage
+			sn.StatusCode = http.StatusOK
+			if err = writeCachedResponse(w, bool(req.Stream), cachedMessage); err != nil {
+				logger.Warn(fmt.Sprintf("error sending cached response: %s", err.Error()))
+				sn.Error = &snapshot.Error{Message: err.Error()}
+			}
+			return
+		} else if cachedResponses != nil {
+			w.Header().Set("X-Cc-Response-Cache", "miss")
+		}
+		if prof.Options.GetLocalCountTokens() {
+			inputTokens = adapter.EstimateInputTokens(&anthropic.CountTokensRequest{
 				System:     req.System,
 				Model:      req.Model,
 				Messages:   req.Messages,
@@ -331,23 +445,46 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 				ToolChoice: req.ToolChoice,
 				Tools:      req.Tools,
 			})
-			if err != nil {
+			logger.Info(fmt.Sprintf("request input tokens (approximate, estimated locally): %d", inputTokens))
+		} else if !prof.Options.GetDisableCountTokensRequest() {
+			countTokensRequest := &anthropic.CountTokensRequest{
+				System:     req.System,
+				Model:      countTokensModel(req.Model, prof),
+				Messages:   req.Messages,
+				Thinking:   req.Thinking,
+				ToolChoice: req.ToolChoice,
+				Tools:      req.Tools,
+			}
+			cache, cacheKey := countTokensCache.lookup(prof, countTokensRequest)
+			if cachedTokens, ok := cache.Get(cacheKey); ok {
+				inputTokens = cachedTokens
+				logger.Info(fmt.Sprintf("request input tokens (estimated, cached): %d", inputTokens))
+			} else if usage, err := prov.CountAnthropicTokens(countTokensCtx, countTokensRequest,
+				provider.WithRequestID(sn.UpstreamRequestID),
+				provider.WithTraceContext(traceHeader),
+				provider.WithStaticHeaders(prof.Anthropic.GetHeaders()),
+			); err != nil {
 				if errors.Is(err, context.DeadlineExceeded) {
-					slog.Warn(fmt.Sprintf("[%d] token calculation timed out", requestID))
+					logger.Warn("token calculation timed out")
 				} else {
-					slog.Error(fmt.Sprintf("[%d] error making CountAnthropicTokens request: %s", requestID, err.Error()))
+					logger.Error(fmt.Sprintf("error making CountAnthropicTokens request: %s", err.Error()))
 				}
 				sn.Error = &snapshot.Error{Message: err.Error()}
+				if prof.Options.GetEstimateTokensOnFailure() {
+					inputTokens = adapter.EstimateInputTokens(countTokensRequest)
+					logger.Debug(fmt.Sprintf("request input tokens (approximate, estimated locally): %d", inputTokens))
+				}
 			} else {
 				inputTokens = usage.InputTokens
-				slog.Info(fmt.Sprintf("[%d] request input tokens (estimated): %d", requestID, inputTokens))
+				logger.Info(fmt.Sprintf("request input tokens (estimated): %d", inputTokens))
+				cache.Add(cacheKey, inputTokens)
 			}
 		}
 		hasServerTools := sync.OnceValue(func() bool {
 			return lo.ContainsBy(req.Tools, func(tool *anthropic.Tool) bool {
-				isServerTool := tool.Type != nil && *tool.Type != anthropic.ToolTypeCustom
+				isServerTool := isAnthropicServerTool(prof, tool)
 				if isServerTool {
-					slog.Info(fmt.Sprintf("[%d] request contains %s tool: %s", requestID, *tool.Type, tool.Name))
+					logger.Info(fmt.Sprintf("request contains %s tool: %s", *tool.Type, tool.Name))
 				}
 				return isServerTool
 			})
@@ -355,20 +492,66 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 		useAnthropicProvider := func() bool {
 			return hasServerTools() || prof.Provider == ProviderAnthropic
 		}
+		// Released by a deferred call, so that the slot is also freed when the handler panics.
+		releaseSlot, err := acquireConcurrencySlot(ctx, prof)
+		if err != nil {
+			message := concurrencyLimitMessage(prof)
+			logger.Warn(message)
+			respondError(w, http.StatusTooManyRequests, message)
+			sn.Error = &snapshot.Error{Message: message}
+			sn.StatusCode = http.StatusTooManyRequests
+			return
+		}
+		defer releaseSlot()
+		requestTimeout := prof.Options.GetRequestTimeout()
+		ctx, timer, cancelTimer := withRequestTimeout(ctx, requestTimeout)
+		defer cancelTimer()
+		timeoutMessage := fmt.Sprintf("Upstream did not respond within %s", requestTimeout)
+		// fallBack hands the request over to the fallback profile of prof when the upstream failed
+		// with err before anything was written to w, and reports whether it did.
+		fallBack := func(err error) bool {
+			name := prof.Options.GetFallbackProfile()
+			if name == "" || !isFallbackError(r.Context(), err) {
+				return false
+			}
+			tried := append(slices.Clone(attempt.tried), prof.Name)
+			if slices.Contains(tried, name) {
+				logger.Warn(fmt.Sprintf("not falling back to profile %q, which already failed for this request", name))
+				return false
+			}
+			fallback, ok := pmPtr.Load().Get(name)
+			if !ok {
+				logger.Warn(fmt.Sprintf("fallback profile %q does not exist", name))
+				return false
+			}
+			logger.Warn(fmt.Sprintf("falling back to profile %q", name))
+			sn.Error = &snapshot.Error{Message: err.Error()}
+			attempt.fallback, attempt.tried = fallback, tried
+			return true
+		}
+		// ctx already ends when the client disconnects; it is also canceled when the handler returns,
+		// so that an upstream stream which was not read to its end is aborted rather than drained,
+		// and the upstream stops generating (and billing) tokens nobody reads.
+		ctx, cancelUpstream := context.WithCancel(ctx)
 		var (
-			stream     anthropic.MessageStream
-			ccProvider = prof.Provider
-			orProvider = "<unknown>"
+			stream        anthropic.MessageStream
+			ccProvider    = prof.Provider
+			orProvider    = "<unknown>"
+			serviceTier   string
+			resolvedModel string
 		)
 		defer func() {
+			cancelUpstream()
 			if stream != nil {
+				// Releases the upstream response body; reads fail right away once ctx is canceled.
 				for range stream {
 				}
 			}
 		}()
 		if useAnthropicProvider() {
 			sn.Provider = ProviderAnthropic
-			slog.Info(fmt.Sprintf("[%d] using provider %q", requestID, ProviderAnthropic))
+			logger = logger.With("provider", ProviderAnthropic)
+			logger.Info("using provider")
 			w.Header().Set("X-Provider", ProviderAnthropic)
 			var (
 				header                http.Header
@@ -379,51 +562,61 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 				sn.ResponseHeader = snapshot.Header(header)
 			}()
 			if enablePassThroughMode {
+				resolvedModel := req.Model
 				if targetModel, ok := prof.Options.GetModels()[req.Model]; ok {
 					rawBody, err = sjson.SetBytes(rawBody, "model", targetModel)
 					if err != nil {
 						panic(fmt.Errorf("unreachable: %s", err.Error()))
 					}
+					resolvedModel = targetModel
 				}
+				// The response is copied as-is, so the model is reported before it is sent.
+				w.Header().Set("X-Resolved-Model", resolvedModel)
 				reader, header, err = prov.MakeAnthropicMessagesRequest(ctx,
 					utils.NewResettableReader(rawBody),
 					provider.WithQuery("beta", "true"),
 					provider.WithHeaders(r.Header),
+					provider.WithStaticHeaders(prof.Anthropic.GetHeaders()),
 				)
 			} else {
 				options := []provider.RequestOption{
 					provider.WithQuery("beta", "true"),
 					provider.WithHeaders(r.Header),
+					provider.WithStaticHeaders(prof.Anthropic.GetHeaders()),
 				}
 				if prof.Anthropic.GetUseRawRequestBody() {
-					rawBody, err = sjson.SetBytes(rawBody, "stream", true)
-					if err != nil {
-						panic(fmt.Errorf("unreachable: %s", err.Error()))
-					}
 					if targetModel, ok := prof.Options.GetModels()[req.Model]; ok {
 						rawBody, err = sjson.SetBytes(rawBody, "model", targetModel)
 						if err != nil {
 							panic(fmt.Errorf("unreachable: %s", err.Error()))
 						}
 					}
+					if prof.Options.GetDisableThinking() {
+						if rawBody, err = sjson.DeleteBytes(rawBody, "thinking"); err != nil {
+							panic(fmt.Errorf("unreachable: %s", err.Error()))
+						}
+					}
 					options = append(options, provider.ReplaceBody(rawBody))
 				}
-				stream, header, err = prov.GenerateAnthropicMessage(ctx, req, options...)
+				if prof.Anthropic.GetUseRawRequestBody() && !bool(req.Stream) {
+					// The raw body keeps the client's stream field, so non-streaming requests get a
+					// JSON response, which is replayed as a stream to share the handling below.
+					stream, header, err = makeAnthropicNonStreamingRequest(ctx, prov, rawBody, options...)
+				} else {
+					stream, header, err = prov.GenerateAnthropicMessage(ctx, req, options...)
+				}
 			}
 			if err != nil {
-				slog.Error(fmt.Sprintf("[%d] error making anthropic /v1/messages request: %s", requestID, err.Error()))
-				if providerError, isProviderError := provider.ParseError(err); isProviderError {
-					respondError(w, providerError.StatusCode(), providerError.Message())
-					sn.Error = &snapshot.Error{
-						Message: providerError.Message(),
-						Type:    providerError.Type(),
-						Source:  providerError.Source(),
-					}
-					sn.StatusCode = providerError.StatusCode()
+				logger.Error(fmt.Sprintf("error making anthropic /v1/messages request: %s", err.Error()))
+				if fallBack(err) {
+					return
+				}
+				if isRequestTimeout(ctx) {
+					respondError(w, 529, timeoutMessage)
+					sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
+					sn.StatusCode = 529
 				} else {
-					respondError(w, http.StatusInternalServerError, err.Error())
-					sn.Error = &snapshot.Error{Message: err.Error()}
-					sn.StatusCode = http.StatusInternalServerError
+					respondUpstreamError(w, sn, err)
 				}
 				return
 			}
@@ -440,10 +633,15 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 				sn.StatusCode = http.StatusOK
 				// Copy response to client and capture for parsing
 				var recvBuf bytes.Buffer
-				tee := io.TeeReader(reader, &recvBuf)
+				tee := io.TeeReader(&timerResetReader{Reader: reader, timer: timer}, &recvBuf)
 				if _, err := io.Copy(w, tee); err != nil {
-					slog.Warn(fmt.Sprintf("[%d] error sending Anthropic response: %s", requestID, err))
-					sn.Error = &snapshot.Error{Message: err.Error()}
+					if isRequestTimeout(ctx) {
+						logger.Warn(fmt.Sprintf("upstream timed out after %s without producing data", requestTimeout))
+						sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
+					} else {
+						logger.Warn(fmt.Sprintf("error sending Anthropic response: %s", err))
+						sn.Error = &snapshot.Error{Message: err.Error()}
+					}
 				}
 				// Parse response to build anthropic.Message for snapshot
 				// Check Content-Type header to determine format
@@ -453,12 +651,12 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 					stream := provider.MakeAnthropicStream(prof, io.NopCloser(&recvBuf))
 					for event, err := range stream {
 						if err != nil {
-							slog.Error(fmt.Sprintf("[%d] error parsing SSE response for snapshot: %s", requestID, err))
+							logger.Error(fmt.Sprintf("error parsing SSE response for snapshot: %s", err))
 							sn.Error = &snapshot.Error{Message: err.Error()}
 							return
 						}
 						if err = dstMessageBuilder.Add(event); err != nil {
-							slog.Error(fmt.Sprintf("[%d] error building message: %s", requestID, err))
+							logger.Error(fmt.Sprintf("error building message: %s", err))
 							sn.Error = &snapshot.Error{Message: err.Error()}
 							return
 						}
@@ -467,61 +665,178 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 				} else if utils.IsContentType(header, "application/json") {
 					// JSON format: unmarshal directly
 					if err := json.Unmarshal(recvBuf.Bytes(), &sn.AnthropicResponse); err != nil {
-						slog.Error(fmt.Sprintf("[%d] error unmarshalling Anthropic response: %s", requestID, err))
+						logger.Error(fmt.Sprintf("error unmarshalling Anthropic response: %s", err))
 						sn.Error = &snapshot.Error{Message: err.Error()}
 					}
 				} else {
-					slog.Warn(fmt.Sprintf("[%d] unknown Content-Type for snapshot parsing: %s", requestID, header.Get("Content-Type")))
+					logger.Warn(fmt.Sprintf("unknown Content-Type for snapshot parsing: %s", header.Get("Content-Type")))
+				}
+				if sn.AnthropicResponse != nil && sn.AnthropicResponse.Usage != nil {
+					usage := sn.AnthropicResponse.Usage
+					mr.ObserveUsage(metrics.Labels{Profile: sn.Profile, Provider: sn.Provider}, metrics.Usage{
+						InputTokens:              usage.InputTokens,
+						OutputTokens:             usage.OutputTokens,
+						CacheReadInputTokens:     usage.CacheReadInputTokens,
+						CacheCreationInputTokens: usage.CacheCreationInputTokens,
+					})
 				}
 				// Log response for debugging (only after parsing to avoid affecting recvBuf)
-				slog.Debug(fmt.Sprintf(">>>>>>>>>>>>>>>>> [%d] anthropic response >>>>>>>>>>>>>>>>>", requestID) + "\n" + recvBuf.String())
-				slog.Debug(fmt.Sprintf("<<<<<<<<<<<<<<<<< [%d] anthropic response <<<<<<<<<<<<<<<<<", requestID))
+				logger.Debug(">>>>>>>>>>>>>>>>> anthropic response >>>>>>>>>>>>>>>>>" + "\n" + recvBuf.String())
+				logger.Debug("<<<<<<<<<<<<<<<<< anthropic response <<<<<<<<<<<<<<<<<")
 				return
 			}
 		} else {
 			switch ccProvider {
+			case ProviderOpenAI:
+				sn.Provider = ProviderOpenAI
+				logger = logger.With("provider", ProviderOpenAI)
+				logger.Info("using provider")
+				w.Header().Set("X-Provider", ProviderOpenAI)
+				toolNames := &adapter.ToolNames{}
+				openaiRequest := adapter.ConvertAnthropicRequestToOpenAIRequest(ctx, req, adapter.RecordToolNames(toolNames))
+				sn.OpenAIRequest = openaiRequest
+				oaStream, header, err := prov.CreateOpenAIModelResponse(ctx, openaiRequest,
+					provider.WithRequestID(sn.UpstreamRequestID),
+					provider.WithTraceContext(traceHeader),
+					provider.WithStaticHeaders(prof.OpenAI.GetHeaders()),
+				)
+				defer func() {
+					sn.ResponseHeader = snapshot.Header(header)
+					sn.ServiceTier = serviceTier
+				}()
+				if err != nil {
+					logger.Error(fmt.Sprintf("error making OpenAI Responses request: %s", err.Error()))
+					if fallBack(err) {
+						return
+					}
+					if isRequestTimeout(ctx) {
+						respondError(w, 529, timeoutMessage)
+						sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
+						sn.StatusCode = 529
+					} else {
+						respondUpstreamError(w, sn, err)
+					}
+					return
+				}
+				stream = adapter.ConvertOpenAIStreamToAnthropicStream(
+					ctx,
+					oaStream,
+					adapter.WithInputTokens(inputTokens),
+					adapter.ExtractServiceTier(&serviceTier),
+					adapter.RestoreToolNames(toolNames),
+				)
+			case ProviderBedrock:
+				sn.Provider = ProviderBedrock
+				logger = logger.With("provider", ProviderBedrock)
+				logger.Info("using provider")
+				w.Header().Set("X-Provider", ProviderBedrock)
+				creds, err := provider.LoadBedrockCredentials(prof.Bedrock)
+				if err != nil {
+					logger.Error(fmt.Sprintf("error loading AWS credentials: %s", err.Error()))
+					respondError(w, http.StatusInternalServerError, err.Error())
+					sn.Error = &snapshot.Error{Message: err.Error()}
+					sn.StatusCode = http.StatusInternalServerError
+					return
+				}
+				var header http.Header
+				defer func() {
+					sn.ResponseHeader = snapshot.Header(header)
+				}()
+				stream, header, err = prov.GenerateBedrockMessage(ctx, req,
+					bedrock.WithAnthropicBetaFeatures(r.Header),
+					provider.WithRequestID(sn.UpstreamRequestID),
+					provider.WithTraceContext(traceHeader),
+					// Signing must come last, after every change to the request.
+					bedrock.WithSignature(creds, prof.Bedrock.GetRegion()),
+				)
+				if err != nil {
+					logger.Error(fmt.Sprintf("error making Bedrock InvokeModelWithResponseStream request: %s", err.Error()))
+					if fallBack(err) {
+						return
+					}
+					if isRequestTimeout(ctx) {
+						respondError(w, 529, timeoutMessage)
+						sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
+						sn.StatusCode = 529
+					} else {
+						respondUpstreamError(w, sn, err)
+					}
+					return
+				}
+			case ProviderGemini:
+				sn.Provider = ProviderGemini
+				logger = logger.With("provider", ProviderGemini)
+				logger.Info("using provider")
+				w.Header().Set("X-Provider", ProviderGemini)
+				var header http.Header
+				defer func() {
+					sn.ResponseHeader = snapshot.Header(header)
+				}()
+				stream, header, err = prov.GenerateGeminiMessage(ctx, req,
+					provider.WithRequestID(sn.UpstreamRequestID),
+					provider.WithTraceContext(traceHeader),
+				)
+				if err != nil {
+					logger.Error(fmt.Sprintf("error making Gemini streamGenerateContent request: %s", err.Error()))
+					if fallBack(err) {
+						return
+					}
+					if isRequestTimeout(ctx) {
+						respondError(w, 529, timeoutMessage)
+						sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
+						sn.StatusCode = 529
+					} else {
+						respondUpstreamError(w, sn, err)
+					}
+					return
+				}
 			case ProviderOpenRouter:
 				fallthrough
 			default:
 				sn.Provider = ProviderOpenRouter
 				ccProvider = ProviderOpenRouter
-				slog.Info(fmt.Sprintf("[%d] using provider %q", requestID, ProviderOpenRouter))
+				logger = logger.With("provider", ProviderOpenRouter)
+				logger.Info("using provider")
 				w.Header().Set("X-Provider", ProviderOpenRouter)
-				allowedProviders := prof.OpenRouter.GetAllowedProviders()
-				openrouterRequest := adapter.ConvertAnthropicRequestToOpenRouterRequest(ctx, req)
+				toolNames := &adapter.ToolNames{}
+				openrouterRequest, err := adapter.ConvertAnthropicRequestToOpenRouterRequest(ctx, req, adapter.RecordToolNames(toolNames))
+				if err != nil {
+					logger.Error(fmt.Sprintf("invalid request: %s", err.Error()))
+					respondError(w, http.StatusBadRequest, err.Error())
+					sn.Error = &snapshot.Error{Message: err.Error()}
+					sn.StatusCode = http.StatusBadRequest
+					return
+				}
 				sn.OpenRouterRequest = openrouterRequest
 				orStream, header, err := prov.CreateOpenRouterChatCompletion(
 					ctx,
 					openrouterRequest,
 					openrouter.WithIdentity("https://github.com/x5iu/claude-code-adapter", "claude-code-adapter"),
 					openrouter.WithAnthropicBetaFeatures(r.Header),
-					openrouter.WithProviderPreference(&openrouter.ProviderPreference{
-						Order:             allowedProviders,
-						AllowFallbacks:    lo.ToPtr(true),
-						RequireParameters: lo.ToPtr(false), // OpenRouter does not support all Anthropic parameters.
-						Only:              allowedProviders,
-						Sort:              lo.ToPtr(openrouter.ProviderSortMethodThroughput),
-					}),
+					provider.WithRequestID(sn.UpstreamRequestID),
+					provider.WithTraceContext(traceHeader),
+					provider.WithStaticHeaders(prof.OpenRouter.GetHeaders()),
+					openrouter.WithTransforms(prof.OpenRouter.GetTransforms()),
+					openrouter.WithFallbackModels(prof.OpenRouter.GetFallbackModels()),
+					openrouter.WithMergedProviderPreference(openRouterProviderPreference(prof.OpenRouter)),
 				)
 				chatCompletionBuilder := openrouter.NewChatCompletionBuilder()
 				defer func() {
 					sn.ResponseHeader = snapshot.Header(header)
 					sn.OpenRouterResponse = chatCompletionBuilder.Build()
+					sn.ServiceTier = serviceTier
 				}()
 				if err != nil {
-					slog.Error(fmt.Sprintf("[%d] error making OpenRouter ChatCompletions request: %s", requestID, err.Error()))
-					if providerError, isProviderError := provider.ParseError(err); isProviderError {
-						respondError(w, providerError.StatusCode(), providerError.Message())
-						sn.Error = &snapshot.Error{
-							Message: providerError.Message(),
-							Type:    providerError.Type(),
-							Source:  providerError.Source(),
-						}
-						sn.StatusCode = providerError.StatusCode()
+					logger.Error(fmt.Sprintf("error making OpenRouter ChatCompletions request: %s", err.Error()))
+					if fallBack(err) {
+						return
+					}
+					if isRequestTimeout(ctx) {
+						respondError(w, 529, timeoutMessage)
+						sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
+						sn.StatusCode = 529
 					} else {
-						respondError(w, http.StatusInternalServerError, err.Error())
-						sn.Error = &snapshot.Error{Message: err.Error()}
-						sn.StatusCode = http.StatusInternalServerError
+						respondUpstreamError(w, sn, err)
 					}
 					return
 				}
@@ -530,28 +845,83 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 					orStream,
 					adapter.WithInputTokens(inputTokens),
 					adapter.ExtractOpenRouterProvider(&orProvider),
+					adapter.ExtractServiceTier(&serviceTier),
 					adapter.ExtractOpenRouterChatCompletionBuilder(chatCompletionBuilder),
+					adapter.RestoreToolNames(toolNames),
+					adapter.WithStopSequences(req.StopSequences),
+					adapter.WithDisableParallelToolUse(req.ToolChoice != nil && req.ToolChoice.DisableParallelToolUse),
 				)
 			}
 		}
 		dstMessageBuilder := anthropic.NewMessageBuilder()
+		var (
+			pinger *keepAlive
+			sse    *sseWriter
+		)
 		if req.Stream {
 			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			// The resolved model, the provider OpenRouter routed to and the service tier are only
+			// known once the stream has been read, so they are sent as trailers.
+			switch ccProvider {
+			case ProviderOpenRouter:
+				w.Header().Set("Trailer", "X-Resolved-Model, X-Upstream-Provider, X-Service-Tier")
+			case ProviderOpenAI:
+				w.Header().Set("Trailer", "X-Resolved-Model, X-Service-Tier")
+			default:
+				w.Header().Set("Trailer", "X-Resolved-Model")
+			}
+			defer func() {
+				setResolvedHeaders(w.Header(), ccProvider, resolvedModel, orProvider, serviceTier)
+			}()
 			w.WriteHeader(http.StatusOK)
 			sn.StatusCode = http.StatusOK
+			sse = newSSEWriter(w, viper.GetDuration(delimiter.ViperKey("http", "flush_interval")))
+			defer sse.Close()
+			pinger = startKeepAlive(w, prof.Options.GetKeepAliveInterval())
+			defer pinger.Stop()
 		} else {
 			w.Header().Set("Content-Type", "application/json")
 		}
 		contextWindowResizeFactor := prof.Options.GetContextWindowResizeFactor()
+		if context1M && len(prof.Options.GetContext1MModels()) > 0 {
+			// The model is known to serve the full 1M window the client opted into,
+			// so usage is reported as-is instead of being scaled to a smaller window.
+			contextWindowResizeFactor = 1.0
+		}
+		if ccProvider == ProviderOpenRouter && prof.OpenRouter.HasTransform(openrouter.TransformMiddleOut) {
+			// OpenRouter compresses the prompt itself; max_tokens was not scaled, so neither is usage.
+			contextWindowResizeFactor = 1.0
+		}
+		if prof.Options.GetDisableThinking() {
+			stream = adapter.DropThinkingBlocks(stream)
+		}
+		stream = adapter.DefaultMiddlewares.WrapStream(ctx, stream)
 		for event, err := range stream {
-			if err != nil {
+			// The handler writes to w from here on, so pings must not interleave with it.
+			pinger.Stop()
+			if err != nil && isRequestTimeout(ctx) {
+				logger.Error(fmt.Sprintf("upstream timed out after %s without producing events", requestTimeout))
+				sn.Error = &snapshot.Error{Message: timeoutMessage, Type: anthropic.OverloadedError}
 				if req.Stream {
-					slog.Error(fmt.Sprintf("[%d] error transfering response stream: %s", requestID, err.Error()))
-					fmt.Fprintf(w, "event: %s\n", anthropic.EventTypeError)
-					fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(&anthropic.StreamError{
-						ErrType:    anthropic.ErrorContentType,
-						ErrMessage: err.Error(),
+					fmt.Fprintf(sse, "event: %s\n", anthropic.EventTypeError)
+					fmt.Fprintf(sse, "data: %s\n\n", utils.JSONEncodeString(&anthropic.Error{
+						ContentType: anthropic.ErrorContentType,
+						Inner: &anthropic.InnerError{
+							Type:    anthropic.OverloadedError,
+							Message: timeoutMessage,
+						},
 					}))
+				} else {
+					respondError(w, 529, timeoutMessage)
+					sn.StatusCode = 529
+				}
+				return
+			}
+			if err != nil {
+				if req.Stream {
+					logger.Error(fmt.Sprintf("error transfering response stream: %s", err.Error()))
+					sn.Error = streamError(err)
+					writeStreamError(sse, sn.Error)
 				} else {
 					respondError(w, http.StatusInternalServerError, err.Error())
 					sn.Error = &snapshot.Error{Message: err.Error()}
@@ -559,6 +929,10 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 				}
 				return
 			}
+			// Synthetic pings are produced locally and must not keep a stalled upstream alive.
+			if _, isPing := event.(*anthropic.EventPing); !isPing {
+				timer.Reset()
+			}
 			switch e := event.(type) {
 			case *anthropic.EventMessageStart:
 				if e.Message != nil {
@@ -587,53 +961,24 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 			}
 			if err = dstMessageBuilder.Add(event); err != nil {
 				if req.Stream {
-					slog.Error(fmt.Sprintf("[%d] an error occurs while consuming stream: %s", requestID, err.Error()))
-					fmt.Fprintf(w, "event: %s\n", anthropic.EventTypeError)
-					if providerError, isProviderError := provider.ParseError(err); isProviderError {
-						fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(&anthropic.StreamError{
-							ErrType:    providerError.Type(),
-							ErrMessage: providerError.Message(),
-						}))
-						sn.Error = &snapshot.Error{
-							Message: providerError.Message(),
-							Type:    providerError.Type(),
-							Source:  providerError.Source(),
-						}
-					} else {
-						fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(&anthropic.StreamError{
-							ErrType:    anthropic.ErrorContentType,
-							ErrMessage: err.Error(),
-						}))
-						sn.Error = &snapshot.Error{Message: err.Error()}
-					}
+					logger.Error(fmt.Sprintf("an error occurs while consuming stream: %s", err.Error()))
+					sn.Error = streamError(err)
+					writeStreamError(sse, sn.Error)
 				} else {
-					if providerError, isProviderError := provider.ParseError(err); isProviderError {
-						respondError(w, providerError.StatusCode(), providerError.Message())
-						sn.Error = &snapshot.Error{
-							Message: providerError.Message(),
-							Type:    providerError.Type(),
-							Source:  providerError.Source(),
-						}
-						sn.StatusCode = providerError.StatusCode()
-					} else {
-						respondError(w, http.StatusInternalServerError, err.Error())
-						sn.Error = &snapshot.Error{Message: err.Error()}
-						sn.StatusCode = http.StatusInternalServerError
-					}
+					respondUpstreamError(w, sn, err)
 				}
 				return
 			}
 			if req.Stream {
-				fmt.Fprintf(w, "event: %s\n", event.EventType())
-				fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(event))
-				if flusher, isFlusher := w.(http.Flusher); isFlusher {
-					flusher.Flush()
-				}
+				sse.WriteEvent(event)
 			}
 			if messageStart, isMessageStart := event.(*anthropic.EventMessageStart); isMessageStart {
 				if messageStart.Message != nil {
+					resolvedModel = messageStart.Message.Model
 					if usage := messageStart.Message.Usage; usage != nil {
 						inputTokens = usage.InputTokens
+						cacheReadInputTokens = usage.CacheReadInputTokens
+						cacheCreationInputTokens = usage.CacheCreationInputTokens
 					}
 				}
 			}
@@ -643,6 +988,12 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 						inputTokens = messageDelta.Usage.InputTokens
 					}
 					outputTokens = messageDelta.Usage.OutputTokens
+					if messageDelta.Usage.CacheReadInputTokens > 0 {
+						cacheReadInputTokens = messageDelta.Usage.CacheReadInputTokens
+					}
+					if messageDelta.Usage.CacheCreationInputTokens > 0 {
+						cacheCreationInputTokens = messageDelta.Usage.CacheCreationInputTokens
+					}
 				}
 				if messageDelta.Delta != nil && messageDelta.Delta.StopReason != nil {
 					stopReason = *messageDelta.Delta.StopReason
@@ -653,28 +1004,115 @@ func onMessages(cmd *cobra.Command, prov provider.Provider, rec snapshot.Recorde
 		sn.AnthropicResponse = dstMessage
 		rawBytes, err := json.MarshalIndent(dstMessage, "", "    ")
 		if err != nil {
-			slog.Error(fmt.Sprintf("[%d] error marshaling non-stream response: %s", requestID, err.Error()))
+			logger.Error(fmt.Sprintf("error marshaling non-stream response: %s", err.Error()))
 			respondError(w, http.StatusInternalServerError, err.Error())
 			sn.Error = &snapshot.Error{Message: err.Error()}
 			sn.StatusCode = http.StatusInternalServerError
 			return
 		}
+		// Only responses that were received in full get here.
+		cachedResponses.Add(responseCacheKey, dstMessage)
 		if !req.Stream {
+			setResolvedHeaders(w.Header(), ccProvider, resolvedModel, orProvider, serviceTier)
 			w.WriteHeader(http.StatusOK)
 			sn.StatusCode = http.StatusOK
 			if _, err = w.Write(rawBytes); err != nil {
-				slog.Warn(fmt.Sprintf("[%d] errror sending non-stream response: %s", requestID, err.Error()))
+				logger.Warn(fmt.Sprintf("errror sending non-stream response: %s", err.Error()))
 				sn.Error = &snapshot.Error{Message: err.Error()}
 			}
 		}
 		switch ccProvider {
 		case ProviderOpenRouter:
-			slog.Info(fmt.Sprintf("[%d] openrouter provider: %s", requestID, orProvider))
+			logger.Info(fmt.Sprintf("openrouter provider: %s", orProvider))
+			if serviceTier != "" {
+				logger.Info(fmt.Sprintf("service tier: %s", serviceTier))
+			}
+		}
+		logger.Info(fmt.Sprintf("stop reason: %s", stopReason))
+		logger.Info(fmt.Sprintf("final tokens usage: input=%d, output=%d", inputTokens, outputTokens))
+		mr.ObserveUsage(metrics.Labels{Profile: sn.Profile, Provider: sn.Provider}, metrics.Usage{
+			InputTokens:              inputTokens,
+			OutputTokens:             outputTokens,
+			CacheReadInputTokens:     cacheReadInputTokens,
+			CacheCreationInputTokens: cacheCreationInputTokens,
+		})
+		logger.Debug(">>>>>>>>>>>>>>>>> anthropic response >>>>>>>>>>>>>>>>>" + "\n" + string(rawBytes))
+		logger.Debug("<<<<<<<<<<<<<<<<< anthropic response <<<<<<<<<<<<<<<<<")
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		attempt := &messagesAttempt{}
+		for {
+			// Every attempt starts from the headers of the client request, which handle changes.
+			attemptRequest := r.Clone(r.Context())
+			if attempt.body != nil {
+				attemptRequest.Body = io.NopCloser(bytes.NewReader(attempt.body))
+			}
+			handle(w, attemptRequest, attempt)
+			if attempt.fallback == nil {
+				return
+			}
+			w.Header().Del("X-Cc-Response-Cache")
+			attempt = &messagesAttempt{profile: attempt.fallback, tried: attempt.tried, body: attempt.body}
 		}
-		slog.Info(fmt.Sprintf("[%d] stop reason: %s", requestID, stopReason))
-		slog.Info(fmt.Sprintf("[%d] final tokens usage: input=%d, output=%d", requestID, inputTokens, outputTokens))
-		slog.Debug(fmt.Sprintf(">>>>>>>>>>>>>>>>> [%d] anthropic response >>>>>>>>>>>>>>>>>", requestID) + "\n" + string(rawBytes))
-		slog.Debug(fmt.Sprintf("<<<<<<<<<<<<<<<<< [%d] anthropic response <<<<<<<<<<<<<<<<<", requestID))
+	}
+}
+
+// messagesAttempt is an attempt of the messages handler to serve a request.
+type messagesAttempt struct {
+	// profile serves the attempt; nil matches the model of the request.
+	profile *profile.Profile
+	// tried are the names of the profiles whose attempts failed before.
+	tried []string
+	// body is the request body, read by the first attempt.
+	body []byte
+	// fallback is set by an attempt that hands the request over to the fallback profile.
+	fallback *profile.Profile
+}
+
+// isFallbackError reports whether err, returned by an upstream request, is worth retrying with
+// the fallback profile: the upstream could not be reached, timed out or responded with a 5xx
+// error. Nothing is retried once the client went away.
+func isFallbackError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if providerError, isProviderError := provider.ParseError(err); isProviderError {
+		return providerError.StatusCode() >= http.StatusInternalServerError
+	}
+	var (
+		urlErr *url.Error
+		netErr net.Error
+	)
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
+
+// makeAnthropicNonStreamingRequest sends body to the Anthropic /v1/messages endpoint as is and
+// replays the JSON response as a message stream.
+func makeAnthropicNonStreamingRequest(ctx context.Context, prov provider.Provider, body []byte, opts ...provider.RequestOption) (anthropic.MessageStream, http.Header, error) {
+	reader, header, err := prov.MakeAnthropicMessagesRequest(ctx, utils.NewResettableReader(body), opts...)
+	if err != nil {
+		return nil, header, err
+	}
+	defer reader.Close()
+	if !utils.IsContentType(header, "application/json") {
+		return nil, header, fmt.Errorf("unexpected Content-Type: %s", header.Get("Content-Type"))
+	}
+	var message *anthropic.Message
+	if err = json.NewDecoder(reader).Decode(&message); err != nil {
+		return nil, header, fmt.Errorf("error decoding Anthropic response: %w", err)
+	}
+	return anthropic.NewMessageStream(message), header, nil
+}
+
+// openRouterProviderPreference builds the provider routing preference of a profile.
+func openRouterProviderPreference(cfg *profile.OpenRouterConfig) *openrouter.ProviderPreference {
+	preferredProviders := cfg.GetPreferredProviders()
+	return &openrouter.ProviderPreference{
+		Order:             preferredProviders,
+		AllowFallbacks:    lo.ToPtr(cfg.GetAllowFallbacks()),
+		RequireParameters: lo.ToPtr(cfg.GetRequireParameters()),
+		Only:              preferredProviders,
+		Sort:              lo.ToPtr(cfg.GetProviderSort()),
 	}
 }
 
@@ -690,13 +1128,34 @@ func profileToSnapshotConfig(p *profile.Profile) *snapshot.Config {
 			Models:                     p.Options.Models,
 			ContextWindowResizeFactor:  p.Options.ContextWindowResizeFactor,
 			DisableCountTokensRequest:  p.Options.DisableCountTokensRequest,
+			LocalCountTokens:           p.Options.LocalCountTokens,
 			MinMaxTokens:               p.Options.MinMaxTokens,
+			DefaultMaxTokens:           p.Options.DefaultMaxTokens,
+			MaxTokensCap:               p.Options.MaxTokensCap,
+			DisallowedTools:            p.Options.DisallowedTools,
+			MaxTools:                   p.Options.MaxTools,
+			SanitizeToolNames:          p.Options.SanitizeToolNames,
+			EnforceSerialToolCalls:     p.Options.EnforceSerialToolCalls,
+			InterimUsageInterval:       p.Options.InterimUsageInterval,
+			AutoCacheBreakpoints:       p.Options.AutoCacheBreakpoints,
+			DisableThinking:            p.Options.DisableThinking,
+			MaxStopSequences:           p.Options.MaxStopSequences,
+			LogitBias:                  p.Options.LogitBias,
+			MaxContentPartBytes:        p.Options.MaxContentPartBytes,
+			StrictSchemaSanitize:       p.Options.StrictSchemaSanitize,
+			SystemPrefix:               p.Options.SystemPrefix,
+			SystemSuffix:               p.Options.SystemSuffix,
+			RedactedThinkingMode:       p.Options.RedactedThinkingMode,
+			ImageDetail:                p.Options.ImageDetail,
 		}
 		if p.Options.Reasoning != nil {
 			cfg.Options.Reasoning = &snapshot.ReasoningConfig{
-				Format:    p.Options.Reasoning.Format,
-				Effort:    p.Options.Reasoning.Effort,
-				Delimiter: p.Options.Reasoning.Delimiter,
+				Format:              p.Options.Reasoning.Format,
+				Effort:              p.Options.Reasoning.Effort,
+				Delimiter:           p.Options.Reasoning.Delimiter,
+				Exclude:             p.Options.Reasoning.Exclude,
+				PreferSummary:       p.Options.Reasoning.PreferSummary,
+				EffortSuffixFormats: p.Options.Reasoning.EffortSuffixFormats,
 			}
 		}
 	}
@@ -712,14 +1171,455 @@ func profileToSnapshotConfig(p *profile.Profile) *snapshot.Config {
 	}
 	if p.OpenRouter != nil {
 		cfg.OpenRouter = &snapshot.OpenRouterConfig{
-			BaseURL:              p.OpenRouter.BaseURL,
-			ModelReasoningFormat: p.OpenRouter.ModelReasoningFormat,
-			AllowedProviders:     p.OpenRouter.AllowedProviders,
+			BaseURL:               p.OpenRouter.BaseURL,
+			ModelReasoningFormat:  p.OpenRouter.ModelReasoningFormat,
+			PreferredProviders:    p.OpenRouter.PreferredProviders,
+			Transforms:            p.OpenRouter.Transforms,
+			ClampCacheTTL:         p.OpenRouter.ClampCacheTTL,
+			MergeConsecutiveRoles: p.OpenRouter.MergeConsecutiveRoles,
+			ForcePartsContent:     p.OpenRouter.ForcePartsContent,
+			ProviderSort:          p.OpenRouter.ProviderSort,
+			AllowFallbacks:        p.OpenRouter.AllowFallbacks,
+			RequireParameters:     p.OpenRouter.RequireParameters,
+			ServiceTier:           p.OpenRouter.ServiceTier,
+			FallbackModels:        p.OpenRouter.FallbackModels,
+			WebSearch:             p.OpenRouter.WebSearch,
+		}
+	}
+	if p.Bedrock != nil {
+		cfg.Bedrock = &snapshot.BedrockConfig{
+			Region:  p.Bedrock.Region,
+			BaseURL: p.Bedrock.BaseURL,
+			Profile: p.Bedrock.Profile,
+			Models:  p.Bedrock.Models,
+		}
+	}
+	if p.Gemini != nil {
+		cfg.Gemini = &snapshot.GeminiConfig{
+			BaseURL: p.Gemini.BaseURL,
+			Models:  p.Gemini.Models,
+		}
+	}
+	if p.OpenAI != nil {
+		cfg.OpenAI = &snapshot.OpenAIConfig{
+			BaseURL:        p.OpenAI.BaseURL,
+			AllowedTools:   p.OpenAI.AllowedTools,
+			PromptCacheKey: p.OpenAI.PromptCacheKey,
+			ServiceTier:    p.OpenAI.ServiceTier,
 		}
 	}
 	return cfg
 }
 
+var errRequestTimeout = errors.New("request timeout")
+
+// requestTimer cancels the request context with errRequestTimeout once the upstream
+// has been silent for longer than the timeout. A nil *requestTimer never fires.
+type requestTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// withRequestTimeout wraps ctx with an idle timeout; a non-positive timeout means no timeout.
+func withRequestTimeout(ctx context.Context, timeout time.Duration) (context.Context, *requestTimer, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, nil, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := &requestTimer{
+		timer:   time.AfterFunc(timeout, func() { cancel(errRequestTimeout) }),
+		timeout: timeout,
+	}
+	return ctx, t, func() {
+		t.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Reset extends the deadline by another full timeout.
+func (t *requestTimer) Reset() {
+	if t != nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func isRequestTimeout(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRequestTimeout)
+}
+
+// timerResetReader extends the request timer whenever data is read from the upstream.
+type timerResetReader struct {
+	io.Reader
+	timer *requestTimer
+}
+
+func (r *timerResetReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.timer.Reset()
+	}
+	return n, err
+}
+
+// keepAlive writes SSE ping events at a fixed interval while the handler waits for the
+// first upstream event. A nil *keepAlive is a no-op.
+type keepAlive struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// startKeepAlive starts pinging w; a non-positive interval disables it and returns nil.
+func startKeepAlive(w http.ResponseWriter, interval time.Duration) *keepAlive {
+	if interval <= 0 {
+		return nil
+	}
+	k := &keepAlive{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(k.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-k.stop:
+				return
+			case <-ticker.C:
+				fmt.Fprintf(w, "event: %s\n", anthropic.EventTypePing)
+				fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(&anthropic.EventPing{Type: anthropic.EventTypePing}))
+				if flusher, isFlusher := w.(http.Flusher); isFlusher {
+					flusher.Flush()
+				}
+			}
+		}
+	}()
+	return k
+}
+
+// Stop stops pinging and waits for an in-flight ping to finish, so that the caller
+// has exclusive access to the ResponseWriter once it returns. It is safe to call repeatedly.
+func (k *keepAlive) Stop() {
+	if k == nil {
+		return
+	}
+	k.once.Do(func() { close(k.stop) })
+	<-k.done
+}
+
+// sseWriter writes SSE events to a ResponseWriter and flushes them, so that reverse proxies
+// do not hold them back. With a positive interval, content_block_delta events are flushed at
+// most once per interval, batching tiny deltas into fewer writes, and a timer flushes the last
+// batch; every other event is flushed right away. It is safe for concurrent use.
+type sseWriter struct {
+	mu        sync.Mutex
+	w         http.ResponseWriter
+	interval  time.Duration
+	lastFlush time.Time
+	timer     *time.Timer
+	closed    bool
+}
+
+func newSSEWriter(w http.ResponseWriter, interval time.Duration) *sseWriter {
+	return &sseWriter{w: w, interval: interval}
+}
+
+// Write writes p without flushing it; Close flushes it.
+func (s *sseWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// WriteEvent writes event and flushes it, unless it is a delta batched with the following ones.
+func (s *sseWriter) WriteEvent(event anthropic.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "event: %s\n", event.EventType())
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", utils.JSONEncodeString(event)); err != nil {
+		return err
+	}
+	_, isDelta := event.(*anthropic.EventContentBlockDelta)
+	if elapsed := time.Since(s.lastFlush); !isDelta || s.interval <= 0 || elapsed >= s.interval {
+		s.flush()
+	} else if s.timer == nil {
+		s.timer = time.AfterFunc(s.interval-elapsed, s.flushPending)
+	}
+	return nil
+}
+
+func (s *sseWriter) flush() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if flusher, isFlusher := s.w.(http.Flusher); isFlusher {
+		flusher.Flush()
+	}
+	s.lastFlush = time.Now()
+}
+
+func (s *sseWriter) flushPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil && !s.closed {
+		s.flush()
+	}
+}
+
+// Close flushes everything written so far. The ResponseWriter is not used once it returns.
+func (s *sseWriter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.flush()
+		s.closed = true
+	}
+}
+
+// countTokensModel returns the model of count_tokens requests for the requested model: the profile's
+// count_tokens_model when set, otherwise the requested model itself. The upstream model it is mapped
+// to by options.models is never used, since it is usually not a valid Anthropic model name.
+func countTokensModel(model string, prof *profile.Profile) string {
+	if countModel := prof.Options.GetCountTokensModel(); countModel != "" {
+		return countModel
+	}
+	return model
+}
+
+// countTokensCaches holds one cache of estimated input tokens per profile. A profile's cache is
+// created on first use and replaced when its size or TTL changes after a config reload.
+type countTokensCaches struct {
+	mu     sync.Mutex
+	caches map[string]*countTokensCacheEntry
+}
+
+type countTokensCacheEntry struct {
+	size  int
+	ttl   time.Duration
+	cache *utils.LRU[string, int64]
+}
+
+// lookup returns the cache of prof and the key of req in it, or a nil cache when caching is
+// disabled for prof or req cannot be hashed.
+func (c *countTokensCaches) lookup(prof *profile.Profile, req *anthropic.CountTokensRequest) (*utils.LRU[string, int64], string) {
+	if !prof.Options.GetCacheCountTokens() {
+		return nil, ""
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, ""
+	}
+	sum := sha256.Sum256(data)
+	size, ttl := prof.Options.GetCountTokensCacheSize(), prof.Options.GetCountTokensCacheTTL()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.caches[prof.Name]
+	if !ok || entry.size != size || entry.ttl != ttl {
+		if c.caches == nil {
+			c.caches = make(map[string]*countTokensCacheEntry)
+		}
+		entry = &countTokensCacheEntry{size: size, ttl: ttl, cache: utils.NewLRU[string, int64](size, ttl)}
+		c.caches[prof.Name] = entry
+	}
+	return entry.cache, hex.EncodeToString(sum[:])
+}
+
+// responseCaches holds one cache of assembled responses per profile, like countTokensCaches.
+type responseCaches struct {
+	mu     sync.Mutex
+	caches map[string]*responseCacheEntry
+}
+
+type responseCacheEntry struct {
+	size  int
+	ttl   time.Duration
+	cache *utils.LRU[string, *anthropic.Message]
+}
+
+// lookup returns the cache of prof and the key of req in it, or a nil cache when caching is
+// disabled for prof or req cannot be hashed. Rather than the upstream request, which is only
+// built by the provider branch, the key hashes what it is converted from: req, whose stream
+// field is always encoded as true so that streaming and non-streaming requests share entries,
+// the anthropic-beta header and the settings of prof, which may change on a config reload.
+func (c *responseCaches) lookup(prof *profile.Profile, req *anthropic.GenerateMessageRequest, header http.Header) (*utils.LRU[string, *anthropic.Message], string) {
+	if !prof.Options.GetResponseCache() {
+		return nil, ""
+	}
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	for _, v := range []any{profileToSnapshotConfig(prof), header.Values(anthropic.HeaderBeta), req} {
+		if err := encoder.Encode(v); err != nil {
+			return nil, ""
+		}
+	}
+	size, ttl := prof.Options.GetResponseCacheSize(), prof.Options.GetResponseCacheTTL()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.caches[prof.Name]
+	if !ok || entry.size != size || entry.ttl != ttl {
+		if c.caches == nil {
+			c.caches = make(map[string]*responseCacheEntry)
+		}
+		entry = &responseCacheEntry{size: size, ttl: ttl, cache: utils.NewLRU[string, *anthropic.Message](size, ttl)}
+		c.caches[prof.Name] = entry
+	}
+	return entry.cache, hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeCachedResponse sends message, replayed from the response cache, as a JSON message or, to
+// streaming requests, as the events that stream it.
+func writeCachedResponse(w http.ResponseWriter, stream bool, message *anthropic.Message) error {
+	if !stream {
+		rawBytes, err := json.MarshalIndent(message, "", "    ")
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(rawBytes)
+		return err
+	}
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	for event := range anthropic.NewMessageStream(message) {
+		fmt.Fprintf(w, "event: %s\n", event.EventType())
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(event)); err != nil {
+			return err
+		}
+	}
+	if flusher, isFlusher := w.(http.Flusher); isFlusher {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// defaultMaxBodyBytes is the request body limit used when http.max_body_bytes is not set.
+const defaultMaxBodyBytes = 32 << 20
+
+// readRequestBody reads the whole request body, up to http.max_body_bytes bytes.
+func readRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxBodyBytes := viper.GetInt64(delimiter.ViperKey("http", "max_body_bytes"))
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+}
+
+// requestBodyError returns the status code and message to respond with when readRequestBody fails.
+func requestBodyError(err error) (int, string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", maxBytesErr.Limit)
+	}
+	return http.StatusInternalServerError, fmt.Sprintf("Failed to read request body: %s", err.Error())
+}
+
+// streamError describes err, raised after the response started streaming. Provider errors keep
+// the Anthropic error type derived from their upstream status, so that clients back off on
+// rate_limit_error and overloaded_error; anything else, like a broken upstream connection, is
+// reported as api_error.
+func streamError(err error) *snapshot.Error {
+	if providerError, isProviderError := provider.ParseError(err); isProviderError {
+		errorType := providerError.Type()
+		if errorType == "" {
+			errorType = anthropic.ErrorTypeForStatus(providerError.StatusCode())
+		}
+		return &snapshot.Error{
+			Message: providerError.Message(),
+			Type:    errorType,
+			Source:  providerError.Source(),
+		}
+	}
+	return &snapshot.Error{Message: err.Error(), Type: anthropic.APIError}
+}
+
+// writeStreamError sends e as the error event of an Anthropic stream.
+func writeStreamError(w io.Writer, e *snapshot.Error) {
+	event := &anthropic.EventError{
+		Type:  anthropic.EventTypeError,
+		Error: &anthropic.StreamError{ErrType: e.Type, ErrMessage: e.Message},
+	}
+	fmt.Fprintf(w, "event: %s\n", event.EventType())
+	fmt.Fprintf(w, "data: %s\n\n", utils.JSONEncodeString(event))
+}
+
+// profileMatchError returns the status and message reported when ProfileManager.Match fails for
+// model: a model denied by its profile is a permission_error, any other failure a bad request.
+func profileMatchError(model string, err error) (int, string) {
+	var deniedErr *profile.ModelDeniedError
+	if errors.As(err, &deniedErr) {
+		return http.StatusForbidden, fmt.Sprintf("Model %q is not allowed by profile %q", model, deniedErr.Profile)
+	}
+	var unknownErr *unknownProfileError
+	if errors.As(err, &unknownErr) {
+		return http.StatusBadRequest, fmt.Sprintf("No profile named %q", unknownErr.Name)
+	}
+	var noMatchErr *profile.NoProfileMatchedError
+	if errors.As(err, &noMatchErr) && len(noMatchErr.Suggestions) > 0 {
+		return http.StatusBadRequest, fmt.Sprintf("No profile configured for model %q, %s", model, noMatchErr.DidYouMean())
+	}
+	return http.StatusBadRequest, fmt.Sprintf("No profile configured for model %q", model)
+}
+
+// HeaderProfileOverride names the profile a request is served with, instead of the one matching
+// its model. It takes precedence over the profile setting, and is not forwarded upstream.
+const HeaderProfileOverride = "X-Cc-Profile"
+
+// unknownProfileError is returned by overrideProfile when no profile has the requested name.
+type unknownProfileError struct {
+	Name string
+}
+
+func (e *unknownProfileError) Error() string {
+	return fmt.Sprintf("no profile named %q", e.Name)
+}
+
+// overrideProfile returns the profile of pm named name, which serves model whether or not one of
+// its patterns matches it. Models the profile denies stay denied.
+func overrideProfile(pm *profile.ProfileManager, name, model string) (*profile.Profile, error) {
+	prof, ok := pm.Get(name)
+	if !ok {
+		return nil, &unknownProfileError{Name: name}
+	}
+	if pattern, denied := prof.Options.DeniedModelPattern(model); denied {
+		return nil, &profile.ModelDeniedError{Model: model, Profile: prof.Name, Pattern: pattern}
+	}
+	return prof, nil
+}
+
+// setResolvedHeaders reports what actually served a request: the model the upstream answered with,
+// the service tier it used and, for OpenRouter, the provider it routed to. Values that are not
+// known are left out.
+func setResolvedHeaders(header http.Header, ccProvider, model, orProvider, serviceTier string) {
+	if model != "" {
+		header.Set("X-Resolved-Model", model)
+	}
+	if serviceTier != "" {
+		header.Set("X-Service-Tier", serviceTier)
+	}
+	if ccProvider == ProviderOpenRouter && orProvider != "" && orProvider != "<unknown>" {
+		header.Set("X-Upstream-Provider", orProvider)
+	}
+}
+
+// respondUpstreamError responds with err, which an upstream request failed with, and records it in
+// sn. Provider errors keep the status, message and type of the upstream; anything else is reported
+// as an internal error.
+func respondUpstreamError(w http.ResponseWriter, sn *snapshot.Snapshot, err error) {
+	if providerError, isProviderError := provider.ParseError(err); isProviderError {
+		respondError(w, providerError.StatusCode(), providerError.Message())
+		sn.Error = &snapshot.Error{
+			Message: providerError.Message(),
+			Type:    providerError.Type(),
+			Source:  providerError.Source(),
+		}
+		sn.StatusCode = providerError.StatusCode()
+		return
+	}
+	respondError(w, http.StatusInternalServerError, err.Error())
+	sn.Error = &snapshot.Error{Message: err.Error()}
+	sn.StatusCode = http.StatusInternalServerError
+}
+
 func respondError(w http.ResponseWriter, status int, message string) {
 	getSecsToNextMinute := func() int {
 		now := time.Now()
@@ -733,28 +1633,15 @@ func respondError(w http.ResponseWriter, status int, message string) {
 		w.Header().Set("X-Retry-After", strconv.Itoa(secs))
 		w.Header().Set("X-Should-Retry", "true")
 	}
-	var errorType string
 	switch status {
-	case http.StatusBadRequest:
-		errorType = anthropic.InvalidRequestError
-	case http.StatusUnauthorized:
-		errorType = anthropic.AuthenticationError
-	case http.StatusForbidden:
-		errorType = anthropic.PermissionError
-	case http.StatusNotFound:
-		errorType = anthropic.NotFoundError
-	case http.StatusRequestEntityTooLarge:
-		errorType = anthropic.RequestTooLarge
 	case http.StatusTooManyRequests:
 		setRetryHeaders(getSecsToNextMinute())
-		errorType = anthropic.RateLimitError
 	case http.StatusInternalServerError:
 		setRetryHeaders(1)
-		errorType = anthropic.APIError
 	case 529:
 		setRetryHeaders(10)
-		errorType = anthropic.OverloadedError
 	}
+	errorType := anthropic.ErrorTypeForStatus(status)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(&anthropic.Error{
@@ -764,14 +1651,18 @@ func respondError(w http.ResponseWriter, status int, message string) {
 			Message: message,
 		},
 	}); err != nil {
-		slog.Warn(fmt.Sprintf("[%s] error sending error response: %s", w.Header().Get("X-Cc-Request-Id"), err.Error()))
+		slog.Warn(fmt.Sprintf("error sending error response: %s", err.Error()), "request_id", w.Header().Get("X-Cc-Request-Id"))
 	}
 }
 
-func makeSnapshotRecorder(ctx context.Context, cfg string) (snapshot.Recorder, error) {
+func makeSnapshotRecorder(ctx context.Context, cfg string, redact *snapshot.RedactConfig) (snapshot.Recorder, error) {
 	if cfg == "" {
 		return snapshot.NopRecorder(), nil
 	}
+	redactor, err := snapshot.NewRedactor(redact)
+	if err != nil {
+		return nil, err
+	}
 	u, err := url.Parse(cfg)
 	if err != nil {
 		return nil, err
@@ -784,16 +1675,79 @@ func makeSnapshotRecorder(ctx context.Context, cfg string) (snapshot.Recorder, e
 		} else {
 			path = u.Path
 		}
+		options := []jsonl.RecorderOption{jsonl.WithRedactor(redactor)}
+		if query := u.Query(); query.Has("max_size") {
+			rotation, err := parseSnapshotRotation(path, query)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, jsonl.WithRotation(*rotation))
+		}
 		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			return nil, err
 		}
-		return jsonl.NewRecorder(ctx, file), nil
+		return jsonl.NewRecorder(ctx, file, options...), nil
 	default:
 		return nil, fmt.Errorf("unsupported snapshot recorder type %q", u.Scheme)
 	}
 }
 
+// parseSnapshotRotation reads the rotation settings of a "jsonl:<file>?max_size=..." snapshot
+// config: max_size accepts a plain byte count or a KB/MB/GB suffix, max_files defaults to 5
+// and compress gzips rotated files.
+func parseSnapshotRotation(path string, query url.Values) (*jsonl.Rotation, error) {
+	rotation := &jsonl.Rotation{Path: path, MaxFiles: 5}
+	maxSize, err := parseByteSize(query.Get("max_size"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid max_size %q: %w", query.Get("max_size"), err)
+	}
+	rotation.MaxSize = maxSize
+	if query.Has("max_files") {
+		maxFiles, err := strconv.Atoi(query.Get("max_files"))
+		if err != nil || maxFiles < 0 {
+			return nil, fmt.Errorf("invalid max_files %q", query.Get("max_files"))
+		}
+		rotation.MaxFiles = maxFiles
+	}
+	if query.Has("compress") {
+		compress, err := strconv.ParseBool(query.Get("compress"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid compress %q", query.Get("compress"))
+		}
+		rotation.Compress = compress
+	}
+	return rotation, nil
+}
+
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("size must not be negative")
+	}
+	return n * multiplier, nil
+}
+
 func onCountTokens(pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		removeForwardedHeaders(r.Header)
@@ -804,12 +1758,10 @@ func onCountTokens(pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.Re
 			)
 			return
 		}
-		rawBody, err := io.ReadAll(r.Body)
+		rawBody, err := readRequestBody(w, r)
 		if err != nil {
-			respondError(w,
-				http.StatusInternalServerError,
-				fmt.Sprintf("Failed to read request body: %s", err.Error()),
-			)
+			status, message := requestBodyError(err)
+			respondError(w, status, message)
 			return
 		}
 		model := gjson.GetBytes(rawBody, "model").String()
@@ -819,9 +1771,30 @@ func onCountTokens(pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.Re
 		}
 		prof, err := pmPtr.Load().Match(model)
 		if err != nil {
-			respondError(w, http.StatusBadRequest, fmt.Sprintf("No profile configured for model %q", model))
+			status, message := profileMatchError(model, err)
+			respondError(w, status, message)
 			return
 		}
+		if prof.Options.GetLocalCountTokens() {
+			var countTokensRequest anthropic.CountTokensRequest
+			if err = json.Unmarshal(rawBody, &countTokensRequest); err != nil {
+				respondError(w, http.StatusBadRequest, fmt.Sprintf("The request body is not valid JSON: %s", err.Error()))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if err = json.NewEncoder(w).Encode(&anthropic.CountTokensResponse{
+				InputTokens: adapter.EstimateInputTokens(&countTokensRequest),
+			}); err != nil {
+				slog.Warn(fmt.Sprintf("error sending count_tokens response: %s", err.Error()))
+			}
+			return
+		}
+		if countModel := countTokensModel(model, prof); countModel != model {
+			if rawBody, err = sjson.SetBytes(rawBody, "model", countModel); err != nil {
+				panic(fmt.Errorf("unreachable: %s", err.Error()))
+			}
+		}
 		countTokensBackend := prof.Anthropic.GetCountTokensBackend()
 		backendURL, err := url.Parse(countTokensBackend)
 		if err != nil {
@@ -835,11 +1808,260 @@ func onCountTokens(pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.Re
 		r.Header.Set("Host", backendURL.Host)
 		r.Header.Set("Content-Length", strconv.Itoa(len(rawBody)))
 		r.Header.Set(anthropic.HeaderAPIKey, prof.Anthropic.GetAPIKey())
+		provider.WithStaticHeaders(prof.Anthropic.GetHeaders())(r)
 		proxy := httputil.NewSingleHostReverseProxy(backendURL)
 		proxy.ServeHTTP(w, r)
 	}
 }
 
+func onModels(pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+			return
+		}
+		resp := &anthropic.ListModelsResponse{Data: make([]*anthropic.ModelInfo, 0)}
+		for _, id := range listProfileModels(pmPtr.Load()) {
+			resp.Data = append(resp.Data, &anthropic.ModelInfo{
+				Type:        "model",
+				ID:          id,
+				DisplayName: id,
+			})
+		}
+		if n := len(resp.Data); n > 0 {
+			resp.FirstID = lo.ToPtr(resp.Data[0].ID)
+			resp.LastID = lo.ToPtr(resp.Data[n-1].ID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			slog.Warn(fmt.Sprintf("error sending models response: %s", err.Error()))
+		}
+	}
+}
+
+// listProfileModels collects the model names a client can request, in profile order.
+// Exact patterns and the keys of each profile's options.models mapping are listed;
+// wildcard patterns such as "*" or "claude-*" name no concrete model and are skipped,
+// so a catch-all profile only contributes its mapped models.
+func listProfileModels(pm *profile.ProfileManager) []string {
+	if pm == nil {
+		return nil
+	}
+	var (
+		models []string
+		seen   = make(map[string]struct{})
+	)
+	add := func(model string) {
+		if model == "" || strings.Contains(model, "*") {
+			return
+		}
+		if _, ok := seen[model]; ok {
+			return
+		}
+		seen[model] = struct{}{}
+		models = append(models, model)
+	}
+	for _, p := range pm.Profiles() {
+		for _, pattern := range p.Models {
+			add(pattern)
+		}
+		mapped := lo.Keys(p.Options.GetModels())
+		slices.Sort(mapped)
+		for _, model := range mapped {
+			add(model)
+		}
+	}
+	return models
+}
+
+// preprocessRequest applies the profile's provider-independent rewrites to req: disallowed tools
+// are removed (adjusting tool_choice accordingly), empty tool_result texts are replaced when
+// prevent_empty_text_tool_result is enabled, and the forced sampling parameters replace the
+// client's ones. It returns the names of the removed tools.
+func preprocessRequest(req *anthropic.GenerateMessageRequest, prof *profile.Profile) (removed []string) {
+	if len(req.Tools) > 0 {
+		// Build a disallowed tool name set from profile options
+		disallowedSet := map[string]struct{}{}
+		for _, name := range prof.Options.GetDisallowedTools() {
+			if name == "" {
+				continue
+			}
+			disallowedSet[name] = struct{}{}
+		}
+		if len(disallowedSet) > 0 {
+			filtered := make([]*anthropic.Tool, 0, len(req.Tools))
+			for _, t := range req.Tools {
+				if _, blocked := disallowedSet[t.Name]; blocked {
+					removed = append(removed, t.Name)
+					continue
+				}
+				filtered = append(filtered, t)
+			}
+			req.Tools = filtered
+			// Normalize tool_choice if necessary
+			if len(req.Tools) == 0 {
+				if req.ToolChoice == nil {
+					req.ToolChoice = &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeNone}
+				} else {
+					req.ToolChoice.Type = anthropic.ToolChoiceTypeNone
+					req.ToolChoice.Name = ""
+				}
+			} else if req.ToolChoice != nil && req.ToolChoice.Type == anthropic.ToolChoiceTypeTool {
+				// Ensure selected tool still exists after filtering
+				remaining := map[string]struct{}{}
+				for _, t := range req.Tools {
+					remaining[t.Name] = struct{}{}
+				}
+				if _, ok := remaining[req.ToolChoice.Name]; !ok {
+					req.ToolChoice.Type = anthropic.ToolChoiceTypeNone
+					req.ToolChoice.Name = ""
+				}
+			}
+		}
+	}
+	if prof.Options.GetPreventEmptyTextToolResult() {
+		// No idea why Claude Code send empty text in tool_result, so we replace it with a hint message if necessary.
+		for _, message := range req.Messages {
+			if message != nil {
+				for _, content := range message.Content {
+					if content != nil && content.Type == anthropic.MessageContentTypeToolResult {
+						for _, part := range content.Content {
+							if part != nil && part.Type == anthropic.MessageContentTypeText && part.Text == "" {
+								part.Text = "(No content)"
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if temperature := prof.Options.GetForceTemperature(); temperature != nil {
+		req.Temperature = *temperature
+	}
+	if topP := prof.Options.GetForceTopP(); topP != nil {
+		req.TopP = lo.ToPtr(*topP)
+	}
+	if prof.Options.GetAutoCacheBreakpoints() {
+		adapter.AddCacheBreakpoints(req)
+	}
+	if prof.Options.GetDisableThinking() {
+		req.Thinking = nil
+	}
+	return removed
+}
+
+// isAnthropicServerTool reports whether tool runs on Anthropic's servers, so that a request using it
+// has to be sent to Anthropic. OpenRouter profiles with openrouter.web_search serve the web search
+// tool themselves, with OpenRouter's web plugin.
+func isAnthropicServerTool(prof *profile.Profile, tool *anthropic.Tool) bool {
+	if tool.Type == nil || *tool.Type == anthropic.ToolTypeCustom {
+		return false
+	}
+	if *tool.Type == anthropic.ToolTypeWebSearch2025 && prof.OpenRouter.GetWebSearch() &&
+		(prof.Provider == ProviderOpenRouter || prof.Provider == "") {
+		return false
+	}
+	return true
+}
+
+// onDebugTranslate returns the upstream request that /v1/messages would send for an Anthropic request,
+// without calling the upstream. The profile is chosen by the "profile" query parameter, or by model matching.
+func onDebugTranslate(pmPtr *atomic.Pointer[profile.ProfileManager]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				respondError(w, http.StatusInternalServerError, fmt.Sprintf("An error occured while translating your request: %v", err))
+			}
+		}()
+		if r.Method != http.MethodPost {
+			respondError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+			return
+		}
+		if !utils.IsContentType(r.Header, "application/json") {
+			respondError(w,
+				http.StatusBadRequest,
+				fmt.Sprintf("Invalid Content-Type %q", r.Header.Get("Content-Type")),
+			)
+			return
+		}
+		rawBody, err := readRequestBody(w, r)
+		if err != nil {
+			status, message := requestBodyError(err)
+			respondError(w, status, message)
+			return
+		}
+		var req *anthropic.GenerateMessageRequest
+		if err = json.Unmarshal(rawBody, &req); err != nil || req == nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("The request body is not a valid message request: %v", err))
+			return
+		}
+		var prof *profile.Profile
+		if name := r.URL.Query().Get("profile"); name != "" {
+			var ok bool
+			if prof, ok = pmPtr.Load().Get(name); !ok {
+				respondError(w, http.StatusBadRequest, fmt.Sprintf("No profile named %q", name))
+				return
+			}
+		} else if prof, err = pmPtr.Load().Match(req.Model); err != nil {
+			status, message := profileMatchError(req.Model, err)
+			respondError(w, status, message)
+			return
+		}
+		if err = adapter.ValidateContentPartSizes(req, prof.Options.GetMaxContentPartBytes()); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		preprocessRequest(req, prof)
+		hasServerTools := lo.ContainsBy(req.Tools, func(tool *anthropic.Tool) bool {
+			return isAnthropicServerTool(prof, tool)
+		})
+		w.Header().Set("X-Profile", prof.Name)
+		switch {
+		case hasServerTools || prof.Provider == ProviderAnthropic || prof.Provider == ProviderBedrock:
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Profile %q forwards this request in Anthropic format, there is nothing to translate", prof.Name))
+		case prof.Provider == ProviderOpenAI:
+			w.Header().Set("X-Provider", ProviderOpenAI)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(adapter.ConvertAnthropicRequestToOpenAIRequest(profile.WithProfile(r.Context(), prof), req))
+		case prof.Provider == ProviderGemini:
+			w.Header().Set("X-Provider", ProviderGemini)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(gemini.ConvertRequest(req))
+		default:
+			openrouterRequest, err := adapter.ConvertAnthropicRequestToOpenRouterRequest(profile.WithProfile(r.Context(), prof), req)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			w.Header().Set("X-Provider", ProviderOpenRouter)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(openrouterRequest)
+		}
+	}
+}
+
+// newInstanceID returns a random ID that tells the request IDs of different adapter processes apart.
+func newInstanceID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// maxRequestIDLength bounds the length of client-supplied X-Request-Id values forwarded upstream.
+const maxRequestIDLength = 128
+
+// upstreamRequestID returns the X-Request-Id supplied by the client, or one derived from the adapter
+// request ID otherwise, which is forwarded upstream so that provider logs can be correlated with ours.
+func upstreamRequestID(header http.Header, instanceID string, requestID int64) string {
+	if id := strings.TrimSpace(header.Get(provider.HeaderRequestID)); id != "" && len(id) <= maxRequestIDLength {
+		if !strings.ContainsFunc(id, func(r rune) bool { return r < '!' || r > '~' }) {
+			return id
+		}
+	}
+	return fmt.Sprintf("cc-%s-%d", instanceID, requestID)
+}
+
 func removeForwardedHeaders(header http.Header) {
 	header.Del("Forwarded")
 	header.Del("X-Forwarded-For")
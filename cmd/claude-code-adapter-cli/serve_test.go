@@ -3,14 +3,32 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/samber/lo"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/adapter"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/provider"
 	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
 )
 
 func TestRespondError(t *testing.T) {
@@ -111,9 +129,89 @@ func TestRespondError(t *testing.T) {
 	}
 }
 
+func TestLoadProfiles_RejectsInvalidReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(config string) *viper.Viper {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		v, err := readConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	if err := loadProfiles(writeConfig(`
+profiles:
+  claude:
+    models: ["claude-*"]
+    provider: openrouter
+    openrouter:
+      api_key: sk-or-test
+`), &pmPtr); err != nil {
+		t.Fatalf("initial load: %v", err)
+	}
+	running := pmPtr.Load()
+
+	for _, tt := range []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"overlapping patterns", `
+profiles:
+  all:
+    models: ["*"]
+    provider: openrouter
+    openrouter:
+      api_key: sk-or-test
+  claude:
+    models: ["claude-*"]
+    provider: openrouter
+    openrouter:
+      api_key: sk-or-test
+`, `profile "claude": models: pattern "claude-*" never matches`},
+		{"missing provider config", `
+profiles:
+  claude:
+    models: ["claude-*"]
+    provider: gemini
+`, `profile "claude": gemini.api_key: required`},
+		{"no profiles", `http: {}`, profile.ErrNoProfilesDefined.Error()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadProfiles(writeConfig(tt.config), &pmPtr)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.want)
+			}
+			if pmPtr.Load() != running {
+				t.Fatal("an invalid reload replaced the running profiles")
+			}
+			if p, err := pmPtr.Load().Match("claude-sonnet-4"); err != nil || p.Name != "claude" || p.Provider != ProviderOpenRouter {
+				t.Errorf("Match() = %+v, %v, want the running openrouter profile", p, err)
+			}
+		})
+	}
+}
+
+func TestLoadProfiles_InitialLoadWithProblems(t *testing.T) {
+	v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+	v.Set(delimiter.ViperKey("profiles", "claude", "models"), []string{"claude-*"})
+	v.Set(delimiter.ViperKey("profiles", "claude", "provider"), ProviderGemini)
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	if err := loadProfiles(v, &pmPtr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pmPtr.Load() == nil {
+		t.Fatal("profiles were not stored")
+	}
+}
+
 func TestMakeSnapshotRecorder(t *testing.T) {
 	t.Run("empty config", func(t *testing.T) {
-		recorder, err := makeSnapshotRecorder(context.Background(), "")
+		recorder, err := makeSnapshotRecorder(context.Background(), "", nil)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -132,7 +230,7 @@ func TestMakeSnapshotRecorder(t *testing.T) {
 		path := filepath.Join(tmpDir, "test.jsonl")
 		cfg := "jsonl:" + path
 
-		recorder, err := makeSnapshotRecorder(context.Background(), cfg)
+		recorder, err := makeSnapshotRecorder(context.Background(), cfg, nil)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -144,8 +242,36 @@ func TestMakeSnapshotRecorder(t *testing.T) {
 		}
 	})
 
+	t.Run("jsonl config with rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.jsonl")
+		recorder, err := makeSnapshotRecorder(context.Background(), "jsonl:"+path+"?max_size=1b&max_files=2", nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := recorder.Record(&snapshot.Snapshot{Version: "v1"}); err != nil {
+				t.Fatalf("Record failed: %v", err)
+			}
+		}
+		recorder.Close()
+		for _, name := range []string{path, path + ".1", path + ".2"} {
+			if _, err := os.Stat(name); err != nil {
+				t.Errorf("expected %s to exist: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("invalid rotation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.jsonl")
+		for _, query := range []string{"max_size=big", "max_size=1MB&max_files=-1", "max_size=1MB&compress=maybe"} {
+			if _, err := makeSnapshotRecorder(context.Background(), "jsonl:"+path+"?"+query, nil); err == nil {
+				t.Errorf("expected an error for %q", query)
+			}
+		}
+	})
+
 	t.Run("invalid scheme", func(t *testing.T) {
-		_, err := makeSnapshotRecorder(context.Background(), "invalid:config")
+		_, err := makeSnapshotRecorder(context.Background(), "invalid:config", nil)
 		if err == nil {
 			t.Fatal("Expected error for invalid scheme, got nil")
 		}
@@ -155,3 +281,1882 @@ func TestMakeSnapshotRecorder(t *testing.T) {
 		}
 	})
 }
+
+func TestOnModels(t *testing.T) {
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:     "anthropic",
+		Models:   []string{"claude-sonnet-4-20250514", "claude-opus-*"},
+		Provider: ProviderAnthropic,
+	})
+	pm.AddProfile(&profile.Profile{
+		Name:     "default",
+		Models:   []string{"*"},
+		Provider: ProviderOpenRouter,
+		Options: &profile.OptionsConfig{
+			Models: map[string]string{
+				"gpt-5":                    "openai/gpt-5",
+				"claude-sonnet-4-20250514": "anthropic/claude-sonnet-4",
+			},
+		},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+
+	rr := httptest.NewRecorder()
+	onModels(&pmPtr)(rr, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rr.Code)
+	}
+	var resp anthropic.ListModelsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	var ids []string
+	for _, m := range resp.Data {
+		if m.Type != "model" {
+			t.Errorf("model %q has type %q, want model", m.ID, m.Type)
+		}
+		ids = append(ids, m.ID)
+	}
+	want := []string{"claude-sonnet-4-20250514", "gpt-5"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	if resp.HasMore || resp.FirstID == nil || *resp.FirstID != want[0] || resp.LastID == nil || *resp.LastID != want[1] {
+		t.Errorf("unexpected paging fields: %+v", resp)
+	}
+
+	rr = httptest.NewRecorder()
+	onModels(&pmPtr)(rr, httptest.NewRequest(http.MethodPost, "/v1/models", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("POST status = %d, want 404", rr.Code)
+	}
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	t.Run("zero means no timeout", func(t *testing.T) {
+		ctx, timer, cancel := withRequestTimeout(context.Background(), 0)
+		defer cancel()
+		timer.Reset() // nil timer must be safe to reset
+		if ctx.Done() != nil {
+			t.Error("context without timeout should never be done")
+		}
+	})
+	t.Run("fires when idle", func(t *testing.T) {
+		ctx, _, cancel := withRequestTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("context was not cancelled after the timeout")
+		}
+		if !isRequestTimeout(ctx) {
+			t.Errorf("cause = %v, want errRequestTimeout", context.Cause(ctx))
+		}
+	})
+	t.Run("reset extends deadline", func(t *testing.T) {
+		ctx, timer, cancel := withRequestTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		for i := 0; i < 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			timer.Reset()
+		}
+		if ctx.Err() != nil {
+			t.Fatal("context should stay alive while the timer is being reset")
+		}
+	})
+	t.Run("cancel is not a timeout", func(t *testing.T) {
+		ctx, _, cancel := withRequestTimeout(context.Background(), time.Minute)
+		cancel()
+		if ctx.Err() == nil {
+			t.Fatal("context should be cancelled")
+		}
+		if isRequestTimeout(ctx) {
+			t.Error("explicit cancel should not be reported as a timeout")
+		}
+	})
+}
+
+func TestKeepAlive(t *testing.T) {
+	t.Run("zero interval is disabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		k := startKeepAlive(rec, 0)
+		if k != nil {
+			t.Fatal("expected nil keepAlive for zero interval")
+		}
+		k.Stop() // nil keepAlive must be safe to stop
+	})
+	t.Run("pings until stopped", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		k := startKeepAlive(rec, 10*time.Millisecond)
+		time.Sleep(55 * time.Millisecond)
+		k.Stop()
+		k.Stop() // stopping twice must not panic
+		body := rec.Body.String()
+		pings := strings.Count(body, "event: ping\ndata: {\"type\":\"ping\"}\n\n")
+		if pings == 0 || pings*len("event: ping\ndata: {\"type\":\"ping\"}\n\n") != len(body) {
+			t.Fatalf("unexpected keepalive output: %q", body)
+		}
+		time.Sleep(30 * time.Millisecond)
+		if rec.Body.Len() != len(body) {
+			t.Error("keepAlive wrote after Stop returned")
+		}
+	})
+}
+
+// flushRecorder is a ResponseRecorder that records the body length at every flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushes []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushes = append(r.flushes, r.Body.Len())
+	r.ResponseRecorder.Flush()
+}
+
+func (r *flushRecorder) Flushes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.flushes)
+}
+
+func TestSSEWriter(t *testing.T) {
+	delta := &anthropic.EventContentBlockDelta{
+		Type:  anthropic.EventTypeContentBlockDelta,
+		Delta: &anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeTextDelta, Text: "hi"},
+	}
+	stop := &anthropic.EventMessageStop{Type: anthropic.EventTypeMessageStop}
+
+	t.Run("flushes every event without an interval", func(t *testing.T) {
+		rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		sse := newSSEWriter(rec, 0)
+		for i := 0; i < 3; i++ {
+			sse.WriteEvent(delta)
+		}
+		if flushes := rec.Flushes(); len(flushes) != 3 || flushes[2] != rec.Body.Len() {
+			t.Errorf("flushes = %v, want one after each of the 3 events", flushes)
+		}
+	})
+
+	t.Run("batches deltas within the interval", func(t *testing.T) {
+		rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		sse := newSSEWriter(rec, 50*time.Millisecond)
+		sse.WriteEvent(stop) // flushed right away, starting the interval
+		for i := 0; i < 5; i++ {
+			sse.WriteEvent(delta)
+		}
+		if flushes := rec.Flushes(); len(flushes) != 1 {
+			t.Fatalf("flushes = %v, want the deltas to be batched", flushes)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if flushes := rec.Flushes(); len(flushes) != 2 || flushes[1] != rec.Body.Len() {
+			t.Fatalf("flushes = %v, want the timer to flush the batched deltas", flushes)
+		}
+		sse.WriteEvent(delta)
+		sse.WriteEvent(delta)
+		sse.WriteEvent(stop)
+		if flushes := rec.Flushes(); len(flushes) != 4 || flushes[3] != rec.Body.Len() {
+			t.Fatalf("flushes = %v, want message_stop to be flushed with the pending delta", flushes)
+		}
+		sse.Close()
+		sse.Close()
+	})
+
+	t.Run("close flushes pending writes", func(t *testing.T) {
+		rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		sse := newSSEWriter(rec, time.Hour)
+		sse.WriteEvent(stop)
+		sse.WriteEvent(delta)
+		writeStreamError(sse, &snapshot.Error{Type: anthropic.APIError, Message: "boom"})
+		sse.Close()
+		flushes := rec.Flushes()
+		if len(flushes) != 2 || flushes[1] != rec.Body.Len() || !strings.Contains(rec.Body.String(), "boom") {
+			t.Errorf("flushes = %v of %q, want Close to flush everything", flushes, rec.Body.String())
+		}
+	})
+}
+
+func TestOnMessages_FlushesStreamEvents(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"hello"}}]}`+"\n\n")
+		io.WriteString(w, `data: {"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"content":" world"},"finish_reason":"stop"}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 1), nil, &pmPtr)(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	events := strings.Count(rec.Body.String(), "event: ")
+	flushes := rec.Flushes()
+	if events == 0 || len(flushes) < events || flushes[len(flushes)-1] != rec.Body.Len() {
+		t.Errorf("%d flushes %v for %d events, want every event flushed", len(flushes), flushes, events)
+	}
+}
+
+func TestOnDebugTranslate(t *testing.T) {
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:     "anthropic",
+		Models:   []string{"claude-*"},
+		Provider: ProviderAnthropic,
+	})
+	pm.AddProfile(&profile.Profile{
+		Name:     "openai",
+		Models:   []string{"gpt-*"},
+		Provider: ProviderOpenAI,
+	})
+	pm.AddProfile(&profile.Profile{
+		Name:     "default",
+		Models:   []string{"*"},
+		Provider: ProviderOpenRouter,
+		Options: &profile.OptionsConfig{
+			Models:          map[string]string{"claude-sonnet-4-20250514": "anthropic/claude-sonnet-4"},
+			DisallowedTools: []string{"WebFetch"},
+		},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	body := `{
+		"model": "claude-sonnet-4-20250514",
+		"max_tokens": 1024,
+		"messages": [{"role": "user", "content": "hello"}],
+		"tools": [
+			{"name": "WebFetch", "input_schema": {"type": "object"}},
+			{"name": "Read", "input_schema": {"type": "object"}}
+		]
+	}`
+	translate := func(target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		onDebugTranslate(&pmPtr)(rr, req)
+		return rr
+	}
+
+	t.Run("explicit profile", func(t *testing.T) {
+		rr := translate("/v1/debug/translate?profile=default", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("X-Profile"); got != "default" {
+			t.Errorf("X-Profile = %q, want default", got)
+		}
+		var resp struct {
+			Model    string `json:"model"`
+			Messages []any  `json:"messages"`
+			Tools    []struct {
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			} `json:"tools"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if resp.Model != "anthropic/claude-sonnet-4" {
+			t.Errorf("model = %q, want mapped model", resp.Model)
+		}
+		if len(resp.Messages) != 1 {
+			t.Errorf("messages = %d, want 1", len(resp.Messages))
+		}
+		if len(resp.Tools) != 1 || resp.Tools[0].Function.Name != "Read" {
+			t.Errorf("tools = %+v, want only Read after disallowed tool removal", resp.Tools)
+		}
+	})
+	t.Run("openai profile", func(t *testing.T) {
+		rr := translate("/v1/debug/translate?profile=openai", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("X-Provider"); got != ProviderOpenAI {
+			t.Errorf("X-Provider = %q, want %q", got, ProviderOpenAI)
+		}
+		var resp struct {
+			Input []any `json:"input"`
+			Tools []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if len(resp.Input) != 1 || len(resp.Tools) != 2 {
+			t.Errorf("input = %d items, tools = %+v", len(resp.Input), resp.Tools)
+		}
+	})
+	t.Run("matched anthropic profile has nothing to translate", func(t *testing.T) {
+		rr := translate("/v1/debug/translate", body)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rr.Code)
+		}
+	})
+	t.Run("unknown profile", func(t *testing.T) {
+		if rr := translate("/v1/debug/translate?profile=missing", body); rr.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rr.Code)
+		}
+	})
+	t.Run("invalid body", func(t *testing.T) {
+		if rr := translate("/v1/debug/translate", "{"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rr.Code)
+		}
+	})
+	t.Run("method not allowed", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		onDebugTranslate(&pmPtr)(rr, httptest.NewRequest(http.MethodGet, "/v1/debug/translate", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404", rr.Code)
+		}
+	})
+}
+
+type chanRecorder chan *snapshot.Snapshot
+
+func (chanRecorder) Close() error { return nil }
+
+func (c chanRecorder) Record(sn *snapshot.Snapshot) error {
+	c <- sn
+	return nil
+}
+
+func TestOnMessages_ServiceTier(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"openai/gpt-5","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`+"\n\n")
+		io.WriteString(w, `data: {"id":"gen-1","model":"openai/gpt-5","service_tier":"flex","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	rec := make(chanRecorder, 2)
+	handler := onMessages(serveCmd, provider.NewProvider(), rec, nil, &pmPtr)
+	for _, stream := range []bool{false, true} {
+		body := `{"model":"gpt-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`
+		if stream {
+			body = `{"model":"gpt-5","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+		}
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		resp := w.Result()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("stream=%v: status = %d: %s", stream, resp.StatusCode, w.Body.String())
+		}
+		if stream {
+			if got := resp.Trailer.Get("X-Service-Tier"); got != "flex" {
+				t.Errorf("X-Service-Tier trailer = %q, want flex", got)
+			}
+		} else if got := resp.Header.Get("X-Service-Tier"); got != "flex" {
+			t.Errorf("X-Service-Tier header = %q, want flex", got)
+		}
+		select {
+		case sn := <-rec:
+			if sn.ServiceTier != "flex" || sn.OpenRouterResponse == nil || sn.OpenRouterResponse.ServiceTier != "flex" {
+				t.Errorf("stream=%v: snapshot service tier = %q, openrouter_response = %+v", stream, sn.ServiceTier, sn.OpenRouterResponse)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no snapshot recorded")
+		}
+	}
+}
+
+func TestOnMessages_OpenRouterWebSearch(t *testing.T) {
+	var gotBody []byte
+	openRouterBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"openai/gpt-5","choices":[{"index":0,"delta":{"role":"assistant","content":"Go 1.24 was released in February 2025."}}]}`+"\n\n")
+		io.WriteString(w, `data: {"id":"gen-1","model":"openai/gpt-5","choices":[{"index":0,"delta":{"annotations":[{"type":"url_citation","url_citation":{"url":"https://go.dev/blog/go1.24","title":"Go 1.24 is released!","start_index":0,"end_index":39}}]},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":8}}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer openRouterBackend.Close()
+	anthropicBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request with the web search tool was sent to Anthropic")
+	}))
+	defer anthropicBackend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		Anthropic:  &profile.AnthropicConfig{BaseURL: anthropicBackend.URL, APIKey: "sk-ant-test"},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: openRouterBackend.URL, APIKey: "sk-or-test", WebSearch: true},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 1), nil, &pmPtr)
+	body := `{"model":"openai/gpt-5","max_tokens":1024,"tools":[{"type":"web_search_20250305","name":"web_search","max_uses":5}],` +
+		`"messages":[{"role":"user","content":"When was Go 1.24 released?"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if got := gjson.GetBytes(gotBody, "plugins.0.id").String(); got != "web" {
+		t.Errorf("upstream plugins = %s", gjson.GetBytes(gotBody, "plugins"))
+	}
+	content := gjson.Get(w.Body.String(), "content")
+	if got := content.Get("#.type").String(); got != `["text","server_tool_use","web_search_tool_result","text"]` {
+		t.Errorf("content types = %s", got)
+	}
+	if got := content.Get("2.content.0.url").String(); got != "https://go.dev/blog/go1.24" {
+		t.Errorf("web search result = %s", content.Get("2"))
+	}
+}
+
+func TestOnMessages_TraceContext(t *testing.T) {
+	var gotTraceParents, gotTraceStates []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		gotTraceParents = append(gotTraceParents, r.Header.Get(provider.HeaderTraceParent))
+		gotTraceStates = append(gotTraceStates, r.Header.Get(provider.HeaderTraceState))
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"openai/gpt-5","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 2), nil, &pmPtr)
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	send := func() {
+		t.Helper()
+		body := `{"model":"gpt-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(provider.HeaderTraceParent, traceParent)
+		r.Header.Set(provider.HeaderTraceState, "vendor=value")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	send()
+	if len(gotTraceParents) != 1 || gotTraceParents[0] != "" {
+		t.Errorf("traceparent = %q, want none without otel.enabled", gotTraceParents)
+	}
+
+	viper.Set(delimiter.ViperKey("otel", "enabled"), true)
+	defer viper.Set(delimiter.ViperKey("otel", "enabled"), false)
+	send()
+	if len(gotTraceParents) != 2 || gotTraceParents[1] != traceParent || gotTraceStates[1] != "vendor=value" {
+		t.Errorf("traceparent = %q, tracestate = %q, want the client's trace context", gotTraceParents, gotTraceStates)
+	}
+}
+
+func TestOnMessages_ResolvedHeaders(t *testing.T) {
+	openRouterBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","provider":"Anthropic","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"hi"}}]}`+"\n\n")
+		io.WriteString(w, `data: {"id":"gen-1","provider":"Anthropic","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":5,"completion_tokens":1}}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer openRouterBackend.Close()
+	anthropicBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-20250514","usage":{"input_tokens":5,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`,
+			`{"type":"message_stop"}`,
+		} {
+			io.WriteString(w, "event: "+gjson.Get(event, "type").String()+"\ndata: "+event+"\n\n")
+		}
+	}))
+	defer anthropicBackend.Close()
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	for _, tc := range []struct {
+		name             string
+		profile          *profile.Profile
+		model            string
+		upstreamProvider string
+	}{
+		{
+			name: "openrouter",
+			profile: &profile.Profile{
+				Name:       "openrouter",
+				Models:     []string{"*"},
+				Provider:   ProviderOpenRouter,
+				Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+				OpenRouter: &profile.OpenRouterConfig{BaseURL: openRouterBackend.URL, APIKey: "sk-or-test"},
+			},
+			model:            "anthropic/claude-sonnet-4",
+			upstreamProvider: "Anthropic",
+		},
+		{
+			name: "anthropic",
+			profile: &profile.Profile{
+				Name:      "anthropic",
+				Models:    []string{"*"},
+				Provider:  ProviderAnthropic,
+				Options:   &profile.OptionsConfig{DisableCountTokensRequest: true},
+				Anthropic: &profile.AnthropicConfig{BaseURL: anthropicBackend.URL, APIKey: "sk-ant-test"},
+			},
+			model: "claude-sonnet-4-20250514",
+		},
+	} {
+		pm := profile.NewProfileManager()
+		pm.AddProfile(tc.profile)
+		var pmPtr atomic.Pointer[profile.ProfileManager]
+		pmPtr.Store(pm)
+		handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 2), nil, &pmPtr)
+		for _, stream := range []bool{false, true} {
+			body := `{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`
+			if stream {
+				body = `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+			}
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler(w, r)
+			resp := w.Result()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s stream=%v: status = %d: %s", tc.name, stream, resp.StatusCode, w.Body.String())
+			}
+			headers := resp.Header
+			if stream {
+				headers = resp.Trailer
+			}
+			if got := headers.Get("X-Resolved-Model"); got != tc.model {
+				t.Errorf("%s stream=%v: X-Resolved-Model = %q, want %q", tc.name, stream, got, tc.model)
+			}
+			if got := headers.Get("X-Upstream-Provider"); got != tc.upstreamProvider {
+				t.Errorf("%s stream=%v: X-Upstream-Provider = %q, want %q", tc.name, stream, got, tc.upstreamProvider)
+			}
+		}
+	}
+}
+
+func TestOnMessages_FallbackProfile(t *testing.T) {
+	var primaryCalls atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		primaryCalls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, `{"error":{"code":502,"message":"upstream is down"}}`)
+	}))
+	defer primary.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		prompt := gjson.GetBytes(body, "messages.0.content.0.text").String()
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"fallback `+prompt+`"},"finish_reason":"stop"}]}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer fallback.Close()
+	newHandler := func(fallbackOf map[string]string, urls map[string]string) http.HandlerFunc {
+		pm := profile.NewProfileManager()
+		for _, name := range []string{"primary", "secondary"} {
+			pm.AddProfile(&profile.Profile{
+				Name:       name,
+				Models:     []string{map[string]string{"primary": "*", "secondary": "secondary"}[name]},
+				Provider:   ProviderOpenRouter,
+				Options:    &profile.OptionsConfig{DisableCountTokensRequest: true, FallbackProfile: fallbackOf[name]},
+				OpenRouter: &profile.OpenRouterConfig{BaseURL: urls[name], APIKey: "sk-or-test"},
+			})
+		}
+		var pmPtr atomic.Pointer[profile.ProfileManager]
+		pmPtr.Store(pm)
+		var serveCmd *cobra.Command
+		for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+			if cmd.Name() == "serve" {
+				serveCmd = cmd
+			}
+		}
+		return onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 4), nil, &pmPtr)
+	}
+	post := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	t.Run("falls back on a 5xx error", func(t *testing.T) {
+		primaryCalls.Store(0)
+		handler := newHandler(
+			map[string]string{"primary": "secondary"},
+			map[string]string{"primary": primary.URL, "secondary": fallback.URL},
+		)
+		w := post(handler)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		if text := gjson.Get(w.Body.String(), "content.0.text").String(); text != "fallback hi" {
+			t.Errorf("response = %s, want the text of the fallback profile", w.Body.String())
+		}
+		if primaryCalls.Load() == 0 {
+			t.Error("primary profile was not called")
+		}
+	})
+
+	t.Run("stops at a cycle", func(t *testing.T) {
+		handler := newHandler(
+			map[string]string{"primary": "secondary", "secondary": "primary"},
+			map[string]string{"primary": primary.URL, "secondary": primary.URL},
+		)
+		w := post(handler)
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("status = %d: %s, want the error of the last profile", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestOnMessages_ProfileOverride(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			if r.Header.Get(HeaderProfileOverride) != "" {
+				t.Errorf("%s header forwarded upstream", HeaderProfileOverride)
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, `data: {"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"from `+name+`"},"finish_reason":"stop"}]}`+"\n\n")
+			io.WriteString(w, "data: [DONE]\n\n")
+		}))
+	}
+	matched, forced := newBackend("matched"), newBackend("forced")
+	defer matched.Close()
+	defer forced.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "matched",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: matched.URL, APIKey: "sk-or-test"},
+	})
+	pm.AddProfile(&profile.Profile{
+		Name:       "forced",
+		Models:     []string{"gpt-*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: forced.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 8), nil, &pmPtr)
+	post := func(override string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`))
+		r.Header.Set("Content-Type", "application/json")
+		if override != "" {
+			r.Header.Set(HeaderProfileOverride, override)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	for _, tt := range []struct {
+		name     string
+		setting  string
+		override string
+		want     string
+	}{
+		{"no override", "", "", "from matched"},
+		{"header", "", "forced", "from forced"},
+		{"setting", "forced", "", "from forced"},
+		{"header over setting", "forced", "matched", "from matched"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set(delimiter.ViperKey("profile"), tt.setting)
+			defer viper.Set(delimiter.ViperKey("profile"), "")
+			w := post(tt.override)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			if text := gjson.Get(w.Body.String(), "content.0.text").String(); text != tt.want {
+				t.Errorf("text = %q, want %q", text, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown profile", func(t *testing.T) {
+		w := post("missing")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `No profile named \"missing\"`) {
+			t.Errorf("status = %d: %s, want a 400 naming the profile", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestOnMessages_NoProfileMatchedSuggestions(t *testing.T) {
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "claude",
+		Models:     []string{"claude-sonnet-4"},
+		Provider:   ProviderOpenRouter,
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: "http://127.0.0.1:0", APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 1), nil, &pmPtr)
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if errType := gjson.Get(body, "error.type").String(); errType != anthropic.InvalidRequestError {
+		t.Errorf("error type = %q, want %s", errType, anthropic.InvalidRequestError)
+	}
+	if message := gjson.Get(body, "error.message").String(); !strings.Contains(message, `did you mean "claude-sonnet-4"?`) {
+		t.Errorf("error message = %q, want a suggestion", message)
+	}
+}
+
+func TestOnMessages_MaxBodyBytes(t *testing.T) {
+	maxBodyBytesKey := delimiter.ViperKey("http", "max_body_bytes")
+	viper.Set(maxBodyBytesKey, 64)
+	t.Cleanup(func() { viper.Set(maxBodyBytesKey, 0) })
+
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(profile.NewProfileManager())
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	rec := make(chanRecorder, 1)
+	handler := onMessages(serveCmd, nil, rec, nil, &pmPtr)
+
+	body := `{"model":"claude-sonnet-4","max_tokens":1,"messages":[{"role":"user","content":"` + strings.Repeat("x", 128) + `"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", w.Code, w.Body.String())
+	}
+	var resp anthropic.Error
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid error body: %v", err)
+	}
+	if resp.Inner == nil || resp.Inner.Type != anthropic.RequestTooLarge {
+		t.Errorf("unexpected error body: %s", w.Body.String())
+	}
+	select {
+	case sn := <-rec:
+		if sn.StatusCode != http.StatusRequestEntityTooLarge || sn.Error == nil {
+			t.Errorf("snapshot status = %d, error = %v", sn.StatusCode, sn.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("snapshot was not recorded")
+	}
+}
+
+func TestOnMessages_UnsupportedAnthropicVersion(t *testing.T) {
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:      "anthropic",
+		Models:    []string{"*"},
+		Provider:  ProviderAnthropic,
+		Anthropic: &profile.AnthropicConfig{Version: "2023-06-01"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	rec := make(chanRecorder, 1)
+	handler := onMessages(serveCmd, nil, rec, nil, &pmPtr)
+
+	body := `{"model":"claude-sonnet-4","max_tokens":1,"messages":[{"role":"user","content":"hi"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(anthropic.HeaderVersion, "2099-01-01")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", w.Code, w.Body.String())
+	}
+	if gjson.Get(w.Body.String(), "error.type").String() != anthropic.InvalidRequestError ||
+		!strings.Contains(gjson.Get(w.Body.String(), "error.message").String(), "2099-01-01") {
+		t.Errorf("unexpected error body: %s", w.Body.String())
+	}
+	select {
+	case sn := <-rec:
+		if sn.StatusCode != http.StatusBadRequest || sn.Error == nil {
+			t.Errorf("snapshot status = %d, error = %v", sn.StatusCode, sn.Error)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("snapshot was not recorded")
+	}
+}
+
+func TestOnMessages_UpstreamRequestID(t *testing.T) {
+	var gotRequestIDs []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRequestIDs = append(gotRequestIDs, r.Header.Get(provider.HeaderRequestID))
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"anthropic/claude-sonnet-4\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	rec := make(chanRecorder, 2)
+	handler := onMessages(serveCmd, provider.NewProvider(), rec, nil, &pmPtr)
+
+	send := func(requestID string) *snapshot.Snapshot {
+		t.Helper()
+		body := `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			r.Header.Set(provider.HeaderRequestID, requestID)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		select {
+		case sn := <-rec:
+			return sn
+		case <-time.After(time.Second):
+			t.Fatal("snapshot was not recorded")
+			return nil
+		}
+	}
+
+	sn := send("")
+	if len(gotRequestIDs) != 1 || !strings.HasPrefix(gotRequestIDs[0], "cc-") || !strings.HasSuffix(gotRequestIDs[0], "-"+sn.RequestID) {
+		t.Errorf("generated upstream request IDs = %q, want cc-<instance>-%s", gotRequestIDs, sn.RequestID)
+	}
+	if sn.UpstreamRequestID != gotRequestIDs[0] {
+		t.Errorf("snapshot upstream_request_id = %q, want %q", sn.UpstreamRequestID, gotRequestIDs[0])
+	}
+
+	sn = send("client-trace-42")
+	if len(gotRequestIDs) != 2 || gotRequestIDs[1] != "client-trace-42" {
+		t.Errorf("upstream request IDs = %q, want the client's X-Request-Id reused", gotRequestIDs)
+	}
+	if sn.UpstreamRequestID != "client-trace-42" {
+		t.Errorf("snapshot upstream_request_id = %q", sn.UpstreamRequestID)
+	}
+}
+
+func TestOnMessages_StreamErrorTypes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		chunk    string
+		wantType string
+	}{
+		{"rate limited", `{"error":{"code":429,"message":"Rate limit exceeded"}}`, anthropic.RateLimitError},
+		{"no provider available", `{"error":{"code":503,"message":"No available provider"}}`, anthropic.OverloadedError},
+		{"overloaded", `{"error":{"code":529,"message":"Overloaded"}}`, anthropic.OverloadedError},
+		{"bad request", `{"error":{"code":400,"message":"Invalid request"}}`, anthropic.InvalidRequestError},
+		{"provider failure", `{"error":{"code":502,"message":"Provider returned error"}}`, anthropic.APIError},
+		{"malformed chunk", `{"choices":`, anthropic.APIError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"anthropic/claude-sonnet-4\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\n")
+				io.WriteString(w, "data: "+tc.chunk+"\n\n")
+			}))
+			defer backend.Close()
+
+			pm := profile.NewProfileManager()
+			pm.AddProfile(&profile.Profile{
+				Name:       "openrouter",
+				Models:     []string{"*"},
+				Provider:   ProviderOpenRouter,
+				Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+				OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+			})
+			var pmPtr atomic.Pointer[profile.ProfileManager]
+			pmPtr.Store(pm)
+			var serveCmd *cobra.Command
+			for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+				if cmd.Name() == "serve" {
+					serveCmd = cmd
+				}
+			}
+			rec := make(chanRecorder, 1)
+			handler := onMessages(serveCmd, provider.NewProvider(), rec, nil, &pmPtr)
+
+			body := `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			var errorEvent string
+			for line := range strings.SplitSeq(w.Body.String(), "\n") {
+				if data, found := strings.CutPrefix(line, "data: "); found && gjson.Get(data, "type").String() == "error" {
+					errorEvent = data
+				}
+			}
+			if errorEvent == "" {
+				t.Fatalf("no error event in stream:\n%s", w.Body.String())
+			}
+			if got := gjson.Get(errorEvent, "error.type").String(); got != tc.wantType {
+				t.Errorf("error type = %q, want %q: %s", got, tc.wantType, errorEvent)
+			}
+			if gjson.Get(errorEvent, "error.message").String() == "" {
+				t.Errorf("error event has no message: %s", errorEvent)
+			}
+			select {
+			case sn := <-rec:
+				if sn.Error == nil || sn.Error.Type != tc.wantType {
+					t.Errorf("snapshot error = %+v, want type %q", sn.Error, tc.wantType)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("snapshot was not recorded")
+			}
+		})
+	}
+}
+
+func TestOnMessages_CodeExecutionToolUsesAnthropic(t *testing.T) {
+	var gotBody []byte
+	anthropicBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4","usage":{"input_tokens":10,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"code_execution_tool_result","tool_use_id":"srvtoolu_1","content":{"type":"code_execution_result","stdout":"4\n","stderr":"","return_code":0,"content":[]}}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn","container":{"id":"container_1","expires_at":"2025-05-23T00:00:00Z"}},"usage":{"output_tokens":5}}`,
+			`{"type":"message_stop"}`,
+		} {
+			io.WriteString(w, "event: "+gjson.Get(event, "type").String()+"\ndata: "+event+"\n\n")
+		}
+	}))
+	defer anthropicBackend.Close()
+	openRouterBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request with the code execution tool was sent to OpenRouter")
+	}))
+	defer openRouterBackend.Close()
+
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		Anthropic:  &profile.AnthropicConfig{BaseURL: anthropicBackend.URL, APIKey: "sk-ant-test"},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: openRouterBackend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 1), nil, &pmPtr)
+
+	body := `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"container":"container_1",` +
+		`"tools":[{"type":"code_execution_20250522","name":"code_execution"}],` +
+		`"messages":[{"role":"user","content":"compute 2+2"},{"role":"assistant","content":[` +
+		`{"type":"server_tool_use","id":"srvtoolu_0","name":"code_execution","input":{"code":"print(1+1)"}},` +
+		`{"type":"code_execution_tool_result","tool_use_id":"srvtoolu_0","content":{"type":"code_execution_result","stdout":"2\n","stderr":"","return_code":0,"content":[]}}]},` +
+		`{"role":"user","content":"and 2+2?"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if provider := w.Header().Get("X-Provider"); provider != ProviderAnthropic {
+		t.Errorf("X-Provider = %q, want %q", provider, ProviderAnthropic)
+	}
+	if got := gjson.GetBytes(gotBody, "tools.0.type").String(); got != string(anthropic.ToolTypeCodeExecution2025) {
+		t.Errorf("forwarded tool type = %q: %s", got, gotBody)
+	}
+	if got := gjson.GetBytes(gotBody, "container").String(); got != "container_1" {
+		t.Errorf("forwarded container = %q: %s", got, gotBody)
+	}
+	if got := gjson.GetBytes(gotBody, "messages.1.content.1.content.stdout").String(); got != "2\n" {
+		t.Errorf("forwarded code execution result = %s", gjson.GetBytes(gotBody, "messages.1.content.1"))
+	}
+	if !strings.Contains(w.Body.String(), `"content":{"type":"code_execution_result","stdout":"4\n"`) {
+		t.Errorf("code execution result was not relayed:\n%s", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"container":{"id":"container_1"`) {
+		t.Errorf("container was not relayed:\n%s", w.Body.String())
+	}
+}
+
+func TestOnMessages_ClientDisconnectCancelsUpstream(t *testing.T) {
+	arrived := make(chan struct{})
+	canceled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body was read.
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"anthropic/claude-sonnet-4\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		close(arrived)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer backend.Close()
+
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 1), nil, &pmPtr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body := `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(httptest.NewRecorder(), r)
+	}()
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not reach the upstream")
+	}
+	cancel()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceling the client request did not abort the upstream request")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after the client went away")
+	}
+}
+
+func TestOnMessages_MaxConcurrency(t *testing.T) {
+	const limit = 2
+	arrived := make(chan struct{}, limit+1)
+	unblock := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unblock := unblock
+		arrived <- struct{}{}
+		<-unblock
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"anthropic/claude-sonnet-4\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+
+	for _, tc := range []struct {
+		name         string
+		queueTimeout time.Duration
+		wantStatus   int
+	}{
+		{name: "reject", wantStatus: http.StatusTooManyRequests},
+		{name: "queue", queueTimeout: 5 * time.Second, wantStatus: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unblock = make(chan struct{})
+			pm := profile.NewProfileManager()
+			pm.AddProfile(&profile.Profile{
+				Name:     "limited-" + tc.name,
+				Models:   []string{"*"},
+				Provider: ProviderOpenRouter,
+				Options: &profile.OptionsConfig{
+					DisableCountTokensRequest: true,
+					MaxConcurrency:            limit,
+					QueueTimeout:              tc.queueTimeout,
+				},
+				OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+			})
+			var pmPtr atomic.Pointer[profile.ProfileManager]
+			pmPtr.Store(pm)
+			var serveCmd *cobra.Command
+			for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+				if cmd.Name() == "serve" {
+					serveCmd = cmd
+				}
+			}
+			rec := make(chanRecorder, limit+2)
+			handler := onMessages(serveCmd, provider.NewProvider(), rec, nil, &pmPtr)
+			send := func() *httptest.ResponseRecorder {
+				body := `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+				r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+				r.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				handler(w, r)
+				return w
+			}
+
+			var wg sync.WaitGroup
+			codes := make(chan int, limit+1)
+			for range limit {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					codes <- send().Code
+				}()
+			}
+			for range limit {
+				select {
+				case <-arrived:
+				case <-time.After(5 * time.Second):
+					t.Fatal("requests within the limit did not reach the upstream")
+				}
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := send()
+				if w.Code == http.StatusTooManyRequests && gjson.Get(w.Body.String(), "error.type").String() != anthropic.RateLimitError {
+					t.Errorf("unexpected error body: %s", w.Body.String())
+				}
+				codes <- w.Code
+			}()
+			if tc.queueTimeout > 0 {
+				select {
+				case <-arrived:
+					t.Fatal("the request over the limit should wait for a free slot")
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+			close(unblock)
+			if tc.queueTimeout > 0 {
+				<-arrived
+			}
+			wg.Wait()
+			close(codes)
+			counts := map[int]int{}
+			for code := range codes {
+				counts[code]++
+			}
+			want := map[int]int{http.StatusOK: limit}
+			want[tc.wantStatus]++
+			if !reflect.DeepEqual(counts, want) {
+				t.Errorf("status codes = %v, want %v", counts, want)
+			}
+			// Every slot was released, so the profile accepts a full batch again.
+			if w := send(); w.Code != http.StatusOK {
+				t.Errorf("status after release = %d: %s", w.Code, w.Body.String())
+			}
+			<-arrived
+		})
+	}
+}
+
+func TestOnMessages_RawRequestBodyNonStreaming(t *testing.T) {
+	var gotBodies []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBodies = append(gotBodies, string(body))
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[{"type":"text","text":"Hello"},{"type":"tool_use","id":"toolu_1","name":"read_file","input":{"path":"go.mod"}}],"stop_reason":"tool_use","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer backend.Close()
+
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:     "anthropic",
+		Models:   []string{"*"},
+		Provider: ProviderAnthropic,
+		Options: &profile.OptionsConfig{
+			DisableCountTokensRequest: true,
+			Models:                    map[string]string{"claude-sonnet-4": "claude-sonnet-4-20250514"},
+		},
+		Anthropic: &profile.AnthropicConfig{BaseURL: backend.URL, APIKey: "sk-ant-test", UseRawRequestBody: true},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	rec := make(chanRecorder, 1)
+	handler := onMessages(serveCmd, provider.NewProvider(), rec, nil, &pmPtr)
+
+	body := `{"model":"claude-sonnet-4","max_tokens":16,"stream":false,"messages":[{"role":"user","content":"hi"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if len(gotBodies) != 1 {
+		t.Fatalf("upstream requests = %d, want 1", len(gotBodies))
+	}
+	if stream := gjson.Get(gotBodies[0], "stream"); !stream.Exists() || stream.Bool() {
+		t.Errorf("upstream stream = %s, want the client's false", stream.Raw)
+	}
+	if model := gjson.Get(gotBodies[0], "model").String(); model != "claude-sonnet-4-20250514" {
+		t.Errorf("upstream model = %q, want mapped model", model)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+	var resp anthropic.Message
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response body: %v\n%s", err, w.Body.String())
+	}
+	if resp.ID != "msg_1" || len(resp.Content) != 2 || resp.Content[0].Text != "Hello" {
+		t.Errorf("unexpected response: %s", w.Body.String())
+	}
+	if path := gjson.GetBytes(resp.Content[1].Input, "path").String(); path != "go.mod" {
+		t.Errorf("tool_use input = %s", resp.Content[1].Input)
+	}
+	if resp.StopReason == nil || *resp.StopReason != anthropic.StopReasonToolUse {
+		t.Errorf("stop_reason = %v, want tool_use", resp.StopReason)
+	}
+	if resp.Usage == nil || resp.Usage.InputTokens != 10 || resp.Usage.OutputTokens != 5 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+	select {
+	case sn := <-rec:
+		if sn.StatusCode != http.StatusOK || sn.AnthropicResponse == nil {
+			t.Errorf("snapshot status = %d, response = %v", sn.StatusCode, sn.AnthropicResponse)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("snapshot was not recorded")
+	}
+}
+
+func TestUpstreamRequestID(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		client string
+		want   string
+	}{
+		{name: "generated", want: "cc-abcd-7"},
+		{name: "client supplied", client: " trace-1 ", want: "trace-1"},
+		{name: "whitespace inside", client: "trace 1", want: "cc-abcd-7"},
+		{name: "too long", client: strings.Repeat("a", maxRequestIDLength+1), want: "cc-abcd-7"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			header := make(http.Header)
+			if tt.client != "" {
+				header.Set(provider.HeaderRequestID, tt.client)
+			}
+			if got := upstreamRequestID(header, "abcd", 7); got != tt.want {
+				t.Errorf("upstreamRequestID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenRouterProviderPreference(t *testing.T) {
+	throughput := openrouter.ProviderSortMethodThroughput
+	latency := openrouter.ProviderSortMethodLatency
+	tests := []struct {
+		name string
+		cfg  *profile.OpenRouterConfig
+		want *openrouter.ProviderPreference
+	}{
+		{
+			name: "defaults",
+			cfg:  nil,
+			want: &openrouter.ProviderPreference{AllowFallbacks: lo.ToPtr(true), RequireParameters: lo.ToPtr(false), Sort: &throughput},
+		},
+		{
+			name: "preferred providers",
+			cfg:  &profile.OpenRouterConfig{PreferredProviders: []openrouter.Provider{openrouter.ProviderAnthropic}},
+			want: &openrouter.ProviderPreference{
+				Order:             []openrouter.Provider{openrouter.ProviderAnthropic},
+				Only:              []openrouter.Provider{openrouter.ProviderAnthropic},
+				AllowFallbacks:    lo.ToPtr(true),
+				RequireParameters: lo.ToPtr(false),
+				Sort:              &throughput,
+			},
+		},
+		{
+			name: "sort and fallbacks from config",
+			cfg:  &profile.OpenRouterConfig{ProviderSort: latency, AllowFallbacks: lo.ToPtr(false)},
+			want: &openrouter.ProviderPreference{AllowFallbacks: lo.ToPtr(false), RequireParameters: lo.ToPtr(false), Sort: &latency},
+		},
+		{
+			name: "require parameters",
+			cfg:  &profile.OpenRouterConfig{RequireParameters: true},
+			want: &openrouter.ProviderPreference{AllowFallbacks: lo.ToPtr(true), RequireParameters: lo.ToPtr(true), Sort: &throughput},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := openRouterProviderPreference(tt.cfg); !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("preference = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestPreprocessRequest_SamplingOverrides(t *testing.T) {
+	req := &anthropic.GenerateMessageRequest{Model: "claude-sonnet-4", Temperature: 0.7}
+	preprocessRequest(req, &profile.Profile{Options: &profile.OptionsConfig{ForceTopP: lo.ToPtr(0.5)}})
+	if req.Temperature != 0.7 || req.TopP == nil || *req.TopP != 0.5 {
+		t.Errorf("unexpected sampling parameters: temperature=%v top_p=%v", req.Temperature, req.TopP)
+	}
+	preprocessRequest(req, &profile.Profile{Options: &profile.OptionsConfig{ForceTemperature: lo.ToPtr(0.2)}})
+	if req.Temperature != 0.2 || *req.TopP != 0.5 {
+		t.Errorf("unexpected sampling parameters: temperature=%v top_p=%v", req.Temperature, req.TopP)
+	}
+}
+
+func TestPreprocessRequest_AutoCacheBreakpoints(t *testing.T) {
+	newRequest := func() *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:    "claude-sonnet-4",
+			System:   anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "system"}},
+			Messages: []*anthropic.Message{{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}}},
+		}
+	}
+	req := newRequest()
+	preprocessRequest(req, &profile.Profile{Options: &profile.OptionsConfig{}})
+	if req.System[0].CacheControl != nil || req.Messages[0].Content[0].CacheControl != nil {
+		t.Error("breakpoints added without auto_cache_breakpoints")
+	}
+	req = newRequest()
+	preprocessRequest(req, &profile.Profile{Options: &profile.OptionsConfig{AutoCacheBreakpoints: true}})
+	if req.System[0].CacheControl == nil || req.Messages[0].Content[0].CacheControl == nil {
+		t.Error("auto_cache_breakpoints should add breakpoints to the system prompt and the user message")
+	}
+}
+
+func TestPreprocessRequest_DisableThinking(t *testing.T) {
+	req := &anthropic.GenerateMessageRequest{
+		Model:    "claude-sonnet-4",
+		Thinking: &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 1024},
+	}
+	preprocessRequest(req, &profile.Profile{Options: &profile.OptionsConfig{}})
+	if req.Thinking == nil {
+		t.Fatal("thinking removed without disable_thinking")
+	}
+	preprocessRequest(req, &profile.Profile{Options: &profile.OptionsConfig{DisableThinking: true}})
+	if req.Thinking != nil {
+		t.Errorf("disable_thinking should remove thinking, got %+v", req.Thinking)
+	}
+}
+
+func TestCountTokensCaches(t *testing.T) {
+	var caches countTokensCaches
+	req := &anthropic.CountTokensRequest{
+		Model:    "claude-sonnet-4",
+		Messages: []*anthropic.Message{{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}}},
+	}
+	if cache, _ := caches.lookup(&profile.Profile{Name: "disabled"}, req); cache != nil {
+		t.Fatal("cache should be nil when cache_count_tokens is disabled")
+	}
+	prof := &profile.Profile{Name: "cached", Options: &profile.OptionsConfig{CacheCountTokens: true, CountTokensCacheSize: 8}}
+	cache, key := caches.lookup(prof, req)
+	if cache == nil || key == "" {
+		t.Fatal("expected a cache and key when cache_count_tokens is enabled")
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("expected miss before the first count")
+	}
+	cache.Add(key, 42)
+	sameCache, sameKey := caches.lookup(prof, &anthropic.CountTokensRequest{Model: req.Model, Messages: req.Messages})
+	if sameCache != cache || sameKey != key {
+		t.Fatal("identical requests should share the cache entry")
+	}
+	if tokens, ok := sameCache.Get(sameKey); !ok || tokens != 42 {
+		t.Errorf("Get = %d, %v, want cached 42", tokens, ok)
+	}
+	if _, otherKey := caches.lookup(prof, &anthropic.CountTokensRequest{Model: req.Model, Messages: req.Messages, System: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "system"}}}); otherKey == key {
+		t.Error("a different system prompt should produce a different key")
+	}
+	// Changing the cache settings, e.g. after a config reload, starts a new cache.
+	prof.Options.CountTokensCacheSize = 16
+	if resized, _ := caches.lookup(prof, req); resized == cache || resized.Len() != 0 {
+		t.Error("expected a new empty cache after the size changed")
+	}
+}
+
+func TestOnMessages_ResponseCache(t *testing.T) {
+	var upstreamRequests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		prompt := gjson.GetBytes(body, "messages.0.content.0.text").String()
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","content":"echo `+prompt+`"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`+"\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true, ResponseCache: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 8), nil, &pmPtr)
+	send := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	w := send(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"one"}]}`)
+	if w.Header().Get("X-Cc-Response-Cache") != "miss" || upstreamRequests.Load() != 1 {
+		t.Fatalf("first request: cache %q, %d upstream requests", w.Header().Get("X-Cc-Response-Cache"), upstreamRequests.Load())
+	}
+	want := w.Body.String()
+
+	w = send(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"one"}]}`)
+	if w.Header().Get("X-Cc-Response-Cache") != "hit" || upstreamRequests.Load() != 1 {
+		t.Errorf("identical request: cache %q, %d upstream requests", w.Header().Get("X-Cc-Response-Cache"), upstreamRequests.Load())
+	}
+	if w.Body.String() != want {
+		t.Errorf("cached response = %s, want %s", w.Body.String(), want)
+	}
+
+	// A streaming request replays the cached message as a stream.
+	w = send(`{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"one"}]}`)
+	if w.Header().Get("X-Cc-Response-Cache") != "hit" || upstreamRequests.Load() != 1 {
+		t.Errorf("streaming request: cache %q, %d upstream requests", w.Header().Get("X-Cc-Response-Cache"), upstreamRequests.Load())
+	}
+	var (
+		types []string
+		text  string
+	)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			event := gjson.Parse(data)
+			types = append(types, event.Get("type").String())
+			text += event.Get("delta.text").String()
+		}
+	}
+	if strings.Join(types, ",") != "message_start,content_block_start,content_block_delta,content_block_stop,message_delta,message_stop" {
+		t.Errorf("event types = %v", types)
+	}
+	if text != "echo one" || !strings.Contains(w.Body.String(), `"output_tokens":3`) {
+		t.Errorf("replayed stream = %s", w.Body.String())
+	}
+
+	w = send(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"two"}]}`)
+	if w.Header().Get("X-Cc-Response-Cache") != "miss" || upstreamRequests.Load() != 2 {
+		t.Errorf("different request: cache %q, %d upstream requests", w.Header().Get("X-Cc-Response-Cache"), upstreamRequests.Load())
+	}
+	if got := gjson.Get(w.Body.String(), "content.0.text").String(); got != "echo two" {
+		t.Errorf("response text = %q", got)
+	}
+}
+
+func TestOnMessages_Middlewares(t *testing.T) {
+	t.Cleanup(adapter.DefaultMiddlewares.Reset)
+	var upstreamPrompt atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamPrompt.Store(gjson.GetBytes(body, "messages.0.content.0.text").String())
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"anthropic/claude-sonnet-4\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"call me at 555-0100\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backend.URL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	handler := onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 4), nil, &pmPtr)
+	scrub := strings.NewReplacer("555-0100", "[phone]")
+	adapter.RegisterRequestMiddleware(adapter.RequestMiddlewareFunc(func(_ context.Context, req *anthropic.GenerateMessageRequest) error {
+		for _, message := range req.Messages {
+			for _, content := range message.Content {
+				if strings.Contains(content.Text, "forbidden") {
+					return errors.New("prompt contains a forbidden word")
+				}
+				content.Text = scrub.Replace(content.Text)
+			}
+		}
+		return nil
+	}))
+	adapter.RegisterResponseMiddleware(adapter.ResponseMiddlewareFunc(func(_ context.Context, event anthropic.Event) (anthropic.Event, error) {
+		if delta, ok := event.(*anthropic.EventContentBlockDelta); ok {
+			delta.Delta.Text = scrub.Replace(delta.Delta.Text)
+		}
+		return event, nil
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"my number is 555-0100"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if got := upstreamPrompt.Load(); got != "my number is [phone]" {
+		t.Errorf("upstream prompt = %q, want it scrubbed", got)
+	}
+	if got := gjson.Get(w.Body.String(), "content.0.text").String(); got != "call me at [phone]" {
+		t.Errorf("response text = %q, want it scrubbed", got)
+	}
+
+	upstreamPrompt.Store("")
+	r = httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"a forbidden word"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "forbidden word") {
+		t.Errorf("status = %d: %s, want the middleware error", w.Code, w.Body.String())
+	}
+	if got := upstreamPrompt.Load(); got != "" {
+		t.Errorf("a rejected request reached upstream: %q", got)
+	}
+}
+
+func TestOnMessages_DeniedModel(t *testing.T) {
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "cheap",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true, DeniedModels: []string{"claude-opus-*"}},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: "http://127.0.0.1:0", APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-1","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 1), nil, &pmPtr)(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %s", w.Code, w.Body.String())
+	}
+	if errorType := gjson.Get(w.Body.String(), "error.type").String(); errorType != anthropic.PermissionError {
+		t.Errorf("error.type = %q, want %q", errorType, anthropic.PermissionError)
+	}
+	if message := gjson.Get(w.Body.String(), "error.message").String(); !strings.Contains(message, `profile "cheap"`) {
+		t.Errorf("error.message = %q, want it to name the profile", message)
+	}
+}
+
+func TestOnCountTokens_Local(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("local_count_tokens must not call upstream")
+		io.WriteString(w, `{"input_tokens":1}`)
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:      "local",
+		Provider:  ProviderOpenRouter,
+		Models:    []string{"claude-*"},
+		Options:   &profile.OptionsConfig{LocalCountTokens: true},
+		Anthropic: &profile.AnthropicConfig{CountTokensBackend: backend.URL},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	body := `{"model":"claude-sonnet-4","system":"You are terse.","messages":[{"role":"user","content":"How many tokens is this prompt?"}]}`
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	onCountTokens(&pmPtr)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var req anthropic.CountTokensRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gjson.Get(w.Body.String(), "input_tokens").Int(), adapter.EstimateInputTokens(&req); got != want || got == 0 {
+		t.Errorf("input_tokens = %d, want %d", got, want)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", strings.NewReader(`{"model":"claude-sonnet-4","messages":1}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	onCountTokens(&pmPtr)(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for an invalid body: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOnCountTokens_Model(t *testing.T) {
+	var gotModel atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotModel.Store(gjson.GetBytes(body, "model").String())
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"input_tokens":10}`)
+	}))
+	defer backend.Close()
+	newProfileManager := func(countModel string) *atomic.Pointer[profile.ProfileManager] {
+		pm := profile.NewProfileManager()
+		pm.AddProfile(&profile.Profile{
+			Name:     "openrouter",
+			Provider: ProviderOpenRouter,
+			Models:   []string{"claude-*"},
+			Options: &profile.OptionsConfig{
+				Models:           map[string]string{"claude-3-5-sonnet": "anthropic/claude-3-5-sonnet:beta"},
+				CountTokensModel: countModel,
+			},
+			Anthropic: &profile.AnthropicConfig{CountTokensBackend: backend.URL},
+		})
+		var pmPtr atomic.Pointer[profile.ProfileManager]
+		pmPtr.Store(pm)
+		return &pmPtr
+	}
+	tests := []struct {
+		name       string
+		countModel string
+		want       string
+	}{
+		{name: "original model, not the mapped one", want: "claude-3-5-sonnet"},
+		{name: "count_tokens_model override", countModel: "claude-sonnet-4-20250514", want: "claude-sonnet-4-20250514"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens",
+				strings.NewReader(`{"model":"claude-3-5-sonnet","messages":[{"role":"user","content":"hi"}]}`))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			onCountTokens(newProfileManager(tt.countModel))(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+			}
+			if got := gotModel.Load(); got != tt.want {
+				t.Errorf("count_tokens model = %v, want %s", got, tt.want)
+			}
+		})
+	}
+	if got := countTokensModel("claude-3-5-sonnet", &profile.Profile{}); got != "claude-3-5-sonnet" {
+		t.Errorf("countTokensModel without options = %q", got)
+	}
+}
+
+func TestOnMessages_OpenAI(t *testing.T) {
+	var (
+		gotBody   []byte
+		gotHeader http.Header
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotHeader = r.Header.Clone()
+		if r.URL.Path != "/v1/responses" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, `data: {"type":"response.created","response":{"id":"resp_1","model":"gpt-5","status":"in_progress","output":[]}}`+"\n\n")
+		io.WriteString(w, `data: {"type":"response.output_text.delta","item_id":"msg_1","delta":"hi"}`+"\n\n")
+		io.WriteString(w, `data: {"type":"response.completed","response":{"id":"resp_1","model":"gpt-5","status":"completed","service_tier":"default","output":[],"usage":{"input_tokens":5,"output_tokens":1}}}`+"\n\n")
+	}))
+	defer backend.Close()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:     "openai",
+		Models:   []string{"*"},
+		Provider: ProviderOpenAI,
+		Options:  &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenAI: &profile.OpenAIConfig{
+			BaseURL: backend.URL,
+			APIKey:  "sk-test",
+			Headers: map[string]string{"OpenAI-Organization": "org-123", "Authorization": "Bearer overridden"},
+		},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	rec := make(chanRecorder, 1)
+	handler := onMessages(serveCmd, provider.NewProvider(), rec, nil, &pmPtr)
+	r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"gpt-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, w.Body.String())
+	}
+	if got := resp.Header.Get("X-Provider"); got != ProviderOpenAI {
+		t.Errorf("X-Provider = %q, want %q", got, ProviderOpenAI)
+	}
+	if got := resp.Header.Get("X-Service-Tier"); got != "default" {
+		t.Errorf("X-Service-Tier = %q, want default", got)
+	}
+	var message anthropic.Message
+	if err := json.Unmarshal(w.Body.Bytes(), &message); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	if len(message.Content) != 1 || message.Content[0].Text != "hi" {
+		t.Errorf("unexpected content: %+v", message.Content)
+	}
+	if !strings.Contains(string(gotBody), `"input":[{"type":"message","role":"user","content":[{"type":"input_text","text":"hi"}]}]`) {
+		t.Errorf("unexpected upstream body: %s", gotBody)
+	}
+	if got := gotHeader.Get("OpenAI-Organization"); got != "org-123" {
+		t.Errorf("OpenAI-Organization = %q, want the profile's static header", got)
+	}
+	if got := gotHeader.Get("Authorization"); got != "Bearer sk-test" {
+		t.Errorf("Authorization = %q, the API key must not be overridden", got)
+	}
+	select {
+	case sn := <-rec:
+		if sn.Provider != ProviderOpenAI || sn.OpenAIRequest == nil || sn.ServiceTier != "default" || sn.ResponseHeader == nil {
+			t.Errorf("unexpected snapshot: provider = %q, openai_request = %+v, service_tier = %q", sn.Provider, sn.OpenAIRequest, sn.ServiceTier)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no snapshot recorded")
+	}
+}
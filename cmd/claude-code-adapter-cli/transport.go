@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+// defaultMaxIdleConnsPerHost replaces the net/http default of 2 idle connections per host, which
+// makes concurrent requests to the same provider open a new connection each time.
+const defaultMaxIdleConnsPerHost = 32
+
+// newUpstreamTransport builds the transport upstream requests are sent with from the http.transport
+// settings. Unset settings keep the defaults of http.DefaultTransport, except for
+// max_idle_conns_per_host, which defaults to defaultMaxIdleConnsPerHost.
+func newUpstreamTransport(v *viper.Viper) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	for _, setting := range []struct {
+		name  string
+		value *int
+	}{
+		{"max_idle_conns", &transport.MaxIdleConns},
+		{"max_idle_conns_per_host", &transport.MaxIdleConnsPerHost},
+	} {
+		key := delimiter.ViperKey("http", "transport", setting.name)
+		if !v.IsSet(key) {
+			continue
+		}
+		n := v.GetInt(key)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid http.transport.%s %d: must not be negative", setting.name, n)
+		}
+		*setting.value = n
+	}
+	if key := delimiter.ViperKey("http", "transport", "idle_conn_timeout"); v.IsSet(key) {
+		timeout := v.GetDuration(key)
+		if timeout < 0 {
+			return nil, fmt.Errorf("invalid http.transport.idle_conn_timeout %s: must not be negative", timeout)
+		}
+		transport.IdleConnTimeout = timeout
+	}
+	if key := delimiter.ViperKey("http", "transport", "force_http2"); v.IsSet(key) && !v.GetBool(key) {
+		// A non-nil, empty TLSNextProto disables HTTP/2, keeping connections on HTTP/1.1.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport, nil
+}
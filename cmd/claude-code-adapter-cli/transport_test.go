@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+func TestNewUpstreamTransport(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		transport, err := newUpstreamTransport(viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter)))
+		if err != nil {
+			t.Fatalf("newUpstreamTransport failed: %v", err)
+		}
+		defaultTransport := http.DefaultTransport.(*http.Transport)
+		if transport.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+			t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+		}
+		if transport.MaxIdleConns != defaultTransport.MaxIdleConns || transport.IdleConnTimeout != defaultTransport.IdleConnTimeout {
+			t.Errorf("MaxIdleConns = %d, IdleConnTimeout = %s, want the net/http defaults", transport.MaxIdleConns, transport.IdleConnTimeout)
+		}
+		if !transport.ForceAttemptHTTP2 || transport.TLSNextProto != nil {
+			t.Error("HTTP/2 should be negotiated by default")
+		}
+		if transport == defaultTransport {
+			t.Error("http.DefaultTransport must not be modified")
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+		v.Set(delimiter.ViperKey("http", "transport", "max_idle_conns"), 0)
+		v.Set(delimiter.ViperKey("http", "transport", "max_idle_conns_per_host"), 64)
+		v.Set(delimiter.ViperKey("http", "transport", "idle_conn_timeout"), "5m")
+		v.Set(delimiter.ViperKey("http", "transport", "force_http2"), false)
+		transport, err := newUpstreamTransport(v)
+		if err != nil {
+			t.Fatalf("newUpstreamTransport failed: %v", err)
+		}
+		if transport.MaxIdleConns != 0 || transport.MaxIdleConnsPerHost != 64 || transport.IdleConnTimeout != 5*time.Minute {
+			t.Errorf("MaxIdleConns = %d, MaxIdleConnsPerHost = %d, IdleConnTimeout = %s", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+		}
+		if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil || len(transport.TLSNextProto) != 0 {
+			t.Error("force_http2: false should disable HTTP/2")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+		v.Set(delimiter.ViperKey("http", "transport", "max_idle_conns_per_host"), -1)
+		if _, err := newUpstreamTransport(v); err == nil {
+			t.Error("expected an error for a negative max_idle_conns_per_host")
+		}
+	})
+}
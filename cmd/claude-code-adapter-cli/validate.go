@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/provider"
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+func newValidateCommand() *cobra.Command {
+	var configFile string
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check the profiles of a config file and report misconfigurations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v, err := readConfigFile(configFile)
+			if err != nil {
+				return err
+			}
+			if problems := validateConfig(v, cmd.OutOrStdout()); problems > 0 {
+				return fmt.Errorf("%s: %d problem(s) found", v.ConfigFileUsed(), problems)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file (default is $HOME/.claude-code-adapter/config.yaml)")
+	return cmd
+}
+
+// readConfigFile reads configFile, or config.yaml from the default locations when it is empty.
+func readConfigFile(configFile string) (*viper.Viper, error) {
+	v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath("$HOME/.claude-code-adapter/")
+	v.AddConfigPath(".")
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	return v, nil
+}
+
+// validateConfig loads the profiles of v, writes a report of the problems of each profile to w,
+// and returns the number of problems found.
+func validateConfig(v *viper.Viper, w io.Writer) int {
+	pm, err := profile.LoadFromViper(v)
+	if err != nil {
+		fmt.Fprintf(w, "error: %s\n", err.Error())
+		return 1
+	}
+	var total int
+	for _, p := range pm.Profiles() {
+		problems := validateProfileOf(pm, p)
+		if len(problems) == 0 {
+			fmt.Fprintf(w, "profile %q: ok\n", p.Name)
+			continue
+		}
+		total += len(problems)
+		fmt.Fprintf(w, "profile %q: %d problem(s)\n", p.Name, len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(w, "  - %s\n", problem)
+		}
+	}
+	return total
+}
+
+// validateProfileManager returns an error listing the problems of every profile of pm, or nil
+// when there is none.
+func validateProfileManager(pm *profile.ProfileManager) error {
+	var problems []string
+	for _, p := range pm.Profiles() {
+		for _, problem := range validateProfileOf(pm, p) {
+			problems = append(problems, fmt.Sprintf("profile %q: %s", p.Name, problem))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateProfileOf returns the problems of p, including its references to other profiles of pm
+// and the model patterns shadowed by an earlier profile of pm.
+func validateProfileOf(pm *profile.ProfileManager, p *profile.Profile) []string {
+	problems := validateProfile(p)
+	if fallback := p.Options.GetFallbackProfile(); fallback == p.Name {
+		problems = append(problems, "options.fallback_profile: a profile cannot fall back to itself")
+	} else if _, ok := pm.Get(fallback); fallback != "" && !ok {
+		problems = append(problems, fmt.Sprintf("options.fallback_profile: unknown profile %q", fallback))
+	}
+	for _, earlier := range pm.Profiles() {
+		if earlier == p {
+			break
+		}
+		for _, pattern := range p.Models {
+			for _, earlierPattern := range earlier.Models {
+				if pattern != "" && earlierPattern != "" && patternCovers(earlierPattern, pattern) {
+					problems = append(problems, fmt.Sprintf("models: pattern %q never matches, profile %q matches every model it does with %q", pattern, earlier.Name, earlierPattern))
+					break
+				}
+			}
+		}
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+// patternCovers reports whether pattern matches every model that other matches.
+func patternCovers(pattern, other string) bool {
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	if !wildcard {
+		return pattern == other
+	}
+	return strings.HasPrefix(other, prefix)
+}
+
+var (
+	knownReasoningFormats = []openrouter.ChatCompletionMessageReasoningDetailFormat{
+		openrouter.ChatCompletionMessageReasoningDetailFormatUnknown,
+		openrouter.ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1,
+		openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1,
+		openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1,
+		openrouter.ChatCompletionMessageReasoningDetailFormatGoogleGeminiV1,
+	}
+	knownReasoningEfforts = []openrouter.ChatCompletionReasoningEffort{
+		openrouter.ChatCompletionReasoningEffortMinimal,
+		openrouter.ChatCompletionReasoningEffortLow,
+		openrouter.ChatCompletionReasoningEffortMedium,
+		openrouter.ChatCompletionReasoningEffortHigh,
+	}
+)
+
+// validateProfile returns the problems of p that would otherwise only surface at request time.
+func validateProfile(p *profile.Profile) (problems []string) {
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if len(p.Models) == 0 {
+		addProblem("models: at least one model pattern is required")
+	}
+	for _, pattern := range p.Models {
+		if pattern == "" {
+			addProblem("models: empty pattern")
+		} else if strings.Contains(strings.TrimSuffix(pattern, "*"), "*") {
+			addProblem("models: pattern %q may only contain \"*\" as its last character", pattern)
+		}
+	}
+	switch p.Provider {
+	case ProviderAnthropic:
+		checkRequiredValue(addProblem, "anthropic.api_key", p.Anthropic.GetAPIKey())
+	case ProviderOpenRouter:
+		if keys := p.OpenRouter.GetAPIKeys(); len(keys) == 0 {
+			addProblem("openrouter.api_key: required")
+		} else {
+			for _, key := range keys {
+				checkRequiredValue(addProblem, "openrouter.api_key", key)
+			}
+		}
+		if p.OpenRouter != nil {
+			if strategy := p.OpenRouter.APIKeyStrategy; strategy != "" && strategy != profile.APIKeyStrategyRoundRobin && strategy != profile.APIKeyStrategyLeastRecentlyErrored {
+				addProblem("openrouter.api_key_strategy: unknown strategy %q", strategy)
+			}
+		}
+		checkRequiredValue(addProblem, "openrouter.base_url", p.OpenRouter.GetBaseURL())
+	case ProviderBedrock:
+		if _, err := provider.LoadBedrockCredentials(p.Bedrock); err != nil {
+			addProblem("bedrock: %s", err.Error())
+		}
+	case ProviderGemini:
+		checkRequiredValue(addProblem, "gemini.api_key", p.Gemini.GetAPIKey())
+	case ProviderOpenAI:
+		checkRequiredValue(addProblem, "openai.api_key", p.OpenAI.GetAPIKey())
+	case "":
+		addProblem("provider: required, one of %q, %q, %q, %q or %q", ProviderAnthropic, ProviderOpenRouter, ProviderBedrock, ProviderGemini, ProviderOpenAI)
+	default:
+		addProblem("provider: unknown provider %q, expected %q, %q, %q, %q or %q", p.Provider, ProviderAnthropic, ProviderOpenRouter, ProviderBedrock, ProviderGemini, ProviderOpenAI)
+	}
+	if p.Options != nil && p.Options.Reasoning != nil {
+		r := p.Options.Reasoning
+		if r.Format != "" && !slices.Contains(knownReasoningFormats, openrouter.ChatCompletionMessageReasoningDetailFormat(r.Format)) {
+			addProblem("options.reasoning.format: unknown format %q", r.Format)
+		}
+		if r.Effort != "" && !slices.Contains(knownReasoningEfforts, openrouter.ChatCompletionReasoningEffort(r.Effort)) {
+			addProblem("options.reasoning.effort: unknown effort %q", r.Effort)
+		}
+	}
+	for model, format := range p.OpenRouter.GetModelReasoningFormat() {
+		if !slices.Contains(knownReasoningFormats, openrouter.ChatCompletionMessageReasoningDetailFormat(format)) {
+			addProblem("openrouter.model_reasoning_format: unknown format %q for model %q", format, model)
+		}
+	}
+	if p.Options != nil {
+		if mode := p.Options.RedactedThinkingMode; mode != "" && mode != profile.RedactedThinkingModeDrop && mode != profile.RedactedThinkingModeEncrypted {
+			addProblem("options.redacted_thinking_mode: unknown mode %q", mode)
+		}
+		switch detail := p.Options.ImageDetail; detail {
+		case "", profile.ImageDetailLow, profile.ImageDetailHigh, profile.ImageDetailAuto:
+		default:
+			addProblem("options.image_detail: unknown detail %q, expected %q, %q or %q", detail, profile.ImageDetailLow, profile.ImageDetailHigh, profile.ImageDetailAuto)
+		}
+	}
+	if sort := p.OpenRouter.GetProviderSort(); !sort.IsKnown() {
+		addProblem("openrouter.provider_sort: unknown sort method %q", sort)
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+// checkRequiredValue reports value when it is empty or still references an unset environment variable.
+func checkRequiredValue(addProblem func(string, ...any), key string, value string) {
+	if value == "" {
+		addProblem("%s: required", key)
+	} else if strings.Contains(value, "${") {
+		addProblem("%s: %q references an unset environment variable", key, value)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCommand(t *testing.T) {
+	t.Setenv("TEST_VALIDATE_OPENROUTER_KEY", "sk-or-test")
+	os.Unsetenv("TEST_VALIDATE_UNSET_KEY")
+	writeConfig := func(t *testing.T, config string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	run := func(t *testing.T, path string) (string, error) {
+		t.Helper()
+		var out bytes.Buffer
+		cmd := newValidateCommand()
+		cmd.SetOut(&out)
+		cmd.SetArgs([]string{"--config", path})
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	t.Run("valid config", func(t *testing.T) {
+		out, err := run(t, writeConfig(t, `
+profiles:
+  claude:
+    models: ["claude-*"]
+    provider: openrouter
+    options:
+      reasoning:
+        format: anthropic-claude-v1
+        effort: high
+    openrouter:
+      api_key: ${TEST_VALIDATE_OPENROUTER_KEY}
+`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v\n%s", err, out)
+		}
+		if !strings.Contains(out, `profile "claude": ok`) {
+			t.Errorf("unexpected report:\n%s", out)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		out, err := run(t, writeConfig(t, `
+profiles:
+  broken:
+    models: ["*-sonnet", ""]
+    provider: openrouter
+    options:
+      redacted_thinking_mode: keep
+      image_detail: medium
+      reasoning:
+        format: anthropic-v2
+    openrouter:
+      api_key: ${TEST_VALIDATE_UNSET_KEY}
+      model_reasoning_format:
+        openai/gpt-5: openai-responses-v2
+  anthropic:
+    models: ["claude-*"]
+    provider: anthropic
+  openai:
+    models: ["gpt-*"]
+    provider: openai
+  typo:
+    models: ["*"]
+    provider: open-router
+`))
+		if err == nil || !strings.Contains(err.Error(), "10 problem(s) found") {
+			t.Fatalf("expected 10 problems, got %v\n%s", err, out)
+		}
+		for _, want := range []string{
+			`profile "broken": 7 problem(s)`,
+			`models: pattern "*-sonnet" may only contain "*" as its last character`,
+			`models: empty pattern`,
+			`openrouter.api_key: "${TEST_VALIDATE_UNSET_KEY}" references an unset environment variable`,
+			`options.reasoning.format: unknown format "anthropic-v2"`,
+			`openrouter.model_reasoning_format: unknown format "openai-responses-v2" for model "openai/gpt-5"`,
+			`options.redacted_thinking_mode: unknown mode "keep"`,
+			`options.image_detail: unknown detail "medium"`,
+			`profile "anthropic": 1 problem(s)`,
+			`anthropic.api_key: required`,
+			`profile "openai": 1 problem(s)`,
+			`openai.api_key: required`,
+			`provider: unknown provider "open-router"`,
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("report does not contain %q:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("shadowed pattern", func(t *testing.T) {
+		out, err := run(t, writeConfig(t, `
+profiles:
+  claude:
+    models: ["claude-*"]
+    provider: openrouter
+    openrouter:
+      api_key: ${TEST_VALIDATE_OPENROUTER_KEY}
+  sonnet:
+    models: ["claude-sonnet-4", "gpt-*"]
+    provider: openrouter
+    openrouter:
+      api_key: ${TEST_VALIDATE_OPENROUTER_KEY}
+`))
+		if err == nil || !strings.Contains(err.Error(), "1 problem(s) found") {
+			t.Fatalf("expected 1 problem, got %v\n%s", err, out)
+		}
+		if want := `models: pattern "claude-sonnet-4" never matches, profile "claude" matches every model it does with "claude-*"`; !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	})
+
+	t.Run("unknown fallback profile", func(t *testing.T) {
+		out, err := run(t, writeConfig(t, `
+profiles:
+  claude:
+    models: ["claude-*"]
+    provider: openrouter
+    options:
+      fallback_profile: anthropic
+    openrouter:
+      api_key: ${TEST_VALIDATE_OPENROUTER_KEY}
+`))
+		if err == nil || !strings.Contains(err.Error(), "1 problem(s) found") {
+			t.Fatalf("expected 1 problem, got %v\n%s", err, out)
+		}
+		if want := `unknown profile "anthropic"`; !strings.Contains(out, want) {
+			t.Errorf("report does not contain %q:\n%s", want, out)
+		}
+	})
+
+	t.Run("missing config file", func(t *testing.T) {
+		if _, err := run(t, filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+			t.Fatal("expected an error for a missing config file")
+		}
+	})
+}
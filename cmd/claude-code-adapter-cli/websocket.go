@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/utils"
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
+)
+
+// websocketGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xA
+)
+
+// websocketMaxPendingMessages is the number of requests a connection queues while one is handled.
+const websocketMaxPendingMessages = 16
+
+const (
+	websocketCloseNormal      = 1000
+	websocketCloseProtocol    = 1002
+	websocketCloseUnsupported = 1003
+	websocketCloseTooBig      = 1009
+)
+
+// onMessagesWebSocket serves the messages endpoint over a WebSocket. Every text message received
+// is an Anthropic request body, which is handled by messages, the regular /v1/messages handler,
+// with the headers of the upgrade request. Requests of a connection are handled one at a time,
+// in order. Each SSE event of a streaming response is sent back as one text message containing
+// its data, and any other response body, e.g. a non-streaming message or an error, as a single
+// text message. Closing the connection cancels the request in flight.
+func onMessagesWebSocket(messages http.HandlerFunc) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgradeWebSocket(w, r)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("error upgrading to WebSocket: %s", err.Error()))
+			return
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		maxBodyBytes := viper.GetInt64(delimiter.ViperKey("http", "max_body_bytes"))
+		if maxBodyBytes <= 0 {
+			maxBodyBytes = defaultMaxBodyBytes
+		}
+		header := r.Header.Clone()
+		for _, name := range []string{"Connection", "Upgrade", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"} {
+			header.Del(name)
+		}
+		header.Set("Content-Type", "application/json")
+		// Reading goes on while a request is handled, so that a disconnect cancels it; only a client
+		// with more than websocketMaxPendingMessages requests queued is not read from until one is done.
+		bodies := make(chan []byte, websocketMaxPendingMessages)
+		go func() {
+			// The client is gone once the connection cannot be read anymore.
+			defer cancel()
+			defer close(bodies)
+			for {
+				body, err := conn.ReadMessage(maxBodyBytes)
+				if err != nil {
+					if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, errWebSocketClosed) {
+						slog.Warn(fmt.Sprintf("error reading WebSocket message: %s", err.Error()))
+					}
+					return
+				}
+				select {
+				case bodies <- body:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		for body := range bodies {
+			if ctx.Err() != nil {
+				return
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/messages", bytes.NewReader(body))
+			if err != nil {
+				slog.Error(fmt.Sprintf("error creating request from WebSocket message: %s", err.Error()))
+				return
+			}
+			req.Header = header.Clone()
+			req.RemoteAddr = r.RemoteAddr
+			rw := &websocketResponseWriter{conn: conn, header: make(http.Header)}
+			messages(rw, req)
+			if err = rw.finish(); err != nil {
+				slog.Warn(fmt.Sprintf("error writing WebSocket message: %s", err.Error()))
+				return
+			}
+		}
+	}
+}
+
+// websocketResponseWriter converts a response of the messages handler into WebSocket messages.
+type websocketResponseWriter struct {
+	conn   *websocketConn
+	header http.Header
+	buf    bytes.Buffer
+	err    error
+}
+
+func (w *websocketResponseWriter) Header() http.Header { return w.header }
+
+// WriteHeader is a no-op, the status of an error response is part of its body.
+func (w *websocketResponseWriter) WriteHeader(int) {}
+
+func (w *websocketResponseWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	if w.isEventStream() {
+		w.sendEvents(false)
+	}
+	return len(p), w.err
+}
+
+// Flush is a no-op, complete events are sent as soon as they are written.
+func (w *websocketResponseWriter) Flush() {}
+
+func (w *websocketResponseWriter) isEventStream() bool {
+	return utils.IsContentType(w.header, "text/event-stream")
+}
+
+// sendEvents sends the data of every complete event in the buffer, and of the incomplete
+// trailing one as well when final is set.
+func (w *websocketResponseWriter) sendEvents(final bool) {
+	for w.err == nil {
+		data := w.buf.Bytes()
+		end := bytes.Index(data, []byte("\n\n"))
+		if end == -1 {
+			if !final || len(bytes.TrimSpace(data)) == 0 {
+				return
+			}
+			end = len(data)
+		}
+		event := data[:end]
+		var payload []byte
+		for line := range bytes.Lines(event) {
+			if value, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				payload = append(payload, bytes.TrimSpace(value)...)
+			}
+		}
+		w.buf.Next(min(end+2, len(data)))
+		if len(payload) > 0 {
+			w.err = w.conn.WriteMessage(websocketOpText, payload)
+		}
+	}
+}
+
+// finish sends what remains of the response once the handler returned.
+func (w *websocketResponseWriter) finish() error {
+	if w.isEventStream() {
+		w.sendEvents(true)
+	} else if w.err == nil && w.buf.Len() > 0 {
+		w.err = w.conn.WriteMessage(websocketOpText, bytes.TrimSpace(w.buf.Bytes()))
+	}
+	return w.err
+}
+
+var errWebSocketClosed = errors.New("websocket: connection closed by peer")
+
+// websocketConn is the server side of a WebSocket connection (RFC 6455) without extensions.
+type websocketConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	mu sync.Mutex // serializes writes
+}
+
+// upgradeWebSocket performs the opening handshake and takes over the connection of r.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		respondError(w, http.StatusBadRequest, "Expected a WebSocket upgrade request")
+		return nil, errors.New("not a WebSocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		respondError(w, http.StatusBadRequest, "Unsupported WebSocket version")
+		return nil, fmt.Errorf("unsupported WebSocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		respondError(w, http.StatusBadRequest, "Missing Sec-WebSocket-Key header")
+		return nil, errors.New("missing Sec-WebSocket-Key header")
+	}
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "WebSocket is not supported by this connection")
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n")
+	fmt.Fprintf(rw, "Upgrade: websocket\r\n")
+	fmt.Fprintf(rw, "Connection: Upgrade\r\n")
+	fmt.Fprintf(rw, "Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(hash[:]))
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &websocketConn{conn: conn, rw: rw}, nil
+}
+
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for field := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the payload of the next text message, answering pings and a close
+// handshake on the way. It fails with errWebSocketClosed once the peer closed the connection.
+func (c *websocketConn) ReadMessage(limit int64) ([]byte, error) {
+	var (
+		message []byte
+		opcode  byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame(limit - int64(len(message)))
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case websocketOpPing:
+			if err = c.WriteMessage(websocketOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case websocketOpPong:
+			continue
+		case websocketOpClose:
+			c.writeClose(websocketCloseNormal)
+			return nil, errWebSocketClosed
+		case websocketOpContinuation:
+			if opcode == 0 {
+				c.writeClose(websocketCloseProtocol)
+				return nil, errors.New("websocket: unexpected continuation frame")
+			}
+		case websocketOpText, websocketOpBinary:
+			if opcode != 0 {
+				c.writeClose(websocketCloseProtocol)
+				return nil, errors.New("websocket: expected a continuation frame")
+			}
+			opcode = op
+		default:
+			c.writeClose(websocketCloseProtocol)
+			return nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+		message = append(message, payload...)
+		if fin {
+			if opcode != websocketOpText {
+				c.writeClose(websocketCloseUnsupported)
+				return nil, errors.New("websocket: binary messages are not supported")
+			}
+			return message, nil
+		}
+	}
+}
+
+func (c *websocketConn) readFrame(limit int64) (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.rw, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0F
+	if head[1]&0x80 == 0 {
+		c.writeClose(websocketCloseProtocol)
+		return false, 0, nil, errors.New("websocket: client frames must be masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if limit < 0 || length > uint64(limit) {
+		c.writeClose(websocketCloseTooBig)
+		return false, 0, nil, fmt.Errorf("websocket: message exceeds the maximum size of %d bytes", max(limit, 0))
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage sends payload as a single unmasked frame.
+func (c *websocketConn) WriteMessage(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		head = append(head, byte(length))
+	case length <= 0xFFFF:
+		head = append(head, 126)
+		head = binary.BigEndian.AppendUint16(head, uint16(length))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(length))
+	}
+	if _, err := c.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+func (c *websocketConn) writeClose(code uint16) {
+	c.WriteMessage(websocketOpClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+func (c *websocketConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+	"github.com/x5iu/claude-code-adapter/pkg/provider"
+)
+
+// dialWebSocket opens a WebSocket to the /v1/messages/ws endpoint of server.
+func dialWebSocket(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	io.WriteString(conn, "GET /v1/messages/ws HTTP/1.1\r\n"+
+		"Host: "+server.Listener.Addr().String()+"\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"X-Api-Key: sk-ant-test\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d", resp.StatusCode)
+	}
+	// Example key and accept value of RFC 6455, section 1.3.
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", accept)
+	}
+	return conn, reader
+}
+
+func writeClientFrame(t *testing.T, conn net.Conn, opcode byte, payload string) {
+	t.Helper()
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+func readServerFrame(t *testing.T, conn net.Conn, reader *bufio.Reader) (byte, string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var head [2]byte
+	if _, err := io.ReadFull(reader, head[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return head[0] & 0x0F, string(payload)
+}
+
+func newWebSocketTestServer(t *testing.T, backendURL string) *httptest.Server {
+	t.Helper()
+	pm := profile.NewProfileManager()
+	pm.AddProfile(&profile.Profile{
+		Name:       "openrouter",
+		Models:     []string{"*"},
+		Provider:   ProviderOpenRouter,
+		Options:    &profile.OptionsConfig{DisableCountTokensRequest: true},
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: backendURL, APIKey: "sk-or-test"},
+	})
+	var pmPtr atomic.Pointer[profile.ProfileManager]
+	pmPtr.Store(pm)
+	var serveCmd *cobra.Command
+	for _, cmd := range newClaudeClaudeAdapterCliCommand().Commands() {
+		if cmd.Name() == "serve" {
+			serveCmd = cmd
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(onMessagesWebSocket(onMessages(serveCmd, provider.NewProvider(), make(chanRecorder, 16), nil, &pmPtr))))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOnMessagesWebSocket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"anthropic/claude-sonnet-4\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"hi\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer backend.Close()
+	server := newWebSocketTestServer(t, backend.URL)
+	conn, reader := dialWebSocket(t, server)
+	defer conn.Close()
+
+	writeClientFrame(t, conn, websocketOpText, `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	var types []string
+	for len(types) == 0 || types[len(types)-1] != "message_stop" {
+		opcode, message := readServerFrame(t, conn, reader)
+		if opcode != websocketOpText || !gjson.Valid(message) {
+			t.Fatalf("unexpected message (opcode %#x): %q", opcode, message)
+		}
+		types = append(types, gjson.Get(message, "type").String())
+		if len(types) > 16 {
+			t.Fatalf("no message_stop event: %v", types)
+		}
+	}
+	if types[0] != "message_start" {
+		t.Errorf("event types = %v, want message_start first", types)
+	}
+
+	// Requests on the same connection are handled in order.
+	writeClientFrame(t, conn, websocketOpText, `{"model":"claude-sonnet-4","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`)
+	writeClientFrame(t, conn, websocketOpText, `{"model":`)
+	if _, message := readServerFrame(t, conn, reader); gjson.Get(message, "type").String() != "message" || gjson.Get(message, "content.0.text").String() != "hi" {
+		t.Errorf("unexpected non-streaming response: %s", message)
+	}
+	if _, message := readServerFrame(t, conn, reader); gjson.Get(message, "error.type").String() != "invalid_request_error" {
+		t.Errorf("unexpected error response: %s", message)
+	}
+
+	writeClientFrame(t, conn, websocketOpPing, "ping")
+	if opcode, message := readServerFrame(t, conn, reader); opcode != websocketOpPong || message != "ping" {
+		t.Errorf("expected a pong echoing the ping, got opcode %#x: %q", opcode, message)
+	}
+	writeClientFrame(t, conn, websocketOpClose, "")
+	if opcode, _ := readServerFrame(t, conn, reader); opcode != websocketOpClose {
+		t.Errorf("expected the close handshake to be answered, got opcode %#x", opcode)
+	}
+}
+
+func TestOnMessagesWebSocket_DisconnectCancelsUpstream(t *testing.T) {
+	arrived := make(chan struct{})
+	canceled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body was read.
+		io.Copy(io.Discard, r.Body)
+		close(arrived)
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer backend.Close()
+	server := newWebSocketTestServer(t, backend.URL)
+	conn, _ := dialWebSocket(t, server)
+
+	writeClientFrame(t, conn, websocketOpText, `{"model":"claude-sonnet-4","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	select {
+	case <-arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not reach the upstream")
+	}
+	conn.Close()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the WebSocket did not cancel the upstream request")
+	}
+}
+
+func TestOnMessagesWebSocket_NotUpgrade(t *testing.T) {
+	w := httptest.NewRecorder()
+	onMessagesWebSocket(func(http.ResponseWriter, *http.Request) {
+		t.Error("messages handler called without an upgrade")
+	})(w, httptest.NewRequest(http.MethodGet, "/v1/messages/ws", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "WebSocket") {
+		t.Errorf("status = %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,92 @@
+package adapter
+
+import (
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+// MaxCacheBreakpoints is the number of cache_control breakpoints Anthropic accepts in a request.
+const MaxCacheBreakpoints = 4
+
+// AddCacheBreakpoints adds ephemeral cache_control breakpoints to the last block of the last
+// user message and to the last system block, unless they already have one, so that the prefix
+// of the conversation is cached even when the client places no breakpoint. Breakpoints already
+// in the request are kept and count towards MaxCacheBreakpoints, which is never exceeded; the
+// user message, which caches the longer prefix, is served first. It returns the number of
+// breakpoints added.
+func AddCacheBreakpoints(req *anthropic.GenerateMessageRequest) (added int) {
+	budget := MaxCacheBreakpoints - countCacheBreakpoints(req)
+	addTo := func(content *anthropic.MessageContent) {
+		if budget > 0 && content != nil && content.CacheControl == nil {
+			content.CacheControl = &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral}
+			budget--
+			added++
+		}
+	}
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if message := req.Messages[i]; message != nil && message.Role == anthropic.MessageRoleUser {
+			if !hasCacheBreakpoint(message.Content) {
+				addTo(lastCacheableContent(message.Content))
+			}
+			break
+		}
+	}
+	if !hasCacheBreakpoint(req.System) {
+		addTo(lastCacheableContent(req.System))
+	}
+	return added
+}
+
+// countCacheBreakpoints counts the cache_control breakpoints of the tools, system prompt and
+// messages of req.
+func countCacheBreakpoints(req *anthropic.GenerateMessageRequest) (count int) {
+	for _, tool := range req.Tools {
+		if tool != nil && tool.CacheControl != nil {
+			count++
+		}
+	}
+	count += countContentCacheBreakpoints(req.System)
+	for _, message := range req.Messages {
+		if message != nil {
+			count += countContentCacheBreakpoints(message.Content)
+		}
+	}
+	return count
+}
+
+func countContentCacheBreakpoints(contents anthropic.MessageContents) (count int) {
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+		if content.CacheControl != nil {
+			count++
+		}
+		count += countContentCacheBreakpoints(content.Content)
+	}
+	return count
+}
+
+func hasCacheBreakpoint(contents anthropic.MessageContents) bool {
+	return countContentCacheBreakpoints(contents) > 0
+}
+
+// lastCacheableContent returns the last block of contents that can carry cache_control: neither
+// a thinking block nor an empty text block, which Anthropic rejects breakpoints on.
+func lastCacheableContent(contents anthropic.MessageContents) *anthropic.MessageContent {
+	for i := len(contents) - 1; i >= 0; i-- {
+		content := contents[i]
+		if content == nil {
+			continue
+		}
+		switch content.Type {
+		case anthropic.MessageContentTypeThinking, anthropic.MessageContentTypeRedactedThinking:
+			continue
+		case anthropic.MessageContentTypeText:
+			if content.Text == "" {
+				continue
+			}
+		}
+		return content
+	}
+	return nil
+}
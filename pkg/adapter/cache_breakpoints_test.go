@@ -0,0 +1,92 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestAddCacheBreakpoints(t *testing.T) {
+	ephemeral := func() *anthropic.CacheControl {
+		return &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral}
+	}
+	text := func(text string) *anthropic.MessageContent {
+		return &anthropic.MessageContent{Type: anthropic.MessageContentTypeText, Text: text}
+	}
+
+	t.Run("adds system and last user message breakpoints", func(t *testing.T) {
+		req := &anthropic.GenerateMessageRequest{
+			System: anthropic.MessageContents{text("You are helpful."), text("Be brief.")},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{text("first")}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{text("answer")}},
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{text("second"), text("")}},
+			},
+		}
+		if added := AddCacheBreakpoints(req); added != 2 {
+			t.Fatalf("added %d breakpoints, want 2", added)
+		}
+		if req.System[0].CacheControl != nil || req.System[1].CacheControl == nil {
+			t.Error("the breakpoint should be on the last system block")
+		}
+		last := req.Messages[2].Content
+		if last[0].CacheControl == nil || last[1].CacheControl != nil {
+			t.Error("the breakpoint should be on the last non-empty block of the last user message")
+		}
+		if req.Messages[0].Content[0].CacheControl != nil || req.Messages[1].Content[0].CacheControl != nil {
+			t.Error("earlier messages should be left as they are")
+		}
+	})
+
+	t.Run("respects the breakpoint limit", func(t *testing.T) {
+		req := &anthropic.GenerateMessageRequest{
+			Tools: []*anthropic.Tool{
+				{Name: "a", CacheControl: ephemeral()},
+				{Name: "b", CacheControl: ephemeral()},
+			},
+			System: anthropic.MessageContents{text("system")},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{
+						{Type: anthropic.MessageContentTypeText, Text: "result", CacheControl: ephemeral()},
+					}},
+				}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{text("answer")}},
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{text("question")}},
+			},
+		}
+		if added := AddCacheBreakpoints(req); added != 1 {
+			t.Fatalf("added %d breakpoints, want 1", added)
+		}
+		if req.Messages[2].Content[0].CacheControl == nil {
+			t.Error("the only free breakpoint should go to the last user message")
+		}
+		if req.System[0].CacheControl != nil {
+			t.Error("the system prompt should not get a breakpoint past the limit")
+		}
+		if count := countCacheBreakpoints(req); count != MaxCacheBreakpoints {
+			t.Errorf("request has %d breakpoints, want %d", count, MaxCacheBreakpoints)
+		}
+	})
+
+	t.Run("keeps existing breakpoints", func(t *testing.T) {
+		req := &anthropic.GenerateMessageRequest{
+			System: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "system", CacheControl: &anthropic.CacheControl{
+				Type: anthropic.MessageCacheControlTypeEphemeral,
+				TTL:  anthropic.MessageCacheControlTTL1Hour,
+			}}},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeText, Text: "cached", CacheControl: ephemeral()},
+					text("question"),
+				}},
+			},
+		}
+		if added := AddCacheBreakpoints(req); added != 0 {
+			t.Errorf("added %d breakpoints, want 0", added)
+		}
+		if req.System[0].CacheControl.TTL != anthropic.MessageCacheControlTTL1Hour {
+			t.Error("the TTL of an existing breakpoint should be kept")
+		}
+	})
+}
@@ -0,0 +1,543 @@
+package adapter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/samber/lo"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+// ConvertAnthropicRequestToOpenAIRequest converts a Messages API request into a streaming OpenAI
+// Responses API request. Responses are not stored upstream, so reasoning is carried over between
+// turns as encrypted content: the thinking blocks of previous turns whose signature was packed by
+// ConvertOpenAIStreamToAnthropicStream are sent back as reasoning items, and other thinking blocks
+// are dropped. The system prompt becomes instructions, or leading input messages when it carries
+// cache control, as described by ConvertAnthropicSystemToOpenAIInput. Stop sequences and top_k are
+// not supported by the Responses API and are dropped.
+func ConvertAnthropicRequestToOpenAIRequest(
+	ctx context.Context,
+	src *anthropic.GenerateMessageRequest,
+	options ...ConvertRequestOption,
+) (dst *openai.CreateModelResponseRequest) {
+	prof, _ := profile.FromContext(ctx)
+	convertOptions := &ConvertRequestOptions{}
+	for _, applyOption := range options {
+		applyOption(convertOptions)
+	}
+	imageDetail := prof.Options.GetImageDetail()
+	if imageDetail == "" {
+		imageDetail = "auto"
+	}
+	dst = &openai.CreateModelResponseRequest{
+		Model:           src.Model,
+		MaxOutputTokens: lo.ToPtr(resolveMaxTokens(prof, src.MaxTokens, true)),
+		Input:           make([]*openai.ResponseInputItem, 0, len(src.Messages)),
+	}
+	if targetModel, ok := prof.Options.GetModels()[dst.Model]; ok {
+		dst.Model = targetModel
+	}
+	var effort openrouter.ChatCompletionReasoningEffort
+	if model, suffixEffort, ok := cutOpenRouterModelEffortSuffix(prof, openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1, dst.Model); ok {
+		dst.Model = model
+		effort = suffixEffort
+	}
+	if effort.IsEmpty() {
+		effort = openrouter.ChatCompletionReasoningEffort(prof.Options.GetReasoningEffort())
+	}
+	if !effort.IsEmpty() || (src.Thinking != nil && src.Thinking.Type == anthropic.ThinkingTypeEnabled) {
+		dst.Reasoning = &openai.ResponseReasoning{Effort: openai.ResponseReasoningEffort(effort)}
+	}
+	if prof.Options.GetDisableThinking() {
+		dst.Reasoning = nil
+		if effort := disabledThinkingEffort(dst.Model); effort != "" {
+			dst.Reasoning = &openai.ResponseReasoning{Effort: openai.ResponseReasoningEffort(effort)}
+		}
+	}
+	if dst.Reasoning != nil {
+		if !prof.Options.GetReasoningExclude() && !prof.Options.GetDisableThinking() {
+			dst.Reasoning.Summary = openai.ResponseReasoningSummaryModeAuto
+		}
+		dst.Include = []string{openai.IncludeReasoningEncryptedContent}
+	}
+	if dst.Reasoning == nil && !isOpenAIReasoningModel(dst.Model) {
+		// Reasoning models reject sampling parameters, and the Anthropic request omits a zero
+		// temperature, so only a temperature the client chose is forwarded.
+		if src.Temperature != 0 {
+			dst.Temperature = lo.ToPtr(min(max(src.Temperature, 0), 2))
+		}
+		if src.TopP != nil {
+			dst.TopP = lo.ToPtr(min(max(*src.TopP, 0), 1))
+		}
+	}
+	if metadata := src.Metadata; metadata != nil {
+		dst.User = metadata.UserID
+		dst.Metadata = ConvertAnthropicMetadataToOpenAI(metadata)
+	}
+	if dst.PromptCacheKey = prof.OpenAI.GetPromptCacheKey(); dst.PromptCacheKey == "" {
+		dst.PromptCacheKey = OpenAIPromptCacheKey(src)
+	}
+	dst.ServiceTier = string(prof.OpenAI.GetServiceTier())
+	if src.TopK != nil {
+		slog.Debug(fmt.Sprintf("dropping top_k=%d, which the Responses API does not support", *src.TopK))
+	}
+	if len(src.StopSequences) > 0 {
+		slog.Debug(fmt.Sprintf("dropping stop_sequences %q, which the Responses API does not support", src.StopSequences))
+	}
+	for _, srcTool := range src.Tools {
+		// A custom tool can omit the type parameter, so we consider a tool with a null type value to be a custom tool.
+		// reference: https://docs.anthropic.com/en/api/messages#custom-tool
+		if srcTool.Type != nil && *srcTool.Type != anthropic.ToolTypeCustom {
+			continue
+		}
+		parameters := []byte(srcTool.InputSchema)
+		if prof.Options.GetStrict() {
+			parameters = InlineJSONSchemaRefs(parameters)
+			if prof.Options.GetStrictSchemaSanitize() {
+				parameters = SanitizeStrictJSONSchema(parameters)
+			}
+		}
+		dst.Tools = append(dst.Tools, &openai.ResponseTool{
+			Type:        openai.ResponseToolTypeFunction,
+			Name:        srcTool.Name,
+			Description: srcTool.Description,
+			Parameters:  parameters,
+			Strict:      prof.Options.GetStrict(),
+		})
+	}
+	if len(dst.Tools) > 0 {
+		// Allowed tools the request does not declare are left out; the upstream rejects them.
+		allowedTools := lo.Filter(prof.OpenAI.GetAllowedTools(), func(name string, _ int) bool {
+			return lo.ContainsBy(dst.Tools, func(tool *openai.ResponseTool) bool { return tool.Name == name })
+		})
+		dst.ToolChoice = ConvertAnthropicToolChoiceToOpenAI(src.ToolChoice, allowedTools)
+		if src.ToolChoice != nil {
+			dst.ParallelToolCalls = lo.ToPtr(!src.ToolChoice.DisableParallelToolUse)
+		}
+	}
+	if system := injectSystemPrefixSuffix(src.System, prof.Options.GetSystemPrefix(), prof.Options.GetSystemSuffix()); len(system) > 0 {
+		var systemItems []*openai.ResponseInputItem
+		dst.Instructions, systemItems = ConvertAnthropicSystemToOpenAIInput(system, imageDetail)
+		dst.Input = append(dst.Input, systemItems...)
+	}
+	// message is the message item the next content part is appended to, so that consecutive parts
+	// of the same role share a message; items of other types end it.
+	var message *openai.ResponseInputItem
+	appendPart := func(role string, part *openai.ResponseInputContent) {
+		if message == nil || message.Role != role {
+			message = &openai.ResponseInputItem{
+				Type: openai.ResponseInputItemTypeMessage,
+				Role: role,
+			}
+			dst.Input = append(dst.Input, message)
+		}
+		message.Content = append(message.Content, part)
+	}
+	appendItems := func(items ...*openai.ResponseInputItem) {
+		dst.Input = append(dst.Input, items...)
+		message = nil
+	}
+	for _, srcMessage := range src.Messages {
+		role := string(srcMessage.Role)
+		textType := openai.ResponseInputContentTypeInputText
+		if srcMessage.Role == anthropic.MessageRoleAssistant {
+			textType = openai.ResponseInputContentTypeOutputText
+		}
+		for _, srcMessageContent := range srcMessage.Content {
+			if srcMessageContent == nil {
+				continue
+			}
+			switch srcMessageContent.Type {
+			case anthropic.MessageContentTypeText:
+				if srcMessageContent.Text == "" {
+					continue
+				}
+				appendPart(role, &openai.ResponseInputContent{
+					Type: textType,
+					Text: srcMessageContent.Text,
+				})
+			case anthropic.MessageContentTypeImage:
+				if srcMessage.Role != anthropic.MessageRoleUser {
+					continue
+				}
+				if imageURL := anthropicImageSourceToURL(srcMessageContent.Source); imageURL != "" {
+					appendPart(role, &openai.ResponseInputContent{
+						Type:     openai.ResponseInputContentTypeInputImage,
+						ImageURL: imageURL,
+						Detail:   imageDetail,
+					})
+				}
+			case anthropic.MessageContentTypeDocument:
+				if srcMessage.Role != anthropic.MessageRoleUser {
+					continue
+				}
+				if part := convertAnthropicDocumentToOpenAIInputContent(srcMessageContent); part != nil {
+					appendPart(role, part)
+				}
+			case anthropic.MessageContentTypeThinking:
+				// The reverse of encryptedReasoningSignature, for delimiters of any length.
+				id, encryptedContent, found := strings.Cut(srcMessageContent.Signature, prof.Options.GetReasoningDelimiter())
+				if !found || id == "" || encryptedContent == "" {
+					slog.Debug("dropping thinking block without OpenAI encrypted reasoning from conversation history")
+					continue
+				}
+				item := &openai.ResponseInputItem{
+					Type:             openai.ResponseInputItemTypeReasoning,
+					ID:               id,
+					EncryptedContent: encryptedContent,
+				}
+				if srcMessageContent.Thinking != "" {
+					item.Summary = []*openai.ResponseReasoningSummary{
+						{Type: openai.ResponseReasoningSummaryTypeSummaryText, Text: srcMessageContent.Thinking},
+					}
+				}
+				appendItems(item)
+			case anthropic.MessageContentTypeRedactedThinking:
+				slog.Debug("dropping redacted_thinking block from conversation history")
+			case anthropic.MessageContentTypeToolUse:
+				arguments := string(srcMessageContent.Input)
+				if arguments == "" {
+					arguments = "{}"
+				}
+				appendItems(&openai.ResponseInputItem{
+					Type:      openai.ResponseInputItemTypeFunctionCall,
+					CallID:    srcMessageContent.ID,
+					Name:      srcMessageContent.Name,
+					Arguments: arguments,
+				})
+			case anthropic.MessageContentTypeToolResult:
+				appendItems(ConvertAnthropicToolResultToOpenAIInputItems(srcMessageContent, imageDetail)...)
+			}
+		}
+	}
+	if prof.Options.GetSanitizeToolNames() {
+		names := convertOptions.ToolNames
+		if names == nil {
+			names = &ToolNames{}
+		}
+		renameOpenAIToolNames(dst, names)
+	}
+	return dst
+}
+
+// convertAnthropicDocumentToOpenAIInputContent converts a document block into an input part the
+// way convertAnthropicDocumentToOpenRouterContentPart does: base64 sources become input_file parts
+// encoded as data URLs, URL sources are passed by URL, and plain text sources become input_text
+// parts. Returns nil for other sources, which are dropped with a warning.
+func convertAnthropicDocumentToOpenAIInputContent(src *anthropic.MessageContent) *openai.ResponseInputContent {
+	source := src.Source
+	if source == nil {
+		return nil
+	}
+	filename := src.Title
+	if filename == "" {
+		filename = "document" + documentExtension(source.MediaType)
+	}
+	switch source.Type {
+	case anthropic.MessageContentSourceTypeBase64:
+		return &openai.ResponseInputContent{
+			Type:     openai.ResponseInputContentTypeInputFile,
+			Filename: filename,
+			FileData: fmt.Sprintf("data:%s;%s,%s", source.MediaType, source.Type, source.Data),
+		}
+	case anthropic.MessageContentSourceTypeURL:
+		return &openai.ResponseInputContent{
+			Type:    openai.ResponseInputContentTypeInputFile,
+			FileURL: source.URL,
+		}
+	case anthropic.MessageContentSourceTypeText:
+		return &openai.ResponseInputContent{
+			Type: openai.ResponseInputContentTypeInputText,
+			Text: source.Data,
+		}
+	}
+	slog.Warn(fmt.Sprintf("dropping document block with unsupported %q source", source.Type))
+	return nil
+}
+
+// ConvertAnthropicToolResultToOpenAIInputItems converts a tool_result block into OpenAI Responses
+// input items. The Responses API represents function outputs as strings, so:
+//   - text parts are joined with newlines into the function_call_output;
+//   - images cannot be part of that output; each image becomes an input_image part of a single user
+//     message that directly follows the function_call_output and names the call it belongs to, and the
+//     output mentions how many images were attached so the model knows to look for them.
+//
+// Image order is preserved, and base64 sources are encoded as data URLs. Every image gets imageDetail,
+// the profile's image_detail, as its detail level, or "auto" when it is empty. A tool_result with
+// is_error set has its output preceded by ToolResultErrorText.
+func ConvertAnthropicToolResultToOpenAIInputItems(toolResult *anthropic.MessageContent, imageDetail string) []*openai.ResponseInputItem {
+	if imageDetail == "" {
+		imageDetail = "auto"
+	}
+	var (
+		texts  []string
+		images []*openai.ResponseInputContent
+	)
+	if toolResult.IsError {
+		texts = append(texts, ToolResultErrorText)
+	}
+	for _, content := range toolResult.Content {
+		if content == nil {
+			continue
+		}
+		switch content.Type {
+		case anthropic.MessageContentTypeText:
+			texts = append(texts, content.Text)
+		case anthropic.MessageContentTypeImage:
+			if imageURL := anthropicImageSourceToURL(content.Source); imageURL != "" {
+				images = append(images, &openai.ResponseInputContent{
+					Type:     openai.ResponseInputContentTypeInputImage,
+					ImageURL: imageURL,
+					Detail:   imageDetail,
+				})
+			}
+		}
+	}
+	if len(images) > 0 {
+		texts = append(texts, fmt.Sprintf("[%d image(s) returned by this tool call are attached in the next message]", len(images)))
+	}
+	items := []*openai.ResponseInputItem{
+		{
+			Type:   openai.ResponseInputItemTypeFunctionCallOutput,
+			CallID: toolResult.ToolUseID,
+			Output: strings.Join(texts, "\n"),
+		},
+	}
+	if len(images) > 0 {
+		content := make([]*openai.ResponseInputContent, 0, len(images)+1)
+		content = append(content, &openai.ResponseInputContent{
+			Type: openai.ResponseInputContentTypeInputText,
+			Text: fmt.Sprintf("Images returned by tool call %s:", toolResult.ToolUseID),
+		})
+		content = append(content, images...)
+		items = append(items, &openai.ResponseInputItem{
+			Type:    openai.ResponseInputItemTypeMessage,
+			Role:    "user",
+			Content: content,
+		})
+	}
+	return items
+}
+
+// ConvertAnthropicSystemToOpenAIInput converts the system prompt of a request for the Responses
+// API. The leading text blocks are flattened into instructions, joined with newlines. Instructions
+// cannot carry cache control, so a system prompt with a cache_control breakpoint is instead
+// returned as input messages, which stay at the start of the input, where prompt caching (keyed by
+// OpenAIPromptCacheKey) applies to them. Instructions cannot carry images either, so the blocks
+// from the first image on are returned as input messages too: runs of text blocks become
+// system-role messages, one input_text part per block, and runs of images become user-role
+// messages, since OpenAI only accepts images from the user; the order of the blocks is kept.
+// Images get imageDetail as their detail level, or "auto" when it is empty. Other blocks are
+// dropped.
+func ConvertAnthropicSystemToOpenAIInput(system anthropic.MessageContents, imageDetail string) (instructions string, items []*openai.ResponseInputItem) {
+	if imageDetail == "" {
+		imageDetail = "auto"
+	}
+	var (
+		blocks []*anthropic.MessageContent
+		cached bool
+	)
+	for _, content := range system {
+		if content == nil {
+			continue
+		}
+		switch content.Type {
+		case anthropic.MessageContentTypeText:
+		case anthropic.MessageContentTypeImage:
+			if anthropicImageSourceToURL(content.Source) == "" {
+				continue
+			}
+		default:
+			continue
+		}
+		blocks = append(blocks, content)
+		cached = cached || content.CacheControl != nil
+	}
+	prefix := 0
+	if !cached {
+		for prefix < len(blocks) && blocks[prefix].Type == anthropic.MessageContentTypeText {
+			prefix++
+		}
+	}
+	texts := make([]string, 0, prefix)
+	for _, content := range blocks[:prefix] {
+		texts = append(texts, content.Text)
+	}
+	instructions = strings.Join(texts, "\n")
+	var message *openai.ResponseInputItem
+	for _, content := range blocks[prefix:] {
+		role := "system"
+		part := &openai.ResponseInputContent{
+			Type: openai.ResponseInputContentTypeInputText,
+			Text: content.Text,
+		}
+		if content.Type == anthropic.MessageContentTypeImage {
+			role = "user"
+			part = &openai.ResponseInputContent{
+				Type:     openai.ResponseInputContentTypeInputImage,
+				ImageURL: anthropicImageSourceToURL(content.Source),
+				Detail:   imageDetail,
+			}
+		}
+		if message == nil || message.Role != role {
+			message = &openai.ResponseInputItem{
+				Type: openai.ResponseInputItemTypeMessage,
+				Role: role,
+			}
+			items = append(items, message)
+		}
+		message.Content = append(message.Content, part)
+	}
+	return instructions, items
+}
+
+// ConvertAnthropicToolChoiceToOpenAI converts the tool_choice of a request for the Responses API.
+// "auto", "none" and "any" map to the "auto", "none" and "required" modes, and a "tool" choice
+// forces that function. allowedTools restricts an "auto" or "any" choice to a subset of the
+// request's tools through an allowed_tools choice, which keeps the other tool definitions in the
+// request, and so its prompt cache prefix, unchanged. nil is returned for a nil tool_choice
+// without a subset, leaving the upstream default.
+func ConvertAnthropicToolChoiceToOpenAI(toolChoice *anthropic.ToolChoice, allowedTools []string) *openai.ResponseToolChoice {
+	if toolChoice == nil && len(allowedTools) == 0 {
+		return nil
+	}
+	mode := openai.ResponseToolChoiceModeAuto
+	if toolChoice != nil {
+		switch toolChoice.Type {
+		case anthropic.ToolChoiceTypeNone:
+			return &openai.ResponseToolChoice{Mode: openai.ResponseToolChoiceModeNone}
+		case anthropic.ToolChoiceTypeTool:
+			return &openai.ResponseToolChoice{Type: openai.ResponseToolChoiceTypeFunction, Name: toolChoice.Name}
+		case anthropic.ToolChoiceTypeAny:
+			mode = openai.ResponseToolChoiceModeRequired
+		}
+	}
+	if len(allowedTools) == 0 {
+		return &openai.ResponseToolChoice{Mode: mode}
+	}
+	choice := &openai.ResponseToolChoice{
+		Type:  openai.ResponseToolChoiceTypeAllowedTools,
+		Mode:  mode,
+		Tools: make([]*openai.ResponseToolChoiceAllowedTool, 0, len(allowedTools)),
+	}
+	for _, name := range allowedTools {
+		choice.Tools = append(choice.Tools, &openai.ResponseToolChoiceAllowedTool{
+			Type: string(openai.ResponseToolChoiceTypeFunction),
+			Name: name,
+		})
+	}
+	return choice
+}
+
+// Limits of the metadata of OpenAI requests, which OpenRouter applies to chat completions too.
+const (
+	OpenAIMaxMetadataPairs       = 16
+	OpenAIMaxMetadataKeyLength   = 64
+	OpenAIMaxMetadataValueLength = 512
+)
+
+// ConvertAnthropicMetadataToOpenAI converts the metadata keys of a request other than user_id,
+// which becomes the user of the request instead, into OpenAI metadata. Metadata values are
+// strings, so string values are kept and other JSON values are encoded; null values are dropped.
+// Keys longer than OpenAIMaxMetadataKeyLength are dropped and values are truncated to
+// OpenAIMaxMetadataValueLength characters. At most OpenAIMaxMetadataPairs keys are kept, in
+// lexical order. nil is returned when there is no metadata to forward.
+func ConvertAnthropicMetadataToOpenAI(metadata *anthropic.Metadata) map[string]string {
+	if metadata == nil || len(metadata.Extra) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(metadata.Extra))
+	for key := range metadata.Extra {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	converted := make(map[string]string, min(len(keys), OpenAIMaxMetadataPairs))
+	var dropped []string
+	for _, key := range keys {
+		raw := metadata.Extra[key]
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		if key == "" || utf8.RuneCountInString(key) > OpenAIMaxMetadataKeyLength || len(converted) == OpenAIMaxMetadataPairs {
+			dropped = append(dropped, key)
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, raw); err != nil {
+				dropped = append(dropped, key)
+				continue
+			}
+			value = compact.String()
+		}
+		if utf8.RuneCountInString(value) > OpenAIMaxMetadataValueLength {
+			value = string([]rune(value)[:OpenAIMaxMetadataValueLength])
+		}
+		converted[key] = value
+	}
+	if len(dropped) > 0 {
+		slog.Warn(fmt.Sprintf("dropping metadata keys the upstream does not accept: %q", dropped))
+	}
+	if len(converted) == 0 {
+		return nil
+	}
+	return converted
+}
+
+func anthropicImageSourceToURL(source *anthropic.MessageContentSource) string {
+	if source == nil {
+		return ""
+	}
+	if source.Type == anthropic.MessageContentSourceTypeURL {
+		return source.URL
+	}
+	return fmt.Sprintf("data:%s;%s,%s", source.MediaType, source.Type, source.Data)
+}
+
+// OpenAIPromptCacheKey derives the prompt_cache_key of a Responses API request from the stable
+// prefix of req: its system prompt and tool definitions. Requests of the same agentic loop share
+// that prefix, so they get the same key and are routed to the same prompt cache. Cache control
+// markers are not part of the prefix, since clients move them between requests. An empty string
+// is returned when req has neither a system prompt nor tools.
+func OpenAIPromptCacheKey(req *anthropic.GenerateMessageRequest) string {
+	if len(req.System) == 0 && len(req.Tools) == 0 {
+		return ""
+	}
+	hash := sha256.New()
+	for _, system := range req.System {
+		if system == nil || system.Type != anthropic.MessageContentTypeText {
+			continue
+		}
+		fmt.Fprintf(hash, "system:%d:%s\n", len(system.Text), system.Text)
+	}
+	for _, tool := range req.Tools {
+		if tool == nil {
+			continue
+		}
+		var toolType anthropic.ToolType
+		if tool.Type != nil {
+			toolType = *tool.Type
+		}
+		schema, _ := json.Marshal(tool.InputSchema)
+		fmt.Fprintf(hash, "tool:%s:%d:%s:%d:%s:%d:%s\n",
+			toolType,
+			len(tool.Name), tool.Name,
+			len(tool.Description), tool.Description,
+			len(schema), schema,
+		)
+	}
+	return "cca-" + hex.EncodeToString(hash.Sum(nil))[:32]
+}
@@ -0,0 +1,890 @@
+package adapter
+
+import (
+	"encoding/json"
+	"maps"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+func TestConvertAnthropicRequestToOpenAIRequest(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:         "gpt-5",
+		MaxTokens:     1024,
+		Temperature:   0.5,
+		StopSequences: []string{"STOP"},
+		System: anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are helpful."},
+			{Type: anthropic.MessageContentTypeText, Text: "Be brief."},
+		},
+		Metadata: &anthropic.Metadata{UserID: "user-1"},
+		Tools: []*anthropic.Tool{
+			{Name: "mcp.read", Description: "Read a file", InputSchema: json.RawMessage(`{"type":"object"}`)},
+		},
+		ToolChoice: &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeAny, DisableParallelToolUse: true},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeText, Text: "Read a.txt"},
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+					Type: "base64", MediaType: "image/png", Data: "AAAA",
+				}},
+			}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeThinking, Thinking: "I should read it.", Signature: "rs_1/ENCRYPTED"},
+				{Type: anthropic.MessageContentTypeThinking, Thinking: "Not from OpenAI.", Signature: "c2lnbmF0dXJl"},
+				{Type: anthropic.MessageContentTypeText, Text: "Reading."},
+				{Type: anthropic.MessageContentTypeToolUse, ID: "call_1", Name: "mcp.read", Input: json.RawMessage(`{"path":"a.txt"}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "call_1", Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeText, Text: "no such file"},
+				}},
+			}},
+		},
+	}
+	names := &ToolNames{}
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.SanitizeToolNames = true
+	}), src, RecordToolNames(names))
+	if dst.Model != "gpt-5" || dst.MaxOutputTokens == nil || *dst.MaxOutputTokens != 1024 {
+		t.Errorf("model = %q, max_output_tokens = %v", dst.Model, dst.MaxOutputTokens)
+	}
+	if dst.Temperature == nil || *dst.Temperature != 0.5 || dst.TopP != nil {
+		t.Errorf("temperature = %v, top_p = %v", dst.Temperature, dst.TopP)
+	}
+	if dst.Instructions != "You are helpful.\nBe brief." {
+		t.Errorf("instructions = %q", dst.Instructions)
+	}
+	if dst.User != "user-1" || dst.Store || dst.Reasoning != nil || len(dst.Include) != 0 {
+		t.Errorf("user = %q, store = %v, reasoning = %+v, include = %q", dst.User, dst.Store, dst.Reasoning, dst.Include)
+	}
+	if len(dst.Tools) != 1 || dst.Tools[0].Name != "mcp_read" || dst.Tools[0].Type != openai.ResponseToolTypeFunction {
+		t.Fatalf("unexpected tools: %+v", dst.Tools)
+	}
+	if dst.ToolChoice == nil || dst.ToolChoice.Mode != openai.ResponseToolChoiceModeRequired {
+		t.Errorf("unexpected tool_choice: %+v", dst.ToolChoice)
+	}
+	if dst.ParallelToolCalls == nil || *dst.ParallelToolCalls {
+		t.Errorf("parallel_tool_calls = %v, want false", dst.ParallelToolCalls)
+	}
+	if got := names.Original("mcp_read"); got != "mcp.read" {
+		t.Errorf("recorded tool name = %q", got)
+	}
+	wantTypes := []openai.ResponseInputItemType{
+		openai.ResponseInputItemTypeMessage,
+		openai.ResponseInputItemTypeReasoning,
+		openai.ResponseInputItemTypeMessage,
+		openai.ResponseInputItemTypeFunctionCall,
+		openai.ResponseInputItemTypeFunctionCallOutput,
+	}
+	if len(dst.Input) != len(wantTypes) {
+		t.Fatalf("expected %d input items, got %d", len(wantTypes), len(dst.Input))
+	}
+	for i, item := range dst.Input {
+		if item.Type != wantTypes[i] {
+			t.Errorf("input[%d].type = %q, want %q", i, item.Type, wantTypes[i])
+		}
+	}
+	if user := dst.Input[0]; user.Role != "user" || len(user.Content) != 2 ||
+		user.Content[1].Type != openai.ResponseInputContentTypeInputImage || user.Content[1].ImageURL != "data:image/png;base64,AAAA" {
+		t.Errorf("unexpected user message: %+v", user)
+	}
+	if reasoning := dst.Input[1]; reasoning.ID != "rs_1" || reasoning.EncryptedContent != "ENCRYPTED" ||
+		len(reasoning.Summary) != 1 || reasoning.Summary[0].Text != "I should read it." {
+		t.Errorf("unexpected reasoning item: %+v", reasoning)
+	}
+	if assistant := dst.Input[2]; assistant.Role != "assistant" || len(assistant.Content) != 1 ||
+		assistant.Content[0].Type != openai.ResponseInputContentTypeOutputText {
+		t.Errorf("unexpected assistant message: %+v", assistant)
+	}
+	if call := dst.Input[3]; call.CallID != "call_1" || call.Name != "mcp_read" || call.Arguments != `{"path":"a.txt"}` {
+		t.Errorf("unexpected function_call: %+v", call)
+	}
+	if output := dst.Input[4]; output.CallID != "call_1" || output.Output != "no such file" {
+		t.Errorf("unexpected function_call_output: %+v", output)
+	}
+	body, err := json.Marshal(dst)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(body), `"stream":true`) || strings.Contains(string(body), "STOP") {
+		t.Errorf("unexpected request body: %s", body)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_CachedSystem(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		System: anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are a coding agent."},
+			{Type: anthropic.MessageContentTypeText, Text: "Project rules.", CacheControl: &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral}},
+		},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	}
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtx(), src)
+	if dst.Instructions != "" {
+		t.Errorf("instructions = %q, want the cached system prompt kept in the input", dst.Instructions)
+	}
+	if len(dst.Input) != 2 {
+		t.Fatalf("expected 2 input items, got %d", len(dst.Input))
+	}
+	system := dst.Input[0]
+	if system.Type != openai.ResponseInputItemTypeMessage || system.Role != "system" || len(system.Content) != 2 {
+		t.Fatalf("unexpected system message: %+v", system)
+	}
+	for i, want := range []string{"You are a coding agent.", "Project rules."} {
+		if part := system.Content[i]; part.Type != openai.ResponseInputContentTypeInputText || part.Text != want {
+			t.Errorf("system part %d = %+v, want %q", i, part, want)
+		}
+	}
+	if user := dst.Input[1]; user.Role != "user" {
+		t.Errorf("the conversation should follow the system message, got %+v", user)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_SystemTextImageText(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		System: anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are a designer."},
+			{Type: anthropic.MessageContentTypeText, Text: "Follow the style guide."},
+			{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+				Type: anthropic.MessageContentSourceTypeBase64, MediaType: "image/png", Data: "AAAA",
+			}},
+			{Type: anthropic.MessageContentTypeText, Text: "The image above is the style guide."},
+		},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	}
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtx(), src)
+	if want := "You are a designer.\nFollow the style guide."; dst.Instructions != want {
+		t.Errorf("instructions = %q, want %q", dst.Instructions, want)
+	}
+	// the image, the text after it, then the conversation
+	if len(dst.Input) != 3 {
+		t.Fatalf("expected 3 input items, got %d", len(dst.Input))
+	}
+	if image := dst.Input[0]; image.Role != "user" || len(image.Content) != 1 ||
+		image.Content[0].Type != openai.ResponseInputContentTypeInputImage || image.Content[0].ImageURL != "data:image/png;base64,AAAA" {
+		t.Errorf("unexpected image message: %+v", image)
+	}
+	if text := dst.Input[1]; text.Role != "system" || len(text.Content) != 1 || text.Content[0].Text != "The image above is the style guide." {
+		t.Errorf("unexpected system message: %+v", text)
+	}
+	if user := dst.Input[2]; user.Role != "user" || len(user.Content) != 1 || user.Content[0].Text != "hi" {
+		t.Errorf("the conversation should follow the system prompt, got %+v", user)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_AllowedTools(t *testing.T) {
+	newRequest := func(toolChoice *anthropic.ToolChoice) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "gpt-5",
+			MaxTokens: 1024,
+			Tools: []*anthropic.Tool{
+				{Name: "Read", InputSchema: json.RawMessage(`{"type":"object"}`)},
+				{Name: "Write", InputSchema: json.RawMessage(`{"type":"object"}`)},
+				{Name: "mcp.grep", InputSchema: json.RawMessage(`{"type":"object"}`)},
+			},
+			ToolChoice: toolChoice,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+			},
+		}
+	}
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.SanitizeToolNames = true
+		p.OpenAI = &profile.OpenAIConfig{AllowedTools: []string{"Read", "mcp.grep", "Bash"}}
+	})
+	t.Run("any", func(t *testing.T) {
+		dst := ConvertAnthropicRequestToOpenAIRequest(ctx, newRequest(&anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeAny}))
+		if len(dst.Tools) != 3 {
+			t.Errorf("every tool should stay declared, got %d", len(dst.Tools))
+		}
+		choice := dst.ToolChoice
+		if choice == nil || choice.Type != openai.ResponseToolChoiceTypeAllowedTools || choice.Mode != openai.ResponseToolChoiceModeRequired {
+			t.Fatalf("unexpected tool_choice: %+v", choice)
+		}
+		var names []string
+		for _, tool := range choice.Tools {
+			names = append(names, tool.Name)
+		}
+		if strings.Join(names, ",") != "Read,mcp_grep" {
+			t.Errorf("allowed tools = %q, want the declared subset under upstream names", names)
+		}
+	})
+	t.Run("no tool_choice", func(t *testing.T) {
+		dst := ConvertAnthropicRequestToOpenAIRequest(ctx, newRequest(nil))
+		if choice := dst.ToolChoice; choice == nil || choice.Type != openai.ResponseToolChoiceTypeAllowedTools || choice.Mode != openai.ResponseToolChoiceModeAuto {
+			t.Errorf("unexpected tool_choice: %+v", choice)
+		}
+	})
+	t.Run("forced tool", func(t *testing.T) {
+		dst := ConvertAnthropicRequestToOpenAIRequest(ctx, newRequest(&anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeTool, Name: "Write"}))
+		if choice := dst.ToolChoice; choice == nil || choice.Type != openai.ResponseToolChoiceTypeFunction || choice.Name != "Write" {
+			t.Errorf("unexpected tool_choice: %+v", choice)
+		}
+	})
+	t.Run("no declared tool allowed", func(t *testing.T) {
+		dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+			p.OpenAI = &profile.OpenAIConfig{AllowedTools: []string{"Bash"}}
+		}), newRequest(nil))
+		if dst.ToolChoice != nil {
+			t.Errorf("tool_choice = %+v, want the upstream default", dst.ToolChoice)
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ToolResultImages(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolUse, ID: "call_1", Name: "screenshot", Input: json.RawMessage(`{}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "call_1", Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeText, Text: "Captured 2 screenshots"},
+					{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+						Type: anthropic.MessageContentSourceTypeBase64, MediaType: "image/png", Data: "AAAA",
+					}},
+					{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+						Type: anthropic.MessageContentSourceTypeURL, URL: "https://example.com/b.jpg",
+					}},
+				}},
+				{Type: anthropic.MessageContentTypeText, Text: "What do you see?"},
+			}},
+		},
+	}
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.ImageDetail = "high"
+	}), src)
+	// function_call, function_call_output, the images of the tool call, then the user's question
+	if len(dst.Input) != 4 {
+		t.Fatalf("expected 4 input items, got %d", len(dst.Input))
+	}
+	if output := dst.Input[1]; output.Type != openai.ResponseInputItemTypeFunctionCallOutput || output.CallID != "call_1" ||
+		!strings.HasPrefix(output.Output, "Captured 2 screenshots\n") {
+		t.Errorf("unexpected function_call_output: %+v", output)
+	}
+	images := dst.Input[2]
+	if images.Role != "user" || len(images.Content) != 3 {
+		t.Fatalf("unexpected image message: %+v", images)
+	}
+	for i, want := range []string{"data:image/png;base64,AAAA", "https://example.com/b.jpg"} {
+		if part := images.Content[i+1]; part.Type != openai.ResponseInputContentTypeInputImage || part.ImageURL != want || part.Detail != "high" {
+			t.Errorf("image %d = %+v, want %s", i, part, want)
+		}
+	}
+	if question := dst.Input[3]; question.Role != "user" || len(question.Content) != 1 || question.Content[0].Text != "What do you see?" {
+		t.Errorf("the question should be a message of its own, got %+v", question)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_PromptCacheKey(t *testing.T) {
+	newRequest := func(system, question string) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "gpt-5",
+			MaxTokens: 1024,
+			System:    anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: system}},
+			Tools: []*anthropic.Tool{
+				{Name: "Read", InputSchema: json.RawMessage(`{"type":"object"}`)},
+			},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: question}}},
+			},
+		}
+	}
+	first := ConvertAnthropicRequestToOpenAIRequest(testCtx(), newRequest("You are a coding agent.", "Read a.txt"))
+	second := ConvertAnthropicRequestToOpenAIRequest(testCtx(), newRequest("You are a coding agent.", "Now read b.txt"))
+	if first.PromptCacheKey == "" || first.PromptCacheKey != second.PromptCacheKey {
+		t.Errorf("keys = %q and %q, want the same key for the same prefix", first.PromptCacheKey, second.PromptCacheKey)
+	}
+	other := ConvertAnthropicRequestToOpenAIRequest(testCtx(), newRequest("You are a reviewer.", "Read a.txt"))
+	if other.PromptCacheKey == first.PromptCacheKey {
+		t.Errorf("a different system prompt should get a different key, got %q", other.PromptCacheKey)
+	}
+	explicit := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.OpenAI = &profile.OpenAIConfig{PromptCacheKey: "team-cache"}
+	}), newRequest("You are a coding agent.", "Read a.txt"))
+	if explicit.PromptCacheKey != "team-cache" {
+		t.Errorf("prompt_cache_key = %q, want the configured key", explicit.PromptCacheKey)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_TopK(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtx(), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-4.1",
+		MaxTokens: 1024,
+		TopK:      lo.ToPtr(40),
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	})
+	body, err := json.Marshal(dst)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	if gjson.GetBytes(body, "top_k").Exists() {
+		t.Errorf("top_k should not be sent to the Responses API: %s", body)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ServiceTier(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	}
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.OpenAI = &profile.OpenAIConfig{ServiceTier: openrouter.ServiceTierFlex}
+	}), src)
+	body, err := json.Marshal(dst)
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	if got := gjson.GetBytes(body, "service_tier").String(); got != "flex" {
+		t.Errorf("service_tier = %q, want flex", got)
+	}
+	body, err = json.Marshal(ConvertAnthropicRequestToOpenAIRequest(testCtx(), src))
+	if err != nil {
+		t.Fatalf("marshal request: %v", err)
+	}
+	if gjson.GetBytes(body, "service_tier").Exists() {
+		t.Errorf("service_tier should be omitted when unset: %s", body)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_Reasoning(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:       "gpt-5",
+		MaxTokens:   1024,
+		Temperature: 0.5,
+		Thinking:    &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 512},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	}
+	t.Run("thinking enabled", func(t *testing.T) {
+		dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithReasoningFormat("openai-responses-v1", "high"), src)
+		if dst.Reasoning == nil || dst.Reasoning.Effort != openai.ResponseReasoningEffortHigh || dst.Reasoning.Summary != openai.ResponseReasoningSummaryModeAuto {
+			t.Errorf("unexpected reasoning: %+v", dst.Reasoning)
+		}
+		if len(dst.Include) != 1 || dst.Include[0] != openai.IncludeReasoningEncryptedContent {
+			t.Errorf("include = %q", dst.Include)
+		}
+		if dst.Temperature != nil {
+			t.Errorf("temperature = %v, want it dropped for reasoning", *dst.Temperature)
+		}
+	})
+	t.Run("disable thinking", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.DisableThinking = true
+		})
+		for _, tc := range []struct {
+			model      string
+			wantEffort openai.ResponseReasoningEffort
+		}{
+			{"gpt-5", openai.ResponseReasoningEffortMinimal},
+			{"o3-mini", openai.ResponseReasoningEffortLow},
+			{"gpt-4o", ""},
+			{"gpt-4.1", ""},
+		} {
+			src := *src
+			src.Model = tc.model
+			dst := ConvertAnthropicRequestToOpenAIRequest(ctx, &src)
+			if tc.wantEffort == "" {
+				if dst.Reasoning != nil || len(dst.Include) != 0 {
+					t.Errorf("%s: reasoning = %+v, include = %q, want neither", tc.model, dst.Reasoning, dst.Include)
+				}
+				continue
+			}
+			if dst.Reasoning == nil || dst.Reasoning.Effort != tc.wantEffort || dst.Reasoning.Summary != "" {
+				t.Errorf("%s: unexpected reasoning: %+v", tc.model, dst.Reasoning)
+			}
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_Documents(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtx(), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeDocument, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeBase64, MediaType: "application/pdf", Data: "JVBERi0=",
+				}},
+				{Type: anthropic.MessageContentTypeDocument, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeURL, URL: "https://example.com/a.pdf",
+				}},
+				{Type: anthropic.MessageContentTypeDocument, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeText, MediaType: "text/plain", Data: "plain notes",
+				}},
+				{Type: anthropic.MessageContentTypeText, Text: "Summarize these."},
+			}},
+		},
+	})
+	if len(dst.Input) != 1 || len(dst.Input[0].Content) != 4 {
+		t.Fatalf("unexpected input: %+v", dst.Input)
+	}
+	content := dst.Input[0].Content
+	if content[0].Type != openai.ResponseInputContentTypeInputFile || content[0].Filename != "document.pdf" ||
+		content[0].FileData != "data:application/pdf;base64,JVBERi0=" {
+		t.Errorf("unexpected base64 document: %+v", content[0])
+	}
+	if content[1].Type != openai.ResponseInputContentTypeInputFile || content[1].FileURL != "https://example.com/a.pdf" {
+		t.Errorf("unexpected URL document: %+v", content[1])
+	}
+	if content[2].Type != openai.ResponseInputContentTypeInputText || content[2].Text != "plain notes" {
+		t.Errorf("unexpected text document: %+v", content[2])
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ContextWindowResizeFactor(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.ContextWindowResizeFactor = 0.5
+	}), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1000,
+	})
+	if dst.MaxOutputTokens == nil || *dst.MaxOutputTokens != 500 {
+		t.Errorf("max_output_tokens = %v, want 500", dst.MaxOutputTokens)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_DefaultMaxTokensAndCap(t *testing.T) {
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.DefaultMaxTokens = 4096
+		p.Options.MaxTokensCap = 8192
+	})
+	for _, tc := range []struct {
+		maxTokens int
+		want      int
+	}{
+		{0, 4096},
+		{1000, 1000},
+		{64000, 8192},
+	} {
+		dst := ConvertAnthropicRequestToOpenAIRequest(ctx, &anthropic.GenerateMessageRequest{
+			Model:     "gpt-5",
+			MaxTokens: tc.maxTokens,
+		})
+		if dst.MaxOutputTokens == nil || *dst.MaxOutputTokens != tc.want {
+			t.Errorf("max_tokens %d: max_output_tokens = %v, want %d", tc.maxTokens, dst.MaxOutputTokens, tc.want)
+		}
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_StrictSchemaSanitize(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.Strict = true
+		p.Options.StrictSchemaSanitize = true
+	}), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		Tools: []*anthropic.Tool{
+			{Name: "read", InputSchema: json.RawMessage(`{"type":"object","properties":{"path":{"$ref":"#/$defs/path"}},"$defs":{"path":{"type":"string","format":"uri"}}}`)},
+		},
+	})
+	if len(dst.Tools) != 1 || !dst.Tools[0].Strict {
+		t.Fatalf("unexpected tools: %+v", dst.Tools)
+	}
+	if got := string(dst.Tools[0].Parameters); strings.Contains(got, "format") || strings.Contains(got, "$ref") ||
+		!strings.Contains(got, `"additionalProperties":false`) {
+		t.Errorf("parameters = %s, want a sanitized schema with inlined references", got)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_SystemPrefixSuffix(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.SystemPrefix = "prefix"
+		p.Options.SystemSuffix = "suffix"
+	}), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		System:    anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "You are helpful."}},
+	})
+	if dst.Instructions != "prefix\nYou are helpful.\nsuffix" {
+		t.Errorf("instructions = %q", dst.Instructions)
+	}
+}
+
+func TestConvertAnthropicToolResultToOpenAIInputItems(t *testing.T) {
+	t.Run("two images", func(t *testing.T) {
+		items := ConvertAnthropicToolResultToOpenAIInputItems(&anthropic.MessageContent{
+			Type:      anthropic.MessageContentTypeToolResult,
+			ToolUseID: "call_1",
+			Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeText, Text: "Captured 2 screenshots"},
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeBase64, MediaType: "image/png", Data: "AAAA",
+				}},
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeURL, URL: "https://example.com/b.jpg",
+				}},
+			},
+		}, "low")
+		if len(items) != 2 {
+			t.Fatalf("expected 2 items, got %d", len(items))
+		}
+		output := items[0]
+		if output.Type != openai.ResponseInputItemTypeFunctionCallOutput || output.CallID != "call_1" {
+			t.Fatalf("unexpected first item: %+v", output)
+		}
+		if want := "Captured 2 screenshots\n[2 image(s) returned by this tool call are attached in the next message]"; output.Output != want {
+			t.Errorf("output = %q, want %q", output.Output, want)
+		}
+		message := items[1]
+		if message.Type != openai.ResponseInputItemTypeMessage || message.Role != "user" {
+			t.Fatalf("unexpected second item: %+v", message)
+		}
+		if len(message.Content) != 3 {
+			t.Fatalf("expected 3 content parts, got %d", len(message.Content))
+		}
+		if message.Content[0].Type != openai.ResponseInputContentTypeInputText || message.Content[0].Text != "Images returned by tool call call_1:" {
+			t.Errorf("unexpected leading text: %+v", message.Content[0])
+		}
+		wantURLs := []string{"data:image/png;base64,AAAA", "https://example.com/b.jpg"}
+		for i, wantURL := range wantURLs {
+			part := message.Content[i+1]
+			if part.Type != openai.ResponseInputContentTypeInputImage || part.ImageURL != wantURL || part.Detail != "low" {
+				t.Errorf("image %d = %+v, want input_image %s with low detail", i, part, wantURL)
+			}
+		}
+	})
+
+	t.Run("text only", func(t *testing.T) {
+		items := ConvertAnthropicToolResultToOpenAIInputItems(&anthropic.MessageContent{
+			Type:      anthropic.MessageContentTypeToolResult,
+			ToolUseID: "call_2",
+			Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeText, Text: "line 1"},
+				{Type: anthropic.MessageContentTypeText, Text: "line 2"},
+			},
+		}, "")
+		if len(items) != 1 {
+			t.Fatalf("expected 1 item, got %d", len(items))
+		}
+		if items[0].Output != "line 1\nline 2" {
+			t.Errorf("output = %q", items[0].Output)
+		}
+	})
+
+	t.Run("is_error", func(t *testing.T) {
+		items := ConvertAnthropicToolResultToOpenAIInputItems(&anthropic.MessageContent{
+			Type:      anthropic.MessageContentTypeToolResult,
+			ToolUseID: "call_3",
+			IsError:   true,
+			Content:   anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "ENOENT: no such file"}},
+		}, "")
+		if want := ToolResultErrorText + "\nENOENT: no such file"; len(items) != 1 || items[0].Output != want {
+			t.Errorf("output = %q, want %q", items[0].Output, want)
+		}
+	})
+
+	t.Run("default detail", func(t *testing.T) {
+		items := ConvertAnthropicToolResultToOpenAIInputItems(&anthropic.MessageContent{
+			Type:      anthropic.MessageContentTypeToolResult,
+			ToolUseID: "call_4",
+			Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeURL, URL: "https://example.com/c.jpg",
+				}},
+			},
+		}, "")
+		if len(items) != 2 || items[1].Content[1].Detail != "auto" {
+			t.Errorf("images should default to the auto detail: %+v", items)
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ReasoningModelSampling(t *testing.T) {
+	topP := 0.9
+	for _, tc := range []struct {
+		model    string
+		sampling bool
+	}{
+		{"gpt-4.1", true},
+		{"o3-mini", false},
+	} {
+		dst := ConvertAnthropicRequestToOpenAIRequest(testCtx(), &anthropic.GenerateMessageRequest{
+			Model:       tc.model,
+			MaxTokens:   1024,
+			Temperature: 3,
+			TopP:        &topP,
+		})
+		if tc.sampling && (dst.Temperature == nil || *dst.Temperature != 2 || dst.TopP == nil || *dst.TopP != topP) {
+			t.Errorf("%s: temperature = %v, top_p = %v, want the clamped client values", tc.model, dst.Temperature, dst.TopP)
+		}
+		if !tc.sampling && (dst.Temperature != nil || dst.TopP != nil) {
+			t.Errorf("%s: temperature = %v, top_p = %v, want them dropped", tc.model, dst.Temperature, dst.TopP)
+		}
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ReasoningExclude(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.Reasoning.Effort = "high"
+		p.Options.Reasoning.Exclude = true
+	}), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+	})
+	if dst.Reasoning == nil || dst.Reasoning.Effort != openai.ResponseReasoningEffortHigh || dst.Reasoning.Summary != "" {
+		t.Errorf("reasoning = %+v, want high effort without a summary", dst.Reasoning)
+	}
+}
+
+func TestConvertAnthropicSystemToOpenAIInput(t *testing.T) {
+	t.Run("text only", func(t *testing.T) {
+		instructions, items := ConvertAnthropicSystemToOpenAIInput(anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are Claude Code."},
+			{Type: anthropic.MessageContentTypeText, Text: "Be concise."},
+		}, "")
+		if instructions != "You are Claude Code.\nBe concise." || items != nil {
+			t.Errorf("instructions = %q, items = %v", instructions, items)
+		}
+	})
+
+	t.Run("cached system block", func(t *testing.T) {
+		instructions, items := ConvertAnthropicSystemToOpenAIInput(anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are Claude Code."},
+			{Type: anthropic.MessageContentTypeText, Text: "<long project context>", CacheControl: &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral}},
+		}, "")
+		if instructions != "" {
+			t.Errorf("instructions = %q, want the system prompt moved to the input", instructions)
+		}
+		if len(items) != 1 || items[0].Type != openai.ResponseInputItemTypeMessage || items[0].Role != "system" {
+			t.Fatalf("items = %+v, want a single system message", items)
+		}
+		content := items[0].Content
+		if len(content) != 2 || content[0].Text != "You are Claude Code." || content[1].Text != "<long project context>" ||
+			content[1].Type != openai.ResponseInputContentTypeInputText {
+			t.Errorf("content = %+v, want one input_text part per block", content)
+		}
+	})
+
+	t.Run("text then image then text", func(t *testing.T) {
+		instructions, items := ConvertAnthropicSystemToOpenAIInput(anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are Claude Code."},
+			{Type: anthropic.MessageContentTypeText, Text: "Follow the style guide."},
+			{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+				Type:      anthropic.MessageContentSourceTypeBase64,
+				MediaType: "image/png",
+				Data:      "iVBORw0KGgo=",
+			}},
+			{Type: anthropic.MessageContentTypeText, Text: "The image above is the style guide."},
+		}, "high")
+		if instructions != "You are Claude Code.\nFollow the style guide." {
+			t.Errorf("instructions = %q, want the leading text blocks", instructions)
+		}
+		if len(items) != 2 {
+			t.Fatalf("items = %+v, want an image message followed by a system message", items)
+		}
+		if image := items[0]; image.Role != "user" || len(image.Content) != 1 ||
+			image.Content[0].Type != openai.ResponseInputContentTypeInputImage ||
+			image.Content[0].ImageURL != "data:image/png;base64,iVBORw0KGgo=" || image.Content[0].Detail != "high" {
+			t.Errorf("image message = %+v", image)
+		}
+		if text := items[1]; text.Role != "system" || len(text.Content) != 1 ||
+			text.Content[0].Text != "The image above is the style guide." {
+			t.Errorf("trailing system message = %+v", text)
+		}
+	})
+
+	t.Run("image first", func(t *testing.T) {
+		instructions, items := ConvertAnthropicSystemToOpenAIInput(anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+				Type: anthropic.MessageContentSourceTypeURL,
+				URL:  "https://example.com/diagram.png",
+			}},
+			{Type: anthropic.MessageContentTypeText, Text: "Explain the diagram."},
+		}, "")
+		if instructions != "" {
+			t.Errorf("instructions = %q, want none", instructions)
+		}
+		if len(items) != 2 || items[0].Role != "user" || items[0].Content[0].Detail != "auto" || items[1].Role != "system" {
+			t.Errorf("items = %+v", items)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if instructions, items := ConvertAnthropicSystemToOpenAIInput(nil, ""); instructions != "" || items != nil {
+			t.Errorf("instructions = %q, items = %v", instructions, items)
+		}
+	})
+}
+
+func TestConvertAnthropicToolChoiceToOpenAI(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		toolChoice   *anthropic.ToolChoice
+		allowedTools []string
+		want         string
+	}{
+		{"unset", nil, nil, "null"},
+		{"auto", &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeAuto}, nil, `"auto"`},
+		{"any", &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeAny}, nil, `"required"`},
+		{"none with subset", &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeNone}, []string{"Read"}, `"none"`},
+		{"tool", &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeTool, Name: "Read"}, []string{"Grep"}, `{"type":"function","name":"Read"}`},
+		{
+			"any with subset",
+			&anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeAny},
+			[]string{"Read", "Grep"},
+			`{"type":"allowed_tools","mode":"required","tools":[{"type":"function","name":"Read"},{"type":"function","name":"Grep"}]}`,
+		},
+		{
+			"unset with subset",
+			nil,
+			[]string{"Read"},
+			`{"type":"allowed_tools","mode":"auto","tools":[{"type":"function","name":"Read"}]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(ConvertAnthropicToolChoiceToOpenAI(tt.toolChoice, tt.allowedTools))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("tool_choice = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIPromptCacheKey(t *testing.T) {
+	newRequest := func(prompt string) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model: "gpt-5",
+			System: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeText, Text: "You are Claude Code.", CacheControl: &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral}},
+			},
+			Tools: []*anthropic.Tool{
+				{Name: "Bash", Description: "Run a command", InputSchema: []byte(`{"type":"object","properties":{"command":{"type":"string"}}}`)},
+			},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: prompt}}},
+			},
+		}
+	}
+	first, second := newRequest("list the files"), newRequest("now read main.go")
+	second.System[0].CacheControl = nil
+	second.Tools[0].InputSchema = []byte(`{"type": "object", "properties": {"command": {"type": "string"}}}`)
+	key := OpenAIPromptCacheKey(first)
+	if key == "" || len(key) > 64 {
+		t.Fatalf("unexpected key %q", key)
+	}
+	if got := OpenAIPromptCacheKey(second); got != key {
+		t.Errorf("requests with identical prefixes got different keys: %q and %q", key, got)
+	}
+	third := newRequest("list the files")
+	third.Tools = append(third.Tools, &anthropic.Tool{Name: "Read", InputSchema: []byte(`{"type":"object"}`)})
+	if got := OpenAIPromptCacheKey(third); got == key {
+		t.Errorf("a different tool set should change the key, got %q for both", got)
+	}
+	if got := OpenAIPromptCacheKey(&anthropic.GenerateMessageRequest{Model: "gpt-5"}); got != "" {
+		t.Errorf("a request without a prefix should have no key, got %q", got)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ModelEffortSuffix(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.Reasoning.Effort = "low"
+	}), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5:high",
+		MaxTokens: 1024,
+	})
+	if dst.Model != "gpt-5" || dst.Reasoning == nil || dst.Reasoning.Effort != openai.ResponseReasoningEffortHigh {
+		t.Errorf("model = %q, reasoning = %+v, want the suffix effort", dst.Model, dst.Reasoning)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenAIRequest_ImageDetail(t *testing.T) {
+	dst := ConvertAnthropicRequestToOpenAIRequest(testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.ImageDetail = "low"
+	}), &anthropic.GenerateMessageRequest{
+		Model:     "gpt-5",
+		MaxTokens: 1024,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeURL, URL: "https://example.com/a.png",
+				}},
+			}},
+		},
+	})
+	if len(dst.Input) != 1 || len(dst.Input[0].Content) != 1 || dst.Input[0].Content[0].Detail != "low" {
+		t.Errorf("unexpected input: %+v", dst.Input)
+	}
+}
+
+func TestConvertAnthropicMetadataToOpenAI(t *testing.T) {
+	t.Run("extra keys", func(t *testing.T) {
+		var metadata anthropic.Metadata
+		if err := json.Unmarshal([]byte(`{"user_id":"user-1","session":"s-1","attempt":2,"tags":["a","b"],"empty":null}`), &metadata); err != nil {
+			t.Fatal(err)
+		}
+		if metadata.UserID != "user-1" {
+			t.Errorf("user_id = %q, want user-1", metadata.UserID)
+		}
+		want := map[string]string{"session": "s-1", "attempt": "2", "tags": `["a","b"]`}
+		if got := ConvertAnthropicMetadataToOpenAI(&metadata); !maps.Equal(got, want) {
+			t.Errorf("metadata = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		metadata := &anthropic.Metadata{Extra: map[string]json.RawMessage{
+			strings.Repeat("k", OpenAIMaxMetadataKeyLength+1): json.RawMessage(`"dropped"`),
+			"long": json.RawMessage(strconv.Quote(strings.Repeat("é", OpenAIMaxMetadataValueLength+1))),
+		}}
+		for i := range OpenAIMaxMetadataPairs {
+			metadata.Extra["other"+strconv.Itoa(i+10)] = json.RawMessage(`"v"`)
+		}
+		got := ConvertAnthropicMetadataToOpenAI(metadata)
+		if len(got) != OpenAIMaxMetadataPairs {
+			t.Errorf("got %d metadata pairs, want %d", len(got), OpenAIMaxMetadataPairs)
+		}
+		if value := got["long"]; value != strings.Repeat("é", OpenAIMaxMetadataValueLength) {
+			t.Errorf("long value has %d characters, want it truncated to %d", len([]rune(value)), OpenAIMaxMetadataValueLength)
+		}
+		if _, ok := got[strings.Repeat("k", OpenAIMaxMetadataKeyLength+1)]; ok {
+			t.Error("key longer than the limit was kept")
+		}
+	})
+
+	t.Run("user_id only", func(t *testing.T) {
+		if got := ConvertAnthropicMetadataToOpenAI(&anthropic.Metadata{UserID: "user-1"}); got != nil {
+			t.Errorf("metadata = %v, want nil", got)
+		}
+	})
+
+	t.Run("request", func(t *testing.T) {
+		dst := ConvertAnthropicRequestToOpenAIRequest(testCtx(), &anthropic.GenerateMessageRequest{
+			Model:     "gpt-5",
+			MaxTokens: 1024,
+			Metadata:  &anthropic.Metadata{UserID: "user-1", Extra: map[string]json.RawMessage{"session": json.RawMessage(`"s-1"`)}},
+		})
+		if dst.User != "user-1" || !maps.Equal(dst.Metadata, map[string]string{"session": "s-1"}) {
+			t.Errorf("user = %q, metadata = %v", dst.User, dst.Metadata)
+		}
+	})
+}
@@ -3,6 +3,12 @@ package adapter
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/samber/lo"
@@ -13,24 +19,43 @@ import (
 )
 
 type ConvertRequestOptions struct {
+	ToolNames *ToolNames
 }
 
 type ConvertRequestOption func(*ConvertRequestOptions)
 
+// RecordToolNames records the tool names rewritten by the sanitize_tool_names option in names, so
+// that the response can be converted back with RestoreToolNames.
+func RecordToolNames(names *ToolNames) ConvertRequestOption {
+	return func(opts *ConvertRequestOptions) {
+		opts.ToolNames = names
+	}
+}
+
+// ToolResultErrorText precedes the content of a tool_result with is_error set when it is converted
+// for upstreams that cannot flag a tool output as failed.
+const ToolResultErrorText = "Error: the tool call failed."
+
+// ConvertAnthropicRequestToOpenRouterRequest converts an Anthropic Messages API request into an
+// OpenRouter chat completion request. It returns a *ContentPartTooLargeError when a text content
+// part of src exceeds the profile's max_content_part_bytes.
 func ConvertAnthropicRequestToOpenRouterRequest(
 	ctx context.Context,
 	src *anthropic.GenerateMessageRequest,
 	options ...ConvertRequestOption,
-) (dst *openrouter.CreateChatCompletionRequest) {
+) (dst *openrouter.CreateChatCompletionRequest, err error) {
 	prof, _ := profile.FromContext(ctx)
 	convertOptions := &ConvertRequestOptions{}
 	for _, applyOption := range options {
 		applyOption(convertOptions)
 	}
-	maxTokens := src.MaxTokens
-	if minMaxTokens := prof.Options.GetMinMaxTokens(); minMaxTokens > 0 && maxTokens < minMaxTokens {
-		maxTokens = minMaxTokens
+	if err = ValidateContentPartSizes(src, prof.Options.GetMaxContentPartBytes()); err != nil {
+		return nil, err
 	}
+	// OpenRouter's middle-out transform already fits the prompt into the upstream window, so
+	// scaling max_tokens on top of it would shrink twice.
+	maxTokens := resolveMaxTokens(prof, src.MaxTokens, !prof.OpenRouter.HasTransform(openrouter.TransformMiddleOut))
+	maxTokensCap := prof.Options.GetMaxTokensCap()
 	dst = &openrouter.CreateChatCompletionRequest{
 		Model:       src.Model,
 		MaxTokens:   lo.ToPtr(maxTokens),
@@ -42,11 +67,46 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 	if targetModel, ok := prof.Options.GetModels()[dst.Model]; ok {
 		dst.Model = targetModel
 	}
-	if metadata := src.Metadata; metadata != nil && metadata.UserID != "" {
+	if isOpenAIReasoningModel(dst.Model) {
+		// OpenAI o-series reasoning models reject sampling parameters, so they are not forwarded.
+		dst.Temperature, dst.TopP = nil, nil
+	} else {
+		*dst.Temperature = min(max(*dst.Temperature, 0), 2)
+		if dst.TopP != nil {
+			dst.TopP = lo.ToPtr(min(max(*dst.TopP, 0), 1))
+		}
+	}
+	if dst.Temperature != nil && dst.TopP != nil && !supportsTemperatureWithTopP(dst.Model) {
+		// The Anthropic request omits a zero temperature, so a zero temperature was not chosen by the
+		// client and top_p is the parameter to keep; otherwise temperature is kept.
+		if *dst.Temperature == 0 {
+			slog.Debug(fmt.Sprintf("dropping temperature, model %q does not accept it together with top_p", dst.Model))
+			dst.Temperature = nil
+		} else {
+			slog.Debug(fmt.Sprintf("dropping top_p, model %q does not accept it together with temperature", dst.Model))
+			dst.TopP = nil
+		}
+	}
+	if dst.TopK != nil && !supportsTopK(dst.Model, prof.OpenRouter.GetPreferredProviders()) {
+		slog.Debug(fmt.Sprintf("dropping top_k=%d, which is not supported by model %q", *dst.TopK, dst.Model))
+		dst.TopK = nil
+	}
+	if logitBias := prof.Options.GetLogitBias(); len(logitBias) > 0 {
+		if supportsLogitBias(dst.Model) {
+			dst.LogitBias = logitBias
+		} else {
+			slog.Warn(fmt.Sprintf("dropping logit_bias, which is not supported by model %q", dst.Model))
+		}
+	}
+	if tier := prof.OpenRouter.GetServiceTier(); tier != "" {
+		dst.ServiceTier = tier
+	}
+	if metadata := src.Metadata; metadata != nil {
 		dst.User = metadata.UserID
+		dst.Metadata = ConvertAnthropicMetadataToOpenAI(metadata)
 	}
-	if len(src.StopSequences) > 0 {
-		dst.Stop = src.StopSequences
+	if stop := normalizeStopSequences(src.StopSequences, prof.Options.GetMaxStopSequences()); len(stop) > 0 {
+		dst.Stop = stop
 	}
 	if srcToolChoice := src.ToolChoice; srcToolChoice != nil {
 		dst.ParallelToolCalls = lo.ToPtr(!srcToolChoice.DisableParallelToolUse)
@@ -87,13 +147,20 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 			}
 			switch srcToolType {
 			case anthropic.ToolTypeCustom:
+				parameters := []byte(srcTool.InputSchema)
+				if prof.Options.GetStrict() {
+					parameters = InlineJSONSchemaRefs(parameters)
+					if prof.Options.GetStrictSchemaSanitize() {
+						parameters = SanitizeStrictJSONSchema(parameters)
+					}
+				}
 				dstTool := &openrouter.ChatCompletionTool{
 					Type: openrouter.ChatCompletionMessageToolCallTypeFunction,
 					Function: &openrouter.ChatCompletionFunction{
 						Name:        srcTool.Name,
 						Description: srcTool.Description,
 						Strict:      prof.Options.GetStrict(),
-						Parameters:  openrouter.ChatCompletionJSONSchemaObject(srcTool.InputSchema),
+						Parameters:  openrouter.ChatCompletionJSONSchemaObject(parameters),
 					},
 				}
 				if srcCacheControl := srcTool.CacheControl; srcCacheControl != nil {
@@ -102,8 +169,28 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 					// it in the future.
 				}
 				dst.Tools = append(dst.Tools, dstTool)
+			case anthropic.ToolTypeWebSearch2025:
+				if !prof.OpenRouter.GetWebSearch() {
+					continue
+				}
+				// OpenRouter searches the web before the model answers, instead of letting the model call
+				// a search tool, so the tool becomes the web plugin.
+				if len(dst.Plugins) == 0 {
+					dst.Plugins = append(dst.Plugins, &openrouter.ChatCompletionPlugin{ID: openrouter.ChatCompletionPluginIDWeb})
+				}
+				if toolChoice := dst.ToolChoice; toolChoice != nil && toolChoice.Tool != nil &&
+					toolChoice.Tool.Function != nil && toolChoice.Tool.Function.Name == srcTool.Name {
+					dst.ToolChoice = nil
+				}
 			}
 		}
+		if len(dst.Tools) == 0 && len(dst.Plugins) > 0 {
+			// The web search tool was the only tool; a tool_choice without tools is rejected.
+			dst.ToolChoice, dst.ParallelToolCalls = nil, nil
+		}
+		if maxTools := prof.Options.GetMaxTools(); maxTools > 0 && len(dst.Tools) > maxTools {
+			dst.Tools = limitOpenRouterTools(src, dst.Tools, maxTools)
+		}
 	}
 	if thinking := src.Thinking; thinking != nil {
 		reasoning := &openrouter.ChatCompletionReasoning{
@@ -115,9 +202,22 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 		case anthropic.ThinkingTypeDisabled:
 			reasoning.Enabled = false
 		}
+		// Upstream rejects a thinking budget that leaves no room for the answer, so the budget is
+		// clamped just below max_tokens, the same boundary force_thinking uses.
+		if reasoning.Enabled && *dst.MaxTokens > 1 && reasoning.MaxTokens >= *dst.MaxTokens {
+			slog.Debug(fmt.Sprintf("thinking budget_tokens %d >= max_tokens %d, clamped to %d",
+				reasoning.MaxTokens, *dst.MaxTokens, *dst.MaxTokens-1))
+			reasoning.MaxTokens = *dst.MaxTokens - 1
+		}
 		dst.Reasoning = reasoning
 	}
-	switch format := getOpenRouterModelReasoningFormat(prof, dst.Model); format {
+	format := getOpenRouterModelReasoningFormat(prof, dst.Model)
+	var suffixEffort openrouter.ChatCompletionReasoningEffort
+	if model, effort, ok := cutOpenRouterModelEffortSuffix(prof, format, dst.Model); ok {
+		dst.Model = model
+		suffixEffort = effort
+	}
+	switch format {
 	case openrouter.ChatCompletionMessageReasoningDetailFormatUnknown:
 		fallthrough
 	case openrouter.ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1:
@@ -125,6 +225,9 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 			if prof.Anthropic.GetForceThinking() {
 				if dst.MaxTokens == nil || *dst.MaxTokens <= 1024 {
 					dst.MaxTokens = lo.ToPtr(32 * 1024)
+					if maxTokensCap > 0 {
+						dst.MaxTokens = lo.ToPtr(min(*dst.MaxTokens, maxTokensCap))
+					}
 				}
 				dst.Reasoning = &openrouter.ChatCompletionReasoning{
 					Enabled:   true,
@@ -132,12 +235,10 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 				}
 			}
 		}
-	case openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1:
-		var effort openrouter.ChatCompletionReasoningEffort
-		if model, suffix, ok := strings.Cut(dst.Model, ":"); ok {
-			dst.Model = model
-			effort = openrouter.ChatCompletionReasoningEffort(suffix)
-		} else {
+	case openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1,
+		openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1:
+		effort := suffixEffort
+		if effort.IsEmpty() {
 			effort = openrouter.ChatCompletionReasoningEffort(prof.Options.GetReasoningEffort())
 		}
 		if !effort.IsEmpty() {
@@ -157,17 +258,44 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 		} else {
 			dst.Reasoning.Enabled = true
 		}
+		if !suffixEffort.IsEmpty() {
+			dst.Reasoning.MaxTokens = 0
+			dst.Reasoning.Effort = suffixEffort
+		}
+	}
+	if prof.Options.GetDisableThinking() {
+		// Takes precedence over the client's thinking field and over force_thinking.
+		switch format {
+		case openrouter.ChatCompletionMessageReasoningDetailFormatGoogleGeminiV1:
+			// Reasoning cannot be turned off, so it is at least kept out of the response.
+			dst.Reasoning = &openrouter.ChatCompletionReasoning{Enabled: true, Exclude: true}
+		case openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1,
+			openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1:
+			if effort := disabledThinkingEffort(dst.Model); effort != "" {
+				dst.Reasoning = &openrouter.ChatCompletionReasoning{
+					Effort:  openrouter.ChatCompletionReasoningEffort(effort),
+					Exclude: true,
+				}
+			} else {
+				dst.Reasoning = nil
+			}
+		default:
+			dst.Reasoning = nil
+		}
+	}
+	if dst.Reasoning != nil && prof.Options.GetReasoningExclude() {
+		dst.Reasoning.Exclude = true
 	}
 	dstMessages := make([]*openrouterChatCompletionMessageWrapper, 0, len(src.Messages))
-	if len(src.System) > 0 {
+	if system := injectSystemPrefixSuffix(src.System, prof.Options.GetSystemPrefix(), prof.Options.GetSystemSuffix()); len(system) > 0 {
 		dstSystemMessage := &openrouter.ChatCompletionMessage{
 			Role: openrouter.ChatCompletionMessageRoleSystem,
 			Content: &openrouter.ChatCompletionMessageContent{
 				Type:  openrouter.ChatCompletionMessageContentTypeParts,
-				Parts: make([]*openrouter.ChatCompletionMessageContentPart, 0, len(src.System)),
+				Parts: make([]*openrouter.ChatCompletionMessageContentPart, 0, len(system)),
 			},
 		}
-		for _, systemContent := range src.System {
+		for _, systemContent := range system {
 			switch systemContent.Type {
 			case anthropic.MessageContentTypeText:
 				dstPart := &openrouter.ChatCompletionMessageContentPart{
@@ -233,7 +361,26 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 					underlyingAnthropicMessage: srcMessage,
 				})
 			case anthropic.MessageContentTypeRedactedThinking:
-				panic("unreachable redacted_thinking")
+				if prof.Options.GetRedactedThinkingMode() != profile.RedactedThinkingModeEncrypted {
+					slog.Debug("dropping redacted_thinking block from conversation history")
+					continue
+				}
+				// Keep the opaque data as an encrypted reasoning detail, which round-trips to
+				// Anthropic models behind OpenRouter; other reasoning formats drop it later.
+				dstMessage := &openrouter.ChatCompletionMessage{
+					Role: dstRole,
+					ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{
+						{
+							Type:   openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted,
+							Data:   srcMessageContent.Data,
+							Format: openrouter.ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1,
+						},
+					},
+				}
+				dstMessages = append(dstMessages, &openrouterChatCompletionMessageWrapper{
+					ChatCompletionMessage:      dstMessage,
+					underlyingAnthropicMessage: srcMessage,
+				})
 			case anthropic.MessageContentTypeToolUse:
 				dstMessage := &openrouter.ChatCompletionMessage{
 					Role: openrouter.ChatCompletionMessageRoleAssistant,
@@ -265,6 +412,20 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 				if srcMessageContent.Content != nil {
 					dstMessage.Content = convertAnthropicToolResultMessageContentsToOpenRouterChatCompletionMessageContent(srcMessageContent.Content)
 				}
+				if srcMessageContent.IsError {
+					// Chat completions have no error flag for tool messages, so the failure is stated in a
+					// separate leading part, leaving cache_control on the original parts untouched.
+					errorPart := &openrouter.ChatCompletionMessageContentPart{
+						Type: openrouter.ChatCompletionMessageContentPartTypeText,
+						Text: ToolResultErrorText,
+					}
+					if dstMessage.Content == nil || dstMessage.Content.Type != openrouter.ChatCompletionMessageContentTypeParts {
+						dstMessage.Content = &openrouter.ChatCompletionMessageContent{
+							Type: openrouter.ChatCompletionMessageContentTypeParts,
+						}
+					}
+					dstMessage.Content.Parts = append([]*openrouter.ChatCompletionMessageContentPart{errorPart}, dstMessage.Content.Parts...)
+				}
 				dstMessages = append(dstMessages, &openrouterChatCompletionMessageWrapper{
 					ChatCompletionMessage:      dstMessage,
 					underlyingAnthropicMessage: srcMessage,
@@ -321,13 +482,197 @@ func ConvertAnthropicRequestToOpenRouterRequest(
 						underlyingAnthropicMessage: srcMessage,
 					})
 				}
+			case anthropic.MessageContentTypeDocument:
+				if dstPart := convertAnthropicDocumentToOpenRouterContentPart(srcMessageContent); dstPart != nil {
+					dstMessage := &openrouter.ChatCompletionMessage{
+						Role: dstRole,
+						Content: &openrouter.ChatCompletionMessageContent{
+							Type:  openrouter.ChatCompletionMessageContentTypeParts,
+							Parts: []*openrouter.ChatCompletionMessageContentPart{dstPart},
+						},
+					}
+					dstMessages = append(dstMessages, &openrouterChatCompletionMessageWrapper{
+						ChatCompletionMessage:      dstMessage,
+						underlyingAnthropicMessage: srcMessage,
+					})
+				}
 			}
 		}
 	}
 	dst.Messages = canonicalOpenRouterMessages(prof, dst.Model, dstMessages)
+	if prof.Options.GetSanitizeToolNames() {
+		names := convertOptions.ToolNames
+		if names == nil {
+			names = &ToolNames{}
+		}
+		renameOpenRouterToolNames(dst, names)
+	}
+	return dst, nil
+}
+
+// resolveMaxTokens applies the max_tokens options of prof to the max_tokens of a request:
+// default_max_tokens replaces an unset value, and the result is kept within min_max_tokens and
+// max_tokens_cap. With scale set, max_tokens is scaled the same way usage is scaled in responses,
+// so that a factor below 1.0 keeps requests within a smaller upstream context window.
+func resolveMaxTokens(prof *profile.Profile, maxTokens int, scale bool) int {
+	if maxTokens == 0 {
+		maxTokens = prof.Options.GetDefaultMaxTokens()
+	}
+	if factor := prof.Options.GetContextWindowResizeFactor(); scale && factor != 1.0 && maxTokens > 0 {
+		maxTokens = max(int(math.Round(float64(maxTokens)*factor)), 1)
+	}
+	if minMaxTokens := prof.Options.GetMinMaxTokens(); minMaxTokens > 0 && maxTokens < minMaxTokens {
+		maxTokens = minMaxTokens
+	}
+	if maxTokensCap := prof.Options.GetMaxTokensCap(); maxTokensCap > 0 && maxTokens > maxTokensCap {
+		slog.Debug(fmt.Sprintf("max_tokens %d exceeds max_tokens_cap, clamped to %d", maxTokens, maxTokensCap))
+		maxTokens = maxTokensCap
+	}
+	return maxTokens
+}
+
+// limitOpenRouterTools keeps at most limit tools, preferring the tool forced by tool_choice and
+// then the tools called most recently in the conversation, so that an ongoing task keeps its
+// tools. The kept tools stay in their original order.
+func limitOpenRouterTools(
+	src *anthropic.GenerateMessageRequest,
+	tools []*openrouter.ChatCompletionTool,
+	limit int,
+) []*openrouter.ChatCompletionTool {
+	var priority []string
+	if toolChoice := src.ToolChoice; toolChoice != nil && toolChoice.Type == anthropic.ToolChoiceTypeTool {
+		priority = append(priority, toolChoice.Name)
+	}
+	for i := len(src.Messages) - 1; i >= 0; i-- {
+		if src.Messages[i] == nil {
+			continue
+		}
+		contents := src.Messages[i].Content
+		for j := len(contents) - 1; j >= 0; j-- {
+			if content := contents[j]; content != nil && content.Type == anthropic.MessageContentTypeToolUse {
+				priority = append(priority, content.Name)
+			}
+		}
+	}
+	keep := make(map[string]bool, limit)
+	for _, name := range priority {
+		if len(keep) == limit {
+			break
+		}
+		if slices.ContainsFunc(tools, func(tool *openrouter.ChatCompletionTool) bool { return tool.Function.Name == name }) {
+			keep[name] = true
+		}
+	}
+	for _, tool := range tools {
+		if len(keep) == limit {
+			break
+		}
+		keep[tool.Function.Name] = true
+	}
+	kept := make([]*openrouter.ChatCompletionTool, 0, limit)
+	var dropped []string
+	for _, tool := range tools {
+		if keep[tool.Function.Name] {
+			kept = append(kept, tool)
+		} else {
+			dropped = append(dropped, tool.Function.Name)
+		}
+	}
+	slog.Warn(fmt.Sprintf("request has %d tools, more than max_tools %d; dropped: %s", len(tools), limit, strings.Join(dropped, ", ")))
+	return kept
+}
+
+// normalizeStopSequences removes empty and duplicate stop sequences, which upstreams reject, and
+// keeps at most limit of the remaining ones in their original order. A limit of 0 means no limit.
+func normalizeStopSequences(stopSequences []string, limit int) []string {
+	normalized := make([]string, 0, len(stopSequences))
+	for _, stop := range stopSequences {
+		if stop != "" && !slices.Contains(normalized, stop) {
+			normalized = append(normalized, stop)
+		}
+	}
+	if limit > 0 && len(normalized) > limit {
+		slog.Warn(fmt.Sprintf("request has %d stop sequences, more than max_stop_sequences %d; dropped: %q",
+			len(normalized), limit, normalized[limit:]))
+		normalized = normalized[:limit]
+	}
+	return normalized
+}
+
+// isEmptyOpenRouterMessageContent reports whether content has no text and no part other than
+// empty text parts.
+func isEmptyOpenRouterMessageContent(content *openrouter.ChatCompletionMessageContent) bool {
+	if content == nil {
+		return true
+	}
+	if !content.IsParts() {
+		return content.Text == ""
+	}
+	for _, part := range content.Parts {
+		if part != nil && (part.Type != openrouter.ChatCompletionMessageContentPartTypeText || part.Text != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// appendOpenRouterMessageContent appends the content of src to dst, turning dst into parts if
+// it is a plain text.
+func appendOpenRouterMessageContent(dst, src *openrouter.ChatCompletionMessageContent) *openrouter.ChatCompletionMessageContent {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		return src
+	}
+	if dst.IsText() {
+		dst = &openrouter.ChatCompletionMessageContent{
+			Type: openrouter.ChatCompletionMessageContentTypeParts,
+			Parts: []*openrouter.ChatCompletionMessageContentPart{
+				{
+					Type: openrouter.ChatCompletionMessageContentPartTypeText,
+					Text: dst.Text,
+				},
+			},
+		}
+	}
+	switch src.Type {
+	case openrouter.ChatCompletionMessageContentTypeText:
+		dst.Parts = append(dst.Parts, &openrouter.ChatCompletionMessageContentPart{
+			Type: openrouter.ChatCompletionMessageContentPartTypeText,
+			Text: src.Text,
+		})
+	case openrouter.ChatCompletionMessageContentTypeParts:
+		dst.Parts = append(dst.Parts, src.Parts...)
+	}
 	return dst
 }
 
+// mergeConsecutiveOpenRouterMessages merges adjacent user messages and adjacent assistant messages,
+// for providers that require the roles to alternate. Content parts are concatenated in order, and
+// the tool calls and reasoning details of assistant messages are kept. System and tool messages
+// are never merged.
+func mergeConsecutiveOpenRouterMessages(messages []*openrouter.ChatCompletionMessage) []*openrouter.ChatCompletionMessage {
+	merged := make([]*openrouter.ChatCompletionMessage, 0, len(messages))
+	for _, message := range messages {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			if last.Role == message.Role &&
+				(message.Role == openrouter.ChatCompletionMessageRoleUser || message.Role == openrouter.ChatCompletionMessageRoleAssistant) {
+				last.Content = appendOpenRouterMessageContent(last.Content, message.Content)
+				if last.Reasoning == "" {
+					last.Reasoning = message.Reasoning
+				}
+				last.ReasoningDetails = append(last.ReasoningDetails, message.ReasoningDetails...)
+				last.ToolCalls = append(last.ToolCalls, message.ToolCalls...)
+				continue
+			}
+		}
+		merged = append(merged, message)
+	}
+	return merged
+}
+
 type openrouterChatCompletionMessageWrapper struct {
 	*openrouter.ChatCompletionMessage
 	underlyingAnthropicMessage *anthropic.Message
@@ -339,6 +684,12 @@ func canonicalOpenRouterMessages(
 	messageWrappers []*openrouterChatCompletionMessageWrapper,
 ) (messages []*openrouter.ChatCompletionMessage) {
 	messages = make([]*openrouter.ChatCompletionMessage, 0, len(messageWrappers))
+	// Only Anthropic supports the 1h cache TTL; other providers reject the request, so unless
+	// Anthropic is among the preferred providers, 1h breakpoints are downgraded to 5m.
+	clampCacheTTL := prof.OpenRouter.GetClampCacheTTL() &&
+		!slices.Contains(prof.OpenRouter.GetPreferredProviders(), openrouter.ProviderAnthropic)
+	imageDetail := prof.Options.GetImageDetail()
+	forcePartsContent := prof.OpenRouter.GetForcePartsContent()
 	var (
 		currentChatCompletionMessage      *openrouter.ChatCompletionMessage
 		currentUnderlyingAnthropicMessage *anthropic.Message
@@ -352,7 +703,9 @@ func canonicalOpenRouterMessages(
 			}
 			messages = append(messages, wrapper.ChatCompletionMessage)
 		} else if underlyingMessage := wrapper.underlyingAnthropicMessage; underlyingMessage != nil {
-			if underlyingMessage != currentUnderlyingAnthropicMessage {
+			// A tool message flushes the message being merged, so content of the same Anthropic message
+			// that follows a tool_result starts a new message instead of being dropped.
+			if underlyingMessage != currentUnderlyingAnthropicMessage || currentChatCompletionMessage == nil {
 				currentUnderlyingAnthropicMessage = underlyingMessage
 				if currentChatCompletionMessage != nil {
 					messages = append(messages, currentChatCompletionMessage)
@@ -370,42 +723,25 @@ func canonicalOpenRouterMessages(
 						currentChatCompletionMessage.ToolCalls = append(currentChatCompletionMessage.ToolCalls, wrapper.ToolCalls...)
 					}
 				}
-				if wrapper.Content != nil {
-					if currentChatCompletionMessage.Content != nil {
-						if currentChatCompletionMessage.Content.IsText() {
-							currentChatCompletionMessage.Content = &openrouter.ChatCompletionMessageContent{
-								Type: openrouter.ChatCompletionMessageContentTypeParts,
-								Parts: []*openrouter.ChatCompletionMessageContentPart{
-									{
-										Type: openrouter.ChatCompletionMessageContentPartTypeText,
-										Text: currentChatCompletionMessage.Content.Text,
-									},
-								},
-							}
-						}
-						switch wrapper.Content.Type {
-						case openrouter.ChatCompletionMessageContentTypeText:
-							currentChatCompletionMessage.Content.Parts = append(currentChatCompletionMessage.Content.Parts, &openrouter.ChatCompletionMessageContentPart{
-								Type: openrouter.ChatCompletionMessageContentPartTypeText,
-								Text: wrapper.Content.Text,
-							})
-						case openrouter.ChatCompletionMessageContentTypeParts:
-							currentChatCompletionMessage.Content.Parts = append(currentChatCompletionMessage.Content.Parts, wrapper.Content.Parts...)
-						}
-					} else {
-						currentChatCompletionMessage.Content = wrapper.Content
-					}
-				}
+				currentChatCompletionMessage.Content = appendOpenRouterMessageContent(currentChatCompletionMessage.Content, wrapper.Content)
 			}
 		}
 	}
 	if currentChatCompletionMessage != nil {
 		messages = append(messages, currentChatCompletionMessage)
 	}
+	if prof.OpenRouter.GetMergeConsecutiveRoles() {
+		messages = mergeConsecutiveOpenRouterMessages(messages)
+	}
 	for _, message := range messages {
 		switch message.Role {
 		case openrouter.ChatCompletionMessageRoleAssistant:
-			if content := message.Content; content != nil && content.IsParts() && len(content.Parts) == 1 {
+			if len(message.ToolCalls) > 0 && isEmptyOpenRouterMessageContent(message.Content) {
+				// Providers reject an empty string or an empty array as the content of an assistant message,
+				// so the content of a message with only tool calls is sent as null instead.
+				message.Content = nil
+			}
+			if content := message.Content; !forcePartsContent && content != nil && content.IsParts() && len(content.Parts) == 1 {
 				if part := content.Parts[0]; part.Type == openrouter.ChatCompletionMessageContentPartTypeText {
 					// Anthropic only accepts text message in a single assistant message, and content should be a string
 					content.Type = openrouter.ChatCompletionMessageContentTypeText
@@ -420,6 +756,7 @@ func canonicalOpenRouterMessages(
 				switch format := getOpenRouterModelReasoningFormat(prof, model); format {
 				case openrouter.ChatCompletionMessageReasoningDetailFormatUnknown,
 					openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1,
+					openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1,
 					openrouter.ChatCompletionMessageReasoningDetailFormatGoogleGeminiV1:
 					revisedReasoningDetails := make([]*openrouter.ChatCompletionMessageReasoningDetail, 0, len(message.ReasoningDetails))
 					for _, reasoningDetail := range message.ReasoningDetails {
@@ -430,6 +767,8 @@ func canonicalOpenRouterMessages(
 								// For openai-responses-v1, we need to convert the reasoning.text part to a reasoning.summary part.
 								reasoningDetail.Summary = reasoningDetail.Text
 								reasoningDetail.Text = ""
+							case openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1:
+								// For openai-chat-v1, reasoning stays in reasoning.text as the Chat Completions reasoning field.
 							}
 						}
 						if reasoningDetail.Summary != "" {
@@ -453,9 +792,10 @@ func canonicalOpenRouterMessages(
 								Format: format,
 								Index:  reasoningDetail.Index,
 							}
-							if delimiterIndex := strings.Index(signature, prof.Options.GetReasoningDelimiter()); delimiterIndex != -1 {
-								derivedReasoningDetail.ID = signature[:delimiterIndex]
-								derivedReasoningDetail.Data = signature[delimiterIndex+1:]
+							// The reverse of encryptedReasoningSignature, for delimiters of any length.
+							if id, data, found := strings.Cut(signature, prof.Options.GetReasoningDelimiter()); found {
+								derivedReasoningDetail.ID = id
+								derivedReasoningDetail.Data = data
 							} else {
 								derivedReasoningDetail.Data = signature
 							}
@@ -471,6 +811,14 @@ func canonicalOpenRouterMessages(
 				}
 			}
 		}
+		if content := message.Content; forcePartsContent && content != nil && content.IsText() && content.Text != "" {
+			content.Type = openrouter.ChatCompletionMessageContentTypeParts
+			content.Parts = []*openrouter.ChatCompletionMessageContentPart{{
+				Type: openrouter.ChatCompletionMessageContentPartTypeText,
+				Text: content.Text,
+			}}
+			content.Text = ""
+		}
 		// OpenRouter says that the cache_control breakpoint can only be inserted into the text part of a multipart message.
 		// So we remove CacheControl fields from content parts for non-text type.
 		//
@@ -480,6 +828,13 @@ func canonicalOpenRouterMessages(
 				if part.Type != openrouter.ChatCompletionMessageContentPartTypeText {
 					part.CacheControl = nil
 				}
+				if imageDetail != "" && part.Type == openrouter.ChatCompletionMessageContentPartTypeImage && part.ImageUrl != nil {
+					part.ImageUrl.Detail = imageDetail
+				}
+				if clampCacheTTL && part.CacheControl != nil &&
+					part.CacheControl.TTL == openrouter.ChatCompletionMessageCacheControlTTL1Hour {
+					part.CacheControl.TTL = openrouter.ChatCompletionMessageCacheControlTTL5Minutes
+				}
 			}
 		}
 	}
@@ -532,11 +887,133 @@ func convertAnthropicToolResultMessageContentsToOpenRouterChatCompletionMessageC
 				}
 				dst.Parts = append(dst.Parts, dstPart)
 			}
+		case anthropic.MessageContentTypeDocument:
+			if dstPart := convertAnthropicDocumentToOpenRouterContentPart(srcContent); dstPart != nil {
+				dst.Parts = append(dst.Parts, dstPart)
+			}
 		}
 	}
 	return dst
 }
 
+// injectSystemPrefixSuffix surrounds the system contents with the profile's system prefix and
+// suffix. Both are separate text blocks, so cache_control on the original blocks keeps applying
+// to the same content; empty values leave the system contents untouched.
+func injectSystemPrefixSuffix(system anthropic.MessageContents, prefix string, suffix string) anthropic.MessageContents {
+	if prefix == "" && suffix == "" {
+		return system
+	}
+	injected := make(anthropic.MessageContents, 0, len(system)+2)
+	if prefix != "" {
+		injected = append(injected, &anthropic.MessageContent{Type: anthropic.MessageContentTypeText, Text: prefix})
+	}
+	injected = append(injected, system...)
+	if suffix != "" {
+		injected = append(injected, &anthropic.MessageContent{Type: anthropic.MessageContentTypeText, Text: suffix})
+	}
+	return injected
+}
+
+// convertAnthropicDocumentToOpenRouterContentPart converts a document block into an OpenRouter
+// content part. Base64 sources become file parts encoded as data URLs like images are, and URL
+// sources are passed through as-is; the file is named after the document title, or "document" with
+// the extension of its media type. Plain text sources become text parts. Returns nil for sources
+// OpenRouter cannot take (e.g. content documents), which are dropped with a warning.
+func convertAnthropicDocumentToOpenRouterContentPart(
+	src *anthropic.MessageContent,
+) *openrouter.ChatCompletionMessageContentPart {
+	source := src.Source
+	if source == nil {
+		return nil
+	}
+	var fileData string
+	switch source.Type {
+	case anthropic.MessageContentSourceTypeBase64:
+		fileData = fmt.Sprintf("data:%s;%s,%s", source.MediaType, source.Type, source.Data)
+	case anthropic.MessageContentSourceTypeURL:
+		fileData = source.URL
+	case anthropic.MessageContentSourceTypeText:
+		dstPart := &openrouter.ChatCompletionMessageContentPart{
+			Type: openrouter.ChatCompletionMessageContentPartTypeText,
+			Text: source.Data,
+		}
+		if srcCacheControl := src.CacheControl; srcCacheControl != nil {
+			dstPart.CacheControl = &openrouter.ChatCompletionMessageCacheControl{
+				Type: openrouter.ChatCompletionMessageCacheControlType(srcCacheControl.Type),
+				TTL:  openrouter.ChatCompletionMessageCacheControlTTL(srcCacheControl.TTL),
+			}
+		}
+		return dstPart
+	default:
+		slog.Warn(fmt.Sprintf("dropping document block with unsupported %q source", source.Type))
+		return nil
+	}
+	filename := src.Title
+	if filename == "" {
+		filename = "document" + documentExtension(source.MediaType)
+	}
+	dstPart := &openrouter.ChatCompletionMessageContentPart{
+		Type: openrouter.ChatCompletionMessageContentPartTypeFile,
+		File: &openrouter.ChatCompletionMessageContentPartFile{
+			Filename: filename,
+			FileData: fileData,
+		},
+	}
+	if srcCacheControl := src.CacheControl; srcCacheControl != nil {
+		dstPart.CacheControl = &openrouter.ChatCompletionMessageCacheControl{
+			Type: openrouter.ChatCompletionMessageCacheControlType(srcCacheControl.Type),
+			TTL:  openrouter.ChatCompletionMessageCacheControlTTL(srcCacheControl.TTL),
+		}
+	}
+	return dstPart
+}
+
+// documentExtension returns the file extension of a document of mediaType. URL sources carry no
+// media type, and Anthropic only accepts PDFs from URLs, so an empty media type is a PDF.
+func documentExtension(mediaType string) string {
+	switch mediaType {
+	case "", "application/pdf":
+		return ".pdf"
+	case "text/plain":
+		return ".txt"
+	}
+	if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ""
+}
+
+// cutOpenRouterModelEffortSuffix splits a reasoning effort suffix, e.g. ":high", off model when
+// its reasoning format is one of the profile's effort suffix formats. Other suffixes, such as
+// the OpenRouter ":free" and ":nitro" variants, are part of the model slug and left in place.
+// Anthropic models never take an effort suffix.
+func cutOpenRouterModelEffortSuffix(
+	prof *profile.Profile,
+	format openrouter.ChatCompletionMessageReasoningDetailFormat,
+	model string,
+) (string, openrouter.ChatCompletionReasoningEffort, bool) {
+	switch format {
+	case openrouter.ChatCompletionMessageReasoningDetailFormatUnknown,
+		openrouter.ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1:
+		return model, "", false
+	}
+	if !slices.Contains(prof.Options.GetReasoningEffortSuffixFormats(), string(format)) {
+		return model, "", false
+	}
+	index := strings.LastIndex(model, ":")
+	if index == -1 {
+		return model, "", false
+	}
+	switch effort := openrouter.ChatCompletionReasoningEffort(model[index+1:]); effort {
+	case openrouter.ChatCompletionReasoningEffortMinimal,
+		openrouter.ChatCompletionReasoningEffortLow,
+		openrouter.ChatCompletionReasoningEffortMedium,
+		openrouter.ChatCompletionReasoningEffortHigh:
+		return model[:index], effort, true
+	}
+	return model, "", false
+}
+
 func getOpenRouterModelReasoningFormat(
 	prof *profile.Profile,
 	model string,
@@ -548,3 +1025,66 @@ func getOpenRouterModelReasoningFormat(
 	}
 	return openrouter.ChatCompletionMessageReasoningDetailFormat(prof.Options.GetReasoningFormat())
 }
+
+// isOpenAIReasoningModel reports whether model names an OpenAI o-series reasoning model
+// such as "openai/o3-mini" or "o1".
+func isOpenAIReasoningModel(model string) bool {
+	name := strings.TrimPrefix(model, "openai/")
+	if len(name) < 2 || name[0] != 'o' || name[1] < '0' || name[1] > '9' {
+		return false
+	}
+	return !strings.Contains(name, "/")
+}
+
+// disabledThinkingEffort returns the lowest reasoning effort model accepts, which disable_thinking
+// requests since these models think at their default effort without a reasoning parameter: "low"
+// for o-series models and "minimal" for GPT-5 models. Returns "" for models that do not reason,
+// such as gpt-4o or gpt-4.1, which reject a reasoning parameter.
+func disabledThinkingEffort(model string) string {
+	if isOpenAIReasoningModel(model) {
+		return "low"
+	}
+	if name := strings.TrimPrefix(model, "openai/"); strings.HasPrefix(name, "gpt-5") && !strings.Contains(name, "-chat") {
+		return "minimal"
+	}
+	return ""
+}
+
+// exclusiveSamplingModel matches the Claude models released since Opus 4.1, e.g.
+// "anthropic/claude-sonnet-4.5" or "claude-opus-4-1-20250805", capturing their version.
+var exclusiveSamplingModel = regexp.MustCompile(`^(?:anthropic/)?claude-(?:opus|sonnet|haiku)-(\d+)(?:[.-](\d{1,2}))?(?:\D|$)`)
+
+// supportsTemperatureWithTopP reports whether model accepts temperature and top_p in the same
+// request; Claude models from Opus 4.1 on only accept one of them.
+func supportsTemperatureWithTopP(model string) bool {
+	match := exclusiveSamplingModel.FindStringSubmatch(model)
+	if match == nil {
+		return true
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return major < 4 || major == 4 && minor == 0
+}
+
+// supportsLogitBias reports whether model accepts logit_bias: Anthropic and Google models do not
+// expose it, and neither do OpenAI o-series reasoning models.
+func supportsLogitBias(model string) bool {
+	for _, prefix := range []string{"anthropic/", "google/", "claude-", "gemini-"} {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return !isOpenAIReasoningModel(model)
+}
+
+// supportsTopK reports whether top_k can reach the upstream of model: OpenAI models never accept
+// it, and other models only when at least one of the preferred providers honors it.
+func supportsTopK(model string, preferredProviders []openrouter.Provider) bool {
+	if strings.HasPrefix(model, "openai/") {
+		return false
+	}
+	if len(preferredProviders) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(preferredProviders, openrouter.Provider.SupportsTopK)
+}
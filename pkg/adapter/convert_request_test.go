@@ -3,6 +3,9 @@ package adapter
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/samber/lo"
@@ -53,6 +56,16 @@ func testCtxWithOptions(opts func(*profile.Profile)) context.Context {
 	return profile.WithProfile(context.Background(), testProfileWithOptions(opts))
 }
 
+// convertToOpenRouter converts src with ConvertAnthropicRequestToOpenRouterRequest, failing the test on error
+func convertToOpenRouter(t *testing.T, ctx context.Context, src *anthropic.GenerateMessageRequest, options ...ConvertRequestOption) *openrouter.CreateChatCompletionRequest {
+	t.Helper()
+	dst, err := ConvertAnthropicRequestToOpenRouterRequest(ctx, src, options...)
+	if err != nil {
+		t.Fatalf("ConvertAnthropicRequestToOpenRouterRequest failed: %v", err)
+	}
+	return dst
+}
+
 // testCtxWithReasoningFormat creates a test context with specific reasoning format and effort
 func testCtxWithReasoningFormat(format, effort string) context.Context {
 	return testCtxWithOptions(func(p *profile.Profile) {
@@ -150,7 +163,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_BasicFields(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tt.src)
+			got := convertToOpenRouter(t, testCtx(), tt.src)
 			if !tt.want(got) {
 				t.Errorf("ConvertAnthropicRequestToOpenRouterRequest() validation failed")
 			}
@@ -158,6 +171,24 @@ func TestConvertAnthropicRequestToOpenRouterRequest_BasicFields(t *testing.T) {
 	}
 }
 
+func TestConvertAnthropicRequestToOpenRouterRequest_Metadata(t *testing.T) {
+	got := convertToOpenRouter(t, testCtx(), &anthropic.GenerateMessageRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 16,
+		Metadata: &anthropic.Metadata{
+			UserID: "user-1",
+			Extra:  map[string]json.RawMessage{"session": json.RawMessage(`"s-1"`)},
+		},
+		Messages: []*anthropic.Message{},
+	})
+	if got.User != "user-1" {
+		t.Errorf("user = %q, want user-1", got.User)
+	}
+	if len(got.Metadata) != 1 || got.Metadata["session"] != "s-1" {
+		t.Errorf("metadata = %v, want the session key without user_id", got.Metadata)
+	}
+}
+
 func TestConvertAnthropicRequestToOpenRouterRequest_ToolChoice(t *testing.T) {
 	tests := []struct {
 		name string
@@ -235,7 +266,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ToolChoice(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tt.src)
+			got := convertToOpenRouter(t, testCtx(), tt.src)
 			if !tt.want(got) {
 				t.Errorf("ConvertAnthropicRequestToOpenRouterRequest() tool choice validation failed")
 			}
@@ -243,6 +274,51 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ToolChoice(t *testing.T) {
 	}
 }
 
+func TestConvertAnthropicRequestToOpenRouterRequest_ParallelToolCalls(t *testing.T) {
+	toolChoiceTypes := []anthropic.ToolChoiceType{
+		anthropic.ToolChoiceTypeAuto,
+		anthropic.ToolChoiceTypeNone,
+		anthropic.ToolChoiceTypeAny,
+		anthropic.ToolChoiceTypeTool,
+	}
+	for _, toolChoiceType := range toolChoiceTypes {
+		for _, disableParallelToolUse := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/disable_parallel_tool_use=%t", toolChoiceType, disableParallelToolUse), func(t *testing.T) {
+				toolChoice := &anthropic.ToolChoice{
+					Type:                   toolChoiceType,
+					DisableParallelToolUse: disableParallelToolUse,
+				}
+				if toolChoiceType == anthropic.ToolChoiceTypeTool {
+					toolChoice.Name = "get_weather"
+				}
+				src := &anthropic.GenerateMessageRequest{
+					Model:      "claude-3-5-sonnet-20241022",
+					MaxTokens:  500,
+					ToolChoice: toolChoice,
+					Messages:   []*anthropic.Message{},
+				}
+				dst := convertToOpenRouter(t, testCtx(), src)
+				if dst.ParallelToolCalls == nil {
+					t.Fatal("ParallelToolCalls should be set")
+				}
+				if *dst.ParallelToolCalls != !disableParallelToolUse {
+					t.Errorf("ParallelToolCalls = %t, want %t", *dst.ParallelToolCalls, !disableParallelToolUse)
+				}
+			})
+		}
+	}
+	t.Run("no tool choice leaves ParallelToolCalls unset", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 500,
+			Messages:  []*anthropic.Message{},
+		})
+		if dst.ParallelToolCalls != nil {
+			t.Errorf("ParallelToolCalls = %t, want nil", *dst.ParallelToolCalls)
+		}
+	})
+}
+
 func TestConvertAnthropicRequestToOpenRouterRequest_Tools(t *testing.T) {
 	// Set up profile with strict mode enabled
 	ctx := testCtxWithOptions(func(p *profile.Profile) {
@@ -276,7 +352,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Tools(t *testing.T) {
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 
 	if len(got.Tools) != 1 {
 		t.Errorf("Expected 1 tool, got %d", len(got.Tools))
@@ -316,7 +392,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Thinking(t *testing.T) {
 			name: "thinking enabled",
 			src: &anthropic.GenerateMessageRequest{
 				Model:     "claude-3-5-sonnet-20241022",
-				MaxTokens: 500,
+				MaxTokens: 2000,
 				Thinking: &anthropic.Thinking{
 					Type:         anthropic.ThinkingTypeEnabled,
 					BudgetTokens: 1000,
@@ -329,6 +405,42 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Thinking(t *testing.T) {
 					dst.Reasoning.MaxTokens == 1000
 			},
 		},
+		{
+			name: "budget equal to max_tokens is clamped",
+			src: &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: 500,
+				Thinking: &anthropic.Thinking{
+					Type:         anthropic.ThinkingTypeEnabled,
+					BudgetTokens: 500,
+				},
+				Messages: []*anthropic.Message{},
+			},
+			want: func(dst *openrouter.CreateChatCompletionRequest) bool {
+				return dst.Reasoning != nil &&
+					dst.Reasoning.Enabled == true &&
+					dst.Reasoning.MaxTokens == 499 &&
+					*dst.MaxTokens == 500
+			},
+		},
+		{
+			name: "budget above max_tokens is clamped",
+			src: &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: 1000,
+				Thinking: &anthropic.Thinking{
+					Type:         anthropic.ThinkingTypeEnabled,
+					BudgetTokens: 2000,
+				},
+				Messages: []*anthropic.Message{},
+			},
+			want: func(dst *openrouter.CreateChatCompletionRequest) bool {
+				return dst.Reasoning != nil &&
+					dst.Reasoning.Enabled == true &&
+					dst.Reasoning.MaxTokens == 999 &&
+					*dst.MaxTokens == 1000
+			},
+		},
 		{
 			name: "thinking disabled",
 			src: &anthropic.GenerateMessageRequest{
@@ -350,7 +462,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Thinking(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tt.src)
+			got := convertToOpenRouter(t, testCtx(), tt.src)
 			if !tt.want(got) {
 				t.Errorf("ConvertAnthropicRequestToOpenRouterRequest() thinking validation failed")
 			}
@@ -646,7 +758,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Messages(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tt.src)
+			got := convertToOpenRouter(t, testCtx(), tt.src)
 			if !tt.want(got) {
 				t.Errorf("ConvertAnthropicRequestToOpenRouterRequest() message validation failed")
 			}
@@ -654,6 +766,55 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Messages(t *testing.T) {
 	}
 }
 
+func TestConvertAnthropicRequestToOpenRouterRequest_ToolCallOnlyAssistantContent(t *testing.T) {
+	toolUse := &anthropic.MessageContent{
+		Type:  anthropic.MessageContentTypeToolUse,
+		ID:    "tool_123",
+		Name:  "get_weather",
+		Input: json.RawMessage(`{"location":"Paris"}`),
+	}
+	for _, tt := range []struct {
+		name    string
+		content anthropic.MessageContents
+		ctx     context.Context
+	}{
+		{"tool call only", anthropic.MessageContents{toolUse}, testCtx()},
+		{"empty text and tool call", anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: ""}, toolUse}, testCtx()},
+		{"forced parts", anthropic.MessageContents{toolUse}, testCtxWithOptions(func(p *profile.Profile) { p.OpenRouter.ForcePartsContent = true })},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := convertToOpenRouter(t, tt.ctx, &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: 16,
+				Messages: []*anthropic.Message{
+					{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Weather?"}}},
+					{Role: anthropic.MessageRoleAssistant, Content: tt.content},
+				},
+			})
+			var assistant *openrouter.ChatCompletionMessage
+			for _, message := range dst.Messages {
+				if message.Role == openrouter.ChatCompletionMessageRoleAssistant {
+					assistant = message
+				}
+			}
+			if assistant == nil || len(assistant.ToolCalls) != 1 {
+				t.Fatalf("no assistant message with a tool call in %+v", dst.Messages)
+			}
+			data, err := json.Marshal(assistant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]json.RawMessage
+			if err = json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			if content, ok := fields["content"]; ok && string(content) != "null" {
+				t.Errorf("content = %s, want it omitted or null", content)
+			}
+		})
+	}
+}
+
 func TestConvertAnthropicToolResultMessageContentsToOpenRouterChatCompletionMessageContent(t *testing.T) {
 	tests := []struct {
 		name string
@@ -1036,7 +1197,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_EdgeCases(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tt.src)
+			got := convertToOpenRouter(t, testCtx(), tt.src)
 			if !tt.want(got) {
 				t.Errorf("ConvertAnthropicRequestToOpenRouterRequest() edge case validation failed")
 			}
@@ -1044,30 +1205,76 @@ func TestConvertAnthropicRequestToOpenRouterRequest_EdgeCases(t *testing.T) {
 	}
 }
 
-func TestConvertAnthropicRequestToOpenRouterRequest_PanicScenarios(t *testing.T) {
-	t.Run("redacted thinking should panic", func(t *testing.T) {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Error("Expected panic for redacted thinking")
-			}
-		}()
-
-		src := &anthropic.GenerateMessageRequest{
+func TestConvertAnthropicRequestToOpenRouterRequest_RedactedThinking(t *testing.T) {
+	newRequest := func() *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
 			Model:     "claude-3-5-sonnet-20241022",
 			MaxTokens: 500,
 			Messages: []*anthropic.Message{
+				{
+					Role:    anthropic.MessageRoleUser,
+					Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}},
+				},
 				{
 					Role: anthropic.MessageRoleAssistant,
 					Content: anthropic.MessageContents{
-						{
-							Type: anthropic.MessageContentTypeRedactedThinking,
-						},
+						{Type: anthropic.MessageContentTypeRedactedThinking, Data: "EmwKAhgBEgy3va3pzix"},
+						{Type: anthropic.MessageContentTypeText, Text: "Hi there"},
 					},
 				},
 			},
 		}
+	}
+
+	t.Run("drop by default", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), newRequest())
+		if len(dst.Messages) != 2 {
+			t.Fatalf("expected 2 messages, got %d", len(dst.Messages))
+		}
+		assistant := dst.Messages[1]
+		if assistant.Role != openrouter.ChatCompletionMessageRoleAssistant {
+			t.Fatalf("expected assistant message, got %s", assistant.Role)
+		}
+		if len(assistant.ReasoningDetails) != 0 {
+			t.Errorf("expected redacted thinking to be dropped, got %d reasoning details", len(assistant.ReasoningDetails))
+		}
+		if assistant.Content == nil || assistant.Content.Text != "Hi there" {
+			t.Errorf("expected text content to be kept, got %+v", assistant.Content)
+		}
+	})
+
+	t.Run("encrypted", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.RedactedThinkingMode = profile.RedactedThinkingModeEncrypted
+		})
+		dst := convertToOpenRouter(t, ctx, newRequest())
+		if len(dst.Messages) != 2 {
+			t.Fatalf("expected 2 messages, got %d", len(dst.Messages))
+		}
+		assistant := dst.Messages[1]
+		if len(assistant.ReasoningDetails) != 1 {
+			t.Fatalf("expected 1 reasoning detail, got %d", len(assistant.ReasoningDetails))
+		}
+		detail := assistant.ReasoningDetails[0]
+		if detail.Type != openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted ||
+			detail.Data != "EmwKAhgBEgy3va3pzix" ||
+			detail.Format != openrouter.ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1 {
+			t.Errorf("unexpected reasoning detail: %+v", detail)
+		}
+		if assistant.Content == nil || assistant.Content.Text != "Hi there" {
+			t.Errorf("expected text content to be kept, got %+v", assistant.Content)
+		}
+	})
 
-		ConvertAnthropicRequestToOpenRouterRequest(testCtx(), src)
+	t.Run("encrypted dropped for non-anthropic reasoning format", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.RedactedThinkingMode = profile.RedactedThinkingModeEncrypted
+			p.Options.Reasoning.Format = string(openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1)
+		})
+		dst := convertToOpenRouter(t, ctx, newRequest())
+		if got := len(dst.Messages[1].ReasoningDetails); got != 0 {
+			t.Errorf("expected no reasoning details, got %d", got)
+		}
 	})
 }
 
@@ -1156,7 +1363,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ModelMapper(t *testing.T) {
 				p.Options.Models = tt.modelMapper
 			})
 
-			got := ConvertAnthropicRequestToOpenRouterRequest(ctx, tt.src)
+			got := convertToOpenRouter(t, ctx, tt.src)
 
 			if got.Model != tt.wantModel {
 				t.Errorf("ConvertAnthropicRequestToOpenRouterRequest() model = %q, want %q", got.Model, tt.wantModel)
@@ -1208,7 +1415,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ModelMapperIntegration(t *te
 			}
 		})
 
-		got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+		got := convertToOpenRouter(t, ctx, src)
 
 		// Verify model was mapped
 		if got.Model != "anthropic/claude-3-5-sonnet:beta" {
@@ -1257,7 +1464,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ModelMapperIntegration(t *te
 			}
 		})
 
-		got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+		got := convertToOpenRouter(t, ctx, src)
 
 		if got.Model != "anthropic/claude-3-haiku:beta" {
 			t.Errorf("Expected model 'anthropic/claude-3-haiku:beta', got %q", got.Model)
@@ -1431,7 +1638,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_CacheControl(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tc.src)
+			got := convertToOpenRouter(t, testCtx(), tc.src)
 			if !tc.want(got) {
 				t.Errorf("Test case %s failed validation", tc.name)
 			}
@@ -1510,7 +1717,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_SystemMessageTypes(t *testin
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), tc.src)
+			got := convertToOpenRouter(t, testCtx(), tc.src)
 			if !tc.want(got) {
 				t.Errorf("Test case %s failed validation", tc.name)
 			}
@@ -1548,7 +1755,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ToolTypeNil(t *testing.T) {
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 
 	if len(got.Tools) != 1 {
 		t.Errorf("Expected 1 tool, got %d", len(got.Tools))
@@ -1605,7 +1812,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_WebSearchTool(t *testing.T)
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), src)
+	got := convertToOpenRouter(t, testCtx(), src)
 
 	// Web Search Tool should be filtered out (not ToolTypeCustom)
 	if len(got.Tools) != 1 {
@@ -1648,7 +1855,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_CacheControlCleanup(t *testi
 		},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), src)
+	got := convertToOpenRouter(t, testCtx(), src)
 
 	// Should have 1 message with 2 parts (merged by canonicalOpenRouterMessages)
 	if len(got.Messages) != 1 {
@@ -1710,7 +1917,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_NewMessageFormat(t *testing.
 		},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(testCtx(), src)
+	got := convertToOpenRouter(t, testCtx(), src)
 
 	if len(got.Messages) != 2 {
 		t.Errorf("Expected 2 messages, got %d", len(got.Messages))
@@ -1739,6 +1946,56 @@ func TestConvertAnthropicRequestToOpenRouterRequest_NewMessageFormat(t *testing.
 	}
 }
 
+func TestConvertAnthropicRequestToOpenRouterRequest_ForcePartsContent(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 500,
+		System:    anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "You are helpful"}},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{{
+				Type:         anthropic.MessageContentTypeText,
+				Text:         "Hi there",
+				CacheControl: &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral},
+			}}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "ls", Input: []byte(`{}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "a.txt"}}},
+			}},
+		},
+	}
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.OpenRouter.ForcePartsContent = true
+	})
+	got := convertToOpenRouter(t, ctx, src)
+	for _, message := range got.Messages {
+		if message.Content == nil {
+			continue
+		}
+		if message.Content.IsText() && message.Content.Text != "" {
+			t.Errorf("%s message should use Parts format, got text %q", message.Role, message.Content.Text)
+		}
+	}
+	assistant := got.Messages[2]
+	if assistant.Role != openrouter.ChatCompletionMessageRoleAssistant || !assistant.Content.IsParts() || len(assistant.Content.Parts) != 1 {
+		t.Fatalf("assistant message should keep its single text part, got %+v", assistant.Content)
+	}
+	if part := assistant.Content.Parts[0]; part.Text != "Hi there" || part.CacheControl == nil {
+		t.Errorf("assistant part = %+v, want the text with its cache_control", part)
+	}
+	// An assistant message with only tool calls has no text to wrap in a part.
+	if toolCalls := got.Messages[3]; len(toolCalls.ToolCalls) != 1 || (toolCalls.Content != nil && toolCalls.Content.IsParts() && len(toolCalls.Content.Parts) > 0) {
+		t.Errorf("tool call message = %+v", toolCalls)
+	}
+
+	got = convertToOpenRouter(t, testCtx(), src)
+	if !got.Messages[2].Content.IsText() {
+		t.Error("assistant message should collapse to Text format by default")
+	}
+}
+
 func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_AnthropicClaudeV1(t *testing.T) {
 	ctx := testCtxWithReasoningFormat("anthropic-claude-v1", "high")
 
@@ -1752,7 +2009,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_AnthropicCla
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -1777,7 +2034,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_OpenAIRespon
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -1802,7 +2059,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_OpenAIResponsesV1_ModelEffor
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -1830,7 +2087,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_OpenAIResponsesV1_NoSuffixKe
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -1858,7 +2115,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_AnthropicClaudeV1_IgnoreSuff
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -1882,7 +2139,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_OpenAIResponsesV1_ModelEffor
 		Messages:  []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -1914,7 +2171,7 @@ func TestForceThinking_AnthropicClaudeV1_NoReasoningAddsReasoning(t *testing.T)
 		Messages:  []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 
 	if got.Reasoning == nil || !got.Reasoning.Enabled {
 		t.Fatalf("force thinking should enable reasoning")
@@ -1933,16 +2190,16 @@ func TestForceThinking_DoesNotOverrideExistingReasoning(t *testing.T) {
 	src := &anthropic.GenerateMessageRequest{
 		Model:     "claude-3-5-sonnet-20241022",
 		MaxTokens: 1000,
-		Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 1234},
+		Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 567},
 		Messages:  []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 
 	if got.Reasoning == nil || !got.Reasoning.Enabled {
 		t.Fatalf("Reasoning should remain enabled from source")
 	}
-	if got.Reasoning.MaxTokens != 1234 {
+	if got.Reasoning.MaxTokens != 567 {
 		t.Errorf("Existing Reasoning.MaxTokens should be preserved, got %d", got.Reasoning.MaxTokens)
 	}
 }
@@ -1951,7 +2208,7 @@ func TestForceThinking_MaxTokensBoundaries(t *testing.T) {
 	ctx := testCtxWithForceThinking("anthropic-claude-v1")
 
 	srcSmall := &anthropic.GenerateMessageRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 10, Messages: []*anthropic.Message{}}
-	gotSmall := ConvertAnthropicRequestToOpenRouterRequest(ctx, srcSmall)
+	gotSmall := convertToOpenRouter(t, ctx, srcSmall)
 	if gotSmall.Reasoning == nil || !gotSmall.Reasoning.Enabled {
 		t.Fatalf("force thinking should enable reasoning (small)")
 	}
@@ -1963,7 +2220,7 @@ func TestForceThinking_MaxTokensBoundaries(t *testing.T) {
 	}
 
 	srcLarge := &anthropic.GenerateMessageRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 100000, Messages: []*anthropic.Message{}}
-	gotLarge := ConvertAnthropicRequestToOpenRouterRequest(ctx, srcLarge)
+	gotLarge := convertToOpenRouter(t, ctx, srcLarge)
 	if gotLarge.Reasoning == nil || !gotLarge.Reasoning.Enabled {
 		t.Fatalf("force thinking should enable reasoning (large)")
 	}
@@ -1978,6 +2235,150 @@ func TestForceThinking_MaxTokensBoundaries(t *testing.T) {
 	}
 }
 
+func TestDisableThinking_OverridesThinkingAndForceThinking(t *testing.T) {
+	for _, tc := range []struct {
+		format      string
+		model       string
+		wantEffort  openrouter.ChatCompletionReasoningEffort
+		wantExclude bool
+		wantNil     bool
+	}{
+		{format: "anthropic-claude-v1", model: "claude-sonnet-4", wantNil: true},
+		{format: "unknown", model: "claude-sonnet-4", wantNil: true},
+		{format: "openai-responses-v1", model: "openai/gpt-5", wantEffort: openrouter.ChatCompletionReasoningEffortMinimal, wantExclude: true},
+		{format: "openai-responses-v1", model: "openai/o3", wantEffort: openrouter.ChatCompletionReasoningEffortLow, wantExclude: true},
+		{format: "openai-responses-v1", model: "openai/gpt-4.1", wantNil: true},
+		{format: "openai-chat-v1", model: "openai/gpt-4o", wantNil: true},
+		{format: "google-gemini-v1", model: "google/gemini-2.5-pro", wantExclude: true},
+	} {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.Reasoning.Format = tc.format
+			p.Options.Reasoning.Effort = "high"
+			p.Options.DisableThinking = true
+			p.Anthropic.ForceThinking = true
+		})
+		src := &anthropic.GenerateMessageRequest{
+			Model:     tc.model,
+			MaxTokens: 4096,
+			Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 2048},
+			Messages:  []*anthropic.Message{},
+		}
+		got := convertToOpenRouter(t, ctx, src)
+		if tc.wantNil {
+			if got.Reasoning != nil {
+				t.Errorf("%s %s: reasoning should be omitted, got %+v", tc.format, tc.model, got.Reasoning)
+			}
+			continue
+		}
+		if got.Reasoning == nil {
+			t.Fatalf("%s %s: reasoning should be set", tc.format, tc.model)
+		}
+		if got.Reasoning.Effort != tc.wantEffort || got.Reasoning.Exclude != tc.wantExclude || got.Reasoning.MaxTokens != 0 {
+			t.Errorf("%s %s: reasoning = %+v", tc.format, tc.model, got.Reasoning)
+		}
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_WebSearchPlugin(t *testing.T) {
+	newRequest := func() *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "openai/gpt-5",
+			MaxTokens: 1024,
+			Tools: []*anthropic.Tool{
+				{Type: lo.ToPtr(anthropic.ToolTypeWebSearch2025), Name: "web_search", MaxUses: 5},
+			},
+			ToolChoice: &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeTool, Name: "web_search"},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "When was Go 1.24 released?"}}},
+			},
+		}
+	}
+
+	got := convertToOpenRouter(t, testCtx(), newRequest())
+	if len(got.Plugins) != 0 {
+		t.Errorf("plugins = %+v, want none without openrouter.web_search", got.Plugins)
+	}
+
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.OpenRouter.WebSearch = true
+	})
+	got = convertToOpenRouter(t, ctx, newRequest())
+	if len(got.Plugins) != 1 || got.Plugins[0].ID != openrouter.ChatCompletionPluginIDWeb {
+		t.Errorf("plugins = %+v, want the web plugin", got.Plugins)
+	}
+	if len(got.Tools) != 0 || got.ToolChoice != nil || got.ParallelToolCalls != nil {
+		t.Errorf("tools = %+v, tool_choice = %+v, want the web search tool removed", got.Tools, got.ToolChoice)
+	}
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"plugins":[{"id":"web"}]`) {
+		t.Errorf("request = %s", data)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_DefaultMaxTokensAndCap(t *testing.T) {
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.DefaultMaxTokens = 4096
+		p.Options.MaxTokensCap = 16000
+	})
+	tests := []struct {
+		name      string
+		maxTokens int
+		want      int
+	}{
+		{"zero uses the default", 0, 4096},
+		{"within cap is kept", 8192, 8192},
+		{"over cap is clamped", 64000, 16000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &anthropic.GenerateMessageRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: tt.maxTokens, Messages: []*anthropic.Message{}}
+			got := convertToOpenRouter(t, ctx, src)
+			if got.MaxTokens == nil || *got.MaxTokens != tt.want {
+				t.Errorf("MaxTokens = %v, want %d", lo.FromPtr(got.MaxTokens), tt.want)
+			}
+		})
+	}
+
+	t.Run("thinking budget follows the cap", func(t *testing.T) {
+		src := &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 64000,
+			Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 32000},
+			Messages:  []*anthropic.Message{},
+		}
+		got := convertToOpenRouter(t, ctx, src)
+		if got.Reasoning == nil || got.Reasoning.MaxTokens != 15999 {
+			t.Errorf("Reasoning = %+v, want the budget clamped below the capped max_tokens", got.Reasoning)
+		}
+	})
+
+	t.Run("force thinking stays within the cap", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.MaxTokensCap = 16000
+			p.Anthropic.ForceThinking = true
+		})
+		src := &anthropic.GenerateMessageRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 0, Messages: []*anthropic.Message{}}
+		got := convertToOpenRouter(t, ctx, src)
+		if got.MaxTokens == nil || *got.MaxTokens != 16000 {
+			t.Errorf("MaxTokens = %v, want the 32768 promotion capped to 16000", lo.FromPtr(got.MaxTokens))
+		}
+		if got.Reasoning == nil || got.Reasoning.MaxTokens != 15999 {
+			t.Errorf("Reasoning = %+v, want a budget of 15999", got.Reasoning)
+		}
+	})
+
+	t.Run("zero without a default is kept", func(t *testing.T) {
+		src := &anthropic.GenerateMessageRequest{Model: "claude-3-5-sonnet-20241022", MaxTokens: 0, Messages: []*anthropic.Message{}}
+		got := convertToOpenRouter(t, testCtx(), src)
+		if got.MaxTokens == nil || *got.MaxTokens != 0 {
+			t.Errorf("MaxTokens = %v, want 0", lo.FromPtr(got.MaxTokens))
+		}
+	})
+}
+
 func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_GoogleGeminiV1(t *testing.T) {
 	ctx := testCtxWithReasoningFormat("google-gemini-v1", "")
 
@@ -1991,7 +2392,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_GoogleGemini
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -2083,7 +2484,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_GoogleGeminiV1_ForceEnabled(
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := ConvertAnthropicRequestToOpenRouterRequest(ctx, tt.src)
+			got := convertToOpenRouter(t, ctx, tt.src)
 			tt.wantCheck(t, got)
 		})
 	}
@@ -2284,6 +2685,156 @@ func TestCanonicalOpenRouterMessages_OpenAIResponsesV1Format(t *testing.T) {
 	}
 }
 
+func TestCanonicalOpenRouterMessages_OpenAIChatV1Format(t *testing.T) {
+	prof := testProfileWithOptions(func(p *profile.Profile) {
+		p.Options.Reasoning.Format = "openai-chat-v1"
+	})
+
+	sharedMsg := &anthropic.Message{Role: anthropic.MessageRoleAssistant}
+
+	src := []*openrouterChatCompletionMessageWrapper{
+		{
+			ChatCompletionMessage: &openrouter.ChatCompletionMessage{
+				Role: openrouter.ChatCompletionMessageRoleAssistant,
+				Content: &openrouter.ChatCompletionMessageContent{
+					Type: openrouter.ChatCompletionMessageContentTypeText,
+					Text: "Let me think about this",
+				},
+				ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{
+					{
+						Type:      openrouter.ChatCompletionMessageReasoningDetailTypeReasoningText,
+						Text:      "First thought",
+						Signature: "rs_1/encrypted",
+					},
+				},
+			},
+			underlyingAnthropicMessage: sharedMsg,
+		},
+	}
+
+	messages := canonicalOpenRouterMessages(prof, "openai/gpt-oss-120b", src)
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 merged message, got %d", len(messages))
+	}
+
+	msg := messages[0]
+	if len(msg.ReasoningDetails) != 2 {
+		t.Fatalf("Expected 2 reasoning details, got %d", len(msg.ReasoningDetails))
+	}
+
+	// For openai-chat-v1, text should stay in reasoning.text
+	textDetail := msg.ReasoningDetails[0]
+	if textDetail.Type != openrouter.ChatCompletionMessageReasoningDetailTypeReasoningText {
+		t.Errorf("Expected reasoning detail to be text type, got %s", textDetail.Type)
+	}
+	if textDetail.Format != openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1 {
+		t.Errorf("Expected format openai-chat-v1, got %s", textDetail.Format)
+	}
+	if textDetail.Text != "First thought" || textDetail.Summary != "" {
+		t.Errorf("Expected text 'First thought' and empty summary, got text=%q summary=%q", textDetail.Text, textDetail.Summary)
+	}
+
+	encryptedDetail := msg.ReasoningDetails[1]
+	if encryptedDetail.Type != openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted ||
+		encryptedDetail.Format != openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIChatV1 ||
+		encryptedDetail.ID != "rs_1" ||
+		encryptedDetail.Data != "encrypted" {
+		t.Errorf("Unexpected encrypted detail: %#v", encryptedDetail)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_OpenAIChatV1(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		effort     string
+		wantModel  string
+		wantEffort string
+	}{
+		{name: "configured effort", model: "openai/gpt-oss-120b", effort: "medium", wantModel: "openai/gpt-oss-120b", wantEffort: "medium"},
+		{name: "model suffix effort", model: "openai/gpt-oss-120b:high", effort: "low", wantModel: "openai/gpt-oss-120b", wantEffort: "high"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithReasoningFormat("openai-chat-v1", tt.effort)
+			src := &anthropic.GenerateMessageRequest{
+				Model:     tt.model,
+				MaxTokens: 500,
+				Thinking: &anthropic.Thinking{
+					Type:         anthropic.ThinkingTypeEnabled,
+					BudgetTokens: 200,
+				},
+				Messages: []*anthropic.Message{},
+			}
+			got := convertToOpenRouter(t, ctx, src)
+			if got.Reasoning == nil {
+				t.Fatalf("Reasoning is nil")
+			}
+			if got.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", got.Model, tt.wantModel)
+			}
+			if got.Reasoning.MaxTokens != 0 {
+				t.Errorf("MaxTokens should be zeroed for OpenAIChatV1, got %d", got.Reasoning.MaxTokens)
+			}
+			if string(got.Reasoning.Effort) != tt.wantEffort {
+				t.Errorf("Effort = %q, want %q", got.Reasoning.Effort, tt.wantEffort)
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_EffortSuffix(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		suffixFormats []string
+		model         string
+		wantModel     string
+		wantEffort    string
+	}{
+		{name: "responses suffix", format: "openai-responses-v1", model: "openai/gpt-5:low", wantModel: "openai/gpt-5", wantEffort: "low"},
+		{name: "responses without suffix", format: "openai-responses-v1", model: "openai/gpt-5", wantModel: "openai/gpt-5", wantEffort: "medium"},
+		{name: "chat suffix", format: "openai-chat-v1", model: "openai/gpt-oss-120b:minimal", wantModel: "openai/gpt-oss-120b", wantEffort: "minimal"},
+		{name: "variant suffix kept", format: "openai-chat-v1", model: "openai/gpt-oss-120b:free", wantModel: "openai/gpt-oss-120b:free", wantEffort: "medium"},
+		{name: "variant and effort suffix", format: "openai-chat-v1", model: "openai/gpt-oss-120b:free:high", wantModel: "openai/gpt-oss-120b:free", wantEffort: "high"},
+		{name: "format not configured", format: "openai-chat-v1", suffixFormats: []string{"openai-responses-v1"}, model: "openai/gpt-oss-120b:high", wantModel: "openai/gpt-oss-120b:high", wantEffort: "medium"},
+		{name: "gemini suffix", format: "google-gemini-v1", suffixFormats: []string{"google-gemini-v1"}, model: "google/gemini-2.5-pro:high", wantModel: "google/gemini-2.5-pro", wantEffort: "high"},
+		{name: "gemini without suffix", format: "google-gemini-v1", suffixFormats: []string{"google-gemini-v1"}, model: "google/gemini-2.5-pro", wantModel: "google/gemini-2.5-pro"},
+		{name: "gemini not configured", format: "google-gemini-v1", model: "google/gemini-2.5-pro:high", wantModel: "google/gemini-2.5-pro:high"},
+		{name: "anthropic ignores suffix", format: "anthropic-claude-v1", suffixFormats: []string{"anthropic-claude-v1"}, model: "anthropic/claude-sonnet-4:high", wantModel: "anthropic/claude-sonnet-4:high"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.Reasoning.Format = tt.format
+				p.Options.Reasoning.Effort = "medium"
+				p.Options.Reasoning.EffortSuffixFormats = tt.suffixFormats
+			})
+			got := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:     tt.model,
+				MaxTokens: 500,
+				Thinking: &anthropic.Thinking{
+					Type:         anthropic.ThinkingTypeEnabled,
+					BudgetTokens: 200,
+				},
+				Messages: []*anthropic.Message{},
+			})
+			if got.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", got.Model, tt.wantModel)
+			}
+			if got.Reasoning == nil {
+				t.Fatalf("Reasoning is nil")
+			}
+			if string(got.Reasoning.Effort) != tt.wantEffort {
+				t.Errorf("Effort = %q, want %q", got.Reasoning.Effort, tt.wantEffort)
+			}
+			if tt.wantEffort != "" && got.Reasoning.MaxTokens != 0 {
+				t.Errorf("MaxTokens should be zeroed when an effort is set, got %d", got.Reasoning.MaxTokens)
+			}
+		})
+	}
+}
+
 // Tests for ChatCompletionMessageReasoningDetailFormatUnknown
 
 func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_Unknown(t *testing.T) {
@@ -2299,7 +2850,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningFormat_Unknown(t *t
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -2325,7 +2876,7 @@ func TestConvertAnthropicRequestToOpenRouterRequest_Unknown_IgnoreSuffixAndKeepM
 		Messages: []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 	if got.Reasoning == nil {
 		t.Fatalf("Reasoning is nil")
 	}
@@ -2350,7 +2901,7 @@ func TestForceThinking_UnknownFormat_NoReasoningAddsReasoning(t *testing.T) {
 		Messages:  []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 
 	// Unknown format should behave like AnthropicClaudeV1 with force thinking
 	if got.Reasoning == nil || !got.Reasoning.Enabled {
@@ -2370,16 +2921,16 @@ func TestForceThinking_UnknownFormat_DoesNotOverrideExistingReasoning(t *testing
 	src := &anthropic.GenerateMessageRequest{
 		Model:     "some-unknown-model",
 		MaxTokens: 1000,
-		Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 1234},
+		Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 567},
 		Messages:  []*anthropic.Message{},
 	}
 
-	got := ConvertAnthropicRequestToOpenRouterRequest(ctx, src)
+	got := convertToOpenRouter(t, ctx, src)
 
 	if got.Reasoning == nil || !got.Reasoning.Enabled {
 		t.Fatalf("Reasoning should remain enabled from source")
 	}
-	if got.Reasoning.MaxTokens != 1234 {
+	if got.Reasoning.MaxTokens != 567 {
 		t.Errorf("Existing Reasoning.MaxTokens should be preserved, got %d", got.Reasoning.MaxTokens)
 	}
 }
@@ -2575,3 +3126,859 @@ func TestCanonicalOpenRouterMessages_UnknownFormat_WithSignatureWithoutDelimiter
 		t.Errorf("Expected Data to contain whole signature, got %q", encrypted.Data)
 	}
 }
+
+func TestConvertAnthropicRequestToOpenRouterRequest_DocumentContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		document *anthropic.MessageContent
+		want     func(*openrouter.ChatCompletionMessageContentPart) bool
+	}{
+		{
+			name: "base64 pdf becomes data URL file part",
+			document: &anthropic.MessageContent{
+				Type: anthropic.MessageContentTypeDocument,
+				Source: &anthropic.MessageContentSource{
+					Type:      anthropic.MessageContentSourceTypeBase64,
+					MediaType: "application/pdf",
+					Data:      "JVBERi0xLjQK",
+				},
+				Title: "report.pdf",
+			},
+			want: func(part *openrouter.ChatCompletionMessageContentPart) bool {
+				return part.IsFile() &&
+					part.File != nil &&
+					part.File.Filename == "report.pdf" &&
+					part.File.FileData == "data:application/pdf;base64,JVBERi0xLjQK"
+			},
+		},
+		{
+			name: "url pdf is passed through",
+			document: &anthropic.MessageContent{
+				Type: anthropic.MessageContentTypeDocument,
+				Source: &anthropic.MessageContentSource{
+					Type: anthropic.MessageContentSourceTypeURL,
+					URL:  "https://example.com/paper.pdf",
+				},
+			},
+			want: func(part *openrouter.ChatCompletionMessageContentPart) bool {
+				return part.IsFile() &&
+					part.File != nil &&
+					part.File.Filename == "document.pdf" &&
+					part.File.FileData == "https://example.com/paper.pdf"
+			},
+		},
+		{
+			name: "untitled base64 document is named after its media type",
+			document: &anthropic.MessageContent{
+				Type: anthropic.MessageContentTypeDocument,
+				Source: &anthropic.MessageContentSource{
+					Type:      anthropic.MessageContentSourceTypeBase64,
+					MediaType: "text/plain",
+					Data:      "aGVsbG8=",
+				},
+			},
+			want: func(part *openrouter.ChatCompletionMessageContentPart) bool {
+				return part.IsFile() &&
+					part.File != nil &&
+					part.File.Filename == "document.txt" &&
+					part.File.FileData == "data:text/plain;base64,aGVsbG8="
+			},
+		},
+		{
+			name: "text document becomes a text part",
+			document: &anthropic.MessageContent{
+				Type: anthropic.MessageContentTypeDocument,
+				Source: &anthropic.MessageContentSource{
+					Type:      anthropic.MessageContentSourceTypeText,
+					MediaType: "text/plain",
+					Data:      "The grass is green.",
+				},
+			},
+			want: func(part *openrouter.ChatCompletionMessageContentPart) bool {
+				return part.IsText() && part.Text == "The grass is green."
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: 1000,
+				Messages: []*anthropic.Message{
+					{
+						Role: anthropic.MessageRoleUser,
+						Content: anthropic.MessageContents{
+							{Type: anthropic.MessageContentTypeText, Text: "Summarize this document."},
+							tt.document,
+						},
+					},
+				},
+			}
+			dst := convertToOpenRouter(t, testCtx(), src)
+			if len(dst.Messages) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(dst.Messages))
+			}
+			content := dst.Messages[0].Content
+			if content == nil || len(content.Parts) != 2 {
+				t.Fatalf("expected 2 content parts, got %#v", content)
+			}
+			if !content.Parts[0].IsText() || content.Parts[0].Text != "Summarize this document." {
+				t.Errorf("unexpected text part: %#v", content.Parts[0])
+			}
+			if !tt.want(content.Parts[1]) {
+				t.Errorf("unexpected document part: %#v (file %#v)", content.Parts[1], content.Parts[1].File)
+			}
+			if !content.Parts[1].IsFile() {
+				return
+			}
+			raw, err := json.Marshal(content.Parts[1])
+			if err != nil {
+				t.Fatalf("marshal file part: %v", err)
+			}
+			if !strings.Contains(string(raw), `"type":"file"`) || !strings.Contains(string(raw), `"file_data"`) {
+				t.Errorf("unexpected file part JSON: %s", raw)
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicToolResultMessageContents_Document(t *testing.T) {
+	dst := convertAnthropicToolResultMessageContentsToOpenRouterChatCompletionMessageContent(anthropic.MessageContents{
+		{
+			Type: anthropic.MessageContentTypeDocument,
+			Source: &anthropic.MessageContentSource{
+				Type:      anthropic.MessageContentSourceTypeBase64,
+				MediaType: "application/pdf",
+				Data:      "JVBERi0xLjQK",
+			},
+		},
+		{
+			Type:   anthropic.MessageContentTypeDocument,
+			Source: &anthropic.MessageContentSource{Type: anthropic.MessageContentSourceTypeText, MediaType: "text/plain", Data: "plain"},
+		},
+		{
+			// content documents have no OpenRouter representation and are dropped
+			Type:   anthropic.MessageContentTypeDocument,
+			Source: &anthropic.MessageContentSource{Type: "content"},
+		},
+	})
+	if len(dst.Parts) != 2 {
+		t.Fatalf("expected 2 parts, got %#v", dst.Parts)
+	}
+	if !dst.Parts[0].IsFile() || dst.Parts[0].File.FileData != "data:application/pdf;base64,JVBERi0xLjQK" {
+		t.Errorf("unexpected file part: %#v", dst.Parts[0])
+	}
+	if !dst.Parts[1].IsText() || dst.Parts[1].Text != "plain" {
+		t.Errorf("unexpected text part: %#v", dst.Parts[1])
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ContextWindowResizeFactor(t *testing.T) {
+	tests := []struct {
+		name          string
+		factor        float64
+		maxTokens     int
+		minMaxTokens  int
+		transforms    []string
+		wantMaxTokens int
+	}{
+		{name: "factor 0.5", factor: 0.5, maxTokens: 1000, wantMaxTokens: 500},
+		{name: "middle-out skips scaling", factor: 0.5, maxTokens: 1000, transforms: []string{"middle-out"}, wantMaxTokens: 1000},
+		{name: "factor 2.0", factor: 2.0, maxTokens: 1000, wantMaxTokens: 2000},
+		{name: "unset factor keeps max_tokens", factor: 0, maxTokens: 1000, wantMaxTokens: 1000},
+		{name: "rounds to nearest", factor: 0.6, maxTokens: 1001, wantMaxTokens: 601},
+		{name: "never drops to zero", factor: 0.1, maxTokens: 1, wantMaxTokens: 1},
+		{name: "min_max_tokens applies after scaling", factor: 0.5, maxTokens: 1000, minMaxTokens: 800, wantMaxTokens: 800},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.ContextWindowResizeFactor = tt.factor
+				p.Options.MinMaxTokens = tt.minMaxTokens
+				p.OpenRouter.Transforms = tt.transforms
+			})
+			got := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: tt.maxTokens,
+				Messages:  []*anthropic.Message{},
+			})
+			if got.MaxTokens == nil || *got.MaxTokens != tt.wantMaxTokens {
+				t.Errorf("MaxTokens = %v, want %d", got.MaxTokens, tt.wantMaxTokens)
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ClampCacheTTL(t *testing.T) {
+	tests := []struct {
+		name               string
+		clamp              bool
+		preferredProviders []openrouter.Provider
+		ttl                anthropic.MessageCacheControlTTL
+		wantTTL            openrouter.ChatCompletionMessageCacheControlTTL
+	}{
+		{name: "flag off keeps 1h", ttl: anthropic.MessageCacheControlTTL1Hour, wantTTL: "1h"},
+		{name: "flag on clamps 1h", clamp: true, preferredProviders: []openrouter.Provider{openrouter.ProviderGoogleVertex}, ttl: anthropic.MessageCacheControlTTL1Hour, wantTTL: "5m"},
+		{name: "flag on without providers clamps 1h", clamp: true, ttl: anthropic.MessageCacheControlTTL1Hour, wantTTL: "5m"},
+		{name: "flag on keeps 5m", clamp: true, ttl: anthropic.MessageCacheControlTTL5Minutes, wantTTL: "5m"},
+		{name: "anthropic provider keeps 1h", clamp: true, preferredProviders: []openrouter.Provider{openrouter.ProviderGoogleVertex, openrouter.ProviderAnthropic}, ttl: anthropic.MessageCacheControlTTL1Hour, wantTTL: "1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.OpenRouter.ClampCacheTTL = tt.clamp
+				p.OpenRouter.PreferredProviders = tt.preferredProviders
+			})
+			cacheControl := &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral, TTL: tt.ttl}
+			got := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: 1000,
+				System:    anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "system", CacheControl: cacheControl}},
+				Messages: []*anthropic.Message{
+					{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello", CacheControl: cacheControl}}},
+				},
+			})
+			var seen int
+			for _, message := range got.Messages {
+				if message.Content == nil || !message.Content.IsParts() {
+					continue
+				}
+				for _, part := range message.Content.Parts {
+					if part.CacheControl == nil {
+						continue
+					}
+					seen++
+					if part.CacheControl.TTL != tt.wantTTL {
+						t.Errorf("%s message TTL = %q, want %q", message.Role, part.CacheControl.TTL, tt.wantTTL)
+					}
+				}
+			}
+			if seen != 2 {
+				t.Errorf("expected 2 cache breakpoints, got %d", seen)
+			}
+			if cacheControl.TTL != tt.ttl {
+				t.Errorf("source cache control mutated: %q", cacheControl.TTL)
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_SystemPrefixSuffix(t *testing.T) {
+	newRequest := func(system anthropic.MessageContents) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 500,
+			System:    system,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}},
+			},
+		}
+	}
+	systemTexts := func(dst *openrouter.CreateChatCompletionRequest) []string {
+		if len(dst.Messages) == 0 || dst.Messages[0].Role != openrouter.ChatCompletionMessageRoleSystem {
+			return nil
+		}
+		texts := make([]string, 0, len(dst.Messages[0].Content.Parts))
+		for _, part := range dst.Messages[0].Content.Parts {
+			texts = append(texts, part.Text)
+		}
+		return texts
+	}
+
+	t.Run("prefix and suffix surround original blocks", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.SystemPrefix = "Compliance notice"
+			p.Options.SystemSuffix = "Tool guidelines"
+		})
+		src := newRequest(anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are Claude Code"},
+			{Type: anthropic.MessageContentTypeText, Text: "Environment", CacheControl: &anthropic.CacheControl{Type: anthropic.MessageCacheControlTypeEphemeral}},
+		})
+		dst := convertToOpenRouter(t, ctx, src)
+		want := []string{"Compliance notice", "You are Claude Code", "Environment", "Tool guidelines"}
+		if got := systemTexts(dst); !reflect.DeepEqual(got, want) {
+			t.Fatalf("system parts = %q, want %q", got, want)
+		}
+		parts := dst.Messages[0].Content.Parts
+		if parts[0].CacheControl != nil || parts[3].CacheControl != nil {
+			t.Error("injected blocks must not carry cache_control")
+		}
+		if parts[2].CacheControl == nil {
+			t.Error("cache_control on the original system block should be preserved")
+		}
+		if len(src.System) != 2 {
+			t.Errorf("source system contents should not be modified, got %d blocks", len(src.System))
+		}
+	})
+
+	t.Run("prefix without original system prompt", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.SystemPrefix = "Compliance notice"
+		})
+		dst := convertToOpenRouter(t, ctx, newRequest(nil))
+		if got, want := systemTexts(dst), []string{"Compliance notice"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("system parts = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("empty values are no-ops", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), newRequest(anthropic.MessageContents{
+			{Type: anthropic.MessageContentTypeText, Text: "You are Claude Code"},
+		}))
+		if got, want := systemTexts(dst), []string{"You are Claude Code"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("system parts = %q, want %q", got, want)
+		}
+		dst = convertToOpenRouter(t, testCtx(), newRequest(nil))
+		if len(dst.Messages) != 1 || dst.Messages[0].Role != openrouter.ChatCompletionMessageRoleUser {
+			t.Fatalf("expected only the user message, got %d messages", len(dst.Messages))
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_SamplingParams(t *testing.T) {
+	newRequest := func(model string, temperature float64, topP *float64) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:       model,
+			MaxTokens:   500,
+			Temperature: temperature,
+			TopP:        topP,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}},
+			},
+		}
+	}
+	t.Run("reasoning model drops temperature and top_p", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.Models = map[string]string{"claude-sonnet-4": "openai/o3-mini"}
+		})
+		dst := convertToOpenRouter(t, ctx, newRequest("claude-sonnet-4", 0.7, lo.ToPtr(0.9)))
+		if dst.Model != "openai/o3-mini" {
+			t.Fatalf("model = %q, want openai/o3-mini", dst.Model)
+		}
+		if dst.Temperature != nil || dst.TopP != nil {
+			t.Errorf("sampling parameters should be dropped, got temperature=%v top_p=%v", dst.Temperature, dst.TopP)
+		}
+		data, err := json.Marshal(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), `"temperature"`) {
+			t.Errorf("temperature should not be serialized: %s", data)
+		}
+	})
+	t.Run("out of range values are clamped", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), newRequest("claude-3-5-sonnet-20241022", 2.5, lo.ToPtr(1.5)))
+		if dst.Temperature == nil || *dst.Temperature != 2 {
+			t.Errorf("temperature = %v, want 2", dst.Temperature)
+		}
+		if dst.TopP == nil || *dst.TopP != 1 {
+			t.Errorf("top_p = %v, want 1", dst.TopP)
+		}
+	})
+	t.Run("in range values are kept", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), newRequest("openai/gpt-4o", 0.7, nil))
+		if dst.Temperature == nil || *dst.Temperature != 0.7 || dst.TopP != nil {
+			t.Errorf("unexpected sampling parameters: temperature=%v top_p=%v", dst.Temperature, dst.TopP)
+		}
+	})
+	t.Run("anthropic model keeps only one of temperature and top_p", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.Options.Models = map[string]string{"claude-sonnet-4-5": "anthropic/claude-sonnet-4.5"}
+		})
+		dst := convertToOpenRouter(t, ctx, newRequest("claude-sonnet-4-5", 0.7, lo.ToPtr(0.9)))
+		if dst.Temperature == nil || *dst.Temperature != 0.7 || dst.TopP != nil {
+			t.Errorf("expected only temperature, got temperature=%v top_p=%v", dst.Temperature, dst.TopP)
+		}
+		dst = convertToOpenRouter(t, ctx, newRequest("claude-sonnet-4-5", 0, lo.ToPtr(0.9)))
+		if dst.Temperature != nil || dst.TopP == nil || *dst.TopP != 0.9 {
+			t.Errorf("expected only top_p, got temperature=%v top_p=%v", dst.Temperature, dst.TopP)
+		}
+	})
+	t.Run("other models keep both temperature and top_p", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), newRequest("claude-sonnet-4-20250514", 0.7, lo.ToPtr(0.9)))
+		if dst.Temperature == nil || *dst.Temperature != 0.7 || dst.TopP == nil || *dst.TopP != 0.9 {
+			t.Errorf("unexpected sampling parameters: temperature=%v top_p=%v", dst.Temperature, dst.TopP)
+		}
+	})
+}
+
+func TestSupportsTemperatureWithTopP(t *testing.T) {
+	for model, want := range map[string]bool{
+		"anthropic/claude-sonnet-4.5":   false,
+		"anthropic/claude-opus-4.1":     false,
+		"claude-opus-4-1-20250805":      false,
+		"claude-haiku-4-5":              false,
+		"anthropic/claude-sonnet-4":     true,
+		"claude-sonnet-4-20250514":      true,
+		"claude-3-5-sonnet-20241022":    true,
+		"anthropic/claude-3.7-sonnet":   true,
+		"openai/gpt-4o":                 true,
+		"google/gemini-2.5-pro-preview": true,
+	} {
+		if got := supportsTemperatureWithTopP(model); got != want {
+			t.Errorf("supportsTemperatureWithTopP(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestIsOpenAIReasoningModel(t *testing.T) {
+	for model, want := range map[string]bool{
+		"openai/o1":          true,
+		"openai/o3-mini":     true,
+		"o4-mini":            true,
+		"openai/gpt-4o":      false,
+		"openai/gpt-4o-mini": false,
+		"anthropic/opus-4":   false,
+		"other/o3":           false,
+		"o":                  false,
+	} {
+		if got := isOpenAIReasoningModel(model); got != want {
+			t.Errorf("isOpenAIReasoningModel(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ServiceTier(t *testing.T) {
+	src := &anthropic.GenerateMessageRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 500,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}},
+		},
+	}
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.OpenRouter.ServiceTier = openrouter.ServiceTierFlex
+	})
+	dst := convertToOpenRouter(t, ctx, src)
+	if dst.ServiceTier != openrouter.ServiceTierFlex {
+		t.Errorf("service_tier = %q, want flex", dst.ServiceTier)
+	}
+	if dst = convertToOpenRouter(t, testCtx(), src); dst.ServiceTier != "" {
+		t.Errorf("service_tier = %q, want unset", dst.ServiceTier)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ToolResultIsError(t *testing.T) {
+	newRequest := func(toolResult *anthropic.MessageContent) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 500,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Read /tmp/missing"}}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "read_file", Input: []byte(`{"path":"/tmp/missing"}`)}}},
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{toolResult}},
+			},
+		}
+	}
+	toolMessage := func(t *testing.T, dst *openrouter.CreateChatCompletionRequest) *openrouter.ChatCompletionMessage {
+		t.Helper()
+		for _, message := range dst.Messages {
+			if message.Role == openrouter.ChatCompletionMessageRoleTool {
+				return message
+			}
+		}
+		t.Fatal("no tool message in converted request")
+		return nil
+	}
+
+	t.Run("text error payload", func(t *testing.T) {
+		var toolResult anthropic.MessageContent
+		if err := json.Unmarshal([]byte(`{"type":"tool_result","tool_use_id":"toolu_1","is_error":true,"content":"ENOENT: no such file"}`), &toolResult); err != nil {
+			t.Fatal(err)
+		}
+		if !toolResult.IsError {
+			t.Fatal("is_error should be decoded")
+		}
+		message := toolMessage(t, convertToOpenRouter(t, testCtx(), newRequest(&toolResult)))
+		if message.ToolCallID != "toolu_1" || len(message.Content.Parts) != 2 {
+			t.Fatalf("unexpected tool message: %+v", message.Content)
+		}
+		if message.Content.Parts[0].Text != ToolResultErrorText || message.Content.Parts[1].Text != "ENOENT: no such file" {
+			t.Errorf("parts = %q, %q", message.Content.Parts[0].Text, message.Content.Parts[1].Text)
+		}
+	})
+
+	t.Run("error without content", func(t *testing.T) {
+		message := toolMessage(t, convertToOpenRouter(t, testCtx(), newRequest(&anthropic.MessageContent{
+			Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", IsError: true,
+		})))
+		if len(message.Content.Parts) != 1 || message.Content.Parts[0].Text != ToolResultErrorText {
+			t.Errorf("unexpected tool message content: %+v", message.Content)
+		}
+	})
+
+	t.Run("successful result is unchanged", func(t *testing.T) {
+		message := toolMessage(t, convertToOpenRouter(t, testCtx(), newRequest(&anthropic.MessageContent{
+			Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1",
+			Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "contents"}},
+		})))
+		if len(message.Content.Parts) != 1 || message.Content.Parts[0].Text != "contents" {
+			t.Errorf("unexpected tool message content: %+v", message.Content)
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_TopK(t *testing.T) {
+	src := func(model string) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     model,
+			MaxTokens: 500,
+			TopK:      lo.ToPtr(40),
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Hello"}}},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		model     string
+		providers []openrouter.Provider
+		wantTopK  bool
+	}{
+		{name: "anthropic model", model: "anthropic/claude-sonnet-4", wantTopK: true},
+		{name: "openai model", model: "openai/gpt-4o", wantTopK: false},
+		{name: "only providers without top_k", model: "deepseek/deepseek-chat", providers: []openrouter.Provider{openrouter.ProviderDeepSeek}, wantTopK: false},
+		{name: "one provider with top_k", model: "deepseek/deepseek-chat", providers: []openrouter.Provider{openrouter.ProviderDeepSeek, openrouter.ProviderTogether}, wantTopK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.OpenRouter.PreferredProviders = tt.providers
+			})
+			dst := convertToOpenRouter(t, ctx, src(tt.model))
+			if got := dst.TopK != nil; got != tt.wantTopK {
+				t.Fatalf("top_k forwarded = %v, want %v", got, tt.wantTopK)
+			}
+			data, err := json.Marshal(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantTopK && strings.Contains(string(data), `"top_k"`) {
+				t.Errorf("top_k leaked into the request: %s", data)
+			}
+		})
+	}
+}
+
+func TestCanonicalOpenRouterMessages_MergeConsecutiveRoles(t *testing.T) {
+	req := &anthropic.GenerateMessageRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 500,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "First"}}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeText, Text: "Second"},
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{Type: anthropic.MessageContentSourceTypeURL, URL: "https://example.com/a.png"}},
+			}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "ls", Input: []byte(`{}`)},
+				{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_2", Name: "pwd", Input: []byte(`{}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "a.txt"}}},
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_2", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "/tmp"}}},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Third"}}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Fourth"}}},
+		},
+	}
+	roles := func(messages []*openrouter.ChatCompletionMessage) []openrouter.ChatCompletionRole {
+		return lo.Map(messages, func(m *openrouter.ChatCompletionMessage, _ int) openrouter.ChatCompletionRole { return m.Role })
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), req)
+		if got := roles(dst.Messages); len(got) != 7 {
+			t.Errorf("consecutive messages should be kept apart by default, got roles %v", got)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) {
+			p.OpenRouter.MergeConsecutiveRoles = true
+		})
+		dst := convertToOpenRouter(t, ctx, req)
+		wantRoles := []openrouter.ChatCompletionRole{
+			openrouter.ChatCompletionMessageRoleUser,
+			openrouter.ChatCompletionMessageRoleAssistant,
+			openrouter.ChatCompletionMessageRoleTool,
+			openrouter.ChatCompletionMessageRoleTool,
+			openrouter.ChatCompletionMessageRoleUser,
+		}
+		if got := roles(dst.Messages); !reflect.DeepEqual(got, wantRoles) {
+			t.Fatalf("roles = %v, want %v", got, wantRoles)
+		}
+		first := dst.Messages[0].Content
+		if first == nil || !first.IsParts() || len(first.Parts) != 3 ||
+			first.Parts[0].Text != "First" || first.Parts[1].Text != "Second" ||
+			first.Parts[2].Type != openrouter.ChatCompletionMessageContentPartTypeImage {
+			t.Errorf("unexpected merged user content: %+v", first)
+		}
+		if dst.Messages[2].ToolCallID != "toolu_1" || dst.Messages[3].ToolCallID != "toolu_2" {
+			t.Errorf("tool messages should be kept apart: %+v", dst.Messages[2:4])
+		}
+		last := dst.Messages[4].Content
+		if last == nil || len(last.Parts) != 2 || last.Parts[0].Text != "Third" || last.Parts[1].Text != "Fourth" {
+			t.Errorf("unexpected merged user content: %+v", last)
+		}
+	})
+}
+
+func TestCanonicalOpenRouterMessages_InterleavedContentOrder(t *testing.T) {
+	t.Run("assistant text around tool_use", func(t *testing.T) {
+		req := &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 500,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "List /tmp"}}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeText, Text: "Let me look."},
+					{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "ls", Input: []byte(`{"path":"/tmp"}`)},
+					{Type: anthropic.MessageContentTypeText, Text: "Waiting for the result."},
+				}},
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "a.txt"}}},
+				}},
+			},
+		}
+		dst := convertToOpenRouter(t, testCtx(), req)
+		roles := lo.Map(dst.Messages, func(m *openrouter.ChatCompletionMessage, _ int) openrouter.ChatCompletionRole { return m.Role })
+		wantRoles := []openrouter.ChatCompletionRole{
+			openrouter.ChatCompletionMessageRoleUser,
+			openrouter.ChatCompletionMessageRoleAssistant,
+			openrouter.ChatCompletionMessageRoleTool,
+		}
+		if !reflect.DeepEqual(roles, wantRoles) {
+			t.Fatalf("roles = %v, want %v", roles, wantRoles)
+		}
+		// Chat completions keep text and tool calls apart, which OpenRouter rebuilds as the text
+		// blocks in order followed by the tool_use blocks, the order Anthropic expects before a
+		// tool_result turn.
+		assistant := dst.Messages[1]
+		if assistant.Content == nil || len(assistant.Content.Parts) != 2 ||
+			assistant.Content.Parts[0].Text != "Let me look." || assistant.Content.Parts[1].Text != "Waiting for the result." {
+			t.Errorf("unexpected assistant content: %+v", assistant.Content)
+		}
+		if len(assistant.ToolCalls) != 1 || assistant.ToolCalls[0].ID != "toolu_1" || assistant.ToolCalls[0].Index != 0 {
+			t.Errorf("unexpected tool calls: %+v", assistant.ToolCalls)
+		}
+		if dst.Messages[2].ToolCallID != "toolu_1" {
+			t.Errorf("tool message should answer toolu_1, got %q", dst.Messages[2].ToolCallID)
+		}
+	})
+
+	t.Run("user text after tool_result is kept", func(t *testing.T) {
+		req := &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 500,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Run both"}}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "a", Input: []byte(`{}`)},
+					{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_2", Name: "b", Input: []byte(`{}`)},
+				}},
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "1"}}},
+					{Type: anthropic.MessageContentTypeText, Text: "first note"},
+					{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_2", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "2"}}},
+					{Type: anthropic.MessageContentTypeText, Text: "second note"},
+				}},
+			},
+		}
+		dst := convertToOpenRouter(t, testCtx(), req)
+		var texts []string
+		for _, message := range dst.Messages[2:] {
+			if message.Role == openrouter.ChatCompletionMessageRoleUser {
+				for _, part := range message.Content.Parts {
+					texts = append(texts, part.Text)
+				}
+			}
+		}
+		if want := []string{"first note", "second note"}; !reflect.DeepEqual(texts, want) {
+			t.Errorf("user texts after tool results = %v, want %v", texts, want)
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ImageDetail(t *testing.T) {
+	image := func() *anthropic.MessageContent {
+		return &anthropic.MessageContent{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{
+			Type: anthropic.MessageContentSourceTypeBase64, MediaType: "image/png", Data: "AAAA",
+		}}
+	}
+	newRequest := func() *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-sonnet",
+			MaxTokens: 100,
+			System:    anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Describe images."}, image()},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeText, Text: "Take screenshots"},
+					image(),
+				}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "screenshot", Input: []byte(`{}`)},
+				}},
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{image(), image()}},
+				}},
+			},
+		}
+	}
+	imageParts := func(dst *openrouter.CreateChatCompletionRequest) (parts []*openrouter.ChatCompletionMessageContentPart) {
+		for _, message := range dst.Messages {
+			if message.Content == nil {
+				continue
+			}
+			for _, part := range message.Content.Parts {
+				if part.Type == openrouter.ChatCompletionMessageContentPartTypeImage {
+					parts = append(parts, part)
+				}
+			}
+		}
+		return parts
+	}
+
+	for _, detail := range []string{"", profile.ImageDetailLow, profile.ImageDetailHigh} {
+		t.Run("detail "+detail, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.ImageDetail = detail
+			})
+			parts := imageParts(convertToOpenRouter(t, ctx, newRequest()))
+			if len(parts) != 4 {
+				t.Fatalf("expected 4 image parts, got %d", len(parts))
+			}
+			for i, part := range parts {
+				if part.ImageUrl == nil || part.ImageUrl.Detail != detail {
+					t.Errorf("image part %d: detail = %q, want %q", i, part.ImageUrl.Detail, detail)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_LogitBias(t *testing.T) {
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.LogitBias = map[string]int{"50256": -100}
+	})
+	for _, tt := range []struct {
+		model string
+		want  bool
+	}{
+		{model: "openai/gpt-4o", want: true},
+		{model: "meta-llama/llama-3.3-70b-instruct", want: true},
+		{model: "anthropic/claude-sonnet-4", want: false},
+		{model: "google/gemini-2.5-pro", want: false},
+		{model: "openai/o3-mini", want: false},
+	} {
+		t.Run(tt.model, func(t *testing.T) {
+			dst := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:     tt.model,
+				MaxTokens: 100,
+				Messages:  []*anthropic.Message{},
+			})
+			data, err := json.Marshal(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(data), `"logit_bias":{"50256":-100}`); got != tt.want {
+				t.Errorf("request = %s, want logit_bias sent: %v", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_StopSequences(t *testing.T) {
+	tests := []struct {
+		name  string
+		stop  []string
+		limit int
+		want  openrouter.ChatCompletionStop
+	}{
+		{name: "unchanged", stop: []string{"</answer>", "STOP"}, want: openrouter.ChatCompletionStop{"</answer>", "STOP"}},
+		{name: "duplicates and empty", stop: []string{"STOP", "", "</answer>", "STOP"}, want: openrouter.ChatCompletionStop{"STOP", "</answer>"}},
+		{name: "only empty", stop: []string{""}, want: nil},
+		{name: "truncated after dedup", stop: []string{"a", "a", "b", "c", "d"}, limit: 2, want: openrouter.ChatCompletionStop{"a", "b"}},
+		{name: "within limit", stop: []string{"a", "b"}, limit: 4, want: openrouter.ChatCompletionStop{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.MaxStopSequences = tt.limit
+			})
+			got := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:         "claude-3-sonnet",
+				MaxTokens:     100,
+				StopSequences: tt.stop,
+				Messages:      []*anthropic.Message{},
+			})
+			if !reflect.DeepEqual(got.Stop, tt.want) {
+				t.Errorf("Stop = %q, want %q", got.Stop, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_MaxTools(t *testing.T) {
+	tools := make([]*anthropic.Tool, 6)
+	for i := range tools {
+		tools[i] = &anthropic.Tool{Name: fmt.Sprintf("tool_%d", i), InputSchema: []byte(`{"type":"object"}`)}
+	}
+	req := &anthropic.GenerateMessageRequest{
+		Model:      "claude-3-5-sonnet-20241022",
+		MaxTokens:  500,
+		Tools:      tools,
+		ToolChoice: &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeTool, Name: "tool_5"},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "go"}}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "tool_3", Input: []byte(`{}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1"},
+			}},
+		},
+	}
+	toolNames := func(dst *openrouter.CreateChatCompletionRequest) []string {
+		return lo.Map(dst.Tools, func(tool *openrouter.ChatCompletionTool, _ int) string { return tool.Function.Name })
+	}
+
+	t.Run("truncated keeping forced and recently used tools", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) { p.Options.MaxTools = 3 })
+		got := toolNames(convertToOpenRouter(t, ctx, req))
+		if want := []string{"tool_0", "tool_3", "tool_5"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("tools = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("within limit", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) { p.Options.MaxTools = 6 })
+		if got := toolNames(convertToOpenRouter(t, ctx, req)); len(got) != 6 {
+			t.Errorf("expected all 6 tools, got %v", got)
+		}
+	})
+
+	t.Run("no limit by default", func(t *testing.T) {
+		if got := toolNames(convertToOpenRouter(t, testCtx(), req)); len(got) != 6 {
+			t.Errorf("expected all 6 tools, got %v", got)
+		}
+	})
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ReasoningExclude(t *testing.T) {
+	req := &anthropic.GenerateMessageRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 2048,
+		Thinking:  &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 1024},
+		Messages:  []*anthropic.Message{{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}}},
+	}
+	dst := convertToOpenRouter(t, testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.Reasoning.Exclude = true
+	}), req)
+	if dst.Reasoning == nil || !dst.Reasoning.Enabled || !dst.Reasoning.Exclude {
+		t.Errorf("expected enabled and excluded reasoning, got %+v", dst.Reasoning)
+	}
+	if dst = convertToOpenRouter(t, testCtx(), req); dst.Reasoning.Exclude {
+		t.Error("reasoning should not be excluded by default")
+	}
+}
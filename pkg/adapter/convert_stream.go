@@ -3,11 +3,16 @@ package adapter
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/samber/lo"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/profile"
 )
@@ -15,7 +20,11 @@ import (
 type ConvertStreamOptions struct {
 	InputTokens                     int64
 	OpenRouterProvider              *string
+	ServiceTier                     *string
 	OpenRouterChatCompletionBuilder *openrouter.ChatCompletionBuilder
+	ToolNames                       *ToolNames
+	StopSequences                   []string
+	DisableParallelToolUse          bool
 }
 
 type ConvertStreamOption func(*ConvertStreamOptions)
@@ -32,12 +41,44 @@ func ExtractOpenRouterProvider(provider *string) ConvertStreamOption {
 	}
 }
 
+// ExtractServiceTier stores the service tier the upstream reports having served the request
+// with in tier; it is left unchanged when the upstream does not report one.
+func ExtractServiceTier(tier *string) ConvertStreamOption {
+	return func(o *ConvertStreamOptions) {
+		o.ServiceTier = tier
+	}
+}
+
 func ExtractOpenRouterChatCompletionBuilder(builder *openrouter.ChatCompletionBuilder) ConvertStreamOption {
 	return func(o *ConvertStreamOptions) {
 		o.OpenRouterChatCompletionBuilder = builder
 	}
 }
 
+// WithStopSequences passes the stop_sequences of the request, so that the stop_sequence of the
+// response can be reconstructed for upstreams that do not report which one matched.
+func WithStopSequences(stopSequences []string) ConvertStreamOption {
+	return func(o *ConvertStreamOptions) {
+		o.StopSequences = stopSequences
+	}
+}
+
+// WithDisableParallelToolUse reports whether the request disabled parallel tool use, so that
+// extra tool calls can be dropped when options.enforce_serial_tool_calls is set.
+func WithDisableParallelToolUse(disableParallelToolUse bool) ConvertStreamOption {
+	return func(o *ConvertStreamOptions) {
+		o.DisableParallelToolUse = disableParallelToolUse
+	}
+}
+
+// RestoreToolNames returns tool calls under the names the client sent, undoing the rewrites
+// recorded by RecordToolNames.
+func RestoreToolNames(names *ToolNames) ConvertStreamOption {
+	return func(o *ConvertStreamOptions) {
+		o.ToolNames = names
+	}
+}
+
 func ConvertOpenRouterStreamToAnthropicStream(
 	ctx context.Context,
 	stream openrouter.ChatCompletionStream,
@@ -48,20 +89,45 @@ func ConvertOpenRouterStreamToAnthropicStream(
 	for _, applyOption := range options {
 		applyOption(convertOptions)
 	}
+	// Providers that do not support reasoning.exclude may still send reasoning, which is dropped here.
+	excludeReasoning := prof.Options.GetReasoningExclude()
+	serialToolCalls := convertOptions.DisableParallelToolUse && prof.Options.GetEnforceSerialToolCalls()
+	interimUsageInterval := prof.Options.GetInterimUsageInterval()
+	webSearch := prof.OpenRouter.GetWebSearch()
+	maxStopSequenceLength := 0
+	for _, stopSequence := range convertOptions.StopSequences {
+		maxStopSequenceLength = max(maxStopSequenceLength, len(stopSequence))
+	}
 	return func(yield func(anthropic.Event, error) bool) {
 		var (
-			startOnce  sync.Once
-			blockIndex int
-			deltaType  anthropic.MessageContentDeltaType
-			toolCallID string
-			stopReason anthropic.StopReason
-			usage      *anthropic.Usage
+			startOnce     sync.Once
+			blockIndex    int
+			deltaType     anthropic.MessageContentDeltaType
+			toolCallID    string
+			toolCallIndex int
+			hasToolUse    bool
+			// droppingToolCall reports whether the fragments of the current tool call are dropped
+			// because it is not the first one of a serial response.
+			droppingToolCall bool
+			// thinkingSigned reports whether the open thinking block already got its signature.
+			thinkingSigned bool
+			stopReason     anthropic.StopReason
+			usage          *anthropic.Usage
+			// interimUsageAt is when usage was last reported before the end of the stream.
+			interimUsageAt = time.Now()
+			// textTail holds the end of the text generated so far, as long as the longest stop sequence.
+			textTail string
+			// webSearched reports whether the results of OpenRouter's web search were already reported.
+			webSearched bool
 		)
 		for chunk, err := range stream {
 			if err != nil {
 				yield(nil, err)
 				return
 			}
+			if convertOptions.ServiceTier != nil && chunk.ServiceTier != "" {
+				*convertOptions.ServiceTier = chunk.ServiceTier
+			}
 			startOnce.Do(func() {
 				if convertOptions.OpenRouterProvider != nil && chunk.Provider != "" {
 					*convertOptions.OpenRouterProvider = chunk.Provider
@@ -84,12 +150,17 @@ func ConvertOpenRouterStreamToAnthropicStream(
 				convertOptions.OpenRouterChatCompletionBuilder.Add(chunk)
 			}
 			if chunk.Usage != nil {
-				usage = &anthropic.Usage{
-					InputTokens:  chunk.Usage.PromptTokens,
-					OutputTokens: chunk.Usage.CompletionTokens,
-				}
-				if promptTokensDetails := chunk.Usage.PromptTokensDetails; promptTokensDetails != nil {
-					usage.CacheReadInputTokens = promptTokensDetails.CachedTokens
+				usage = accumulateOpenRouterUsage(usage, chunk.Usage)
+				if interimUsageInterval > 0 && stopReason == "" && time.Since(interimUsageAt) >= interimUsageInterval {
+					interimUsageAt = time.Now()
+					interimUsage := *usage
+					if !yield(&anthropic.EventMessageDelta{
+						Type:  anthropic.EventTypeMessageDelta,
+						Delta: &anthropic.Message{},
+						Usage: &interimUsage,
+					}, nil) {
+						return
+					}
 				}
 			}
 			if choices := chunk.Choices; len(choices) > 0 {
@@ -101,7 +172,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 					}
 				}
 				if delta := choice.Delta; delta != nil {
-					if reasoningDetails := delta.ReasoningDetails; reasoningDetailsContainsReasoningTypes(reasoningDetails,
+					if reasoningDetails := delta.ReasoningDetails; !excludeReasoning && reasoningDetailsContainsReasoningTypes(reasoningDetails,
 						openrouter.ChatCompletionMessageReasoningDetailTypeReasoningText,
 						openrouter.ChatCompletionMessageReasoningDetailTypeSummary,
 					) {
@@ -117,6 +188,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 								blockIndex++
 							}
 							deltaType = anthropic.MessageContentDeltaTypeThinkingDelta
+							thinkingSigned = false
 							blockStart := &anthropic.EventContentBlockStart{
 								Type:  anthropic.EventTypeContentBlockStart,
 								Index: blockIndex,
@@ -136,6 +208,29 @@ func ConvertOpenRouterStreamToAnthropicStream(
 								//
 								// And the bad news is that OpenRouter tends to output empty thinking deltas.
 								if reasoningDetailText := reasoningDetail.Text; reasoningDetailText != "" {
+									// A signature closes its thinking block, so reasoning that follows it (interleaved
+									// thinking between tool calls) goes to a new block instead of being coalesced.
+									if thinkingSigned {
+										blockStop := &anthropic.EventContentBlockStop{
+											Type:  anthropic.EventTypeContentBlockStop,
+											Index: blockIndex,
+										}
+										if !yield(blockStop, nil) {
+											return
+										}
+										blockIndex++
+										thinkingSigned = false
+										blockStart := &anthropic.EventContentBlockStart{
+											Type:  anthropic.EventTypeContentBlockStart,
+											Index: blockIndex,
+											ContentBlock: &anthropic.MessageContent{
+												Type: anthropic.MessageContentTypeThinking,
+											},
+										}
+										if !yield(blockStart, nil) {
+											return
+										}
+									}
 									blockDelta := &anthropic.EventContentBlockDelta{
 										Type:  anthropic.EventTypeContentBlockDelta,
 										Index: blockIndex,
@@ -160,6 +255,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 									if !yield(blockDelta, nil) {
 										return
 									}
+									thinkingSigned = true
 								}
 							case openrouter.ChatCompletionMessageReasoningDetailTypeSummary:
 								if reasoningDetailSummary := reasoningDetail.Summary; reasoningDetailSummary != "" {
@@ -213,10 +309,112 @@ func ConvertOpenRouterStreamToAnthropicStream(
 						if !yield(blockDelta, nil) {
 							return
 						}
+						if maxStopSequenceLength > 0 {
+							textTail += content
+							textTail = textTail[max(0, len(textTail)-maxStopSequenceLength):]
+						}
+					}
+					// Citations can only be attached to a text block; they usually arrive with the last
+					// content chunk, but a new text block is started if another block is open.
+					if citations := ConvertOpenRouterAnnotationsToAnthropicCitations(delta.Annotations); len(citations) > 0 {
+						// The web plugin searched before the model answered, but its results are only known from
+						// the citations, so the search is reported here, before the text block holding them.
+						if webSearch && !webSearched {
+							webSearched = true
+							if deltaType != "" {
+								blockStop := &anthropic.EventContentBlockStop{
+									Type:  anthropic.EventTypeContentBlockStop,
+									Index: blockIndex,
+								}
+								if !yield(blockStop, nil) {
+									return
+								}
+								blockIndex++
+								deltaType = ""
+							}
+							toolUseID := "srvtoolu_" + chunk.ID
+							for _, block := range []*anthropic.MessageContent{
+								{
+									Type: anthropic.MessageContentTypeServerToolUse,
+									ID:   toolUseID,
+									Name: "web_search",
+								},
+								{
+									Type:      anthropic.MessageContentTypeWebSearchToolResult,
+									ToolUseID: toolUseID,
+									Content:   ConvertOpenRouterAnnotationsToAnthropicWebSearchResults(delta.Annotations),
+								},
+							} {
+								blockStart := &anthropic.EventContentBlockStart{
+									Type:         anthropic.EventTypeContentBlockStart,
+									Index:        blockIndex,
+									ContentBlock: block,
+								}
+								blockStop := &anthropic.EventContentBlockStop{
+									Type:  anthropic.EventTypeContentBlockStop,
+									Index: blockIndex,
+								}
+								if !yield(blockStart, nil) || !yield(blockStop, nil) {
+									return
+								}
+								blockIndex++
+							}
+						}
+						if deltaType != anthropic.MessageContentDeltaTypeTextDelta {
+							if deltaType != "" {
+								blockStop := &anthropic.EventContentBlockStop{
+									Type:  anthropic.EventTypeContentBlockStop,
+									Index: blockIndex,
+								}
+								if !yield(blockStop, nil) {
+									return
+								}
+								blockIndex++
+							}
+							deltaType = anthropic.MessageContentDeltaTypeTextDelta
+							blockStart := &anthropic.EventContentBlockStart{
+								Type:  anthropic.EventTypeContentBlockStart,
+								Index: blockIndex,
+								ContentBlock: &anthropic.MessageContent{
+									Type: anthropic.MessageContentTypeText,
+								},
+							}
+							if !yield(blockStart, nil) {
+								return
+							}
+						}
+						for _, citation := range citations {
+							blockDelta := &anthropic.EventContentBlockDelta{
+								Type:  anthropic.EventTypeContentBlockDelta,
+								Index: blockIndex,
+								Delta: &anthropic.MessageContentDelta{
+									Type:     anthropic.MessageContentDeltaTypeCitationsDelta,
+									Citation: citation,
+								},
+							}
+							if !yield(blockDelta, nil) {
+								return
+							}
+						}
 					}
-					if toolCalls := delta.ToolCalls; len(toolCalls) > 0 {
-						if toolCall := toolCalls[0]; toolCall.Function != nil {
-							if deltaType != anthropic.MessageContentDeltaTypeInputJSONDelta || (toolCall.ID != "" && toolCall.ID != toolCallID) {
+					// Argument fragments of the same tool call are keyed by their index; the id is usually only
+					// present on the first fragment, so either a new index or a new id starts a new tool_use block.
+					for _, toolCall := range delta.ToolCalls {
+						if toolCall != nil && toolCall.Function != nil {
+							sameToolCall := toolCall.Index == toolCallIndex && (toolCall.ID == "" || toolCall.ID == toolCallID)
+							// Once the first tool call was emitted, every other one is dropped, fragments included.
+							if serialToolCalls && hasToolUse &&
+								(droppingToolCall || !sameToolCall || deltaType != anthropic.MessageContentDeltaTypeInputJSONDelta) {
+								if !droppingToolCall || !sameToolCall {
+									slog.Warn(fmt.Sprintf("parallel tool use is disabled, dropping tool call %q (id %q)",
+										toolCall.Function.Name, toolCall.ID))
+								}
+								droppingToolCall = true
+								toolCallID = toolCall.ID
+								toolCallIndex = toolCall.Index
+								continue
+							}
+							if deltaType != anthropic.MessageContentDeltaTypeInputJSONDelta || !sameToolCall {
 								if deltaType != "" {
 									blockStop := &anthropic.EventContentBlockStop{
 										Type:  anthropic.EventTypeContentBlockStop,
@@ -229,13 +427,15 @@ func ConvertOpenRouterStreamToAnthropicStream(
 								}
 								deltaType = anthropic.MessageContentDeltaTypeInputJSONDelta
 								toolCallID = toolCall.ID
+								toolCallIndex = toolCall.Index
+								hasToolUse = true
 								blockStart := &anthropic.EventContentBlockStart{
 									Type:  anthropic.EventTypeContentBlockStart,
 									Index: blockIndex,
 									ContentBlock: &anthropic.MessageContent{
 										Type:  anthropic.MessageContentTypeToolUse,
 										ID:    toolCall.ID,
-										Name:  toolCall.Function.Name,
+										Name:  convertOptions.ToolNames.Original(toolCall.Function.Name),
 										Input: json.RawMessage("{}"),
 									},
 								}
@@ -262,7 +462,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 							}
 						}
 					}
-					if reasoningDetails := delta.ReasoningDetails; reasoningDetailsContainsReasoningTypes(reasoningDetails,
+					if reasoningDetails := delta.ReasoningDetails; !excludeReasoning && reasoningDetailsContainsReasoningTypes(reasoningDetails,
 						openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted,
 					) {
 						if deltaType != anthropic.MessageContentDeltaTypeThinkingDelta {
@@ -277,6 +477,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 								blockIndex++
 							}
 							deltaType = anthropic.MessageContentDeltaTypeThinkingDelta
+							thinkingSigned = false
 							blockStart := &anthropic.EventContentBlockStart{
 								Type:  anthropic.EventTypeContentBlockStart,
 								Index: blockIndex,
@@ -292,12 +493,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 							switch reasoningDetail.Type {
 							case openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted:
 								if reasoningDetailData := reasoningDetail.Data; reasoningDetailData != "" {
-									var signature string
-									if reasoningDetailID := reasoningDetail.ID; reasoningDetailID != "" {
-										signature = reasoningDetailID + prof.Options.GetReasoningDelimiter() + reasoningDetailData
-									} else {
-										signature = reasoningDetailData
-									}
+									signature := encryptedReasoningSignature(prof.Options.GetReasoningDelimiter(), reasoningDetail.ID, reasoningDetailData)
 									blockDelta := &anthropic.EventContentBlockDelta{
 										Type:  anthropic.EventTypeContentBlockDelta,
 										Index: blockIndex,
@@ -309,6 +505,7 @@ func ConvertOpenRouterStreamToAnthropicStream(
 									if !yield(blockDelta, nil) {
 										return
 									}
+									thinkingSigned = true
 								}
 							}
 						}
@@ -325,11 +522,28 @@ func ConvertOpenRouterStreamToAnthropicStream(
 				return
 			}
 		}
+		// Some providers report "stop" even when the turn ends with tool calls; Claude Code only
+		// runs the tools when stop_reason is tool_use.
+		if stopReason == anthropic.StopReasonEndTurn && hasToolUse {
+			stopReason = anthropic.StopReasonToolUse
+		}
 		delta := &anthropic.Message{}
+		// Upstreams report a matched stop sequence as a plain "stop"; when the output ends with one of
+		// the requested stop sequences, the response is reported the way Anthropic does.
+		if (stopReason == anthropic.StopReasonEndTurn || stopReason == anthropic.StopReasonStopSequence) &&
+			deltaType == anthropic.MessageContentDeltaTypeTextDelta {
+			if stopSequence, ok := matchStopSequence(textTail, convertOptions.StopSequences); ok {
+				stopReason = anthropic.StopReasonStopSequence
+				delta.StopSequence = lo.ToPtr(stopSequence)
+			}
+		}
 		if stopReason != "" {
 			delta.StopReason = lo.ToPtr(stopReason)
 		}
 		if usage != nil {
+			if webSearched {
+				usage.ServerToolUse = &anthropic.ServerToolUseUsage{WebSearchRequests: 1}
+			}
 			delta.Usage = usage
 		}
 		messageDelta := &anthropic.EventMessageDelta{
@@ -344,13 +558,47 @@ func ConvertOpenRouterStreamToAnthropicStream(
 	}
 }
 
+// accumulateOpenRouterUsage merges the usage reported by a chunk into usage. Upstreams report
+// cumulative counts, but some split them across chunks, e.g. prompt tokens first and completion
+// tokens at the end, so a count a chunk leaves at zero keeps its previous value.
+func accumulateOpenRouterUsage(usage *anthropic.Usage, chunkUsage *openrouter.ChatCompletionUsage) *anthropic.Usage {
+	if usage == nil {
+		usage = &anthropic.Usage{}
+	}
+	if chunkUsage.PromptTokens > 0 {
+		usage.InputTokens = chunkUsage.PromptTokens
+	}
+	if chunkUsage.CompletionTokens > 0 {
+		usage.OutputTokens = chunkUsage.CompletionTokens
+	}
+	if promptTokensDetails := chunkUsage.PromptTokensDetails; promptTokensDetails != nil && promptTokensDetails.CachedTokens > 0 {
+		usage.CacheReadInputTokens = promptTokensDetails.CachedTokens
+	}
+	return usage
+}
+
+// matchStopSequence returns the longest of stopSequences that text ends with.
+func matchStopSequence(text string, stopSequences []string) (matched string, ok bool) {
+	for _, stopSequence := range stopSequences {
+		if stopSequence != "" && len(stopSequence) > len(matched) && strings.HasSuffix(text, stopSequence) {
+			matched, ok = stopSequence, true
+		}
+	}
+	return matched, ok
+}
+
 func ConvertOpenRouterFinishReasonToAnthropicStopReason(
 	finishReason openrouter.ChatCompletionFinishReason,
 	nativeFinishReason string,
 ) (stopReason anthropic.StopReason) {
 	switch finishReason {
 	case openrouter.ChatCompletionFinishReasonStop:
-		stopReason = anthropic.StopReasonEndTurn
+		// OpenRouter normalizes Anthropic's stop_sequence to "stop", but keeps it as the native reason.
+		if anthropic.StopReason(nativeFinishReason) == anthropic.StopReasonStopSequence {
+			stopReason = anthropic.StopReasonStopSequence
+		} else {
+			stopReason = anthropic.StopReasonEndTurn
+		}
 	case openrouter.ChatCompletionFinishReasonLength:
 		stopReason = anthropic.StopReasonMaxTokens
 	case openrouter.ChatCompletionFinishReasonContentFilter:
@@ -358,15 +606,70 @@ func ConvertOpenRouterFinishReasonToAnthropicStopReason(
 	case openrouter.ChatCompletionFinishReasonToolCalls:
 		stopReason = anthropic.StopReasonToolUse
 	default:
-		if nativeFinishReason != "" {
-			stopReason = anthropic.StopReason(nativeFinishReason)
-		} else {
-			stopReason = anthropic.StopReasonPauseTurn
-		}
+		// Passing unknown values through (e.g. "error" or a provider's native reason) confuses the client,
+		// so the turn is reported as finished normally.
+		slog.Debug(fmt.Sprintf("unknown finish_reason %q (native_finish_reason %q), using %q",
+			finishReason, nativeFinishReason, anthropic.StopReasonEndTurn))
+		stopReason = anthropic.StopReasonEndTurn
 	}
 	return stopReason
 }
 
+// ConvertOpenRouterAnnotationsToAnthropicCitations converts URL citation annotations into
+// web_search_result_location citations; other annotation types are ignored.
+func ConvertOpenRouterAnnotationsToAnthropicCitations(annotations []*openrouter.ChatCompletionAnnotation) []*anthropic.Citation {
+	var citations []*anthropic.Citation
+	for _, annotation := range annotations {
+		if annotation == nil || annotation.Type != openrouter.ChatCompletionAnnotationTypeURLCitation || annotation.URLCitation == nil {
+			continue
+		}
+		citations = append(citations, &anthropic.Citation{
+			Type:      anthropic.CitationTypeWebSearchResultLocation,
+			URL:       annotation.URLCitation.URL,
+			Title:     annotation.URLCitation.Title,
+			CitedText: annotation.URLCitation.Content,
+		})
+	}
+	return citations
+}
+
+// ConvertOpenRouterAnnotationsToAnthropicWebSearchResults converts the URL citation annotations of
+// a response into the web_search_result blocks of a web_search_tool_result, one per URL, in the
+// order they are first cited.
+func ConvertOpenRouterAnnotationsToAnthropicWebSearchResults(annotations []*openrouter.ChatCompletionAnnotation) anthropic.MessageContents {
+	var (
+		results anthropic.MessageContents
+		seen    = map[string]struct{}{}
+	)
+	for _, annotation := range annotations {
+		if annotation == nil || annotation.Type != openrouter.ChatCompletionAnnotationTypeURLCitation || annotation.URLCitation == nil {
+			continue
+		}
+		if _, ok := seen[annotation.URLCitation.URL]; ok {
+			continue
+		}
+		seen[annotation.URLCitation.URL] = struct{}{}
+		results = append(results, &anthropic.MessageContent{
+			Type:  anthropic.MessageContentTypeWebSearchResult,
+			Url:   annotation.URLCitation.URL,
+			Title: annotation.URLCitation.Title,
+		})
+	}
+	return results
+}
+
+// encryptedReasoningSignature packs the ID and data of an encrypted reasoning item into a thinking
+// signature, which canonicalOpenRouterMessages and ConvertAnthropicRequestToOpenAIRequest split at
+// the first delimiter when the conversation is sent back. Data without an ID is prefixed with the
+// delimiter when it contains the delimiter itself, as base64 does for "/", so that no part of it is
+// mistaken for an ID.
+func encryptedReasoningSignature(delimiter string, id string, data string) string {
+	if id != "" || strings.Contains(data, delimiter) {
+		return id + delimiter + data
+	}
+	return data
+}
+
 func reasoningDetailsContainsReasoningTypes(
 	details []*openrouter.ChatCompletionMessageReasoningDetail,
 	reasoningTypes ...openrouter.ChatCompletionMessageReasoningDetailType,
@@ -378,3 +681,352 @@ func reasoningDetailsContainsReasoningTypes(
 	}
 	return false
 }
+
+// ConvertOpenAIStreamToAnthropicStream converts the event stream of an OpenAI Responses API request
+// made by ConvertAnthropicRequestToOpenAIRequest into an Anthropic message stream. Output text and
+// refusals become text blocks, function calls become tool_use blocks, and reasoning text, or its
+// summary when options.reasoning.prefer_summary is set, becomes thinking blocks. The encrypted
+// content of a reasoning item is packed into the signature of its thinking block with
+// encryptedReasoningSignature, so that it is sent back as a reasoning item with the next turn.
+// The stop reason and usage are taken from the final response; a failed response or an error
+// event ends the stream with an error.
+func ConvertOpenAIStreamToAnthropicStream(
+	ctx context.Context,
+	stream openai.ResponseStream,
+	options ...ConvertStreamOption,
+) anthropic.MessageStream {
+	convertOptions := &ConvertStreamOptions{}
+	for _, applyOption := range options {
+		applyOption(convertOptions)
+	}
+	var profileOptions *profile.OptionsConfig
+	if prof, ok := profile.FromContext(ctx); ok {
+		profileOptions = prof.Options
+	}
+	preferSummary := profileOptions.GetReasoningPreferSummary()
+	return func(yield func(anthropic.Event, error) bool) {
+		var (
+			started      bool
+			blockIndex   int
+			deltaType    anthropic.MessageContentDeltaType
+			blockItemID  string
+			summaryIndex = -1
+			hasToolUse   bool
+			stopReason   anthropic.StopReason
+			usage        *anthropic.Usage
+			// function_call items are announced by response.output_item.added, while their
+			// arguments are streamed by item_id afterward.
+			functionCalls = make(map[string]*openai.ResponseOutputItem)
+			// reasoningTextItems are the reasoning items whose full text is streamed, which then
+			// replaces their summary unless summaries are preferred.
+			reasoningTextItems = make(map[string]bool)
+			// refusedItems are the message items whose refusal was streamed by deltas, so that
+			// response.refusal.done only writes the refusals that were not.
+			refusedItems = make(map[string]bool)
+			refused      bool
+		)
+		messageStart := func(response *openai.Response) bool {
+			if started {
+				return true
+			}
+			started = true
+			message := &anthropic.Message{
+				Type: anthropic.MessageTypeMessage,
+				Role: anthropic.MessageRoleAssistant,
+				Usage: &anthropic.Usage{
+					InputTokens:  convertOptions.InputTokens,
+					OutputTokens: 1,
+				},
+			}
+			if response != nil {
+				message.ID = response.ID
+				message.Model = response.Model
+			}
+			return yield(&anthropic.EventMessageStart{
+				Type:    anthropic.EventTypeMessageStart,
+				Message: message,
+			}, nil)
+		}
+		// switchBlock closes the current content block and starts a new one unless the
+		// delta belongs to the block that is already open.
+		switchBlock := func(newDeltaType anthropic.MessageContentDeltaType, itemID string, contentBlock *anthropic.MessageContent) bool {
+			if deltaType == newDeltaType && blockItemID == itemID {
+				return true
+			}
+			if deltaType != "" {
+				blockStop := &anthropic.EventContentBlockStop{
+					Type:  anthropic.EventTypeContentBlockStop,
+					Index: blockIndex,
+				}
+				if !yield(blockStop, nil) {
+					return false
+				}
+				blockIndex++
+			}
+			deltaType = newDeltaType
+			blockItemID = itemID
+			summaryIndex = -1
+			blockStart := &anthropic.EventContentBlockStart{
+				Type:         anthropic.EventTypeContentBlockStart,
+				Index:        blockIndex,
+				ContentBlock: contentBlock,
+			}
+			return yield(blockStart, nil)
+		}
+		blockDelta := func(delta *anthropic.MessageContentDelta) bool {
+			return yield(&anthropic.EventContentBlockDelta{
+				Type:  anthropic.EventTypeContentBlockDelta,
+				Index: blockIndex,
+				Delta: delta,
+			}, nil)
+		}
+		setServiceTier := func(response *openai.Response) {
+			if convertOptions.ServiceTier != nil && response != nil && response.ServiceTier != "" {
+				*convertOptions.ServiceTier = response.ServiceTier
+			}
+		}
+		startFunctionCall := func(itemID string) bool {
+			hasToolUse = true
+			item, ok := functionCalls[itemID]
+			if !ok {
+				item = &openai.ResponseOutputItem{ID: itemID, CallID: itemID}
+			}
+			return switchBlock(anthropic.MessageContentDeltaTypeInputJSONDelta, itemID, &anthropic.MessageContent{
+				Type:  anthropic.MessageContentTypeToolUse,
+				ID:    item.CallID,
+				Name:  convertOptions.ToolNames.Original(item.Name),
+				Input: json.RawMessage("{}"),
+			})
+		}
+		for event, err := range stream {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			var response *openai.Response
+			switch event := event.(type) {
+			case *openai.ResponseCreatedEvent:
+				response = event.Response
+			case *openai.ResponseCompletedEvent:
+				setServiceTier(event.Response)
+			case *openai.ResponseIncompleteEvent:
+				setServiceTier(event.Response)
+			}
+			setServiceTier(response)
+			if !messageStart(response) {
+				return
+			}
+			switch event := event.(type) {
+			case *openai.ErrorEvent:
+				yield(nil, event)
+				return
+			case *openai.ResponseFailedEvent:
+				if event.Response != nil && event.Response.Error != nil {
+					yield(nil, event.Response.Error)
+				} else {
+					yield(nil, &openai.ResponseError{Message: "response failed"})
+				}
+				return
+			case *openai.ResponseOutputItemAddedEvent:
+				if item := event.Item; item != nil && item.Type == openai.ResponseOutputItemTypeFunctionCall {
+					if item.CallID == "" {
+						item.CallID = item.ID
+					}
+					functionCalls[item.ID] = item
+					if !startFunctionCall(item.ID) {
+						return
+					}
+				}
+			case *openai.ResponseOutputItemDoneEvent:
+				// The encrypted content of a reasoning item, returned when the request includes
+				// reasoning.encrypted_content, becomes the signature of its thinking block so that
+				// it is sent back unchanged with the next turn.
+				if item := event.Item; item != nil && item.Type == openai.ResponseOutputItemTypeReasoning && item.EncryptedContent != "" {
+					if !switchBlock(anthropic.MessageContentDeltaTypeThinkingDelta, item.ID, &anthropic.MessageContent{
+						Type: anthropic.MessageContentTypeThinking,
+					}) {
+						return
+					}
+					if !blockDelta(&anthropic.MessageContentDelta{
+						Type:      anthropic.MessageContentDeltaTypeSignatureDelta,
+						Signature: encryptedReasoningSignature(profileOptions.GetReasoningDelimiter(), item.ID, item.EncryptedContent),
+					}) {
+						return
+					}
+				}
+			case *openai.ResponseOutputTextDeltaEvent:
+				if event.Delta == "" {
+					continue
+				}
+				if !switchBlock(anthropic.MessageContentDeltaTypeTextDelta, event.ItemID, &anthropic.MessageContent{
+					Type: anthropic.MessageContentTypeText,
+				}) {
+					return
+				}
+				if !blockDelta(&anthropic.MessageContentDelta{
+					Type: anthropic.MessageContentDeltaTypeTextDelta,
+					Text: event.Delta,
+				}) {
+					return
+				}
+			case *openai.ResponseRefusalDeltaEvent, *openai.ResponseRefusalDoneEvent:
+				// A refusal replaces the output text of a message item; it becomes a text block, and
+				// the response stops with the refusal stop reason.
+				var itemID, text string
+				switch event := event.(type) {
+				case *openai.ResponseRefusalDeltaEvent:
+					itemID, text = event.ItemID, event.Delta
+					refusedItems[itemID] = true
+				case *openai.ResponseRefusalDoneEvent:
+					if refusedItems[event.ItemID] {
+						continue
+					}
+					itemID, text = event.ItemID, event.Refusal
+				}
+				refused = true
+				if text == "" {
+					continue
+				}
+				if !switchBlock(anthropic.MessageContentDeltaTypeTextDelta, itemID, &anthropic.MessageContent{
+					Type: anthropic.MessageContentTypeText,
+				}) {
+					return
+				}
+				if !blockDelta(&anthropic.MessageContentDelta{
+					Type: anthropic.MessageContentDeltaTypeTextDelta,
+					Text: text,
+				}) {
+					return
+				}
+			case *openai.ResponseReasoningTextDeltaEvent:
+				// Claude Code will crash when it encounters an empty thinking field.
+				if event.Delta == "" || preferSummary {
+					continue
+				}
+				reasoningTextItems[event.ItemID] = true
+				if !switchBlock(anthropic.MessageContentDeltaTypeThinkingDelta, event.ItemID, &anthropic.MessageContent{
+					Type: anthropic.MessageContentTypeThinking,
+				}) {
+					return
+				}
+				if !blockDelta(&anthropic.MessageContentDelta{
+					Type:     anthropic.MessageContentDeltaTypeThinkingDelta,
+					Thinking: event.Delta,
+				}) {
+					return
+				}
+			case *openai.ResponseReasoningSummaryTextDeltaEvent:
+				if event.Delta == "" || reasoningTextItems[event.ItemID] {
+					continue
+				}
+				if !switchBlock(anthropic.MessageContentDeltaTypeThinkingDelta, event.ItemID, &anthropic.MessageContent{
+					Type: anthropic.MessageContentTypeThinking,
+				}) {
+					return
+				}
+				thinking := event.Delta
+				// A reasoning item may carry several summary parts; keep them in one thinking
+				// block, separated by a blank line.
+				if summaryIndex >= 0 && event.SummaryIndex != summaryIndex {
+					thinking = "\n\n" + thinking
+				}
+				summaryIndex = event.SummaryIndex
+				if !blockDelta(&anthropic.MessageContentDelta{
+					Type:     anthropic.MessageContentDeltaTypeThinkingDelta,
+					Thinking: thinking,
+				}) {
+					return
+				}
+			case *openai.ResponseFunctionCallArgumentsDeltaEvent:
+				if !startFunctionCall(event.ItemID) {
+					return
+				}
+				// Claude Code will stop outputting when it encounters an empty partial_json field.
+				if event.Delta == "" {
+					continue
+				}
+				if !blockDelta(&anthropic.MessageContentDelta{
+					Type:        anthropic.MessageContentDeltaTypeInputJSONDelta,
+					PartialJSON: event.Delta,
+				}) {
+					return
+				}
+			case *openai.ResponseCompletedEvent:
+				stopReason, usage = convertOpenAIResponseStopReasonAndUsage(event.Response, hasToolUse)
+			case *openai.ResponseIncompleteEvent:
+				stopReason, usage = convertOpenAIResponseStopReasonAndUsage(event.Response, hasToolUse)
+			}
+		}
+		if !messageStart(nil) {
+			return
+		}
+		if deltaType != "" {
+			blockEnd := &anthropic.EventContentBlockStop{
+				Type:  anthropic.EventTypeContentBlockStop,
+				Index: blockIndex,
+			}
+			if !yield(blockEnd, nil) {
+				return
+			}
+		}
+		if refused && (stopReason == "" || stopReason == anthropic.StopReasonEndTurn) {
+			stopReason = anthropic.StopReasonRefusal
+		}
+		delta := &anthropic.Message{}
+		if stopReason != "" {
+			delta.StopReason = lo.ToPtr(stopReason)
+		}
+		if usage != nil {
+			delta.Usage = usage
+		}
+		messageDelta := &anthropic.EventMessageDelta{
+			Type:  anthropic.EventTypeMessageDelta,
+			Delta: delta,
+			Usage: usage,
+		}
+		if !yield(messageDelta, nil) {
+			return
+		}
+		yield(&anthropic.EventMessageStop{Type: anthropic.EventTypeMessageStop}, nil)
+	}
+}
+
+func convertOpenAIResponseStopReasonAndUsage(
+	response *openai.Response,
+	hasToolUse bool,
+) (stopReason anthropic.StopReason, usage *anthropic.Usage) {
+	if response == nil {
+		return anthropic.StopReasonEndTurn, nil
+	}
+	switch response.Status {
+	case openai.ResponseStatusIncomplete:
+		var reason openai.ResponseIncompleteReason
+		if response.IncompleteDetails != nil {
+			reason = response.IncompleteDetails.Reason
+		}
+		switch reason {
+		case openai.ResponseIncompleteReasonMaxOutputTokens:
+			stopReason = anthropic.StopReasonMaxTokens
+		case openai.ResponseIncompleteReasonContentFilter:
+			stopReason = anthropic.StopReasonRefusal
+		default:
+			stopReason = anthropic.StopReasonPauseTurn
+		}
+	default:
+		if hasToolUse {
+			stopReason = anthropic.StopReasonToolUse
+		} else {
+			stopReason = anthropic.StopReasonEndTurn
+		}
+	}
+	if responseUsage := response.Usage; responseUsage != nil {
+		usage = &anthropic.Usage{
+			InputTokens:  responseUsage.InputTokens,
+			OutputTokens: responseUsage.OutputTokens,
+		}
+		if inputTokensDetails := responseUsage.InputTokensDetails; inputTokensDetails != nil {
+			usage.CacheReadInputTokens = inputTokensDetails.CachedTokens
+		}
+	}
+	return stopReason, usage
+}
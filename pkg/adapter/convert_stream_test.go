@@ -2,10 +2,15 @@ package adapter
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/profile"
 )
@@ -440,9 +445,11 @@ func TestConvertOpenRouterStreamToAnthropicStream_ContentTypeTransitions(t *test
 
 func TestConvertOpenRouterStreamToAnthropicStream_FinishReasons(t *testing.T) {
 	tests := []struct {
-		name         string
-		finishReason openrouter.ChatCompletionFinishReason
-		expected     anthropic.StopReason
+		name               string
+		finishReason       openrouter.ChatCompletionFinishReason
+		nativeFinishReason string
+		toolCall           bool
+		expected           anthropic.StopReason
 	}{
 		{
 			name:         "stop reason",
@@ -465,23 +472,54 @@ func TestConvertOpenRouterStreamToAnthropicStream_FinishReasons(t *testing.T) {
 			expected:     anthropic.StopReasonToolUse,
 		},
 		{
-			name:         "unknown reason",
-			finishReason: openrouter.ChatCompletionFinishReason("unknown"),
-			expected:     anthropic.StopReasonPauseTurn,
+			name:               "stop with native stop_sequence",
+			finishReason:       openrouter.ChatCompletionFinishReasonStop,
+			nativeFinishReason: "stop_sequence",
+			expected:           anthropic.StopReasonStopSequence,
+		},
+		{
+			name:         "stop after tool calls",
+			finishReason: openrouter.ChatCompletionFinishReasonStop,
+			toolCall:     true,
+			expected:     anthropic.StopReasonToolUse,
+		},
+		{
+			name:         "length after tool calls",
+			finishReason: openrouter.ChatCompletionFinishReasonLength,
+			toolCall:     true,
+			expected:     anthropic.StopReasonMaxTokens,
+		},
+		{
+			name:         "error reason",
+			finishReason: openrouter.ChatCompletionFinishReason("error"),
+			expected:     anthropic.StopReasonEndTurn,
+		},
+		{
+			name:               "unknown reason",
+			finishReason:       openrouter.ChatCompletionFinishReason("unknown"),
+			nativeFinishReason: "unknown",
+			expected:           anthropic.StopReasonEndTurn,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			choice := &openrouter.ChatCompletionChunkChoice{
+				FinishReason:       tt.finishReason,
+				NativeFinishReason: tt.nativeFinishReason,
+			}
+			if tt.toolCall {
+				choice.Delta = &openrouter.ChatCompletionChunkChoiceDelta{
+					ToolCalls: []*openrouter.ChatCompletionToolCall{
+						{ID: "call_1", Function: &openrouter.ChatCompletionMessageToolCallFunction{Name: "Read", Arguments: "{}"}},
+					},
+				}
+			}
 			chunks := []*openrouter.ChatCompletionChunk{
 				{
-					ID:    "chatcmpl-123",
-					Model: "claude-3-5-sonnet-20241022",
-					Choices: []*openrouter.ChatCompletionChunkChoice{
-						{
-							FinishReason: tt.finishReason,
-						},
-					},
+					ID:      "chatcmpl-123",
+					Model:   "claude-3-5-sonnet-20241022",
+					Choices: []*openrouter.ChatCompletionChunkChoice{choice},
 				},
 			}
 
@@ -595,6 +633,87 @@ func TestConvertOpenRouterStreamToAnthropicStream_Usage(t *testing.T) {
 	}
 }
 
+func TestConvertOpenRouterStreamToAnthropicStream_UsageAcrossChunks(t *testing.T) {
+	chunks := []*openrouter.ChatCompletionChunk{
+		{
+			ID:      "chatcmpl-123",
+			Model:   "claude-3-5-sonnet-20241022",
+			Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{Content: "Hel"}}},
+			Usage: &openrouter.ChatCompletionUsage{
+				PromptTokens:        40,
+				PromptTokensDetails: &openrouter.ChatCompletionPromptTokensDetails{CachedTokens: 30},
+			},
+		},
+		{
+			ID:      "chatcmpl-123",
+			Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{Content: "lo"}}},
+			Usage:   &openrouter.ChatCompletionUsage{CompletionTokens: 2},
+		},
+		{
+			ID:      "chatcmpl-123",
+			Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: openrouter.ChatCompletionFinishReasonStop}},
+		},
+		{
+			ID:    "chatcmpl-123",
+			Usage: &openrouter.ChatCompletionUsage{CompletionTokens: 3},
+		},
+	}
+	messageDeltas := func(t *testing.T, ctx context.Context) []*anthropic.EventMessageDelta {
+		t.Helper()
+		events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(ctx, createMockStream(chunks, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var deltas []*anthropic.EventMessageDelta
+		for _, event := range events {
+			if delta, ok := event.(*anthropic.EventMessageDelta); ok {
+				deltas = append(deltas, delta)
+			}
+		}
+		return deltas
+	}
+	wantFinal := anthropic.Usage{InputTokens: 40, OutputTokens: 3, CacheReadInputTokens: 30}
+
+	t.Run("accumulated", func(t *testing.T) {
+		deltas := messageDeltas(t, streamTestCtx())
+		if len(deltas) != 1 {
+			t.Fatalf("got %d message_delta events, want 1", len(deltas))
+		}
+		if usage := deltas[0].Usage; usage == nil || *usage != wantFinal {
+			t.Errorf("final usage = %+v, want %+v", usage, wantFinal)
+		}
+	})
+
+	t.Run("interim", func(t *testing.T) {
+		ctx := streamTestCtx()
+		prof, _ := profile.FromContext(ctx)
+		prof.Options.InterimUsageInterval = time.Nanosecond
+		deltas := messageDeltas(t, ctx)
+		// One interim delta per usage chunk before the finish reason, then the final one.
+		if len(deltas) != 3 {
+			t.Fatalf("got %d message_delta events, want 3", len(deltas))
+		}
+		for i, want := range []anthropic.Usage{
+			{InputTokens: 40, CacheReadInputTokens: 30},
+			{InputTokens: 40, OutputTokens: 2, CacheReadInputTokens: 30},
+		} {
+			if deltas[i].Delta.StopReason != nil {
+				t.Errorf("interim delta %d has stop_reason %q", i, *deltas[i].Delta.StopReason)
+			}
+			if usage := deltas[i].Usage; usage == nil || *usage != want {
+				t.Errorf("interim usage %d = %+v, want %+v", i, usage, want)
+			}
+		}
+		final := deltas[2]
+		if final.Delta.StopReason == nil || *final.Delta.StopReason != anthropic.StopReasonEndTurn {
+			t.Errorf("final delta has no end_turn stop_reason: %+v", final.Delta)
+		}
+		if usage := final.Usage; usage == nil || *usage != wantFinal {
+			t.Errorf("final usage = %+v, want %+v", usage, wantFinal)
+		}
+	})
+}
+
 func TestConvertOpenRouterStreamToAnthropicStream_ErrorHandling(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -786,6 +905,288 @@ func TestConvertOpenRouterStreamToAnthropicStream_CrossTypeBlockStop(t *testing.
 	}
 }
 
+func TestConvertOpenRouterStreamToAnthropicStream_ToolCallArgumentFragments(t *testing.T) {
+	toolCallChunk := func(index int, id, name, arguments string) *openrouter.ChatCompletionChunk {
+		return &openrouter.ChatCompletionChunk{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			ToolCalls: []*openrouter.ChatCompletionToolCall{{Index: index, ID: id, Function: &openrouter.ChatCompletionMessageToolCallFunction{Name: name, Arguments: arguments}}},
+		}}}}
+	}
+	chunks := []*openrouter.ChatCompletionChunk{
+		toolCallChunk(0, "tool_1", "read_file", `{"path":`),
+		toolCallChunk(0, "", "", `"/tmp/a.txt",`),
+		toolCallChunk(0, "", "", `"limit":10}`),
+		toolCallChunk(1, "tool_2", "list_dir", `{"path":"/tmp"}`),
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: openrouter.ChatCompletionFinishReasonToolCalls}}},
+	}
+	events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(streamTestCtx(), createMockStream(chunks, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var (
+		starts      []*anthropic.EventContentBlockStart
+		stops       []int
+		partialJSON = map[int]string{}
+		fragments   = map[int]int{}
+	)
+	for _, event := range events {
+		switch e := event.(type) {
+		case *anthropic.EventContentBlockStart:
+			starts = append(starts, e)
+		case *anthropic.EventContentBlockDelta:
+			if e.Delta.Type != anthropic.MessageContentDeltaTypeInputJSONDelta {
+				t.Fatalf("unexpected delta type: %s", e.Delta.Type)
+			}
+			partialJSON[e.Index] += e.Delta.PartialJSON
+			fragments[e.Index]++
+		case *anthropic.EventContentBlockStop:
+			stops = append(stops, e.Index)
+		}
+	}
+	if len(starts) != 2 {
+		t.Fatalf("expected 2 tool_use blocks, got %d", len(starts))
+	}
+	if starts[0].ContentBlock.ID != "tool_1" || starts[0].ContentBlock.Name != "read_file" || starts[0].Index != 0 {
+		t.Errorf("unexpected first block start: %+v", starts[0].ContentBlock)
+	}
+	if starts[1].ContentBlock.ID != "tool_2" || starts[1].ContentBlock.Name != "list_dir" || starts[1].Index != 1 {
+		t.Errorf("unexpected second block start: %+v", starts[1].ContentBlock)
+	}
+	if fragments[0] != 3 {
+		t.Errorf("expected 3 input_json_delta events for the first tool call, got %d", fragments[0])
+	}
+	if want := `{"path":"/tmp/a.txt","limit":10}`; partialJSON[0] != want {
+		t.Errorf("reconstructed JSON = %s, want %s", partialJSON[0], want)
+	}
+	var input map[string]any
+	if err = json.Unmarshal([]byte(partialJSON[0]), &input); err != nil {
+		t.Errorf("reconstructed JSON is invalid: %v", err)
+	}
+	if want := `{"path":"/tmp"}`; partialJSON[1] != want {
+		t.Errorf("second tool call JSON = %s, want %s", partialJSON[1], want)
+	}
+	if !reflect.DeepEqual(stops, []int{0, 1}) {
+		t.Errorf("content_block_stop indices = %v, want [0 1]", stops)
+	}
+}
+
+func TestConvertOpenRouterStreamToAnthropicStream_InterleavedThinking(t *testing.T) {
+	reasoningChunk := func(text, signature string) *openrouter.ChatCompletionChunk {
+		return &openrouter.ChatCompletionChunk{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{{Type: openrouter.ChatCompletionMessageReasoningDetailTypeReasoningText, Text: text, Signature: signature}},
+		}}}}
+	}
+	toolCallChunk := func(index int, id, name, arguments string) *openrouter.ChatCompletionChunk {
+		return &openrouter.ChatCompletionChunk{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			ToolCalls: []*openrouter.ChatCompletionToolCall{{Index: index, ID: id, Function: &openrouter.ChatCompletionMessageToolCallFunction{Name: name, Arguments: arguments}}},
+		}}}}
+	}
+	chunks := []*openrouter.ChatCompletionChunk{
+		reasoningChunk("I should list the directory.", ""),
+		reasoningChunk("", "sig-1"),
+		toolCallChunk(0, "tool_1", "list_dir", `{"path":"/tmp"}`),
+		reasoningChunk("Now read the file.", "sig-2"),
+		reasoningChunk("Then check its size.", "sig-3"),
+		toolCallChunk(1, "tool_2", "read_file", `{"path":"/tmp/a.txt"}`),
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: openrouter.ChatCompletionFinishReasonToolCalls}}},
+	}
+	builder := anthropic.NewMessageBuilder()
+	for event, err := range ConvertOpenRouterStreamToAnthropicStream(streamTestCtx(), createMockStream(chunks, nil)) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder error: %v", err)
+		}
+	}
+	type block struct {
+		Type      anthropic.MessageContentType
+		Text      string
+		Signature string
+	}
+	var got []block
+	for _, content := range builder.Message().Content {
+		switch content.Type {
+		case anthropic.MessageContentTypeThinking:
+			got = append(got, block{content.Type, content.Thinking, content.Signature})
+		case anthropic.MessageContentTypeToolUse:
+			got = append(got, block{Type: content.Type, Text: content.ID})
+		}
+	}
+	want := []block{
+		{anthropic.MessageContentTypeThinking, "I should list the directory.", "sig-1"},
+		{anthropic.MessageContentTypeToolUse, "tool_1", ""},
+		{anthropic.MessageContentTypeThinking, "Now read the file.", "sig-2"},
+		{anthropic.MessageContentTypeThinking, "Then check its size.", "sig-3"},
+		{anthropic.MessageContentTypeToolUse, "tool_2", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("content blocks =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestConvertOpenRouterStreamToAnthropicStream_ReasoningExclude(t *testing.T) {
+	ctx := profile.WithProfile(context.Background(), &profile.Profile{
+		Name:     "test",
+		Provider: "openrouter",
+		Options: &profile.OptionsConfig{
+			Reasoning: &profile.ReasoningConfig{Format: "anthropic-claude-v1", Exclude: true},
+		},
+	})
+	chunks := []*openrouter.ChatCompletionChunk{
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{
+				{Type: openrouter.ChatCompletionMessageReasoningDetailTypeReasoningText, Text: "thinking", Signature: "sig"},
+				{Type: openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted, Data: "opaque"},
+			},
+		}}}},
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{Content: "Hello"}}}},
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: openrouter.ChatCompletionFinishReasonStop}}},
+	}
+	builder := anthropic.NewMessageBuilder()
+	for event, err := range ConvertOpenRouterStreamToAnthropicStream(ctx, createMockStream(chunks, nil)) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if start, ok := event.(*anthropic.EventContentBlockStart); ok && start.ContentBlock.Type == anthropic.MessageContentTypeThinking {
+			t.Fatal("no thinking block should be emitted when reasoning is excluded")
+		}
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder error: %v", err)
+		}
+	}
+	content := builder.Message().Content
+	if len(content) != 1 || content[0].Type != anthropic.MessageContentTypeText || content[0].Text != "Hello" {
+		t.Errorf("unexpected content: %+v", content)
+	}
+}
+
+func TestConvertOpenRouterStreamToAnthropicStream_URLCitation(t *testing.T) {
+	chunks := []*openrouter.ChatCompletionChunk{
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			Content: "Go 1.24 was released in February 2025.",
+		}}}},
+		{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			Annotations: []*openrouter.ChatCompletionAnnotation{{
+				Type: openrouter.ChatCompletionAnnotationTypeURLCitation,
+				URLCitation: &openrouter.ChatCompletionURLCitation{
+					URL:        "https://go.dev/blog/go1.24",
+					Title:      "Go 1.24 is released!",
+					Content:    "Today the Go team is excited to release Go 1.24",
+					StartIndex: 0,
+					EndIndex:   39,
+				},
+			}},
+		}, FinishReason: openrouter.ChatCompletionFinishReasonStop}}},
+	}
+	events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(streamTestCtx(), createMockStream(chunks, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	for _, event := range events {
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder.Add failed: %v", err)
+		}
+	}
+	message := builder.Message()
+	if len(message.Content) != 1 {
+		t.Fatalf("expected 1 content block, got %d", len(message.Content))
+	}
+	block := message.Content[0]
+	if block.Type != anthropic.MessageContentTypeText || block.Text != "Go 1.24 was released in February 2025." {
+		t.Errorf("unexpected text block: %#v", block)
+	}
+	want := []*anthropic.Citation{{
+		Type:      anthropic.CitationTypeWebSearchResultLocation,
+		URL:       "https://go.dev/blog/go1.24",
+		Title:     "Go 1.24 is released!",
+		CitedText: "Today the Go team is excited to release Go 1.24",
+	}}
+	if !reflect.DeepEqual(block.Citations, want) {
+		t.Errorf("citations = %+v, want %+v", block.Citations, want[0])
+	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"citations":[{"type":"web_search_result_location"`) {
+		t.Errorf("citations not serialized as expected: %s", data)
+	}
+}
+
+func TestConvertOpenRouterStreamToAnthropicStream_WebSearchResults(t *testing.T) {
+	citation := func(url, title string) *openrouter.ChatCompletionAnnotation {
+		return &openrouter.ChatCompletionAnnotation{
+			Type:        openrouter.ChatCompletionAnnotationTypeURLCitation,
+			URLCitation: &openrouter.ChatCompletionURLCitation{URL: url, Title: title, Content: title},
+		}
+	}
+	chunks := []*openrouter.ChatCompletionChunk{
+		{ID: "gen-1", Model: "openai/gpt-5", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			Content: "Go 1.24 was released in February 2025.",
+		}}}},
+		{ID: "gen-1", Model: "openai/gpt-5", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+			Annotations: []*openrouter.ChatCompletionAnnotation{
+				citation("https://go.dev/blog/go1.24", "Go 1.24 is released!"),
+				citation("https://go.dev/doc/go1.24", "Go 1.24 Release Notes"),
+				citation("https://go.dev/blog/go1.24", "Go 1.24 is released!"),
+			},
+		}, FinishReason: openrouter.ChatCompletionFinishReasonStop}}, Usage: &openrouter.ChatCompletionUsage{PromptTokens: 10, CompletionTokens: 8}},
+	}
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.OpenRouter.WebSearch = true
+	})
+	events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(ctx, createMockStream(chunks, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	var usage *anthropic.Usage
+	for _, event := range events {
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder.Add failed: %v", err)
+		}
+		if messageDelta, ok := event.(*anthropic.EventMessageDelta); ok {
+			usage = messageDelta.Usage
+		}
+	}
+	content := builder.Message().Content
+	if len(content) != 4 {
+		t.Fatalf("expected text, server_tool_use, web_search_tool_result and cited text blocks, got %+v", content)
+	}
+	if content[0].Type != anthropic.MessageContentTypeText || content[0].Text != "Go 1.24 was released in February 2025." {
+		t.Errorf("text block = %+v", content[0])
+	}
+	if toolUse := content[1]; toolUse.Type != anthropic.MessageContentTypeServerToolUse || toolUse.Name != "web_search" || toolUse.ID != "srvtoolu_gen-1" {
+		t.Errorf("server_tool_use block = %+v", toolUse)
+	}
+	result := content[2]
+	if result.Type != anthropic.MessageContentTypeWebSearchToolResult || result.ToolUseID != "srvtoolu_gen-1" {
+		t.Fatalf("web_search_tool_result block = %+v", result)
+	}
+	if len(result.Content) != 2 || result.Content[0].Url != "https://go.dev/blog/go1.24" ||
+		result.Content[1].Url != "https://go.dev/doc/go1.24" || result.Content[1].Title != "Go 1.24 Release Notes" ||
+		result.Content[0].Type != anthropic.MessageContentTypeWebSearchResult {
+		t.Errorf("web search results = %+v, want one result per URL", result.Content)
+	}
+	if cited := content[3]; cited.Type != anthropic.MessageContentTypeText || len(cited.Citations) != 3 {
+		t.Errorf("citations block = %+v", cited)
+	}
+	if usage == nil || usage.ServerToolUse == nil || usage.ServerToolUse.WebSearchRequests != 1 {
+		t.Errorf("usage = %+v, want one web search request", usage)
+	}
+
+	events, err = collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(streamTestCtx(), createMockStream(chunks, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, event := range events {
+		if start, ok := event.(*anthropic.EventContentBlockStart); ok && start.ContentBlock.Type != anthropic.MessageContentTypeText {
+			t.Errorf("without openrouter.web_search only text blocks are expected, got %s", start.ContentBlock.Type)
+		}
+	}
+}
+
 func TestConvertOpenRouterStreamToAnthropicStream_CacheReadInputTokens_TwoRequests(t *testing.T) {
 	mk := func(cached int64) openrouter.ChatCompletionStream {
 		chunks := []*openrouter.ChatCompletionChunk{
@@ -834,3 +1235,695 @@ func createMockStream(chunks []*openrouter.ChatCompletionChunk, err error) openr
 		}
 	}
 }
+
+func createMockOpenAIStream(events []openai.Event, err error) openai.ResponseStream {
+	return func(yield func(openai.Event, error) bool) {
+		for _, event := range events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+func collectAnthropicEvents(t *testing.T, stream anthropic.MessageStream) ([]anthropic.Event, error) {
+	t.Helper()
+	var events []anthropic.Event
+	for event, err := range stream {
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_TextAndUsage(t *testing.T) {
+	events := []openai.Event{
+		&openai.ResponseCreatedEvent{Response: &openai.Response{ID: "resp_1", Model: "gpt-5", Status: openai.ResponseStatusInProgress}},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeMessage, ID: "msg_1"}},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: "Hello"},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: ""},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: " world"},
+		&openai.ResponseCompletedEvent{Response: &openai.Response{
+			ID:     "resp_1",
+			Status: openai.ResponseStatusCompleted,
+			Usage: &openai.ResponseUsage{
+				InputTokens:        100,
+				InputTokensDetails: &openai.ResponseInputTokensDetails{CachedTokens: 40},
+				OutputTokens:       20,
+			},
+		}},
+	}
+	got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil), WithInputTokens(90)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// message_start, block_start, 2 deltas, block_stop, message_delta, message_stop
+	if len(got) != 7 {
+		t.Fatalf("expected 7 events, got %d", len(got))
+	}
+	start, ok := got[0].(*anthropic.EventMessageStart)
+	if !ok || start.Message.ID != "resp_1" || start.Message.Model != "gpt-5" || start.Message.Usage.InputTokens != 90 {
+		t.Fatalf("unexpected message_start: %#v", got[0])
+	}
+	if block, ok := got[1].(*anthropic.EventContentBlockStart); !ok || block.ContentBlock.Type != anthropic.MessageContentTypeText {
+		t.Fatalf("expected text block start, got %#v", got[1])
+	}
+	if delta, ok := got[3].(*anthropic.EventContentBlockDelta); !ok || delta.Delta.Text != " world" {
+		t.Fatalf("unexpected text delta: %#v", got[3])
+	}
+	messageDelta, ok := got[5].(*anthropic.EventMessageDelta)
+	if !ok {
+		t.Fatalf("expected message_delta, got %#v", got[5])
+	}
+	if messageDelta.Delta.StopReason == nil || *messageDelta.Delta.StopReason != anthropic.StopReasonEndTurn {
+		t.Errorf("expected end_turn stop reason, got %v", messageDelta.Delta.StopReason)
+	}
+	if u := messageDelta.Usage; u == nil || u.InputTokens != 100 || u.OutputTokens != 20 || u.CacheReadInputTokens != 40 {
+		t.Errorf("unexpected usage: %#v", messageDelta.Usage)
+	}
+	if _, ok := got[6].(*anthropic.EventMessageStop); !ok {
+		t.Errorf("expected message_stop, got %#v", got[6])
+	}
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_ReasoningAndToolCall(t *testing.T) {
+	events := []openai.Event{
+		&openai.ResponseCreatedEvent{Response: &openai.Response{ID: "resp_2", Model: "gpt-5"}},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_1"}},
+		&openai.ResponseReasoningSummaryTextDeltaEvent{ItemID: "rs_1", SummaryIndex: 0, Delta: "first"},
+		&openai.ResponseReasoningSummaryTextDeltaEvent{ItemID: "rs_1", SummaryIndex: 0, Delta: " part"},
+		&openai.ResponseReasoningSummaryTextDeltaEvent{ItemID: "rs_1", SummaryIndex: 1, Delta: "second"},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeMessage, ID: "msg_1"}},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: "Let me check."},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeFunctionCall, ID: "fc_1", CallID: "call_1", Name: "get_weather"}},
+		&openai.ResponseFunctionCallArgumentsDeltaEvent{ItemID: "fc_1", Delta: `{"city":`},
+		&openai.ResponseFunctionCallArgumentsDeltaEvent{ItemID: "fc_1", Delta: `"Paris"}`},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeFunctionCall, ID: "fc_2", CallID: "call_2", Name: "get_time"}},
+		&openai.ResponseFunctionCallArgumentsDeltaEvent{ItemID: "fc_2", Delta: `{}`},
+		&openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted}},
+	}
+	got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	for _, event := range got {
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder.Add failed: %v", err)
+		}
+	}
+	message := builder.Message()
+	if len(message.Content) != 4 {
+		t.Fatalf("expected 4 content blocks, got %d", len(message.Content))
+	}
+	if c := message.Content[0]; c.Type != anthropic.MessageContentTypeThinking || c.Thinking != "first part\n\nsecond" {
+		t.Errorf("unexpected thinking block: %#v", c)
+	}
+	if c := message.Content[1]; c.Type != anthropic.MessageContentTypeText || c.Text != "Let me check." {
+		t.Errorf("unexpected text block: %#v", c)
+	}
+	if c := message.Content[2]; c.Type != anthropic.MessageContentTypeToolUse || c.ID != "call_1" || c.Name != "get_weather" || string(c.Input) != `{"city":"Paris"}` {
+		t.Errorf("unexpected first tool_use block: %#v (input %s)", c, c.Input)
+	}
+	if c := message.Content[3]; c.Type != anthropic.MessageContentTypeToolUse || c.ID != "call_2" || c.Name != "get_time" {
+		t.Errorf("unexpected second tool_use block: %#v", c)
+	}
+	if message.StopReason == nil || *message.StopReason != anthropic.StopReasonToolUse {
+		t.Errorf("expected tool_use stop reason, got %v", message.StopReason)
+	}
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_StopReasons(t *testing.T) {
+	tests := []struct {
+		name  string
+		event openai.Event
+		want  anthropic.StopReason
+	}{
+		{
+			name:  "completed",
+			event: &openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted}},
+			want:  anthropic.StopReasonEndTurn,
+		},
+		{
+			name: "incomplete max_output_tokens",
+			event: &openai.ResponseIncompleteEvent{Response: &openai.Response{
+				Status:            openai.ResponseStatusIncomplete,
+				IncompleteDetails: &openai.ResponseIncompleteDetails{Reason: openai.ResponseIncompleteReasonMaxOutputTokens},
+			}},
+			want: anthropic.StopReasonMaxTokens,
+		},
+		{
+			name: "incomplete content_filter",
+			event: &openai.ResponseIncompleteEvent{Response: &openai.Response{
+				Status:            openai.ResponseStatusIncomplete,
+				IncompleteDetails: &openai.ResponseIncompleteDetails{Reason: openai.ResponseIncompleteReasonContentFilter},
+			}},
+			want: anthropic.StopReasonRefusal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream([]openai.Event{tt.event}, nil)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			messageDelta, ok := got[len(got)-2].(*anthropic.EventMessageDelta)
+			if !ok || messageDelta.Delta.StopReason == nil || *messageDelta.Delta.StopReason != tt.want {
+				t.Fatalf("expected stop reason %q, got %#v", tt.want, got[len(got)-2])
+			}
+		})
+	}
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_ServiceTier(t *testing.T) {
+	events := []openai.Event{
+		&openai.ResponseCreatedEvent{Response: &openai.Response{ID: "resp_4", ServiceTier: "auto"}},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: "hi"},
+		&openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted, ServiceTier: "priority"}},
+	}
+	var tier string
+	if _, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil), ExtractServiceTier(&tier))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tier != "priority" {
+		t.Errorf("service tier = %q, want the one of the completed response", tier)
+	}
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_Refusal(t *testing.T) {
+	t.Run("streamed refusal", func(t *testing.T) {
+		events := []openai.Event{
+			&openai.ResponseCreatedEvent{Response: &openai.Response{ID: "resp_3", Model: "gpt-5"}},
+			&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeMessage, ID: "msg_1"}},
+			&openai.ResponseRefusalDeltaEvent{ItemID: "msg_1", Delta: "I can't help"},
+			&openai.ResponseRefusalDeltaEvent{ItemID: "msg_1", Delta: " with that."},
+			&openai.ResponseRefusalDoneEvent{ItemID: "msg_1", Refusal: "I can't help with that."},
+			&openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted}},
+		}
+		got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		builder := anthropic.NewMessageBuilder()
+		for _, event := range got {
+			if err = builder.Add(event); err != nil {
+				t.Fatalf("builder.Add failed: %v", err)
+			}
+		}
+		message := builder.Message()
+		if len(message.Content) != 1 || message.Content[0].Type != anthropic.MessageContentTypeText || message.Content[0].Text != "I can't help with that." {
+			t.Fatalf("expected a single text block with the refusal, got %#v", message.Content)
+		}
+		if message.StopReason == nil || *message.StopReason != anthropic.StopReasonRefusal {
+			t.Errorf("expected refusal stop reason, got %v", message.StopReason)
+		}
+	})
+
+	t.Run("refusal only in done event", func(t *testing.T) {
+		events := []openai.Event{
+			&openai.ResponseRefusalDoneEvent{ItemID: "msg_1", Refusal: "No."},
+			&openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted}},
+		}
+		got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// message_start, block_start, delta, block_stop, message_delta, message_stop
+		if len(got) != 6 {
+			t.Fatalf("expected 6 events, got %d", len(got))
+		}
+		if delta, ok := got[2].(*anthropic.EventContentBlockDelta); !ok || delta.Delta.Text != "No." {
+			t.Errorf("unexpected refusal delta: %#v", got[2])
+		}
+		if messageDelta, ok := got[4].(*anthropic.EventMessageDelta); !ok || messageDelta.Delta.StopReason == nil || *messageDelta.Delta.StopReason != anthropic.StopReasonRefusal {
+			t.Errorf("expected refusal stop reason, got %#v", got[4])
+		}
+	})
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_Errors(t *testing.T) {
+	t.Run("stream error", func(t *testing.T) {
+		streamErr := errors.New("connection reset")
+		_, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(nil, streamErr)))
+		if !errors.Is(err, streamErr) {
+			t.Fatalf("expected stream error, got %v", err)
+		}
+	})
+	t.Run("error event", func(t *testing.T) {
+		events := []openai.Event{&openai.ErrorEvent{Code: "server_error", Message: "boom"}}
+		_, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil)))
+		if err == nil || err.Error() != "server_error: boom" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	t.Run("response failed", func(t *testing.T) {
+		events := []openai.Event{&openai.ResponseFailedEvent{Response: &openai.Response{
+			Status: openai.ResponseStatusFailed,
+			Error:  &openai.ResponseError{Code: "rate_limit_exceeded", Message: "slow down"},
+		}}}
+		_, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil)))
+		var responseErr *openai.ResponseError
+		if !errors.As(err, &responseErr) || responseErr.Code != "rate_limit_exceeded" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+// roundTripThinkingSignature sends an assistant thinking block through the anthropic-claude-v1 request
+// conversion, replays the resulting reasoning details as an OpenRouter stream (splitting text and signature
+// into fragments of at most fragmentSize bytes) and returns the thinking block rebuilt from the converted
+// Anthropic stream, along with the reasoning detail accumulated by the OpenRouter chat completion builder.
+func roundTripThinkingSignature(
+	t *testing.T,
+	thinking *anthropic.MessageContent,
+	fragmentSize int,
+) (*anthropic.MessageContent, *openrouter.ChatCompletionMessageReasoningDetail) {
+	t.Helper()
+	ctx := streamTestCtx()
+	prof, _ := profile.FromContext(ctx)
+	assistant := &anthropic.Message{
+		Role:    anthropic.MessageRoleAssistant,
+		Content: anthropic.MessageContents{thinking},
+	}
+	messages := canonicalOpenRouterMessages(prof, "anthropic/claude-sonnet-4", []*openrouterChatCompletionMessageWrapper{
+		{
+			ChatCompletionMessage: &openrouter.ChatCompletionMessage{
+				Role:      openrouter.ChatCompletionMessageRoleAssistant,
+				Reasoning: thinking.Thinking,
+				ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{
+					{
+						Type:      openrouter.ChatCompletionMessageReasoningDetailTypeReasoningText,
+						Text:      thinking.Thinking,
+						Signature: thinking.Signature,
+						Format:    openrouter.ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1,
+					},
+				},
+			},
+			underlyingAnthropicMessage: assistant,
+		},
+	})
+	if len(messages) != 1 || len(messages[0].ReasoningDetails) != 1 {
+		t.Fatalf("expected a single assistant message with one reasoning detail, got %+v", messages)
+	}
+	detail := messages[0].ReasoningDetails[0]
+	split := func(s string) []string {
+		var fragments []string
+		for len(s) > fragmentSize {
+			fragments = append(fragments, s[:fragmentSize])
+			s = s[fragmentSize:]
+		}
+		return append(fragments, s)
+	}
+	var chunks []*openrouter.ChatCompletionChunk
+	newChunk := func(reasoningDetail *openrouter.ChatCompletionMessageReasoningDetail) *openrouter.ChatCompletionChunk {
+		return &openrouter.ChatCompletionChunk{
+			ID:    "chatcmpl-roundtrip",
+			Model: "anthropic/claude-sonnet-4",
+			Choices: []*openrouter.ChatCompletionChunkChoice{
+				{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+					ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{reasoningDetail},
+				}},
+			},
+		}
+	}
+	for _, text := range split(detail.Text) {
+		chunks = append(chunks, newChunk(&openrouter.ChatCompletionMessageReasoningDetail{
+			Type:   detail.Type,
+			Text:   text,
+			Format: detail.Format,
+		}))
+	}
+	for _, signature := range split(detail.Signature) {
+		chunks = append(chunks, newChunk(&openrouter.ChatCompletionMessageReasoningDetail{
+			Type:      detail.Type,
+			Signature: signature,
+			Format:    detail.Format,
+		}))
+	}
+	chunks = append(chunks, &openrouter.ChatCompletionChunk{
+		ID:      "chatcmpl-roundtrip",
+		Model:   "anthropic/claude-sonnet-4",
+		Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: "stop", Delta: &openrouter.ChatCompletionChunkChoiceDelta{}}},
+	})
+	chatCompletionBuilder := openrouter.NewChatCompletionBuilder()
+	events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(ctx, createMockStream(chunks, nil),
+		ExtractOpenRouterChatCompletionBuilder(chatCompletionBuilder),
+	))
+	if err != nil {
+		t.Fatalf("unexpected stream error: %v", err)
+	}
+	messageBuilder := anthropic.NewMessageBuilder()
+	for _, event := range events {
+		if err = messageBuilder.Add(event); err != nil {
+			t.Fatalf("error building message: %v", err)
+		}
+	}
+	message := messageBuilder.Message()
+	if len(message.Content) != 1 || message.Content[0].Type != anthropic.MessageContentTypeThinking {
+		t.Fatalf("expected a single thinking block, got %+v", message.Content)
+	}
+	completion := chatCompletionBuilder.Build()
+	if len(completion.Choices) != 1 || completion.Choices[0].Message == nil || len(completion.Choices[0].Message.ReasoningDetails) != 1 {
+		t.Fatalf("expected a single accumulated reasoning detail, got %+v", completion.Choices)
+	}
+	return message.Content[0], completion.Choices[0].Message.ReasoningDetails[0]
+}
+
+func TestThinkingSignatureRoundTrip(t *testing.T) {
+	longSignature := strings.Repeat("EqoBCkgIARABGAIiQL2fK4p+7nXw9Lm3Z0Qb1a/Y6sVhR8T2uD5cJ0kF4eN3gH7iM9oP1qR5sT8vW2xY4zA6bC+dE0fG==", 64)
+	tests := []struct {
+		name         string
+		signature    string
+		fragmentSize int
+	}{
+		{name: "short signature in one fragment", signature: "sig123", fragmentSize: 1024},
+		{name: "long signature in one fragment", signature: longSignature, fragmentSize: len(longSignature)},
+		{name: "long signature split into fragments", signature: longSignature, fragmentSize: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thinking := &anthropic.MessageContent{
+				Type:      anthropic.MessageContentTypeThinking,
+				Thinking:  "The user wants a greeting; keep it short.",
+				Signature: tt.signature,
+			}
+			got, detail := roundTripThinkingSignature(t, thinking, tt.fragmentSize)
+			if got.Signature != thinking.Signature {
+				t.Errorf("signature changed in round trip: got %d bytes, want %d bytes", len(got.Signature), len(thinking.Signature))
+			}
+			if got.Thinking != thinking.Thinking {
+				t.Errorf("thinking = %q, want %q", got.Thinking, thinking.Thinking)
+			}
+			if detail.Signature != thinking.Signature {
+				t.Errorf("accumulated reasoning detail signature: got %d bytes, want %d bytes", len(detail.Signature), len(thinking.Signature))
+			}
+		})
+	}
+}
+
+func TestEncryptedReasoningRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    openrouter.ChatCompletionMessageReasoningDetailFormat
+		model     string
+		delimiter string
+		id        string
+		data      string
+	}{
+		{name: "with id", id: "rs_1", data: "gAAAAABo/x+y9Q=="},
+		{name: "without id, data containing the delimiter", data: "CqYBAR/84Z+u8w=="},
+		{name: "without id", data: "CqYBAR84Zu8w"},
+		{
+			name:   "gemini tool call signature",
+			format: openrouter.ChatCompletionMessageReasoningDetailFormatGoogleGeminiV1,
+			model:  "google/gemini-2.5-pro",
+			id:     "tool_Read_abc123",
+			data:   "CiQB0e2Kb/Xr+4n2Nq==",
+		},
+		{
+			name:      "gemini with a multi-character delimiter",
+			format:    openrouter.ChatCompletionMessageReasoningDetailFormatGoogleGeminiV1,
+			model:     "google/gemini-2.5-pro",
+			delimiter: "::",
+			id:        "tool_Read_abc123",
+			data:      "CiQB0e2Kb/Xr::4n2Nq==",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.format == "" {
+				tt.format, tt.model = openrouter.ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1, "openai/gpt-5"
+			}
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.Reasoning.Format = string(tt.format)
+				if tt.delimiter != "" {
+					p.Options.Reasoning.Delimiter = tt.delimiter
+				}
+			})
+			chunks := []*openrouter.ChatCompletionChunk{
+				{ID: "chatcmpl-1", Model: tt.model, Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+					ReasoningDetails: []*openrouter.ChatCompletionMessageReasoningDetail{{
+						Type:   openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted,
+						ID:     tt.id,
+						Data:   tt.data,
+						Format: tt.format,
+					}},
+				}}}},
+				{ID: "chatcmpl-1", Model: tt.model, Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: "stop", Delta: &openrouter.ChatCompletionChunkChoiceDelta{}}}},
+			}
+			events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(ctx, createMockStream(chunks, nil)))
+			if err != nil {
+				t.Fatalf("unexpected stream error: %v", err)
+			}
+			messageBuilder := anthropic.NewMessageBuilder()
+			for _, event := range events {
+				if err = messageBuilder.Add(event); err != nil {
+					t.Fatalf("error building message: %v", err)
+				}
+			}
+			assistant := messageBuilder.Message()
+			if tt.id != "" {
+				delimiter := tt.delimiter
+				if delimiter == "" {
+					delimiter = "/"
+				}
+				if len(assistant.Content) == 0 || assistant.Content[0].Signature != tt.id+delimiter+tt.data {
+					t.Errorf("thinking signature = %+v, want %q", assistant.Content, tt.id+delimiter+tt.data)
+				}
+			}
+			dst := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:     tt.model,
+				MaxTokens: 1024,
+				Messages: []*anthropic.Message{
+					{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+					{Role: anthropic.MessageRoleAssistant, Content: assistant.Content},
+					{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "go on"}}},
+				},
+			})
+			var encrypted []*openrouter.ChatCompletionMessageReasoningDetail
+			for _, message := range dst.Messages {
+				for _, detail := range message.ReasoningDetails {
+					if detail.Type == openrouter.ChatCompletionMessageReasoningDetailTypeEncrypted {
+						encrypted = append(encrypted, detail)
+					}
+				}
+			}
+			if len(encrypted) != 1 {
+				t.Fatalf("expected one encrypted reasoning detail, got %d", len(encrypted))
+			}
+			if encrypted[0].ID != tt.id || encrypted[0].Data != tt.data {
+				t.Errorf("encrypted reasoning detail = {ID: %q, Data: %q}, want {ID: %q, Data: %q}", encrypted[0].ID, encrypted[0].Data, tt.id, tt.data)
+			}
+		})
+	}
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_EncryptedReasoning(t *testing.T) {
+	events := []openai.Event{
+		&openai.ResponseCreatedEvent{Response: &openai.Response{ID: "resp_3", Model: "gpt-5"}},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_1"}},
+		&openai.ResponseReasoningSummaryTextDeltaEvent{ItemID: "rs_1", Delta: "thinking"},
+		&openai.ResponseOutputItemDoneEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_1", EncryptedContent: "gAAAAABo/x+y"}},
+		&openai.ResponseOutputItemDoneEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_2", EncryptedContent: "gAAAAABp"}},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: "done"},
+		&openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted}},
+	}
+	got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(streamTestCtx(), createMockOpenAIStream(events, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	for _, event := range got {
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder.Add failed: %v", err)
+		}
+	}
+	message := builder.Message()
+	if len(message.Content) != 3 {
+		t.Fatalf("expected 3 content blocks, got %d", len(message.Content))
+	}
+	if c := message.Content[0]; c.Type != anthropic.MessageContentTypeThinking || c.Thinking != "thinking" || c.Signature != "rs_1/gAAAAABo/x+y" {
+		t.Errorf("unexpected first thinking block: %#v", c)
+	}
+	if c := message.Content[1]; c.Type != anthropic.MessageContentTypeThinking || c.Thinking != "" || c.Signature != "rs_2/gAAAAABp" {
+		t.Errorf("unexpected second thinking block: %#v", c)
+	}
+}
+
+func TestConvertOpenRouterStreamToAnthropicStream_StopSequence(t *testing.T) {
+	textChunk := func(content string, finishReason openrouter.ChatCompletionFinishReason) *openrouter.ChatCompletionChunk {
+		return &openrouter.ChatCompletionChunk{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{
+			Delta:        &openrouter.ChatCompletionChunkChoiceDelta{Content: content},
+			FinishReason: finishReason,
+		}}}
+	}
+	messageDelta := func(t *testing.T, chunks []*openrouter.ChatCompletionChunk, stopSequences []string) *anthropic.Message {
+		t.Helper()
+		events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(streamTestCtx(), createMockStream(chunks, nil),
+			WithStopSequences(stopSequences),
+		))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, event := range events {
+			if e, ok := event.(*anthropic.EventMessageDelta); ok {
+				return e.Delta
+			}
+		}
+		t.Fatal("no message_delta event")
+		return nil
+	}
+
+	t.Run("output ends with a stop sequence", func(t *testing.T) {
+		delta := messageDelta(t, []*openrouter.ChatCompletionChunk{
+			textChunk("The answer is 42.\n\nHu", ""),
+			textChunk("man:", openrouter.ChatCompletionFinishReasonStop),
+		}, []string{"Assistant:", "\n\nHuman:"})
+		if delta.StopReason == nil || *delta.StopReason != anthropic.StopReasonStopSequence {
+			t.Errorf("stop_reason = %v, want stop_sequence", delta.StopReason)
+		}
+		if delta.StopSequence == nil || *delta.StopSequence != "\n\nHuman:" {
+			t.Errorf("stop_sequence = %v, want %q", delta.StopSequence, "\n\nHuman:")
+		}
+	})
+
+	t.Run("output without a stop sequence", func(t *testing.T) {
+		delta := messageDelta(t, []*openrouter.ChatCompletionChunk{
+			textChunk("The answer is 42.", openrouter.ChatCompletionFinishReasonStop),
+		}, []string{"\n\nHuman:"})
+		if delta.StopReason == nil || *delta.StopReason != anthropic.StopReasonEndTurn || delta.StopSequence != nil {
+			t.Errorf("stop_reason = %v, stop_sequence = %v, want end_turn without a stop sequence", delta.StopReason, delta.StopSequence)
+		}
+	})
+
+	t.Run("max tokens", func(t *testing.T) {
+		delta := messageDelta(t, []*openrouter.ChatCompletionChunk{
+			textChunk("END", openrouter.ChatCompletionFinishReasonLength),
+		}, []string{"END"})
+		if delta.StopReason == nil || *delta.StopReason != anthropic.StopReasonMaxTokens || delta.StopSequence != nil {
+			t.Errorf("stop_reason = %v, stop_sequence = %v, want max_tokens", delta.StopReason, delta.StopSequence)
+		}
+	})
+}
+
+func TestConvertOpenRouterStreamToAnthropicStream_EnforceSerialToolCalls(t *testing.T) {
+	toolCallChunk := func(index int, id, name, arguments string, finishReason openrouter.ChatCompletionFinishReason) *openrouter.ChatCompletionChunk {
+		return &openrouter.ChatCompletionChunk{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{
+			Delta: &openrouter.ChatCompletionChunkChoiceDelta{ToolCalls: []*openrouter.ChatCompletionToolCall{{
+				Index:    index,
+				ID:       id,
+				Function: &openrouter.ChatCompletionMessageToolCallFunction{Name: name, Arguments: arguments},
+			}}},
+			FinishReason: finishReason,
+		}}}
+	}
+	chunks := []*openrouter.ChatCompletionChunk{
+		toolCallChunk(0, "call_1", "Read", `{"file_path":`, ""),
+		toolCallChunk(0, "", "", `"a.go"}`, ""),
+		toolCallChunk(1, "call_2", "Read", `{"file_path":`, ""),
+		toolCallChunk(1, "", "", `"b.go"}`, openrouter.ChatCompletionFinishReasonToolCalls),
+	}
+	toolUses := func(t *testing.T, ctx context.Context, options ...ConvertStreamOption) (ids []string, arguments string) {
+		t.Helper()
+		events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(ctx, createMockStream(chunks, nil), options...))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, event := range events {
+			switch e := event.(type) {
+			case *anthropic.EventContentBlockStart:
+				ids = append(ids, e.ContentBlock.ID)
+			case *anthropic.EventContentBlockDelta:
+				arguments += e.Delta.PartialJSON
+			case *anthropic.EventMessageDelta:
+				if e.Delta.StopReason == nil || *e.Delta.StopReason != anthropic.StopReasonToolUse {
+					t.Errorf("stop_reason = %v, want tool_use", e.Delta.StopReason)
+				}
+			}
+		}
+		return ids, arguments
+	}
+	enforced := testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.EnforceSerialToolCalls = true
+	})
+
+	t.Run("parallel tool use disabled", func(t *testing.T) {
+		ids, arguments := toolUses(t, enforced, WithDisableParallelToolUse(true))
+		if !reflect.DeepEqual(ids, []string{"call_1"}) || arguments != `{"file_path":"a.go"}` {
+			t.Errorf("tool calls = %v with arguments %s, want only call_1", ids, arguments)
+		}
+	})
+
+	t.Run("parallel tool use allowed", func(t *testing.T) {
+		ids, _ := toolUses(t, enforced)
+		if !reflect.DeepEqual(ids, []string{"call_1", "call_2"}) {
+			t.Errorf("tool calls = %v, want both", ids)
+		}
+	})
+
+	t.Run("option not set", func(t *testing.T) {
+		ids, _ := toolUses(t, streamTestCtx(), WithDisableParallelToolUse(true))
+		if !reflect.DeepEqual(ids, []string{"call_1", "call_2"}) {
+			t.Errorf("tool calls = %v, want both", ids)
+		}
+	})
+}
+
+func TestConvertOpenAIStreamToAnthropicStream_PreferSummary(t *testing.T) {
+	events := []openai.Event{
+		&openai.ResponseCreatedEvent{Response: &openai.Response{ID: "resp_4", Model: "gpt-oss-120b"}},
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_1"}},
+		&openai.ResponseReasoningTextDeltaEvent{ItemID: "rs_1", Delta: "full "},
+		&openai.ResponseReasoningTextDeltaEvent{ItemID: "rs_1", Delta: "text"},
+		&openai.ResponseReasoningSummaryTextDeltaEvent{ItemID: "rs_1", Delta: "summary"},
+		&openai.ResponseOutputItemDoneEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_1", EncryptedContent: "enc1"}},
+		// A reasoning item that only exposes a summary.
+		&openai.ResponseOutputItemAddedEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_2"}},
+		&openai.ResponseReasoningSummaryTextDeltaEvent{ItemID: "rs_2", Delta: "only summary"},
+		&openai.ResponseOutputItemDoneEvent{Item: &openai.ResponseOutputItem{Type: openai.ResponseOutputItemTypeReasoning, ID: "rs_2", EncryptedContent: "enc2"}},
+		&openai.ResponseOutputTextDeltaEvent{ItemID: "msg_1", Delta: "answer"},
+		&openai.ResponseCompletedEvent{Response: &openai.Response{Status: openai.ResponseStatusCompleted}},
+	}
+	thinkingBlocks := func(t *testing.T, preferSummary bool) [][2]string {
+		t.Helper()
+		ctx := profile.WithProfile(context.Background(), &profile.Profile{
+			Options: &profile.OptionsConfig{Reasoning: &profile.ReasoningConfig{PreferSummary: preferSummary}},
+		})
+		got, err := collectAnthropicEvents(t, ConvertOpenAIStreamToAnthropicStream(ctx, createMockOpenAIStream(events, nil)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		builder := anthropic.NewMessageBuilder()
+		for _, event := range got {
+			if err = builder.Add(event); err != nil {
+				t.Fatalf("builder.Add failed: %v", err)
+			}
+		}
+		var blocks [][2]string
+		for _, content := range builder.Message().Content {
+			if content.Type == anthropic.MessageContentTypeThinking {
+				blocks = append(blocks, [2]string{content.Thinking, content.Signature})
+			}
+		}
+		return blocks
+	}
+
+	t.Run("full text by default", func(t *testing.T) {
+		want := [][2]string{{"full text", "rs_1/enc1"}, {"only summary", "rs_2/enc2"}}
+		if got := thinkingBlocks(t, false); !reflect.DeepEqual(got, want) {
+			t.Errorf("thinking blocks = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("prefer summary", func(t *testing.T) {
+		want := [][2]string{{"summary", "rs_1/enc1"}, {"only summary", "rs_2/enc2"}}
+		if got := thinkingBlocks(t, true); !reflect.DeepEqual(got, want) {
+			t.Errorf("thinking blocks = %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package adapter
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+const (
+	// estimateCharsPerToken and estimateTokensPerWord are rough averages for English text and code.
+	estimateCharsPerToken = 4
+	estimateTokensPerWord = 1.3
+	// estimateMessageOverheadTokens accounts for role and block framing around each message.
+	estimateMessageOverheadTokens = 4
+	// estimateMediaTokens is charged for each image or document block, whose size cannot be
+	// estimated from base64 data without decoding it.
+	estimateMediaTokens = 1600
+)
+
+// EstimateInputTokens approximates the input tokens of req without calling upstream, using a
+// character/word heuristic over its text. It is only meant as a fallback when the count_tokens
+// endpoint is unavailable; the result may differ from the real count by a wide margin.
+func EstimateInputTokens(req *anthropic.CountTokensRequest) int64 {
+	var e tokenEstimator
+	e.addContents(req.System)
+	for _, message := range req.Messages {
+		if message == nil {
+			continue
+		}
+		e.tokens += estimateMessageOverheadTokens
+		e.addContents(message.Content)
+	}
+	for _, tool := range req.Tools {
+		if tool == nil {
+			continue
+		}
+		e.addText(tool.Name)
+		e.addText(tool.Description)
+		e.addText(string(tool.InputSchema))
+	}
+	return e.estimate()
+}
+
+type tokenEstimator struct {
+	chars  int
+	words  int
+	tokens int64
+}
+
+func (e *tokenEstimator) addText(s string) {
+	e.chars += utf8.RuneCountInString(s)
+	e.words += len(strings.Fields(s))
+}
+
+func (e *tokenEstimator) addContents(contents anthropic.MessageContents) {
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+		switch content.Type {
+		case anthropic.MessageContentTypeImage, anthropic.MessageContentTypeDocument:
+			e.tokens += estimateMediaTokens
+		default:
+			e.addText(content.Text)
+			e.addText(content.Thinking)
+			e.addText(content.Name)
+			e.addText(string(content.Input))
+			e.addContents(content.Content)
+		}
+	}
+}
+
+// estimate takes the larger of the character- and word-based estimates, so that neither long
+// identifiers nor short, frequent words are undercounted.
+func (e *tokenEstimator) estimate() int64 {
+	byChars := (e.chars + estimateCharsPerToken - 1) / estimateCharsPerToken
+	byWords := int(float64(e.words)*estimateTokensPerWord + 0.5)
+	return e.tokens + int64(max(byChars, byWords))
+}
@@ -0,0 +1,98 @@
+package adapter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestEstimateInputTokens(t *testing.T) {
+	newRequest := func(text string, messages int) *anthropic.CountTokensRequest {
+		req := &anthropic.CountTokensRequest{Model: "claude-sonnet-4"}
+		for range messages {
+			req.Messages = append(req.Messages, &anthropic.Message{
+				Role:    anthropic.MessageRoleUser,
+				Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: text}},
+			})
+		}
+		return req
+	}
+
+	t.Run("monotonic in message size", func(t *testing.T) {
+		var previous int64
+		for _, n := range []int{0, 1, 10, 100, 1000} {
+			got := EstimateInputTokens(newRequest(strings.Repeat("hello world ", n), 1))
+			if got < previous {
+				t.Errorf("estimate for %d repetitions = %d, smaller than %d", n, got, previous)
+			}
+			previous = got
+		}
+	})
+
+	t.Run("monotonic in message count", func(t *testing.T) {
+		var previous int64
+		for _, n := range []int{1, 2, 5, 20} {
+			got := EstimateInputTokens(newRequest("The quick brown fox jumps over the lazy dog.", n))
+			if got <= previous {
+				t.Errorf("estimate for %d messages = %d, not larger than %d", n, got, previous)
+			}
+			previous = got
+		}
+	})
+
+	t.Run("plausible magnitude", func(t *testing.T) {
+		// 1000 words of 6 characters each is roughly 1300-1750 tokens.
+		got := EstimateInputTokens(newRequest(strings.Repeat("token ", 1000), 1))
+		if got < 1000 || got > 2000 {
+			t.Errorf("estimate = %d, want between 1000 and 2000", got)
+		}
+	})
+
+	t.Run("sample prompts within tolerance", func(t *testing.T) {
+		// BPE tokenizers such as Claude's produce about one token per 3 to 4.5 characters of English
+		// prose and source code; the estimate should stay within that band plus message framing.
+		for _, prompt := range []string{
+			"Summarize the following meeting notes in three bullet points and list any action items with their owners.",
+			"func (s *Server) handle(w http.ResponseWriter, r *http.Request) {\n\tif r.Method != http.MethodPost {\n\t\thttp.Error(w, \"method not allowed\", 405)\n\t\treturn\n\t}\n}",
+			strings.Repeat("Refactor the parser so that error messages include the line and column of the offending token. ", 20),
+		} {
+			got := EstimateInputTokens(newRequest(prompt, 1)) - estimateMessageOverheadTokens
+			low, high := int64(len(prompt)*2/9), int64(len(prompt)/3)
+			if got < low || got > high {
+				t.Errorf("estimate for %d characters = %d, want between %d and %d", len(prompt), got, low, high)
+			}
+		}
+	})
+
+	t.Run("system, tools, tool results and images count", func(t *testing.T) {
+		base := newRequest("hi", 1)
+		baseTokens := EstimateInputTokens(base)
+		req := newRequest("hi", 1)
+		req.System = anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "You are a helpful assistant."}}
+		if EstimateInputTokens(req) <= baseTokens {
+			t.Error("system prompt should increase the estimate")
+		}
+		req = newRequest("hi", 1)
+		req.Tools = []*anthropic.Tool{{Name: "read_file", Description: "Read a file", InputSchema: []byte(`{"type":"object"}`)}}
+		if EstimateInputTokens(req) <= baseTokens {
+			t.Error("tools should increase the estimate")
+		}
+		req = newRequest("hi", 1)
+		req.Messages[0].Content = append(req.Messages[0].Content, &anthropic.MessageContent{
+			Type:    anthropic.MessageContentTypeToolResult,
+			Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "file contents"}},
+		})
+		if EstimateInputTokens(req) <= baseTokens {
+			t.Error("tool results should increase the estimate")
+		}
+		req = newRequest("hi", 1)
+		req.Messages[0].Content = append(req.Messages[0].Content, &anthropic.MessageContent{
+			Type:   anthropic.MessageContentTypeImage,
+			Source: &anthropic.MessageContentSource{Type: anthropic.MessageContentSourceTypeBase64, Data: strings.Repeat("A", 1<<20)},
+		})
+		if got := EstimateInputTokens(req); got != baseTokens+estimateMediaTokens {
+			t.Errorf("image estimate = %d, want flat %d on top of %d", got, estimateMediaTokens, baseTokens)
+		}
+	})
+}
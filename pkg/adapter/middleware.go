@@ -0,0 +1,136 @@
+package adapter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+// RequestMiddleware transforms a request before it is converted and sent upstream, e.g. to scrub
+// PII or rewrite prompts. Returning an error rejects the request, and later middlewares are not run.
+type RequestMiddleware interface {
+	TransformRequest(ctx context.Context, req *anthropic.GenerateMessageRequest) error
+}
+
+// ResponseMiddleware transforms each event of a response before it is sent to the client.
+// Returning a nil event drops it; returning an error ends the response with that error, and later
+// middlewares are not run.
+type ResponseMiddleware interface {
+	TransformEvent(ctx context.Context, event anthropic.Event) (anthropic.Event, error)
+}
+
+// RequestMiddlewareFunc adapts a function to RequestMiddleware.
+type RequestMiddlewareFunc func(ctx context.Context, req *anthropic.GenerateMessageRequest) error
+
+func (f RequestMiddlewareFunc) TransformRequest(ctx context.Context, req *anthropic.GenerateMessageRequest) error {
+	return f(ctx, req)
+}
+
+// ResponseMiddlewareFunc adapts a function to ResponseMiddleware.
+type ResponseMiddlewareFunc func(ctx context.Context, event anthropic.Event) (anthropic.Event, error)
+
+func (f ResponseMiddlewareFunc) TransformEvent(ctx context.Context, event anthropic.Event) (anthropic.Event, error) {
+	return f(ctx, event)
+}
+
+// NopMiddleware is a RequestMiddleware and ResponseMiddleware that changes nothing; it shows the
+// shape of a middleware and may be embedded to implement only one of the interfaces.
+type NopMiddleware struct{}
+
+func (NopMiddleware) TransformRequest(context.Context, *anthropic.GenerateMessageRequest) error {
+	return nil
+}
+
+func (NopMiddleware) TransformEvent(_ context.Context, event anthropic.Event) (anthropic.Event, error) {
+	return event, nil
+}
+
+// Middlewares is an ordered set of request and response middlewares, run in registration order.
+// The zero value is ready to use.
+type Middlewares struct {
+	mu       sync.RWMutex
+	request  []RequestMiddleware
+	response []ResponseMiddleware
+}
+
+// DefaultMiddlewares are the middlewares the serve command runs on every /v1/messages request.
+var DefaultMiddlewares = &Middlewares{}
+
+// RegisterRequestMiddleware adds m to DefaultMiddlewares.
+func RegisterRequestMiddleware(m RequestMiddleware) { DefaultMiddlewares.AddRequest(m) }
+
+// RegisterResponseMiddleware adds m to DefaultMiddlewares.
+func RegisterResponseMiddleware(m ResponseMiddleware) { DefaultMiddlewares.AddResponse(m) }
+
+// AddRequest appends a request middleware.
+func (ms *Middlewares) AddRequest(m RequestMiddleware) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.request = append(ms.request, m)
+}
+
+// AddResponse appends a response middleware.
+func (ms *Middlewares) AddResponse(m ResponseMiddleware) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.response = append(ms.response, m)
+}
+
+// HasRequest reports whether any request middleware is registered.
+func (ms *Middlewares) HasRequest() bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return len(ms.request) > 0
+}
+
+// Reset removes all middlewares.
+func (ms *Middlewares) Reset() {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.request, ms.response = nil, nil
+}
+
+// TransformRequest runs the request middlewares on req in order, stopping at the first error.
+func (ms *Middlewares) TransformRequest(ctx context.Context, req *anthropic.GenerateMessageRequest) error {
+	ms.mu.RLock()
+	middlewares := ms.request
+	ms.mu.RUnlock()
+	for _, m := range middlewares {
+		if err := m.TransformRequest(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WrapStream runs the response middlewares on every event of stream. Without response middlewares
+// stream is returned as is.
+func (ms *Middlewares) WrapStream(ctx context.Context, stream anthropic.MessageStream) anthropic.MessageStream {
+	ms.mu.RLock()
+	middlewares := ms.response
+	ms.mu.RUnlock()
+	if len(middlewares) == 0 {
+		return stream
+	}
+	return func(yield func(anthropic.Event, error) bool) {
+		for event, err := range stream {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			for _, m := range middlewares {
+				if event, err = m.TransformEvent(ctx, event); err != nil {
+					yield(nil, err)
+					return
+				}
+				if event == nil {
+					break
+				}
+			}
+			if event != nil && !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
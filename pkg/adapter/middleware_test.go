@@ -0,0 +1,109 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestMiddlewares_TransformRequest(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) RequestMiddleware {
+		return RequestMiddlewareFunc(func(_ context.Context, req *anthropic.GenerateMessageRequest) error {
+			calls = append(calls, name)
+			req.Model += "+" + name
+			return err
+		})
+	}
+	errRejected := errors.New("rejected")
+	var ms Middlewares
+	ms.AddRequest(NopMiddleware{})
+	ms.AddRequest(record("first", nil))
+	ms.AddRequest(record("second", errRejected))
+	ms.AddRequest(record("third", nil))
+
+	req := &anthropic.GenerateMessageRequest{Model: "m"}
+	if err := ms.TransformRequest(context.Background(), req); !errors.Is(err, errRejected) {
+		t.Errorf("TransformRequest error = %v, want %v", err, errRejected)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("middlewares called = %v, want %v", calls, want)
+	}
+	if req.Model != "m+first+second" {
+		t.Errorf("model = %q", req.Model)
+	}
+}
+
+func TestMiddlewares_WrapStream(t *testing.T) {
+	events := []anthropic.Event{
+		&anthropic.EventMessageStart{Type: anthropic.EventTypeMessageStart},
+		&anthropic.EventPing{Type: anthropic.EventTypePing},
+		&anthropic.EventContentBlockDelta{Type: anthropic.EventTypeContentBlockDelta, Delta: &anthropic.MessageContentDelta{Text: "secret"}},
+		&anthropic.EventMessageStop{Type: anthropic.EventTypeMessageStop},
+	}
+	stream := func(yield func(anthropic.Event, error) bool) {
+		for _, event := range events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+
+	var ms Middlewares
+	if got := ms.WrapStream(context.Background(), stream); reflect.ValueOf(got).Pointer() != reflect.ValueOf(anthropic.MessageStream(stream)).Pointer() {
+		t.Error("a stream without response middlewares should be returned as is")
+	}
+	ms.AddResponse(NopMiddleware{})
+	ms.AddResponse(ResponseMiddlewareFunc(func(_ context.Context, event anthropic.Event) (anthropic.Event, error) {
+		if event.EventType() == anthropic.EventTypePing {
+			return nil, nil
+		}
+		if delta, ok := event.(*anthropic.EventContentBlockDelta); ok {
+			delta.Delta.Text = "[scrubbed]"
+		}
+		return event, nil
+	}))
+	ms.AddResponse(ResponseMiddlewareFunc(func(_ context.Context, event anthropic.Event) (anthropic.Event, error) {
+		if event.EventType() == anthropic.EventTypePing {
+			t.Error("a dropped event reached a later middleware")
+		}
+		if delta, ok := event.(*anthropic.EventContentBlockDelta); ok && delta.Delta.Text != "[scrubbed]" {
+			t.Errorf("middlewares ran out of order: %q", delta.Delta.Text)
+		}
+		return event, nil
+	}))
+	var types []anthropic.EventType
+	for event, err := range ms.WrapStream(context.Background(), stream) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		types = append(types, event.EventType())
+	}
+	want := []anthropic.EventType{anthropic.EventTypeMessageStart, anthropic.EventTypeContentBlockDelta, anthropic.EventTypeMessageStop}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("event types = %v, want %v", types, want)
+	}
+
+	errBlocked := errors.New("blocked")
+	ms.AddResponse(ResponseMiddlewareFunc(func(_ context.Context, event anthropic.Event) (anthropic.Event, error) {
+		if event.EventType() == anthropic.EventTypeContentBlockDelta {
+			return nil, errBlocked
+		}
+		return event, nil
+	}))
+	types = nil
+	var gotErr error
+	for event, err := range ms.WrapStream(context.Background(), stream) {
+		if err != nil {
+			gotErr = err
+			continue
+		}
+		types = append(types, event.EventType())
+	}
+	if !errors.Is(gotErr, errBlocked) || !reflect.DeepEqual(types, want[:1]) {
+		t.Errorf("events = %v, error = %v; want the stream to end with the middleware error", types, gotErr)
+	}
+}
@@ -0,0 +1,278 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// strictUnsupportedSchemaKeywords lists JSON Schema keywords rejected by OpenAI-compatible
+// strict function calling.
+var strictUnsupportedSchemaKeywords = []string{
+	"$schema",
+	"$id",
+	"$comment",
+	"default",
+	"examples",
+	"format",
+	"pattern",
+	"minimum",
+	"maximum",
+	"exclusiveMinimum",
+	"exclusiveMaximum",
+	"multipleOf",
+	"minLength",
+	"maxLength",
+	"minItems",
+	"maxItems",
+	"uniqueItems",
+	"minProperties",
+	"maxProperties",
+	"patternProperties",
+	"propertyNames",
+	"unevaluatedProperties",
+	"dependentRequired",
+	"dependentSchemas",
+	"contentEncoding",
+	"contentMediaType",
+	"readOnly",
+	"writeOnly",
+	"deprecated",
+}
+
+// SanitizeStrictJSONSchema rewrites a tool input schema so that it is accepted by strict
+// function calling: unsupported keywords are removed recursively, every object gets
+// "additionalProperties": false, and all properties are listed in "required", with
+// previously optional properties made nullable instead. A schema that is not a JSON
+// object is returned unchanged.
+func SanitizeStrictJSONSchema(schema []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(schema))
+	decoder.UseNumber()
+	var root map[string]any
+	if err := decoder.Decode(&root); err != nil || root == nil {
+		return schema
+	}
+	sanitizeStrictSchemaNode(root)
+	sanitized, err := json.Marshal(root)
+	if err != nil {
+		return schema
+	}
+	return sanitized
+}
+
+func sanitizeStrictSchemaNode(node map[string]any) {
+	for _, keyword := range strictUnsupportedSchemaKeywords {
+		delete(node, keyword)
+	}
+	for _, keyword := range []string{"items", "not"} {
+		if child, ok := node[keyword].(map[string]any); ok {
+			sanitizeStrictSchemaNode(child)
+		}
+	}
+	for _, keyword := range []string{"anyOf", "allOf", "oneOf", "prefixItems"} {
+		if children, ok := node[keyword].([]any); ok {
+			for _, child := range children {
+				if child, ok := child.(map[string]any); ok {
+					sanitizeStrictSchemaNode(child)
+				}
+			}
+		}
+	}
+	for _, keyword := range []string{"$defs", "definitions"} {
+		if defs, ok := node[keyword].(map[string]any); ok {
+			for _, def := range defs {
+				if def, ok := def.(map[string]any); ok {
+					sanitizeStrictSchemaNode(def)
+				}
+			}
+		}
+	}
+	properties, hasProperties := node["properties"].(map[string]any)
+	if !hasProperties && !isStrictSchemaType(node, "object") {
+		return
+	}
+	node["additionalProperties"] = false
+	if !hasProperties {
+		return
+	}
+	var required []string
+	if values, ok := node["required"].([]any); ok {
+		for _, value := range values {
+			if name, ok := value.(string); ok {
+				required = append(required, name)
+			}
+		}
+	}
+	names := make([]string, 0, len(properties))
+	for name, property := range properties {
+		names = append(names, name)
+		property, ok := property.(map[string]any)
+		if !ok {
+			continue
+		}
+		sanitizeStrictSchemaNode(property)
+		if !slices.Contains(required, name) {
+			makeStrictSchemaNullable(property)
+		}
+	}
+	slices.Sort(names)
+	node["required"] = names
+}
+
+func isStrictSchemaType(node map[string]any, want string) bool {
+	switch schemaType := node["type"].(type) {
+	case string:
+		return schemaType == want
+	case []any:
+		return slices.Contains(schemaType, any(want))
+	}
+	return false
+}
+
+// makeStrictSchemaNullable lets an optional property be sent as null, since strict mode
+// requires every property to be present.
+func makeStrictSchemaNullable(node map[string]any) {
+	if enum, ok := node["enum"].([]any); ok && !slices.Contains(enum, nil) {
+		node["enum"] = append(enum, nil)
+	}
+	switch schemaType := node["type"].(type) {
+	case string:
+		if schemaType != "null" {
+			node["type"] = []any{schemaType, "null"}
+		}
+	case []any:
+		if !slices.Contains(schemaType, any("null")) {
+			node["type"] = append(schemaType, "null")
+		}
+	default:
+		if anyOf, ok := node["anyOf"].([]any); ok {
+			node["anyOf"] = append(anyOf, map[string]any{"type": "null"})
+		}
+	}
+}
+
+// InlineJSONSchemaRefs replaces the local "$ref"s of a tool input schema, such as
+// "#/$defs/Address" or "#/definitions/Address", with a copy of the schema they point to, since
+// strict function calling does not resolve them. Keywords next to a "$ref", such as a
+// description, are kept over those of the referenced schema. Recursive references cannot be
+// inlined and are left as they are, in which case "$defs" and "definitions" are kept too;
+// otherwise they are removed. A schema that is not a JSON object, or has no "$ref", is returned
+// unchanged.
+func InlineJSONSchemaRefs(schema []byte) []byte {
+	if !bytes.Contains(schema, []byte(`"$ref"`)) {
+		return schema
+	}
+	decoder := json.NewDecoder(bytes.NewReader(schema))
+	decoder.UseNumber()
+	var root map[string]any
+	if err := decoder.Decode(&root); err != nil || root == nil {
+		return schema
+	}
+	// The definitions are only inlined where they are referenced, and kept as they are otherwise.
+	body := make(map[string]any, len(root))
+	for key, value := range root {
+		if key != "$defs" && key != "definitions" {
+			body[key] = value
+		}
+	}
+	inliner := &schemaRefInliner{root: root}
+	inlined, _ := inliner.inline(body, nil).(map[string]any)
+	if inliner.keepDefs {
+		for _, keyword := range []string{"$defs", "definitions"} {
+			if defs, ok := root[keyword]; ok {
+				inlined[keyword] = defs
+			}
+		}
+	}
+	result, err := json.Marshal(inlined)
+	if err != nil {
+		return schema
+	}
+	return result
+}
+
+type schemaRefInliner struct {
+	root map[string]any
+	// keepDefs reports whether a "$ref" was left in the schema, which still needs the definitions.
+	keepDefs bool
+}
+
+// inline returns a copy of node with its references inlined; expanding holds the references
+// being inlined, to detect recursive ones.
+func (inliner *schemaRefInliner) inline(node any, expanding []string) any {
+	switch node := node.(type) {
+	case map[string]any:
+		if ref, ok := node["$ref"].(string); ok {
+			target, resolved := resolveSchemaRef(inliner.root, ref)
+			if !resolved || slices.Contains(expanding, ref) {
+				inliner.keepDefs = true
+				return copySchemaNode(node)
+			}
+			inlined, ok := inliner.inline(target, append(slices.Clip(expanding), ref)).(map[string]any)
+			if !ok {
+				inliner.keepDefs = true
+				return copySchemaNode(node)
+			}
+			for key, value := range node {
+				if key != "$ref" {
+					inlined[key] = inliner.inline(value, expanding)
+				}
+			}
+			return inlined
+		}
+		copied := make(map[string]any, len(node))
+		for key, value := range node {
+			copied[key] = inliner.inline(value, expanding)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(node))
+		for i, value := range node {
+			copied[i] = inliner.inline(value, expanding)
+		}
+		return copied
+	}
+	return node
+}
+
+// resolveSchemaRef resolves a local reference, a JSON pointer into root such as "#/$defs/Address".
+func resolveSchemaRef(root map[string]any, ref string) (any, bool) {
+	if ref == "#" {
+		return root, true
+	}
+	pointer, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, false
+	}
+	var node any = root
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if node, ok = object[token]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func copySchemaNode(node any) any {
+	switch node := node.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(node))
+		for key, value := range node {
+			copied[key] = copySchemaNode(value)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(node))
+		for i, value := range node {
+			copied[i] = copySchemaNode(value)
+		}
+		return copied
+	}
+	return node
+}
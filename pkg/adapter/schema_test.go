@@ -0,0 +1,247 @@
+package adapter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+const strictSchemaTestInput = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"type": "object",
+	"additionalProperties": true,
+	"properties": {
+		"path": {"type": "string", "format": "uri", "default": "/tmp"},
+		"limit": {"type": "integer", "minimum": 1, "maximum": 100},
+		"mode": {"type": "string", "enum": ["read", "write"]},
+		"options": {
+			"type": "object",
+			"properties": {
+				"format": {"type": "string", "pattern": "^[a-z]+$"},
+				"tags": {"type": "array", "items": {"type": "string", "minLength": 1}, "maxItems": 5}
+			},
+			"required": ["format"]
+		}
+	},
+	"required": ["path", "options"]
+}`
+
+const strictSchemaTestWant = `{
+	"type": "object",
+	"additionalProperties": false,
+	"properties": {
+		"path": {"type": "string"},
+		"limit": {"type": ["integer", "null"]},
+		"mode": {"type": ["string", "null"], "enum": ["read", "write", null]},
+		"options": {
+			"type": "object",
+			"additionalProperties": false,
+			"properties": {
+				"format": {"type": "string"},
+				"tags": {"type": ["array", "null"], "items": {"type": "string"}}
+			},
+			"required": ["format", "tags"]
+		}
+	},
+	"required": ["limit", "mode", "options", "path"]
+}`
+
+func assertJSONEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("invalid expected JSON: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+}
+
+func TestSanitizeStrictJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			name:   "nested schema with disallowed keywords",
+			schema: strictSchemaTestInput,
+			want:   strictSchemaTestWant,
+		},
+		{
+			name:   "object without properties",
+			schema: `{"type": "object"}`,
+			want:   `{"type": "object", "additionalProperties": false}`,
+		},
+		{
+			name:   "definitions and anyOf are sanitized",
+			schema: `{"type": "object", "properties": {"v": {"anyOf": [{"$ref": "#/$defs/n"}, {"type": "string", "format": "date"}]}}, "required": ["v"], "$defs": {"n": {"type": "number", "multipleOf": 2}}}`,
+			want:   `{"type": "object", "additionalProperties": false, "properties": {"v": {"anyOf": [{"$ref": "#/$defs/n"}, {"type": "string"}]}}, "required": ["v"], "$defs": {"n": {"type": "number"}}}`,
+		},
+		{
+			name:   "optional anyOf property becomes nullable",
+			schema: `{"type": "object", "properties": {"v": {"anyOf": [{"type": "string"}, {"type": "number"}]}}}`,
+			want:   `{"type": "object", "additionalProperties": false, "properties": {"v": {"anyOf": [{"type": "string"}, {"type": "number"}, {"type": "null"}]}}, "required": ["v"]}`,
+		},
+		{
+			name:   "large numbers are preserved",
+			schema: `{"type": "object", "properties": {"n": {"type": "integer", "enum": [9007199254740993]}}, "required": ["n"]}`,
+			want:   `{"type": "object", "additionalProperties": false, "properties": {"n": {"type": "integer", "enum": [9007199254740993]}}, "required": ["n"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertJSONEqual(t, SanitizeStrictJSONSchema([]byte(tt.schema)), tt.want)
+		})
+	}
+}
+
+func TestSanitizeStrictJSONSchema_InvalidSchemaUnchanged(t *testing.T) {
+	for _, schema := range []string{``, `null`, `[1, 2]`, `{"type":`} {
+		if got := SanitizeStrictJSONSchema([]byte(schema)); string(got) != schema {
+			t.Errorf("SanitizeStrictJSONSchema(%q) = %q, want unchanged", schema, got)
+		}
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_StrictSchemaSanitize(t *testing.T) {
+	newRequest := func() *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "claude-3-5-sonnet-20241022",
+			MaxTokens: 100,
+			Messages: []*anthropic.Message{
+				{
+					Role:    anthropic.MessageRoleUser,
+					Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hello"}},
+				},
+			},
+			Tools: []*anthropic.Tool{
+				{Name: "read_file", InputSchema: json.RawMessage(strictSchemaTestInput)},
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		strict   bool
+		sanitize bool
+		want     string
+	}{
+		{name: "strict with sanitize", strict: true, sanitize: true, want: strictSchemaTestWant},
+		{name: "strict without sanitize", strict: true, sanitize: false, want: strictSchemaTestInput},
+		{name: "sanitize without strict", strict: false, sanitize: true, want: strictSchemaTestInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.Strict = tt.strict
+				p.Options.StrictSchemaSanitize = tt.sanitize
+			})
+			dst := convertToOpenRouter(t, ctx, newRequest())
+			if len(dst.Tools) != 1 {
+				t.Fatalf("expected 1 tool, got %d", len(dst.Tools))
+			}
+			if dst.Tools[0].Function.Strict != tt.strict {
+				t.Errorf("Strict = %v, want %v", dst.Tools[0].Function.Strict, tt.strict)
+			}
+			assertJSONEqual(t, dst.Tools[0].Function.Parameters, tt.want)
+		})
+	}
+}
+
+func TestInlineJSONSchemaRefs(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{
+			name: "$defs",
+			schema: `{
+				"type": "object",
+				"properties": {
+					"home": {"$ref": "#/$defs/Address", "description": "Home address"},
+					"work": {"$ref": "#/$defs/Address"},
+					"contacts": {"type": "array", "items": {"$ref": "#/$defs/Contact"}}
+				},
+				"$defs": {
+					"Address": {"type": "object", "properties": {"city": {"type": "string"}}},
+					"Contact": {"type": "object", "properties": {"address": {"$ref": "#/$defs/Address"}}}
+				}
+			}`,
+			want: `{
+				"type": "object",
+				"properties": {
+					"home": {"type": "object", "properties": {"city": {"type": "string"}}, "description": "Home address"},
+					"work": {"type": "object", "properties": {"city": {"type": "string"}}},
+					"contacts": {"type": "array", "items": {"type": "object", "properties": {"address": {"type": "object", "properties": {"city": {"type": "string"}}}}}}
+				}
+			}`,
+		},
+		{
+			name:   "definitions",
+			schema: `{"type": "object", "properties": {"id": {"$ref": "#/definitions/ID"}}, "definitions": {"ID": {"type": "string"}}}`,
+			want:   `{"type": "object", "properties": {"id": {"type": "string"}}}`,
+		},
+		{
+			name:   "recursive reference kept",
+			schema: `{"type": "object", "properties": {"root": {"$ref": "#/$defs/Node"}}, "$defs": {"Node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/Node"}}}}}`,
+			want:   `{"type": "object", "properties": {"root": {"type": "object", "properties": {"child": {"$ref": "#/$defs/Node"}}}}, "$defs": {"Node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/Node"}}}}}`,
+		},
+		{
+			name:   "unresolvable reference kept",
+			schema: `{"type": "object", "properties": {"id": {"$ref": "https://example.com/id.json"}}}`,
+			want:   `{"type": "object", "properties": {"id": {"$ref": "https://example.com/id.json"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertJSONEqual(t, InlineJSONSchemaRefs([]byte(tt.schema)), tt.want)
+		})
+	}
+	for _, schema := range []string{`{"type": "object"}`, `{"$ref":`} {
+		if got := InlineJSONSchemaRefs([]byte(schema)); string(got) != schema {
+			t.Errorf("InlineJSONSchemaRefs(%q) = %q, want unchanged", schema, got)
+		}
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_StrictSchemaRefs(t *testing.T) {
+	schema := `{"type": "object", "properties": {"address": {"$ref": "#/$defs/Address"}}, "required": ["address"], "$defs": {"Address": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}}`
+	for _, tt := range []struct {
+		name   string
+		strict bool
+		want   string
+	}{
+		{
+			name:   "strict",
+			strict: true,
+			want:   `{"type": "object", "additionalProperties": false, "properties": {"address": {"type": "object", "additionalProperties": false, "properties": {"city": {"type": "string"}}, "required": ["city"]}}, "required": ["address"]}`,
+		},
+		{name: "not strict", strict: false, want: schema},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testCtxWithOptions(func(p *profile.Profile) {
+				p.Options.Strict = tt.strict
+				p.Options.StrictSchemaSanitize = true
+			})
+			dst := convertToOpenRouter(t, ctx, &anthropic.GenerateMessageRequest{
+				Model:     "claude-3-5-sonnet-20241022",
+				MaxTokens: 100,
+				Messages: []*anthropic.Message{
+					{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hello"}}},
+				},
+				Tools: []*anthropic.Tool{{Name: "save_address", InputSchema: json.RawMessage(schema)}},
+			})
+			if len(dst.Tools) != 1 {
+				t.Fatalf("expected 1 tool, got %d", len(dst.Tools))
+			}
+			assertJSONEqual(t, dst.Tools[0].Function.Parameters, tt.want)
+		})
+	}
+}
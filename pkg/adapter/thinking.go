@@ -0,0 +1,50 @@
+package adapter
+
+import (
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+// DropThinkingBlocks removes thinking and redacted_thinking content blocks from stream. The blocks
+// that remain are renumbered so that their indexes stay contiguous, as clients expect.
+func DropThinkingBlocks(stream anthropic.MessageStream) anthropic.MessageStream {
+	return func(yield func(anthropic.Event, error) bool) {
+		var (
+			dropped = map[int]struct{}{}
+			indexes = map[int]int{}
+		)
+		for event, err := range stream {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			switch e := event.(type) {
+			case *anthropic.EventContentBlockStart:
+				if block := e.ContentBlock; block != nil && (block.Type == anthropic.MessageContentTypeThinking ||
+					block.Type == anthropic.MessageContentTypeRedactedThinking) {
+					dropped[e.Index] = struct{}{}
+					continue
+				}
+				index := len(indexes)
+				indexes[e.Index] = index
+				e.Index = index
+			case *anthropic.EventContentBlockDelta:
+				if _, isDropped := dropped[e.Index]; isDropped {
+					continue
+				}
+				if index, ok := indexes[e.Index]; ok {
+					e.Index = index
+				}
+			case *anthropic.EventContentBlockStop:
+				if _, isDropped := dropped[e.Index]; isDropped {
+					continue
+				}
+				if index, ok := indexes[e.Index]; ok {
+					e.Index = index
+				}
+			}
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package adapter
+
+import (
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestDropThinkingBlocks(t *testing.T) {
+	events := []anthropic.Event{
+		&anthropic.EventMessageStart{Type: anthropic.EventTypeMessageStart, Message: &anthropic.Message{Role: anthropic.MessageRoleAssistant, Usage: &anthropic.Usage{}}},
+		&anthropic.EventContentBlockStart{Type: anthropic.EventTypeContentBlockStart, Index: 0, ContentBlock: &anthropic.MessageContent{Type: anthropic.MessageContentTypeThinking}},
+		&anthropic.EventContentBlockDelta{Type: anthropic.EventTypeContentBlockDelta, Index: 0, Delta: &anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeThinkingDelta, Thinking: "hmm"}},
+		&anthropic.EventContentBlockStop{Type: anthropic.EventTypeContentBlockStop, Index: 0},
+		&anthropic.EventContentBlockStart{Type: anthropic.EventTypeContentBlockStart, Index: 1, ContentBlock: &anthropic.MessageContent{Type: anthropic.MessageContentTypeText}},
+		&anthropic.EventContentBlockDelta{Type: anthropic.EventTypeContentBlockDelta, Index: 1, Delta: &anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeTextDelta, Text: "hi"}},
+		&anthropic.EventContentBlockStop{Type: anthropic.EventTypeContentBlockStop, Index: 1},
+		&anthropic.EventContentBlockStart{Type: anthropic.EventTypeContentBlockStart, Index: 2, ContentBlock: &anthropic.MessageContent{Type: anthropic.MessageContentTypeRedactedThinking, Data: "secret"}},
+		&anthropic.EventContentBlockStop{Type: anthropic.EventTypeContentBlockStop, Index: 2},
+		&anthropic.EventContentBlockStart{Type: anthropic.EventTypeContentBlockStart, Index: 3, ContentBlock: &anthropic.MessageContent{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "f"}},
+		&anthropic.EventContentBlockStop{Type: anthropic.EventTypeContentBlockStop, Index: 3},
+		&anthropic.EventMessageStop{Type: anthropic.EventTypeMessageStop},
+	}
+	stream := func(yield func(anthropic.Event, error) bool) {
+		for _, event := range events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+	got, err := collectAnthropicEvents(t, DropThinkingBlocks(stream))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	for _, event := range got {
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("the filtered stream is not a valid message: %v", err)
+		}
+	}
+	content := builder.Message().Content
+	if len(content) != 2 || content[0].Type != anthropic.MessageContentTypeText || content[0].Text != "hi" ||
+		content[1].Type != anthropic.MessageContentTypeToolUse {
+		t.Errorf("content = %+v", content)
+	}
+	if len(got) != 7 {
+		t.Errorf("got %d events, want 7", len(got))
+	}
+}
@@ -0,0 +1,102 @@
+package adapter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+)
+
+// MaxToolNameLength is the longest function name OpenAI-compatible upstreams accept.
+const MaxToolNameLength = 64
+
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// ToolNames records the tool names rewritten to match ^[a-zA-Z0-9_-]{1,64}$, the function names
+// OpenAI-compatible upstreams accept, so that tool calls in the response are restored to the
+// names the client knows. MCP tools, for example, are often named after dotted identifiers.
+//
+// The zero value is ready to use, and a nil *ToolNames leaves every name as it is.
+type ToolNames struct {
+	upstream map[string]string // client name -> upstream name
+	original map[string]string // upstream name -> client name
+}
+
+// Upstream returns the name the tool called name is sent upstream with. Names are rewritten
+// consistently, and two names never share an upstream name.
+func (n *ToolNames) Upstream(name string) string {
+	if n == nil {
+		return name
+	}
+	if upstream, ok := n.upstream[name]; ok {
+		return upstream
+	}
+	if n.upstream == nil {
+		n.upstream = make(map[string]string)
+		n.original = make(map[string]string)
+	}
+	upstream := invalidToolNameChars.ReplaceAllString(name, "_")
+	if _, taken := n.original[upstream]; taken || upstream == "" || len(upstream) > MaxToolNameLength {
+		// "mcp.read" and "mcp_read" would both become "mcp_read", so a hash of the client name
+		// tells them apart; it also keeps truncated names unique.
+		sum := sha256.Sum256([]byte(name))
+		upstream = upstream[:min(len(upstream), MaxToolNameLength-9)] + "_" + hex.EncodeToString(sum[:4])
+	}
+	n.upstream[name] = upstream
+	n.original[upstream] = name
+	return upstream
+}
+
+// Original returns the name the client knows the tool sent upstream as name by.
+func (n *ToolNames) Original(name string) string {
+	if n == nil {
+		return name
+	}
+	if original, ok := n.original[name]; ok {
+		return original
+	}
+	return name
+}
+
+// renameOpenRouterToolNames rewrites the tool names of a converted request: the declared tools,
+// the tool forced by tool_choice, and the tool calls of previous assistant turns.
+func renameOpenRouterToolNames(dst *openrouter.CreateChatCompletionRequest, names *ToolNames) {
+	for _, tool := range dst.Tools {
+		if tool.Function != nil {
+			tool.Function.Name = names.Upstream(tool.Function.Name)
+		}
+	}
+	if toolChoice := dst.ToolChoice; toolChoice != nil && toolChoice.Tool != nil && toolChoice.Tool.Function != nil {
+		toolChoice.Tool.Function.Name = names.Upstream(toolChoice.Tool.Function.Name)
+	}
+	for _, message := range dst.Messages {
+		for _, toolCall := range message.ToolCalls {
+			if toolCall.Function != nil {
+				toolCall.Function.Name = names.Upstream(toolCall.Function.Name)
+			}
+		}
+	}
+}
+
+// renameOpenAIToolNames is renameOpenRouterToolNames for Responses API requests, whose tool calls
+// are function_call input items.
+func renameOpenAIToolNames(dst *openai.CreateModelResponseRequest, names *ToolNames) {
+	for _, tool := range dst.Tools {
+		tool.Name = names.Upstream(tool.Name)
+	}
+	if toolChoice := dst.ToolChoice; toolChoice != nil {
+		if toolChoice.Name != "" {
+			toolChoice.Name = names.Upstream(toolChoice.Name)
+		}
+		for _, tool := range toolChoice.Tools {
+			tool.Name = names.Upstream(tool.Name)
+		}
+	}
+	for _, item := range dst.Input {
+		if item.Type == openai.ResponseInputItemTypeFunctionCall {
+			item.Name = names.Upstream(item.Name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package adapter
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+func TestToolNames(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	names := &ToolNames{}
+	for _, name := range []string{"read_file", "mcp__server__do.thing", "mcp__server__do_thing", "a/b", "", strings.Repeat("x", 100)} {
+		upstream := names.Upstream(name)
+		if !valid.MatchString(upstream) {
+			t.Errorf("Upstream(%q) = %q, which upstreams reject", name, upstream)
+		}
+		if upstream != names.Upstream(name) {
+			t.Errorf("Upstream(%q) is not stable", name)
+		}
+		if original := names.Original(upstream); original != name {
+			t.Errorf("Original(%q) = %q, want %q", upstream, original, name)
+		}
+	}
+	if got := names.Upstream("read_file"); got != "read_file" {
+		t.Errorf("valid names should be kept, got %q", got)
+	}
+	if got := names.Upstream("mcp__server__do.thing"); got != "mcp__server__do_thing" {
+		t.Errorf("Upstream(mcp__server__do.thing) = %q", got)
+	}
+	if names.Upstream("mcp__server__do_thing") == "mcp__server__do_thing" {
+		t.Error("a name colliding with a rewritten one must be told apart")
+	}
+	if got := names.Original("unknown"); got != "unknown" {
+		t.Errorf("Original(unknown) = %q", got)
+	}
+	var nilNames *ToolNames
+	if nilNames.Upstream("a.b") != "a.b" || nilNames.Original("a_b") != "a_b" {
+		t.Error("a nil ToolNames should leave names unchanged")
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_SanitizeToolNames(t *testing.T) {
+	const name = "mcp__server__do.thing"
+	req := &anthropic.GenerateMessageRequest{
+		Model:      "claude-3-5-sonnet-20241022",
+		MaxTokens:  500,
+		Tools:      []*anthropic.Tool{{Name: name, InputSchema: []byte(`{"type":"object"}`)}},
+		ToolChoice: &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeTool, Name: name},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "go"}}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: name, Input: []byte(`{}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "done"}}},
+			}},
+		},
+	}
+	toolCallNames := func(dst *openrouter.CreateChatCompletionRequest) (names []string) {
+		for _, message := range dst.Messages {
+			for _, toolCall := range message.ToolCalls {
+				names = append(names, toolCall.Function.Name)
+			}
+		}
+		return names
+	}
+
+	t.Run("rewritten", func(t *testing.T) {
+		ctx := testCtxWithOptions(func(p *profile.Profile) { p.Options.SanitizeToolNames = true })
+		names := &ToolNames{}
+		dst := convertToOpenRouter(t, ctx, req, RecordToolNames(names))
+		const want = "mcp__server__do_thing"
+		if got := dst.Tools[0].Function.Name; got != want {
+			t.Errorf("tool name = %q, want %q", got, want)
+		}
+		if got := dst.ToolChoice.Tool.Function.Name; got != want {
+			t.Errorf("tool_choice name = %q, want %q", got, want)
+		}
+		if got := toolCallNames(dst); len(got) != 1 || got[0] != want {
+			t.Errorf("tool_calls names = %v, want [%s]", got, want)
+		}
+		if got := req.Tools[0].Name; got != name {
+			t.Errorf("the source request was modified: %q", got)
+		}
+
+		chunks := []*openrouter.ChatCompletionChunk{
+			{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{Delta: &openrouter.ChatCompletionChunkChoiceDelta{
+				ToolCalls: []*openrouter.ChatCompletionToolCall{{ID: "call_1", Function: &openrouter.ChatCompletionMessageToolCallFunction{Name: want, Arguments: `{}`}}},
+			}}}},
+			{ID: "chatcmpl-1", Model: "m", Choices: []*openrouter.ChatCompletionChunkChoice{{FinishReason: openrouter.ChatCompletionFinishReasonToolCalls}}},
+		}
+		events, err := collectAnthropicEvents(t, ConvertOpenRouterStreamToAnthropicStream(streamTestCtx(), createMockStream(chunks, nil), RestoreToolNames(names)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var restored []string
+		for _, event := range events {
+			if start, ok := event.(*anthropic.EventContentBlockStart); ok {
+				restored = append(restored, start.ContentBlock.Name)
+			}
+		}
+		if len(restored) != 1 || restored[0] != name {
+			t.Errorf("tool_use names = %v, want [%s]", restored, name)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		dst := convertToOpenRouter(t, testCtx(), req)
+		if got := dst.Tools[0].Function.Name; got != name {
+			t.Errorf("tool name = %q, want %q", got, name)
+		}
+		if got := toolCallNames(dst); len(got) != 1 || got[0] != name {
+			t.Errorf("tool_calls names = %v", got)
+		}
+	})
+}
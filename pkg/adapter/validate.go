@@ -0,0 +1,97 @@
+package adapter
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+// ContentPartTooLargeError reports a text content part whose size exceeds the
+// profile's max_content_part_bytes limit. NestedPartIndex is the index of the part within
+// the tool_result block at PartIndex, or -1 when the part is not nested.
+type ContentPartTooLargeError struct {
+	MessageIndex    int
+	PartIndex       int
+	NestedPartIndex int
+	Size            int
+	Limit           int
+}
+
+func (e *ContentPartTooLargeError) Error() string {
+	path := fmt.Sprintf("messages.%d.content.%d", e.MessageIndex, e.PartIndex)
+	if e.NestedPartIndex >= 0 {
+		path += fmt.Sprintf(".content.%d", e.NestedPartIndex)
+	}
+	return fmt.Sprintf("%s: text content is %d bytes, which exceeds the limit of %d bytes", path, e.Size, e.Limit)
+}
+
+// ValidateContentPartSizes checks that no text content part in src is larger than limit bytes,
+// including the text parts nested inside tool_result blocks. Image data is not checked here.
+// A non-positive limit disables the check.
+func ValidateContentPartSizes(src *anthropic.GenerateMessageRequest, limit int) error {
+	if src == nil || limit <= 0 {
+		return nil
+	}
+	for messageIndex, message := range src.Messages {
+		if message == nil {
+			continue
+		}
+		for partIndex, content := range message.Content {
+			if content == nil {
+				continue
+			}
+			switch content.Type {
+			case anthropic.MessageContentTypeText:
+				if size := len(content.Text); size > limit {
+					return &ContentPartTooLargeError{
+						MessageIndex:    messageIndex,
+						PartIndex:       partIndex,
+						NestedPartIndex: -1,
+						Size:            size,
+						Limit:           limit,
+					}
+				}
+			case anthropic.MessageContentTypeToolResult:
+				for nestedPartIndex, part := range content.Content {
+					if part == nil || part.Type != anthropic.MessageContentTypeText {
+						continue
+					}
+					if size := len(part.Text); size > limit {
+						return &ContentPartTooLargeError{
+							MessageIndex:    messageIndex,
+							PartIndex:       partIndex,
+							NestedPartIndex: nestedPartIndex,
+							Size:            size,
+							Limit:           limit,
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveAnthropicVersion checks the anthropic-version header of a client request. A missing
+// version is set to configured, the version of the matched profile. Any other version must be
+// one of anthropic.Versions or configured itself, so that an unknown version is rejected with a
+// clear error instead of being forwarded to an upstream with undefined results.
+func ResolveAnthropicVersion(header http.Header, configured string) error {
+	version := strings.TrimSpace(header.Get(anthropic.HeaderVersion))
+	if version == "" {
+		header.Set(anthropic.HeaderVersion, configured)
+		return nil
+	}
+	if version == configured || slices.Contains(anthropic.Versions, version) {
+		return nil
+	}
+	supported := anthropic.Versions
+	if !slices.Contains(supported, configured) {
+		supported = append([]string{configured}, supported...)
+	}
+	return fmt.Errorf("%s: unsupported version %q, supported versions are %s",
+		anthropic.HeaderVersion, version, strings.Join(supported, ", "))
+}
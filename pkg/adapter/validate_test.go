@@ -0,0 +1,178 @@
+package adapter
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+func contentPartSizeTestRequest(text string) *anthropic.GenerateMessageRequest {
+	return &anthropic.GenerateMessageRequest{
+		Model:     "claude-3-5-sonnet-20241022",
+		MaxTokens: 100,
+		Messages: []*anthropic.Message{
+			{
+				Role:    anthropic.MessageRoleUser,
+				Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hello"}},
+			},
+			{
+				Role:    anthropic.MessageRoleAssistant,
+				Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}},
+			},
+			{
+				Role: anthropic.MessageRoleUser,
+				Content: anthropic.MessageContents{
+					{Type: anthropic.MessageContentTypeText, Text: "short"},
+					{Type: anthropic.MessageContentTypeText, Text: text},
+				},
+			},
+		},
+	}
+}
+
+func TestValidateContentPartSizes(t *testing.T) {
+	const limit = 16
+	tests := []struct {
+		name    string
+		src     *anthropic.GenerateMessageRequest
+		wantErr *ContentPartTooLargeError
+	}{
+		{
+			name: "text part just under the limit passes",
+			src:  contentPartSizeTestRequest(strings.Repeat("a", limit-1)),
+		},
+		{
+			name: "text part exactly at the limit passes",
+			src:  contentPartSizeTestRequest(strings.Repeat("a", limit)),
+		},
+		{
+			name:    "oversized text part is rejected",
+			src:     contentPartSizeTestRequest(strings.Repeat("a", limit+1)),
+			wantErr: &ContentPartTooLargeError{MessageIndex: 2, PartIndex: 1, NestedPartIndex: -1, Size: limit + 1, Limit: limit},
+		},
+		{
+			name: "oversized tool_result text is rejected",
+			src: &anthropic.GenerateMessageRequest{
+				Messages: []*anthropic.Message{
+					{
+						Role: anthropic.MessageRoleUser,
+						Content: anthropic.MessageContents{
+							{
+								Type:      anthropic.MessageContentTypeToolResult,
+								ToolUseID: "toolu_1",
+								Content: anthropic.MessageContents{
+									{Type: anthropic.MessageContentTypeText, Text: "ok"},
+									{Type: anthropic.MessageContentTypeText, Text: strings.Repeat("b", limit*2)},
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: &ContentPartTooLargeError{MessageIndex: 0, PartIndex: 0, NestedPartIndex: 1, Size: limit * 2, Limit: limit},
+		},
+		{
+			name: "image data is excluded",
+			src: &anthropic.GenerateMessageRequest{
+				Messages: []*anthropic.Message{
+					{
+						Role: anthropic.MessageRoleUser,
+						Content: anthropic.MessageContents{
+							{
+								Type: anthropic.MessageContentTypeImage,
+								Source: &anthropic.MessageContentSource{
+									Type:      "base64",
+									MediaType: "image/png",
+									Data:      strings.Repeat("c", limit*4),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateContentPartSizes(tt.src, limit)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var got *ContentPartTooLargeError
+			if !errors.As(err, &got) {
+				t.Fatalf("expected *ContentPartTooLargeError, got %v", err)
+			}
+			if *got != *tt.wantErr {
+				t.Errorf("got %+v, want %+v", got, tt.wantErr)
+			}
+			if !strings.HasPrefix(err.Error(), "messages.") {
+				t.Errorf("expected the error to start with the part path, got %q", err.Error())
+			}
+		})
+	}
+}
+
+func TestValidateContentPartSizes_NonPositiveLimitDisablesCheck(t *testing.T) {
+	if err := ValidateContentPartSizes(contentPartSizeTestRequest(strings.Repeat("a", 1024)), 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestConvertAnthropicRequestToOpenRouterRequest_ContentPartTooLarge(t *testing.T) {
+	ctx := testCtxWithOptions(func(p *profile.Profile) {
+		p.Options.MaxContentPartBytes = 16
+	})
+	dst, err := ConvertAnthropicRequestToOpenRouterRequest(ctx, contentPartSizeTestRequest(strings.Repeat("a", 17)))
+	var tooLarge *ContentPartTooLargeError
+	if !errors.As(err, &tooLarge) || dst != nil {
+		t.Fatalf("got (%+v, %v), want a *ContentPartTooLargeError", dst, err)
+	}
+	if _, err = ConvertAnthropicRequestToOpenRouterRequest(ctx, contentPartSizeTestRequest(strings.Repeat("a", 16))); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestResolveAnthropicVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		configured string
+		want       string
+		wantErr    bool
+	}{
+		{name: "missing defaults to configured", configured: "2023-06-01", want: "2023-06-01"},
+		{name: "missing defaults to custom configured", configured: "2025-01-01", want: "2025-01-01"},
+		{name: "supported", version: "2023-06-01", configured: "2023-06-01", want: "2023-06-01"},
+		{name: "older supported", version: "2023-01-01", configured: "2023-06-01", want: "2023-01-01"},
+		{name: "configured", version: "2025-01-01", configured: "2025-01-01", want: "2025-01-01"},
+		{name: "unsupported", version: "2099-01-01", configured: "2023-06-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.version != "" {
+				header.Set(anthropic.HeaderVersion, tt.version)
+			}
+			err := ResolveAnthropicVersion(header, tt.configured)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), tt.version) || !strings.Contains(err.Error(), "2023-06-01") {
+					t.Fatalf("expected an error naming the version and the supported ones, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := header.Get(anthropic.HeaderVersion); got != tt.want {
+				t.Errorf("anthropic-version = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -21,14 +21,32 @@ func WithBetaFeatures(features ...string) func(*http.Request) {
 const (
 	BetaFeatureFineGrainedToolStreaming20250514 = "fine-grained-tool-streaming-2025-05-14"
 	BetaFeatureInterleavedThinking20250514      = "interleaved-thinking-2025-05-14"
+	BetaFeatureContext1M20250807                = "context-1m-2025-08-07"
 )
 
+// HasBetaFeature reports whether feature is listed in any of the comma-separated
+// anthropic-beta header values.
+func HasBetaFeature(header http.Header, feature string) bool {
+	for _, features := range header.Values(HeaderBeta) {
+		for f := range strings.SplitSeq(features, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), feature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 const (
 	HeaderAPIKey  = "x-api-key"
 	HeaderVersion = "anthropic-version"
 	HeaderBeta    = "anthropic-beta"
 )
 
+// Versions are the published values of the anthropic-version header, newest first.
+// reference: https://docs.anthropic.com/en/api/versioning
+var Versions = []string{"2023-06-01", "2023-01-01"}
+
 const (
 	ErrorContentType = "error"
 )
@@ -44,6 +62,32 @@ const (
 	OverloadedError     = "overloaded_error"
 )
 
+// ErrorTypeForStatus returns the error type the Anthropic API reports with an HTTP status code.
+// Other client errors are invalid_request_error and everything else is api_error.
+// reference: https://docs.anthropic.com/en/api/errors
+func ErrorTypeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return InvalidRequestError
+	case http.StatusUnauthorized:
+		return AuthenticationError
+	case http.StatusForbidden:
+		return PermissionError
+	case http.StatusNotFound:
+		return NotFoundError
+	case http.StatusRequestEntityTooLarge:
+		return RequestTooLarge
+	case http.StatusTooManyRequests:
+		return RateLimitError
+	case http.StatusServiceUnavailable, 529:
+		return OverloadedError
+	}
+	if status/100 == 4 {
+		return InvalidRequestError
+	}
+	return APIError
+}
+
 type Error struct {
 	ContentType string      `json:"type"`
 	Inner       *InnerError `json:"error"`
@@ -95,6 +139,9 @@ type GenerateMessageRequest struct {
 	TopK          *int            `json:"top_k,omitempty"`
 	TopP          *float64        `json:"top_p,omitempty"`
 	Stream        utils.True      `json:"stream"`
+	// Container reuses the code execution container of a previous response.
+	// reference: https://docs.anthropic.com/en/docs/agents-and-tools/tool-use/code-execution-tool#reusing-containers
+	Container string `json:"container,omitempty"`
 }
 
 type CountTokensRequest struct {
@@ -106,6 +153,110 @@ type CountTokensRequest struct {
 	Tools      []*Tool         `json:"tools,omitempty"`
 }
 
+// CountTokensResponse is the response of the count_tokens endpoint.
+// reference: https://docs.anthropic.com/en/api/messages-count-tokens
+type CountTokensResponse struct {
+	InputTokens int64 `json:"input_tokens"`
+}
+
+// ModelInfo is an entry of the /v1/models response.
+// reference: https://docs.anthropic.com/en/api/models-list
+type ModelInfo struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at,omitempty"`
+}
+
+type ListModelsResponse struct {
+	Data    []*ModelInfo `json:"data"`
+	HasMore bool         `json:"has_more"`
+	FirstID *string      `json:"first_id"`
+	LastID  *string      `json:"last_id"`
+}
+
+// CreateMessageBatchRequest is the request of the Message Batches API.
+// reference: https://docs.anthropic.com/en/api/creating-message-batches
+type CreateMessageBatchRequest struct {
+	Requests []*MessageBatchRequest `json:"requests"`
+}
+
+type MessageBatchRequest struct {
+	CustomID string `json:"custom_id"`
+	// Params is a messages request body; it is kept raw so that it goes through the messages
+	// handler exactly as a direct request would.
+	Params json.RawMessage `json:"params"`
+}
+
+type MessageBatchProcessingStatus string
+
+const (
+	MessageBatchProcessingStatusInProgress MessageBatchProcessingStatus = "in_progress"
+	MessageBatchProcessingStatusCanceling  MessageBatchProcessingStatus = "canceling"
+	MessageBatchProcessingStatusEnded      MessageBatchProcessingStatus = "ended"
+)
+
+const MessageBatchType = "message_batch"
+
+type MessageBatch struct {
+	ID                string                       `json:"id"`
+	Type              string                       `json:"type"`
+	ProcessingStatus  MessageBatchProcessingStatus `json:"processing_status"`
+	RequestCounts     MessageBatchRequestCounts    `json:"request_counts"`
+	EndedAt           *string                      `json:"ended_at"`
+	CreatedAt         string                       `json:"created_at"`
+	ExpiresAt         string                       `json:"expires_at"`
+	ArchivedAt        *string                      `json:"archived_at"`
+	CancelInitiatedAt *string                      `json:"cancel_initiated_at"`
+	ResultsURL        *string                      `json:"results_url"`
+}
+
+type MessageBatchRequestCounts struct {
+	Processing int64 `json:"processing"`
+	Succeeded  int64 `json:"succeeded"`
+	Errored    int64 `json:"errored"`
+	Canceled   int64 `json:"canceled"`
+	Expired    int64 `json:"expired"`
+}
+
+type ListMessageBatchesResponse struct {
+	Data    []*MessageBatch `json:"data"`
+	HasMore bool            `json:"has_more"`
+	FirstID *string         `json:"first_id"`
+	LastID  *string         `json:"last_id"`
+}
+
+// DeletedMessageBatch is the response of deleting a batch.
+type DeletedMessageBatch struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+const DeletedMessageBatchType = "message_batch_deleted"
+
+type MessageBatchResultType string
+
+const (
+	MessageBatchResultTypeSucceeded MessageBatchResultType = "succeeded"
+	MessageBatchResultTypeErrored   MessageBatchResultType = "errored"
+	MessageBatchResultTypeCanceled  MessageBatchResultType = "canceled"
+	MessageBatchResultTypeExpired   MessageBatchResultType = "expired"
+)
+
+// MessageBatchIndividualResponse is a line of the .jsonl results of a batch.
+// reference: https://docs.anthropic.com/en/api/retrieving-message-batch-results
+type MessageBatchIndividualResponse struct {
+	CustomID string              `json:"custom_id"`
+	Result   *MessageBatchResult `json:"result"`
+}
+
+type MessageBatchResult struct {
+	Type MessageBatchResultType `json:"type"`
+	// Message is the response body of a succeeded request.
+	Message json.RawMessage `json:"message,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
 type Message struct {
 	ID           string          `json:"id,omitempty"`
 	Type         MessageType     `json:"type,omitempty"`
@@ -115,6 +266,13 @@ type Message struct {
 	StopReason   *StopReason     `json:"stop_reason,omitempty"`
 	StopSequence *string         `json:"stop_sequence,omitempty"`
 	Usage        *Usage          `json:"usage,omitempty"`
+	Container    *Container      `json:"container,omitempty"`
+}
+
+// Container is the code execution container a response ran in.
+type Container struct {
+	ID        string `json:"id"`
+	ExpiresAt string `json:"expires_at"`
 }
 
 type MessageType string
@@ -146,6 +304,7 @@ type MessageContentType string
 const (
 	MessageContentTypeText                MessageContentType = "text"
 	MessageContentTypeImage               MessageContentType = "image"
+	MessageContentTypeDocument            MessageContentType = "document"
 	MessageContentTypeToolUse             MessageContentType = "tool_use"
 	MessageContentTypeToolResult          MessageContentType = "tool_result"
 	MessageContentTypeThinking            MessageContentType = "thinking"
@@ -153,6 +312,8 @@ const (
 	MessageContentTypeServerToolUse       MessageContentType = "server_tool_use"
 	MessageContentTypeWebSearchToolResult MessageContentType = "web_search_tool_result"
 	MessageContentTypeWebSearchResult     MessageContentType = "web_search_result"
+
+	MessageContentTypeCodeExecutionToolResult MessageContentType = "code_execution_tool_result"
 )
 
 type MessageContents []*MessageContent
@@ -161,6 +322,9 @@ func (mc MessageContents) MarshalJSON() ([]byte, error) {
 	if mc == nil {
 		return []byte("[]"), nil
 	}
+	if len(mc) == 1 && mc[0] != nil && mc[0].object != nil {
+		return mc[0].object, nil
+	}
 	return json.Marshal([]*MessageContent(mc))
 }
 
@@ -185,6 +349,17 @@ func (mc *MessageContents) UnmarshalJSON(data []byte) error {
 				Text: content,
 			})
 			return nil
+		case '{':
+			// The content of some server tool results is a single object, e.g. the
+			// code_execution_result of a code_execution_tool_result, or the error of a
+			// web_search_tool_result. It is kept verbatim, so that it is sent back unchanged.
+			var content *MessageContent
+			if err := json.Unmarshal(data, &content); err != nil {
+				return err
+			}
+			content.object = bytes.Clone(data)
+			*mc = MessageContents{content}
+			return nil
 		default:
 			return errors.New("message content should be a string or an array")
 		}
@@ -204,6 +379,7 @@ type MessageContent struct {
 	Input     json.RawMessage       `json:"input,omitempty"`
 	ToolUseID string                `json:"tool_use_id,omitempty"`
 	Content   MessageContents       `json:"content,omitempty"`
+	IsError   bool                  `json:"is_error,omitempty"`
 
 	Title            string  `json:"title,omitempty"`
 	Url              string  `json:"url,omitempty"`
@@ -212,19 +388,29 @@ type MessageContent struct {
 
 	// Citation are always enabled for web search
 	// reference: https://docs.anthropic.com/en/docs/agents-and-tools/tool-use/web-search-tool#citations
-	Citations []*Citation `json:"citation,omitempty"`
+	Citations []*Citation `json:"citations,omitempty"`
 
 	// CacheControl enables prompt caching from Anthropic
 	// reference: https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching
 	CacheControl *CacheControl `json:"cache_control,omitempty"`
+
+	// object is the original JSON of a content that was sent as an object instead of an array.
+	object json.RawMessage
 }
 
 type MessageContentSource struct {
 	Type      MessageContentType `json:"type"`
 	MediaType string             `json:"media_type,omitempty"`
 	Data      string             `json:"data,omitempty"`
+	URL       string             `json:"url,omitempty"`
 }
 
+const (
+	MessageContentSourceTypeBase64 MessageContentType = "base64"
+	MessageContentSourceTypeURL    MessageContentType = "url"
+	MessageContentSourceTypeText   MessageContentType = "text"
+)
+
 type CacheControl struct {
 	Type MessageCacheControlType `json:"type"`
 	TTL  MessageCacheControlTTL  `json:"ttl,omitempty"`
@@ -243,18 +429,33 @@ const (
 	MessageCacheControlTTL1Hour    MessageCacheControlTTL = "1h"
 )
 
+// Citation locates the source of a text block. Only the fields of its Type are set.
+//
+// reference: https://docs.anthropic.com/en/docs/build-with-claude/citations
 type Citation struct {
 	Type           CitationType `json:"type"`
-	URL            string       `json:"url"`
-	Title          string       `json:"title"`
-	EncryptedIndex string       `json:"encrypted_index"`
+	URL            string       `json:"url,omitempty"`
+	Title          string       `json:"title,omitempty"`
+	EncryptedIndex string       `json:"encrypted_index,omitempty"`
 	CitedText      string       `json:"cited_text"`
+
+	DocumentIndex   *int   `json:"document_index,omitempty"`
+	DocumentTitle   string `json:"document_title,omitempty"`
+	StartCharIndex  *int   `json:"start_char_index,omitempty"`
+	EndCharIndex    *int   `json:"end_char_index,omitempty"`
+	StartPageNumber *int   `json:"start_page_number,omitempty"`
+	EndPageNumber   *int   `json:"end_page_number,omitempty"`
+	StartBlockIndex *int   `json:"start_block_index,omitempty"`
+	EndBlockIndex   *int   `json:"end_block_index,omitempty"`
 }
 
 type CitationType string
 
 const (
 	CitationTypeWebSearchResultLocation CitationType = "web_search_result_location"
+	CitationTypeCharLocation            CitationType = "char_location"
+	CitationTypePageLocation            CitationType = "page_location"
+	CitationTypeContentBlockLocation    CitationType = "content_block_location"
 )
 
 type MessageContentDeltaType string
@@ -278,6 +479,41 @@ type MessageContentDelta struct {
 
 type Metadata struct {
 	UserID string `json:"user_id,omitempty"`
+	// Extra holds the metadata keys other than user_id, which are kept so that they reach the upstream.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(m.Extra)+1)
+	for key, value := range m.Extra {
+		fields[key] = value
+	}
+	if m.UserID != "" {
+		userID, err := json.Marshal(m.UserID)
+		if err != nil {
+			return nil, err
+		}
+		fields["user_id"] = userID
+	}
+	return json.Marshal(fields)
+}
+
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	*m = Metadata{}
+	if userID, ok := fields["user_id"]; ok {
+		if err := json.Unmarshal(userID, &m.UserID); err != nil {
+			return fmt.Errorf("metadata.user_id: %w", err)
+		}
+		delete(fields, "user_id")
+	}
+	if len(fields) > 0 {
+		m.Extra = fields
+	}
+	return nil
 }
 
 type Thinking struct {
@@ -359,6 +595,10 @@ type ToolType string
 const (
 	ToolTypeCustom        ToolType = "custom"
 	ToolTypeWebSearch2025 ToolType = "web_search_20250305"
+	// ToolTypeCodeExecution2025 is the code execution server tool, which runs code in a
+	// container hosted by Anthropic.
+	// reference: https://docs.anthropic.com/en/docs/agents-and-tools/tool-use/code-execution-tool
+	ToolTypeCodeExecution2025 ToolType = "code_execution_20250522"
 )
 
 const (
@@ -438,6 +678,9 @@ func (builder *MessageBuilder) Add(event Event) error {
 		if e.Delta != nil {
 			builder.message.StopSequence = e.Delta.StopSequence
 			builder.message.StopReason = e.Delta.StopReason
+			if e.Delta.Container != nil {
+				builder.message.Container = e.Delta.Container
+			}
 		}
 		if e.Usage != nil {
 			if e.Usage.InputTokens > 0 {
@@ -467,7 +710,7 @@ func (builder *MessageBuilder) Add(event Event) error {
 				//
 				// reference: https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#example-working-with-redacted-thinking-blocks
 				panic("unreachable redacted_thinking")
-			case MessageContentTypeWebSearchToolResult:
+			case MessageContentTypeWebSearchToolResult, MessageContentTypeCodeExecutionToolResult:
 				content.ToolUseID = e.ContentBlock.ToolUseID
 				content.Content = e.ContentBlock.Content
 			}
@@ -478,7 +721,8 @@ func (builder *MessageBuilder) Add(event Event) error {
 			case MessageContentDeltaTypeThinkingDelta:
 				builder.textBuilder.WriteString(e.Delta.Thinking)
 			case MessageContentDeltaTypeSignatureDelta:
-				builder.message.Content[e.Index].Signature = e.Delta.Signature
+				// Converted streams may split long signatures across several deltas.
+				builder.message.Content[e.Index].Signature += e.Delta.Signature
 			case MessageContentDeltaTypeTextDelta:
 				builder.textBuilder.WriteString(e.Delta.Text)
 			case MessageContentDeltaTypeCitationsDelta:
@@ -535,6 +779,81 @@ func (builder *MessageBuilder) Add(event Event) error {
 	return nil
 }
 
+// NewMessageStream replays a complete, non-streaming message as the events of a stream, so that
+// it can be handled like a streaming response. Feeding the events to a MessageBuilder yields the
+// message again.
+func NewMessageStream(message *Message) MessageStream {
+	return func(yield func(Event, error) bool) {
+		start := &Message{
+			ID:      message.ID,
+			Type:    message.Type,
+			Role:    message.Role,
+			Content: MessageContents{},
+			Model:   message.Model,
+			Usage:   &Usage{},
+		}
+		if message.Usage != nil {
+			usage := *message.Usage
+			start.Usage = &usage
+		}
+		if !yield(&EventMessageStart{Type: EventTypeMessageStart, Message: start}, nil) {
+			return
+		}
+		for index, content := range message.Content {
+			if content == nil {
+				continue
+			}
+			for _, event := range contentBlockEvents(index, content) {
+				if !yield(event, nil) {
+					return
+				}
+			}
+		}
+		if !yield(&EventMessageDelta{
+			Type:  EventTypeMessageDelta,
+			Delta: &Message{StopReason: message.StopReason, StopSequence: message.StopSequence},
+			Usage: message.Usage,
+		}, nil) {
+			return
+		}
+		yield(&EventMessageStop{Type: EventTypeMessageStop}, nil)
+	}
+}
+
+// contentBlockEvents returns the start, delta and stop events that stream content at index.
+func contentBlockEvents(index int, content *MessageContent) []Event {
+	delta := func(delta *MessageContentDelta) Event {
+		return &EventContentBlockDelta{Type: EventTypeContentBlockDelta, Index: index, Delta: delta}
+	}
+	var (
+		block  = content
+		deltas []Event
+	)
+	switch content.Type {
+	case MessageContentTypeText:
+		block = &MessageContent{Type: content.Type, Text: ""}
+		deltas = append(deltas, delta(&MessageContentDelta{Type: MessageContentDeltaTypeTextDelta, Text: content.Text}))
+		for _, citation := range content.Citations {
+			deltas = append(deltas, delta(&MessageContentDelta{Type: MessageContentDeltaTypeCitationsDelta, Citation: citation}))
+		}
+	case MessageContentTypeThinking:
+		block = &MessageContent{Type: content.Type, Thinking: ""}
+		deltas = append(deltas, delta(&MessageContentDelta{Type: MessageContentDeltaTypeThinkingDelta, Thinking: content.Thinking}))
+		if content.Signature != "" {
+			deltas = append(deltas, delta(&MessageContentDelta{Type: MessageContentDeltaTypeSignatureDelta, Signature: content.Signature}))
+		}
+	case MessageContentTypeToolUse, MessageContentTypeServerToolUse:
+		block = &MessageContent{Type: content.Type, ID: content.ID, Name: content.Name, Input: json.RawMessage("{}")}
+		if len(content.Input) > 0 {
+			deltas = append(deltas, delta(&MessageContentDelta{Type: MessageContentDeltaTypeInputJSONDelta, PartialJSON: string(content.Input)}))
+		}
+	}
+	events := make([]Event, 0, len(deltas)+2)
+	events = append(events, &EventContentBlockStart{Type: EventTypeContentBlockStart, Index: index, ContentBlock: block})
+	events = append(events, deltas...)
+	return append(events, &EventContentBlockStop{Type: EventTypeContentBlockStop, Index: index})
+}
+
 type EventType string
 
 const (
@@ -3,6 +3,7 @@ package anthropic
 import (
 	"bytes"
 	"encoding/json"
+	"net/http"
 	"testing"
 
 	"github.com/samber/lo"
@@ -783,3 +784,157 @@ func TestTool_OmitZero_NilSlices(t *testing.T) {
 		t.Errorf("expected blocked_domains omitted, got: %s", string(b))
 	}
 }
+
+func TestHasBetaFeature(t *testing.T) {
+	header := http.Header{}
+	header.Add(HeaderBeta, "claude-code-20250219, interleaved-thinking-2025-05-14")
+	header.Add(HeaderBeta, " Context-1M-2025-08-07 ")
+	if !HasBetaFeature(header, BetaFeatureContext1M20250807) {
+		t.Error("expected context-1m beta to be detected")
+	}
+	if !HasBetaFeature(header, BetaFeatureInterleavedThinking20250514) {
+		t.Error("expected interleaved-thinking beta to be detected")
+	}
+	if HasBetaFeature(header, BetaFeatureFineGrainedToolStreaming20250514) {
+		t.Error("unexpected fine-grained-tool-streaming beta")
+	}
+	if HasBetaFeature(http.Header{}, BetaFeatureContext1M20250807) {
+		t.Error("unexpected beta in empty header")
+	}
+}
+
+func TestMessageContent_ToolResultIsErrorRoundTrip(t *testing.T) {
+	data := []byte(`{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"command not found"}],"is_error":true}`)
+	var content MessageContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if !content.IsError {
+		t.Fatal("is_error should be decoded")
+	}
+	got, err := json.Marshal(&content)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("round trip = %s, want %s", got, data)
+	}
+	content.IsError = false
+	if got, _ = json.Marshal(&content); bytes.Contains(got, []byte("is_error")) {
+		t.Errorf("is_error should be omitted when false: %s", got)
+	}
+}
+
+func TestMetadata_ExtraKeysRoundTrip(t *testing.T) {
+	data := []byte(`{"session":"s-1","tags":["a"],"user_id":"user-1"}`)
+	var metadata Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if metadata.UserID != "user-1" || len(metadata.Extra) != 2 {
+		t.Fatalf("metadata = %+v, want user_id and 2 extra keys", metadata)
+	}
+	got, err := json.Marshal(&metadata)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("round trip = %s, want %s", got, data)
+	}
+	if got, _ = json.Marshal(&Metadata{}); string(got) != "{}" {
+		t.Errorf("empty metadata = %s, want {}", got)
+	}
+}
+
+func TestMessageContent_CodeExecutionToolResultRoundTrip(t *testing.T) {
+	data := []byte(`{"type":"code_execution_tool_result","tool_use_id":"srvtoolu_1","content":{"type":"code_execution_result","stdout":"","stderr":"","return_code":0,"content":[]}}`)
+	var content MessageContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if len(content.Content) != 1 || content.Content[0].Type != "code_execution_result" {
+		t.Fatalf("unexpected content: %+v", content.Content)
+	}
+	got, err := json.Marshal(&content)
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("round trip = %s, want %s", got, data)
+	}
+}
+
+func TestMessageBuilder_CodeExecution(t *testing.T) {
+	var block EventContentBlockStart
+	if err := json.Unmarshal([]byte(`{"type":"content_block_start","index":1,"content_block":{"type":"code_execution_tool_result","tool_use_id":"srvtoolu_1","content":{"type":"code_execution_result","stdout":"4\n","stderr":"","return_code":0,"content":[]}}}`), &block); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	events := []Event{
+		&EventMessageStart{Message: &Message{ID: "msg_1", Usage: &Usage{InputTokens: 10}}},
+		&EventContentBlockStart{Index: 0, ContentBlock: &MessageContent{Type: MessageContentTypeServerToolUse, ID: "srvtoolu_1", Name: "code_execution"}},
+		&EventContentBlockDelta{Index: 0, Delta: &MessageContentDelta{Type: MessageContentDeltaTypeInputJSONDelta, PartialJSON: `{"code":"print(2+2)"}`}},
+		&EventContentBlockStop{Index: 0},
+		&block,
+		&EventContentBlockStop{Index: 1},
+		&EventMessageDelta{
+			Delta: &Message{StopReason: lo.ToPtr(StopReasonEndTurn), Container: &Container{ID: "container_1", ExpiresAt: "2025-05-23T00:00:00Z"}},
+			Usage: &Usage{OutputTokens: 5},
+		},
+	}
+	builder := NewMessageBuilder()
+	for _, event := range events {
+		if err := builder.Add(event); err != nil {
+			t.Fatalf("Expected no error adding event, got: %v", err)
+		}
+	}
+	result := builder.Message()
+	if result.Container == nil || result.Container.ID != "container_1" {
+		t.Errorf("container = %+v, want container_1", result.Container)
+	}
+	if len(result.Content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got: %d", len(result.Content))
+	}
+	got, err := json.Marshal(result.Content[1])
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	if want := `{"type":"code_execution_tool_result","tool_use_id":"srvtoolu_1","content":{"type":"code_execution_result","stdout":"4\n","stderr":"","return_code":0,"content":[]}}`; string(got) != want {
+		t.Errorf("tool result = %s, want %s", got, want)
+	}
+}
+
+func TestNewMessageStream(t *testing.T) {
+	message := &Message{
+		ID:    "msg_1",
+		Type:  MessageTypeMessage,
+		Role:  MessageRoleAssistant,
+		Model: "claude-sonnet-4",
+		Content: MessageContents{
+			{Type: MessageContentTypeThinking, Thinking: "Let me look.", Signature: "sig"},
+			{Type: MessageContentTypeText, Text: "Reading the file.", Citations: []*Citation{{Type: CitationTypeCharLocation, CitedText: "file"}}},
+			{Type: MessageContentTypeToolUse, ID: "toolu_1", Name: "read_file", Input: json.RawMessage(`{"path":"main.go"}`)},
+			{Type: MessageContentTypeToolUse, ID: "toolu_2", Name: "exit_plan_mode", Input: json.RawMessage(`{}`)},
+		},
+		StopReason: lo.ToPtr(StopReasonToolUse),
+		Usage:      &Usage{InputTokens: 12, OutputTokens: 34},
+	}
+	builder := NewMessageBuilder()
+	var types []EventType
+	for event, err := range NewMessageStream(message) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		types = append(types, event.EventType())
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder error: %v", err)
+		}
+	}
+	if types[0] != EventTypeMessageStart || types[len(types)-1] != EventTypeMessageStop {
+		t.Errorf("unexpected event order: %v", types)
+	}
+	want, _ := json.Marshal(message)
+	got, _ := json.Marshal(builder.Message())
+	if !bytes.Equal(got, want) {
+		t.Errorf("rebuilt message differs:\ngot:  %s\nwant: %s", got, want)
+	}
+}
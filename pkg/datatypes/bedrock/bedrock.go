@@ -0,0 +1,152 @@
+package bedrock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/tidwall/sjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+// AnthropicVersion is the anthropic_version value required by Bedrock in place of the
+// Anthropic-Version header.
+const AnthropicVersion = "bedrock-2023-05-31"
+
+// ContentTypeEventStream is the Content-Type of invoke-with-response-stream responses.
+const ContentTypeEventStream = "application/vnd.amazon.eventstream"
+
+// supportedBetaFeatures lists the anthropic-beta features Bedrock accepts; Bedrock rejects
+// requests carrying any other beta flag.
+var supportedBetaFeatures = map[string]struct{}{
+	anthropic.BetaFeatureFineGrainedToolStreaming20250514: {},
+	anthropic.BetaFeatureInterleavedThinking20250514:      {},
+	"token-efficient-tools-2025-02-19":                    {},
+	"output-128k-2025-02-19":                              {},
+	"computer-use-2024-10-22":                             {},
+	"computer-use-2025-01-24":                             {},
+	anthropic.BetaFeatureContext1M20250807:                {},
+}
+
+// EncodeRequest converts an Anthropic Messages request into the body expected by Bedrock's
+// InvokeModel APIs: the model moves to the URL, streaming is selected by the endpoint and the
+// API version is carried in the body.
+func EncodeRequest(req *anthropic.GenerateMessageRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range []string{"model", "stream", "metadata"} {
+		if body, err = sjson.DeleteBytes(body, key); err != nil {
+			return "", err
+		}
+	}
+	if body, err = sjson.SetBytes(body, "anthropic_version", AnthropicVersion); err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func rewriteRequestBody(req *http.Request, fn func([]byte) ([]byte, error)) {
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			defer r.Close()
+			if body, err := io.ReadAll(r); err == nil {
+				if newBody, err := fn(body); err == nil {
+					if oldBody := req.Body; oldBody != nil {
+						oldBody.Close()
+					}
+					req.ContentLength = int64(len(newBody))
+					req.Body = io.NopCloser(bytes.NewReader(newBody))
+					req.GetBody = func() (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(newBody)), nil
+					}
+				}
+			}
+		}
+	}
+}
+
+// WithAnthropicBetaFeatures forwards the anthropic-beta features supported by Bedrock from
+// oriHeader into the anthropic_beta body field. Unsupported features are dropped.
+func WithAnthropicBetaFeatures(oriHeader http.Header) func(*http.Request) {
+	return func(req *http.Request) {
+		var features []string
+		for _, values := range oriHeader.Values(anthropic.HeaderBeta) {
+			for feature := range strings.SplitSeq(values, ",") {
+				feature = strings.ToLower(strings.TrimSpace(feature))
+				if _, ok := supportedBetaFeatures[feature]; ok && !slices.Contains(features, feature) {
+					features = append(features, feature)
+				}
+			}
+		}
+		if len(features) > 0 {
+			rewriteRequestBody(req, func(body []byte) ([]byte, error) {
+				return sjson.SetBytes(body, "anthropic_beta", features)
+			})
+		}
+	}
+}
+
+// Error is returned by Bedrock both as a JSON response body and as an exception event
+// inside response streams.
+type Error struct {
+	ErrMessage    string `json:"message"`
+	ExceptionType string `json:"-"`
+
+	statusCode int
+}
+
+func (e *Error) Error() string {
+	if e.ExceptionType != "" {
+		return fmt.Sprintf("(%d) %s: %s", e.statusCode, e.ExceptionType, e.ErrMessage)
+	}
+	return fmt.Sprintf("(%d) %s", e.statusCode, e.ErrMessage)
+}
+
+// Type maps the HTTP status to the closest Anthropic error type.
+func (e *Error) Type() string {
+	return anthropic.ErrorTypeForStatus(e.statusCode)
+}
+
+func (e *Error) Message() string              { return e.ErrMessage }
+func (e *Error) Source() string               { return "bedrock" }
+func (e *Error) StatusCode() int              { return e.statusCode }
+func (e *Error) SetStatusCode(statusCode int) { e.statusCode = statusCode }
+
+// exceptionStatusCodes maps stream exception types to the HTTP status Bedrock uses for the
+// same error outside of a stream.
+var exceptionStatusCodes = map[string]int{
+	"validationException":         http.StatusBadRequest,
+	"accessDeniedException":       http.StatusForbidden,
+	"resourceNotFoundException":   http.StatusNotFound,
+	"modelTimeoutException":       http.StatusRequestTimeout,
+	"modelStreamErrorException":   http.StatusFailedDependency,
+	"throttlingException":         http.StatusTooManyRequests,
+	"internalServerException":     http.StatusInternalServerError,
+	"serviceUnavailableException": http.StatusServiceUnavailable,
+}
+
+// NewExceptionError builds an Error from a stream exception event.
+func NewExceptionError(exceptionType string, payload []byte) *Error {
+	e := &Error{ExceptionType: exceptionType}
+	if err := json.Unmarshal(payload, e); err != nil || e.ErrMessage == "" {
+		e.ErrMessage = string(payload)
+	}
+	statusCode, ok := exceptionStatusCodes[exceptionType]
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+	e.SetStatusCode(statusCode)
+	return e
+}
+
+// Chunk is the payload of a "chunk" event; Bytes holds one Anthropic streaming event as JSON.
+type Chunk struct {
+	Bytes []byte `json:"bytes"`
+}
@@ -0,0 +1,214 @@
+package bedrock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestSign(t *testing.T) {
+	// Test vectors from the AWS Signature Version 4 test suite.
+	creds := &Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{
+			name: "get-vanilla",
+			url:  "https://example.amazonaws.com/",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name: "get-vanilla-query-order-key-case",
+			url:  "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			Sign(req, creds, "us-east-1", "service", now)
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization =\n%s\nwant\n%s", got, tt.want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %s", got)
+			}
+		})
+	}
+}
+
+func TestSign_BodyAndSessionToken(t *testing.T) {
+	body := []byte(`{"messages":[]}`)
+	req, err := http.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-v2%3A1/invoke", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	Sign(req, &Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", SessionToken: "TOKEN"}, "us-east-1", SigningService, time.Now())
+	auth := req.Header.Get("Authorization")
+	if !strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("unexpected signed headers: %s", auth)
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "TOKEN" {
+		t.Error("session token header not set")
+	}
+	// The body must still be readable after signing.
+	if got, _ := io.ReadAll(req.Body); !bytes.Equal(got, body) {
+		t.Errorf("body = %s, want %s", got, body)
+	}
+}
+
+func TestCanonicalURI(t *testing.T) {
+	if got, want := canonicalURI("/model/anthropic.claude-v2%3A1/invoke"), "/model/anthropic.claude-v2%253A1/invoke"; got != want {
+		t.Errorf("canonicalURI = %s, want %s", got, want)
+	}
+	if got := canonicalURI(""); got != "/" {
+		t.Errorf("canonicalURI(\"\") = %s, want /", got)
+	}
+}
+
+func TestDecodeEventStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(EncodeEventStreamMessage([][2]string{
+		{HeaderMessageType, MessageTypeEvent},
+		{HeaderEventType, "chunk"},
+	}, []byte(`{"bytes":"eyJ0eXBlIjoicGluZyJ9"}`)))
+	stream.Write(EncodeEventStreamMessage([][2]string{
+		{HeaderMessageType, MessageTypeException},
+		{HeaderExceptionType, "throttlingException"},
+	}, []byte(`{"message":"Too many requests"}`)))
+
+	var messages []*EventStreamMessage
+	for message, err := range DecodeEventStream(&stream) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+	if messages[0].Header(HeaderEventType) != "chunk" {
+		t.Errorf("unexpected event type: %q", messages[0].Header(HeaderEventType))
+	}
+	var chunk Chunk
+	if err := json.Unmarshal(messages[0].Payload, &chunk); err != nil {
+		t.Fatalf("invalid chunk: %v", err)
+	}
+	if string(chunk.Bytes) != `{"type":"ping"}` {
+		t.Errorf("chunk bytes = %s", chunk.Bytes)
+	}
+	if messages[1].Header(HeaderMessageType) != MessageTypeException || messages[1].Header(HeaderExceptionType) != "throttlingException" {
+		t.Errorf("unexpected exception headers: %v", messages[1].Headers)
+	}
+}
+
+func TestDecodeEventStream_Errors(t *testing.T) {
+	valid := EncodeEventStreamMessage([][2]string{{HeaderEventType, "chunk"}}, []byte(`{}`))
+	corrupted := bytes.Clone(valid)
+	corrupted[len(corrupted)-6] ^= 0xff
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "message checksum", data: corrupted, want: "message checksum mismatch"},
+		{name: "prelude checksum", data: append([]byte{0, 0, 0, 16, 0, 0, 0, 0}, 0, 0, 0, 0), want: "prelude checksum mismatch"},
+		{name: "truncated message", data: valid[:len(valid)-2], want: "reading message"},
+		{name: "truncated prelude", data: valid[:5], want: "reading prelude"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotErr error
+			for _, err := range DecodeEventStream(bytes.NewReader(tt.data)) {
+				if err != nil {
+					gotErr = err
+				}
+			}
+			if gotErr == nil || !strings.Contains(gotErr.Error(), tt.want) {
+				t.Errorf("error = %v, want containing %q", gotErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeRequest(t *testing.T) {
+	body, err := EncodeRequest(&anthropic.GenerateMessageRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Stream:    true,
+		Metadata:  &anthropic.Metadata{UserID: "user"},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("EncodeRequest failed: %v", err)
+	}
+	for _, key := range []string{"model", "stream", "metadata"} {
+		if gjson.Get(body, key).Exists() {
+			t.Errorf("%q should be removed: %s", key, body)
+		}
+	}
+	if got := gjson.Get(body, "anthropic_version").String(); got != AnthropicVersion {
+		t.Errorf("anthropic_version = %q", got)
+	}
+	if got := gjson.Get(body, "max_tokens").Int(); got != 1024 {
+		t.Errorf("max_tokens = %d", got)
+	}
+}
+
+func TestWithAnthropicBetaFeatures(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "https://example.com", strings.NewReader(`{"max_tokens":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Add(anthropic.HeaderBeta, "claude-code-20250219, interleaved-thinking-2025-05-14")
+	header.Add(anthropic.HeaderBeta, "fine-grained-tool-streaming-2025-05-14,interleaved-thinking-2025-05-14")
+	WithAnthropicBetaFeatures(header)(req)
+	body, _ := io.ReadAll(req.Body)
+	got := gjson.GetBytes(body, "anthropic_beta").Raw
+	if want := `["interleaved-thinking-2025-05-14","fine-grained-tool-streaming-2025-05-14"]`; got != want {
+		t.Errorf("anthropic_beta = %s, want %s", got, want)
+	}
+	if req.ContentLength != int64(len(body)) {
+		t.Errorf("ContentLength = %d, want %d", req.ContentLength, len(body))
+	}
+}
+
+func TestNewExceptionError(t *testing.T) {
+	err := NewExceptionError("throttlingException", []byte(`{"message":"Too many requests"}`))
+	if err.StatusCode() != http.StatusTooManyRequests || err.Type() != anthropic.RateLimitError {
+		t.Errorf("unexpected status/type: %d %s", err.StatusCode(), err.Type())
+	}
+	if err.Message() != "Too many requests" || err.Source() != "bedrock" {
+		t.Errorf("unexpected message/source: %q %q", err.Message(), err.Source())
+	}
+	var target *Error
+	if !errors.As(error(err), &target) {
+		t.Error("errors.As should match *Error")
+	}
+	if err = NewExceptionError("somethingNew", []byte("plain text")); err.StatusCode() != http.StatusInternalServerError || err.Message() != "plain text" {
+		t.Errorf("unexpected fallback: %d %q", err.StatusCode(), err.Message())
+	}
+}
@@ -0,0 +1,173 @@
+package bedrock
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+)
+
+// Well-known headers of AWS event stream messages.
+const (
+	HeaderMessageType   = ":message-type"
+	HeaderEventType     = ":event-type"
+	HeaderExceptionType = ":exception-type"
+	HeaderErrorCode     = ":error-code"
+	HeaderErrorMessage  = ":error-message"
+)
+
+// Values of the :message-type header.
+const (
+	MessageTypeEvent     = "event"
+	MessageTypeException = "exception"
+	MessageTypeError     = "error"
+)
+
+const (
+	eventStreamPreludeLength = 12
+	eventStreamCRCLength     = 4
+	// eventStreamMaxMessageLength guards against allocating huge buffers on a corrupt prelude.
+	eventStreamMaxMessageLength = 16 * 1024 * 1024
+)
+
+// EventStreamMessage is a single decoded message of the AWS event stream encoding
+// (application/vnd.amazon.eventstream). Only string header values are kept.
+type EventStreamMessage struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// Header returns the string value of header name.
+func (m *EventStreamMessage) Header(name string) string {
+	return m.Headers[name]
+}
+
+// DecodeEventStream reads binary event stream messages from r until EOF.
+//
+// reference: https://smithy.io/2.0/aws/amazon-eventstream.html
+func DecodeEventStream(r io.Reader) iter.Seq2[*EventStreamMessage, error] {
+	return func(yield func(*EventStreamMessage, error) bool) {
+		prelude := make([]byte, eventStreamPreludeLength)
+		for {
+			if _, err := io.ReadFull(r, prelude); err != nil {
+				if !errors.Is(err, io.EOF) {
+					yield(nil, fmt.Errorf("event stream: reading prelude: %w", err))
+				}
+				return
+			}
+			totalLength := binary.BigEndian.Uint32(prelude[0:4])
+			headersLength := binary.BigEndian.Uint32(prelude[4:8])
+			if crc := crc32.ChecksumIEEE(prelude[0:8]); crc != binary.BigEndian.Uint32(prelude[8:12]) {
+				yield(nil, errors.New("event stream: prelude checksum mismatch"))
+				return
+			}
+			if totalLength > eventStreamMaxMessageLength ||
+				uint64(totalLength) < uint64(eventStreamPreludeLength)+uint64(headersLength)+eventStreamCRCLength {
+				yield(nil, fmt.Errorf("event stream: invalid message length %d", totalLength))
+				return
+			}
+			message := make([]byte, totalLength)
+			copy(message, prelude)
+			if _, err := io.ReadFull(r, message[eventStreamPreludeLength:]); err != nil {
+				yield(nil, fmt.Errorf("event stream: reading message: %w", err))
+				return
+			}
+			crcOffset := totalLength - eventStreamCRCLength
+			if crc := crc32.ChecksumIEEE(message[:crcOffset]); crc != binary.BigEndian.Uint32(message[crcOffset:]) {
+				yield(nil, errors.New("event stream: message checksum mismatch"))
+				return
+			}
+			headersEnd := eventStreamPreludeLength + headersLength
+			headers, err := decodeEventStreamHeaders(message[eventStreamPreludeLength:headersEnd])
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(&EventStreamMessage{Headers: headers, Payload: message[headersEnd:crcOffset]}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Header value types of the event stream encoding.
+const (
+	headerTypeBoolTrue = iota
+	headerTypeBoolFalse
+	headerTypeByte
+	headerTypeShort
+	headerTypeInteger
+	headerTypeLong
+	headerTypeByteArray
+	headerTypeString
+	headerTypeTimestamp
+	headerTypeUUID
+)
+
+func decodeEventStreamHeaders(b []byte) (map[string]string, error) {
+	errTruncated := errors.New("event stream: truncated headers")
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLength := int(b[0])
+		if len(b) < 1+nameLength+1 {
+			return nil, errTruncated
+		}
+		name := string(b[1 : 1+nameLength])
+		valueType := b[1+nameLength]
+		b = b[1+nameLength+1:]
+		var valueLength int
+		switch valueType {
+		case headerTypeBoolTrue, headerTypeBoolFalse:
+			valueLength = 0
+		case headerTypeByte:
+			valueLength = 1
+		case headerTypeShort:
+			valueLength = 2
+		case headerTypeInteger:
+			valueLength = 4
+		case headerTypeLong, headerTypeTimestamp:
+			valueLength = 8
+		case headerTypeUUID:
+			valueLength = 16
+		case headerTypeByteArray, headerTypeString:
+			if len(b) < 2 {
+				return nil, errTruncated
+			}
+			valueLength = int(binary.BigEndian.Uint16(b[:2]))
+			b = b[2:]
+		default:
+			return nil, fmt.Errorf("event stream: unknown header value type %d", valueType)
+		}
+		if len(b) < valueLength {
+			return nil, errTruncated
+		}
+		if valueType == headerTypeString {
+			headers[name] = string(b[:valueLength])
+		}
+		b = b[valueLength:]
+	}
+	return headers, nil
+}
+
+// EncodeEventStreamMessage encodes a message with string headers; it is the inverse of
+// DecodeEventStream and mainly useful for tests.
+func EncodeEventStreamMessage(headers [][2]string, payload []byte) []byte {
+	var headerBytes []byte
+	for _, header := range headers {
+		headerBytes = append(headerBytes, byte(len(header[0])))
+		headerBytes = append(headerBytes, header[0]...)
+		headerBytes = append(headerBytes, headerTypeString)
+		headerBytes = binary.BigEndian.AppendUint16(headerBytes, uint16(len(header[1])))
+		headerBytes = append(headerBytes, header[1]...)
+	}
+	totalLength := eventStreamPreludeLength + len(headerBytes) + len(payload) + eventStreamCRCLength
+	message := make([]byte, 0, totalLength)
+	message = binary.BigEndian.AppendUint32(message, uint32(totalLength))
+	message = binary.BigEndian.AppendUint32(message, uint32(len(headerBytes)))
+	message = binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+	message = append(message, headerBytes...)
+	message = append(message, payload...)
+	return binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+}
@@ -0,0 +1,385 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/utils"
+)
+
+// EncodeRequest converts an Anthropic Messages request into the body expected by the
+// streamGenerateContent method; the model moves to the URL.
+func EncodeRequest(req *anthropic.GenerateMessageRequest) (string, error) {
+	return utils.JSONEncode(ConvertRequest(req))
+}
+
+// ConvertRequest converts an Anthropic Messages request into a Gemini generateContent request.
+//
+// Thinking blocks are not sent back as thoughts, since Gemini only needs their signatures, which
+// are attached to the part that follows them, where Gemini returned them. Server tools have no
+// Gemini function equivalent and are dropped.
+func ConvertRequest(req *anthropic.GenerateMessageRequest) *GenerateContentRequest {
+	dst := &GenerateContentRequest{
+		Contents: make([]*Content, 0, len(req.Messages)),
+		GenerationConfig: &GenerationConfig{
+			MaxOutputTokens: req.MaxTokens,
+			TopP:            req.TopP,
+			TopK:            req.TopK,
+			StopSequences:   req.StopSequences,
+		},
+	}
+	if req.Temperature != 0 {
+		dst.GenerationConfig.Temperature = &req.Temperature
+	}
+	if thinking := req.Thinking; thinking != nil && thinking.Type == anthropic.ThinkingTypeEnabled {
+		dst.GenerationConfig.ThinkingConfig = &ThinkingConfig{IncludeThoughts: true}
+		if thinking.BudgetTokens > 0 {
+			dst.GenerationConfig.ThinkingConfig.ThinkingBudget = &thinking.BudgetTokens
+		}
+	}
+	var systemParts []*Part
+	for _, content := range req.System {
+		if content != nil && content.Type == anthropic.MessageContentTypeText && content.Text != "" {
+			systemParts = append(systemParts, &Part{Text: content.Text})
+		}
+	}
+	if len(systemParts) > 0 {
+		dst.SystemInstruction = &Content{Parts: systemParts}
+	}
+	// functionResponse parts are matched by name, while tool_result blocks only refer to the id of
+	// the tool_use block.
+	toolNames := make(map[string]string)
+	for _, message := range req.Messages {
+		if message == nil {
+			continue
+		}
+		role := RoleUser
+		if message.Role == anthropic.MessageRoleAssistant {
+			role = RoleModel
+		}
+		var (
+			parts            []*Part
+			pendingSignature string
+		)
+		addPart := func(part *Part) {
+			part.ThoughtSignature, pendingSignature = pendingSignature, ""
+			parts = append(parts, part)
+		}
+		for _, content := range message.Content {
+			if content == nil {
+				continue
+			}
+			switch content.Type {
+			case anthropic.MessageContentTypeThinking:
+				if content.Signature != "" {
+					pendingSignature = content.Signature
+				}
+			case anthropic.MessageContentTypeToolUse:
+				toolNames[content.ID] = content.Name
+				args := content.Input
+				if len(args) == 0 {
+					args = json.RawMessage("{}")
+				}
+				addPart(&Part{FunctionCall: &FunctionCall{Name: content.Name, Args: args}})
+			case anthropic.MessageContentTypeToolResult:
+				var (
+					texts []string
+					media []*Part
+				)
+				for _, result := range content.Content {
+					if result == nil {
+						continue
+					}
+					if result.Type == anthropic.MessageContentTypeText {
+						texts = append(texts, result.Text)
+					} else if part := convertMediaContent(result); part != nil {
+						media = append(media, part)
+					}
+				}
+				key := "content"
+				if content.IsError {
+					key = "error"
+				}
+				response, _ := json.Marshal(map[string]string{key: strings.Join(texts, "\n")})
+				addPart(&Part{FunctionResponse: &FunctionResponse{Name: toolNames[content.ToolUseID], Response: response}})
+				parts = append(parts, media...)
+			case anthropic.MessageContentTypeText:
+				if content.Text != "" {
+					addPart(&Part{Text: content.Text})
+				}
+			default:
+				if part := convertMediaContent(content); part != nil {
+					addPart(part)
+				}
+			}
+		}
+		if len(parts) > 0 {
+			dst.Contents = append(dst.Contents, &Content{Role: role, Parts: parts})
+		}
+	}
+	var declarations []*FunctionDeclaration
+	for _, tool := range req.Tools {
+		if tool == nil || (tool.Type != nil && *tool.Type != anthropic.ToolTypeCustom) {
+			continue
+		}
+		declarations = append(declarations, &FunctionDeclaration{
+			Name:                 tool.Name,
+			Description:          tool.Description,
+			ParametersJSONSchema: tool.InputSchema,
+		})
+	}
+	if len(declarations) > 0 {
+		dst.Tools = []*Tool{{FunctionDeclarations: declarations}}
+	}
+	if toolChoice := req.ToolChoice; toolChoice != nil && len(declarations) > 0 {
+		config := &FunctionCallingConfig{}
+		switch toolChoice.Type {
+		case anthropic.ToolChoiceTypeAuto:
+			config.Mode = FunctionCallingModeAuto
+		case anthropic.ToolChoiceTypeAny:
+			config.Mode = FunctionCallingModeAny
+		case anthropic.ToolChoiceTypeTool:
+			config.Mode = FunctionCallingModeAny
+			config.AllowedFunctionNames = []string{toolChoice.Name}
+		case anthropic.ToolChoiceTypeNone:
+			config.Mode = FunctionCallingModeNone
+		}
+		dst.ToolConfig = &ToolConfig{FunctionCallingConfig: config}
+	}
+	return dst
+}
+
+// convertMediaContent converts image and document blocks, and returns nil for other blocks.
+func convertMediaContent(content *anthropic.MessageContent) *Part {
+	if content.Type != anthropic.MessageContentTypeImage && content.Type != anthropic.MessageContentTypeDocument {
+		return nil
+	}
+	source := content.Source
+	if source == nil {
+		return nil
+	}
+	switch source.Type {
+	case anthropic.MessageContentSourceTypeBase64:
+		return &Part{InlineData: &Blob{MimeType: source.MediaType, Data: source.Data}}
+	case anthropic.MessageContentSourceTypeURL:
+		return &Part{FileData: &FileData{MimeType: source.MediaType, FileURI: source.URL}}
+	case anthropic.MessageContentTypeText:
+		// Plain text documents carry their content in data.
+		return &Part{Text: source.Data}
+	}
+	return nil
+}
+
+// NewAnthropicStream converts the chunks of a streamGenerateContent response into an Anthropic
+// message stream.
+//
+// Gemini returns thought signatures on the part that follows the thoughts, often a function call.
+// Since Anthropic only has signatures on thinking blocks, a signature is emitted on the thinking
+// block before that part, opening an empty one when the response had no thoughts to show.
+func NewAnthropicStream(chunks iter.Seq2[*GenerateContentResponse, error]) anthropic.MessageStream {
+	return func(yield func(anthropic.Event, error) bool) {
+		s := &streamConverter{yield: yield, index: -1}
+		for chunk, err := range chunks {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !s.add(chunk) {
+				return
+			}
+		}
+		if !s.start(nil) {
+			return
+		}
+		s.finish()
+	}
+}
+
+type streamConverter struct {
+	yield func(anthropic.Event, error) bool
+
+	started    bool
+	index      int
+	blockType  anthropic.MessageContentType
+	toolCalls  int
+	toolUse    bool
+	stopReason anthropic.StopReason
+	usage      *UsageMetadata
+}
+
+func (s *streamConverter) emit(event anthropic.Event) bool {
+	return s.yield(event, nil)
+}
+
+func (s *streamConverter) start(chunk *GenerateContentResponse) bool {
+	if s.started {
+		return true
+	}
+	s.started = true
+	message := &anthropic.Message{
+		Type:    anthropic.MessageTypeMessage,
+		Role:    anthropic.MessageRoleAssistant,
+		Content: anthropic.MessageContents{},
+		Usage:   &anthropic.Usage{},
+	}
+	if chunk != nil {
+		message.ID = chunk.ResponseID
+		message.Model = chunk.ModelVersion
+		if usage := chunk.UsageMetadata; usage != nil {
+			message.Usage.InputTokens = usage.PromptTokenCount - usage.CachedContentTokenCount
+			message.Usage.CacheReadInputTokens = usage.CachedContentTokenCount
+		}
+	}
+	return s.emit(&anthropic.EventMessageStart{Type: anthropic.EventTypeMessageStart, Message: message})
+}
+
+func (s *streamConverter) add(chunk *GenerateContentResponse) bool {
+	if chunk == nil {
+		return true
+	}
+	if !s.start(chunk) {
+		return false
+	}
+	if chunk.UsageMetadata != nil {
+		s.usage = chunk.UsageMetadata
+	}
+	if feedback := chunk.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+		s.stopReason = anthropic.StopReasonRefusal
+	}
+	for _, candidate := range chunk.Candidates {
+		if candidate == nil || candidate.Index != 0 {
+			continue
+		}
+		if content := candidate.Content; content != nil {
+			for _, part := range content.Parts {
+				if part != nil && !s.addPart(part) {
+					return false
+				}
+			}
+		}
+		if candidate.FinishReason != "" {
+			s.stopReason = convertFinishReason(candidate.FinishReason)
+		}
+	}
+	return true
+}
+
+func (s *streamConverter) addPart(part *Part) bool {
+	if part.Thought {
+		if part.Text != "" {
+			if !s.open(&anthropic.MessageContent{Type: anthropic.MessageContentTypeThinking}) ||
+				!s.delta(&anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeThinkingDelta, Thinking: part.Text}) {
+				return false
+			}
+		}
+		if part.ThoughtSignature != "" {
+			return s.signature(part.ThoughtSignature)
+		}
+		return true
+	}
+	if part.ThoughtSignature != "" && (part.FunctionCall != nil || s.blockType != anthropic.MessageContentTypeText) {
+		// A signature on a text part that continues an open text block is dropped, since it
+		// could not be placed before the part it belongs to.
+		if !s.signature(part.ThoughtSignature) {
+			return false
+		}
+	}
+	switch {
+	case part.FunctionCall != nil:
+		call := part.FunctionCall
+		s.toolUse = true
+		id := call.ID
+		if id == "" {
+			s.toolCalls++
+			id = fmt.Sprintf("toolu_gemini_%d", s.toolCalls)
+		}
+		args := string(call.Args)
+		if args == "" || args == "null" {
+			args = "{}"
+		}
+		return s.close() &&
+			s.open(&anthropic.MessageContent{Type: anthropic.MessageContentTypeToolUse, ID: id, Name: call.Name, Input: json.RawMessage("{}")}) &&
+			s.delta(&anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeInputJSONDelta, PartialJSON: args}) &&
+			s.close()
+	case part.Text != "":
+		return s.open(&anthropic.MessageContent{Type: anthropic.MessageContentTypeText}) &&
+			s.delta(&anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeTextDelta, Text: part.Text})
+	}
+	return true
+}
+
+// signature puts signature on the open thinking block, or on a new empty one, and closes it.
+func (s *streamConverter) signature(signature string) bool {
+	return s.open(&anthropic.MessageContent{Type: anthropic.MessageContentTypeThinking}) &&
+		s.delta(&anthropic.MessageContentDelta{Type: anthropic.MessageContentDeltaTypeSignatureDelta, Signature: signature}) &&
+		s.close()
+}
+
+// open starts a block of the type of block, unless a block of that type is already open.
+func (s *streamConverter) open(block *anthropic.MessageContent) bool {
+	if s.blockType == block.Type && block.Type != anthropic.MessageContentTypeToolUse {
+		return true
+	}
+	if !s.close() {
+		return false
+	}
+	s.index++
+	s.blockType = block.Type
+	return s.emit(&anthropic.EventContentBlockStart{Type: anthropic.EventTypeContentBlockStart, Index: s.index, ContentBlock: block})
+}
+
+func (s *streamConverter) delta(delta *anthropic.MessageContentDelta) bool {
+	return s.emit(&anthropic.EventContentBlockDelta{Type: anthropic.EventTypeContentBlockDelta, Index: s.index, Delta: delta})
+}
+
+func (s *streamConverter) close() bool {
+	if s.blockType == "" {
+		return true
+	}
+	s.blockType = ""
+	return s.emit(&anthropic.EventContentBlockStop{Type: anthropic.EventTypeContentBlockStop, Index: s.index})
+}
+
+func (s *streamConverter) finish() {
+	if !s.close() {
+		return
+	}
+	stopReason := s.stopReason
+	switch {
+	case (stopReason == "" || stopReason == anthropic.StopReasonEndTurn) && s.toolUse:
+		// Gemini reports STOP after function calls, where Anthropic reports tool_use.
+		stopReason = anthropic.StopReasonToolUse
+	case stopReason == "":
+		stopReason = anthropic.StopReasonEndTurn
+	}
+	usage := &anthropic.Usage{}
+	if s.usage != nil {
+		usage.InputTokens = s.usage.PromptTokenCount - s.usage.CachedContentTokenCount
+		usage.CacheReadInputTokens = s.usage.CachedContentTokenCount
+		usage.OutputTokens = s.usage.CandidatesTokenCount + s.usage.ThoughtsTokenCount
+	}
+	if !s.emit(&anthropic.EventMessageDelta{
+		Type:  anthropic.EventTypeMessageDelta,
+		Delta: &anthropic.Message{StopReason: &stopReason},
+		Usage: usage,
+	}) {
+		return
+	}
+	s.emit(&anthropic.EventMessageStop{Type: anthropic.EventTypeMessageStop})
+}
+
+// convertFinishReason maps a Gemini finish reason to an Anthropic stop reason. Gemini reports
+// STOP for stop sequences too, so stop_sequence is never returned.
+func convertFinishReason(reason FinishReason) anthropic.StopReason {
+	switch reason {
+	case FinishReasonMaxTokens:
+		return anthropic.StopReasonMaxTokens
+	case FinishReasonSafety, FinishReasonRecitation, FinishReasonBlocklist, FinishReasonProhibitedContent, FinishReasonSPII:
+		return anthropic.StopReasonRefusal
+	default:
+		return anthropic.StopReasonEndTurn
+	}
+}
@@ -0,0 +1,195 @@
+// Package gemini contains the request and response types of the Gemini API generateContent
+// methods, and the conversion between them and the Anthropic Messages API.
+//
+// reference: https://ai.google.dev/api/generate-content
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+// HeaderAPIKey carries the Gemini API key.
+const HeaderAPIKey = "X-Goog-Api-Key"
+
+// GenerateContentRequest is the body of the models.streamGenerateContent method.
+type GenerateContentRequest struct {
+	Contents          []*Content        `json:"contents"`
+	SystemInstruction *Content          `json:"systemInstruction,omitempty"`
+	Tools             []*Tool           `json:"tools,omitempty"`
+	ToolConfig        *ToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleModel Role = "model"
+)
+
+type Content struct {
+	Role  Role    `json:"role,omitempty"`
+	Parts []*Part `json:"parts"`
+}
+
+// Part is one piece of a Content; exactly one of Text, InlineData, FileData, FunctionCall
+// and FunctionResponse is set.
+type Part struct {
+	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
+	ThoughtSignature string            `json:"thoughtSignature,omitempty"`
+	InlineData       *Blob             `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type Blob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type FunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type FunctionResponse struct {
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type Tool struct {
+	FunctionDeclarations []*FunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+// FunctionDeclaration describes a function with a JSON Schema, which unlike the OpenAPI subset
+// of the "parameters" field accepts the input_schema of Anthropic tools as is.
+type FunctionDeclaration struct {
+	Name                 string          `json:"name"`
+	Description          string          `json:"description,omitempty"`
+	ParametersJSONSchema json.RawMessage `json:"parametersJsonSchema,omitempty"`
+}
+
+type ToolConfig struct {
+	FunctionCallingConfig *FunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+type FunctionCallingMode string
+
+const (
+	FunctionCallingModeAuto FunctionCallingMode = "AUTO"
+	FunctionCallingModeAny  FunctionCallingMode = "ANY"
+	FunctionCallingModeNone FunctionCallingMode = "NONE"
+)
+
+type FunctionCallingConfig struct {
+	Mode                 FunctionCallingMode `json:"mode,omitempty"`
+	AllowedFunctionNames []string            `json:"allowedFunctionNames,omitempty"`
+}
+
+type GenerationConfig struct {
+	MaxOutputTokens int             `json:"maxOutputTokens,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"topP,omitempty"`
+	TopK            *int            `json:"topK,omitempty"`
+	StopSequences   []string        `json:"stopSequences,omitempty"`
+	ThinkingConfig  *ThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type ThinkingConfig struct {
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+}
+
+// GenerateContentResponse is one chunk of a streamGenerateContent response.
+type GenerateContentResponse struct {
+	Candidates     []*Candidate    `json:"candidates,omitempty"`
+	PromptFeedback *PromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  *UsageMetadata  `json:"usageMetadata,omitempty"`
+	ModelVersion   string          `json:"modelVersion,omitempty"`
+	ResponseID     string          `json:"responseId,omitempty"`
+}
+
+type Candidate struct {
+	Content      *Content     `json:"content,omitempty"`
+	FinishReason FinishReason `json:"finishReason,omitempty"`
+	Index        int          `json:"index"`
+}
+
+type FinishReason string
+
+const (
+	FinishReasonStop                  FinishReason = "STOP"
+	FinishReasonMaxTokens             FinishReason = "MAX_TOKENS"
+	FinishReasonSafety                FinishReason = "SAFETY"
+	FinishReasonRecitation            FinishReason = "RECITATION"
+	FinishReasonBlocklist             FinishReason = "BLOCKLIST"
+	FinishReasonProhibitedContent     FinishReason = "PROHIBITED_CONTENT"
+	FinishReasonSPII                  FinishReason = "SPII"
+	FinishReasonMalformedFunctionCall FinishReason = "MALFORMED_FUNCTION_CALL"
+)
+
+type PromptFeedback struct {
+	BlockReason string `json:"blockReason,omitempty"`
+}
+
+type UsageMetadata struct {
+	PromptTokenCount        int64 `json:"promptTokenCount"`
+	CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+	CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+	ThoughtsTokenCount      int64 `json:"thoughtsTokenCount"`
+	TotalTokenCount         int64 `json:"totalTokenCount"`
+}
+
+// Error is the error body returned by the Gemini API, both as a response and inside a stream.
+type Error struct {
+	Inner *InnerError `json:"error"`
+
+	statusCode int
+}
+
+type InnerError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("(%d) %s: %s", e.statusCode, e.Inner.Status, e.Inner.Message)
+}
+
+// Type maps the HTTP status to the closest Anthropic error type.
+func (e *Error) Type() string {
+	return anthropic.ErrorTypeForStatus(e.statusCode)
+}
+
+func (e *Error) Message() string { return e.Inner.Message }
+func (e *Error) Source() string  { return "gemini" }
+func (e *Error) StatusCode() int { return e.statusCode }
+
+func (e *Error) SetStatusCode(statusCode int) { e.statusCode = statusCode }
+
+// UnmarshalJSON keeps Inner non-nil, so that the accessors never have to check it.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	type plain Error
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	if e.Inner == nil {
+		e.Inner = &InnerError{}
+	}
+	if e.statusCode == 0 {
+		e.statusCode = e.Inner.Code
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package gemini
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestEncodeRequest(t *testing.T) {
+	temperature := 0.5
+	body, err := EncodeRequest(&anthropic.GenerateMessageRequest{
+		Model:       "gemini-2.5-pro",
+		MaxTokens:   1024,
+		Temperature: temperature,
+		System:      anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Be brief."}},
+		Thinking:    &anthropic.Thinking{Type: anthropic.ThinkingTypeEnabled, BudgetTokens: 512},
+		Tools: []*anthropic.Tool{
+			{Name: "get_weather", Description: "Get the weather", InputSchema: json.RawMessage(`{"type":"object","properties":{"city":{"type":"string"}}}`)},
+		},
+		ToolChoice: &anthropic.ToolChoice{Type: anthropic.ToolChoiceTypeTool, Name: "get_weather"},
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeText, Text: "Weather in Paris?"},
+				{Type: anthropic.MessageContentTypeImage, Source: &anthropic.MessageContentSource{Type: anthropic.MessageContentSourceTypeBase64, MediaType: "image/png", Data: "aGk="}},
+			}},
+			{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeThinking, Thinking: "Call the tool.", Signature: "sig-1"},
+				{Type: anthropic.MessageContentTypeToolUse, ID: "toolu_1", Name: "get_weather", Input: json.RawMessage(`{"city":"Paris"}`)},
+			}},
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{
+				{Type: anthropic.MessageContentTypeToolResult, ToolUseID: "toolu_1", Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "Sunny"}}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("EncodeRequest failed: %v", err)
+	}
+	for path, want := range map[string]string{
+		"model":                                                    "",
+		"systemInstruction.parts.0.text":                           "Be brief.",
+		"generationConfig.maxOutputTokens":                         "1024",
+		"generationConfig.temperature":                             "0.5",
+		"generationConfig.thinkingConfig.includeThoughts":          "true",
+		"generationConfig.thinkingConfig.thinkingBudget":           "512",
+		"tools.0.functionDeclarations.0.name":                      "get_weather",
+		"tools.0.functionDeclarations.0.parametersJsonSchema.type": "object",
+		"toolConfig.functionCallingConfig.mode":                    "ANY",
+		"toolConfig.functionCallingConfig.allowedFunctionNames.0":  "get_weather",
+		"contents.0.role":                                          "user",
+		"contents.0.parts.1.inlineData.mimeType":                   "image/png",
+		"contents.1.role":                                          "model",
+		"contents.1.parts.#":                                       "1",
+		"contents.1.parts.0.thoughtSignature":                      "sig-1",
+		"contents.1.parts.0.functionCall.args.city":                "Paris",
+		"contents.2.parts.0.functionResponse.name":                 "get_weather",
+		"contents.2.parts.0.functionResponse.response.content":     "Sunny",
+	} {
+		if got := gjson.Get(body, path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestNewAnthropicStream(t *testing.T) {
+	chunks := []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Let me check.","thought":true}]},"index":0}],"modelVersion":"gemini-2.5-pro","responseId":"resp-1","usageMetadata":{"promptTokenCount":20,"cachedContentTokenCount":5}}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Checking "},{"text":"now."}]},"index":0}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}},"thoughtSignature":"sig-1"}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":20,"cachedContentTokenCount":5,"candidatesTokenCount":7,"thoughtsTokenCount":3}}`,
+	}
+	stream := NewAnthropicStream(func(yield func(*GenerateContentResponse, error) bool) {
+		for _, data := range chunks {
+			var chunk *GenerateContentResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+	})
+	builder := anthropic.NewMessageBuilder()
+	for event, err := range stream {
+		if err != nil {
+			t.Fatalf("stream error: %v", err)
+		}
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder error: %v", err)
+		}
+	}
+	message := builder.Message()
+	if message.ID != "resp-1" || message.Model != "gemini-2.5-pro" {
+		t.Errorf("unexpected id/model: %q %q", message.ID, message.Model)
+	}
+	if len(message.Content) != 4 {
+		t.Fatalf("expected 4 content blocks, got %d: %+v", len(message.Content), message.Content)
+	}
+	if c := message.Content[0]; c.Type != anthropic.MessageContentTypeThinking || c.Thinking != "Let me check." || c.Signature != "" {
+		t.Errorf("unexpected thinking block: %+v", c)
+	}
+	if c := message.Content[1]; c.Type != anthropic.MessageContentTypeText || c.Text != "Checking now." {
+		t.Errorf("unexpected text block: %+v", c)
+	}
+	if c := message.Content[2]; c.Type != anthropic.MessageContentTypeThinking || c.Thinking != "" || c.Signature != "sig-1" {
+		t.Errorf("the signature should precede the function call: %+v", c)
+	}
+	if c := message.Content[3]; c.Type != anthropic.MessageContentTypeToolUse || c.Name != "get_weather" || c.ID == "" || gjson.GetBytes(c.Input, "city").String() != "Paris" {
+		t.Errorf("unexpected tool_use block: %+v", c)
+	}
+	if message.StopReason == nil || *message.StopReason != anthropic.StopReasonToolUse {
+		t.Errorf("unexpected stop reason: %v", message.StopReason)
+	}
+	if u := message.Usage; u.InputTokens != 15 || u.OutputTokens != 10 {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+}
+
+func TestConvertFinishReason(t *testing.T) {
+	for reason, want := range map[FinishReason]anthropic.StopReason{
+		FinishReasonStop:      anthropic.StopReasonEndTurn,
+		FinishReasonMaxTokens: anthropic.StopReasonMaxTokens,
+		FinishReasonSafety:    anthropic.StopReasonRefusal,
+		FinishReasonSPII:      anthropic.StopReasonRefusal,
+	} {
+		if got := convertFinishReason(reason); got != want {
+			t.Errorf("convertFinishReason(%s) = %s, want %s", reason, got, want)
+		}
+	}
+}
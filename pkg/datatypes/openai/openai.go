@@ -0,0 +1,563 @@
+// Package openai contains the subset of the OpenAI Responses API types used by the adapter.
+// reference: https://platform.openai.com/docs/api-reference/responses-streaming
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/utils"
+)
+
+type ResponseStream = iter.Seq2[Event, error]
+
+// CreateModelResponseRequest is the body of a streaming POST /v1/responses request.
+type CreateModelResponseRequest struct {
+	Model             string               `json:"model"`
+	Instructions      string               `json:"instructions,omitempty"`
+	Input             []*ResponseInputItem `json:"input"`
+	Tools             []*ResponseTool      `json:"tools,omitempty"`
+	ToolChoice        *ResponseToolChoice  `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	MaxOutputTokens   *int                 `json:"max_output_tokens,omitempty"`
+	Temperature       *float64             `json:"temperature,omitempty"`
+	TopP              *float64             `json:"top_p,omitempty"`
+	Reasoning         *ResponseReasoning   `json:"reasoning,omitempty"`
+	Include           []string             `json:"include,omitempty"`
+	Metadata          map[string]string    `json:"metadata,omitempty"`
+	User              string               `json:"user,omitempty"`
+	PromptCacheKey    string               `json:"prompt_cache_key,omitempty"`
+	ServiceTier       string               `json:"service_tier,omitempty"`
+	Store             bool                 `json:"store"`
+	Stream            utils.True           `json:"stream"`
+}
+
+// IncludeReasoningEncryptedContent asks for the encrypted content of reasoning items, which is
+// how reasoning is carried over between turns when responses are not stored.
+const IncludeReasoningEncryptedContent = "reasoning.encrypted_content"
+
+type ResponseToolType string
+
+const (
+	ResponseToolTypeFunction ResponseToolType = "function"
+)
+
+type ResponseTool struct {
+	Type        ResponseToolType `json:"type"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Parameters  json.RawMessage  `json:"parameters,omitempty"`
+	Strict      bool             `json:"strict"`
+}
+
+type ResponseReasoningEffort string
+
+const (
+	ResponseReasoningEffortMinimal ResponseReasoningEffort = "minimal"
+	ResponseReasoningEffortLow     ResponseReasoningEffort = "low"
+	ResponseReasoningEffortMedium  ResponseReasoningEffort = "medium"
+	ResponseReasoningEffortHigh    ResponseReasoningEffort = "high"
+)
+
+type ResponseReasoningSummaryMode string
+
+const (
+	ResponseReasoningSummaryModeAuto ResponseReasoningSummaryMode = "auto"
+)
+
+type ResponseReasoning struct {
+	Effort  ResponseReasoningEffort      `json:"effort,omitempty"`
+	Summary ResponseReasoningSummaryMode `json:"summary,omitempty"`
+}
+
+// Error is the body of an error response of the OpenAI API.
+type Error struct {
+	Inner *InnerError `json:"error"`
+
+	statusCode int
+}
+
+type InnerError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Param   string `json:"param,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("(%d) %s: %s", e.statusCode, e.Inner.Type, e.Inner.Message)
+}
+
+// Type maps the HTTP status to the closest Anthropic error type.
+func (e *Error) Type() string {
+	return anthropic.ErrorTypeForStatus(e.statusCode)
+}
+
+func (e *Error) Message() string { return e.Inner.Message }
+func (e *Error) Source() string  { return "openai" }
+func (e *Error) StatusCode() int { return e.statusCode }
+
+func (e *Error) SetStatusCode(statusCode int) { e.statusCode = statusCode }
+
+// UnmarshalJSON keeps Inner non-nil, so that the accessors never have to check it.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	type plain Error
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	if e.Inner == nil {
+		e.Inner = &InnerError{}
+	}
+	return nil
+}
+
+type ResponseStatus string
+
+const (
+	ResponseStatusCompleted  ResponseStatus = "completed"
+	ResponseStatusFailed     ResponseStatus = "failed"
+	ResponseStatusInProgress ResponseStatus = "in_progress"
+	ResponseStatusCancelled  ResponseStatus = "cancelled"
+	ResponseStatusQueued     ResponseStatus = "queued"
+	ResponseStatusIncomplete ResponseStatus = "incomplete"
+)
+
+type Response struct {
+	ID                string                     `json:"id"`
+	Object            string                     `json:"object"`
+	CreatedAt         int64                      `json:"created_at"`
+	Model             string                     `json:"model"`
+	Status            ResponseStatus             `json:"status"`
+	ServiceTier       string                     `json:"service_tier,omitempty"`
+	Output            []*ResponseOutputItem      `json:"output"`
+	Usage             *ResponseUsage             `json:"usage,omitempty"`
+	IncompleteDetails *ResponseIncompleteDetails `json:"incomplete_details,omitempty"`
+	Error             *ResponseError             `json:"error,omitempty"`
+}
+
+type ResponseIncompleteReason string
+
+const (
+	ResponseIncompleteReasonMaxOutputTokens ResponseIncompleteReason = "max_output_tokens"
+	ResponseIncompleteReasonContentFilter   ResponseIncompleteReason = "content_filter"
+)
+
+type ResponseIncompleteDetails struct {
+	Reason ResponseIncompleteReason `json:"reason"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+type ResponseInputItemType string
+
+const (
+	ResponseInputItemTypeMessage            ResponseInputItemType = "message"
+	ResponseInputItemTypeFunctionCall       ResponseInputItemType = "function_call"
+	ResponseInputItemTypeFunctionCallOutput ResponseInputItemType = "function_call_output"
+	ResponseInputItemTypeReasoning          ResponseInputItemType = "reasoning"
+)
+
+// ResponseInputItem is an item of the Responses API request input.
+type ResponseInputItem struct {
+	Type ResponseInputItemType `json:"type"`
+	ID   string                `json:"id,omitempty"`
+
+	// message
+	Role    string                  `json:"role,omitempty"`
+	Content []*ResponseInputContent `json:"content,omitempty"`
+
+	// function_call and function_call_output; the Responses API only accepts a string output.
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    string `json:"output,omitempty"`
+
+	// reasoning
+	Summary          []*ResponseReasoningSummary `json:"summary,omitempty"`
+	EncryptedContent string                      `json:"encrypted_content,omitempty"`
+}
+
+// MarshalJSON always writes the summary of reasoning items, which the Responses API requires even
+// when it is empty.
+func (item *ResponseInputItem) MarshalJSON() ([]byte, error) {
+	type plain ResponseInputItem
+	if item.Type != ResponseInputItemTypeReasoning {
+		return json.Marshal((*plain)(item))
+	}
+	summary := item.Summary
+	if summary == nil {
+		summary = []*ResponseReasoningSummary{}
+	}
+	return json.Marshal(&struct {
+		*plain
+		Summary []*ResponseReasoningSummary `json:"summary"`
+	}{
+		plain:   (*plain)(item),
+		Summary: summary,
+	})
+}
+
+type ResponseInputContentType string
+
+const (
+	ResponseInputContentTypeInputText  ResponseInputContentType = "input_text"
+	ResponseInputContentTypeInputImage ResponseInputContentType = "input_image"
+	ResponseInputContentTypeInputFile  ResponseInputContentType = "input_file"
+	ResponseInputContentTypeOutputText ResponseInputContentType = "output_text"
+)
+
+type ResponseInputContent struct {
+	Type     ResponseInputContentType `json:"type"`
+	Text     string                   `json:"text,omitempty"`
+	ImageURL string                   `json:"image_url,omitempty"`
+	Detail   string                   `json:"detail,omitempty"`
+
+	// input_file
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	FileURL  string `json:"file_url,omitempty"`
+}
+
+type ResponseToolChoiceType string
+
+const (
+	ResponseToolChoiceTypeFunction     ResponseToolChoiceType = "function"
+	ResponseToolChoiceTypeAllowedTools ResponseToolChoiceType = "allowed_tools"
+)
+
+type ResponseToolChoiceMode string
+
+const (
+	ResponseToolChoiceModeNone     ResponseToolChoiceMode = "none"
+	ResponseToolChoiceModeAuto     ResponseToolChoiceMode = "auto"
+	ResponseToolChoiceModeRequired ResponseToolChoiceMode = "required"
+)
+
+// ResponseToolChoice is the tool_choice of a Responses API request. A choice without a Type is
+// marshaled as its bare Mode string.
+type ResponseToolChoice struct {
+	Type ResponseToolChoiceType `json:"type"`
+	Mode ResponseToolChoiceMode `json:"mode,omitempty"`
+
+	// function
+	Name string `json:"name,omitempty"`
+
+	// allowed_tools
+	Tools []*ResponseToolChoiceAllowedTool `json:"tools,omitempty"`
+}
+
+func (c *ResponseToolChoice) MarshalJSON() ([]byte, error) {
+	if c.Type == "" {
+		return json.Marshal(c.Mode)
+	}
+	type choice ResponseToolChoice
+	return json.Marshal((*choice)(c))
+}
+
+// ResponseToolChoiceAllowedTool is a tool the model may call under an allowed_tools choice.
+type ResponseToolChoiceAllowedTool struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type ResponseOutputItemType string
+
+const (
+	ResponseOutputItemTypeMessage      ResponseOutputItemType = "message"
+	ResponseOutputItemTypeReasoning    ResponseOutputItemType = "reasoning"
+	ResponseOutputItemTypeFunctionCall ResponseOutputItemType = "function_call"
+)
+
+type ResponseOutputItem struct {
+	Type   ResponseOutputItemType `json:"type"`
+	ID     string                 `json:"id"`
+	Status ResponseStatus         `json:"status,omitempty"`
+
+	// message
+	Role    string                   `json:"role,omitempty"`
+	Content []*ResponseOutputContent `json:"content,omitempty"`
+
+	// function_call
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+
+	// reasoning
+	Summary          []*ResponseReasoningSummary `json:"summary,omitempty"`
+	EncryptedContent string                      `json:"encrypted_content,omitempty"`
+}
+
+type ResponseOutputContentType string
+
+const (
+	ResponseOutputContentTypeOutputText    ResponseOutputContentType = "output_text"
+	ResponseOutputContentTypeRefusal       ResponseOutputContentType = "refusal"
+	ResponseOutputContentTypeReasoningText ResponseOutputContentType = "reasoning_text"
+)
+
+type ResponseOutputContent struct {
+	Type    ResponseOutputContentType `json:"type"`
+	Text    string                    `json:"text,omitempty"`
+	Refusal string                    `json:"refusal,omitempty"`
+}
+
+// ResponseReasoningSummaryTypeSummaryText is the type of the summary parts of reasoning items.
+const ResponseReasoningSummaryTypeSummaryText = "summary_text"
+
+type ResponseReasoningSummary struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ResponseUsage struct {
+	InputTokens         int64                       `json:"input_tokens"`
+	InputTokensDetails  *ResponseInputTokensDetails `json:"input_tokens_details,omitempty"`
+	OutputTokens        int64                       `json:"output_tokens"`
+	OutputTokensDetails *ResponseOutputTokenDetails `json:"output_tokens_details,omitempty"`
+	TotalTokens         int64                       `json:"total_tokens"`
+}
+
+type ResponseInputTokensDetails struct {
+	CachedTokens int64 `json:"cached_tokens"`
+}
+
+type ResponseOutputTokenDetails struct {
+	ReasoningTokens int64 `json:"reasoning_tokens"`
+}
+
+type EventType string
+
+const (
+	EventTypeError                              EventType = "error"
+	EventTypeResponseCreated                    EventType = "response.created"
+	EventTypeResponseInProgress                 EventType = "response.in_progress"
+	EventTypeResponseCompleted                  EventType = "response.completed"
+	EventTypeResponseIncomplete                 EventType = "response.incomplete"
+	EventTypeResponseFailed                     EventType = "response.failed"
+	EventTypeResponseOutputItemAdded            EventType = "response.output_item.added"
+	EventTypeResponseOutputItemDone             EventType = "response.output_item.done"
+	EventTypeResponseOutputTextDelta            EventType = "response.output_text.delta"
+	EventTypeResponseReasoningTextDelta         EventType = "response.reasoning_text.delta"
+	EventTypeResponseReasoningSummaryTextDelta  EventType = "response.reasoning_summary_text.delta"
+	EventTypeResponseFunctionCallArgumentsDelta EventType = "response.function_call_arguments.delta"
+	EventTypeResponseRefusalDelta               EventType = "response.refusal.delta"
+	EventTypeResponseRefusalDone                EventType = "response.refusal.done"
+)
+
+type Event interface {
+	EventType() EventType
+}
+
+var (
+	_ Event = (*ErrorEvent)(nil)
+	_ Event = (*ResponseCreatedEvent)(nil)
+	_ Event = (*ResponseInProgressEvent)(nil)
+	_ Event = (*ResponseCompletedEvent)(nil)
+	_ Event = (*ResponseIncompleteEvent)(nil)
+	_ Event = (*ResponseFailedEvent)(nil)
+	_ Event = (*ResponseOutputItemAddedEvent)(nil)
+	_ Event = (*ResponseOutputItemDoneEvent)(nil)
+	_ Event = (*ResponseOutputTextDeltaEvent)(nil)
+	_ Event = (*ResponseReasoningTextDeltaEvent)(nil)
+	_ Event = (*ResponseReasoningSummaryTextDeltaEvent)(nil)
+	_ Event = (*ResponseFunctionCallArgumentsDeltaEvent)(nil)
+	_ Event = (*ResponseRefusalDeltaEvent)(nil)
+	_ Event = (*ResponseRefusalDoneEvent)(nil)
+	_ Event = (*UnknownEvent)(nil)
+)
+
+type (
+	ErrorEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		Code           string    `json:"code"`
+		Message        string    `json:"message"`
+		Param          string    `json:"param,omitempty"`
+	}
+	ResponseCreatedEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		Response       *Response `json:"response"`
+	}
+	ResponseInProgressEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		Response       *Response `json:"response"`
+	}
+	ResponseCompletedEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		Response       *Response `json:"response"`
+	}
+	ResponseIncompleteEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		Response       *Response `json:"response"`
+	}
+	ResponseFailedEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		Response       *Response `json:"response"`
+	}
+	ResponseOutputItemAddedEvent struct {
+		Type           EventType           `json:"type"`
+		SequenceNumber int64               `json:"sequence_number"`
+		OutputIndex    int                 `json:"output_index"`
+		Item           *ResponseOutputItem `json:"item"`
+	}
+	ResponseOutputItemDoneEvent struct {
+		Type           EventType           `json:"type"`
+		SequenceNumber int64               `json:"sequence_number"`
+		OutputIndex    int                 `json:"output_index"`
+		Item           *ResponseOutputItem `json:"item"`
+	}
+	ResponseOutputTextDeltaEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		ItemID         string    `json:"item_id"`
+		OutputIndex    int       `json:"output_index"`
+		ContentIndex   int       `json:"content_index"`
+		Delta          string    `json:"delta"`
+	}
+	ResponseReasoningTextDeltaEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		ItemID         string    `json:"item_id"`
+		OutputIndex    int       `json:"output_index"`
+		ContentIndex   int       `json:"content_index"`
+		Delta          string    `json:"delta"`
+	}
+	ResponseReasoningSummaryTextDeltaEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		ItemID         string    `json:"item_id"`
+		OutputIndex    int       `json:"output_index"`
+		SummaryIndex   int       `json:"summary_index"`
+		Delta          string    `json:"delta"`
+	}
+	ResponseFunctionCallArgumentsDeltaEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		ItemID         string    `json:"item_id"`
+		OutputIndex    int       `json:"output_index"`
+		Delta          string    `json:"delta"`
+	}
+	ResponseRefusalDeltaEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		ItemID         string    `json:"item_id"`
+		OutputIndex    int       `json:"output_index"`
+		ContentIndex   int       `json:"content_index"`
+		Delta          string    `json:"delta"`
+	}
+	ResponseRefusalDoneEvent struct {
+		Type           EventType `json:"type"`
+		SequenceNumber int64     `json:"sequence_number"`
+		ItemID         string    `json:"item_id"`
+		OutputIndex    int       `json:"output_index"`
+		ContentIndex   int       `json:"content_index"`
+		Refusal        string    `json:"refusal"`
+	}
+	// UnknownEvent holds events the adapter does not interpret, e.g. response.content_part.added.
+	UnknownEvent struct {
+		Type EventType       `json:"type"`
+		Raw  json.RawMessage `json:"-"`
+	}
+)
+
+func (event ErrorEvent) EventType() EventType              { return EventTypeError }
+func (event ResponseCreatedEvent) EventType() EventType    { return EventTypeResponseCreated }
+func (event ResponseInProgressEvent) EventType() EventType { return EventTypeResponseInProgress }
+func (event ResponseCompletedEvent) EventType() EventType  { return EventTypeResponseCompleted }
+func (event ResponseIncompleteEvent) EventType() EventType { return EventTypeResponseIncomplete }
+func (event ResponseFailedEvent) EventType() EventType     { return EventTypeResponseFailed }
+func (event ResponseOutputItemAddedEvent) EventType() EventType {
+	return EventTypeResponseOutputItemAdded
+}
+func (event ResponseOutputItemDoneEvent) EventType() EventType {
+	return EventTypeResponseOutputItemDone
+}
+func (event ResponseOutputTextDeltaEvent) EventType() EventType {
+	return EventTypeResponseOutputTextDelta
+}
+func (event ResponseReasoningTextDeltaEvent) EventType() EventType {
+	return EventTypeResponseReasoningTextDelta
+}
+func (event ResponseReasoningSummaryTextDeltaEvent) EventType() EventType {
+	return EventTypeResponseReasoningSummaryTextDelta
+}
+func (event ResponseFunctionCallArgumentsDeltaEvent) EventType() EventType {
+	return EventTypeResponseFunctionCallArgumentsDelta
+}
+func (event ResponseRefusalDeltaEvent) EventType() EventType {
+	return EventTypeResponseRefusalDelta
+}
+func (event ResponseRefusalDoneEvent) EventType() EventType {
+	return EventTypeResponseRefusalDone
+}
+func (event UnknownEvent) EventType() EventType { return event.Type }
+
+func (event *ErrorEvent) Error() string {
+	return (&ResponseError{Code: event.Code, Message: event.Message}).Error()
+}
+
+// UnmarshalEvent decodes a single streaming event according to its "type" field.
+// Events of unsupported types are returned as *UnknownEvent.
+func UnmarshalEvent(data []byte) (Event, error) {
+	var header struct {
+		Type EventType `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	var event Event
+	switch header.Type {
+	case EventTypeError:
+		event = &ErrorEvent{}
+	case EventTypeResponseCreated:
+		event = &ResponseCreatedEvent{}
+	case EventTypeResponseInProgress:
+		event = &ResponseInProgressEvent{}
+	case EventTypeResponseCompleted:
+		event = &ResponseCompletedEvent{}
+	case EventTypeResponseIncomplete:
+		event = &ResponseIncompleteEvent{}
+	case EventTypeResponseFailed:
+		event = &ResponseFailedEvent{}
+	case EventTypeResponseOutputItemAdded:
+		event = &ResponseOutputItemAddedEvent{}
+	case EventTypeResponseOutputItemDone:
+		event = &ResponseOutputItemDoneEvent{}
+	case EventTypeResponseOutputTextDelta:
+		event = &ResponseOutputTextDeltaEvent{}
+	case EventTypeResponseReasoningTextDelta:
+		event = &ResponseReasoningTextDeltaEvent{}
+	case EventTypeResponseReasoningSummaryTextDelta:
+		event = &ResponseReasoningSummaryTextDeltaEvent{}
+	case EventTypeResponseFunctionCallArgumentsDelta:
+		event = &ResponseFunctionCallArgumentsDeltaEvent{}
+	case EventTypeResponseRefusalDelta:
+		event = &ResponseRefusalDeltaEvent{}
+	case EventTypeResponseRefusalDone:
+		event = &ResponseRefusalDoneEvent{}
+	default:
+		return &UnknownEvent{Type: header.Type, Raw: slices.Clone(data)}, nil
+	}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,118 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnmarshalEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		check func(t *testing.T, event Event)
+	}{
+		{
+			name: "response.created",
+			data: `{"type":"response.created","sequence_number":0,"response":{"id":"resp_1","model":"gpt-5","status":"in_progress","output":[]}}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*ResponseCreatedEvent)
+				if !ok || e.Response == nil || e.Response.ID != "resp_1" || e.Response.Status != ResponseStatusInProgress {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+		{
+			name: "response.output_item.added function_call",
+			data: `{"type":"response.output_item.added","output_index":1,"item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":""}}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*ResponseOutputItemAddedEvent)
+				if !ok || e.OutputIndex != 1 || e.Item.Type != ResponseOutputItemTypeFunctionCall || e.Item.CallID != "call_1" {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+		{
+			name: "response.reasoning_summary_text.delta",
+			data: `{"type":"response.reasoning_summary_text.delta","item_id":"rs_1","output_index":0,"summary_index":2,"delta":"hmm"}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*ResponseReasoningSummaryTextDeltaEvent)
+				if !ok || e.SummaryIndex != 2 || e.Delta != "hmm" {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+		{
+			name: "response.completed usage",
+			data: `{"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":10,"input_tokens_details":{"cached_tokens":4},"output_tokens":5,"total_tokens":15}}}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*ResponseCompletedEvent)
+				if !ok || e.Response.Usage == nil || e.Response.Usage.InputTokensDetails.CachedTokens != 4 || e.Response.Usage.TotalTokens != 15 {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+		{
+			name: "response.refusal.done",
+			data: `{"type":"response.refusal.done","item_id":"msg_1","output_index":0,"content_index":0,"refusal":"I can't help with that."}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*ResponseRefusalDoneEvent)
+				if !ok || e.ItemID != "msg_1" || e.Refusal != "I can't help with that." {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+		{
+			name: "error",
+			data: `{"type":"error","code":"server_error","message":"boom"}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*ErrorEvent)
+				if !ok || e.Error() != "server_error: boom" {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+		{
+			name: "unknown type",
+			data: `{"type":"response.content_part.added","item_id":"msg_1"}`,
+			check: func(t *testing.T, event Event) {
+				e, ok := event.(*UnknownEvent)
+				if !ok || e.EventType() != "response.content_part.added" || len(e.Raw) == 0 {
+					t.Fatalf("unexpected event: %#v", event)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := UnmarshalEvent([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("UnmarshalEvent failed: %v", err)
+			}
+			tt.check(t, event)
+		})
+	}
+}
+
+func TestUnmarshalEvent_InvalidJSON(t *testing.T) {
+	if _, err := UnmarshalEvent([]byte(`{"type":`)); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+}
+
+func TestResponseInputItem_MarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		item *ResponseInputItem
+		want string
+	}{
+		{&ResponseInputItem{Type: ResponseInputItemTypeReasoning, ID: "rs_1", EncryptedContent: "ENCRYPTED"}, `{"type":"reasoning","id":"rs_1","encrypted_content":"ENCRYPTED","summary":[]}`},
+		{&ResponseInputItem{Type: ResponseInputItemTypeFunctionCallOutput, CallID: "call_1", Output: "ok"}, `{"type":"function_call_output","call_id":"call_1","output":"ok"}`},
+	} {
+		got, err := json.Marshal(tc.item)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("marshal = %s, want %s", got, tc.want)
+		}
+	}
+}
@@ -21,7 +21,7 @@ import (
 )
 
 func init() {
-	viper.SetDefault(delimiter.ViperKey("openrouter", "allowed_providers"), []string{ProviderAnthropic})
+	viper.SetDefault(delimiter.ViperKey("openrouter", "preferred_providers"), []Provider{ProviderAnthropic})
 }
 
 func WithIdentity(referer string, title string) func(*http.Request) {
@@ -31,22 +31,149 @@ func WithIdentity(referer string, title string) func(*http.Request) {
 	}
 }
 
+// WithProviderPreference replaces the provider preference of the request body with pref.
 func WithProviderPreference(pref *ProviderPreference) func(*http.Request) {
 	return func(req *http.Request) {
-		if req.GetBody != nil {
-			if r, err := req.GetBody(); err == nil {
-				var data *CreateChatCompletionRequest
-				if err = json.NewDecoder(r).Decode(&data); err == nil {
-					data.Provider = pref
-					if newBody, err := json.Marshal(data); err == nil {
-						if oldBody := req.Body; oldBody != nil {
-							oldBody.Close()
-						}
-						req.ContentLength = int64(len(newBody))
-						req.Body = io.NopCloser(bytes.NewReader(newBody))
-						req.GetBody = func() (io.ReadCloser, error) {
-							return io.NopCloser(bytes.NewReader(newBody)), nil
-						}
+		rewriteChatCompletionRequest(req, func(data *CreateChatCompletionRequest) {
+			data.Provider = pref
+		})
+	}
+}
+
+// WithMergedProviderPreference merges pref into the provider preference already present
+// in the request body, so that several sources can each add their own constraints.
+// See MergeProviderPreference for the merge semantics.
+func WithMergedProviderPreference(pref *ProviderPreference) func(*http.Request) {
+	return func(req *http.Request) {
+		rewriteChatCompletionRequest(req, func(data *CreateChatCompletionRequest) {
+			data.Provider = MergeProviderPreference(data.Provider, pref)
+		})
+	}
+}
+
+// TransformMiddleOut is the OpenRouter transform that compresses prompts exceeding the
+// model's context window by removing messages from the middle of the conversation.
+//
+// reference: https://openrouter.ai/docs/features/message-transforms
+const TransformMiddleOut = "middle-out"
+
+// WithTransforms sets the message transforms of the request body. An empty list leaves
+// the request untouched, so OpenRouter applies its per-model defaults.
+func WithTransforms(transforms []string) func(*http.Request) {
+	return func(req *http.Request) {
+		if len(transforms) == 0 {
+			return
+		}
+		rewriteChatCompletionRequest(req, func(data *CreateChatCompletionRequest) {
+			data.Transforms = transforms
+		})
+	}
+}
+
+// WithFallbackModels sets the models OpenRouter tries, in order, when the primary model of the
+// request is unavailable. The primary model stays in the model field and is not repeated in the
+// list; an empty list leaves the request untouched.
+//
+// reference: https://openrouter.ai/docs/features/model-routing
+func WithFallbackModels(models []string) func(*http.Request) {
+	return func(req *http.Request) {
+		if len(models) == 0 {
+			return
+		}
+		rewriteChatCompletionRequest(req, func(data *CreateChatCompletionRequest) {
+			data.Models = slices.DeleteFunc(slices.Clone(models), func(model string) bool {
+				return model == data.Model
+			})
+		})
+	}
+}
+
+// MergeProviderPreference returns a new ProviderPreference combining base and overlay:
+//   - Order is extended with the overlay providers not already listed;
+//   - Only is intersected (an empty side places no restriction; if the intersection
+//     is empty, the base list is kept, so that a source merged later, like a request-level
+//     constraint, can narrow an earlier restriction, like the profile's, but never replace it);
+//   - Ignore is the union of both lists;
+//   - Sort and the remaining scalar fields are overridden only when set in overlay.
+func MergeProviderPreference(base, overlay *ProviderPreference) *ProviderPreference {
+	if base == nil && overlay == nil {
+		return nil
+	}
+	merged := &ProviderPreference{}
+	if base != nil {
+		*merged = *base
+		merged.Order = slices.Clone(base.Order)
+		merged.Only = slices.Clone(base.Only)
+		merged.Ignore = slices.Clone(base.Ignore)
+	}
+	if overlay == nil {
+		return merged
+	}
+	for _, p := range overlay.Order {
+		if !slices.Contains(merged.Order, p) {
+			merged.Order = append(merged.Order, p)
+		}
+	}
+	switch {
+	case len(overlay.Only) == 0:
+	case len(merged.Only) == 0:
+		merged.Only = slices.Clone(overlay.Only)
+	default:
+		only := make([]Provider, 0, len(merged.Only))
+		for _, p := range merged.Only {
+			if slices.Contains(overlay.Only, p) {
+				only = append(only, p)
+			}
+		}
+		if len(only) > 0 {
+			merged.Only = only
+		}
+	}
+	for _, p := range overlay.Ignore {
+		if !slices.Contains(merged.Ignore, p) {
+			merged.Ignore = append(merged.Ignore, p)
+		}
+	}
+	if overlay.Sort != nil && *overlay.Sort != "" {
+		merged.Sort = overlay.Sort
+	}
+	if overlay.AllowFallbacks != nil {
+		merged.AllowFallbacks = overlay.AllowFallbacks
+	}
+	if overlay.RequireParameters != nil {
+		merged.RequireParameters = overlay.RequireParameters
+	}
+	if overlay.DataCollection != nil {
+		merged.DataCollection = overlay.DataCollection
+	}
+	if len(overlay.Quantizations) > 0 {
+		merged.Quantizations = overlay.Quantizations
+	}
+	if overlay.MaxPrice != nil {
+		merged.MaxPrice = overlay.MaxPrice
+	}
+	if overlay.Experimental != nil {
+		merged.Experimental = overlay.Experimental
+	}
+	return merged
+}
+
+// rewriteChatCompletionRequest decodes the request body, applies fn and re-encodes it.
+// The request is left untouched if the body cannot be read or decoded.
+func rewriteChatCompletionRequest(req *http.Request, fn func(*CreateChatCompletionRequest)) {
+	if req.GetBody != nil {
+		if r, err := req.GetBody(); err == nil {
+			var data *CreateChatCompletionRequest
+			if err = json.NewDecoder(r).Decode(&data); err == nil {
+				fn(data)
+				if newBody, err := json.Marshal(data); err == nil {
+					if oldBody := req.Body; oldBody != nil {
+						oldBody.Close()
+					}
+					req.ContentLength = int64(len(newBody))
+					req.Body = io.NopCloser(bytes.NewReader(newBody))
+					req.GetBody = func() (io.ReadCloser, error) {
+						return io.NopCloser(bytes.NewReader(newBody)), nil
 					}
 				}
 			}
@@ -60,11 +187,14 @@ func WithAnthropicBetaFeatures(oriHeader http.Header) func(*http.Request) {
 		for _, features := range oriHeader.Values(anthropic.HeaderBeta) {
 			if features != "" {
 				for feature := range strings.SplitSeq(features, ",") {
-					switch strings.ToLower(strings.TrimSpace(feature)) {
+					feature = strings.TrimSpace(feature)
+					switch strings.ToLower(feature) {
 					case anthropic.BetaFeatureFineGrainedToolStreaming20250514:
 						featSet[feature] = struct{}{}
 					case anthropic.BetaFeatureInterleavedThinking20250514:
 						featSet[feature] = struct{}{}
+					case anthropic.BetaFeatureContext1M20250807:
+						featSet[feature] = struct{}{}
 					}
 				}
 			}
@@ -102,18 +232,10 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("(%d) %s", e.Inner.Code, e.Inner.Message)
 }
 
+// Type maps the error code, which OpenRouter sets to the upstream HTTP status, to the closest
+// Anthropic error type.
 func (e *Error) Type() string {
-	switch e.Inner.Code / 100 {
-	case 4:
-		if e.Inner.Code == 429 {
-			return "overloaded_error"
-		}
-		return "invalid_request_error"
-	case 5:
-		return "internal_server_error"
-	default:
-		return "unknown_error"
-	}
+	return anthropic.ErrorTypeForStatus(e.Inner.Code)
 }
 
 func (e *Error) Message() string              { return e.Inner.Message }
@@ -141,26 +263,121 @@ type CreateChatCompletionRequest struct {
 	TopP              *float64                       `json:"top_p,omitempty"`
 	TopK              *int                           `json:"top_k,omitempty"`
 	User              string                         `json:"user,omitempty"`
+	Metadata          map[string]string              `json:"metadata,omitempty"`
+	LogitBias         map[string]int                 `json:"logit_bias,omitempty"`
 	Stream            utils.True                     `json:"stream"`
 	Provider          *ProviderPreference            `json:"provider,omitempty"`
 	Usage             *ChatCompletionUsageOptions    `json:"usage,omitempty"`
+	Transforms        []string                       `json:"transforms,omitempty"`
+	Models            []string                       `json:"models,omitempty"`
+	ServiceTier       ServiceTier                    `json:"service_tier,omitempty"`
+	Plugins           []*ChatCompletionPlugin        `json:"plugins,omitempty"`
+}
+
+// ChatCompletionPlugin enables an OpenRouter plugin for a request, such as web search.
+//
+// reference: https://openrouter.ai/docs/features/web-search
+type ChatCompletionPlugin struct {
+	ID         ChatCompletionPluginID `json:"id"`
+	MaxResults int                    `json:"max_results,omitempty"`
+}
+
+type ChatCompletionPluginID string
+
+const (
+	ChatCompletionPluginIDWeb ChatCompletionPluginID = "web"
+)
+
+// ServiceTier selects the processing tier of providers that offer one, such as OpenAI.
+type ServiceTier string
+
+const (
+	ServiceTierAuto     ServiceTier = "auto"
+	ServiceTierDefault  ServiceTier = "default"
+	ServiceTierFlex     ServiceTier = "flex"
+	ServiceTierPriority ServiceTier = "priority"
+)
+
+// IsKnown reports whether t is one of the supported service tiers.
+func (t ServiceTier) IsKnown() bool {
+	switch t {
+	case ServiceTierAuto, ServiceTierDefault, ServiceTierFlex, ServiceTierPriority:
+		return true
+	}
+	return false
 }
 
+// Provider is an OpenRouter provider slug, as used in ProviderPreference.
+type Provider string
+
 const (
-	ProviderGoogleVertex       = "google-vertex"
-	ProviderGoogleVertexGlobal = "google-vertex/global"
-	ProviderGoogleVertexEurope = "google-vertex/europe"
-	ProviderAnthropic          = "anthropic"
-	ProviderAmazonBedrock      = "amazon-bedrock"
+	ProviderAnthropic          Provider = "anthropic"
+	ProviderOpenAI             Provider = "openai"
+	ProviderAzure              Provider = "azure"
+	ProviderGoogleVertex       Provider = "google-vertex"
+	ProviderGoogleVertexGlobal Provider = "google-vertex/global"
+	ProviderGoogleVertexEurope Provider = "google-vertex/europe"
+	ProviderGoogleAIStudio     Provider = "google-ai-studio"
+	ProviderAmazonBedrock      Provider = "amazon-bedrock"
+	ProviderDeepSeek           Provider = "deepseek"
+	ProviderMistral            Provider = "mistral"
+	ProviderXAI                Provider = "xai"
+	ProviderGroq               Provider = "groq"
+	ProviderTogether           Provider = "together"
+	ProviderFireworks          Provider = "fireworks"
+	ProviderDeepInfra          Provider = "deepinfra"
 )
 
+var knownProviders = map[Provider]struct{}{
+	ProviderAnthropic:          {},
+	ProviderOpenAI:             {},
+	ProviderAzure:              {},
+	ProviderGoogleVertex:       {},
+	ProviderGoogleVertexGlobal: {},
+	ProviderGoogleVertexEurope: {},
+	ProviderGoogleAIStudio:     {},
+	ProviderAmazonBedrock:      {},
+	ProviderDeepSeek:           {},
+	ProviderMistral:            {},
+	ProviderXAI:                {},
+	ProviderGroq:               {},
+	ProviderTogether:           {},
+	ProviderFireworks:          {},
+	ProviderDeepInfra:          {},
+}
+
+// IsKnown reports whether p is one of the provider constants defined in this package.
+// Unknown providers are still sent to OpenRouter as-is.
+func (p Provider) IsKnown() bool {
+	_, ok := knownProviders[p]
+	return ok
+}
+
+// providersWithoutTopK are the known providers whose APIs have no top_k parameter. Anthropic,
+// Google (Vertex and AI Studio), Amazon Bedrock, Together, Fireworks and DeepInfra honor top_k.
+var providersWithoutTopK = map[Provider]struct{}{
+	ProviderOpenAI:   {},
+	ProviderAzure:    {},
+	ProviderDeepSeek: {},
+	ProviderMistral:  {},
+	ProviderXAI:      {},
+	ProviderGroq:     {},
+}
+
+// SupportsTopK reports whether p honors the top_k sampling parameter. Unknown providers are
+// assumed to support it, in which case OpenRouter drops it if they do not.
+func (p Provider) SupportsTopK() bool {
+	_, unsupported := providersWithoutTopK[p]
+	return !unsupported
+}
+
 type ProviderPreference struct {
-	Order             []string                      `json:"order,omitempty"`
+	Order             []Provider                    `json:"order,omitempty"`
 	AllowFallbacks    *bool                         `json:"allow_fallbacks,omitempty"`
 	RequireParameters *bool                         `json:"require_parameters,omitempty"`
 	DataCollection    *ProviderDataCollectionPolicy `json:"data_collection,omitempty"`
-	Only              []string                      `json:"only,omitempty"`
-	Ignore            []string                      `json:"ignore,omitempty"`
+	Only              []Provider                    `json:"only,omitempty"`
+	Ignore            []Provider                    `json:"ignore,omitempty"`
 	Quantizations     []ProviderQuantizationLevel   `json:"quantizations,omitempty"`
 	Sort              *ProviderSortMethod           `json:"sort,omitempty"`
 	MaxPrice          *ProviderMaxPrice             `json:"max_price,omitempty"`
@@ -196,6 +413,15 @@ const (
 	ProviderSortMethodLatency    ProviderSortMethod = "latency"
 )
 
+// IsKnown reports whether m is one of the sort methods supported by OpenRouter.
+func (m ProviderSortMethod) IsKnown() bool {
+	switch m {
+	case ProviderSortMethodPrice, ProviderSortMethodThroughput, ProviderSortMethodLatency:
+		return true
+	}
+	return false
+}
+
 type ProviderMaxPrice struct {
 	Prompt     ProviderMaxPriceValue `json:"prompt,omitempty"`
 	Completion ProviderMaxPriceValue `json:"completion,omitempty"`
@@ -278,6 +504,30 @@ type ChatCompletionMessage struct {
 	ToolCalls        []*ChatCompletionToolCall               `json:"tool_calls,omitempty"`
 	Reasoning        string                                  `json:"reasoning,omitempty"`
 	ReasoningDetails []*ChatCompletionMessageReasoningDetail `json:"reasoning_details,omitempty"`
+	Annotations      []*ChatCompletionAnnotation             `json:"annotations,omitempty"`
+}
+
+// ChatCompletionAnnotation is an annotation attached to an assistant message, such as
+// the URL citations produced by web search.
+//
+// reference: https://openrouter.ai/docs/features/web-search#parsing-web-search-results
+type ChatCompletionAnnotation struct {
+	Type        ChatCompletionAnnotationType `json:"type"`
+	URLCitation *ChatCompletionURLCitation   `json:"url_citation,omitempty"`
+}
+
+type ChatCompletionAnnotationType string
+
+const (
+	ChatCompletionAnnotationTypeURLCitation ChatCompletionAnnotationType = "url_citation"
+)
+
+type ChatCompletionURLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+	Content    string `json:"content,omitempty"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
 }
 
 type ChatCompletionRole string
@@ -343,6 +593,7 @@ type ChatCompletionMessageContentPart struct {
 	Text         string                                    `json:"text,omitempty"`
 	Refusal      *string                                   `json:"refusal,omitempty"`
 	ImageUrl     *ChatCompletionMessageContentPartImageUrl `json:"image_url,omitempty"`
+	File         *ChatCompletionMessageContentPartFile     `json:"file,omitempty"`
 	CacheControl *ChatCompletionMessageCacheControl        `json:"cache_control,omitempty"`
 }
 
@@ -358,12 +609,17 @@ func (part ChatCompletionMessageContentPart) IsImage() bool {
 	return part.Type == ChatCompletionMessageContentPartTypeImage
 }
 
+func (part ChatCompletionMessageContentPart) IsFile() bool {
+	return part.Type == ChatCompletionMessageContentPartTypeFile
+}
+
 type ChatCompletionMessageContentPartType string
 
 const (
 	ChatCompletionMessageContentPartTypeText    ChatCompletionMessageContentPartType = "text"
 	ChatCompletionMessageContentPartTypeRefusal ChatCompletionMessageContentPartType = "refusal"
 	ChatCompletionMessageContentPartTypeImage   ChatCompletionMessageContentPartType = "image_url"
+	ChatCompletionMessageContentPartTypeFile    ChatCompletionMessageContentPartType = "file"
 )
 
 type ChatCompletionMessageCacheControl struct {
@@ -376,11 +632,24 @@ type (
 	ChatCompletionMessageCacheControlTTL  string
 )
 
+const (
+	ChatCompletionMessageCacheControlTTL5Minutes ChatCompletionMessageCacheControlTTL = "5m"
+	ChatCompletionMessageCacheControlTTL1Hour    ChatCompletionMessageCacheControlTTL = "1h"
+)
+
 type ChatCompletionMessageContentPartImageUrl struct {
 	Url    string `json:"url"`
 	Detail string `json:"detail,omitempty"`
 }
 
+// ChatCompletionMessageContentPartFile is a file (e.g. PDF) attachment.
+// FileData is either a data URL ("data:application/pdf;base64,...") or a public URL.
+// reference: https://openrouter.ai/docs/features/multimodal/pdfs
+type ChatCompletionMessageContentPartFile struct {
+	Filename string `json:"filename,omitempty"`
+	FileData string `json:"file_data"`
+}
+
 type ChatCompletionToolCall struct {
 	Index    int                                    `json:"index"`
 	ID       string                                 `json:"id"`
@@ -419,6 +688,7 @@ const (
 	ChatCompletionMessageReasoningDetailFormatUnknown           ChatCompletionMessageReasoningDetailFormat = "unknown"
 	ChatCompletionMessageReasoningDetailFormatAnthropicClaudeV1 ChatCompletionMessageReasoningDetailFormat = "anthropic-claude-v1"
 	ChatCompletionMessageReasoningDetailFormatOpenAIResponsesV1 ChatCompletionMessageReasoningDetailFormat = "openai-responses-v1"
+	ChatCompletionMessageReasoningDetailFormatOpenAIChatV1      ChatCompletionMessageReasoningDetailFormat = "openai-chat-v1"
 	ChatCompletionMessageReasoningDetailFormatGoogleGeminiV1    ChatCompletionMessageReasoningDetailFormat = "google-gemini-v1"
 )
 
@@ -521,6 +791,7 @@ type ChatCompletionChunkChoiceDelta struct {
 	ToolCalls        []*ChatCompletionToolCall               `json:"tool_calls,omitempty"`
 	Reasoning        string                                  `json:"reasoning,omitempty"`
 	ReasoningDetails []*ChatCompletionMessageReasoningDetail `json:"reasoning_details,omitempty"`
+	Annotations      []*ChatCompletionAnnotation             `json:"annotations,omitempty"`
 }
 
 type ChatCompletionLogprobs struct {
@@ -563,13 +834,14 @@ type ChatCompletionCompletionTokensDetails struct {
 }
 
 type ChatCompletion struct {
-	ID       string                  `json:"id"`
-	Provider string                  `json:"provider"`
-	Model    string                  `json:"model"`
-	Created  int64                   `json:"created"`
-	Object   string                  `json:"object"`
-	Choices  []*ChatCompletionChoice `json:"choices"`
-	Usage    *ChatCompletionUsage    `json:"usage"`
+	ID          string                  `json:"id"`
+	Provider    string                  `json:"provider"`
+	Model       string                  `json:"model"`
+	Created     int64                   `json:"created"`
+	Object      string                  `json:"object"`
+	Choices     []*ChatCompletionChoice `json:"choices"`
+	ServiceTier string                  `json:"service_tier,omitempty"`
+	Usage       *ChatCompletionUsage    `json:"usage"`
 }
 
 func (c ChatCompletion) GetPromptTokens() int64 {
@@ -610,24 +882,26 @@ func NewChatCompletionBuilder() *ChatCompletionBuilder {
 }
 
 type ChatCompletionBuilder struct {
-	ID       string
-	Provider string
-	Model    string
-	Created  int64
-	Object   string
-	Choices  []*ChatCompletionChoiceBuilder
-	Usage    *ChatCompletionUsage
+	ID          string
+	Provider    string
+	Model       string
+	Created     int64
+	Object      string
+	Choices     []*ChatCompletionChoiceBuilder
+	ServiceTier string
+	Usage       *ChatCompletionUsage
 }
 
 func (builder *ChatCompletionBuilder) Build() *ChatCompletion {
 	c := &ChatCompletion{
-		ID:       builder.ID,
-		Provider: builder.Provider,
-		Model:    builder.Model,
-		Created:  builder.Created,
-		Object:   builder.Object,
-		Choices:  make([]*ChatCompletionChoice, len(builder.Choices)),
-		Usage:    builder.Usage,
+		ID:          builder.ID,
+		Provider:    builder.Provider,
+		Model:       builder.Model,
+		Created:     builder.Created,
+		Object:      builder.Object,
+		Choices:     make([]*ChatCompletionChoice, len(builder.Choices)),
+		ServiceTier: builder.ServiceTier,
+		Usage:       builder.Usage,
 	}
 	for i, choice := range builder.Choices {
 		c.Choices[i] = choice.Build()
@@ -657,6 +931,10 @@ func (builder *ChatCompletionBuilder) Add(chunk *ChatCompletionChunk) {
 	if builder.Object == "" {
 		builder.Object = chunk.Object
 	}
+	// The tier that served the request may only be known by the last chunks.
+	if chunk.ServiceTier != "" {
+		builder.ServiceTier = chunk.ServiceTier
+	}
 	for _, choice := range chunk.Choices {
 		if choice.Index >= len(builder.Choices) {
 			for range (choice.Index - len(builder.Choices)) + 1 {
@@ -793,6 +1071,7 @@ type ChatCompletionMessageBuilder struct {
 	ToolCalls        []*ChatCompletionMessageToolCallBuilder
 	Reasoning        []byte
 	ReasoningDetails []*ChatCompletionMessageReasoningDetailBuilder
+	Annotations      []*ChatCompletionAnnotation
 }
 
 func (builder *ChatCompletionMessageBuilder) Build() *ChatCompletionMessage {
@@ -812,6 +1091,7 @@ func (builder *ChatCompletionMessageBuilder) Build() *ChatCompletionMessage {
 		ToolCalls:        make([]*ChatCompletionToolCall, len(builder.ToolCalls)),
 		Reasoning:        string(builder.Reasoning),
 		ReasoningDetails: make([]*ChatCompletionMessageReasoningDetail, len(builder.ReasoningDetails)),
+		Annotations:      builder.Annotations,
 	}
 	for i, toolCall := range builder.ToolCalls {
 		if toolCall != nil {
@@ -834,6 +1114,7 @@ func (builder *ChatCompletionMessageBuilder) Add(delta *ChatCompletionChunkChoic
 		builder.Role = delta.Role
 	}
 	builder.Content = append(builder.Content, delta.Content...)
+	builder.Annotations = append(builder.Annotations, delta.Annotations...)
 	if delta.Refusal != nil {
 		builder.Refusal = append(builder.Refusal, *delta.Refusal...)
 	}
@@ -964,9 +1245,8 @@ func (builder *ChatCompletionMessageReasoningDetailBuilder) Add(reasoningDetail
 	if builder.Data == "" {
 		builder.Data = reasoningDetail.Data
 	}
-	if builder.Signature == "" {
-		builder.Signature = reasoningDetail.Signature
-	}
+	// Long signatures may be streamed in fragments; keeping only the first one would truncate them.
+	builder.Signature += reasoningDetail.Signature
 	if builder.Format == "" {
 		builder.Format = reasoningDetail.Format
 	}
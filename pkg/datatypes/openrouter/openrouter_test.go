@@ -6,6 +6,7 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -189,22 +190,21 @@ func TestToolCalls_AccumulationAndExpansion(t *testing.T) {
 }
 
 func TestErrorTypeMapping(t *testing.T) {
-	e := &Error{}
-	e.Inner.Code = 429
-	if e.Type() != "overloaded_error" {
-		t.Fatalf("429 type: %s", e.Type())
-	}
-	e.Inner.Code = 400
-	if e.Type() != "invalid_request_error" {
-		t.Fatalf("4xx type: %s", e.Type())
-	}
-	e.Inner.Code = 500
-	if e.Type() != "internal_server_error" {
-		t.Fatalf("5xx type: %s", e.Type())
-	}
-	e.Inner.Code = 0
-	if e.Type() != "unknown_error" {
-		t.Fatalf("default type: %s", e.Type())
+	for code, want := range map[int]string{
+		400: "invalid_request_error",
+		401: "authentication_error",
+		402: "invalid_request_error",
+		429: "rate_limit_error",
+		500: "api_error",
+		502: "api_error",
+		503: "overloaded_error",
+		0:   "api_error",
+	} {
+		e := &Error{}
+		e.Inner.Code = code
+		if e.Type() != want {
+			t.Errorf("%d type: %s, want %s", code, e.Type(), want)
+		}
 	}
 }
 
@@ -245,7 +245,7 @@ func TestWithProviderPreferenceAndIdentity(t *testing.T) {
 	req.Body = io.NopCloser(bytes.NewReader(body))
 	req.ContentLength = int64(len(body))
 	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
-	pref := &ProviderPreference{Only: []string{"anthropic"}}
+	pref := &ProviderPreference{Only: []Provider{"anthropic"}}
 	WithProviderPreference(pref)(req)
 	b1, _ := io.ReadAll(req.Body)
 	var r1 CreateChatCompletionRequest
@@ -515,7 +515,7 @@ func TestWithProviderPreference_OverrideExisting(t *testing.T) {
 	req.Body = io.NopCloser(bytes.NewReader(body))
 	req.ContentLength = int64(len(body))
 	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
-	pref := &ProviderPreference{Only: []string{"anthropic"}}
+	pref := &ProviderPreference{Only: []Provider{"anthropic"}}
 	WithProviderPreference(pref)(req)
 	b1, _ := io.ReadAll(req.Body)
 	var r CreateChatCompletionRequest
@@ -525,6 +525,182 @@ func TestWithProviderPreference_OverrideExisting(t *testing.T) {
 	}
 }
 
+func newProviderPreferenceTestRequest(t *testing.T, body []byte) *http.Request {
+	t.Helper()
+	req := &http.Request{Header: http.Header{}}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	return req
+}
+
+func readProviderPreference(t *testing.T, req *http.Request) *ProviderPreference {
+	t.Helper()
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if req.ContentLength != int64(len(b)) {
+		t.Fatalf("content length mismatch: %d vs %d", req.ContentLength, len(b))
+	}
+	var r CreateChatCompletionRequest
+	if err = json.Unmarshal(b, &r); err != nil {
+		t.Fatalf("unmarshal body: %v", err)
+	}
+	return r.Provider
+}
+
+func TestWithMergedProviderPreference(t *testing.T) {
+	throughput := ProviderSortMethodThroughput
+	price := ProviderSortMethodPrice
+	empty := ProviderSortMethod("")
+	tests := []struct {
+		name   string
+		first  *ProviderPreference
+		second *ProviderPreference
+		want   *ProviderPreference
+	}{
+		{
+			name:   "order extends without duplicates",
+			first:  &ProviderPreference{Order: []Provider{ProviderAnthropic, ProviderGoogleVertex}},
+			second: &ProviderPreference{Order: []Provider{ProviderGoogleVertex, ProviderAmazonBedrock}},
+			want:   &ProviderPreference{Order: []Provider{ProviderAnthropic, ProviderGoogleVertex, ProviderAmazonBedrock}},
+		},
+		{
+			name:   "only intersects",
+			first:  &ProviderPreference{Only: []Provider{ProviderAnthropic, ProviderGoogleVertex, ProviderAmazonBedrock}},
+			second: &ProviderPreference{Only: []Provider{ProviderAmazonBedrock, ProviderAnthropic}},
+			want:   &ProviderPreference{Only: []Provider{ProviderAnthropic, ProviderAmazonBedrock}},
+		},
+		{
+			name:   "only with empty side keeps the other",
+			first:  &ProviderPreference{Only: []Provider{ProviderAnthropic}},
+			second: &ProviderPreference{Order: []Provider{ProviderAnthropic}},
+			want:   &ProviderPreference{Only: []Provider{ProviderAnthropic}, Order: []Provider{ProviderAnthropic}},
+		},
+		{
+			name:   "only with disjoint lists keeps the earlier list",
+			first:  &ProviderPreference{Only: []Provider{ProviderAnthropic}},
+			second: &ProviderPreference{Only: []Provider{ProviderGoogleVertex}},
+			want:   &ProviderPreference{Only: []Provider{ProviderAnthropic}},
+		},
+		{
+			name:   "ignore unions",
+			first:  &ProviderPreference{Ignore: []Provider{ProviderAzure, ProviderOpenAI}},
+			second: &ProviderPreference{Ignore: []Provider{ProviderOpenAI, ProviderGroq}},
+			want:   &ProviderPreference{Ignore: []Provider{ProviderAzure, ProviderOpenAI, ProviderGroq}},
+		},
+		{
+			name:   "sort overrides when set",
+			first:  &ProviderPreference{Sort: &throughput},
+			second: &ProviderPreference{Sort: &price},
+			want:   &ProviderPreference{Sort: &price},
+		},
+		{
+			name:   "sort kept when overlay is empty",
+			first:  &ProviderPreference{Sort: &throughput},
+			second: &ProviderPreference{Sort: &empty, AllowFallbacks: lo.ToPtr(false)},
+			want:   &ProviderPreference{Sort: &throughput, AllowFallbacks: lo.ToPtr(false)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newProviderPreferenceTestRequest(t, []byte(`{"messages":[],"model":"m","stream":true}`))
+			WithMergedProviderPreference(tt.first)(req)
+			WithMergedProviderPreference(tt.second)(req)
+			got := readProviderPreference(t, req)
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Fatalf("merged preference = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestWithMergedProviderPreference_DisjointOnlyKeepsProfile(t *testing.T) {
+	req := newProviderPreferenceTestRequest(t, []byte(`{"messages":[],"model":"m","stream":true}`))
+	profilePreference := &ProviderPreference{Only: []Provider{ProviderAnthropic, ProviderAmazonBedrock}}
+	WithMergedProviderPreference(profilePreference)(req)
+	WithMergedProviderPreference(&ProviderPreference{Only: []Provider{ProviderGoogleVertex}})(req)
+	got := readProviderPreference(t, req)
+	if got == nil || !reflect.DeepEqual(got.Only, profilePreference.Only) {
+		t.Fatalf("only = %#v, want the profile's list %v", got, profilePreference.Only)
+	}
+}
+
+func TestWithMergedProviderPreference_MergesExistingBody(t *testing.T) {
+	body := []byte(`{"messages":[],"model":"m","stream":true,"provider":{"only":["google-vertex","anthropic"],"ignore":["azure"]}}`)
+	req := newProviderPreferenceTestRequest(t, body)
+	WithMergedProviderPreference(&ProviderPreference{Only: []Provider{ProviderAnthropic}, Ignore: []Provider{ProviderGroq}})(req)
+	got := readProviderPreference(t, req)
+	if got == nil || !reflect.DeepEqual(got.Only, []Provider{ProviderAnthropic}) {
+		t.Fatalf("unexpected only: %#v", got)
+	}
+	if !reflect.DeepEqual(got.Ignore, []Provider{ProviderAzure, ProviderGroq}) {
+		t.Fatalf("unexpected ignore: %#v", got.Ignore)
+	}
+}
+
+func TestMergeProviderPreference_DoesNotMutateInputs(t *testing.T) {
+	base := &ProviderPreference{Order: []Provider{ProviderAnthropic}, Ignore: []Provider{ProviderAzure}}
+	overlay := &ProviderPreference{Order: []Provider{ProviderGoogleVertex}, Ignore: []Provider{ProviderGroq}}
+	_ = MergeProviderPreference(base, overlay)
+	if len(base.Order) != 1 || len(base.Ignore) != 1 {
+		t.Fatalf("base mutated: %#v", base)
+	}
+	if MergeProviderPreference(nil, nil) != nil {
+		t.Fatal("merging nil preferences should return nil")
+	}
+}
+
+func TestWithTransforms(t *testing.T) {
+	body := []byte(`{"messages":[],"model":"m","stream":true}`)
+	req := newProviderPreferenceTestRequest(t, body)
+	WithTransforms([]string{TransformMiddleOut})(req)
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if req.ContentLength != int64(len(b)) {
+		t.Fatalf("content length mismatch: %d vs %d", req.ContentLength, len(b))
+	}
+	if !bytes.Contains(b, []byte(`"transforms":["middle-out"]`)) {
+		t.Fatalf("transforms not serialized: %s", b)
+	}
+
+	req = newProviderPreferenceTestRequest(t, body)
+	WithTransforms(nil)(req)
+	if b, _ = io.ReadAll(req.Body); !bytes.Equal(b, body) {
+		t.Fatalf("empty transforms should leave the body untouched: %s", b)
+	}
+}
+
+func TestWithFallbackModels(t *testing.T) {
+	body := []byte(`{"messages":[],"model":"anthropic/claude-sonnet-4","stream":true}`)
+	req := newProviderPreferenceTestRequest(t, body)
+	WithFallbackModels([]string{"anthropic/claude-sonnet-4", "openai/gpt-5", "google/gemini-2.5-pro"})(req)
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if req.ContentLength != int64(len(b)) {
+		t.Fatalf("content length mismatch: %d vs %d", req.ContentLength, len(b))
+	}
+	if !bytes.Contains(b, []byte(`"model":"anthropic/claude-sonnet-4"`)) {
+		t.Fatalf("primary model changed: %s", b)
+	}
+	if !bytes.Contains(b, []byte(`"models":["openai/gpt-5","google/gemini-2.5-pro"]`)) {
+		t.Fatalf("fallback models not serialized: %s", b)
+	}
+
+	req = newProviderPreferenceTestRequest(t, body)
+	WithFallbackModels(nil)(req)
+	if b, _ = io.ReadAll(req.Body); !bytes.Equal(b, body) {
+		t.Fatalf("empty fallback models should leave the body untouched: %s", b)
+	}
+}
+
 func TestWithIdentity_OverrideHeaders(t *testing.T) {
 	req := &http.Request{}
 	req.Header = http.Header{"HTTP-Referer": []string{"old"}, "X-Title": []string{"old"}}
@@ -536,7 +712,7 @@ func TestWithIdentity_OverrideHeaders(t *testing.T) {
 
 func TestErrorTypeMapping_Extended(t *testing.T) {
 	codes := []int{401, 403, 408, 499, 600, 700}
-	expects := []string{"invalid_request_error", "invalid_request_error", "invalid_request_error", "invalid_request_error", "unknown_error", "unknown_error"}
+	expects := []string{"authentication_error", "permission_error", "invalid_request_error", "invalid_request_error", "api_error", "api_error"}
 	for i, code := range codes {
 		e := &Error{}
 		e.Inner.Code = code
@@ -637,6 +813,19 @@ func TestWithAnthropicBetaFeatures_InterleavedThinkingOnly(t *testing.T) {
 	}
 }
 
+func TestWithAnthropicBetaFeatures_Context1M(t *testing.T) {
+	req := &http.Request{Header: http.Header{}}
+	oriHeader := make(http.Header)
+	oriHeader.Set("anthropic-beta", "claude-code-20250219, context-1m-2025-08-07")
+
+	WithAnthropicBetaFeatures(oriHeader)(req)
+
+	headerVal := req.Header.Get("x-anthropic-beta")
+	if headerVal != "context-1m-2025-08-07" {
+		t.Fatalf("expected trimmed context-1m feature, got: %q", headerVal)
+	}
+}
+
 func TestWithAnthropicBetaFeatures_BothFeaturesInSingleValue(t *testing.T) {
 	req := &http.Request{Header: http.Header{}}
 	oriHeader := make(http.Header)
@@ -0,0 +1,141 @@
+// Package metrics exposes request and token usage counters in the Prometheus text format.
+//
+// A nil *Registry is valid and records nothing, so callers can pass nil when metrics are
+// disabled instead of checking a flag at every call site.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Counter names exposed by the registry.
+const (
+	RequestsTotal                 = "claude_code_adapter_requests_total"
+	ErrorsTotal                   = "claude_code_adapter_errors_total"
+	InputTokensTotal              = "claude_code_adapter_input_tokens_total"
+	OutputTokensTotal             = "claude_code_adapter_output_tokens_total"
+	CacheReadInputTokensTotal     = "claude_code_adapter_cache_read_input_tokens_total"
+	CacheCreationInputTokensTotal = "claude_code_adapter_cache_creation_input_tokens_total"
+)
+
+var counterHelp = map[string]string{
+	RequestsTotal:                 "Total number of /v1/messages requests.",
+	ErrorsTotal:                   "Total number of /v1/messages requests that ended with an error.",
+	InputTokensTotal:              "Total number of input tokens reported to clients.",
+	OutputTokensTotal:             "Total number of output tokens reported to clients.",
+	CacheReadInputTokensTotal:     "Total number of cache read input tokens reported to clients.",
+	CacheCreationInputTokensTotal: "Total number of cache creation input tokens reported to clients.",
+}
+
+// Labels identifies the series a sample belongs to.
+type Labels struct {
+	Profile  string
+	Provider string
+}
+
+// Usage holds the token counts of a single request.
+type Usage struct {
+	InputTokens              int64
+	OutputTokens             int64
+	CacheReadInputTokens     int64
+	CacheCreationInputTokens int64
+}
+
+type seriesKey struct {
+	name   string
+	labels Labels
+}
+
+// Registry holds the counters. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[seriesKey]int64
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{counters: make(map[seriesKey]int64)}
+}
+
+func (r *Registry) add(name string, labels Labels, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[seriesKey{name: name, labels: labels}] += delta
+}
+
+// ObserveRequest counts a finished request, and an error when failed is true.
+func (r *Registry) ObserveRequest(labels Labels, failed bool) {
+	if r == nil {
+		return
+	}
+	r.add(RequestsTotal, labels, 1)
+	if failed {
+		r.add(ErrorsTotal, labels, 1)
+	}
+}
+
+// ObserveUsage adds the token counts of a request.
+func (r *Registry) ObserveUsage(labels Labels, usage Usage) {
+	if r == nil {
+		return
+	}
+	r.add(InputTokensTotal, labels, usage.InputTokens)
+	r.add(OutputTokensTotal, labels, usage.OutputTokens)
+	r.add(CacheReadInputTokensTotal, labels, usage.CacheReadInputTokens)
+	r.add(CacheCreationInputTokensTotal, labels, usage.CacheCreationInputTokens)
+}
+
+// WriteTo writes all counters in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	if r == nil {
+		return 0, nil
+	}
+	r.mu.Lock()
+	counters := maps.Clone(r.counters)
+	r.mu.Unlock()
+	keys := slices.Collect(maps.Keys(counters))
+	slices.SortFunc(keys, func(a, b seriesKey) int {
+		return strings.Compare(a.name+"\x00"+a.labels.Profile+"\x00"+a.labels.Provider,
+			b.name+"\x00"+b.labels.Profile+"\x00"+b.labels.Provider)
+	})
+	var (
+		sb   strings.Builder
+		last string
+	)
+	for _, key := range keys {
+		if key.name != last {
+			fmt.Fprintf(&sb, "# HELP %s %s\n", key.name, counterHelp[key.name])
+			fmt.Fprintf(&sb, "# TYPE %s counter\n", key.name)
+			last = key.name
+		}
+		fmt.Fprintf(&sb, "%s{profile=\"%s\",provider=\"%s\"} %d\n",
+			key.name, escapeLabelValue(key.labels.Profile), escapeLabelValue(key.labels.Provider), counters[key])
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// ServeHTTP implements http.Handler for the /metrics endpoint.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		r.WriteTo(w)
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
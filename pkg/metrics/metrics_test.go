@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	claude := Labels{Profile: "claude", Provider: "anthropic"}
+	gpt := Labels{Profile: "gpt", Provider: "openrouter"}
+	r.ObserveRequest(claude, false)
+	r.ObserveRequest(claude, true)
+	r.ObserveRequest(gpt, false)
+	r.ObserveUsage(claude, Usage{InputTokens: 100, OutputTokens: 20, CacheReadInputTokens: 80, CacheCreationInputTokens: 5})
+	r.ObserveUsage(claude, Usage{InputTokens: 50, OutputTokens: 10})
+	r.ObserveUsage(gpt, Usage{InputTokens: 7, OutputTokens: 3})
+
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"# TYPE claude_code_adapter_requests_total counter\n",
+		`claude_code_adapter_requests_total{profile="claude",provider="anthropic"} 2` + "\n",
+		`claude_code_adapter_requests_total{profile="gpt",provider="openrouter"} 1` + "\n",
+		`claude_code_adapter_errors_total{profile="claude",provider="anthropic"} 1` + "\n",
+		`claude_code_adapter_input_tokens_total{profile="claude",provider="anthropic"} 150` + "\n",
+		`claude_code_adapter_output_tokens_total{profile="claude",provider="anthropic"} 30` + "\n",
+		`claude_code_adapter_cache_read_input_tokens_total{profile="claude",provider="anthropic"} 80` + "\n",
+		`claude_code_adapter_cache_creation_input_tokens_total{profile="claude",provider="anthropic"} 5` + "\n",
+		`claude_code_adapter_input_tokens_total{profile="gpt",provider="openrouter"} 7` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `claude_code_adapter_errors_total{profile="gpt"`) {
+		t.Errorf("unexpected error series for gpt profile\n%s", out)
+	}
+	if n := strings.Count(out, "# TYPE claude_code_adapter_requests_total"); n != 1 {
+		t.Errorf("expected a single TYPE line per metric, got %d", n)
+	}
+}
+
+func TestRegistry_EscapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.ObserveRequest(Labels{Profile: "a\"b\\c\nd", Provider: "openrouter"}, false)
+	var sb strings.Builder
+	r.WriteTo(&sb)
+	if want := `{profile="a\"b\\c\nd",provider="openrouter"} 1`; !strings.Contains(sb.String(), want) {
+		t.Errorf("output missing %q\n%s", want, sb.String())
+	}
+}
+
+func TestRegistry_Nil(t *testing.T) {
+	var r *Registry
+	r.ObserveRequest(Labels{Profile: "p"}, true)
+	r.ObserveUsage(Labels{Profile: "p"}, Usage{InputTokens: 1})
+	var sb strings.Builder
+	if n, err := r.WriteTo(&sb); n != 0 || err != nil || sb.Len() != 0 {
+		t.Errorf("nil registry WriteTo = (%d, %v), output %q", n, err, sb.String())
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.ObserveRequest(Labels{Profile: "p", Provider: "anthropic"}, false)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), `claude_code_adapter_requests_total{profile="p",provider="anthropic"} 1`) {
+		t.Errorf("unexpected body:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
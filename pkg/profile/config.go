@@ -2,13 +2,18 @@ package profile
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
 )
 
@@ -21,8 +26,8 @@ type HTTPConfig struct {
 // envVarRegex matches environment variable references like ${VAR_NAME}
 var envVarRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
 
-// expandEnv expands environment variable references in a string.
-// Supports ${VAR_NAME} syntax.
+// ExpandEnv expands environment variable references in a string.
+// Supports ${VAR_NAME} syntax; references to unset variables are kept literally.
 func ExpandEnv(s string) string {
 	return envVarRegex.ReplaceAllStringFunc(s, func(match string) string {
 		// Extract variable name from ${VAR_NAME}
@@ -30,6 +35,7 @@ func ExpandEnv(s string) string {
 		if value, ok := os.LookupEnv(varName); ok {
 			return value
 		}
+		slog.Debug(fmt.Sprintf("environment variable %q is not set, keeping %s literally", varName, match))
 		return match // Return original if not found
 	})
 }
@@ -60,16 +66,47 @@ func LoadFromViper(v *viper.Viper) (*ProfileManager, error) {
 			Options:    loadOptionsConfig(v, delimiter.ViperKey(key, "options")),
 			Anthropic:  loadAnthropicConfig(v, delimiter.ViperKey(key, "anthropic")),
 			OpenRouter: loadOpenRouterConfig(v, delimiter.ViperKey(key, "openrouter")),
+			Bedrock:    loadBedrockConfig(v, delimiter.ViperKey(key, "bedrock")),
+			Gemini:     loadGeminiConfig(v, delimiter.ViperKey(key, "gemini")),
+			OpenAI:     loadOpenAIConfig(v, delimiter.ViperKey(key, "openai")),
 		}
 		// Expand environment variables in API keys and URLs
 		if p.Anthropic != nil {
 			p.Anthropic.APIKey = ExpandEnv(p.Anthropic.APIKey)
 			p.Anthropic.BaseURL = ExpandEnv(p.Anthropic.BaseURL)
 			p.Anthropic.CountTokensBackend = ExpandEnv(p.Anthropic.CountTokensBackend)
+			for name, value := range p.Anthropic.Headers {
+				p.Anthropic.Headers[name] = ExpandEnv(value)
+			}
 		}
 		if p.OpenRouter != nil {
 			p.OpenRouter.APIKey = ExpandEnv(p.OpenRouter.APIKey)
+			for i, apiKey := range p.OpenRouter.APIKeys {
+				p.OpenRouter.APIKeys[i] = ExpandEnv(apiKey)
+			}
 			p.OpenRouter.BaseURL = ExpandEnv(p.OpenRouter.BaseURL)
+			for name, value := range p.OpenRouter.Headers {
+				p.OpenRouter.Headers[name] = ExpandEnv(value)
+			}
+		}
+		if p.Bedrock != nil {
+			p.Bedrock.Region = ExpandEnv(p.Bedrock.Region)
+			p.Bedrock.BaseURL = ExpandEnv(p.Bedrock.BaseURL)
+			p.Bedrock.AccessKeyID = ExpandEnv(p.Bedrock.AccessKeyID)
+			p.Bedrock.SecretAccessKey = ExpandEnv(p.Bedrock.SecretAccessKey)
+			p.Bedrock.SessionToken = ExpandEnv(p.Bedrock.SessionToken)
+			p.Bedrock.Profile = ExpandEnv(p.Bedrock.Profile)
+		}
+		if p.Gemini != nil {
+			p.Gemini.APIKey = ExpandEnv(p.Gemini.APIKey)
+			p.Gemini.BaseURL = ExpandEnv(p.Gemini.BaseURL)
+		}
+		if p.OpenAI != nil {
+			p.OpenAI.APIKey = ExpandEnv(p.OpenAI.APIKey)
+			p.OpenAI.BaseURL = ExpandEnv(p.OpenAI.BaseURL)
+			for name, value := range p.OpenAI.Headers {
+				p.OpenAI.Headers[name] = ExpandEnv(value)
+			}
 		}
 		pm.AddProfile(p)
 	}
@@ -132,8 +169,42 @@ func loadOptionsConfig(v *viper.Viper, key string) *OptionsConfig {
 		ContextWindowResizeFactor:  v.GetFloat64(delimiter.ViperKey(key, "context_window_resize_factor")),
 		DisableCountTokensRequest:  v.GetBool(delimiter.ViperKey(key, "disable_count_tokens_request")),
 		MinMaxTokens:               v.GetInt(delimiter.ViperKey(key, "min_max_tokens")),
+		DefaultMaxTokens:           v.GetInt(delimiter.ViperKey(key, "default_max_tokens")),
+		MaxTokensCap:               v.GetInt(delimiter.ViperKey(key, "max_tokens_cap")),
 		DisallowedTools:            v.GetStringSlice(delimiter.ViperKey(key, "disallowed_tools")),
+		MaxTools:                   v.GetInt(delimiter.ViperKey(key, "max_tools")),
+		SanitizeToolNames:          v.GetBool(delimiter.ViperKey(key, "sanitize_tool_names")),
+		EnforceSerialToolCalls:     v.GetBool(delimiter.ViperKey(key, "enforce_serial_tool_calls")),
+		MaxStopSequences:           v.GetInt(delimiter.ViperKey(key, "max_stop_sequences")),
+		LogitBias:                  loadLogitBias(v, delimiter.ViperKey(key, "logit_bias")),
 		StreamDataBufferSize:       v.GetInt(delimiter.ViperKey(key, "stream_data_buffer_size")),
+		MaxContentPartBytes:        v.GetInt(delimiter.ViperKey(key, "max_content_part_bytes")),
+		RequestTimeout:             v.GetDuration(delimiter.ViperKey(key, "request_timeout")),
+		MaxConcurrency:             v.GetInt(delimiter.ViperKey(key, "max_concurrency")),
+		QueueTimeout:               v.GetDuration(delimiter.ViperKey(key, "queue_timeout")),
+		StrictSchemaSanitize:       v.GetBool(delimiter.ViperKey(key, "strict_schema_sanitize")),
+		Context1MModels:            v.GetStringSlice(delimiter.ViperKey(key, "context_1m_models")),
+		DeniedModels:               v.GetStringSlice(delimiter.ViperKey(key, "denied_models")),
+		SystemPrefix:               v.GetString(delimiter.ViperKey(key, "system_prefix")),
+		SystemSuffix:               v.GetString(delimiter.ViperKey(key, "system_suffix")),
+		RedactedThinkingMode:       v.GetString(delimiter.ViperKey(key, "redacted_thinking_mode")),
+		ImageDetail:                v.GetString(delimiter.ViperKey(key, "image_detail")),
+		KeepAliveInterval:          v.GetDuration(delimiter.ViperKey(key, "keep_alive_interval")),
+		ForceTemperature:           loadOptionalFloat64(v, delimiter.ViperKey(key, "force_temperature")),
+		ForceTopP:                  loadOptionalFloat64(v, delimiter.ViperKey(key, "force_top_p")),
+		CacheCountTokens:           v.GetBool(delimiter.ViperKey(key, "cache_count_tokens")),
+		CountTokensCacheSize:       v.GetInt(delimiter.ViperKey(key, "count_tokens_cache_size")),
+		CountTokensCacheTTL:        v.GetDuration(delimiter.ViperKey(key, "count_tokens_cache_ttl")),
+		EstimateTokensOnFailure:    v.GetBool(delimiter.ViperKey(key, "estimate_tokens_on_failure")),
+		LocalCountTokens:           v.GetBool(delimiter.ViperKey(key, "local_count_tokens")),
+		CountTokensModel:           v.GetString(delimiter.ViperKey(key, "count_tokens_model")),
+		ResponseCache:              v.GetBool(delimiter.ViperKey(key, "response_cache")),
+		ResponseCacheSize:          v.GetInt(delimiter.ViperKey(key, "response_cache_size")),
+		ResponseCacheTTL:           v.GetDuration(delimiter.ViperKey(key, "response_cache_ttl")),
+		FallbackProfile:            v.GetString(delimiter.ViperKey(key, "fallback_profile")),
+		InterimUsageInterval:       v.GetDuration(delimiter.ViperKey(key, "interim_usage_interval")),
+		AutoCacheBreakpoints:       v.GetBool(delimiter.ViperKey(key, "auto_cache_breakpoints")),
+		DisableThinking:            v.GetBool(delimiter.ViperKey(key, "disable_thinking")),
 	}
 }
 
@@ -142,9 +213,12 @@ func loadReasoningConfig(v *viper.Viper, key string) *ReasoningConfig {
 		return nil
 	}
 	return &ReasoningConfig{
-		Format:    v.GetString(delimiter.ViperKey(key, "format")),
-		Effort:    v.GetString(delimiter.ViperKey(key, "effort")),
-		Delimiter: v.GetString(delimiter.ViperKey(key, "delimiter")),
+		Format:              v.GetString(delimiter.ViperKey(key, "format")),
+		Effort:              v.GetString(delimiter.ViperKey(key, "effort")),
+		Delimiter:           v.GetString(delimiter.ViperKey(key, "delimiter")),
+		Exclude:             v.GetBool(delimiter.ViperKey(key, "exclude")),
+		PreferSummary:       v.GetBool(delimiter.ViperKey(key, "prefer_summary")),
+		EffortSuffixFormats: v.GetStringSlice(delimiter.ViperKey(key, "effort_suffix_formats")),
 	}
 }
 
@@ -161,6 +235,7 @@ func loadAnthropicConfig(v *viper.Viper, key string) *AnthropicConfig {
 		APIKey:                         v.GetString(delimiter.ViperKey(key, "api_key")),
 		Version:                        v.GetString(delimiter.ViperKey(key, "version")),
 		CountTokensBackend:             v.GetString(delimiter.ViperKey(key, "count_tokens_backend")),
+		Headers:                        v.GetStringMapString(delimiter.ViperKey(key, "headers")),
 	}
 }
 
@@ -169,11 +244,146 @@ func loadOpenRouterConfig(v *viper.Viper, key string) *OpenRouterConfig {
 		return nil
 	}
 	return &OpenRouterConfig{
-		BaseURL:              v.GetString(delimiter.ViperKey(key, "base_url")),
-		APIKey:               v.GetString(delimiter.ViperKey(key, "api_key")),
-		ModelReasoningFormat: v.GetStringMapString(delimiter.ViperKey(key, "model_reasoning_format")),
-		AllowedProviders:     v.GetStringSlice(delimiter.ViperKey(key, "allowed_providers")),
+		BaseURL:               v.GetString(delimiter.ViperKey(key, "base_url")),
+		APIKey:                v.GetString(delimiter.ViperKey(key, "api_key")),
+		APIKeys:               v.GetStringSlice(delimiter.ViperKey(key, "api_keys")),
+		APIKeyStrategy:        v.GetString(delimiter.ViperKey(key, "api_key_strategy")),
+		ModelReasoningFormat:  v.GetStringMapString(delimiter.ViperKey(key, "model_reasoning_format")),
+		PreferredProviders:    loadPreferredProviders(v, key),
+		Headers:               v.GetStringMapString(delimiter.ViperKey(key, "headers")),
+		Transforms:            v.GetStringSlice(delimiter.ViperKey(key, "transforms")),
+		ClampCacheTTL:         v.GetBool(delimiter.ViperKey(key, "clamp_cache_ttl")),
+		MergeConsecutiveRoles: v.GetBool(delimiter.ViperKey(key, "merge_consecutive_roles")),
+		ForcePartsContent:     v.GetBool(delimiter.ViperKey(key, "force_parts_content")),
+		ProviderSort:          loadProviderSort(v, key),
+		AllowFallbacks:        loadOptionalBool(v, delimiter.ViperKey(key, "allow_fallbacks")),
+		RequireParameters:     v.GetBool(delimiter.ViperKey(key, "require_parameters")),
+		ServiceTier:           loadServiceTier(v, key),
+		FallbackModels:        v.GetStringSlice(delimiter.ViperKey(key, "fallback_models")),
+		WebSearch:             v.GetBool(delimiter.ViperKey(key, "web_search")),
+	}
+}
+
+func loadBedrockConfig(v *viper.Viper, key string) *BedrockConfig {
+	if !v.IsSet(key) {
+		return nil
+	}
+	return &BedrockConfig{
+		Region:          v.GetString(delimiter.ViperKey(key, "region")),
+		BaseURL:         v.GetString(delimiter.ViperKey(key, "base_url")),
+		AccessKeyID:     v.GetString(delimiter.ViperKey(key, "access_key_id")),
+		SecretAccessKey: v.GetString(delimiter.ViperKey(key, "secret_access_key")),
+		SessionToken:    v.GetString(delimiter.ViperKey(key, "session_token")),
+		Profile:         v.GetString(delimiter.ViperKey(key, "profile")),
+		Models:          v.GetStringMapString(delimiter.ViperKey(key, "models")),
+	}
+}
+
+func loadGeminiConfig(v *viper.Viper, key string) *GeminiConfig {
+	if !v.IsSet(key) {
+		return nil
+	}
+	return &GeminiConfig{
+		BaseURL: v.GetString(delimiter.ViperKey(key, "base_url")),
+		APIKey:  v.GetString(delimiter.ViperKey(key, "api_key")),
+		Models:  v.GetStringMapString(delimiter.ViperKey(key, "models")),
+	}
+}
+
+func loadOpenAIConfig(v *viper.Viper, key string) *OpenAIConfig {
+	if !v.IsSet(key) {
+		return nil
+	}
+	return &OpenAIConfig{
+		BaseURL:        v.GetString(delimiter.ViperKey(key, "base_url")),
+		APIKey:         v.GetString(delimiter.ViperKey(key, "api_key")),
+		Headers:        v.GetStringMapString(delimiter.ViperKey(key, "headers")),
+		AllowedTools:   v.GetStringSlice(delimiter.ViperKey(key, "allowed_tools")),
+		PromptCacheKey: v.GetString(delimiter.ViperKey(key, "prompt_cache_key")),
+		ServiceTier:    loadServiceTier(v, key),
+	}
+}
+
+// loadPreferredProviders reads the preferred_providers list, falling back to the
+// legacy allowed_providers key. Unknown provider names are kept but reported with
+// a warning, since OpenRouter adds providers more often than we release.
+func loadPreferredProviders(v *viper.Viper, key string) []openrouter.Provider {
+	names := v.GetStringSlice(delimiter.ViperKey(key, "preferred_providers"))
+	if !v.IsSet(delimiter.ViperKey(key, "preferred_providers")) {
+		names = v.GetStringSlice(delimiter.ViperKey(key, "allowed_providers"))
+	}
+	if names == nil {
+		return nil
+	}
+	providers := make([]openrouter.Provider, 0, len(names))
+	for _, name := range names {
+		p := openrouter.Provider(name)
+		if !p.IsKnown() {
+			slog.Warn(fmt.Sprintf("unknown OpenRouter provider %q in %q", name, delimiter.ViperKey(key, "preferred_providers")))
+		}
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+func loadProviderSort(v *viper.Viper, key string) openrouter.ProviderSortMethod {
+	sort := openrouter.ProviderSortMethod(v.GetString(delimiter.ViperKey(key, "provider_sort")))
+	if sort != "" && !sort.IsKnown() {
+		slog.Warn(fmt.Sprintf("unknown OpenRouter provider sort %q in %q", sort, delimiter.ViperKey(key, "provider_sort")))
+	}
+	return sort
+}
+
+// loadServiceTier reads the service_tier value; unknown tiers are dropped with a warning
+// instead of being forwarded, since the upstream would reject the whole request.
+func loadServiceTier(v *viper.Viper, key string) openrouter.ServiceTier {
+	tier := openrouter.ServiceTier(v.GetString(delimiter.ViperKey(key, "service_tier")))
+	if tier != "" && !tier.IsKnown() {
+		slog.Warn(fmt.Sprintf("unknown service tier %q in %q, ignoring it", tier, delimiter.ViperKey(key, "service_tier")))
+		return ""
+	}
+	return tier
+}
+
+// loadLogitBias loads a map of token IDs to biases; entries that are not a token ID with a bias in
+// [-100, 100] are dropped with a warning.
+func loadLogitBias(v *viper.Viper, key string) map[string]int {
+	tokens := v.GetStringMap(key)
+	if len(tokens) == 0 {
+		return nil
+	}
+	logitBias := make(map[string]int, len(tokens))
+	for token := range tokens {
+		if _, err := strconv.ParseUint(token, 10, 64); err != nil {
+			slog.Warn(fmt.Sprintf("invalid token ID %q in %q, ignoring it", token, key))
+			continue
+		}
+		bias := v.GetInt(delimiter.ViperKey(key, token))
+		if bias < -100 || bias > 100 {
+			slog.Warn(fmt.Sprintf("logit bias %d of token %s in %q is out of [-100, 100], ignoring it", bias, token, key))
+			continue
+		}
+		logitBias[token] = bias
+	}
+	return logitBias
+}
+
+// loadOptionalBool returns nil when key is not set, so that getters can apply their own default.
+func loadOptionalBool(v *viper.Viper, key string) *bool {
+	if !v.IsSet(key) {
+		return nil
+	}
+	value := v.GetBool(key)
+	return &value
+}
+
+// loadOptionalFloat64 returns nil when key is not set, so that an explicit zero can be told apart.
+func loadOptionalFloat64(v *viper.Viper, key string) *float64 {
+	if !v.IsSet(key) {
+		return nil
 	}
+	value := v.GetFloat64(key)
+	return &value
 }
 
 // GetHTTPConfig returns the HTTP configuration from viper.
@@ -197,6 +407,15 @@ func (o *OptionsConfig) GetStrict() bool {
 	return o.Strict
 }
 
+// GetStrictSchemaSanitize safely gets the value with a default.
+// It only takes effect when Strict is enabled.
+func (o *OptionsConfig) GetStrictSchemaSanitize() bool {
+	if o == nil {
+		return false
+	}
+	return o.StrictSchemaSanitize
+}
+
 // GetPreventEmptyTextToolResult safely gets the value with a default.
 func (o *OptionsConfig) GetPreventEmptyTextToolResult() bool {
 	if o == nil {
@@ -256,6 +475,32 @@ func (o *OptionsConfig) GetReasoningDelimiter() string {
 	return o.Reasoning.Delimiter
 }
 
+// GetReasoningExclude safely gets whether reasoning is kept out of the response.
+func (o *OptionsConfig) GetReasoningExclude() bool {
+	if o == nil || o.Reasoning == nil {
+		return false
+	}
+	return o.Reasoning.Exclude
+}
+
+// GetReasoningPreferSummary safely gets whether reasoning summaries, rather than the full
+// reasoning text, become thinking blocks when an upstream streams both.
+func (o *OptionsConfig) GetReasoningPreferSummary() bool {
+	if o == nil || o.Reasoning == nil {
+		return false
+	}
+	return o.Reasoning.PreferSummary
+}
+
+// GetReasoningEffortSuffixFormats safely gets the reasoning formats that accept a model effort suffix.
+// Default is the OpenAI formats, "openai-responses-v1" and "openai-chat-v1".
+func (o *OptionsConfig) GetReasoningEffortSuffixFormats() []string {
+	if o == nil || o.Reasoning == nil || o.Reasoning.EffortSuffixFormats == nil {
+		return []string{"openai-responses-v1", "openai-chat-v1"}
+	}
+	return o.Reasoning.EffortSuffixFormats
+}
+
 // GetMinMaxTokens safely gets the minimum max_tokens value.
 // Returns 0 if not set (meaning no minimum enforcement).
 func (o *OptionsConfig) GetMinMaxTokens() int {
@@ -265,6 +510,24 @@ func (o *OptionsConfig) GetMinMaxTokens() int {
 	return o.MinMaxTokens
 }
 
+// GetDefaultMaxTokens safely gets the max_tokens used when a request has none.
+// Returns 0 if not set (meaning the request is sent as-is).
+func (o *OptionsConfig) GetDefaultMaxTokens() int {
+	if o == nil {
+		return 0
+	}
+	return o.DefaultMaxTokens
+}
+
+// GetMaxTokensCap safely gets the maximum max_tokens value.
+// Returns 0 if not set (meaning no maximum enforcement).
+func (o *OptionsConfig) GetMaxTokensCap() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxTokensCap
+}
+
 // GetDisallowedTools safely gets the disallowed tools list.
 func (o *OptionsConfig) GetDisallowedTools() []string {
 	if o == nil || o.DisallowedTools == nil {
@@ -273,6 +536,50 @@ func (o *OptionsConfig) GetDisallowedTools() []string {
 	return o.DisallowedTools
 }
 
+// GetMaxTools safely gets the maximum number of function tools sent upstream.
+// Returns 0 if not set (meaning no limit).
+func (o *OptionsConfig) GetMaxTools() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxTools
+}
+
+// GetLogitBias safely gets the logit biases, keyed by token ID, added to OpenRouter requests.
+func (o *OptionsConfig) GetLogitBias() map[string]int {
+	if o == nil {
+		return nil
+	}
+	return o.LogitBias
+}
+
+// GetEnforceSerialToolCalls safely gets whether tool calls beyond the first one are dropped
+// from responses to requests that disable parallel tool use.
+func (o *OptionsConfig) GetEnforceSerialToolCalls() bool {
+	if o == nil {
+		return false
+	}
+	return o.EnforceSerialToolCalls
+}
+
+// GetSanitizeToolNames safely gets whether tool names are rewritten to match upstream naming rules.
+// Returns false if not set.
+func (o *OptionsConfig) GetSanitizeToolNames() bool {
+	if o == nil {
+		return false
+	}
+	return o.SanitizeToolNames
+}
+
+// GetMaxStopSequences safely gets the maximum number of stop sequences sent upstream.
+// Returns 0 if not set (meaning no limit).
+func (o *OptionsConfig) GetMaxStopSequences() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxStopSequences
+}
+
 // GetStreamDataBufferSize safely gets the stream data buffer size.
 // This is the maximum size of a single line in the SSE stream.
 // Default is 1MB which should be sufficient for most model responses.
@@ -283,6 +590,279 @@ func (o *OptionsConfig) GetStreamDataBufferSize() int {
 	return o.StreamDataBufferSize
 }
 
+// GetMaxContentPartBytes safely gets the maximum size of a single text content part.
+// Default is 10MB; requests with larger text parts are rejected before forwarding.
+func (o *OptionsConfig) GetMaxContentPartBytes() int {
+	if o == nil || o.MaxContentPartBytes == 0 {
+		return 10 * 1024 * 1024 // 10MB
+	}
+	return o.MaxContentPartBytes
+}
+
+// GetRequestTimeout safely gets the upstream request timeout.
+// Returns 0 if not set (meaning no timeout). The timeout is an idle timeout
+// for streams: it is extended every time the upstream produces an event.
+func (o *OptionsConfig) GetRequestTimeout() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.RequestTimeout
+}
+
+// GetMaxConcurrency safely gets the maximum number of requests of the profile served at once.
+// Returns 0 if not set (meaning no limit).
+func (o *OptionsConfig) GetMaxConcurrency() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxConcurrency
+}
+
+// GetQueueTimeout safely gets how long a request waits for a free slot when the profile
+// is at its concurrency limit. Returns 0 if not set (meaning excess requests are rejected at once).
+func (o *OptionsConfig) GetQueueTimeout() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.QueueTimeout
+}
+
+// GetKeepAliveInterval safely gets the interval of ping events sent to streaming clients
+// while waiting for the first upstream event. Returns 0 if not set (meaning disabled).
+func (o *OptionsConfig) GetKeepAliveInterval() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.KeepAliveInterval
+}
+
+// GetFallbackProfile safely gets the name of the profile a request is retried with when the
+// upstream of this profile fails before responding. Returns "" if not set (meaning no fallback).
+func (o *OptionsConfig) GetFallbackProfile() string {
+	if o == nil {
+		return ""
+	}
+	return o.FallbackProfile
+}
+
+// GetInterimUsageInterval safely gets the minimum interval between the message_delta events
+// reporting the usage of a stream before it ends. Returns 0 if not set (meaning disabled).
+func (o *OptionsConfig) GetInterimUsageInterval() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.InterimUsageInterval
+}
+
+// GetAutoCacheBreakpoints safely gets whether cache_control breakpoints are added to the system
+// prompt and the last user message of requests that lack them.
+func (o *OptionsConfig) GetAutoCacheBreakpoints() bool {
+	if o == nil {
+		return false
+	}
+	return o.AutoCacheBreakpoints
+}
+
+// GetDisableThinking safely gets whether thinking is kept out of requests and responses,
+// whatever the client asks for.
+func (o *OptionsConfig) GetDisableThinking() bool {
+	if o == nil {
+		return false
+	}
+	return o.DisableThinking
+}
+
+// GetCacheCountTokens safely gets whether estimated input tokens are cached per request prefix.
+func (o *OptionsConfig) GetCacheCountTokens() bool {
+	if o == nil {
+		return false
+	}
+	return o.CacheCountTokens
+}
+
+// GetCountTokensCacheSize safely gets the maximum number of cached token counts, defaulting to 1024.
+func (o *OptionsConfig) GetCountTokensCacheSize() int {
+	if o == nil || o.CountTokensCacheSize <= 0 {
+		return 1024
+	}
+	return o.CountTokensCacheSize
+}
+
+// GetCountTokensCacheTTL safely gets how long a cached token count stays valid, defaulting to 5 minutes.
+func (o *OptionsConfig) GetCountTokensCacheTTL() time.Duration {
+	if o == nil || o.CountTokensCacheTTL <= 0 {
+		return 5 * time.Minute
+	}
+	return o.CountTokensCacheTTL
+}
+
+// GetResponseCache safely gets whether the responses to identical requests are cached and replayed.
+func (o *OptionsConfig) GetResponseCache() bool {
+	if o == nil {
+		return false
+	}
+	return o.ResponseCache
+}
+
+// GetResponseCacheSize safely gets the maximum number of cached responses, defaulting to 256.
+func (o *OptionsConfig) GetResponseCacheSize() int {
+	if o == nil || o.ResponseCacheSize <= 0 {
+		return 256
+	}
+	return o.ResponseCacheSize
+}
+
+// GetResponseCacheTTL safely gets how long a cached response is replayed, defaulting to 10 minutes.
+func (o *OptionsConfig) GetResponseCacheTTL() time.Duration {
+	if o == nil || o.ResponseCacheTTL <= 0 {
+		return 10 * time.Minute
+	}
+	return o.ResponseCacheTTL
+}
+
+// GetEstimateTokensOnFailure safely gets whether input tokens are estimated locally when the
+// count_tokens request fails or times out.
+func (o *OptionsConfig) GetEstimateTokensOnFailure() bool {
+	if o == nil {
+		return false
+	}
+	return o.EstimateTokensOnFailure
+}
+
+// GetLocalCountTokens safely gets whether input tokens are always estimated locally, so that
+// prompts are never sent upstream just to be counted.
+func (o *OptionsConfig) GetLocalCountTokens() bool {
+	if o == nil {
+		return false
+	}
+	return o.LocalCountTokens
+}
+
+// GetCountTokensModel safely gets the Anthropic model used for count_tokens requests.
+// Returns an empty string if not set (meaning the model requested by the client).
+func (o *OptionsConfig) GetCountTokensModel() string {
+	if o == nil {
+		return ""
+	}
+	return o.CountTokensModel
+}
+
+// GetForceTemperature safely gets the temperature that replaces the client's one.
+// Returns nil if not set (meaning the client's temperature is kept).
+func (o *OptionsConfig) GetForceTemperature() *float64 {
+	if o == nil {
+		return nil
+	}
+	return o.ForceTemperature
+}
+
+// GetForceTopP safely gets the top_p that replaces the client's one.
+// Returns nil if not set (meaning the client's top_p is kept).
+func (o *OptionsConfig) GetForceTopP() *float64 {
+	if o == nil {
+		return nil
+	}
+	return o.ForceTopP
+}
+
+// GetContext1MModels safely gets the model patterns that support the 1M context window.
+// An empty list means the profile does not restrict the context-1m beta.
+func (o *OptionsConfig) GetContext1MModels() []string {
+	if o == nil {
+		return nil
+	}
+	return o.Context1MModels
+}
+
+// SupportsContext1M reports whether model may be used with the context-1m beta. The model
+// matches if it, or its mapped target in models, matches one of the context_1m_models patterns.
+// Without configured patterns every model is allowed.
+func (o *OptionsConfig) SupportsContext1M(model string) bool {
+	patterns := o.GetContext1MModels()
+	if len(patterns) == 0 {
+		return true
+	}
+	candidates := []string{model}
+	if targetModel, ok := o.GetModels()[model]; ok {
+		candidates = append(candidates, targetModel)
+	}
+	for _, pattern := range patterns {
+		for _, candidate := range candidates {
+			if matchPattern(pattern, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetDeniedModels safely gets the model patterns the profile rejects.
+func (o *OptionsConfig) GetDeniedModels() []string {
+	if o == nil {
+		return nil
+	}
+	return o.DeniedModels
+}
+
+// DeniedModelPattern returns the denied_models pattern that model, or its mapped target in
+// models, matches.
+func (o *OptionsConfig) DeniedModelPattern(model string) (string, bool) {
+	candidates := []string{model}
+	if targetModel, ok := o.GetModels()[model]; ok {
+		candidates = append(candidates, targetModel)
+	}
+	for _, pattern := range o.GetDeniedModels() {
+		for _, candidate := range candidates {
+			if matchPattern(pattern, candidate) {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// GetSystemPrefix safely gets the text prepended to the system prompt.
+func (o *OptionsConfig) GetSystemPrefix() string {
+	if o == nil {
+		return ""
+	}
+	return o.SystemPrefix
+}
+
+// GetSystemSuffix safely gets the text appended to the system prompt.
+func (o *OptionsConfig) GetSystemSuffix() string {
+	if o == nil {
+		return ""
+	}
+	return o.SystemSuffix
+}
+
+// GetRedactedThinkingMode safely gets how redacted_thinking blocks are converted.
+// Returns RedactedThinkingModeDrop unless RedactedThinkingModeEncrypted is configured.
+func (o *OptionsConfig) GetRedactedThinkingMode() string {
+	if o == nil || o.RedactedThinkingMode != RedactedThinkingModeEncrypted {
+		return RedactedThinkingModeDrop
+	}
+	return o.RedactedThinkingMode
+}
+
+// GetImageDetail safely gets the detail level set on converted image parts.
+// Returns "" if not set (meaning the upstream default).
+func (o *OptionsConfig) GetImageDetail() string {
+	if o == nil {
+		return ""
+	}
+	return o.ImageDetail
+}
+
+// GetHeaders safely gets the extra headers sent with every Anthropic request.
+func (a *AnthropicConfig) GetHeaders() map[string]string {
+	if a == nil {
+		return nil
+	}
+	return a.Headers
+}
+
 // GetBaseURL safely gets the Anthropic base URL with a default.
 func (a *AnthropicConfig) GetBaseURL() string {
 	if a == nil || a.BaseURL == "" {
@@ -348,6 +928,14 @@ func (a *AnthropicConfig) GetCountTokensBackend() string {
 	return strings.TrimSuffix(a.CountTokensBackend, "/")
 }
 
+// GetHeaders safely gets the extra headers sent with every OpenRouter request.
+func (o *OpenRouterConfig) GetHeaders() map[string]string {
+	if o == nil {
+		return nil
+	}
+	return o.Headers
+}
+
 // GetBaseURL safely gets the OpenRouter base URL with a default.
 func (o *OpenRouterConfig) GetBaseURL() string {
 	if o == nil || o.BaseURL == "" {
@@ -356,12 +944,36 @@ func (o *OpenRouterConfig) GetBaseURL() string {
 	return strings.TrimSuffix(o.BaseURL, "/")
 }
 
-// GetAPIKey safely gets the OpenRouter API key.
+// GetAPIKey safely gets the OpenRouter API key, falling back to the first of APIKeys.
 func (o *OpenRouterConfig) GetAPIKey() string {
+	if keys := o.GetAPIKeys(); len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// GetAPIKeys safely gets all OpenRouter API keys to rotate between: APIKey first, if set,
+// followed by APIKeys, without empty or duplicate entries.
+func (o *OpenRouterConfig) GetAPIKeys() []string {
 	if o == nil {
-		return ""
+		return nil
 	}
-	return o.APIKey
+	var keys []string
+	for _, key := range append([]string{o.APIKey}, o.APIKeys...) {
+		if key != "" && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetAPIKeyStrategy safely gets how requests choose between multiple API keys.
+// Returns APIKeyStrategyRoundRobin unless APIKeyStrategyLeastRecentlyErrored is configured.
+func (o *OpenRouterConfig) GetAPIKeyStrategy() string {
+	if o == nil || o.APIKeyStrategy != APIKeyStrategyLeastRecentlyErrored {
+		return APIKeyStrategyRoundRobin
+	}
+	return o.APIKeyStrategy
 }
 
 // GetModelReasoningFormat safely gets the model reasoning format map.
@@ -372,10 +984,231 @@ func (o *OpenRouterConfig) GetModelReasoningFormat() map[string]string {
 	return o.ModelReasoningFormat
 }
 
-// GetAllowedProviders safely gets the allowed providers list.
-func (o *OpenRouterConfig) GetAllowedProviders() []string {
+// GetPreferredProviders safely gets the preferred providers list.
+func (o *OpenRouterConfig) GetPreferredProviders() []openrouter.Provider {
+	if o == nil {
+		return nil
+	}
+	return o.PreferredProviders
+}
+
+// GetTransforms safely gets the OpenRouter message transforms.
+func (o *OpenRouterConfig) GetTransforms() []string {
+	if o == nil {
+		return nil
+	}
+	return o.Transforms
+}
+
+// GetFallbackModels safely gets the models OpenRouter falls back to.
+func (o *OpenRouterConfig) GetFallbackModels() []string {
 	if o == nil {
 		return nil
 	}
-	return o.AllowedProviders
+	return o.FallbackModels
+}
+
+// HasTransform reports whether transform is enabled (case-insensitive).
+func (o *OpenRouterConfig) HasTransform(transform string) bool {
+	for _, t := range o.GetTransforms() {
+		if strings.EqualFold(strings.TrimSpace(t), transform) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClampCacheTTL safely gets the clamp_cache_ttl flag.
+func (o *OpenRouterConfig) GetClampCacheTTL() bool {
+	if o == nil {
+		return false
+	}
+	return o.ClampCacheTTL
+}
+
+// GetMergeConsecutiveRoles safely gets the merge_consecutive_roles flag.
+func (o *OpenRouterConfig) GetMergeConsecutiveRoles() bool {
+	if o == nil {
+		return false
+	}
+	return o.MergeConsecutiveRoles
+}
+
+// GetForcePartsContent safely gets the force_parts_content flag.
+func (o *OpenRouterConfig) GetForcePartsContent() bool {
+	if o == nil {
+		return false
+	}
+	return o.ForcePartsContent
+}
+
+// GetProviderSort safely gets the OpenRouter provider sort method, defaulting to throughput.
+func (o *OpenRouterConfig) GetProviderSort() openrouter.ProviderSortMethod {
+	if o == nil || o.ProviderSort == "" {
+		return openrouter.ProviderSortMethodThroughput
+	}
+	return o.ProviderSort
+}
+
+// GetAllowFallbacks safely gets whether OpenRouter may fall back to other providers, defaulting to true.
+func (o *OpenRouterConfig) GetAllowFallbacks() bool {
+	if o == nil || o.AllowFallbacks == nil {
+		return true
+	}
+	return *o.AllowFallbacks
+}
+
+// GetRequireParameters safely gets whether OpenRouter may only route to providers supporting
+// every parameter of the request, defaulting to false.
+func (o *OpenRouterConfig) GetRequireParameters() bool {
+	return o != nil && o.RequireParameters
+}
+
+// GetWebSearch safely gets whether the Anthropic web search tool is served by OpenRouter's web
+// plugin, defaulting to false.
+func (o *OpenRouterConfig) GetWebSearch() bool {
+	return o != nil && o.WebSearch
+}
+
+// GetServiceTier safely gets the service tier requested from upstream providers.
+// Returns an empty string if not set (meaning the provider's default tier).
+func (o *OpenRouterConfig) GetServiceTier() openrouter.ServiceTier {
+	if o == nil {
+		return ""
+	}
+	return o.ServiceTier
+}
+
+// GetRegion safely gets the AWS region, falling back to AWS_REGION and then us-east-1.
+func (b *BedrockConfig) GetRegion() string {
+	if b == nil || b.Region == "" {
+		if region := os.Getenv("AWS_REGION"); region != "" {
+			return region
+		}
+		return "us-east-1"
+	}
+	return b.Region
+}
+
+// GetBaseURL safely gets the Bedrock runtime base URL with a regional default.
+func (b *BedrockConfig) GetBaseURL() string {
+	if b == nil || b.BaseURL == "" {
+		return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", b.GetRegion())
+	}
+	return strings.TrimSuffix(b.BaseURL, "/")
+}
+
+// GetAccessKeyID safely gets the configured AWS access key id.
+func (b *BedrockConfig) GetAccessKeyID() string {
+	if b == nil {
+		return ""
+	}
+	return b.AccessKeyID
+}
+
+// GetSecretAccessKey safely gets the configured AWS secret access key.
+func (b *BedrockConfig) GetSecretAccessKey() string {
+	if b == nil {
+		return ""
+	}
+	return b.SecretAccessKey
+}
+
+// GetSessionToken safely gets the configured AWS session token.
+func (b *BedrockConfig) GetSessionToken() string {
+	if b == nil {
+		return ""
+	}
+	return b.SessionToken
+}
+
+// GetProfile safely gets the AWS shared credentials profile name.
+func (b *BedrockConfig) GetProfile() string {
+	if b == nil {
+		return ""
+	}
+	return b.Profile
+}
+
+// GetModels safely gets the mapping from client-facing model id to Bedrock model id.
+func (b *BedrockConfig) GetModels() map[string]string {
+	if b == nil || b.Models == nil {
+		return make(map[string]string)
+	}
+	return b.Models
+}
+
+// GetBaseURL safely gets the Gemini API base URL with default.
+func (g *GeminiConfig) GetBaseURL() string {
+	if g == nil || g.BaseURL == "" {
+		return "https://generativelanguage.googleapis.com"
+	}
+	return strings.TrimSuffix(g.BaseURL, "/")
+}
+
+// GetAPIKey safely gets the Gemini API key.
+func (g *GeminiConfig) GetAPIKey() string {
+	if g == nil {
+		return ""
+	}
+	return g.APIKey
+}
+
+// GetModels safely gets the mapping from client-facing model id to Gemini model name.
+func (g *GeminiConfig) GetModels() map[string]string {
+	if g == nil || g.Models == nil {
+		return make(map[string]string)
+	}
+	return g.Models
+}
+
+// GetBaseURL safely gets the OpenAI API base URL with default.
+func (o *OpenAIConfig) GetBaseURL() string {
+	if o == nil || o.BaseURL == "" {
+		return "https://api.openai.com"
+	}
+	return strings.TrimSuffix(o.BaseURL, "/")
+}
+
+// GetAPIKey safely gets the OpenAI API key.
+func (o *OpenAIConfig) GetAPIKey() string {
+	if o == nil {
+		return ""
+	}
+	return o.APIKey
+}
+
+// GetPromptCacheKey safely gets the explicit prompt_cache_key of requests.
+// Returns an empty string if not set (meaning the key is derived from each request).
+func (o *OpenAIConfig) GetPromptCacheKey() string {
+	if o == nil {
+		return ""
+	}
+	return o.PromptCacheKey
+}
+
+// GetAllowedTools safely gets the names of the tools the model may call.
+// Returns an empty slice if not set (meaning every tool may be called).
+func (o *OpenAIConfig) GetAllowedTools() []string {
+	if o == nil || o.AllowedTools == nil {
+		return []string{}
+	}
+	return o.AllowedTools
+}
+
+// GetHeaders safely gets the extra headers sent with every OpenAI request.
+func (o *OpenAIConfig) GetHeaders() map[string]string {
+	if o == nil {
+		return nil
+	}
+	return o.Headers
+}
+
+// GetServiceTier safely gets the service tier requested from OpenAI.
+// Returns an empty string if not set (meaning the project's default tier).
+func (o *OpenAIConfig) GetServiceTier() openrouter.ServiceTier {
+	if o == nil {
+		return ""
+	}
+	return o.ServiceTier
 }
@@ -4,7 +4,13 @@ package profile
 
 import (
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 )
 
 var (
@@ -12,6 +18,78 @@ var (
 	ErrNoProfilesDefined = errors.New("no profiles defined in configuration")
 )
 
+// ModelDeniedError is returned by Match when the profile matching a model denies it through
+// options.denied_models.
+type ModelDeniedError struct {
+	Model   string
+	Profile string
+	Pattern string
+}
+
+func (e *ModelDeniedError) Error() string {
+	return fmt.Sprintf("model %q is denied by pattern %q of profile %q", e.Model, e.Pattern, e.Profile)
+}
+
+// NoProfileMatchedError is returned by Match when no profile matches a model. It wraps
+// ErrNoProfileMatched, and lists the configured model patterns closest to the model, which
+// likely name the model the client meant.
+type NoProfileMatchedError struct {
+	Model       string
+	Suggestions []string
+}
+
+func (e *NoProfileMatchedError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("no profile matched for model %q", e.Model)
+	}
+	return fmt.Sprintf("no profile matched for model %q, %s", e.Model, e.DidYouMean())
+}
+
+// DidYouMean returns a question naming the suggested patterns, or "" when there is none.
+func (e *NoProfileMatchedError) DidYouMean() string {
+	if len(e.Suggestions) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(e.Suggestions))
+	for i, suggestion := range e.Suggestions {
+		quoted[i] = strconv.Quote(suggestion)
+	}
+	if len(quoted) == 1 {
+		return fmt.Sprintf("did you mean %s?", quoted[0])
+	}
+	return fmt.Sprintf("did you mean %s or %s?", strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+}
+
+func (e *NoProfileMatchedError) Unwrap() error {
+	return ErrNoProfileMatched
+}
+
+// Values of OptionsConfig.RedactedThinkingMode.
+const (
+	// RedactedThinkingModeDrop removes redacted_thinking blocks when converting requests.
+	RedactedThinkingModeDrop = "drop"
+	// RedactedThinkingModeEncrypted forwards redacted_thinking data as an encrypted reasoning detail.
+	RedactedThinkingModeEncrypted = "encrypted"
+)
+
+// Values of OptionsConfig.ImageDetail.
+const (
+	// ImageDetailLow processes images at a low resolution, for a fixed and small token cost.
+	ImageDetailLow = "low"
+	// ImageDetailHigh processes images at their full resolution.
+	ImageDetailHigh = "high"
+	// ImageDetailAuto lets the upstream choose the resolution.
+	ImageDetailAuto = "auto"
+)
+
+// Values of OpenRouterConfig.APIKeyStrategy.
+const (
+	// APIKeyStrategyRoundRobin uses the configured API keys in turn.
+	APIKeyStrategyRoundRobin = "round_robin"
+	// APIKeyStrategyLeastRecentlyErrored uses the key whose last rate limit or auth error is the oldest.
+	APIKeyStrategyLeastRecentlyErrored = "least_recently_errored"
+)
+
 // Profile represents a configuration profile that can be matched against model names.
 type Profile struct {
 	Name       string            `yaml:"name" json:"name" mapstructure:"name"`
@@ -20,6 +98,9 @@ type Profile struct {
 	Options    *OptionsConfig    `yaml:"options" json:"options" mapstructure:"options"`
 	Anthropic  *AnthropicConfig  `yaml:"anthropic" json:"anthropic" mapstructure:"anthropic"`
 	OpenRouter *OpenRouterConfig `yaml:"openrouter" json:"openrouter" mapstructure:"openrouter"`
+	Bedrock    *BedrockConfig    `yaml:"bedrock" json:"bedrock" mapstructure:"bedrock"`
+	Gemini     *GeminiConfig     `yaml:"gemini" json:"gemini" mapstructure:"gemini"`
+	OpenAI     *OpenAIConfig     `yaml:"openai" json:"openai" mapstructure:"openai"`
 }
 
 // OptionsConfig contains general options for request processing.
@@ -31,35 +112,121 @@ type OptionsConfig struct {
 	ContextWindowResizeFactor  float64           `yaml:"context_window_resize_factor" json:"context_window_resize_factor" mapstructure:"context_window_resize_factor"`
 	DisableCountTokensRequest  bool              `yaml:"disable_count_tokens_request" json:"disable_count_tokens_request" mapstructure:"disable_count_tokens_request"`
 	MinMaxTokens               int               `yaml:"min_max_tokens" json:"min_max_tokens" mapstructure:"min_max_tokens"`
+	DefaultMaxTokens           int               `yaml:"default_max_tokens" json:"default_max_tokens" mapstructure:"default_max_tokens"`
+	MaxTokensCap               int               `yaml:"max_tokens_cap" json:"max_tokens_cap" mapstructure:"max_tokens_cap"`
 	DisallowedTools            []string          `yaml:"disallowed_tools" json:"disallowed_tools" mapstructure:"disallowed_tools"`
+	MaxTools                   int               `yaml:"max_tools" json:"max_tools" mapstructure:"max_tools"`
+	SanitizeToolNames          bool              `yaml:"sanitize_tool_names" json:"sanitize_tool_names" mapstructure:"sanitize_tool_names"`
+	EnforceSerialToolCalls     bool              `yaml:"enforce_serial_tool_calls" json:"enforce_serial_tool_calls" mapstructure:"enforce_serial_tool_calls"`
+	MaxStopSequences           int               `yaml:"max_stop_sequences" json:"max_stop_sequences" mapstructure:"max_stop_sequences"`
+	LogitBias                  map[string]int    `yaml:"logit_bias" json:"logit_bias" mapstructure:"logit_bias"`
 	StreamDataBufferSize       int               `yaml:"stream_data_buffer_size" json:"stream_data_buffer_size" mapstructure:"stream_data_buffer_size"`
+	MaxContentPartBytes        int               `yaml:"max_content_part_bytes" json:"max_content_part_bytes" mapstructure:"max_content_part_bytes"`
+	RequestTimeout             time.Duration     `yaml:"request_timeout" json:"request_timeout" mapstructure:"request_timeout"`
+	MaxConcurrency             int               `yaml:"max_concurrency" json:"max_concurrency" mapstructure:"max_concurrency"`
+	QueueTimeout               time.Duration     `yaml:"queue_timeout" json:"queue_timeout" mapstructure:"queue_timeout"`
+	StrictSchemaSanitize       bool              `yaml:"strict_schema_sanitize" json:"strict_schema_sanitize" mapstructure:"strict_schema_sanitize"`
+	Context1MModels            []string          `yaml:"context_1m_models" json:"context_1m_models" mapstructure:"context_1m_models"`
+	DeniedModels               []string          `yaml:"denied_models" json:"denied_models" mapstructure:"denied_models"`
+	SystemPrefix               string            `yaml:"system_prefix" json:"system_prefix" mapstructure:"system_prefix"`
+	SystemSuffix               string            `yaml:"system_suffix" json:"system_suffix" mapstructure:"system_suffix"`
+	RedactedThinkingMode       string            `yaml:"redacted_thinking_mode" json:"redacted_thinking_mode" mapstructure:"redacted_thinking_mode"`
+	ImageDetail                string            `yaml:"image_detail" json:"image_detail" mapstructure:"image_detail"`
+	KeepAliveInterval          time.Duration     `yaml:"keep_alive_interval" json:"keep_alive_interval" mapstructure:"keep_alive_interval"`
+	ForceTemperature           *float64          `yaml:"force_temperature" json:"force_temperature" mapstructure:"force_temperature"`
+	ForceTopP                  *float64          `yaml:"force_top_p" json:"force_top_p" mapstructure:"force_top_p"`
+	CacheCountTokens           bool              `yaml:"cache_count_tokens" json:"cache_count_tokens" mapstructure:"cache_count_tokens"`
+	CountTokensCacheSize       int               `yaml:"count_tokens_cache_size" json:"count_tokens_cache_size" mapstructure:"count_tokens_cache_size"`
+	CountTokensCacheTTL        time.Duration     `yaml:"count_tokens_cache_ttl" json:"count_tokens_cache_ttl" mapstructure:"count_tokens_cache_ttl"`
+	EstimateTokensOnFailure    bool              `yaml:"estimate_tokens_on_failure" json:"estimate_tokens_on_failure" mapstructure:"estimate_tokens_on_failure"`
+	LocalCountTokens           bool              `yaml:"local_count_tokens" json:"local_count_tokens" mapstructure:"local_count_tokens"`
+	CountTokensModel           string            `yaml:"count_tokens_model" json:"count_tokens_model" mapstructure:"count_tokens_model"`
+	ResponseCache              bool              `yaml:"response_cache" json:"response_cache" mapstructure:"response_cache"`
+	ResponseCacheSize          int               `yaml:"response_cache_size" json:"response_cache_size" mapstructure:"response_cache_size"`
+	ResponseCacheTTL           time.Duration     `yaml:"response_cache_ttl" json:"response_cache_ttl" mapstructure:"response_cache_ttl"`
+	FallbackProfile            string            `yaml:"fallback_profile" json:"fallback_profile" mapstructure:"fallback_profile"`
+	InterimUsageInterval       time.Duration     `yaml:"interim_usage_interval" json:"interim_usage_interval" mapstructure:"interim_usage_interval"`
+	AutoCacheBreakpoints       bool              `yaml:"auto_cache_breakpoints" json:"auto_cache_breakpoints" mapstructure:"auto_cache_breakpoints"`
+	DisableThinking            bool              `yaml:"disable_thinking" json:"disable_thinking" mapstructure:"disable_thinking"`
 }
 
 // ReasoningConfig contains options for reasoning/thinking mode.
 type ReasoningConfig struct {
-	Format    string `yaml:"format" json:"format" mapstructure:"format"`
-	Effort    string `yaml:"effort" json:"effort" mapstructure:"effort"`
-	Delimiter string `yaml:"delimiter" json:"delimiter" mapstructure:"delimiter"`
+	Format              string   `yaml:"format" json:"format" mapstructure:"format"`
+	Effort              string   `yaml:"effort" json:"effort" mapstructure:"effort"`
+	Delimiter           string   `yaml:"delimiter" json:"delimiter" mapstructure:"delimiter"`
+	Exclude             bool     `yaml:"exclude" json:"exclude" mapstructure:"exclude"`
+	PreferSummary       bool     `yaml:"prefer_summary" json:"prefer_summary" mapstructure:"prefer_summary"`
+	EffortSuffixFormats []string `yaml:"effort_suffix_formats" json:"effort_suffix_formats" mapstructure:"effort_suffix_formats"`
 }
 
 // AnthropicConfig contains Anthropic-specific configuration.
 type AnthropicConfig struct {
-	UseRawRequestBody              bool   `yaml:"use_raw_request_body" json:"use_raw_request_body" mapstructure:"use_raw_request_body"`
-	EnablePassThroughMode          bool   `yaml:"enable_pass_through_mode" json:"enable_pass_through_mode" mapstructure:"enable_pass_through_mode"`
-	DisableWebSearchBlockedDomains bool   `yaml:"disable_web_search_blocked_domains" json:"disable_web_search_blocked_domains" mapstructure:"disable_web_search_blocked_domains"`
-	ForceThinking                  bool   `yaml:"force_thinking" json:"force_thinking" mapstructure:"force_thinking"`
-	BaseURL                        string `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
-	APIKey                         string `yaml:"api_key" json:"api_key" mapstructure:"api_key"`
-	Version                        string `yaml:"version" json:"version" mapstructure:"version"`
-	CountTokensBackend             string `yaml:"count_tokens_backend" json:"count_tokens_backend" mapstructure:"count_tokens_backend"`
+	UseRawRequestBody              bool              `yaml:"use_raw_request_body" json:"use_raw_request_body" mapstructure:"use_raw_request_body"`
+	EnablePassThroughMode          bool              `yaml:"enable_pass_through_mode" json:"enable_pass_through_mode" mapstructure:"enable_pass_through_mode"`
+	DisableWebSearchBlockedDomains bool              `yaml:"disable_web_search_blocked_domains" json:"disable_web_search_blocked_domains" mapstructure:"disable_web_search_blocked_domains"`
+	ForceThinking                  bool              `yaml:"force_thinking" json:"force_thinking" mapstructure:"force_thinking"`
+	BaseURL                        string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	APIKey                         string            `yaml:"api_key" json:"api_key" mapstructure:"api_key"`
+	Version                        string            `yaml:"version" json:"version" mapstructure:"version"`
+	CountTokensBackend             string            `yaml:"count_tokens_backend" json:"count_tokens_backend" mapstructure:"count_tokens_backend"`
+	Headers                        map[string]string `yaml:"headers" json:"headers" mapstructure:"headers"`
 }
 
 // OpenRouterConfig contains OpenRouter-specific configuration.
 type OpenRouterConfig struct {
-	BaseURL              string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
-	APIKey               string            `yaml:"api_key" json:"api_key" mapstructure:"api_key"`
-	ModelReasoningFormat map[string]string `yaml:"model_reasoning_format" json:"model_reasoning_format" mapstructure:"model_reasoning_format"`
-	AllowedProviders     []string          `yaml:"allowed_providers" json:"allowed_providers" mapstructure:"allowed_providers"`
+	BaseURL               string                        `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	APIKey                string                        `yaml:"api_key" json:"api_key" mapstructure:"api_key"`
+	APIKeys               []string                      `yaml:"api_keys" json:"api_keys" mapstructure:"api_keys"`
+	APIKeyStrategy        string                        `yaml:"api_key_strategy" json:"api_key_strategy" mapstructure:"api_key_strategy"`
+	ModelReasoningFormat  map[string]string             `yaml:"model_reasoning_format" json:"model_reasoning_format" mapstructure:"model_reasoning_format"`
+	PreferredProviders    []openrouter.Provider         `yaml:"preferred_providers" json:"preferred_providers" mapstructure:"preferred_providers"`
+	Headers               map[string]string             `yaml:"headers" json:"headers" mapstructure:"headers"`
+	Transforms            []string                      `yaml:"transforms" json:"transforms" mapstructure:"transforms"`
+	ClampCacheTTL         bool                          `yaml:"clamp_cache_ttl" json:"clamp_cache_ttl" mapstructure:"clamp_cache_ttl"`
+	MergeConsecutiveRoles bool                          `yaml:"merge_consecutive_roles" json:"merge_consecutive_roles" mapstructure:"merge_consecutive_roles"`
+	ForcePartsContent     bool                          `yaml:"force_parts_content" json:"force_parts_content" mapstructure:"force_parts_content"`
+	ProviderSort          openrouter.ProviderSortMethod `yaml:"provider_sort" json:"provider_sort" mapstructure:"provider_sort"`
+	AllowFallbacks        *bool                         `yaml:"allow_fallbacks" json:"allow_fallbacks" mapstructure:"allow_fallbacks"`
+	RequireParameters     bool                          `yaml:"require_parameters" json:"require_parameters" mapstructure:"require_parameters"`
+	ServiceTier           openrouter.ServiceTier        `yaml:"service_tier" json:"service_tier" mapstructure:"service_tier"`
+	FallbackModels        []string                      `yaml:"fallback_models" json:"fallback_models" mapstructure:"fallback_models"`
+	WebSearch             bool                          `yaml:"web_search" json:"web_search" mapstructure:"web_search"`
+}
+
+// BedrockConfig contains AWS Bedrock-specific configuration.
+// Credentials are taken from AccessKeyID/SecretAccessKey when set, otherwise from the
+// named Profile in the AWS shared credentials file, otherwise from the AWS_* environment variables.
+type BedrockConfig struct {
+	Region          string            `yaml:"region" json:"region" mapstructure:"region"`
+	BaseURL         string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	AccessKeyID     string            `yaml:"access_key_id" json:"access_key_id" mapstructure:"access_key_id"`
+	SecretAccessKey string            `yaml:"secret_access_key" json:"secret_access_key" mapstructure:"secret_access_key"`
+	SessionToken    string            `yaml:"session_token" json:"session_token" mapstructure:"session_token"`
+	Profile         string            `yaml:"profile" json:"profile" mapstructure:"profile"`
+	Models          map[string]string `yaml:"models" json:"models" mapstructure:"models"`
+}
+
+// GeminiConfig contains Google Gemini API-specific configuration.
+type GeminiConfig struct {
+	BaseURL string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	APIKey  string            `yaml:"api_key" json:"api_key" mapstructure:"api_key"`
+	Models  map[string]string `yaml:"models" json:"models" mapstructure:"models"`
+}
+
+// OpenAIConfig contains OpenAI Responses API-specific configuration.
+type OpenAIConfig struct {
+	BaseURL string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	APIKey  string            `yaml:"api_key" json:"api_key" mapstructure:"api_key"`
+	Headers map[string]string `yaml:"headers" json:"headers" mapstructure:"headers"`
+	// AllowedTools restricts the tools the model may call to a subset of the request's tools,
+	// keeping the other tool definitions in the request.
+	AllowedTools []string `yaml:"allowed_tools" json:"allowed_tools" mapstructure:"allowed_tools"`
+	// PromptCacheKey is sent as the prompt_cache_key of every request instead of the key derived
+	// from the system prompt and tools of each request.
+	PromptCacheKey string `yaml:"prompt_cache_key" json:"prompt_cache_key" mapstructure:"prompt_cache_key"`
+	// ServiceTier is the processing tier requested for every request; unset uses the project's default.
+	ServiceTier openrouter.ServiceTier `yaml:"service_tier" json:"service_tier" mapstructure:"service_tier"`
 }
 
 // ProfileManager manages a collection of profiles and provides model-to-profile matching.
@@ -80,19 +247,98 @@ func (pm *ProfileManager) AddProfile(p *Profile) {
 }
 
 // Match finds the first profile that matches the given model name.
-// Returns ErrNoProfileMatched if no profile matches.
+// Returns a *NoProfileMatchedError, which wraps ErrNoProfileMatched, if no profile matches, and a
+// *ModelDeniedError if the matching profile denies the model; later profiles are not considered then.
 func (pm *ProfileManager) Match(model string) (*Profile, error) {
 	if len(pm.profiles) == 0 {
 		return nil, ErrNoProfilesDefined
 	}
+	for _, p := range pm.profiles {
+		if _, matched := p.MatchedPattern(model); matched {
+			if pattern, denied := p.Options.DeniedModelPattern(model); denied {
+				return nil, &ModelDeniedError{Model: model, Profile: p.Name, Pattern: pattern}
+			}
+			return p, nil
+		}
+	}
+	return nil, &NoProfileMatchedError{Model: model, Suggestions: pm.suggestPatterns(model)}
+}
+
+// maxPatternSuggestions is the number of patterns a NoProfileMatchedError suggests at most.
+const maxPatternSuggestions = 3
+
+// suggestPatterns returns the model patterns of pm within a few edits of model, closest first.
+// A wildcard pattern is compared with the start of model, as long as its prefix.
+func (pm *ProfileManager) suggestPatterns(model string) []string {
+	type suggestion struct {
+		pattern  string
+		distance int
+	}
+	var suggestions []suggestion
 	for _, p := range pm.profiles {
 		for _, pattern := range p.Models {
-			if matchPattern(pattern, model) {
-				return p, nil
+			if pattern == "*" || slices.ContainsFunc(suggestions, func(s suggestion) bool { return s.pattern == pattern }) {
+				continue
+			}
+			target, candidate := strings.TrimSuffix(pattern, "*"), model
+			if strings.HasSuffix(pattern, "*") && len(candidate) > len(target) {
+				candidate = candidate[:len(target)]
+			}
+			// Allow about one edit per three characters, so that short names only suggest close typos.
+			distance := levenshtein(strings.ToLower(candidate), strings.ToLower(target))
+			if distance <= max(1, len(target)/3) {
+				suggestions = append(suggestions, suggestion{pattern: pattern, distance: distance})
 			}
 		}
 	}
-	return nil, ErrNoProfileMatched
+	slices.SortStableFunc(suggestions, func(a, b suggestion) int { return a.distance - b.distance })
+	patterns := make([]string, 0, min(len(suggestions), maxPatternSuggestions))
+	for _, s := range suggestions[:min(len(suggestions), maxPatternSuggestions)] {
+		patterns = append(patterns, s.pattern)
+	}
+	return patterns
+}
+
+// levenshtein returns the edit distance between a and b, counted in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// MatchedPattern returns the first model pattern of p that matches model.
+func (p *Profile) MatchedPattern(model string) (string, bool) {
+	for _, pattern := range p.Models {
+		if matchPattern(pattern, model) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Get returns the profile with the given name.
+func (pm *ProfileManager) Get(name string) (*Profile, bool) {
+	for _, p := range pm.profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
 }
 
 // Profiles returns all registered profiles.
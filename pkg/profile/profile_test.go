@@ -1,9 +1,20 @@
 package profile
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"log/slog"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
 )
 
 func TestMatchPattern(t *testing.T) {
@@ -84,7 +95,7 @@ func TestProfileManager_Match(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.model, func(t *testing.T) {
 			got, err := pm.Match(tt.model)
-			if err != tt.wantErr {
+			if !errors.Is(err, tt.wantErr) {
 				t.Errorf("Match(%q) error = %v, want %v", tt.model, err, tt.wantErr)
 				return
 			}
@@ -95,6 +106,40 @@ func TestProfileManager_Match(t *testing.T) {
 	}
 }
 
+func TestProfileManager_MatchSuggestions(t *testing.T) {
+	pm := NewProfileManager()
+	pm.AddProfile(&Profile{Name: "claude", Models: []string{"claude-*"}, Provider: "anthropic"})
+	pm.AddProfile(&Profile{Name: "gpt", Models: []string{"gpt-5", "gpt-5-mini", "gpt-4", "o3"}, Provider: "openrouter"})
+
+	tests := []struct {
+		model string
+		want  []string
+	}{
+		{"cluade-sonnet-4", []string{"claude-*"}},
+		{"gpt5", []string{"gpt-5"}},
+		{"gpt-5-mino", []string{"gpt-5-mini"}},
+		{"gpt-6", []string{"gpt-5", "gpt-4"}},
+		{"o4", []string{"o3"}},
+		{"llama-3", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			_, err := pm.Match(tt.model)
+			var noMatchErr *NoProfileMatchedError
+			if !errors.As(err, &noMatchErr) || !errors.Is(err, ErrNoProfileMatched) {
+				t.Fatalf("Match(%q) error = %v, want a *NoProfileMatchedError", tt.model, err)
+			}
+			if !reflect.DeepEqual(noMatchErr.Suggestions, tt.want) && (len(noMatchErr.Suggestions) > 0 || len(tt.want) > 0) {
+				t.Errorf("suggestions = %q, want %q", noMatchErr.Suggestions, tt.want)
+			}
+		})
+	}
+	_, err := pm.Match("gpt-6")
+	if want := `no profile matched for model "gpt-6", did you mean "gpt-5" or "gpt-4"?`; err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %s", err, want)
+	}
+}
+
 func TestProfileManager_MatchPriority(t *testing.T) {
 	pm := NewProfileManager()
 
@@ -121,6 +166,40 @@ func TestProfileManager_MatchPriority(t *testing.T) {
 	}
 }
 
+func TestProfileManager_MatchDeniedModels(t *testing.T) {
+	pm := NewProfileManager()
+	pm.AddProfile(&Profile{
+		Name:     "cheap",
+		Models:   []string{"*"},
+		Provider: "openrouter",
+		Options: &OptionsConfig{
+			DeniedModels: []string{"claude-opus-*", "openai/o1-pro"},
+			Models:       map[string]string{"reasoner": "openai/o1-pro"},
+		},
+	})
+
+	if got, err := pm.Match("claude-sonnet-4"); err != nil || got.Name != "cheap" {
+		t.Errorf("Match(claude-sonnet-4) = %v, %v, want profile cheap", got, err)
+	}
+	for _, tt := range []struct {
+		model   string
+		pattern string
+	}{
+		{"claude-opus-4-1", "claude-opus-*"},
+		{"reasoner", "openai/o1-pro"},
+	} {
+		got, err := pm.Match(tt.model)
+		var deniedErr *ModelDeniedError
+		if !errors.As(err, &deniedErr) {
+			t.Errorf("Match(%q) = %v, %v, want a ModelDeniedError", tt.model, got, err)
+			continue
+		}
+		if deniedErr.Model != tt.model || deniedErr.Profile != "cheap" || deniedErr.Pattern != tt.pattern {
+			t.Errorf("Match(%q) error = %+v", tt.model, deniedErr)
+		}
+	}
+}
+
 func TestProfileManager_EmptyProfiles(t *testing.T) {
 	pm := NewProfileManager()
 
@@ -227,6 +306,21 @@ func TestOptionsConfig_Getters(t *testing.T) {
 	if nilOpts.GetReasoningDelimiter() != "/" {
 		t.Error("GetReasoningDelimiter on nil should return /")
 	}
+	if nilOpts.GetMaxContentPartBytes() != 10*1024*1024 {
+		t.Error("GetMaxContentPartBytes on nil should return 10MB")
+	}
+	if nilOpts.GetRequestTimeout() != 0 {
+		t.Error("GetRequestTimeout on nil should return 0 (no timeout)")
+	}
+	if nilOpts.GetKeepAliveInterval() != 0 {
+		t.Error("GetKeepAliveInterval on nil should return 0 (disabled)")
+	}
+	if nilOpts.GetCacheCountTokens() || nilOpts.GetCountTokensCacheSize() != 1024 || nilOpts.GetCountTokensCacheTTL() != 5*time.Minute {
+		t.Error("count tokens cache getters on nil should return disabled, 1024 and 5m")
+	}
+	if nilOpts.GetEstimateTokensOnFailure() {
+		t.Error("GetEstimateTokensOnFailure on nil should return false")
+	}
 
 	// Test zero value
 	opts := &OptionsConfig{}
@@ -299,8 +393,11 @@ func TestOpenRouterConfig_Getters(t *testing.T) {
 	if nilCfg.GetModelReasoningFormat() == nil {
 		t.Error("GetModelReasoningFormat on nil should return empty map, not nil")
 	}
-	if nilCfg.GetAllowedProviders() != nil {
-		t.Error("GetAllowedProviders on nil should return nil")
+	if nilCfg.GetPreferredProviders() != nil {
+		t.Error("GetPreferredProviders on nil should return nil")
+	}
+	if nilCfg.GetClampCacheTTL() {
+		t.Error("GetClampCacheTTL on nil should return false")
 	}
 
 	// Test with values
@@ -308,9 +405,554 @@ func TestOpenRouterConfig_Getters(t *testing.T) {
 		BaseURL:              "https://custom.openrouter.com/api/",
 		APIKey:               "or-custom",
 		ModelReasoningFormat: map[string]string{"model": "format"},
-		AllowedProviders:     []string{"anthropic"},
+		PreferredProviders:   []openrouter.Provider{openrouter.ProviderAnthropic},
 	}
 	if cfg.GetBaseURL() != "https://custom.openrouter.com/api" {
 		t.Errorf("GetBaseURL should trim trailing slash, got %q", cfg.GetBaseURL())
 	}
+	if providers := cfg.GetPreferredProviders(); len(providers) != 1 || providers[0] != openrouter.ProviderAnthropic {
+		t.Errorf("GetPreferredProviders should return set value, got %v", providers)
+	}
+}
+
+// captureLogs redirects the default slog logger into a buffer for the duration of fn.
+func captureLogs(t *testing.T, fn func()) string {
+	t.Helper()
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer slog.SetDefault(orig)
+	fn()
+	return buf.String()
+}
+
+func loadTestViper(t *testing.T, config string) *viper.Viper {
+	t.Helper()
+	v := viper.NewWithOptions(viper.KeyDelimiter(delimiter.ViperKeyDelimiter))
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	return v
+}
+
+func TestLoadFromViper_PreferredProviders(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    openrouter:
+      preferred_providers:
+        - anthropic
+        - google-vertex
+`)
+	var pm *ProfileManager
+	logs := captureLogs(t, func() {
+		var err error
+		if pm, err = LoadFromViper(v); err != nil {
+			t.Fatalf("LoadFromViper failed: %v", err)
+		}
+	})
+	if strings.Contains(logs, "unknown OpenRouter provider") {
+		t.Errorf("unexpected warning for known providers: %s", logs)
+	}
+	prof, err := pm.Match("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	want := []openrouter.Provider{openrouter.ProviderAnthropic, openrouter.ProviderGoogleVertex}
+	if !reflect.DeepEqual(prof.OpenRouter.GetPreferredProviders(), want) {
+		t.Errorf("PreferredProviders = %v, want %v", prof.OpenRouter.GetPreferredProviders(), want)
+	}
+}
+
+func TestLoadFromViper_UnknownPreferredProviderWarns(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    openrouter:
+      preferred_providers:
+        - anthropic
+        - not-a-provider
+`)
+	var pm *ProfileManager
+	logs := captureLogs(t, func() {
+		var err error
+		if pm, err = LoadFromViper(v); err != nil {
+			t.Fatalf("LoadFromViper failed: %v", err)
+		}
+	})
+	if !strings.Contains(logs, "level=WARN") || !strings.Contains(logs, "not-a-provider") {
+		t.Errorf("expected warning for unknown provider, got: %q", logs)
+	}
+	if strings.Count(logs, "unknown OpenRouter provider") != 1 {
+		t.Errorf("expected exactly one warning, got: %q", logs)
+	}
+	// Unknown providers are warned about, not dropped.
+	providers := pm.Profiles()[0].OpenRouter.GetPreferredProviders()
+	if len(providers) != 2 || providers[1] != "not-a-provider" {
+		t.Errorf("unexpected providers: %v", providers)
+	}
+}
+
+func TestLoadFromViper_LegacyAllowedProviders(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    openrouter:
+      allowed_providers:
+        - amazon-bedrock
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	providers := pm.Profiles()[0].OpenRouter.GetPreferredProviders()
+	if len(providers) != 1 || providers[0] != openrouter.ProviderAmazonBedrock {
+		t.Errorf("unexpected providers: %v", providers)
+	}
+}
+
+func TestLoadFromViper_RequestTimeout(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    options:
+      request_timeout: 90s
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := prof.Options.GetRequestTimeout(); got != 90*time.Second {
+		t.Errorf("GetRequestTimeout() = %s, want 90s", got)
+	}
+}
+
+func TestLoadFromViper_Bedrock(t *testing.T) {
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("TEST_BEDROCK_SECRET", "secret")
+	v := loadTestViper(t, `
+profiles:
+  bedrock:
+    models: ["claude-*"]
+    provider: bedrock
+    bedrock:
+      access_key_id: AKID
+      secret_access_key: ${TEST_BEDROCK_SECRET}
+      models:
+        claude-sonnet-4: us.anthropic.claude-sonnet-4-20250514-v1:0
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := prof.Bedrock.GetRegion(); got != "eu-west-1" {
+		t.Errorf("GetRegion() = %q, want eu-west-1", got)
+	}
+	if got := prof.Bedrock.GetBaseURL(); got != "https://bedrock-runtime.eu-west-1.amazonaws.com" {
+		t.Errorf("GetBaseURL() = %q", got)
+	}
+	if got := prof.Bedrock.GetSecretAccessKey(); got != "secret" {
+		t.Errorf("GetSecretAccessKey() = %q, want expanded value", got)
+	}
+	if got := prof.Bedrock.GetModels()["claude-sonnet-4"]; got != "us.anthropic.claude-sonnet-4-20250514-v1:0" {
+		t.Errorf("GetModels() mapping = %q", got)
+	}
+
+	var nilBedrock *BedrockConfig
+	t.Setenv("AWS_REGION", "")
+	if got := nilBedrock.GetRegion(); got != "us-east-1" {
+		t.Errorf("GetRegion on nil = %q, want us-east-1", got)
+	}
+	if got := nilBedrock.GetBaseURL(); got != "https://bedrock-runtime.us-east-1.amazonaws.com" {
+		t.Errorf("GetBaseURL on nil = %q", got)
+	}
+}
+
+func TestLoadFromViper_OpenAI(t *testing.T) {
+	t.Setenv("TEST_OPENAI_API_KEY", "sk-test")
+	v := loadTestViper(t, `
+profiles:
+  openai:
+    models: ["gpt-*"]
+    provider: openai
+    openai:
+      api_key: ${TEST_OPENAI_API_KEY}
+      base_url: https://proxy.example.com/
+      headers:
+        OpenAI-Organization: ${TEST_OPENAI_API_KEY}-org
+      allowed_tools: [Read, Grep]
+      prompt_cache_key: team-cache
+      service_tier: flex
+  openai-batch:
+    models: ["batch-*"]
+    provider: openai
+    openai:
+      service_tier: express
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("gpt-5")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := prof.OpenAI.GetAPIKey(); got != "sk-test" {
+		t.Errorf("GetAPIKey() = %q, want expanded value", got)
+	}
+	if got := prof.OpenAI.GetBaseURL(); got != "https://proxy.example.com" {
+		t.Errorf("GetBaseURL() = %q", got)
+	}
+	if got := prof.OpenAI.GetHeaders()["openai-organization"]; got != "sk-test-org" {
+		t.Errorf("GetHeaders() = %v, want expanded values", prof.OpenAI.GetHeaders())
+	}
+	if got := prof.OpenAI.GetPromptCacheKey(); got != "team-cache" {
+		t.Errorf("GetPromptCacheKey() = %q", got)
+	}
+	if got := prof.OpenAI.GetAllowedTools(); len(got) != 2 || got[0] != "Read" || got[1] != "Grep" {
+		t.Errorf("GetAllowedTools() = %q", got)
+	}
+	if got := prof.OpenAI.GetServiceTier(); got != openrouter.ServiceTierFlex {
+		t.Errorf("GetServiceTier() = %q, want flex", got)
+	}
+	if batch, _ := pm.Get("openai-batch"); batch.OpenAI.GetServiceTier() != "" {
+		t.Errorf("unknown service tier should be dropped, got %q", batch.OpenAI.GetServiceTier())
+	}
+
+	var nilOpenAI *OpenAIConfig
+	if nilOpenAI.GetAPIKey() != "" || nilOpenAI.GetBaseURL() != "https://api.openai.com" || len(nilOpenAI.GetAllowedTools()) != 0 || nilOpenAI.GetHeaders() != nil || nilOpenAI.GetServiceTier() != "" {
+		t.Error("nil OpenAIConfig getters should return defaults")
+	}
+}
+
+func TestOptionsConfig_SupportsContext1M(t *testing.T) {
+	var nilOpts *OptionsConfig
+	if !nilOpts.SupportsContext1M("any-model") {
+		t.Error("SupportsContext1M on nil should allow every model")
+	}
+	opts := &OptionsConfig{
+		Models:          map[string]string{"claude-sonnet-4-20250514": "anthropic/claude-sonnet-4"},
+		Context1MModels: []string{"anthropic/claude-sonnet-4*", "claude-opus-4-6"},
+	}
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"claude-sonnet-4-20250514", true}, // via mapped target
+		{"anthropic/claude-sonnet-4.5", true},
+		{"claude-opus-4-6", true},
+		{"claude-opus-4-1-20250805", false},
+		{"claude-haiku-4-5", false},
+	}
+	for _, tt := range tests {
+		if got := opts.SupportsContext1M(tt.model); got != tt.want {
+			t.Errorf("SupportsContext1M(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestOptionsConfig_GetRedactedThinkingMode(t *testing.T) {
+	var nilOpts *OptionsConfig
+	if got := nilOpts.GetRedactedThinkingMode(); got != RedactedThinkingModeDrop {
+		t.Errorf("GetRedactedThinkingMode on nil = %q, want %q", got, RedactedThinkingModeDrop)
+	}
+	for mode, want := range map[string]string{
+		"":          RedactedThinkingModeDrop,
+		"drop":      RedactedThinkingModeDrop,
+		"encrypted": RedactedThinkingModeEncrypted,
+		"unknown":   RedactedThinkingModeDrop,
+	} {
+		if got := (&OptionsConfig{RedactedThinkingMode: mode}).GetRedactedThinkingMode(); got != want {
+			t.Errorf("GetRedactedThinkingMode(%q) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestLoadFromViper_ProviderRouting(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  interactive:
+    models: ["claude-*"]
+    provider: openrouter
+    openrouter:
+      provider_sort: latency
+      allow_fallbacks: false
+      require_parameters: true
+      service_tier: priority
+  batch:
+    models: ["batch-*"]
+    provider: openrouter
+    openrouter:
+      service_tier: express
+  default:
+    models: ["*"]
+    provider: openrouter
+    openrouter:
+      api_key: key
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	interactive, _ := pm.Get("interactive")
+	if got := interactive.OpenRouter.GetProviderSort(); got != openrouter.ProviderSortMethodLatency {
+		t.Errorf("provider sort = %q, want latency", got)
+	}
+	if interactive.OpenRouter.GetAllowFallbacks() {
+		t.Error("allow_fallbacks: false should be kept")
+	}
+	def, _ := pm.Get("default")
+	if got := def.OpenRouter.GetProviderSort(); got != openrouter.ProviderSortMethodThroughput {
+		t.Errorf("default provider sort = %q, want throughput", got)
+	}
+	if def.OpenRouter.AllowFallbacks != nil || !def.OpenRouter.GetAllowFallbacks() {
+		t.Error("allow_fallbacks should default to true when unset")
+	}
+	if !interactive.OpenRouter.GetRequireParameters() || def.OpenRouter.GetRequireParameters() {
+		t.Error("require_parameters should be loaded, and default to false when unset")
+	}
+	if got := interactive.OpenRouter.GetServiceTier(); got != openrouter.ServiceTierPriority {
+		t.Errorf("service tier = %q, want priority", got)
+	}
+	if batch, _ := pm.Get("batch"); batch.OpenRouter.GetServiceTier() != "" {
+		t.Errorf("unknown service tier should be dropped, got %q", batch.OpenRouter.GetServiceTier())
+	}
+	if def.OpenRouter.GetServiceTier() != "" {
+		t.Error("service tier should be empty when unset")
+	}
+}
+
+func TestLoadFromViper_Headers(t *testing.T) {
+	t.Setenv("TEST_CF_CLIENT_SECRET", "secret")
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    anthropic:
+      headers:
+        CF-Access-Client-Secret: ${TEST_CF_CLIENT_SECRET}
+    openrouter:
+      headers:
+        X-Org-Id: org-123
+      transforms: ["middle-out"]
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	// Viper lower-cases map keys; header names are case-insensitive.
+	if got := prof.Anthropic.GetHeaders()["cf-access-client-secret"]; got != "secret" {
+		t.Errorf("anthropic header = %q, want expanded value", got)
+	}
+	if got := prof.OpenRouter.GetHeaders()["x-org-id"]; got != "org-123" {
+		t.Errorf("openrouter header = %q, want org-123", got)
+	}
+	if !prof.OpenRouter.HasTransform("Middle-Out") {
+		t.Errorf("transforms = %v, want middle-out enabled", prof.OpenRouter.GetTransforms())
+	}
+	var nilOpenRouter *OpenRouterConfig
+	if nilOpenRouter.GetTransforms() != nil || nilOpenRouter.HasTransform("middle-out") {
+		t.Error("nil OpenRouterConfig should have no transforms")
+	}
+	var nilAnthropic *AnthropicConfig
+	if nilAnthropic.GetHeaders() != nil {
+		t.Error("GetHeaders on nil should return nil")
+	}
+}
+
+func TestLoadFromViper_ExpandEnv(t *testing.T) {
+	t.Setenv("TEST_OPENROUTER_API_KEY", "sk-or-123")
+	t.Setenv("TEST_ANTHROPIC_BASE_URL", "https://anthropic.example.com")
+	os.Unsetenv("TEST_UNSET_API_KEY")
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    anthropic:
+      api_key: ${TEST_UNSET_API_KEY}
+      base_url: ${TEST_ANTHROPIC_BASE_URL}/proxy
+    openrouter:
+      api_key: ${TEST_OPENROUTER_API_KEY}
+      headers:
+        X-Title: ${TEST_UNSET_API_KEY}
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := prof.OpenRouter.GetAPIKey(); got != "sk-or-123" {
+		t.Errorf("openrouter api_key = %q, want sk-or-123", got)
+	}
+	if got := prof.Anthropic.GetBaseURL(); got != "https://anthropic.example.com/proxy" {
+		t.Errorf("anthropic base_url = %q, want expanded value", got)
+	}
+	// Unset variables fall back to the literal value.
+	if got := prof.Anthropic.GetAPIKey(); got != "${TEST_UNSET_API_KEY}" {
+		t.Errorf("anthropic api_key = %q, want literal reference", got)
+	}
+	if got := prof.OpenRouter.GetHeaders()["x-title"]; got != "${TEST_UNSET_API_KEY}" {
+		t.Errorf("openrouter header = %q, want literal reference", got)
+	}
+}
+
+func TestLoadFromViper_APIKeys(t *testing.T) {
+	t.Setenv("TEST_OPENROUTER_API_KEY_2", "sk-or-2")
+	v := loadTestViper(t, `
+profiles:
+  default:
+    models: ["*"]
+    provider: openrouter
+    openrouter:
+      api_key: sk-or-1
+      api_keys: ["${TEST_OPENROUTER_API_KEY_2}", "", "sk-or-1", "sk-or-3"]
+      api_key_strategy: least_recently_errored
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("claude-sonnet-4")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := strings.Join(prof.OpenRouter.GetAPIKeys(), ","); got != "sk-or-1,sk-or-2,sk-or-3" {
+		t.Errorf("GetAPIKeys() = %s, want api_key followed by expanded, deduplicated api_keys", got)
+	}
+	if got := prof.OpenRouter.GetAPIKeyStrategy(); got != APIKeyStrategyLeastRecentlyErrored {
+		t.Errorf("GetAPIKeyStrategy() = %q", got)
+	}
+
+	config := &OpenRouterConfig{APIKeys: []string{"sk-or-2", "sk-or-3"}, APIKeyStrategy: "random"}
+	if got := config.GetAPIKey(); got != "sk-or-2" {
+		t.Errorf("GetAPIKey() = %q, want first of api_keys", got)
+	}
+	if got := config.GetAPIKeyStrategy(); got != APIKeyStrategyRoundRobin {
+		t.Errorf("GetAPIKeyStrategy() = %q, want round_robin for unknown strategies", got)
+	}
+	var nilConfig *OpenRouterConfig
+	if keys := nilConfig.GetAPIKeys(); keys != nil || nilConfig.GetAPIKey() != "" {
+		t.Errorf("nil config keys = %v", keys)
+	}
+}
+
+func TestLoadFromViper_SamplingOverrides(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  forced:
+    models: ["claude-*"]
+    provider: openrouter
+    options:
+      force_temperature: 0
+      force_top_p: 0.95
+  default:
+    models: ["*"]
+    provider: openrouter
+    options:
+      strict: true
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	forced, _ := pm.Get("forced")
+	if got := forced.Options.GetForceTemperature(); got == nil || *got != 0 {
+		t.Errorf("force_temperature = %v, want explicit 0", got)
+	}
+	if got := forced.Options.GetForceTopP(); got == nil || *got != 0.95 {
+		t.Errorf("force_top_p = %v, want 0.95", got)
+	}
+	def, _ := pm.Get("default")
+	if def.Options.GetForceTemperature() != nil || def.Options.GetForceTopP() != nil {
+		t.Error("unset overrides should be nil")
+	}
+	var nilOpts *OptionsConfig
+	if nilOpts.GetForceTemperature() != nil || nilOpts.GetForceTopP() != nil {
+		t.Error("overrides on nil options should be nil")
+	}
+}
+
+func TestLoadFromViper_LogitBias(t *testing.T) {
+	v := loadTestViper(t, `
+profiles:
+  biased:
+    models: ["*"]
+    provider: openrouter
+    options:
+      logit_bias:
+        "50256": -100
+        "1734": 5
+        "not-a-token": 10
+        "42": 150
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	biased, _ := pm.Get("biased")
+	if got, want := biased.Options.GetLogitBias(), map[string]int{"50256": -100, "1734": 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("logit_bias = %v, want %v without the invalid entries", got, want)
+	}
+}
+
+func TestLoadFromViper_Gemini(t *testing.T) {
+	t.Setenv("TEST_GEMINI_API_KEY", "gemini-key")
+	v := loadTestViper(t, `
+profiles:
+  gemini:
+    models: ["gemini-*"]
+    provider: gemini
+    gemini:
+      api_key: ${TEST_GEMINI_API_KEY}
+      models:
+        gemini-pro: gemini-2.5-pro
+`)
+	pm, err := LoadFromViper(v)
+	if err != nil {
+		t.Fatalf("LoadFromViper failed: %v", err)
+	}
+	prof, err := pm.Match("gemini-pro")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := prof.Gemini.GetAPIKey(); got != "gemini-key" {
+		t.Errorf("GetAPIKey() = %q, want expanded value", got)
+	}
+	if got := prof.Gemini.GetBaseURL(); got != "https://generativelanguage.googleapis.com" {
+		t.Errorf("GetBaseURL() = %q", got)
+	}
+	if got := prof.Gemini.GetModels()["gemini-pro"]; got != "gemini-2.5-pro" {
+		t.Errorf("GetModels() mapping = %q", got)
+	}
+
+	var nilGemini *GeminiConfig
+	if nilGemini.GetAPIKey() != "" || nilGemini.GetModels() == nil {
+		t.Error("nil GeminiConfig getters should return defaults")
+	}
 }
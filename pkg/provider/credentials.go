@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/bedrock"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+// LoadBedrockCredentials resolves AWS credentials for cfg, in order of precedence:
+//  1. access_key_id/secret_access_key from the profile config;
+//  2. the AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY environment variables, unless a named profile is configured;
+//  3. the named profile (or AWS_PROFILE, or "default") in the shared credentials file.
+func LoadBedrockCredentials(cfg *profile.BedrockConfig) (*bedrock.Credentials, error) {
+	if accessKeyID, secretAccessKey := cfg.GetAccessKeyID(), cfg.GetSecretAccessKey(); accessKeyID != "" && secretAccessKey != "" {
+		return &bedrock.Credentials{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			SessionToken:    cfg.GetSessionToken(),
+		}, nil
+	}
+	profileName := cfg.GetProfile()
+	if profileName == "" {
+		accessKeyID, secretAccessKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if accessKeyID != "" && secretAccessKey != "" {
+			return &bedrock.Credentials{
+				AccessKeyID:     accessKeyID,
+				SecretAccessKey: secretAccessKey,
+				SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			}, nil
+		}
+		if profileName = os.Getenv("AWS_PROFILE"); profileName == "" {
+			profileName = "default"
+		}
+	}
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("bedrock: no credentials configured: %w", err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	creds, err := readSharedCredentials(path, profileName)
+	if err != nil {
+		return nil, fmt.Errorf("bedrock: no credentials configured: %w", err)
+	}
+	return creds, nil
+}
+
+// readSharedCredentials reads one profile from an AWS shared credentials file (INI format).
+func readSharedCredentials(path string, profileName string) (*bedrock.Credentials, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var (
+		creds   bedrock.Credentials
+		section string
+	)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profileName {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("profile %q not found in %s", profileName, path)
+	}
+	return &creds, nil
+}
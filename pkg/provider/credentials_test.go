@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+func TestLoadBedrockCredentials(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(credentialsFile, []byte(`
+[default]
+aws_access_key_id = DEFAULT_AKID
+aws_secret_access_key = DEFAULT_SECRET
+
+# work account
+[work]
+aws_access_key_id=WORK_AKID
+aws_secret_access_key=WORK_SECRET
+aws_session_token=WORK_TOKEN
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		cfg     *profile.BedrockConfig
+		env     map[string]string
+		wantKey string
+		wantErr bool
+	}{
+		{
+			name:    "static credentials win",
+			cfg:     &profile.BedrockConfig{AccessKeyID: "STATIC_AKID", SecretAccessKey: "STATIC_SECRET", Profile: "work"},
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "ENV_AKID", "AWS_SECRET_ACCESS_KEY": "ENV_SECRET"},
+			wantKey: "STATIC_AKID",
+		},
+		{
+			name:    "environment variables",
+			cfg:     nil,
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "ENV_AKID", "AWS_SECRET_ACCESS_KEY": "ENV_SECRET"},
+			wantKey: "ENV_AKID",
+		},
+		{
+			name:    "named profile overrides environment",
+			cfg:     &profile.BedrockConfig{Profile: "work"},
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "ENV_AKID", "AWS_SECRET_ACCESS_KEY": "ENV_SECRET"},
+			wantKey: "WORK_AKID",
+		},
+		{
+			name:    "AWS_PROFILE",
+			cfg:     &profile.BedrockConfig{},
+			env:     map[string]string{"AWS_PROFILE": "work"},
+			wantKey: "WORK_AKID",
+		},
+		{
+			name:    "default profile",
+			cfg:     &profile.BedrockConfig{},
+			wantKey: "DEFAULT_AKID",
+		},
+		{
+			name:    "missing profile",
+			cfg:     &profile.BedrockConfig{Profile: "missing"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE"} {
+				t.Setenv(key, tt.env[key])
+			}
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+			creds, err := LoadBedrockCredentials(tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", creds)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if creds.AccessKeyID != tt.wantKey {
+				t.Errorf("AccessKeyID = %q, want %q", creds.AccessKeyID, tt.wantKey)
+			}
+			if tt.wantKey == "WORK_AKID" && creds.SessionToken != "WORK_TOKEN" {
+				t.Errorf("SessionToken = %q, want WORK_TOKEN", creds.SessionToken)
+			}
+		})
+	}
+}
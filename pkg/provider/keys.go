@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+// apiKeyPools holds the rotation state of each distinct list of OpenRouter API keys. It is keyed
+// by the keys themselves rather than by profile, so that the state survives config reloads.
+var apiKeyPools sync.Map // map[string]*apiKeyPool
+
+// apiKeyPool rotates requests between API keys and remembers when each key last failed.
+type apiKeyPool struct {
+	keys []string
+	next atomic.Uint64
+	now  func() time.Time
+
+	mu          sync.Mutex
+	lastErrored map[string]time.Time
+}
+
+func newAPIKeyPool(keys []string) *apiKeyPool {
+	return &apiKeyPool{
+		keys:        keys,
+		now:         time.Now,
+		lastErrored: make(map[string]time.Time, len(keys)),
+	}
+}
+
+// getAPIKeyPool returns the shared pool for keys, creating it on first use.
+func getAPIKeyPool(keys []string) *apiKeyPool {
+	id := strings.Join(keys, "\x00")
+	if pool, ok := apiKeyPools.Load(id); ok {
+		return pool.(*apiKeyPool)
+	}
+	pool, _ := apiKeyPools.LoadOrStore(id, newAPIKeyPool(keys))
+	return pool.(*apiKeyPool)
+}
+
+// pick returns the key for the next request. Each call advances the rotation, so a retried
+// request is sent with a different key than the attempt that failed.
+func (p *apiKeyPool) pick(strategy string) string {
+	start := int((p.next.Add(1) - 1) % uint64(len(p.keys)))
+	if strategy != profile.APIKeyStrategyLeastRecentlyErrored {
+		return p.keys[start]
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Keys that never failed have a zero time and win; ties go to the round-robin order.
+	picked := p.keys[start]
+	for i := 1; i < len(p.keys); i++ {
+		key := p.keys[(start+i)%len(p.keys)]
+		if p.lastErrored[key].Before(p.lastErrored[picked]) {
+			picked = key
+		}
+	}
+	return picked
+}
+
+// markErrored records that key was just rejected by the upstream.
+func (p *apiKeyPool) markErrored(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastErrored[key] = p.now()
+}
+
+// selectOpenRouterAPIKey returns the OpenRouter API key to send with the next request of prof.
+func selectOpenRouterAPIKey(prof *profile.Profile) string {
+	keys := prof.OpenRouter.GetAPIKeys()
+	switch len(keys) {
+	case 0:
+		return ""
+	case 1:
+		return keys[0]
+	}
+	return getAPIKeyPool(keys).pick(prof.OpenRouter.GetAPIKeyStrategy())
+}
+
+// isAPIKeyError reports whether statusCode means that the request failed because of the key
+// itself (rate limited, out of credits or revoked), so that another key may succeed.
+func isAPIKeyError(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusPaymentRequired, http.StatusUnauthorized:
+		return true
+	}
+	return false
+}
+
+// markOpenRouterAPIKeyErrored records a key-specific failure of resp, so that the
+// least_recently_errored strategy avoids its key for the following requests.
+func markOpenRouterAPIKeyErrored(prof *profile.Profile, resp *http.Response) {
+	if !isAPIKeyError(resp.StatusCode) || resp.Request == nil {
+		return
+	}
+	keys := prof.OpenRouter.GetAPIKeys()
+	if len(keys) < 2 {
+		return
+	}
+	key := strings.TrimPrefix(resp.Request.Header.Get("Authorization"), "Bearer ")
+	getAPIKeyPool(keys).markErrored(key)
+}
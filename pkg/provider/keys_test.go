@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
+	"github.com/x5iu/claude-code-adapter/pkg/profile"
+)
+
+func TestCreateOpenRouterChatCompletion_APIKeyRotation(t *testing.T) {
+	for _, strategy := range []string{profile.APIKeyStrategyRoundRobin, profile.APIKeyStrategyLeastRecentlyErrored} {
+		t.Run(strategy, func(t *testing.T) {
+			// Keys are unique per strategy, since rotation state is shared by identical key lists.
+			limitedKey, okKey := "sk-limited-"+strategy, "sk-ok-"+strategy
+			var (
+				mu   sync.Mutex
+				used []string
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				mu.Lock()
+				used = append(used, key)
+				mu.Unlock()
+				if key == limitedKey {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusTooManyRequests)
+					fmt.Fprint(w, `{"error":{"code":429,"message":"Rate limit exceeded"}}`)
+					return
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, "data: {\"id\":\"gen-1\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"hi\"}}]}\n\n")
+				fmt.Fprint(w, "data: [DONE]\n\n")
+			}))
+			defer server.Close()
+			ctx := profile.WithProfile(context.Background(), &profile.Profile{
+				Name:     "openrouter",
+				Provider: "openrouter",
+				OpenRouter: &profile.OpenRouterConfig{
+					BaseURL:        server.URL,
+					APIKeys:        []string{limitedKey, okKey},
+					APIKeyStrategy: strategy,
+				},
+			})
+			for i := range 3 {
+				stream, _, err := NewProvider().CreateOpenRouterChatCompletion(ctx, &openrouter.CreateChatCompletionRequest{
+					Model:    "anthropic/claude-sonnet-4",
+					Messages: []*openrouter.ChatCompletionMessage{{Role: openrouter.ChatCompletionMessageRoleUser}},
+					Stream:   true,
+				})
+				if err != nil {
+					t.Fatalf("request %d failed: %v", i, err)
+				}
+				for _, err := range stream {
+					if err != nil {
+						t.Fatalf("request %d stream error: %v", i, err)
+					}
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if used[len(used)-1] != okKey {
+				t.Errorf("last request used %q, want %q", used[len(used)-1], okKey)
+			}
+			var limited int
+			for _, key := range used {
+				if key == limitedKey {
+					limited++
+				}
+			}
+			// Round robin keeps trying the rate-limited key in turn and retries each request with
+			// the next key; least_recently_errored avoids it after its first failure.
+			want := 3
+			if strategy == profile.APIKeyStrategyLeastRecentlyErrored {
+				want = 1
+			}
+			if limited != want {
+				t.Errorf("rate-limited key used %d times, want %d (keys used: %v)", limited, want, used)
+			}
+		})
+	}
+}
+
+func TestAPIKeyPool(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	pool := newAPIKeyPool([]string{"a", "b", "c"})
+	pool.now = func() time.Time { return now }
+
+	var got []string
+	for range 4 {
+		got = append(got, pool.pick(profile.APIKeyStrategyRoundRobin))
+	}
+	if strings.Join(got, ",") != "a,b,c,a" {
+		t.Errorf("round robin picked %v", got)
+	}
+
+	pool.markErrored("b")
+	now = now.Add(time.Second)
+	pool.markErrored("c")
+	got = got[:0]
+	for range 3 {
+		got = append(got, pool.pick(profile.APIKeyStrategyLeastRecentlyErrored))
+	}
+	if strings.Join(got, ",") != "a,a,a" {
+		t.Errorf("least recently errored picked %v, want the key that never failed", got)
+	}
+	now = now.Add(time.Second)
+	pool.markErrored("a")
+	if key := pool.pick(profile.APIKeyStrategyLeastRecentlyErrored); key != "b" {
+		t.Errorf("picked %q, want b, whose error is the oldest", key)
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/bedrock"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/gemini"
 	"github.com/x5iu/claude-code-adapter/pkg/profile"
 )
 
@@ -40,14 +44,71 @@ func getConfigFromContext(ctx context.Context, keys ...string) string {
 		}
 		switch key {
 		case "api_key":
-			return prof.OpenRouter.GetAPIKey()
+			return selectOpenRouterAPIKey(prof)
 		case "base_url":
 			return prof.OpenRouter.GetBaseURL()
 		}
+	case "bedrock":
+		switch key {
+		case "base_url":
+			return prof.Bedrock.GetBaseURL()
+		}
+	case "gemini":
+		switch key {
+		case "api_key":
+			return prof.Gemini.GetAPIKey()
+		case "base_url":
+			return prof.Gemini.GetBaseURL()
+		}
+	case "openai":
+		switch key {
+		case "api_key":
+			return prof.OpenAI.GetAPIKey()
+		case "base_url":
+			return prof.OpenAI.GetBaseURL()
+		}
 	}
 	return ""
 }
 
+// getBedrockModelID resolves the Bedrock model id for req and escapes it for use as a URL path
+// segment, since model ids contain ':' and inference profile ARNs contain '/'. ':' is escaped
+// too (url.PathEscape keeps it) to match the path the AWS SDKs send and sign.
+// This function is used by the generated defc code templates.
+func getBedrockModelID(ctx context.Context, req *anthropic.GenerateMessageRequest) string {
+	modelID := req.Model
+	if prof, ok := profile.FromContext(ctx); ok {
+		if targetModel, ok := prof.Bedrock.GetModels()[req.Model]; ok {
+			modelID = targetModel
+		}
+	}
+	return strings.ReplaceAll(url.PathEscape(modelID), ":", "%3A")
+}
+
+// encodeBedrockRequest is used by the generated defc code templates to build the
+// invoke-with-response-stream request body.
+func encodeBedrockRequest(req *anthropic.GenerateMessageRequest) (string, error) {
+	return bedrock.EncodeRequest(req)
+}
+
+// getGeminiModel resolves the Gemini model name for req and escapes it for use as a URL path
+// segment. This function is used by the generated defc code templates.
+func getGeminiModel(ctx context.Context, req *anthropic.GenerateMessageRequest) string {
+	model := req.Model
+	if prof, ok := profile.FromContext(ctx); ok {
+		if targetModel, ok := prof.Gemini.GetModels()[req.Model]; ok {
+			model = targetModel
+		}
+	}
+	return url.PathEscape(strings.TrimPrefix(model, "models/"))
+}
+
+// encodeGeminiRequest is used by the generated defc code templates to build the
+// streamGenerateContent request body.
+func encodeGeminiRequest(req *anthropic.GenerateMessageRequest) (string, error) {
+	return gemini.EncodeRequest(req)
+}
+
 type RequestOption = func(*http.Request)
 
 func WithQuery(key string, value string) RequestOption {
@@ -66,6 +127,63 @@ func WithHeaders(headers http.Header) RequestOption {
 	}
 }
 
+// HeaderRequestID carries the correlation ID of a request to the upstream provider.
+const HeaderRequestID = "X-Request-Id"
+
+// WithRequestID sets the X-Request-Id header, so that upstream logs can be correlated with
+// the adapter's logs.
+func WithRequestID(id string) RequestOption {
+	return func(req *http.Request) {
+		if id != "" {
+			req.Header.Set(HeaderRequestID, id)
+		}
+	}
+}
+
+// W3C Trace Context headers, which carry the trace of a request across services.
+const (
+	HeaderTraceParent = "Traceparent"
+	HeaderTraceState  = "Tracestate"
+)
+
+// WithTraceContext copies the W3C Trace Context headers of headers, the headers of the client's
+// request, to the upstream request, so that the upstream call joins the client's trace.
+func WithTraceContext(headers http.Header) RequestOption {
+	return func(req *http.Request) {
+		if traceParent := headers.Get(HeaderTraceParent); traceParent != "" {
+			req.Header.Set(HeaderTraceParent, traceParent)
+			if traceState := headers.Get(HeaderTraceState); traceState != "" {
+				req.Header.Set(HeaderTraceState, traceState)
+			}
+		}
+	}
+}
+
+// protectedHeaders are managed by the adapter or net/http and are never set by WithStaticHeaders.
+var protectedHeaders = map[string]struct{}{
+	"Content-Type":      {},
+	"Content-Length":    {},
+	"Transfer-Encoding": {},
+	"Host":              {},
+	"User-Agent":        {},
+}
+
+// WithStaticHeaders adds the configured headers to the request. Headers already present on the
+// request, such as the API key or headers forwarded from the client, are never overridden.
+func WithStaticHeaders(headers map[string]string) RequestOption {
+	return func(req *http.Request) {
+		for k, v := range headers {
+			k = http.CanonicalHeaderKey(k)
+			if _, protected := protectedHeaders[k]; protected {
+				continue
+			}
+			if _, exists := req.Header[k]; !exists {
+				req.Header.Set(k, v)
+			}
+		}
+	}
+}
+
 func ReplaceBody(data []byte) RequestOption {
 	return func(req *http.Request) {
 		if oldBody := req.Body; oldBody != nil {
@@ -6,10 +6,11 @@ import (
 	"net/http"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 )
 
-//go:generate go tool github.com/x5iu/defc generate --output provider_impl.go --features api/ignore-status,api/get-body,api/retry,api/gzip --func json_encode=utils.JSONEncode --func get_config=getConfigFromContext
+//go:generate go tool github.com/x5iu/defc generate --output provider_impl.go --features api/ignore-status,api/get-body,api/retry,api/gzip --func json_encode=utils.JSONEncode --func get_config=getConfigFromContext --func bedrock_model_id=getBedrockModelID --func bedrock_encode=encodeBedrockRequest --func gemini_model=getGeminiModel --func gemini_encode=encodeGeminiRequest
 type Provider interface {
 	responseHandler() *ResponseHandler
 
@@ -57,4 +58,37 @@ type Provider interface {
 		req *openrouter.CreateChatCompletionRequest,
 		opts ...RequestOption,
 	) (openrouter.ChatCompletionStream, http.Header, error)
+
+	// GenerateBedrockMessage POST retry=2 options(opts) {{ get_config .ctx "bedrock" "base_url" }}/model/{{ bedrock_model_id .ctx .req }}/invoke-with-response-stream
+	// Content-Type: application/json
+	// Accept: application/vnd.amazon.eventstream
+	//
+	// {{ bedrock_encode .req }}
+	GenerateBedrockMessage(
+		ctx context.Context,
+		req *anthropic.GenerateMessageRequest,
+		opts ...RequestOption,
+	) (anthropic.MessageStream, http.Header, error)
+
+	// GenerateGeminiMessage POST retry=2 options(opts) {{ get_config .ctx "gemini" "base_url" }}/v1beta/models/{{ gemini_model .ctx .req }}:streamGenerateContent?alt=sse
+	// Content-Type: application/json
+	// X-Goog-Api-Key: {{ get_config .ctx "gemini" "api_key" }}
+	//
+	// {{ gemini_encode .req }}
+	GenerateGeminiMessage(
+		ctx context.Context,
+		req *anthropic.GenerateMessageRequest,
+		opts ...RequestOption,
+	) (anthropic.MessageStream, http.Header, error)
+
+	// CreateOpenAIModelResponse POST retry=2 options(opts) {{ get_config .ctx "openai" "base_url" }}/v1/responses
+	// Content-Type: application/json
+	// Authorization: Bearer {{ get_config .ctx "openai" "api_key" }}
+	//
+	// {{ json_encode .req }}
+	CreateOpenAIModelResponse(
+		ctx context.Context,
+		req *openai.CreateModelResponseRequest,
+		opts ...RequestOption,
+	) (openai.ResponseStream, http.Header, error)
 }
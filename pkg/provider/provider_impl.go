@@ -14,6 +14,7 @@ import (
 	"text/template"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/utils"
 	__rt "github.com/x5iu/defc/runtime"
@@ -24,6 +25,9 @@ const (
 	ProviderMethodGenerateAnthropicMessage       = "GenerateAnthropicMessage"
 	ProviderMethodCountAnthropicTokens           = "CountAnthropicTokens"
 	ProviderMethodCreateOpenRouterChatCompletion = "CreateOpenRouterChatCompletion"
+	ProviderMethodGenerateBedrockMessage         = "GenerateBedrockMessage"
+	ProviderMethodGenerateGeminiMessage          = "GenerateGeminiMessage"
+	ProviderMethodCreateOpenAIModelResponse      = "CreateOpenAIModelResponse"
 )
 
 func NewProvider() Provider {
@@ -33,14 +37,20 @@ func NewProvider() Provider {
 type implProvider struct{}
 
 var (
-	addrProviderTmplMakeAnthropicMessagesRequest     = template.Must(template.New("AddressMakeAnthropicMessagesRequest").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"anthropic\" \"base_url\" }}/v1/messages"))
-	headerProviderTmplMakeAnthropicMessagesRequest   = template.Must(template.New("HeaderMakeAnthropicMessagesRequest").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-API-Key: {{ get_config .ctx \"anthropic\" \"api_key\" }}\r\nAnthropic-Version: {{ get_config .ctx \"anthropic\" \"version\" }}\r\n\r\n"))
-	addrProviderTmplGenerateAnthropicMessage         = template.Must(template.New("AddressGenerateAnthropicMessage").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"anthropic\" \"base_url\" }}/v1/messages"))
-	headerProviderTmplGenerateAnthropicMessage       = template.Must(template.New("HeaderGenerateAnthropicMessage").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-API-Key: {{ get_config .ctx \"anthropic\" \"api_key\" }}\r\nAnthropic-Version: {{ get_config .ctx \"anthropic\" \"version\" }}\r\n\r\n{{ json_encode .req }}"))
-	addrProviderTmplCountAnthropicTokens             = template.Must(template.New("AddressCountAnthropicTokens").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"anthropic\" \"base_url\" }}/v1/messages/count_tokens"))
-	headerProviderTmplCountAnthropicTokens           = template.Must(template.New("HeaderCountAnthropicTokens").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-API-Key: {{ get_config .ctx \"anthropic\" \"api_key\" }}\r\nAnthropic-Version: {{ get_config .ctx \"anthropic\" \"version\" }}\r\n\r\n{{ json_encode .req }}"))
-	addrProviderTmplCreateOpenRouterChatCompletion   = template.Must(template.New("AddressCreateOpenRouterChatCompletion").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"openrouter\" \"base_url\" }}/v1/chat/completions"))
-	headerProviderTmplCreateOpenRouterChatCompletion = template.Must(template.New("HeaderCreateOpenRouterChatCompletion").Funcs(template.FuncMap{"get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nAuthorization: Bearer {{ get_config .ctx \"openrouter\" \"api_key\" }}\r\n\r\n{{ json_encode .req }}"))
+	addrProviderTmplMakeAnthropicMessagesRequest     = template.Must(template.New("AddressMakeAnthropicMessagesRequest").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"anthropic\" \"base_url\" }}/v1/messages"))
+	headerProviderTmplMakeAnthropicMessagesRequest   = template.Must(template.New("HeaderMakeAnthropicMessagesRequest").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-API-Key: {{ get_config .ctx \"anthropic\" \"api_key\" }}\r\nAnthropic-Version: {{ get_config .ctx \"anthropic\" \"version\" }}\r\n\r\n"))
+	addrProviderTmplGenerateAnthropicMessage         = template.Must(template.New("AddressGenerateAnthropicMessage").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"anthropic\" \"base_url\" }}/v1/messages"))
+	headerProviderTmplGenerateAnthropicMessage       = template.Must(template.New("HeaderGenerateAnthropicMessage").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-API-Key: {{ get_config .ctx \"anthropic\" \"api_key\" }}\r\nAnthropic-Version: {{ get_config .ctx \"anthropic\" \"version\" }}\r\n\r\n{{ json_encode .req }}"))
+	addrProviderTmplCountAnthropicTokens             = template.Must(template.New("AddressCountAnthropicTokens").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"anthropic\" \"base_url\" }}/v1/messages/count_tokens"))
+	headerProviderTmplCountAnthropicTokens           = template.Must(template.New("HeaderCountAnthropicTokens").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-API-Key: {{ get_config .ctx \"anthropic\" \"api_key\" }}\r\nAnthropic-Version: {{ get_config .ctx \"anthropic\" \"version\" }}\r\n\r\n{{ json_encode .req }}"))
+	addrProviderTmplCreateOpenRouterChatCompletion   = template.Must(template.New("AddressCreateOpenRouterChatCompletion").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"openrouter\" \"base_url\" }}/v1/chat/completions"))
+	headerProviderTmplCreateOpenRouterChatCompletion = template.Must(template.New("HeaderCreateOpenRouterChatCompletion").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nAuthorization: Bearer {{ get_config .ctx \"openrouter\" \"api_key\" }}\r\n\r\n{{ json_encode .req }}"))
+	addrProviderTmplGenerateBedrockMessage           = template.Must(template.New("AddressGenerateBedrockMessage").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"bedrock\" \"base_url\" }}/model/{{ bedrock_model_id .ctx .req }}/invoke-with-response-stream"))
+	headerProviderTmplGenerateBedrockMessage         = template.Must(template.New("HeaderGenerateBedrockMessage").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nAccept: application/vnd.amazon.eventstream\r\n\r\n{{ bedrock_encode .req }}"))
+	addrProviderTmplGenerateGeminiMessage            = template.Must(template.New("AddressGenerateGeminiMessage").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"gemini\" \"base_url\" }}/v1beta/models/{{ gemini_model .ctx .req }}:streamGenerateContent?alt=sse"))
+	headerProviderTmplGenerateGeminiMessage          = template.Must(template.New("HeaderGenerateGeminiMessage").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nX-Goog-Api-Key: {{ get_config .ctx \"gemini\" \"api_key\" }}\r\n\r\n{{ gemini_encode .req }}"))
+	addrProviderTmplCreateOpenAIModelResponse        = template.Must(template.New("AddressCreateOpenAIModelResponse").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("{{ get_config .ctx \"openai\" \"base_url\" }}/v1/responses"))
+	headerProviderTmplCreateOpenAIModelResponse      = template.Must(template.New("HeaderCreateOpenAIModelResponse").Funcs(template.FuncMap{"bedrock_encode": encodeBedrockRequest, "bedrock_model_id": getBedrockModelID, "gemini_encode": encodeGeminiRequest, "gemini_model": getGeminiModel, "get_config": getConfigFromContext, "json_encode": utils.JSONEncode}).Parse("Content-Type: application/json\r\nAuthorization: Bearer {{ get_config .ctx \"openai\" \"api_key\" }}\r\n\r\n{{ json_encode .req }}"))
 )
 
 func (*implProvider) responseHandler() *ResponseHandler {
@@ -560,3 +570,381 @@ func (__imp *implProvider) __CreateOpenRouterChatCompletion(ctx context.Context,
 
 	return v0CreateOpenRouterChatCompletion, v1CreateOpenRouterChatCompletion, nil
 }
+
+func (__imp *implProvider) GenerateBedrockMessage(ctx context.Context, req *anthropic.GenerateMessageRequest, opts ...RequestOption) (anthropic.MessageStream, http.Header, error) {
+	__maxRetry := 2
+
+	__retryCount := 0
+__RETRY:
+	var (
+		v0GenerateBedrockMessage  anthropic.MessageStream
+		v1GenerateBedrockMessage  http.Header
+		errGenerateBedrockMessage error
+	)
+
+	v0GenerateBedrockMessage, v1GenerateBedrockMessage, errGenerateBedrockMessage = __imp.__GenerateBedrockMessage(ctx, req, opts...)
+	if errGenerateBedrockMessage != nil {
+		if __retryCount < __maxRetry {
+			if __getResponse, ok := errGenerateBedrockMessage.(__rt.FutureResponseError); ok {
+				__getResponse.Response().Body.Close()
+			}
+			__retryCount++
+			goto __RETRY
+		}
+	}
+	return v0GenerateBedrockMessage, v1GenerateBedrockMessage, errGenerateBedrockMessage
+}
+
+func (__imp *implProvider) __GenerateBedrockMessage(ctx context.Context, req *anthropic.GenerateMessageRequest, opts ...RequestOption) (anthropic.MessageStream, http.Header, error) {
+
+	addrGenerateBedrockMessage := __rt.GetBuffer()
+	defer __rt.PutBuffer(addrGenerateBedrockMessage)
+	defer addrGenerateBedrockMessage.Reset()
+
+	headerGenerateBedrockMessage := __rt.GetBuffer()
+	defer __rt.PutBuffer(headerGenerateBedrockMessage)
+	defer headerGenerateBedrockMessage.Reset()
+
+	var (
+		v0GenerateBedrockMessage = __rt.New[anthropic.MessageStream]()
+		v1GenerateBedrockMessage = __rt.New[http.Header]()
+	)
+
+	var (
+		errGenerateBedrockMessage          error
+		httpResponseGenerateBedrockMessage *http.Response
+		responseGenerateBedrockMessage     __rt.FutureResponse = __imp.responseHandler()
+	)
+
+	if errGenerateBedrockMessage = addrProviderTmplGenerateBedrockMessage.Execute(addrGenerateBedrockMessage, map[string]any{
+		"ctx":  ctx,
+		"req":  req,
+		"opts": opts,
+	}); errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error building 'GenerateBedrockMessage' url: %w", errGenerateBedrockMessage)
+	}
+
+	if errGenerateBedrockMessage = headerProviderTmplGenerateBedrockMessage.Execute(headerGenerateBedrockMessage, map[string]any{
+		"ctx":  ctx,
+		"req":  req,
+		"opts": opts,
+	}); errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error building 'GenerateBedrockMessage' header: %w", errGenerateBedrockMessage)
+	}
+	bufReaderGenerateBedrockMessage := bufio.NewReader(headerGenerateBedrockMessage)
+	mimeHeaderGenerateBedrockMessage, errGenerateBedrockMessage := textproto.NewReader(bufReaderGenerateBedrockMessage).ReadMIMEHeader()
+	if errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error reading 'GenerateBedrockMessage' header: %w", errGenerateBedrockMessage)
+	}
+
+	urlGenerateBedrockMessage := addrGenerateBedrockMessage.String()
+	requestBodyGenerateBedrockMessage, errGenerateBedrockMessage := io.ReadAll(bufReaderGenerateBedrockMessage)
+	if errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error reading 'GenerateBedrockMessage' request body: %w", errGenerateBedrockMessage)
+	}
+	requestGenerateBedrockMessage, errGenerateBedrockMessage := http.NewRequestWithContext(ctx, "POST", urlGenerateBedrockMessage, bytes.NewReader(requestBodyGenerateBedrockMessage))
+	if errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error building 'GenerateBedrockMessage' request: %w", errGenerateBedrockMessage)
+	}
+
+	for kGenerateBedrockMessage, vvGenerateBedrockMessage := range mimeHeaderGenerateBedrockMessage {
+		for _, vGenerateBedrockMessage := range vvGenerateBedrockMessage {
+			requestGenerateBedrockMessage.Header.Add(kGenerateBedrockMessage, vGenerateBedrockMessage)
+		}
+	}
+
+	requestGenerateBedrockMessage.Header.Add("Accept-Encoding", "gzip")
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(requestGenerateBedrockMessage)
+		}
+	}
+
+	httpResponseGenerateBedrockMessage, errGenerateBedrockMessage = http.DefaultClient.Do(requestGenerateBedrockMessage)
+
+	if errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error sending 'GenerateBedrockMessage' request: %w", errGenerateBedrockMessage)
+	}
+
+	func() {
+		for _, contentEncoding := range httpResponseGenerateBedrockMessage.Header.Values("Content-Encoding") {
+			if commaIndex := strings.IndexByte(contentEncoding, ','); commaIndex >= 0 {
+				contentEncoding = contentEncoding[:commaIndex]
+			}
+			if strings.TrimSpace(contentEncoding) == "gzip" {
+				httpResponseGenerateBedrockMessage.Body = &__rt.GzipReadCloser{R: httpResponseGenerateBedrockMessage.Body}
+				return
+			}
+		}
+	}()
+
+	if errGenerateBedrockMessage = responseGenerateBedrockMessage.FromResponse("GenerateBedrockMessage", httpResponseGenerateBedrockMessage); errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error converting 'GenerateBedrockMessage' response: %w", errGenerateBedrockMessage)
+	}
+
+	addrGenerateBedrockMessage.Reset()
+	headerGenerateBedrockMessage.Reset()
+
+	if errGenerateBedrockMessage = responseGenerateBedrockMessage.Err(); errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error returned from 'GenerateBedrockMessage' response: %w", errGenerateBedrockMessage)
+	}
+
+	if errGenerateBedrockMessage = responseGenerateBedrockMessage.ScanValues(&v0GenerateBedrockMessage, &v1GenerateBedrockMessage); errGenerateBedrockMessage != nil {
+		return v0GenerateBedrockMessage, v1GenerateBedrockMessage, fmt.Errorf("error scanning value from 'GenerateBedrockMessage' response: %w", errGenerateBedrockMessage)
+	}
+
+	return v0GenerateBedrockMessage, v1GenerateBedrockMessage, nil
+}
+
+func (__imp *implProvider) GenerateGeminiMessage(ctx context.Context, req *anthropic.GenerateMessageRequest, opts ...RequestOption) (anthropic.MessageStream, http.Header, error) {
+	__maxRetry := 2
+
+	__retryCount := 0
+__RETRY:
+	var (
+		v0GenerateGeminiMessage  anthropic.MessageStream
+		v1GenerateGeminiMessage  http.Header
+		errGenerateGeminiMessage error
+	)
+
+	v0GenerateGeminiMessage, v1GenerateGeminiMessage, errGenerateGeminiMessage = __imp.__GenerateGeminiMessage(ctx, req, opts...)
+	if errGenerateGeminiMessage != nil {
+		if __retryCount < __maxRetry {
+			if __getResponse, ok := errGenerateGeminiMessage.(__rt.FutureResponseError); ok {
+				__getResponse.Response().Body.Close()
+			}
+			__retryCount++
+			goto __RETRY
+		}
+	}
+	return v0GenerateGeminiMessage, v1GenerateGeminiMessage, errGenerateGeminiMessage
+}
+
+func (__imp *implProvider) __GenerateGeminiMessage(ctx context.Context, req *anthropic.GenerateMessageRequest, opts ...RequestOption) (anthropic.MessageStream, http.Header, error) {
+
+	addrGenerateGeminiMessage := __rt.GetBuffer()
+	defer __rt.PutBuffer(addrGenerateGeminiMessage)
+	defer addrGenerateGeminiMessage.Reset()
+
+	headerGenerateGeminiMessage := __rt.GetBuffer()
+	defer __rt.PutBuffer(headerGenerateGeminiMessage)
+	defer headerGenerateGeminiMessage.Reset()
+
+	var (
+		v0GenerateGeminiMessage = __rt.New[anthropic.MessageStream]()
+		v1GenerateGeminiMessage = __rt.New[http.Header]()
+	)
+
+	var (
+		errGenerateGeminiMessage          error
+		httpResponseGenerateGeminiMessage *http.Response
+		responseGenerateGeminiMessage     __rt.FutureResponse = __imp.responseHandler()
+	)
+
+	if errGenerateGeminiMessage = addrProviderTmplGenerateGeminiMessage.Execute(addrGenerateGeminiMessage, map[string]any{
+		"ctx":  ctx,
+		"req":  req,
+		"opts": opts,
+	}); errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error building 'GenerateGeminiMessage' url: %w", errGenerateGeminiMessage)
+	}
+
+	if errGenerateGeminiMessage = headerProviderTmplGenerateGeminiMessage.Execute(headerGenerateGeminiMessage, map[string]any{
+		"ctx":  ctx,
+		"req":  req,
+		"opts": opts,
+	}); errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error building 'GenerateGeminiMessage' header: %w", errGenerateGeminiMessage)
+	}
+	bufReaderGenerateGeminiMessage := bufio.NewReader(headerGenerateGeminiMessage)
+	mimeHeaderGenerateGeminiMessage, errGenerateGeminiMessage := textproto.NewReader(bufReaderGenerateGeminiMessage).ReadMIMEHeader()
+	if errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error reading 'GenerateGeminiMessage' header: %w", errGenerateGeminiMessage)
+	}
+
+	urlGenerateGeminiMessage := addrGenerateGeminiMessage.String()
+	requestBodyGenerateGeminiMessage, errGenerateGeminiMessage := io.ReadAll(bufReaderGenerateGeminiMessage)
+	if errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error reading 'GenerateGeminiMessage' request body: %w", errGenerateGeminiMessage)
+	}
+	requestGenerateGeminiMessage, errGenerateGeminiMessage := http.NewRequestWithContext(ctx, "POST", urlGenerateGeminiMessage, bytes.NewReader(requestBodyGenerateGeminiMessage))
+	if errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error building 'GenerateGeminiMessage' request: %w", errGenerateGeminiMessage)
+	}
+
+	for kGenerateGeminiMessage, vvGenerateGeminiMessage := range mimeHeaderGenerateGeminiMessage {
+		for _, vGenerateGeminiMessage := range vvGenerateGeminiMessage {
+			requestGenerateGeminiMessage.Header.Add(kGenerateGeminiMessage, vGenerateGeminiMessage)
+		}
+	}
+
+	requestGenerateGeminiMessage.Header.Add("Accept-Encoding", "gzip")
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(requestGenerateGeminiMessage)
+		}
+	}
+
+	httpResponseGenerateGeminiMessage, errGenerateGeminiMessage = http.DefaultClient.Do(requestGenerateGeminiMessage)
+
+	if errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error sending 'GenerateGeminiMessage' request: %w", errGenerateGeminiMessage)
+	}
+
+	func() {
+		for _, contentEncoding := range httpResponseGenerateGeminiMessage.Header.Values("Content-Encoding") {
+			if commaIndex := strings.IndexByte(contentEncoding, ','); commaIndex >= 0 {
+				contentEncoding = contentEncoding[:commaIndex]
+			}
+			if strings.TrimSpace(contentEncoding) == "gzip" {
+				httpResponseGenerateGeminiMessage.Body = &__rt.GzipReadCloser{R: httpResponseGenerateGeminiMessage.Body}
+				return
+			}
+		}
+	}()
+
+	if errGenerateGeminiMessage = responseGenerateGeminiMessage.FromResponse("GenerateGeminiMessage", httpResponseGenerateGeminiMessage); errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error converting 'GenerateGeminiMessage' response: %w", errGenerateGeminiMessage)
+	}
+
+	addrGenerateGeminiMessage.Reset()
+	headerGenerateGeminiMessage.Reset()
+
+	if errGenerateGeminiMessage = responseGenerateGeminiMessage.Err(); errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error returned from 'GenerateGeminiMessage' response: %w", errGenerateGeminiMessage)
+	}
+
+	if errGenerateGeminiMessage = responseGenerateGeminiMessage.ScanValues(&v0GenerateGeminiMessage, &v1GenerateGeminiMessage); errGenerateGeminiMessage != nil {
+		return v0GenerateGeminiMessage, v1GenerateGeminiMessage, fmt.Errorf("error scanning value from 'GenerateGeminiMessage' response: %w", errGenerateGeminiMessage)
+	}
+
+	return v0GenerateGeminiMessage, v1GenerateGeminiMessage, nil
+}
+
+func (__imp *implProvider) CreateOpenAIModelResponse(ctx context.Context, req *openai.CreateModelResponseRequest, opts ...RequestOption) (openai.ResponseStream, http.Header, error) {
+	__maxRetry := 2
+
+	__retryCount := 0
+__RETRY:
+	var (
+		v0CreateOpenAIModelResponse  openai.ResponseStream
+		v1CreateOpenAIModelResponse  http.Header
+		errCreateOpenAIModelResponse error
+	)
+
+	v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, errCreateOpenAIModelResponse = __imp.__CreateOpenAIModelResponse(ctx, req, opts...)
+	if errCreateOpenAIModelResponse != nil {
+		if __retryCount < __maxRetry {
+			if __getResponse, ok := errCreateOpenAIModelResponse.(__rt.FutureResponseError); ok {
+				__getResponse.Response().Body.Close()
+			}
+			__retryCount++
+			goto __RETRY
+		}
+	}
+	return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, errCreateOpenAIModelResponse
+}
+
+func (__imp *implProvider) __CreateOpenAIModelResponse(ctx context.Context, req *openai.CreateModelResponseRequest, opts ...RequestOption) (openai.ResponseStream, http.Header, error) {
+
+	addrCreateOpenAIModelResponse := __rt.GetBuffer()
+	defer __rt.PutBuffer(addrCreateOpenAIModelResponse)
+	defer addrCreateOpenAIModelResponse.Reset()
+
+	headerCreateOpenAIModelResponse := __rt.GetBuffer()
+	defer __rt.PutBuffer(headerCreateOpenAIModelResponse)
+	defer headerCreateOpenAIModelResponse.Reset()
+
+	var (
+		v0CreateOpenAIModelResponse = __rt.New[openai.ResponseStream]()
+		v1CreateOpenAIModelResponse = __rt.New[http.Header]()
+	)
+
+	var (
+		errCreateOpenAIModelResponse          error
+		httpResponseCreateOpenAIModelResponse *http.Response
+		responseCreateOpenAIModelResponse     __rt.FutureResponse = __imp.responseHandler()
+	)
+
+	if errCreateOpenAIModelResponse = addrProviderTmplCreateOpenAIModelResponse.Execute(addrCreateOpenAIModelResponse, map[string]any{
+		"ctx":  ctx,
+		"req":  req,
+		"opts": opts,
+	}); errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error building 'CreateOpenAIModelResponse' url: %w", errCreateOpenAIModelResponse)
+	}
+
+	if errCreateOpenAIModelResponse = headerProviderTmplCreateOpenAIModelResponse.Execute(headerCreateOpenAIModelResponse, map[string]any{
+		"ctx":  ctx,
+		"req":  req,
+		"opts": opts,
+	}); errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error building 'CreateOpenAIModelResponse' header: %w", errCreateOpenAIModelResponse)
+	}
+	bufReaderCreateOpenAIModelResponse := bufio.NewReader(headerCreateOpenAIModelResponse)
+	mimeHeaderCreateOpenAIModelResponse, errCreateOpenAIModelResponse := textproto.NewReader(bufReaderCreateOpenAIModelResponse).ReadMIMEHeader()
+	if errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error reading 'CreateOpenAIModelResponse' header: %w", errCreateOpenAIModelResponse)
+	}
+
+	urlCreateOpenAIModelResponse := addrCreateOpenAIModelResponse.String()
+	requestBodyCreateOpenAIModelResponse, errCreateOpenAIModelResponse := io.ReadAll(bufReaderCreateOpenAIModelResponse)
+	if errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error reading 'CreateOpenAIModelResponse' request body: %w", errCreateOpenAIModelResponse)
+	}
+	requestCreateOpenAIModelResponse, errCreateOpenAIModelResponse := http.NewRequestWithContext(ctx, "POST", urlCreateOpenAIModelResponse, bytes.NewReader(requestBodyCreateOpenAIModelResponse))
+	if errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error building 'CreateOpenAIModelResponse' request: %w", errCreateOpenAIModelResponse)
+	}
+
+	for kCreateOpenAIModelResponse, vvCreateOpenAIModelResponse := range mimeHeaderCreateOpenAIModelResponse {
+		for _, vCreateOpenAIModelResponse := range vvCreateOpenAIModelResponse {
+			requestCreateOpenAIModelResponse.Header.Add(kCreateOpenAIModelResponse, vCreateOpenAIModelResponse)
+		}
+	}
+
+	requestCreateOpenAIModelResponse.Header.Add("Accept-Encoding", "gzip")
+
+	for _, opt := range opts {
+		if opt != nil {
+			opt(requestCreateOpenAIModelResponse)
+		}
+	}
+
+	httpResponseCreateOpenAIModelResponse, errCreateOpenAIModelResponse = http.DefaultClient.Do(requestCreateOpenAIModelResponse)
+
+	if errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error sending 'CreateOpenAIModelResponse' request: %w", errCreateOpenAIModelResponse)
+	}
+
+	func() {
+		for _, contentEncoding := range httpResponseCreateOpenAIModelResponse.Header.Values("Content-Encoding") {
+			if commaIndex := strings.IndexByte(contentEncoding, ','); commaIndex >= 0 {
+				contentEncoding = contentEncoding[:commaIndex]
+			}
+			if strings.TrimSpace(contentEncoding) == "gzip" {
+				httpResponseCreateOpenAIModelResponse.Body = &__rt.GzipReadCloser{R: httpResponseCreateOpenAIModelResponse.Body}
+				return
+			}
+		}
+	}()
+
+	if errCreateOpenAIModelResponse = responseCreateOpenAIModelResponse.FromResponse("CreateOpenAIModelResponse", httpResponseCreateOpenAIModelResponse); errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error converting 'CreateOpenAIModelResponse' response: %w", errCreateOpenAIModelResponse)
+	}
+
+	addrCreateOpenAIModelResponse.Reset()
+	headerCreateOpenAIModelResponse.Reset()
+
+	if errCreateOpenAIModelResponse = responseCreateOpenAIModelResponse.Err(); errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error returned from 'CreateOpenAIModelResponse' response: %w", errCreateOpenAIModelResponse)
+	}
+
+	if errCreateOpenAIModelResponse = responseCreateOpenAIModelResponse.ScanValues(&v0CreateOpenAIModelResponse, &v1CreateOpenAIModelResponse); errCreateOpenAIModelResponse != nil {
+		return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, fmt.Errorf("error scanning value from 'CreateOpenAIModelResponse' response: %w", errCreateOpenAIModelResponse)
+	}
+
+	return v0CreateOpenAIModelResponse, v1CreateOpenAIModelResponse, nil
+}
@@ -2,7 +2,11 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -10,6 +14,9 @@ import (
 	"github.com/samber/lo"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/bedrock"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/gemini"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/profile"
 )
@@ -194,7 +201,7 @@ func TestCreateOpenRouterChatCompletion_WithProviderPreference(t *testing.T) {
 
 	// Create provider preference to only use Anthropic models
 	providerPref := &openrouter.ProviderPreference{
-		Only: []string{openrouter.ProviderAnthropic},
+		Only: []openrouter.Provider{openrouter.ProviderAnthropic},
 	}
 
 	req := &openrouter.CreateChatCompletionRequest{
@@ -569,7 +576,7 @@ func TestCreateOpenRouterChatCompletion_CachedTokensAcrossRequests(t *testing.T)
 		}
 	}
 	getCached := func() (int64, error) {
-		pref := openrouter.ProviderPreference{Only: []string{openrouter.ProviderAnthropic}}
+		pref := openrouter.ProviderPreference{Only: []openrouter.Provider{openrouter.ProviderAnthropic}}
 		stream, _, err := provider.CreateOpenRouterChatCompletion(ctx, mkReq(), openrouter.WithProviderPreference(&pref))
 		if err != nil {
 			return 0, err
@@ -982,3 +989,338 @@ func truncateString(s string, maxLength int) string {
 	}
 	return s[:maxLength] + "..."
 }
+
+func TestGenerateBedrockMessage_Stream(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+		`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":12,"outputTokenCount":5}}`,
+	}
+	var (
+		gotPath string
+		gotBody []byte
+		gotAuth string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", bedrock.ContentTypeEventStream)
+		for _, event := range events {
+			payload, _ := json.Marshal(&bedrock.Chunk{Bytes: []byte(event)})
+			w.Write(bedrock.EncodeEventStreamMessage([][2]string{
+				{bedrock.HeaderMessageType, bedrock.MessageTypeEvent},
+				{bedrock.HeaderEventType, "chunk"},
+			}, payload))
+		}
+	}))
+	defer server.Close()
+	ctx := profile.WithProfile(context.Background(), &profile.Profile{
+		Name:     "bedrock",
+		Provider: "bedrock",
+		Bedrock: &profile.BedrockConfig{
+			BaseURL: server.URL,
+			Models:  map[string]string{"claude-sonnet-4": "us.anthropic.claude-sonnet-4-20250514-v1:0"},
+		},
+	})
+	stream, _, err := NewProvider().GenerateBedrockMessage(ctx, &anthropic.GenerateMessageRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 100,
+		Stream:    true,
+		Messages: []*anthropic.Message{
+			{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "hi"}}},
+		},
+	}, bedrock.WithSignature(&bedrock.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, "us-east-1"))
+	if err != nil {
+		t.Fatalf("GenerateBedrockMessage failed: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	for event, err := range stream {
+		if err != nil {
+			t.Fatalf("stream error: %v", err)
+		}
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder error: %v", err)
+		}
+	}
+	message := builder.Message()
+	if len(message.Content) != 1 || message.Content[0].Text != "Hello" {
+		t.Errorf("unexpected content: %+v", message.Content)
+	}
+	if message.StopReason == nil || *message.StopReason != anthropic.StopReasonEndTurn {
+		t.Errorf("unexpected stop reason: %v", message.StopReason)
+	}
+	if want := "/model/us.anthropic.claude-sonnet-4-20250514-v1%3A0/invoke-with-response-stream"; gotPath != want {
+		t.Errorf("path = %s, want %s", gotPath, want)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/") {
+		t.Errorf("request is not signed: %q", gotAuth)
+	}
+	var body map[string]any
+	if err = json.Unmarshal(gotBody, &body); err != nil {
+		t.Fatalf("invalid request body: %v", err)
+	}
+	if body["anthropic_version"] != bedrock.AnthropicVersion || body["model"] != nil || body["stream"] != nil {
+		t.Errorf("unexpected request body: %s", gotBody)
+	}
+}
+
+func TestGenerateBedrockMessage_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantType   string
+	}{
+		{
+			name: "http error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"message":"The security token included in the request is invalid."}`))
+			},
+			wantStatus: http.StatusForbidden,
+			wantType:   anthropic.PermissionError,
+		},
+		{
+			name: "stream exception",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", bedrock.ContentTypeEventStream)
+				w.Write(bedrock.EncodeEventStreamMessage([][2]string{
+					{bedrock.HeaderMessageType, bedrock.MessageTypeException},
+					{bedrock.HeaderExceptionType, "throttlingException"},
+				}, []byte(`{"message":"Too many requests, please wait before trying again."}`)))
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantType:   anthropic.RateLimitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+			ctx := profile.WithProfile(context.Background(), &profile.Profile{
+				Name:    "bedrock",
+				Bedrock: &profile.BedrockConfig{BaseURL: server.URL},
+			})
+			stream, _, err := NewProvider().GenerateBedrockMessage(ctx, &anthropic.GenerateMessageRequest{Model: "m", MaxTokens: 1})
+			if err == nil {
+				for _, streamErr := range stream {
+					if streamErr != nil {
+						err = streamErr
+					}
+				}
+			}
+			providerError, ok := ParseError(err)
+			if !ok {
+				t.Fatalf("expected provider error, got %v", err)
+			}
+			if providerError.StatusCode() != tt.wantStatus || providerError.Type() != tt.wantType || providerError.Source() != "bedrock" {
+				t.Errorf("unexpected error: %d %s %s", providerError.StatusCode(), providerError.Type(), providerError.Source())
+			}
+		})
+	}
+}
+
+func TestGenerateGeminiMessage(t *testing.T) {
+	var (
+		gotPath string
+		gotKey  string
+		gotBody []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path + "?" + r.URL.RawQuery
+		gotKey = r.Header.Get(gemini.HeaderAPIKey)
+		gotBody, _ = io.ReadAll(r.Body)
+		if strings.Contains(string(gotBody), "overloaded") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"The model is overloaded.","status":"UNAVAILABLE"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hello\"}]},\"index\":0}],\"responseId\":\"resp-1\"}\n\n"))
+		w.Write([]byte("data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"!\"}]},\"finishReason\":\"STOP\",\"index\":0}],\"usageMetadata\":{\"promptTokenCount\":4,\"candidatesTokenCount\":2}}\n\n"))
+	}))
+	defer server.Close()
+	ctx := profile.WithProfile(context.Background(), &profile.Profile{
+		Name:     "gemini",
+		Provider: "gemini",
+		Gemini: &profile.GeminiConfig{
+			BaseURL: server.URL,
+			APIKey:  "gemini-key",
+			Models:  map[string]string{"gemini-pro": "gemini-2.5-pro"},
+		},
+	})
+	newRequest := func(text string) *anthropic.GenerateMessageRequest {
+		return &anthropic.GenerateMessageRequest{
+			Model:     "gemini-pro",
+			MaxTokens: 100,
+			Stream:    true,
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: text}}},
+			},
+		}
+	}
+	stream, _, err := NewProvider().GenerateGeminiMessage(ctx, newRequest("hi"))
+	if err != nil {
+		t.Fatalf("GenerateGeminiMessage failed: %v", err)
+	}
+	builder := anthropic.NewMessageBuilder()
+	for event, err := range stream {
+		if err != nil {
+			t.Fatalf("stream error: %v", err)
+		}
+		if err = builder.Add(event); err != nil {
+			t.Fatalf("builder error: %v", err)
+		}
+	}
+	message := builder.Message()
+	if len(message.Content) != 1 || message.Content[0].Text != "Hello!" {
+		t.Errorf("unexpected content: %+v", message.Content)
+	}
+	if message.StopReason == nil || *message.StopReason != anthropic.StopReasonEndTurn {
+		t.Errorf("unexpected stop reason: %v", message.StopReason)
+	}
+	if want := "/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse"; gotPath != want {
+		t.Errorf("path = %s, want %s", gotPath, want)
+	}
+	if gotKey != "gemini-key" {
+		t.Errorf("api key = %q", gotKey)
+	}
+	if !strings.Contains(string(gotBody), `"contents":[{"role":"user","parts":[{"text":"hi"}]}]`) {
+		t.Errorf("unexpected request body: %s", gotBody)
+	}
+
+	_, _, err = NewProvider().GenerateGeminiMessage(ctx, newRequest("overloaded"))
+	providerError, ok := ParseError(err)
+	if !ok {
+		t.Fatalf("expected provider error, got %v", err)
+	}
+	if providerError.StatusCode() != http.StatusServiceUnavailable || providerError.Type() != anthropic.OverloadedError || providerError.Source() != "gemini" {
+		t.Errorf("unexpected error: %d %s %s", providerError.StatusCode(), providerError.Type(), providerError.Source())
+	}
+}
+
+func TestWithStaticHeaders(t *testing.T) {
+	var gotHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"id\":\"gen-1\",\"model\":\"openai/gpt-5\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"ok\"},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer server.Close()
+	ctx := profile.WithProfile(context.Background(), &profile.Profile{
+		Name:       "openrouter",
+		Provider:   "openrouter",
+		OpenRouter: &profile.OpenRouterConfig{BaseURL: server.URL, APIKey: "sk-or-test"},
+	})
+	stream, _, err := NewProvider().CreateOpenRouterChatCompletion(ctx, &openrouter.CreateChatCompletionRequest{Model: "openai/gpt-5"},
+		WithStaticHeaders(map[string]string{
+			"x-org-id":            "org-123",
+			"CF-Access-Client-Id": "client-id",
+			"Authorization":       "Bearer overridden",
+			"Content-Type":        "text/plain",
+			"User-Agent":          "custom-agent",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("CreateOpenRouterChatCompletion failed: %v", err)
+	}
+	for _, err := range stream {
+		if err != nil {
+			t.Fatalf("stream error: %v", err)
+		}
+	}
+	if got := gotHeader.Get("X-Org-Id"); got != "org-123" {
+		t.Errorf("X-Org-Id = %q, want org-123", got)
+	}
+	if got := gotHeader.Get("Cf-Access-Client-Id"); got != "client-id" {
+		t.Errorf("CF-Access-Client-Id = %q, want client-id", got)
+	}
+	if got := gotHeader.Get("Authorization"); got != "Bearer sk-or-test" {
+		t.Errorf("Authorization = %q, the API key must not be overridden", got)
+	}
+	if got := gotHeader.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := gotHeader.Get("User-Agent"); got == "custom-agent" {
+		t.Error("User-Agent must not be set by static headers")
+	}
+}
+
+func TestCreateOpenAIModelResponse(t *testing.T) {
+	var (
+		gotPath string
+		gotAuth string
+		gotBody []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+		if strings.Contains(string(gotBody), "overloaded") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error":{"message":"Rate limit reached.","type":"requests","code":"rate_limit_exceeded"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"model\":\"gpt-5\",\"status\":\"in_progress\",\"output\":[]}}\n\n"))
+		w.Write([]byte("event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"item_id\":\"msg_1\",\"delta\":\"Hello!\"}\n\n"))
+		w.Write([]byte("event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"model\":\"gpt-5\",\"status\":\"completed\",\"output\":[],\"usage\":{\"input_tokens\":4,\"output_tokens\":2}}}\n\n"))
+	}))
+	defer server.Close()
+	ctx := profile.WithProfile(context.Background(), &profile.Profile{
+		Name:     "openai",
+		Provider: "openai",
+		OpenAI:   &profile.OpenAIConfig{BaseURL: server.URL, APIKey: "sk-test"},
+	})
+	newRequest := func(text string) *openai.CreateModelResponseRequest {
+		return &openai.CreateModelResponseRequest{
+			Model: "gpt-5",
+			Input: []*openai.ResponseInputItem{{
+				Type:    openai.ResponseInputItemTypeMessage,
+				Role:    "user",
+				Content: []*openai.ResponseInputContent{{Type: openai.ResponseInputContentTypeInputText, Text: text}},
+			}},
+		}
+	}
+	stream, _, err := NewProvider().CreateOpenAIModelResponse(ctx, newRequest("hi"))
+	if err != nil {
+		t.Fatalf("CreateOpenAIModelResponse failed: %v", err)
+	}
+	var deltas []string
+	for event, err := range stream {
+		if err != nil {
+			t.Fatalf("stream error: %v", err)
+		}
+		if delta, ok := event.(*openai.ResponseOutputTextDeltaEvent); ok {
+			deltas = append(deltas, delta.Delta)
+		}
+	}
+	if len(deltas) != 1 || deltas[0] != "Hello!" {
+		t.Errorf("unexpected text deltas: %q", deltas)
+	}
+	if gotPath != "/v1/responses" {
+		t.Errorf("path = %s, want /v1/responses", gotPath)
+	}
+	if gotAuth != "Bearer sk-test" {
+		t.Errorf("authorization = %q", gotAuth)
+	}
+	if !strings.Contains(string(gotBody), `"stream":true`) || !strings.Contains(string(gotBody), `"store":false`) {
+		t.Errorf("unexpected request body: %s", gotBody)
+	}
+
+	_, _, err = NewProvider().CreateOpenAIModelResponse(ctx, newRequest("overloaded"))
+	providerError, ok := ParseError(err)
+	if !ok {
+		t.Fatalf("expected provider error, got %v", err)
+	}
+	if providerError.StatusCode() != http.StatusTooManyRequests || providerError.Message() != "Rate limit reached." || providerError.Source() != "openai" {
+		t.Errorf("unexpected error: %d %s %s", providerError.StatusCode(), providerError.Message(), providerError.Source())
+	}
+}
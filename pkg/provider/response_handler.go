@@ -15,6 +15,9 @@ import (
 	"time"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/bedrock"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/gemini"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/profile"
 	"github.com/x5iu/claude-code-adapter/pkg/utils"
@@ -30,6 +33,9 @@ var providerErrorParser = map[string]func(*http.Response) error{
 	ProviderMethodGenerateAnthropicMessage:       parseError[*anthropic.Error],
 	ProviderMethodCountAnthropicTokens:           parseError[*anthropic.Error],
 	ProviderMethodCreateOpenRouterChatCompletion: parseError[*openrouter.Error],
+	ProviderMethodGenerateBedrockMessage:         parseError[*bedrock.Error],
+	ProviderMethodGenerateGeminiMessage:          parseError[*gemini.Error],
+	ProviderMethodCreateOpenAIModelResponse:      parseError[*openai.Error],
 }
 
 func (r *ResponseHandler) ScanValues(values ...any) error {
@@ -40,6 +46,11 @@ func (r *ResponseHandler) ScanValues(values ...any) error {
 		}
 	}
 	if r.Response.StatusCode/100 != 2 {
+		if r.CurrentMethod == ProviderMethodCreateOpenRouterChatCompletion {
+			if prof, ok := profile.FromContext(ctx); ok {
+				markOpenRouterAPIKeyErrored(prof, r.Response)
+			}
+		}
 		return providerErrorParser[r.CurrentMethod](r.Response)
 	}
 	responseHeader := r.Response.Header
@@ -60,6 +71,24 @@ func (r *ResponseHandler) ScanValues(values ...any) error {
 		}
 		stream := values[0].(*openrouter.ChatCompletionStream)
 		*stream = makeOpenRouterStream(profile.MustFromContext(ctx), r.Response.Body)
+	case ProviderMethodGenerateBedrockMessage:
+		if !utils.IsContentType(responseHeader, bedrock.ContentTypeEventStream) {
+			return fmt.Errorf("unexpected Content-Type: %s", responseHeader.Get("Content-Type"))
+		}
+		stream := values[0].(*anthropic.MessageStream)
+		*stream = makeBedrockStream(r.Response.Body)
+	case ProviderMethodGenerateGeminiMessage:
+		if !utils.IsContentType(responseHeader, "text/event-stream") {
+			return fmt.Errorf("unexpected Content-Type: %s", responseHeader.Get("Content-Type"))
+		}
+		stream := values[0].(*anthropic.MessageStream)
+		*stream = makeGeminiStream(profile.MustFromContext(ctx), r.Response.Body)
+	case ProviderMethodCreateOpenAIModelResponse:
+		if !utils.IsContentType(responseHeader, "text/event-stream") {
+			return fmt.Errorf("unexpected Content-Type: %s", responseHeader.Get("Content-Type"))
+		}
+		stream := values[0].(*openai.ResponseStream)
+		*stream = makeOpenAIStream(profile.MustFromContext(ctx), r.Response.Body)
 	default:
 		defer r.Response.Body.Close()
 		switch {
@@ -244,6 +273,53 @@ func makeOpenRouterStream(prof *profile.Profile, r io.ReadCloser) openrouter.Cha
 	}
 }
 
+// makeBedrockStream decodes an invoke-with-response-stream body. Each "chunk" event carries
+// one Anthropic streaming event, so no conversion beyond unwrapping is needed.
+func makeBedrockStream(r io.ReadCloser) anthropic.MessageStream {
+	return func(yield func(anthropic.Event, error) bool) {
+		defer r.Close()
+		for message, err := range bedrock.DecodeEventStream(r) {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			switch message.Header(bedrock.HeaderMessageType) {
+			case bedrock.MessageTypeException:
+				yield(nil, bedrock.NewExceptionError(message.Header(bedrock.HeaderExceptionType), message.Payload))
+				return
+			case bedrock.MessageTypeError:
+				yield(nil, bedrock.NewExceptionError(message.Header(bedrock.HeaderErrorCode), []byte(message.Header(bedrock.HeaderErrorMessage))))
+				return
+			}
+			if message.Header(bedrock.HeaderEventType) != "chunk" {
+				continue
+			}
+			var chunk bedrock.Chunk
+			if err = json.Unmarshal(message.Payload, &chunk); err != nil {
+				yield(nil, err)
+				return
+			}
+			var eventType struct {
+				Type anthropic.EventType `json:"type"`
+			}
+			if err = json.Unmarshal(chunk.Bytes, &eventType); err != nil {
+				yield(nil, err)
+				return
+			}
+			if unmarshalEvent, ok := anthropicEventBuilder[eventType.Type]; ok {
+				event, err := unmarshalEvent(chunk.Bytes)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				if !yield(event, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
 type Error interface {
 	error
 
@@ -276,3 +352,52 @@ func parseError[E Error](r *http.Response) error {
 		return errors.New(string(body))
 	}
 }
+
+// makeGeminiStream decodes a streamGenerateContent?alt=sse body and converts its chunks into
+// Anthropic streaming events.
+func makeGeminiStream(prof *profile.Profile, r io.ReadCloser) anthropic.MessageStream {
+	dataIterator := makeDataIterator(prof, r)
+	return gemini.NewAnthropicStream(func(yield func(*gemini.GenerateContentResponse, error) bool) {
+		for data, err := range dataIterator {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			var geminiError *gemini.Error
+			if err := json.Unmarshal(data, &geminiError); err == nil && geminiError.Inner.Code != 0 {
+				yield(nil, geminiError)
+				return
+			}
+			var chunk *gemini.GenerateContentResponse
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(chunk, nil) {
+				return
+			}
+		}
+	})
+}
+
+// makeOpenAIStream decodes the server-sent events of a streaming Responses API request. Each
+// event carries its type in its data, so the event lines are not needed.
+func makeOpenAIStream(prof *profile.Profile, r io.ReadCloser) openai.ResponseStream {
+	dataIterator := makeDataIterator(prof, r)
+	return func(yield func(openai.Event, error) bool) {
+		for data, err := range dataIterator {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			event, err := openai.UnmarshalEvent(data)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(event, nil) {
+				return
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"os"
 	"sync"
 
 	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
@@ -13,7 +14,24 @@ import (
 
 var ErrClosed = errors.New("jsonl recorder closed")
 
-func NewRecorder(ctx context.Context, out io.WriteCloser) snapshot.Recorder {
+type RecorderOption func(*Recorder)
+
+// WithRedactor scrubs every snapshot with redactor before it is written.
+func WithRedactor(redactor *snapshot.Redactor) RecorderOption {
+	return func(r *Recorder) {
+		r.redactor = redactor
+	}
+}
+
+// WithRotation rotates the snapshot file once appending a snapshot would grow it past
+// rotation.MaxSize bytes. out must be the file opened at rotation.Path in append mode.
+func WithRotation(rotation Rotation) RecorderOption {
+	return func(r *Recorder) {
+		r.rotation = &rotation
+	}
+}
+
+func NewRecorder(ctx context.Context, out io.WriteCloser, options ...RecorderOption) snapshot.Recorder {
 	record := &Recorder{
 		cx:         ctx,
 		ch:         make(chan *item, 64),
@@ -22,6 +40,14 @@ func NewRecorder(ctx context.Context, out io.WriteCloser) snapshot.Recorder {
 		closed:     make(chan struct{}),
 		flushEvery: 32,
 	}
+	for _, applyOption := range options {
+		applyOption(record)
+	}
+	if record.rotation != nil {
+		if info, err := os.Stat(record.rotation.Path); err == nil {
+			record.size = info.Size()
+		}
+	}
 	record.start()
 	return record
 }
@@ -36,11 +62,20 @@ type Recorder struct {
 	once       sync.Once
 	pending    int
 	flushEvery int
+	redactor   *snapshot.Redactor
+	rotation   *Rotation
+	size       int64
 }
 
 func (r *Recorder) start() {
 	r.wg.Add(1)
 	appendToFile := func(it *item) {
+		if r.shouldRotate(int64(len(it.snapshot)) + 1) {
+			if err := r.rotate(); err != nil {
+				it.report(r.cx, err)
+				return
+			}
+		}
 		if _, err := r.bw.Write(it.snapshot); err != nil {
 			it.report(r.cx, err)
 			return
@@ -49,6 +84,7 @@ func (r *Recorder) start() {
 			it.report(r.cx, err)
 			return
 		}
+		r.size += int64(len(it.snapshot)) + 1
 		r.pending++
 		if r.flushEvery > 0 && (r.pending >= r.flushEvery || len(r.ch) == 0) {
 			if err := r.bw.Flush(); err != nil {
@@ -95,6 +131,7 @@ func (r *Recorder) Record(snap *snapshot.Snapshot) error {
 	if err != nil {
 		return err
 	}
+	bytes = r.redactor.Redact(bytes)
 	it := &item{snapshot: bytes, callback: make(chan error, 1)}
 	select {
 	case <-r.cx.Done():
@@ -83,6 +83,33 @@ func TestNewRecorder_RecordWritesToFile(t *testing.T) {
 	}
 }
 
+func TestNewRecorder_WithRedactor(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "snap-*.jsonl")
+	if err != nil {
+		t.Fatalf("temp file error: %v", err)
+	}
+	defer f.Close()
+	redactor, err := snapshot.NewRedactor(&snapshot.RedactConfig{Paths: []string{"request_id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(context.Background(), f, WithRedactor(redactor))
+	s := &snapshot.Snapshot{Version: "x", RequestID: "secret", RequestHeader: snapshot.Header{"X-Api-Key": {"sk-ant"}}}
+	if err := rec.Record(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("read file error: %v", err)
+	}
+	if bytes.Contains(b, []byte("secret")) || bytes.Contains(b, []byte("sk-ant")) {
+		t.Fatalf("snapshot was not redacted: %s", b)
+	}
+	if !bytes.Contains(b, []byte(`"request_id":"`+snapshot.RedactedPlaceholder+`"`)) {
+		t.Fatalf("redacted value missing placeholder: %s", b)
+	}
+}
+
 func TestRecord_MultipleWritesNewlineSeparated(t *testing.T) {
 	f, err := os.CreateTemp(t.TempDir(), "snap-*.jsonl")
 	if err != nil {
@@ -0,0 +1,131 @@
+package jsonl
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// Rotation configures size-based rotation of a snapshot file.
+//
+// Rotated files are named "<Path>.1" (the most recent) through "<Path>.<MaxFiles>", with a
+// ".gz" suffix when Compress is set; older files are pruned.
+type Rotation struct {
+	// Path is the file the recorder appends to.
+	Path string
+	// MaxSize is the size in bytes past which the file is rotated; zero disables rotation.
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept; zero discards the file on rotation.
+	MaxFiles int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+const compressedExt = ".gz"
+
+func (r *Recorder) shouldRotate(n int64) bool {
+	if r.rotation == nil || r.rotation.MaxSize <= 0 {
+		return false
+	}
+	// A single snapshot larger than MaxSize is still written, just to a file of its own.
+	return r.size > 0 && r.size+n > r.rotation.MaxSize
+}
+
+// rotate moves the current file aside and reopens Path. It runs on the writer goroutine,
+// so no snapshot is written while the files are being shifted.
+func (r *Recorder) rotate() error {
+	if err := r.bw.Flush(); err != nil {
+		return err
+	}
+	r.pending = 0
+	if err := r.out.Close(); err != nil {
+		return err
+	}
+	shiftErr := r.rotation.shift()
+	file, err := os.OpenFile(r.rotation.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Join(shiftErr, err)
+	}
+	r.out = file
+	r.bw.Reset(file)
+	r.size = 0
+	if info, err := file.Stat(); err == nil {
+		r.size = info.Size()
+	}
+	return shiftErr
+}
+
+func (rot *Rotation) rotatedName(i int) string {
+	return rot.Path + "." + strconv.Itoa(i)
+}
+
+// shift renames Path to "<Path>.1", moving every older rotated file one slot down and
+// removing the ones past MaxFiles.
+func (rot *Rotation) shift() error {
+	maxFiles := max(rot.MaxFiles, 0)
+	for i := max(maxFiles, 1); ; i++ {
+		removed := false
+		for _, name := range []string{rot.rotatedName(i), rot.rotatedName(i) + compressedExt} {
+			if err := os.Remove(name); err == nil {
+				removed = true
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if !removed {
+			break
+		}
+	}
+	if maxFiles == 0 {
+		return os.Remove(rot.Path)
+	}
+	for i := maxFiles - 1; i >= 1; i-- {
+		for _, ext := range []string{"", compressedExt} {
+			if err := os.Rename(rot.rotatedName(i)+ext, rot.rotatedName(i+1)+ext); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(rot.Path, rot.rotatedName(1)); err != nil {
+		return err
+	}
+	if rot.Compress {
+		return compressFile(rot.rotatedName(1))
+	}
+	return nil
+}
+
+// compressFile replaces name with its gzipped copy "<name>.gz".
+func compressFile(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+compressedExt, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst.Name())
+		}
+	}()
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,156 @@
+package jsonl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/x5iu/claude-code-adapter/pkg/snapshot"
+)
+
+func newRotatingRecorder(t *testing.T, rotation Rotation) snapshot.Recorder {
+	t.Helper()
+	f, err := os.OpenFile(rotation.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRecorder(context.Background(), f, WithRotation(rotation))
+}
+
+func countLines(t *testing.T, name string) int {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var scanner *bufio.Scanner
+	if strings.HasSuffix(name, compressedExt) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner = bufio.NewScanner(zr)
+	} else {
+		scanner = bufio.NewScanner(f)
+	}
+	lines := 0
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "{") {
+			t.Fatalf("%s contains a partial snapshot: %q", name, scanner.Text())
+		}
+		lines++
+	}
+	return lines
+}
+
+func TestRecorder_RotatesPastMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	// An empty v1 snapshot marshals to 138 bytes with its newline, so every file holds two.
+	rec := newRotatingRecorder(t, Rotation{Path: path, MaxSize: 300, MaxFiles: 2})
+	for i := 0; i < 7; i++ {
+		if err := rec.Record(&snapshot.Snapshot{Version: "v1"}); err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		if got := countLines(t, name); got != want {
+			t.Errorf("%s has %d snapshots, want %d", name, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 to be pruned, stat error: %v", path, err)
+	}
+}
+
+func TestRecorder_RotationCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	rec := newRotatingRecorder(t, Rotation{Path: path, MaxSize: 20, MaxFiles: 3, Compress: true})
+	for i := 0; i < 3; i++ {
+		if err := rec.Record(&snapshot.Snapshot{Version: "v1"}); err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{path + ".1.gz", path + ".2.gz"} {
+		if got := countLines(t, name); got != 1 {
+			t.Errorf("%s has %d snapshots, want 1", name, got)
+		}
+	}
+	for _, name := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("expected uncompressed %s to be removed, stat error: %v", name, err)
+		}
+	}
+}
+
+func TestRecorder_RotationZeroMaxFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	if err := os.WriteFile(path+".1", []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := newRotatingRecorder(t, Rotation{Path: path, MaxSize: 20})
+	for i := 0; i < 3; i++ {
+		if err := rec.Record(&snapshot.Snapshot{Version: "v1"}); err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := countLines(t, path); got != 1 {
+		t.Errorf("%s has %d snapshots, want 1", path, got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.1 to be removed, stat error: %v", path, err)
+	}
+}
+
+func TestRecorder_RotationConcurrentRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl")
+	rec := newRotatingRecorder(t, Rotation{Path: path, MaxSize: 1000, MaxFiles: 100})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := rec.Record(&snapshot.Snapshot{Version: "v1"}); err != nil {
+					t.Errorf("Record: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) < 2 {
+		t.Fatalf("expected the file to rotate, got %v", names)
+	}
+	total := 0
+	for _, name := range names {
+		if info, err := os.Stat(name); err != nil || info.Size() > 1000 {
+			t.Errorf("%s exceeds the max size: %v", name, err)
+		}
+		total += countLines(t, name)
+	}
+	if total != 200 {
+		t.Errorf("recorded %d snapshots across %d files, want 200", total, len(names))
+	}
+}
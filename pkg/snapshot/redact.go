@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// RedactedPlaceholder replaces every redacted value.
+const RedactedPlaceholder = "[REDACTED]"
+
+// DefaultRedactedHeaders carry credentials and are always redacted from recorded headers.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// RedactConfig selects the parts of a snapshot that are scrubbed before it is written.
+type RedactConfig struct {
+	// Paths are GJSON paths into the snapshot JSON, e.g. "anthropic_request.system"; a "#"
+	// component matches every element of an array, e.g. "anthropic_request.messages.#.content".
+	Paths []string `yaml:"paths" json:"paths" mapstructure:"paths"`
+	// Patterns are regular expressions; matches inside any string value are replaced.
+	Patterns []string `yaml:"patterns" json:"patterns" mapstructure:"patterns"`
+	// Headers are request and response header names redacted in addition to DefaultRedactedHeaders.
+	Headers []string `yaml:"headers" json:"headers" mapstructure:"headers"`
+}
+
+// Redactor scrubs serialized snapshots according to a RedactConfig.
+type Redactor struct {
+	paths    []string
+	patterns []*regexp.Regexp
+	headers  []string
+}
+
+// NewRedactor compiles cfg; a nil cfg only redacts DefaultRedactedHeaders.
+func NewRedactor(cfg *RedactConfig) (*Redactor, error) {
+	r := &Redactor{headers: make([]string, 0, len(DefaultRedactedHeaders))}
+	for _, name := range DefaultRedactedHeaders {
+		r.headers = append(r.headers, http.CanonicalHeaderKey(name))
+	}
+	if cfg == nil {
+		return r, nil
+	}
+	for _, name := range cfg.Headers {
+		r.headers = append(r.headers, http.CanonicalHeaderKey(name))
+	}
+	r.paths = cfg.Paths
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact returns data, a JSON-encoded Snapshot, with the configured values replaced by
+// RedactedPlaceholder. Values that cannot be redacted are left untouched rather than
+// failing the recording.
+func (r *Redactor) Redact(data []byte) []byte {
+	if r == nil {
+		return data
+	}
+	for _, field := range []string{"request_header", "response_header"} {
+		for _, name := range r.headers {
+			data = r.set(data, field+"."+escapePathComponent(name))
+		}
+	}
+	for _, path := range r.paths {
+		for _, expanded := range expandPath(data, path) {
+			data = r.set(data, expanded)
+		}
+	}
+	if len(r.patterns) > 0 {
+		data = r.redactPatterns(data)
+	}
+	return data
+}
+
+func (r *Redactor) set(data []byte, path string) []byte {
+	if !gjson.GetBytes(data, path).Exists() {
+		return data
+	}
+	if redacted, err := sjson.SetBytes(data, path, RedactedPlaceholder); err == nil {
+		return redacted
+	}
+	return data
+}
+
+// redactPatterns decodes data, replaces pattern matches in every string and re-encodes it.
+func (r *Redactor) redactPatterns(data []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return data
+	}
+	redacted, err := json.Marshal(r.redactValue(value))
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+func (r *Redactor) redactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		for _, re := range r.patterns {
+			v = re.ReplaceAllLiteralString(v, RedactedPlaceholder)
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = r.redactValue(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = r.redactValue(v[key])
+		}
+	}
+	return value
+}
+
+// expandPath replaces each "#" component of path with the indexes of the array it refers to.
+func expandPath(data []byte, path string) []string {
+	prefix, rest, found := strings.Cut(path, ".#")
+	if !found || (rest != "" && rest[0] != '.') {
+		return []string{path}
+	}
+	var paths []string
+	n := int(gjson.GetBytes(data, prefix+".#").Int())
+	for i := range n {
+		paths = append(paths, expandPath(data, fmt.Sprintf("%s.%d%s", prefix, i, rest))...)
+	}
+	return paths
+}
+
+func escapePathComponent(component string) string {
+	var b strings.Builder
+	for _, c := range component {
+		switch c {
+		case '.', '*', '?', '|', '#', '@', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
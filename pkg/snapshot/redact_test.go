@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	snap := &Snapshot{
+		Version: "v1",
+		AnthropicRequest: &anthropic.GenerateMessageRequest{
+			Model:  "claude-sonnet-4",
+			System: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "secret system prompt"}},
+			Messages: []*anthropic.Message{
+				{Role: anthropic.MessageRoleUser, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "my key is sk-abcdefghijklmnopqrstuvwxyz"}}},
+				{Role: anthropic.MessageRoleAssistant, Content: anthropic.MessageContents{{Type: anthropic.MessageContentTypeText, Text: "noted"}}},
+			},
+		},
+		RequestHeader: Header{
+			"X-Api-Key":         {"sk-ant-123"},
+			"Authorization":     {"Bearer token"},
+			"X-Custom-Secret":   {"custom"},
+			"Anthropic-Version": {"2023-06-01"},
+		},
+		ResponseHeader: Header{"Set-Cookie": {"a=b", "c=d"}},
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := NewRedactor(&RedactConfig{
+		Paths:    []string{"anthropic_request.system", "anthropic_request.messages.#.content.#.text", "missing.path"},
+		Patterns: []string{`sk-[A-Za-z0-9]{20,}`},
+		Headers:  []string{"x-custom-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted := redactor.Redact(data)
+	for path, want := range map[string]string{
+		"anthropic_request.system":                    RedactedPlaceholder,
+		"anthropic_request.messages.0.content.0.text": RedactedPlaceholder,
+		"anthropic_request.messages.1.content.0.text": RedactedPlaceholder,
+		"anthropic_request.model":                     "claude-sonnet-4",
+		"request_header.X-Api-Key":                    RedactedPlaceholder,
+		"request_header.Authorization":                RedactedPlaceholder,
+		"request_header.X-Custom-Secret":              RedactedPlaceholder,
+		"request_header.Anthropic-Version":            "2023-06-01",
+		"response_header.Set-Cookie":                  RedactedPlaceholder,
+	} {
+		if got := gjson.GetBytes(redacted, path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	var decoded Snapshot
+	if err = json.Unmarshal(redacted, &decoded); err != nil {
+		t.Fatalf("redacted snapshot is not valid: %v", err)
+	}
+	if got := http.Header(decoded.RequestHeader).Get("X-Api-Key"); got != RedactedPlaceholder {
+		t.Errorf("decoded X-Api-Key = %q", got)
+	}
+}
+
+func TestRedactor_Patterns(t *testing.T) {
+	redactor, err := NewRedactor(&RedactConfig{Patterns: []string{`sk-[A-Za-z0-9]{20,}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"anthropic_request":{"messages":[{"content":[{"text":"key sk-abcdefghijklmnopqrstuvwxyz here"}]}],"max_tokens":12345678901234567}}`)
+	redacted := redactor.Redact(data)
+	if got := gjson.GetBytes(redacted, "anthropic_request.messages.0.content.0.text").String(); got != "key [REDACTED] here" {
+		t.Errorf("text = %q", got)
+	}
+	if got := gjson.GetBytes(redacted, "anthropic_request.max_tokens").Raw; got != "12345678901234567" {
+		t.Errorf("numbers should be kept as is, got %s", got)
+	}
+	if _, err = NewRedactor(&RedactConfig{Patterns: []string{"("}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestRedactor_Default(t *testing.T) {
+	redactor, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"request_header":{"X-Api-Key":"sk-ant","Content-Type":"application/json"}}`)
+	redacted := redactor.Redact(data)
+	if got := gjson.GetBytes(redacted, "request_header.X-Api-Key").String(); got != RedactedPlaceholder {
+		t.Errorf("X-Api-Key = %q, credential headers should always be redacted", got)
+	}
+	if got := gjson.GetBytes(redacted, "request_header.Content-Type").String(); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	var nilRedactor *Redactor
+	if got := nilRedactor.Redact(data); string(got) != string(data) {
+		t.Error("a nil Redactor should not change the snapshot")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/anthropic"
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openai"
 	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 )
 
@@ -29,15 +30,18 @@ type Snapshot struct {
 	FinishTime         time.Time                               `json:"finish_time"`
 	Version            string                                  `json:"version"`
 	RequestID          string                                  `json:"request_id"`
+	UpstreamRequestID  string                                  `json:"upstream_request_id,omitempty"`
 	StatusCode         int                                     `json:"status_code"`
 	Provider           string                                  `json:"provider"`
 	Profile            string                                  `json:"profile,omitempty"`
+	ServiceTier        string                                  `json:"service_tier,omitempty"`
 	Config             *Config                                 `json:"config,omitempty"`
 	Error              *Error                                  `json:"error,omitempty"`
 	AnthropicRequest   *anthropic.GenerateMessageRequest       `json:"anthropic_request,omitempty"`
 	AnthropicResponse  *anthropic.Message                      `json:"anthropic_response,omitempty"`
 	OpenRouterRequest  *openrouter.CreateChatCompletionRequest `json:"openrouter_request,omitempty"`
 	OpenRouterResponse *openrouter.ChatCompletion              `json:"openrouter_response,omitempty"`
+	OpenAIRequest      *openai.CreateModelResponseRequest      `json:"openai_request,omitempty"`
 	RequestHeader      Header                                  `json:"request_header,omitempty"`
 	ResponseHeader     Header                                  `json:"response_header,omitempty"`
 }
@@ -53,6 +57,9 @@ type Config struct {
 	Options    *OptionsConfig    `yaml:"options" json:"options" mapstructure:"options"`
 	Anthropic  *AnthropicConfig  `yaml:"anthropic" json:"anthropic" mapstructure:"anthropic"`
 	OpenRouter *OpenRouterConfig `yaml:"openrouter" json:"openrouter" mapstructure:"openrouter"`
+	Bedrock    *BedrockConfig    `yaml:"bedrock" json:"bedrock" mapstructure:"bedrock"`
+	Gemini     *GeminiConfig     `yaml:"gemini" json:"gemini" mapstructure:"gemini"`
+	OpenAI     *OpenAIConfig     `yaml:"openai" json:"openai" mapstructure:"openai"`
 }
 
 type OptionsConfig struct {
@@ -62,14 +69,34 @@ type OptionsConfig struct {
 	Models                     map[string]string `yaml:"models" json:"models" mapstructure:"models"`
 	ContextWindowResizeFactor  float64           `yaml:"context_window_resize_factor" json:"context_window_resize_factor" mapstructure:"context_window_resize_factor"`
 	DisableCountTokensRequest  bool              `yaml:"disable_count_tokens_request" json:"disable_count_tokens_request" mapstructure:"disable_count_tokens_request"`
+	LocalCountTokens           bool              `yaml:"local_count_tokens" json:"local_count_tokens" mapstructure:"local_count_tokens"`
 	MinMaxTokens               int               `yaml:"min_max_tokens" json:"min_max_tokens" mapstructure:"min_max_tokens"`
+	DefaultMaxTokens           int               `yaml:"default_max_tokens" json:"default_max_tokens" mapstructure:"default_max_tokens"`
+	MaxTokensCap               int               `yaml:"max_tokens_cap" json:"max_tokens_cap" mapstructure:"max_tokens_cap"`
 	DisallowedTools            []string          `yaml:"disallowed_tools" json:"disallowed_tools" mapstructure:"disallowed_tools"`
+	MaxTools                   int               `yaml:"max_tools" json:"max_tools" mapstructure:"max_tools"`
+	SanitizeToolNames          bool              `yaml:"sanitize_tool_names" json:"sanitize_tool_names" mapstructure:"sanitize_tool_names"`
+	EnforceSerialToolCalls     bool              `yaml:"enforce_serial_tool_calls" json:"enforce_serial_tool_calls" mapstructure:"enforce_serial_tool_calls"`
+	InterimUsageInterval       time.Duration     `yaml:"interim_usage_interval" json:"interim_usage_interval" mapstructure:"interim_usage_interval"`
+	AutoCacheBreakpoints       bool              `yaml:"auto_cache_breakpoints" json:"auto_cache_breakpoints" mapstructure:"auto_cache_breakpoints"`
+	DisableThinking            bool              `yaml:"disable_thinking" json:"disable_thinking" mapstructure:"disable_thinking"`
+	MaxStopSequences           int               `yaml:"max_stop_sequences" json:"max_stop_sequences" mapstructure:"max_stop_sequences"`
+	LogitBias                  map[string]int    `yaml:"logit_bias" json:"logit_bias" mapstructure:"logit_bias"`
+	MaxContentPartBytes        int               `yaml:"max_content_part_bytes" json:"max_content_part_bytes" mapstructure:"max_content_part_bytes"`
+	StrictSchemaSanitize       bool              `yaml:"strict_schema_sanitize" json:"strict_schema_sanitize" mapstructure:"strict_schema_sanitize"`
+	SystemPrefix               string            `yaml:"system_prefix" json:"system_prefix" mapstructure:"system_prefix"`
+	SystemSuffix               string            `yaml:"system_suffix" json:"system_suffix" mapstructure:"system_suffix"`
+	RedactedThinkingMode       string            `yaml:"redacted_thinking_mode" json:"redacted_thinking_mode" mapstructure:"redacted_thinking_mode"`
+	ImageDetail                string            `yaml:"image_detail" json:"image_detail" mapstructure:"image_detail"`
 }
 
 type ReasoningConfig struct {
-	Format    string `yaml:"format" json:"format" mapstructure:"format"`
-	Effort    string `yaml:"effort" json:"effort" mapstructure:"effort"`
-	Delimiter string `yaml:"delimiter" json:"delimiter" mapstructure:"delimiter"`
+	Format              string   `yaml:"format" json:"format" mapstructure:"format"`
+	Effort              string   `yaml:"effort" json:"effort" mapstructure:"effort"`
+	Delimiter           string   `yaml:"delimiter" json:"delimiter" mapstructure:"delimiter"`
+	Exclude             bool     `yaml:"exclude" json:"exclude" mapstructure:"exclude"`
+	PreferSummary       bool     `yaml:"prefer_summary" json:"prefer_summary" mapstructure:"prefer_summary"`
+	EffortSuffixFormats []string `yaml:"effort_suffix_formats" json:"effort_suffix_formats" mapstructure:"effort_suffix_formats"`
 }
 
 type AnthropicConfig struct {
@@ -82,9 +109,41 @@ type AnthropicConfig struct {
 }
 
 type OpenRouterConfig struct {
-	BaseURL              string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
-	ModelReasoningFormat map[string]string `yaml:"model_reasoning_format" json:"model_reasoning_format" mapstructure:"model_reasoning_format"`
-	AllowedProviders     []string          `yaml:"allowed_providers" json:"allowed_providers" mapstructure:"allowed_providers"`
+	BaseURL               string                        `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	ModelReasoningFormat  map[string]string             `yaml:"model_reasoning_format" json:"model_reasoning_format" mapstructure:"model_reasoning_format"`
+	PreferredProviders    []openrouter.Provider         `yaml:"preferred_providers" json:"preferred_providers" mapstructure:"preferred_providers"`
+	Transforms            []string                      `yaml:"transforms" json:"transforms" mapstructure:"transforms"`
+	ClampCacheTTL         bool                          `yaml:"clamp_cache_ttl" json:"clamp_cache_ttl" mapstructure:"clamp_cache_ttl"`
+	MergeConsecutiveRoles bool                          `yaml:"merge_consecutive_roles" json:"merge_consecutive_roles" mapstructure:"merge_consecutive_roles"`
+	ForcePartsContent     bool                          `yaml:"force_parts_content" json:"force_parts_content" mapstructure:"force_parts_content"`
+	ProviderSort          openrouter.ProviderSortMethod `yaml:"provider_sort" json:"provider_sort" mapstructure:"provider_sort"`
+	AllowFallbacks        *bool                         `yaml:"allow_fallbacks" json:"allow_fallbacks" mapstructure:"allow_fallbacks"`
+	RequireParameters     bool                          `yaml:"require_parameters" json:"require_parameters" mapstructure:"require_parameters"`
+	ServiceTier           openrouter.ServiceTier        `yaml:"service_tier" json:"service_tier" mapstructure:"service_tier"`
+	FallbackModels        []string                      `yaml:"fallback_models" json:"fallback_models" mapstructure:"fallback_models"`
+	WebSearch             bool                          `yaml:"web_search" json:"web_search" mapstructure:"web_search"`
+}
+
+// BedrockConfig records the Bedrock routing settings; credentials are never recorded.
+type BedrockConfig struct {
+	Region  string            `yaml:"region" json:"region" mapstructure:"region"`
+	BaseURL string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	Profile string            `yaml:"profile" json:"profile" mapstructure:"profile"`
+	Models  map[string]string `yaml:"models" json:"models" mapstructure:"models"`
+}
+
+// GeminiConfig records the Gemini routing settings; the API key is never recorded.
+type GeminiConfig struct {
+	BaseURL string            `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	Models  map[string]string `yaml:"models" json:"models" mapstructure:"models"`
+}
+
+// OpenAIConfig records the OpenAI routing settings; the API key is never recorded.
+type OpenAIConfig struct {
+	BaseURL        string                 `yaml:"base_url" json:"base_url" mapstructure:"base_url"`
+	AllowedTools   []string               `yaml:"allowed_tools" json:"allowed_tools" mapstructure:"allowed_tools"`
+	PromptCacheKey string                 `yaml:"prompt_cache_key" json:"prompt_cache_key" mapstructure:"prompt_cache_key"`
+	ServiceTier    openrouter.ServiceTier `yaml:"service_tier" json:"service_tier" mapstructure:"service_tier"`
 }
 
 type Header http.Header
@@ -103,3 +162,26 @@ func (h Header) MarshalJSON() ([]byte, error) {
 	}
 	return json.Marshal(x)
 }
+
+// UnmarshalJSON accepts both forms written by MarshalJSON: a single string or a list of strings.
+func (h *Header) UnmarshalJSON(data []byte) error {
+	var x map[string]json.RawMessage
+	if err := json.Unmarshal(data, &x); err != nil {
+		return err
+	}
+	header := make(Header, len(x))
+	for k, raw := range x {
+		var single string
+		if err := json.Unmarshal(raw, &single); err == nil {
+			header[k] = []string{single}
+			continue
+		}
+		var multi []string
+		if err := json.Unmarshal(raw, &multi); err != nil {
+			return err
+		}
+		header[k] = multi
+	}
+	*h = header
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 
+	"github.com/x5iu/claude-code-adapter/pkg/datatypes/openrouter"
 	"github.com/x5iu/claude-code-adapter/pkg/utils/delimiter"
 )
 
@@ -39,7 +40,7 @@ openrouter:
   model_reasoning_format:
     anthropic/claude-sonnet-4: "anthropic-claude-v1"
     openai/gpt-5: "openai-responses-v1"
-  allowed_providers:
+  preferred_providers:
     - "anthropic"
     - "google-vertex"
     - "amazon-bedrock"
@@ -60,7 +61,7 @@ openrouter:
 	if cfg.Anthropic == nil || cfg.Anthropic.BaseURL != "https://api.anthropic.com" || cfg.Anthropic.Version != "2023-06-01" || cfg.Anthropic.ForceThinking != false {
 		t.Fatalf("unexpected anthropic: %#v", cfg.Anthropic)
 	}
-	if cfg.OpenRouter == nil || cfg.OpenRouter.BaseURL != "https://openrouter.ai/api" || len(cfg.OpenRouter.AllowedProviders) != 3 {
+	if cfg.OpenRouter == nil || cfg.OpenRouter.BaseURL != "https://openrouter.ai/api" || len(cfg.OpenRouter.PreferredProviders) != 3 {
 		t.Fatalf("unexpected openrouter: %#v", cfg.OpenRouter)
 	}
 	b, err := json.Marshal(&cfg)
@@ -116,7 +117,7 @@ openrouter:
   model_reasoning_format:
     anthropic/claude-sonnet-4: "anthropic-claude-v1"
     openai/gpt-5: "openai-responses-v1"
-  allowed_providers:
+  preferred_providers:
     - "anthropic"
     - "google-vertex"
     - "amazon-bedrock"
@@ -155,9 +156,9 @@ openrouter:
 	if cfg.OpenRouter.ModelReasoningFormat["openai/gpt-5"] != "openai-responses-v1" {
 		t.Fatalf("unexpected model_reasoning_format for openai/gpt-5: %s", cfg.OpenRouter.ModelReasoningFormat["openai/gpt-5"])
 	}
-	expectedProviders := []string{"anthropic", "google-vertex", "amazon-bedrock"}
-	if !reflect.DeepEqual(cfg.OpenRouter.AllowedProviders, expectedProviders) {
-		t.Fatalf("unexpected allowed_providers: %#v", cfg.OpenRouter.AllowedProviders)
+	expectedProviders := []openrouter.Provider{"anthropic", "google-vertex", "amazon-bedrock"}
+	if !reflect.DeepEqual(cfg.OpenRouter.PreferredProviders, expectedProviders) {
+		t.Fatalf("unexpected preferred_providers: %#v", cfg.OpenRouter.PreferredProviders)
 	}
 }
 
@@ -249,6 +250,24 @@ func TestHeader_MarshalJSON_Empty(t *testing.T) {
 	}
 }
 
+func TestHeader_UnmarshalJSON_RoundTrip(t *testing.T) {
+	h := Header{"Single": {"one"}, "Multi": {"a", "b"}}
+	b, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("json marshal error: %v", err)
+	}
+	var got Header
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json unmarshal error: %v", err)
+	}
+	if !reflect.DeepEqual(got, h) {
+		t.Fatalf("header mismatch after round trip: %#v", got)
+	}
+	if err = json.Unmarshal([]byte(`{"Bad":1}`), &got); err == nil {
+		t.Fatalf("expected error for non-string header value")
+	}
+}
+
 func TestViper_Unmarshal_Config_ModelReasoningFormatDots(t *testing.T) {
 	yamlData := `
 openrouter:
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a fixed-size, concurrency-safe least-recently-used cache whose entries expire after a TTL.
+// A nil *LRU is a valid cache that stores nothing.
+type LRU[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	ll      *list.List
+	entries map[K]*list.Element
+	now     func() time.Time
+}
+
+type lruEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// NewLRU creates a cache holding at most size entries, each valid for ttl after it was added.
+// A non-positive ttl means entries never expire.
+func NewLRU[K comparable, V any](size int, ttl time.Duration) *LRU[K, V] {
+	return &LRU[K, V]{
+		size:    max(size, 1),
+		ttl:     ttl,
+		ll:      list.New(),
+		entries: make(map[K]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value cached for key, if it exists and has not expired.
+func (c *LRU[K, V]) Get(key K) (value V, ok bool) {
+	if c == nil {
+		return value, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return value, false
+	}
+	entry := elem.Value.(*lruEntry[K, V])
+	if c.ttl > 0 && !c.now().Before(entry.expiresAt) {
+		c.ll.Remove(elem)
+		delete(c.entries, key)
+		return value, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+// Add caches value for key, evicting the least recently used entry if the cache is full.
+func (c *LRU[K, V]) Add(key K, value V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiresAt := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*lruEntry[K, V])
+		entry.value, entry.expiresAt = value, expiresAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+// Len returns the number of cached entries, including expired ones not yet evicted.
+func (c *LRU[K, V]) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewLRU[string, int64](2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v, want 1, true", v, ok)
+	}
+
+	// "b" is the least recently used entry and is evicted.
+	cache.Add("c", 3)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if v, ok := cache.Get("c"); !ok || v != 3 {
+		t.Errorf("Get(c) = %d, %v, want 3, true", v, ok)
+	}
+
+	now = now.Add(30 * time.Second)
+	cache.Add("c", 4)
+	now = now.Add(45 * time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expected a to be expired")
+	}
+	if v, ok := cache.Get("c"); !ok || v != 4 {
+		t.Errorf("Get(c) = %d, %v, want refreshed value 4", v, ok)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %d, want 1 after expired entry was removed", cache.Len())
+	}
+}
+
+func TestLRU_NoTTL(t *testing.T) {
+	now := time.Now()
+	cache := NewLRU[int, string](0, 0)
+	cache.now = func() time.Time { return now }
+	cache.Add(1, "one")
+	now = now.Add(24 * time.Hour)
+	if v, ok := cache.Get(1); !ok || v != "one" {
+		t.Errorf("Get(1) = %q, %v, want entry without expiry", v, ok)
+	}
+	cache.Add(2, "two")
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %d, want non-positive size to hold one entry", cache.Len())
+	}
+}
+
+func TestLRU_Nil(t *testing.T) {
+	var cache *LRU[string, int64]
+	cache.Add("a", 1)
+	if _, ok := cache.Get("a"); ok || cache.Len() != 0 {
+		t.Error("nil cache should store nothing")
+	}
+}